@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	//nolint:gosec // we want to unconditionally expose pprof here for advanced troubleshooting scenarios
@@ -12,6 +13,7 @@ import (
 
 	"github.com/cloudnativelabs/kube-router/pkg/cmd"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/cloudnativelabs/kube-router/pkg/version"
 	"github.com/spf13/pflag"
 	"k8s.io/klog/v2"
@@ -46,6 +48,23 @@ func Main() error {
 	if err != nil {
 		return fmt.Errorf("failed to set flag: %s", err)
 	}
+	err = flag.Set("vmodule", config.VModule)
+	if err != nil {
+		return fmt.Errorf("failed to set flag: %s", err)
+	}
+	switch config.LogFormat {
+	case options.LogFormatText:
+	case options.LogFormatJSON:
+		verbosity, err := strconv.Atoi(config.VLevel)
+		if err != nil {
+			return fmt.Errorf("failed to parse log level: %s", err)
+		}
+		// klog checks the verbosity of its V logs (honoring --vmodule) before passing them on, the logger only
+		// checks the one of the V logs of the controller loggers
+		klog.SetLoggerWithOptions(utils.NewJSONLogger(os.Stderr, verbosity), klog.ContextualLogger(true))
+	default:
+		return fmt.Errorf("--log-format must be either %s or %s", options.LogFormatText, options.LogFormatJSON)
+	}
 
 	if config.HelpRequested {
 		pflag.Usage()
@@ -57,6 +76,10 @@ func Main() error {
 		return nil
 	}
 
+	if config.NetpolSimulate != "" {
+		return cmd.SimulateFlow(os.Stdout, config)
+	}
+
 	if os.Geteuid() != 0 {
 		return fmt.Errorf("kube-router needs to be run with privileges to execute iptables, ipset and configure ipvs")
 	}
@@ -71,6 +94,10 @@ func Main() error {
 		return fmt.Errorf("failed to parse kube-router config: %v", err)
 	}
 
+	if config.ConntrackReport {
+		return kubeRouter.ConntrackReport(os.Stdout)
+	}
+
 	if config.EnablePprof {
 		go func() {
 			server := http.Server{
@@ -0,0 +1,146 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// GatewayClassResource is the resource of the cluster scoped GatewayClass custom resource
+	GatewayClassResource = SchemeGroupVersion.WithResource("gatewayclasses")
+	// GatewayResource is the resource of the namespaced Gateway custom resource
+	GatewayResource = SchemeGroupVersion.WithResource("gateways")
+)
+
+// The protocols of the listeners of the Gateways
+const (
+	// TCPProtocolType listeners accept TCPRoutes
+	TCPProtocolType = "TCP"
+	// UDPProtocolType listeners accept UDPRoutes
+	UDPProtocolType = "UDP"
+)
+
+// IPAddressType is the type of the addresses of the Gateways that are IP addresses, the default type
+const IPAddressType = "IPAddress"
+
+// The namespaces the routes attached to a listener may be in
+const (
+	// NamespacesFromSame only allows the routes of the namespace of the Gateway, the default
+	NamespacesFromSame = "Same"
+	// NamespacesFromAll allows the routes of all the namespaces
+	NamespacesFromAll = "All"
+	// NamespacesFromSelector allows the routes of the namespaces matching the selector
+	NamespacesFromSelector = "Selector"
+)
+
+// The types of the conditions of the status of the GatewayClasses, the Gateways, their listeners and the routes
+const (
+	// ConditionAccepted tells whether the controller accepted the resource
+	ConditionAccepted = "Accepted"
+	// ConditionProgrammed tells whether the Gateway or the listener is served
+	ConditionProgrammed = "Programmed"
+	// ConditionResolvedRefs tells whether the references of the listener or the route could all be resolved
+	ConditionResolvedRefs = "ResolvedRefs"
+)
+
+// The reasons of the conditions, the ones that aren't defined by the Gateway API are specific to kube-router
+const (
+	ReasonAccepted              = "Accepted"
+	ReasonProgrammed            = "Programmed"
+	ReasonResolvedRefs          = "ResolvedRefs"
+	ReasonListenersNotValid     = "ListenersNotValid"
+	ReasonAddressNotAssigned    = "AddressNotAssigned"
+	ReasonInvalid               = "Invalid"
+	ReasonPending               = "Pending"
+	ReasonUnsupportedProtocol   = "UnsupportedProtocol"
+	ReasonNoMatchingParent      = "NoMatchingParent"
+	ReasonNotAllowedByListeners = "NotAllowedByListeners"
+	// ReasonConflicted is the reason of the routes that aren't accepted as their listeners serve an older route
+	ReasonConflicted      = "Conflicted"
+	ReasonInvalidKind     = "InvalidKind"
+	ReasonRefNotPermitted = "RefNotPermitted"
+	ReasonBackendNotFound = "BackendNotFound"
+)
+
+// GatewayClass is a class of Gateways, programmed by the controller named by the class
+type GatewayClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GatewayClassSpec   `json:"spec"`
+	Status GatewayClassStatus `json:"status,omitempty"`
+}
+
+// GatewayClassStatus holds the conditions the controller of the class sets, Accepted once it programs its Gateways
+type GatewayClassStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// GatewayClassSpec names the controller of the Gateways of the class
+type GatewayClassSpec struct {
+	ControllerName string `json:"controllerName"`
+}
+
+// Gateway is an instance of a GatewayClass, listening for traffic on its addresses
+type Gateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GatewaySpec   `json:"spec"`
+	Status GatewayStatus `json:"status,omitempty"`
+}
+
+// GatewaySpec describes the listeners of the Gateway and the addresses it asks for
+type GatewaySpec struct {
+	GatewayClassName string `json:"gatewayClassName"`
+	// Listeners are the ports the Gateway serves, the routes attach to them
+	Listeners []Listener `json:"listeners"`
+	// Addresses are the addresses asked for, the controller allocates them when there are none
+	Addresses []GatewayAddress `json:"addresses,omitempty"`
+}
+
+// Listener is a port of the Gateway and the routes it accepts
+type Listener struct {
+	Name          string         `json:"name"`
+	Port          int32          `json:"port"`
+	Protocol      string         `json:"protocol"`
+	AllowedRoutes *AllowedRoutes `json:"allowedRoutes,omitempty"`
+}
+
+// AllowedRoutes restricts the namespaces of the routes that may attach to a listener
+type AllowedRoutes struct {
+	Namespaces *RouteNamespaces `json:"namespaces,omitempty"`
+}
+
+// RouteNamespaces selects the namespaces of the routes, From is Same, All or Selector
+type RouteNamespaces struct {
+	From     *string               `json:"from,omitempty"`
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// GatewayAddress is an address of a Gateway, of type IPAddress when Type isn't set
+type GatewayAddress struct {
+	Type  *string `json:"type,omitempty"`
+	Value string  `json:"value"`
+}
+
+// GatewayStatus holds the addresses the controller bound the Gateway to, the conditions of the Gateway and the status
+// of its listeners
+type GatewayStatus struct {
+	Addresses  []GatewayAddress   `json:"addresses,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	Listeners  []ListenerStatus   `json:"listeners,omitempty"`
+}
+
+// ListenerStatus holds the conditions of a listener, the kinds of the routes it accepts and how many are attached
+type ListenerStatus struct {
+	Name           string             `json:"name"`
+	SupportedKinds []RouteGroupKind   `json:"supportedKinds"`
+	AttachedRoutes int32              `json:"attachedRoutes"`
+	Conditions     []metav1.Condition `json:"conditions"`
+}
+
+// RouteGroupKind is a kind of route, of the group of the Gateway API when Group isn't set
+type RouteGroupKind struct {
+	Group *string `json:"group,omitempty"`
+	Kind  string  `json:"kind"`
+}
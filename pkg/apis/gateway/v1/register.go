@@ -0,0 +1,14 @@
+// Package v1 contains the subset of the Gateway API of sig-network that kube-router programs, the GatewayClasses and
+// the Gateways. The CRDs are published by the gateway-api project, the resources are accessed through the dynamic
+// client and converted with the helpers of the kube-router.io API.
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the API group of the Gateway API resources
+const GroupName = "gateway.networking.k8s.io"
+
+// SchemeGroupVersion is the group version of the resources in this package
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1"}
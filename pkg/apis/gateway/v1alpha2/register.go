@@ -0,0 +1,14 @@
+// Package v1alpha2 contains the subset of the experimental L4 routes of the Gateway API that kube-router programs, the
+// TCPRoutes and the UDPRoutes. They attach to the listeners of the Gateways of the v1 package, and are accessed
+// through the dynamic client like them.
+package v1alpha2
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the API group of the Gateway API resources
+const GroupName = "gateway.networking.k8s.io"
+
+// SchemeGroupVersion is the group version of the resources in this package
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha2"}
@@ -0,0 +1,84 @@
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// TCPRouteResource is the resource of the namespaced TCPRoute custom resource
+	TCPRouteResource = SchemeGroupVersion.WithResource("tcproutes")
+	// UDPRouteResource is the resource of the namespaced UDPRoute custom resource
+	UDPRouteResource = SchemeGroupVersion.WithResource("udproutes")
+)
+
+// The kinds of the routes
+const (
+	TCPRouteKind = "TCPRoute"
+	UDPRouteKind = "UDPRoute"
+)
+
+// TCPRoute forwards the TCP connections accepted by the listeners it attaches to to its backends
+type TCPRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RouteSpec   `json:"spec"`
+	Status RouteStatus `json:"status,omitempty"`
+}
+
+// UDPRoute forwards the UDP datagrams received by the listeners it attaches to to its backends
+type UDPRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RouteSpec   `json:"spec"`
+	Status RouteStatus `json:"status,omitempty"`
+}
+
+// RouteSpec is the spec shared by the TCPRoutes and the UDPRoutes, which only differ by the protocol of the listeners
+// they attach to
+type RouteSpec struct {
+	// ParentRefs are the Gateways, or some of their listeners, the route attaches to
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+	Rules      []RouteRule       `json:"rules"`
+}
+
+// ParentReference refers to a Gateway, in the namespace of the route when Namespace isn't set, or to only its listener
+// of the name SectionName or of the port Port
+type ParentReference struct {
+	Group       *string `json:"group,omitempty"`
+	Kind        *string `json:"kind,omitempty"`
+	Namespace   *string `json:"namespace,omitempty"`
+	Name        string  `json:"name"`
+	SectionName *string `json:"sectionName,omitempty"`
+	Port        *int32  `json:"port,omitempty"`
+}
+
+// RouteRule forwards the traffic to its backends
+type RouteRule struct {
+	BackendRefs []BackendRef `json:"backendRefs,omitempty"`
+}
+
+// BackendRef refers to a port of a Service, the traffic is split between the backends of a rule by their weights,
+// which default to 1
+type BackendRef struct {
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace,omitempty"`
+	Port      *int32  `json:"port,omitempty"`
+	Weight    *int32  `json:"weight,omitempty"`
+}
+
+// RouteStatus holds the status of the route for each of its parents, as set by the controllers of the parents
+type RouteStatus struct {
+	Parents []RouteParentStatus `json:"parents,omitempty"`
+}
+
+// RouteParentStatus holds the conditions of the route set by the controller of one of its parents: Accepted tells
+// whether it is attached to the parent, ResolvedRefs whether its backends could all be resolved
+type RouteParentStatus struct {
+	ParentRef      ParentReference    `json:"parentRef"`
+	ControllerName string             `json:"controllerName"`
+	Conditions     []metav1.Condition `json:"conditions,omitempty"`
+}
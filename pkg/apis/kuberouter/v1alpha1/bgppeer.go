@@ -0,0 +1,102 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BGPPeerResource is the resource of the cluster scoped BGPPeer custom resource
+var BGPPeerResource = SchemeGroupVersion.WithResource("bgppeers")
+
+// BGPPeer is an external BGP router that the nodes it selects peer with
+type BGPPeer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BGPPeerSpec   `json:"spec"`
+	Status BGPPeerStatus `json:"status,omitempty"`
+}
+
+// BGPPeerSpec describes the peer router, the BGP session with it and the nodes that peer with it
+type BGPPeerSpec struct {
+	// PeerIP is the address of the peer router
+	PeerIP string `json:"peerIP"`
+	// PeerASN is the ASN of the peer router
+	PeerASN uint32 `json:"peerASN"`
+	// Port is the port the peer router listens on, 179 when not given
+	Port uint32 `json:"port,omitempty"`
+	// PasswordSecretRef is the key of the Secret holding the password of the TCP MD5 signatures of the session
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+	// HoldTime of the session, --bgp-holdtime when not given
+	HoldTime *metav1.Duration `json:"holdTime,omitempty"`
+	// MultihopTTL enables eBGP multihop with the given TTL when greater than 1, --peer-router-multihop-ttl when not
+	// given
+	MultihopTTL uint8 `json:"multihopTTL,omitempty"`
+	// TTLSecurityHops enables GTSM (RFC 5082) with the given number of hops to the peer router, it can't be combined
+	// with a multihop TTL greater than 1
+	TTLSecurityHops uint8 `json:"ttlSecurityHops,omitempty"`
+	// MaxPrefixes is the maximum number of prefixes accepted from the peer router before the session is torn down, no
+	// limit when not given
+	MaxPrefixes uint32 `json:"maxPrefixes,omitempty"`
+	// BFD sets the timers of the BFD session with the peer router when BFD is enabled by --peer-router-bfd
+	BFD *BGPPeerBFD `json:"bfd,omitempty"`
+	// GracefulRestart sets the times of the graceful restart of the session when it is enabled by
+	// --bgp-graceful-restart
+	GracefulRestart *BGPPeerGracefulRestart `json:"gracefulRestart,omitempty"`
+	// RPKIInvalidAction is what is done with the routes of the peer router the RPKI caches of --rpki-caches find
+	// invalid, one of reject, depreference or accept, --rpki-invalid-action when not given
+	RPKIInvalidAction string `json:"rpkiInvalidAction,omitempty"`
+	// NodeSelector restricts the peer to the nodes that have all of the given labels, an empty selector selects all
+	// nodes
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// BGPPeerStatus records the BGP sessions of the nodes with the peer router
+type BGPPeerStatus struct {
+	// Nodes maps the name of a node to its session with the peer router, each node records its own session
+	Nodes map[string]BGPPeerNodeStatus `json:"nodes,omitempty"`
+}
+
+// BGPPeerNodeStatus is the state of the BGP session of a node with the peer router
+type BGPPeerNodeStatus struct {
+	// SessionState is the state of the BGP finite state machine of the session, established when it is up
+	SessionState string `json:"sessionState"`
+	// EstablishedTime is the time the session was established, while it is
+	EstablishedTime *metav1.Time `json:"establishedTime,omitempty"`
+	// PrefixesReceived is the number of prefixes received from the peer router
+	PrefixesReceived int64 `json:"prefixesReceived"`
+	// PrefixesAdvertised is the number of prefixes advertised to the peer router
+	PrefixesAdvertised int64 `json:"prefixesAdvertised"`
+	// Flaps is the number of times the session went down after being established since kube-router started
+	Flaps uint32 `json:"flaps"`
+}
+
+// BGPPeerBFD are the timers of the BFD session with a peer router
+type BGPPeerBFD struct {
+	// Interval at which BFD control packets are sent to and expected from the peer router, --peer-router-bfd-interval
+	// when not given
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Multiplier is the number of control packets that can be missed before the session is declared down,
+	// --peer-router-bfd-multiplier when not given
+	Multiplier uint8 `json:"multiplier,omitempty"`
+}
+
+// BGPPeerGracefulRestart are the times the peer router keeps the routes of the node while the node restarts
+type BGPPeerGracefulRestart struct {
+	// RestartTime is how long the peer router waits for the session to be set up again before it withdraws the routes
+	// of the node, --bgp-graceful-restart-time when not given
+	RestartTime *metav1.Duration `json:"restartTime,omitempty"`
+	// LongLivedStaleTime is how long the peer router keeps the routes as stale routes after the restart time when
+	// Long-Lived Graceful Restart is enabled by --bgp-long-lived-graceful-restart, --bgp-long-lived-stale-time when
+	// not given
+	LongLivedStaleTime *metav1.Duration `json:"longLivedStaleTime,omitempty"`
+}
+
+// SecretKeyReference is a key of a Secret
+type SecretKeyReference struct {
+	// Namespace of the Secret
+	Namespace string `json:"namespace"`
+	// Name of the Secret
+	Name string `json:"name"`
+	// Key of the Secret holding the value, password when not given
+	Key string `json:"key,omitempty"`
+}
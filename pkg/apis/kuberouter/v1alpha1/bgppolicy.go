@@ -0,0 +1,80 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BGPPolicyResource is the resource of the cluster scoped BGPPolicy custom resource
+var BGPPolicyResource = SchemeGroupVersion.WithResource("bgppolicies")
+
+// The actions of the rules of the BGP policies
+const (
+	// BGPPolicyActionAccept accepts the route, the rules and policies after the rule aren't evaluated
+	BGPPolicyActionAccept = "Accept"
+	// BGPPolicyActionReject rejects the route
+	BGPPolicyActionReject = "Reject"
+)
+
+// BGPPolicy filters and modifies the routes learned from and advertised to the external BGP peers of the nodes
+type BGPPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BGPPolicySpec `json:"spec"`
+}
+
+// BGPPolicySpec describes the peers the policy applies to and its rules
+type BGPPolicySpec struct {
+	// Order orders the BGP policies, the lower the number the earlier the policy is evaluated. Policies of the same
+	// order are evaluated in the order of their names.
+	Order int32 `json:"order,omitempty"`
+	// Peers restricts the policy to the peers of the given addresses, when empty the policy applies to all external
+	// peers
+	Peers []string `json:"peers,omitempty"`
+	// Import rules are evaluated in order on the routes learned from the peers
+	Import []BGPPolicyRule `json:"import,omitempty"`
+	// Export rules are evaluated in order on the routes advertised to the peers
+	Export []BGPPolicyRule `json:"export,omitempty"`
+}
+
+// BGPPolicyRule matches the routes of any of the prefixes that have any of the communities
+type BGPPolicyRule struct {
+	// Prefixes the routes are matched against, when empty routes of all prefixes are matched
+	Prefixes []BGPPolicyPrefix `json:"prefixes,omitempty"`
+	// Communities the routes are matched against, when empty routes with and without communities are matched
+	Communities []string `json:"communities,omitempty"`
+	// Action is one of Accept or Reject, when not given the matched routes are modified as set and evaluated further
+	Action string `json:"action,omitempty"`
+	// Set modifies the matched routes, it can not be used with the Reject action
+	Set *BGPPolicySet `json:"set,omitempty"`
+}
+
+// BGPPolicyPrefix matches the prefixes within a CIDR
+type BGPPolicyPrefix struct {
+	// CIDR the prefixes are within
+	CIDR string `json:"cidr"`
+	// MinLength is the shortest prefix length matched, the prefix length of the CIDR when not given
+	MinLength int32 `json:"minLength,omitempty"`
+	// MaxLength is the longest prefix length matched, MinLength when not given
+	MaxLength int32 `json:"maxLength,omitempty"`
+}
+
+// BGPPolicySet modifies the attributes of the routes
+type BGPPolicySet struct {
+	// LocalPref replaces the local preference of the routes
+	LocalPref *uint32 `json:"localPref,omitempty"`
+	// MED replaces the multi exit discriminator of the routes
+	MED *uint32 `json:"med,omitempty"`
+	// ASPathPrepend prepends an AS to the AS path of the routes
+	ASPathPrepend *BGPPolicyASPathPrepend `json:"asPathPrepend,omitempty"`
+	// Communities are added to the communities of the routes
+	Communities []string `json:"communities,omitempty"`
+}
+
+// BGPPolicyASPathPrepend is the AS prepended to the AS path and how often it is
+type BGPPolicyASPathPrepend struct {
+	// ASN prepended to the AS path
+	ASN uint32 `json:"asn"`
+	// Repeat is the number of times the ASN is prepended, once when not given
+	Repeat uint8 `json:"repeat,omitempty"`
+}
@@ -0,0 +1,40 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressIPResource is the resource of the cluster scoped EgressIP custom resource
+var EgressIPResource = SchemeGroupVersion.WithResource("egressips")
+
+// EgressIP is a source address that the traffic of the pods it selects to destinations outside of the cluster is
+// translated to, on one of the nodes it selects
+type EgressIP struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EgressIPSpec   `json:"spec"`
+	Status EgressIPStatus `json:"status,omitempty"`
+}
+
+// EgressIPSpec describes the egress address, the pods whose traffic leaves the cluster from it and the nodes that can
+// hold it
+type EgressIPSpec struct {
+	// EgressIP is the address the source of the traffic of the pods is translated to, advertised to the BGP peers by
+	// the node holding it
+	EgressIP string `json:"egressIP"`
+	// NamespaceSelector and PodSelector select the pods whose traffic leaves the cluster from the egress address, a
+	// missing selector selects all namespaces or all of their pods. When both are missing no pod is selected.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// NodeSelector restricts the nodes that can hold the egress address to the ones that have all of the given labels,
+	// an empty selector selects all nodes
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// EgressIPStatus records the node holding the egress address
+type EgressIPStatus struct {
+	// EgressNode is the name of the node the traffic of the pods leaves the cluster from, written by the node when it
+	// takes over the egress address
+	EgressNode string `json:"egressNode,omitempty"`
+}
@@ -0,0 +1,79 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GlobalNetworkPolicyResource is the resource of the cluster scoped GlobalNetworkPolicy custom resource
+var GlobalNetworkPolicyResource = SchemeGroupVersion.WithResource("globalnetworkpolicies")
+
+// The actions of the rules of the global network policies
+const (
+	// GlobalNetworkPolicyActionAllow allows the traffic, the policies evaluated after the global network policy aren't
+	GlobalNetworkPolicyActionAllow = "Allow"
+	// GlobalNetworkPolicyActionDeny drops the traffic
+	GlobalNetworkPolicyActionDeny = "Deny"
+	// GlobalNetworkPolicyActionPass skips the remaining global network policies and the AdminNetworkPolicies of pods,
+	// the traffic is evaluated by the NetworkPolicies, or by the NodeFirewalls of host endpoints
+	GlobalNetworkPolicyActionPass = "Pass"
+)
+
+// GlobalNetworkPolicy is a cluster wide policy that applies to the pods of all namespaces and to the nodes themselves,
+// it is evaluated before the AdminNetworkPolicies and the NetworkPolicies of the pods and before the NodeFirewalls of
+// the nodes
+type GlobalNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GlobalNetworkPolicySpec `json:"spec"`
+}
+
+// GlobalNetworkPolicySpec describes the pods and host endpoints the policy applies to and its rules
+type GlobalNetworkPolicySpec struct {
+	// Order orders the global network policies, the lower the number the earlier the policy is evaluated. Policies of
+	// the same order are evaluated in the order of their names.
+	Order int32 `json:"order,omitempty"`
+	// NamespaceSelector and PodSelector select the pods the policy applies to, a missing selector selects all
+	// namespaces or all of their pods. When both are missing the policy applies to no pod.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// HostEndpoints selects the nodes whose own addresses the ingress rules of the policy apply to
+	HostEndpoints *metav1.LabelSelector `json:"hostEndpoints,omitempty"`
+	// Ingress rules are evaluated in order on the traffic to the pods and host endpoints, the first match wins
+	Ingress []GlobalNetworkPolicyRule `json:"ingress,omitempty"`
+	// Egress rules are evaluated in order on the traffic from the pods, the first match wins
+	Egress []GlobalNetworkPolicyRule `json:"egress,omitempty"`
+}
+
+// GlobalNetworkPolicyRule matches the traffic from (ingress) or to (egress) any of the peers to any of the ports
+type GlobalNetworkPolicyRule struct {
+	// Action is one of Allow, Deny or Pass
+	Action string `json:"action"`
+	// Peers restricts the sources of the ingress and the destinations of the egress traffic, when empty traffic from
+	// and to anywhere is matched
+	Peers []GlobalNetworkPolicyPeer `json:"peers,omitempty"`
+	// Ports the traffic is destined to, when empty traffic to all ports and protocols is matched
+	Ports []GlobalNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// GlobalNetworkPolicyPeer is either pods, nodes or a CIDR
+type GlobalNetworkPolicyPeer struct {
+	// NamespaceSelector and PodSelector select pods like the ones of the spec, they can not be used in the rules of
+	// host endpoints
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// Nodes selects the nodes of the cluster, by their internal and external IPs
+	Nodes *metav1.LabelSelector `json:"nodes,omitempty"`
+	// CIDR the traffic is sourced from or destined to
+	CIDR string `json:"cidr,omitempty"`
+}
+
+// GlobalNetworkPolicyPort is a port or port range of a protocol
+type GlobalNetworkPolicyPort struct {
+	// Protocol is one of TCP (the default), UDP or SCTP
+	Protocol string `json:"protocol,omitempty"`
+	// Port is the destination port, when not given all ports of the protocol are matched
+	Port int32 `json:"port,omitempty"`
+	// EndPort makes the rule match the range of ports from Port to EndPort
+	EndPort int32 `json:"endPort,omitempty"`
+}
@@ -0,0 +1,36 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IPPoolResource is the resource of the cluster scoped IPPool custom resource
+var IPPoolResource = SchemeGroupVersion.WithResource("ippools")
+
+// IPPool is a range of addresses that pod CIDRs are allocated to nodes from
+type IPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPPoolSpec   `json:"spec"`
+	Status IPPoolStatus `json:"status,omitempty"`
+}
+
+// IPPoolSpec describes the addresses of the pool and how they are carved up between nodes
+type IPPoolSpec struct {
+	// CIDR of the pool
+	CIDR string `json:"cidr"`
+	// BlockSize is the prefix length of the pod CIDR that is allocated to each node from the pool
+	BlockSize int `json:"blockSize"`
+	// NodeSelector restricts the pool to the nodes that have all of the given labels, an empty selector selects all
+	// nodes
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Disabled pools keep their existing allocations but do not allocate to new nodes
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// IPPoolStatus records the blocks of the pool that have been allocated
+type IPPoolStatus struct {
+	// Allocations maps the name of a node to the pod CIDR it was allocated from this pool
+	Allocations map[string]string `json:"allocations,omitempty"`
+}
@@ -0,0 +1,57 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeFirewallResource is the resource of the cluster scoped NodeFirewall custom resource
+var NodeFirewallResource = SchemeGroupVersion.WithResource("nodefirewalls")
+
+// NodeFirewall defines the traffic that is allowed to reach the services running on the nodes themselves (SSH, the
+// kubelet, BGP, NodePorts, ...) as opposed to the pods
+type NodeFirewall struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NodeFirewallSpec `json:"spec"`
+}
+
+// NodeFirewallSpec describes the nodes the firewall applies to and the ingress traffic it allows
+type NodeFirewallSpec struct {
+	// NodeSelector restricts the firewall to the nodes that have all of the given labels, an empty selector selects
+	// all nodes
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// DefaultDeny drops all traffic to the node that is not allowed by the ingress rules of the firewalls selecting
+	// it, otherwise the rules only open up traffic that would be dropped by the node's own firewall
+	DefaultDeny bool `json:"defaultDeny,omitempty"`
+	// Ingress rules allow traffic to the node
+	Ingress []NodeFirewallIngressRule `json:"ingress,omitempty"`
+}
+
+// NodeFirewallIngressRule allows traffic from any of the peers to any of the ports
+type NodeFirewallIngressRule struct {
+	// Ports the traffic is allowed to, when empty traffic to all ports and protocols is allowed
+	Ports []NodeFirewallPort `json:"ports,omitempty"`
+	// From restricts the sources of the traffic, when empty traffic from anywhere is allowed
+	From []NodeFirewallPeer `json:"from,omitempty"`
+}
+
+// NodeFirewallPort is a port or port range of a protocol
+type NodeFirewallPort struct {
+	// Protocol is one of TCP (the default), UDP, SCTP, ICMP or IPIP
+	Protocol string `json:"protocol,omitempty"`
+	// Port is the destination port, when not given all ports of the protocol are allowed. Only valid for TCP, UDP
+	// and SCTP.
+	Port int32 `json:"port,omitempty"`
+	// EndPort makes the rule allow the range of ports from Port to EndPort
+	EndPort int32 `json:"endPort,omitempty"`
+}
+
+// NodeFirewallPeer is a source of traffic, either a CIDR or the nodes of the cluster
+type NodeFirewallPeer struct {
+	// CIDR the traffic is sourced from
+	CIDR string `json:"cidr,omitempty"`
+	// ClusterNodes matches the traffic of the nodes of the cluster, by their node IPs and the BGP and overlay
+	// addresses they publish
+	ClusterNodes bool `json:"clusterNodes,omitempty"`
+}
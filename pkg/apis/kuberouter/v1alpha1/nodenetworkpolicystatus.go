@@ -0,0 +1,43 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeNetworkPolicyStatusResource is the resource of the cluster scoped NodeNetworkPolicyStatus custom resource
+var NodeNetworkPolicyStatusResource = SchemeGroupVersion.WithResource("nodenetworkpolicystatuses")
+
+// NodeNetworkPolicyStatusKind is the kind of the NodeNetworkPolicyStatus custom resource
+const NodeNetworkPolicyStatusKind = "NodeNetworkPolicyStatus"
+
+// NodeNetworkPolicyStatus records whether the network policies are enforced on a node, it is named after the node
+// and owned by it
+type NodeNetworkPolicyStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status NodeNetworkPolicyStatusStatus `json:"status,omitempty"`
+}
+
+// NodeNetworkPolicyStatusStatus is the outcome of the syncs of the network policies on the node
+type NodeNetworkPolicyStatusStatus struct {
+	// LastSyncTime is the time the last successful sync of the network policies finished
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// SyncVersion is the version of the chains of the last successful sync
+	SyncVersion string `json:"syncVersion,omitempty"`
+	// NetworkPolicies is the number of network policies enforced by the last successful sync
+	NetworkPolicies int `json:"networkPolicies"`
+	// AdminNetworkPolicies is the number of admin, baseline admin and global network policies enforced by the last
+	// successful sync
+	AdminNetworkPolicies int `json:"adminNetworkPolicies"`
+	// Pods is the number of pods of the node that have a firewall chain
+	Pods int `json:"pods"`
+	// Chains is the number of iptables chains of the network policies and of the pods
+	Chains int `json:"chains"`
+	// IPSets is the number of ipsets of the network policies
+	IPSets int `json:"ipSets"`
+	// Error is the error of the last sync when it failed, it is cleared by the next successful sync
+	Error string `json:"error,omitempty"`
+	// ErrorTime is the time the last sync failed
+	ErrorTime *metav1.Time `json:"errorTime,omitempty"`
+}
@@ -0,0 +1,29 @@
+// Package v1alpha1 contains the custom resources that are used to configure kube-router. The resources are accessed
+// through the dynamic client, so the types here only need to be able to convert to and from unstructured objects.
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the API group of kube-router's custom resources
+const GroupName = "kube-router.io"
+
+// SchemeGroupVersion is the group version of the resources in this package
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+// FromUnstructured converts an unstructured object returned by the dynamic client into one of the types in this package
+func FromUnstructured(u *unstructured.Unstructured, obj interface{}) error {
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), obj)
+}
+
+// ToUnstructured converts one of the types in this package into an unstructured object for use with the dynamic client
+func ToUnstructured(obj interface{}) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
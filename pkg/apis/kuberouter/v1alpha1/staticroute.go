@@ -0,0 +1,42 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StaticRouteResource is the resource of the cluster scoped StaticRoute custom resource
+var StaticRouteResource = SchemeGroupVersion.WithResource("staticroutes")
+
+// The types of the static routes
+const (
+	// StaticRouteTypeUnicast routes the traffic to the destination through the gateway
+	StaticRouteTypeUnicast = "Unicast"
+	// StaticRouteTypeBlackhole silently drops the traffic to the destination
+	StaticRouteTypeBlackhole = "Blackhole"
+)
+
+// StaticRoute is a route that the nodes it selects install in their routing table
+type StaticRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec StaticRouteSpec `json:"spec"`
+}
+
+// StaticRouteSpec describes the route and the nodes that install it
+type StaticRouteSpec struct {
+	// Destination is the CIDR the route is to
+	Destination string `json:"destination"`
+	// Type of the route, one of Unicast or Blackhole, Unicast when not given
+	Type string `json:"type,omitempty"`
+	// Gateway is the address the traffic to the destination is routed through, it is required by and only allowed for
+	// Unicast routes
+	Gateway string `json:"gateway,omitempty"`
+	// Table is the routing table the route is installed in, the main table when not given
+	Table int `json:"table,omitempty"`
+	// Metric is the priority of the route, lower metrics being preferred
+	Metric int `json:"metric,omitempty"`
+	// NodeSelector restricts the route to the nodes that have all of the given labels, an empty selector selects all
+	// nodes
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
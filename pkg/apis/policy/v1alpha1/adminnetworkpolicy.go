@@ -0,0 +1,130 @@
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// AdminNetworkPolicyResource is the resource of the cluster scoped AdminNetworkPolicy custom resource
+	AdminNetworkPolicyResource = SchemeGroupVersion.WithResource("adminnetworkpolicies")
+	// BaselineAdminNetworkPolicyResource is the resource of the cluster scoped BaselineAdminNetworkPolicy custom
+	// resource
+	BaselineAdminNetworkPolicyResource = SchemeGroupVersion.WithResource("baselineadminnetworkpolicies")
+)
+
+// BaselineAdminNetworkPolicyName is the name of the single BaselineAdminNetworkPolicy of the cluster
+const BaselineAdminNetworkPolicyName = "default"
+
+// The actions of the rules of the admin network policies
+const (
+	// AdminNetworkPolicyRuleActionAllow allows the traffic, the lower tiers aren't evaluated
+	AdminNetworkPolicyRuleActionAllow = "Allow"
+	// AdminNetworkPolicyRuleActionDeny drops the traffic, the lower tiers aren't evaluated
+	AdminNetworkPolicyRuleActionDeny = "Deny"
+	// AdminNetworkPolicyRuleActionPass skips the lower priority AdminNetworkPolicies, the traffic is evaluated by the
+	// NetworkPolicies, or the BaselineAdminNetworkPolicy when none selects the pod. Not valid for the baseline.
+	AdminNetworkPolicyRuleActionPass = "Pass"
+)
+
+// AdminNetworkPolicy is a cluster wide policy evaluated before the NetworkPolicies
+type AdminNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AdminNetworkPolicySpec `json:"spec"`
+}
+
+// AdminNetworkPolicySpec describes the pods the policy applies to and its rules
+type AdminNetworkPolicySpec struct {
+	// Priority orders the AdminNetworkPolicies, the lower the number the higher the precedence, from 0 to 1000
+	Priority int32 `json:"priority"`
+	// Subject selects the pods the policy applies to
+	Subject AdminNetworkPolicySubject `json:"subject"`
+	// Ingress rules are evaluated in order on the traffic to the pods of the subject, the first match wins
+	Ingress []AdminNetworkPolicyIngressRule `json:"ingress,omitempty"`
+	// Egress rules are evaluated in order on the traffic from the pods of the subject, the first match wins
+	Egress []AdminNetworkPolicyEgressRule `json:"egress,omitempty"`
+}
+
+// BaselineAdminNetworkPolicy is the cluster wide default policy of the pods no NetworkPolicy selects
+type BaselineAdminNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BaselineAdminNetworkPolicySpec `json:"spec"`
+}
+
+// BaselineAdminNetworkPolicySpec describes the pods the baseline applies to and its rules, which have the same shape
+// as the ones of the AdminNetworkPolicies but can only Allow or Deny
+type BaselineAdminNetworkPolicySpec struct {
+	Subject AdminNetworkPolicySubject       `json:"subject"`
+	Ingress []AdminNetworkPolicyIngressRule `json:"ingress,omitempty"`
+	Egress  []AdminNetworkPolicyEgressRule  `json:"egress,omitempty"`
+}
+
+// AdminNetworkPolicySubject selects pods, either all the pods of namespaces or some of their pods
+type AdminNetworkPolicySubject struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPod        `json:"pods,omitempty"`
+}
+
+// NamespacedPod selects the pods matching the pod selector in the namespaces matching the namespace selector
+type NamespacedPod struct {
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+	PodSelector       metav1.LabelSelector `json:"podSelector"`
+}
+
+// AdminNetworkPolicyIngressRule matches the traffic from any of the peers to any of the ports
+type AdminNetworkPolicyIngressRule struct {
+	Name   string                          `json:"name,omitempty"`
+	Action string                          `json:"action"`
+	From   []AdminNetworkPolicyIngressPeer `json:"from"`
+	// Ports the traffic is matched on, all ports when not given. Named ports are resolved on the pods of the subject.
+	Ports []AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// AdminNetworkPolicyIngressPeer is a source of traffic, exactly one of the fields is set
+type AdminNetworkPolicyIngressPeer struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPod        `json:"pods,omitempty"`
+}
+
+// AdminNetworkPolicyEgressRule matches the traffic to any of the peers to any of the ports
+type AdminNetworkPolicyEgressRule struct {
+	Name   string                         `json:"name,omitempty"`
+	Action string                         `json:"action"`
+	To     []AdminNetworkPolicyEgressPeer `json:"to"`
+	// Ports the traffic is matched on, all ports when not given. Named ports are resolved on the pods of the peers.
+	Ports []AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// AdminNetworkPolicyEgressPeer is a destination of traffic, exactly one of the fields is set
+type AdminNetworkPolicyEgressPeer struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPod        `json:"pods,omitempty"`
+	// Nodes selects the nodes by their labels, the traffic to their internal and external IPs is matched
+	Nodes *metav1.LabelSelector `json:"nodes,omitempty"`
+	// Networks are CIDRs
+	Networks []string `json:"networks,omitempty"`
+}
+
+// AdminNetworkPolicyPort is a port, a named port or a range of ports, exactly one of the fields is set
+type AdminNetworkPolicyPort struct {
+	PortNumber *Port      `json:"portNumber,omitempty"`
+	NamedPort  *string    `json:"namedPort,omitempty"`
+	PortRange  *PortRange `json:"portRange,omitempty"`
+}
+
+// Port is a port of a protocol
+type Port struct {
+	Protocol v1.Protocol `json:"protocol"`
+	Port     int32       `json:"port"`
+}
+
+// PortRange is the range of ports from Start to End of a protocol
+type PortRange struct {
+	Protocol v1.Protocol `json:"protocol,omitempty"`
+	Start    int32       `json:"start"`
+	End      int32       `json:"end"`
+}
@@ -0,0 +1,14 @@
+// Package v1alpha1 contains the subset of the AdminNetworkPolicy API of sig-network that kube-router enforces. The CRDs
+// are published by the network-policy-api project, the resources are accessed through the dynamic client and converted
+// with the helpers of the kube-router.io API.
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the API group of the AdminNetworkPolicy resources
+const GroupName = "policy.networking.k8s.io"
+
+// SchemeGroupVersion is the group version of the resources in this package
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
@@ -0,0 +1,137 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// version is the version of the BFD protocol as defined by RFC 5880
+	version = 1
+	// controlPacketLength is the length of a BFD control packet without the optional authentication section
+	controlPacketLength = 24
+
+	flagPoll       = 0x20
+	flagFinal      = 0x10
+	flagAuth       = 0x04
+	flagDemand     = 0x02
+	flagMultipoint = 0x01
+)
+
+// State is the state of a BFD session
+type State uint8
+
+const (
+	StateAdminDown State = 0
+	StateDown      State = 1
+	StateInit      State = 2
+	StateUp        State = 3
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAdminDown:
+		return "AdminDown"
+	case StateDown:
+		return "Down"
+	case StateInit:
+		return "Init"
+	case StateUp:
+		return "Up"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint8(s))
+}
+
+// Diagnostic is the reason of the last state change of a BFD session
+type Diagnostic uint8
+
+const (
+	DiagNone                 Diagnostic = 0
+	DiagControlDetectExpired Diagnostic = 1
+	DiagNeighborSignaledDown Diagnostic = 3
+	DiagAdministrativelyDown Diagnostic = 7
+)
+
+// ControlPacket is a BFD control packet (RFC 5880 section 4.1), authentication is not supported
+type ControlPacket struct {
+	Diag                      Diagnostic
+	State                     State
+	Poll                      bool
+	Final                     bool
+	Demand                    bool
+	DetectMult                uint8
+	MyDiscriminator           uint32
+	YourDiscriminator         uint32
+	DesiredMinTxInterval      uint32
+	RequiredMinRxInterval     uint32
+	RequiredMinEchoRxInterval uint32
+}
+
+// Marshal encodes the control packet
+func (p *ControlPacket) Marshal() []byte {
+	b := make([]byte, controlPacketLength)
+	b[0] = version<<5 | uint8(p.Diag)&0x1f
+	b[1] = uint8(p.State) << 6
+	if p.Poll {
+		b[1] |= flagPoll
+	}
+	if p.Final {
+		b[1] |= flagFinal
+	}
+	if p.Demand {
+		b[1] |= flagDemand
+	}
+	b[2] = p.DetectMult
+	b[3] = controlPacketLength
+	binary.BigEndian.PutUint32(b[4:], p.MyDiscriminator)
+	binary.BigEndian.PutUint32(b[8:], p.YourDiscriminator)
+	binary.BigEndian.PutUint32(b[12:], p.DesiredMinTxInterval)
+	binary.BigEndian.PutUint32(b[16:], p.RequiredMinRxInterval)
+	binary.BigEndian.PutUint32(b[20:], p.RequiredMinEchoRxInterval)
+	return b
+}
+
+// UnmarshalControlPacket decodes and validates a control packet following the reception rules of RFC 5880 section
+// 6.8.6 that don't depend on the state of a session
+func UnmarshalControlPacket(b []byte) (*ControlPacket, error) {
+	if len(b) < controlPacketLength {
+		return nil, errors.New("packet too short")
+	}
+	if b[0]>>5 != version {
+		return nil, fmt.Errorf("unsupported version %d", b[0]>>5)
+	}
+	length := int(b[3])
+	if length < controlPacketLength || length > len(b) {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	if b[1]&flagAuth != 0 {
+		return nil, errors.New("authentication is not supported")
+	}
+	if b[1]&flagMultipoint != 0 {
+		return nil, errors.New("multipoint bit set")
+	}
+	p := &ControlPacket{
+		Diag:                      Diagnostic(b[0] & 0x1f),
+		State:                     State(b[1] >> 6),
+		Poll:                      b[1]&flagPoll != 0,
+		Final:                     b[1]&flagFinal != 0,
+		Demand:                    b[1]&flagDemand != 0,
+		DetectMult:                b[2],
+		MyDiscriminator:           binary.BigEndian.Uint32(b[4:]),
+		YourDiscriminator:         binary.BigEndian.Uint32(b[8:]),
+		DesiredMinTxInterval:      binary.BigEndian.Uint32(b[12:]),
+		RequiredMinRxInterval:     binary.BigEndian.Uint32(b[16:]),
+		RequiredMinEchoRxInterval: binary.BigEndian.Uint32(b[20:]),
+	}
+	if p.DetectMult == 0 {
+		return nil, errors.New("detect multiplier is zero")
+	}
+	if p.MyDiscriminator == 0 {
+		return nil, errors.New("my discriminator is zero")
+	}
+	if p.YourDiscriminator == 0 && p.State != StateDown && p.State != StateAdminDown {
+		return nil, fmt.Errorf("your discriminator is zero in state %s", p.State)
+	}
+	return p, nil
+}
@@ -0,0 +1,69 @@
+package bfd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ControlPacketMarshal(t *testing.T) {
+	p := &ControlPacket{
+		Diag:                  DiagControlDetectExpired,
+		State:                 StateUp,
+		Poll:                  true,
+		DetectMult:            3,
+		MyDiscriminator:       0x01020304,
+		YourDiscriminator:     0x05060708,
+		DesiredMinTxInterval:  300000,
+		RequiredMinRxInterval: 300000,
+	}
+	b := p.Marshal()
+	assert.Equal(t, []byte{
+		0x21, 0xe0, 0x03, 0x18,
+		0x01, 0x02, 0x03, 0x04,
+		0x05, 0x06, 0x07, 0x08,
+		0x00, 0x04, 0x93, 0xe0,
+		0x00, 0x04, 0x93, 0xe0,
+		0x00, 0x00, 0x00, 0x00,
+	}, b)
+
+	decoded, err := UnmarshalControlPacket(b)
+	assert.NoError(t, err)
+	assert.Equal(t, p, decoded)
+}
+
+func Test_UnmarshalControlPacket(t *testing.T) {
+	valid := (&ControlPacket{State: StateUp, DetectMult: 3, MyDiscriminator: 1, YourDiscriminator: 2}).Marshal()
+	modified := func(f func(b []byte)) []byte {
+		b := append([]byte{}, valid...)
+		f(b)
+		return b
+	}
+
+	testcases := []struct {
+		name   string
+		packet []byte
+		err    bool
+	}{
+		{"valid", valid, false},
+		{"too short", valid[:20], true},
+		{"wrong version", modified(func(b []byte) { b[0] = 2 << 5 }), true},
+		{"length larger than packet", modified(func(b []byte) { b[3] = 48 }), true},
+		{"authentication", modified(func(b []byte) { b[1] |= flagAuth }), true},
+		{"multipoint", modified(func(b []byte) { b[1] |= flagMultipoint }), true},
+		{"zero detect multiplier", modified(func(b []byte) { b[2] = 0 }), true},
+		{"zero my discriminator", modified(func(b []byte) { copy(b[4:8], []byte{0, 0, 0, 0}) }), true},
+		{"zero your discriminator when up", modified(func(b []byte) { copy(b[8:12], []byte{0, 0, 0, 0}) }), true},
+		{"zero your discriminator when down", modified(func(b []byte) {
+			b[1] = uint8(StateDown) << 6
+			copy(b[8:12], []byte{0, 0, 0, 0})
+		}), false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := UnmarshalControlPacket(tc.packet)
+			assert.Equal(t, tc.err, err != nil, "unexpected error: %v", err)
+		})
+	}
+}
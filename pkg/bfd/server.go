@@ -0,0 +1,277 @@
+package bfd
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ControlPort is the destination UDP port of single hop BFD control packets (RFC 5881 section 4)
+	ControlPort = 3784
+	// the source port of the control packets must be in this range and unique per session (RFC 5881 section 4)
+	minSourcePort = 49152
+	maxSourcePort = 65535
+	// single hop control packets are sent with a TTL of 255 and any other TTL is dropped on reception (RFC 5881
+	// section 5), so that they can't be spoofed from a remote network
+	singleHopTTL = 255
+
+	sourcePortAttempts = 32
+)
+
+// SessionConfig is the configuration of a BFD session
+type SessionConfig struct {
+	LocalAddress  net.IP
+	RemoteAddress net.IP
+	// MinInterval is used as both the desired minimum transmit interval and the required minimum receive interval
+	MinInterval      time.Duration
+	DetectMultiplier uint8
+	// OnStateChange is called each time the session changes state, in order and outside of the session loop
+	OnStateChange func(remote net.IP, state State)
+}
+
+// Server runs single hop BFD sessions with a set of peers
+type Server struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	byDiscr  map[uint32]*Session
+	conns    []net.PacketConn
+	wg       sync.WaitGroup
+}
+
+// NewServer returns a BFD server, Start must be called before any session is added
+func NewServer() *Server {
+	return &Server{
+		sessions: make(map[string]*Session),
+		byDiscr:  make(map[uint32]*Session),
+	}
+}
+
+// Start listens for control packets on both address families, the IPv6 listener is optional as IPv6 may be disabled
+// on the node
+func (s *Server) Start() error {
+	conn4, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", ControlPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen for BFD control packets on UDP port %d: %v", ControlPort, err)
+	}
+	pc4 := ipv4.NewPacketConn(conn4)
+	if err = pc4.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+		_ = conn4.Close()
+		return fmt.Errorf("failed to enable TTL control messages: %v", err)
+	}
+	s.conns = append(s.conns, conn4)
+	s.wg.Add(1)
+	go s.receive(func(b []byte) (int, int, net.Addr, error) {
+		n, cm, src, err := pc4.ReadFrom(b)
+		if cm == nil {
+			return n, 0, src, err
+		}
+		return n, cm.TTL, src, err
+	})
+
+	conn6, err := net.ListenPacket("udp6", fmt.Sprintf("[::]:%d", ControlPort))
+	if err != nil {
+		klog.Warningf("Failed to listen for IPv6 BFD control packets, IPv6 BFD sessions won't come up: %v", err)
+		return nil
+	}
+	pc6 := ipv6.NewPacketConn(conn6)
+	if err = pc6.SetControlMessage(ipv6.FlagHopLimit, true); err != nil {
+		_ = conn6.Close()
+		klog.Warningf("Failed to enable hop limit control messages, IPv6 BFD sessions won't come up: %v", err)
+		return nil
+	}
+	s.conns = append(s.conns, conn6)
+	s.wg.Add(1)
+	go s.receive(func(b []byte) (int, int, net.Addr, error) {
+		n, cm, src, err := pc6.ReadFrom(b)
+		if cm == nil {
+			return n, 0, src, err
+		}
+		return n, cm.HopLimit, src, err
+	})
+	return nil
+}
+
+// Stop stops all the sessions, sending a last AdminDown control packet to the peers, and closes the listeners
+func (s *Server) Stop() {
+	s.mu.Lock()
+	for remote, session := range s.sessions {
+		session.stop()
+		delete(s.sessions, remote)
+		delete(s.byDiscr, session.localDiscr)
+	}
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) receive(readFrom func(b []byte) (int, int, net.Addr, error)) {
+	defer s.wg.Done()
+	b := make([]byte, 512)
+	for {
+		n, ttl, src, err := readFrom(b)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			klog.V(1).Infof("Failed to read BFD control packet: %v", err)
+			continue
+		}
+		if ttl != singleHopTTL {
+			klog.V(2).Infof("Dropping BFD control packet from %s with TTL %d", src, ttl)
+			continue
+		}
+		p, err := UnmarshalControlPacket(b[:n])
+		if err != nil {
+			klog.V(2).Infof("Dropping invalid BFD control packet from %s: %v", src, err)
+			continue
+		}
+		udpAddr, ok := src.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		session := s.lookupSession(p, udpAddr.IP)
+		if session == nil {
+			klog.V(2).Infof("Dropping BFD control packet from %s without a matching session", src)
+			continue
+		}
+		select {
+		case session.rxCh <- p:
+		default:
+			klog.V(1).Infof("Dropping BFD control packet from %s, the session isn't keeping up", src)
+		}
+	}
+}
+
+// lookupSession demultiplexes a received control packet to its session, by our discriminator once the peer knows it
+// or else by the address of the peer (RFC 5880 section 6.3)
+func (s *Server) lookupSession(p *ControlPacket, src net.IP) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.YourDiscriminator != 0 {
+		session, ok := s.byDiscr[p.YourDiscriminator]
+		if !ok || !session.remoteAddress.Equal(src) {
+			return nil
+		}
+		return session
+	}
+	return s.sessions[src.String()]
+}
+
+// AddSession starts a session with the peer of the given configuration. Adding a session for a peer that already has
+// one only applies the interval and the multiplier to it, unless the local address changed in which case the session
+// is restarted from the new address.
+func (s *Server) AddSession(cfg SessionConfig) error {
+	if cfg.RemoteAddress == nil {
+		return errors.New("remote address of the BFD session is missing")
+	}
+	if cfg.MinInterval <= 0 || cfg.DetectMultiplier == 0 {
+		return fmt.Errorf("invalid BFD interval %s or multiplier %d", cfg.MinInterval, cfg.DetectMultiplier)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.sessions[cfg.RemoteAddress.String()]; ok {
+		if existing.localAddress.Equal(cfg.LocalAddress) {
+			existing.setTimers(cfg.MinInterval, cfg.DetectMultiplier)
+			return nil
+		}
+		existing.stop()
+		delete(s.sessions, cfg.RemoteAddress.String())
+		delete(s.byDiscr, existing.localDiscr)
+	}
+
+	conn, err := dialControlConn(cfg.LocalAddress, cfg.RemoteAddress)
+	if err != nil {
+		return err
+	}
+	var discr uint32
+	for discr == 0 || s.byDiscr[discr] != nil {
+		//nolint:gosec // discriminators only need to be unique, not unpredictable
+		discr = rand.Uint32()
+	}
+	session := newSession(cfg, discr)
+	session.conn = conn
+	s.sessions[cfg.RemoteAddress.String()] = session
+	s.byDiscr[discr] = session
+	go session.run()
+	klog.Infof("Started BFD session with %s", cfg.RemoteAddress)
+	return nil
+}
+
+// DeleteSession stops the session with the given peer
+func (s *Server) DeleteSession(remote net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[remote.String()]
+	if !ok {
+		return
+	}
+	session.stop()
+	delete(s.sessions, remote.String())
+	delete(s.byDiscr, session.localDiscr)
+	klog.Infof("Stopped BFD session with %s", remote)
+}
+
+// Peers returns the addresses of the peers that have a session
+func (s *Server) Peers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]string, 0, len(s.sessions))
+	for remote := range s.sessions {
+		peers = append(peers, remote)
+	}
+	return peers
+}
+
+// SessionState returns the state of the session with the given peer
+func (s *Server) SessionState(remote net.IP) (State, bool) {
+	s.mu.Lock()
+	session, ok := s.sessions[remote.String()]
+	s.mu.Unlock()
+	if !ok {
+		return StateDown, false
+	}
+	return session.State(), true
+}
+
+// dialControlConn opens the socket a session sends its control packets from, with a TTL of 255 and a source port in
+// the range reserved by RFC 5881
+func dialControlConn(local, remote net.IP) (net.Conn, error) {
+	network := "udp4"
+	if remote.To4() == nil {
+		network = "udp6"
+	}
+	var lastErr error
+	for i := 0; i < sourcePortAttempts; i++ {
+		//nolint:gosec // the source port doesn't need a cryptographically secure random number
+		port := minSourcePort + rand.Intn(maxSourcePort-minSourcePort+1)
+		conn, err := net.DialUDP(network, &net.UDPAddr{IP: local, Port: port},
+			&net.UDPAddr{IP: remote, Port: ControlPort})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if network == "udp4" {
+			err = ipv4.NewConn(conn).SetTTL(singleHopTTL)
+		} else {
+			err = ipv6.NewConn(conn).SetHopLimit(singleHopTTL)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to set the TTL of BFD control packets: %v", err)
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("failed to open a socket to send BFD control packets to %s: %v", remote, lastErr)
+}
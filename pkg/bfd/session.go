@@ -0,0 +1,316 @@
+package bfd
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// slowTxInterval is the minimum transmit interval while a session is not Up (RFC 5880 section 6.8.3)
+	slowTxInterval = time.Second
+)
+
+// Session is a single hop BFD session in asynchronous mode (RFC 5880 and RFC 5881). The state machine itself is
+// driven by handlePacket and checkDetectionTime, which don't do any I/O so that they can be exercised without sockets.
+type Session struct {
+	mu sync.Mutex
+
+	localAddress  net.IP
+	remoteAddress net.IP
+
+	state            State
+	remoteState      State
+	localDiscr       uint32
+	remoteDiscr      uint32
+	localDiag        Diagnostic
+	desiredMinTx     time.Duration
+	activeMinTx      time.Duration
+	requiredMinRx    time.Duration
+	detectMult       uint8
+	remoteMinRx      time.Duration
+	remoteDesiredTx  time.Duration
+	remoteDetectMult uint8
+	pollActive       bool
+	lastRx           time.Time
+	onStateChange    func(remote net.IP, state State)
+	stateChangeCh    chan State
+	conn             net.Conn
+	rxCh             chan *ControlPacket
+	stopCh           chan struct{}
+	doneCh           chan struct{}
+}
+
+func newSession(cfg SessionConfig, localDiscr uint32) *Session {
+	s := &Session{
+		localAddress:  cfg.LocalAddress,
+		remoteAddress: cfg.RemoteAddress,
+		state:         StateDown,
+		remoteState:   StateDown,
+		localDiscr:    localDiscr,
+		desiredMinTx:  cfg.MinInterval,
+		activeMinTx:   cfg.MinInterval,
+		requiredMinRx: cfg.MinInterval,
+		detectMult:    cfg.DetectMultiplier,
+		// RFC 5880 section 6.8.1, bfd.RemoteMinRxInterval is initialized to 1 microsecond
+		remoteMinRx:   time.Microsecond,
+		onStateChange: cfg.OnStateChange,
+		stateChangeCh: make(chan State, 16),
+		rxCh:          make(chan *ControlPacket, 16),
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+	go s.notifyStateChanges()
+	return s
+}
+
+// notifyStateChanges calls the state change callback outside of the session loop, as it may be slow (e.g.
+// reconfiguring the BGP server), while keeping the changes in order
+func (s *Session) notifyStateChanges() {
+	for state := range s.stateChangeCh {
+		if s.onStateChange != nil {
+			s.onStateChange(s.remoteAddress, state)
+		}
+	}
+}
+
+// State returns the current state of the session
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// setState must be called with the session mutex held
+func (s *Session) setState(state State, diag Diagnostic) {
+	if s.state == state {
+		return
+	}
+	klog.Infof("BFD session with %s changed state from %s to %s", s.remoteAddress, s.state, state)
+	s.state = state
+	s.localDiag = diag
+	switch state {
+	case StateUp:
+		// the transmit interval is about to drop from the slow interval to the configured one, which has to be
+		// negotiated with a poll sequence (RFC 5880 section 6.8.3)
+		if s.desiredMinTx < slowTxInterval {
+			s.pollActive = true
+		}
+	case StateDown:
+		s.pollActive = false
+	}
+	s.stateChangeCh <- state
+}
+
+// handlePacket processes a received control packet following RFC 5880 section 6.8.6, it returns true when a packet
+// with the final bit has to be sent in response to a poll
+func (s *Session) handlePacket(p *ControlPacket, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remoteDiscr = p.MyDiscriminator
+	s.remoteState = p.State
+	s.remoteDetectMult = p.DetectMult
+	s.remoteMinRx = time.Duration(p.RequiredMinRxInterval) * time.Microsecond
+	s.remoteDesiredTx = time.Duration(p.DesiredMinTxInterval) * time.Microsecond
+	s.lastRx = now
+	if p.Final {
+		s.pollActive = false
+		s.activeMinTx = s.desiredMinTx
+	}
+
+	if s.state == StateAdminDown {
+		return false
+	}
+	if p.State == StateAdminDown {
+		if s.state != StateDown {
+			s.setState(StateDown, DiagNeighborSignaledDown)
+		}
+		return p.Poll
+	}
+	switch s.state {
+	case StateDown:
+		switch p.State {
+		case StateDown:
+			s.setState(StateInit, DiagNone)
+		case StateInit:
+			s.setState(StateUp, DiagNone)
+		}
+	case StateInit:
+		if p.State == StateInit || p.State == StateUp {
+			s.setState(StateUp, DiagNone)
+		}
+	case StateUp:
+		if p.State == StateDown {
+			s.setState(StateDown, DiagNeighborSignaledDown)
+		}
+	}
+	return p.Poll
+}
+
+// detectionTime returns the detection time of the session in asynchronous mode (RFC 5880 section 6.8.4), it must be
+// called with the session mutex held
+func (s *Session) detectionTime() time.Duration {
+	interval := s.requiredMinRx
+	if s.remoteDesiredTx > interval {
+		interval = s.remoteDesiredTx
+	}
+	return time.Duration(s.remoteDetectMult) * interval
+}
+
+// checkDetectionTime brings the session down when no packet has been received from the peer for a detection time
+func (s *Session) checkDetectionTime(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != StateInit && s.state != StateUp {
+		return
+	}
+	if now.Sub(s.lastRx) > s.detectionTime() {
+		s.remoteDiscr = 0
+		s.setState(StateDown, DiagControlDetectExpired)
+	}
+}
+
+// txInterval returns the interval until the next periodic control packet, including the jitter of RFC 5880 section
+// 6.8.7, or zero when no periodic packets must be sent
+func (s *Session) txInterval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.remoteMinRx == 0 && s.remoteDiscr != 0 {
+		return 0
+	}
+	interval := s.activeMinTx
+	if s.state != StateUp && interval < slowTxInterval {
+		interval = slowTxInterval
+	}
+	if s.remoteMinRx > interval {
+		interval = s.remoteMinRx
+	}
+	// reduce the interval by a random 0 to 25%, or 10 to 25% with a detection multiplier of 1
+	maxJitter, minJitter := 25, 0
+	if s.detectMult == 1 {
+		minJitter = 10
+	}
+	//nolint:gosec // the jitter doesn't need a cryptographically secure random number
+	jitter := minJitter + rand.Intn(maxJitter-minJitter+1)
+	return interval * time.Duration(100-jitter) / 100
+}
+
+// setTimers changes the interval and the detection multiplier of the session. While the session is Up the change is
+// negotiated with a poll sequence, and an increased transmit interval only takes effect once the peer acknowledged it
+// (RFC 5880 section 6.8.3).
+func (s *Session) setTimers(minInterval time.Duration, detectMult uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.desiredMinTx == minInterval && s.requiredMinRx == minInterval && s.detectMult == detectMult {
+		return
+	}
+	klog.Infof("BFD session with %s changed its interval from %s to %s and its multiplier from %d to %d",
+		s.remoteAddress, s.desiredMinTx, minInterval, s.detectMult, detectMult)
+	s.desiredMinTx = minInterval
+	s.requiredMinRx = minInterval
+	s.detectMult = detectMult
+	if s.state == StateUp {
+		s.pollActive = true
+		if minInterval < s.activeMinTx {
+			s.activeMinTx = minInterval
+		}
+	} else {
+		s.activeMinTx = minInterval
+	}
+}
+
+// detectCheckInterval returns how often the detection time is checked, a few times per receive interval so that
+// failures are detected close to the detection time
+func (s *Session) detectCheckInterval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requiredMinRx / 4
+}
+
+// controlPacket builds the next control packet to send to the peer
+func (s *Session) controlPacket(final bool) *ControlPacket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	desiredMinTx := s.desiredMinTx
+	if s.state != StateUp && desiredMinTx < slowTxInterval {
+		desiredMinTx = slowTxInterval
+	}
+	return &ControlPacket{
+		Diag:                  s.localDiag,
+		State:                 s.state,
+		Poll:                  s.pollActive && !final,
+		Final:                 final,
+		DetectMult:            s.detectMult,
+		MyDiscriminator:       s.localDiscr,
+		YourDiscriminator:     s.remoteDiscr,
+		DesiredMinTxInterval:  uint32(desiredMinTx / time.Microsecond),
+		RequiredMinRxInterval: uint32(s.requiredMinRx / time.Microsecond),
+	}
+}
+
+func (s *Session) send(p *ControlPacket) error {
+	_, err := s.conn.Write(p.Marshal())
+	return err
+}
+
+// run sends the periodic control packets and processes the received ones until the session is stopped
+func (s *Session) run() {
+	defer close(s.doneCh)
+
+	txTimer := time.NewTimer(0)
+	defer txTimer.Stop()
+	detectCheckInterval := s.detectCheckInterval()
+	detectTicker := time.NewTicker(detectCheckInterval)
+	defer detectTicker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			// let the peer know that the session is going away on purpose, so that it doesn't treat it as a failure
+			s.mu.Lock()
+			s.state = StateAdminDown
+			s.localDiag = DiagAdministrativelyDown
+			s.mu.Unlock()
+			if err := s.send(s.controlPacket(false)); err != nil {
+				klog.V(1).Infof("Failed to send BFD control packet to %s: %v", s.remoteAddress, err)
+			}
+			return
+		case p := <-s.rxCh:
+			if s.handlePacket(p, time.Now()) {
+				if err := s.send(s.controlPacket(true)); err != nil {
+					klog.V(1).Infof("Failed to send BFD control packet to %s: %v", s.remoteAddress, err)
+				}
+			}
+		case <-detectTicker.C:
+			s.checkDetectionTime(time.Now())
+			// follow the changes of the receive interval
+			if interval := s.detectCheckInterval(); interval != detectCheckInterval {
+				detectCheckInterval = interval
+				detectTicker.Reset(interval)
+			}
+		case <-txTimer.C:
+			interval := s.txInterval()
+			if interval == 0 {
+				txTimer.Reset(slowTxInterval)
+				continue
+			}
+			if err := s.send(s.controlPacket(false)); err != nil {
+				klog.V(1).Infof("Failed to send BFD control packet to %s: %v", s.remoteAddress, err)
+			}
+			txTimer.Reset(interval)
+		}
+	}
+}
+
+func (s *Session) stop() {
+	close(s.stopCh)
+	<-s.doneCh
+	close(s.stateChangeCh)
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+}
@@ -0,0 +1,126 @@
+package bfd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSession() (*Session, chan State) {
+	states := make(chan State, 16)
+	s := newSession(SessionConfig{
+		RemoteAddress:    net.ParseIP("10.0.0.1"),
+		MinInterval:      300 * time.Millisecond,
+		DetectMultiplier: 3,
+		OnStateChange: func(_ net.IP, state State) {
+			states <- state
+		},
+	}, 42)
+	return s, states
+}
+
+func Test_SessionThreeWayHandshake(t *testing.T) {
+	s, states := newTestSession()
+	now := time.Now()
+
+	s.handlePacket(&ControlPacket{State: StateDown, DetectMult: 3, MyDiscriminator: 7,
+		DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 300000}, now)
+	assert.Equal(t, StateInit, s.State())
+	s.handlePacket(&ControlPacket{State: StateUp, DetectMult: 3, MyDiscriminator: 7, YourDiscriminator: 42,
+		DesiredMinTxInterval: 1000000, RequiredMinRxInterval: 300000}, now)
+	assert.Equal(t, StateUp, s.State())
+	assert.ElementsMatch(t, []State{StateInit, StateUp}, []State{<-states, <-states})
+
+	p := s.controlPacket(false)
+	assert.Equal(t, uint32(7), p.YourDiscriminator)
+	assert.True(t, p.Poll, "expected a poll sequence to negotiate the faster transmit interval")
+	assert.Equal(t, uint32(300000), p.DesiredMinTxInterval)
+
+	final := s.handlePacket(&ControlPacket{State: StateUp, DetectMult: 3, MyDiscriminator: 7, YourDiscriminator: 42,
+		Final: true, DesiredMinTxInterval: 300000, RequiredMinRxInterval: 300000}, now)
+	assert.False(t, final)
+	assert.False(t, s.controlPacket(false).Poll, "expected the poll sequence to be terminated by the final bit")
+}
+
+func Test_SessionDetectionTimeExpired(t *testing.T) {
+	s, states := newTestSession()
+	now := time.Now()
+
+	s.handlePacket(&ControlPacket{State: StateInit, DetectMult: 3, MyDiscriminator: 7,
+		DesiredMinTxInterval: 300000, RequiredMinRxInterval: 300000}, now)
+	assert.Equal(t, StateUp, s.State())
+
+	s.checkDetectionTime(now.Add(800 * time.Millisecond))
+	assert.Equal(t, StateUp, s.State())
+	s.checkDetectionTime(now.Add(time.Second))
+	assert.Equal(t, StateDown, s.State())
+	assert.ElementsMatch(t, []State{StateUp, StateDown}, []State{<-states, <-states})
+
+	p := s.controlPacket(false)
+	assert.Equal(t, DiagControlDetectExpired, p.Diag)
+	assert.Equal(t, uint32(0), p.YourDiscriminator)
+	assert.Equal(t, uint32(1000000), p.DesiredMinTxInterval, "expected the slow transmit interval while down")
+}
+
+func Test_SessionNeighborSignaledDown(t *testing.T) {
+	s, states := newTestSession()
+	now := time.Now()
+
+	s.handlePacket(&ControlPacket{State: StateInit, DetectMult: 3, MyDiscriminator: 7}, now)
+	s.handlePacket(&ControlPacket{State: StateAdminDown, DetectMult: 3, MyDiscriminator: 7}, now)
+	assert.Equal(t, StateDown, s.State())
+	assert.ElementsMatch(t, []State{StateUp, StateDown}, []State{<-states, <-states})
+	assert.Equal(t, DiagNeighborSignaledDown, s.controlPacket(false).Diag)
+
+	assert.True(t, s.handlePacket(&ControlPacket{State: StateDown, DetectMult: 3, MyDiscriminator: 7, Poll: true},
+		now), "expected a final packet in response to a poll")
+}
+
+func Test_SessionTxInterval(t *testing.T) {
+	s, _ := newTestSession()
+	for i := 0; i < 100; i++ {
+		interval := s.txInterval()
+		assert.True(t, interval >= 750*time.Millisecond && interval <= time.Second,
+			"expected the slow transmit interval with jitter while down, got %s", interval)
+	}
+
+	s.state = StateUp
+	s.remoteMinRx = 500 * time.Millisecond
+	for i := 0; i < 100; i++ {
+		interval := s.txInterval()
+		assert.True(t, interval >= 375*time.Millisecond && interval <= 500*time.Millisecond,
+			"expected the required minimum receive interval of the peer with jitter, got %s", interval)
+	}
+
+	s.remoteMinRx = 0
+	s.remoteDiscr = 7
+	assert.Equal(t, time.Duration(0), s.txInterval(), "expected no periodic packets when the peer asks for none")
+}
+
+func Test_SessionSetTimers(t *testing.T) {
+	s, _ := newTestSession()
+	now := time.Now()
+	s.handlePacket(&ControlPacket{State: StateInit, DetectMult: 3, MyDiscriminator: 7, YourDiscriminator: 42,
+		DesiredMinTxInterval: 300000, RequiredMinRxInterval: 300000}, now)
+	s.handlePacket(&ControlPacket{State: StateUp, DetectMult: 3, MyDiscriminator: 7, YourDiscriminator: 42,
+		Final: true, DesiredMinTxInterval: 300000, RequiredMinRxInterval: 300000}, now)
+	assert.Equal(t, StateUp, s.State())
+
+	s.setTimers(600*time.Millisecond, 5)
+	p := s.controlPacket(false)
+	assert.True(t, p.Poll, "expected a poll sequence to negotiate the new timers")
+	assert.Equal(t, uint32(600000), p.DesiredMinTxInterval)
+	assert.Equal(t, uint32(600000), p.RequiredMinRxInterval)
+	assert.Equal(t, uint8(5), p.DetectMult)
+	assert.True(t, s.txInterval() <= 300*time.Millisecond,
+		"expected the transmit interval to only increase once the peer acknowledged it")
+
+	s.handlePacket(&ControlPacket{State: StateUp, DetectMult: 3, MyDiscriminator: 7, YourDiscriminator: 42,
+		Final: true, DesiredMinTxInterval: 300000, RequiredMinRxInterval: 300000}, now)
+	assert.False(t, s.controlPacket(false).Poll)
+	assert.True(t, s.txInterval() >= 450*time.Millisecond, "expected the increased transmit interval to be used")
+	assert.Equal(t, 150*time.Millisecond, s.detectCheckInterval())
+	assert.Equal(t, StateUp, s.State(), "expected the session to stay up across the change of its timers")
+}
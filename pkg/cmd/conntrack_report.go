@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConntrackReport prints the conntrack flows of the node aggregated per service and per pod, the ones with the most
+// bytes carried by long-lived flows first, to help find connection leaks and tune the conntrack timeouts
+func (kr *KubeRouter) ConntrackReport(w io.Writer) error {
+	node, err := utils.GetNodeObject(kr.Client, kr.Config.HostnameOverride)
+	if err != nil {
+		return err
+	}
+	nodeIP, err := utils.GetNodeIP(node)
+	if err != nil {
+		return err
+	}
+
+	// the lists are served from the watch cache of the API server, the report doesn't need them to be up to date
+	svcList, err := kr.Client.CoreV1().Services("").List(context.Background(),
+		metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return errors.New("Failed to list services: " + err.Error())
+	}
+	services := make([]*v1core.Service, 0, len(svcList.Items))
+	for i := range svcList.Items {
+		services = append(services, &svcList.Items[i])
+	}
+	podList, err := kr.Client.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return errors.New("Failed to list pods: " + err.Error())
+	}
+	pods := make([]*v1core.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+
+	flows, err := utils.ListConntrackFlows()
+	if err != nil {
+		return err
+	}
+	serviceStats, podStats := utils.AggregateConntrackFlows(flows, utils.ConntrackServiceFrontends(services, nodeIP),
+		utils.ConntrackPodIPs(pods), kr.Config.ConntrackLongLivedAge, time.Now())
+
+	fmt.Fprintf(w, "%d conntrack flows, long-lived from %s\n", len(flows), kr.Config.ConntrackLongLivedAge)
+	for _, report := range []struct {
+		kind  string
+		stats map[string]*utils.ConntrackFlowStats
+	}{{"SERVICE", serviceStats}, {"POD", podStats}} {
+		fmt.Fprintln(w)
+		printConntrackStats(w, report.kind, report.stats)
+	}
+	return nil
+}
+
+func printConntrackStats(w io.Writer, kind string, stats map[string]*utils.ConntrackFlowStats) {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if stats[names[i]].LongLivedBytes != stats[names[j]].LongLivedBytes {
+			return stats[names[i]].LongLivedBytes > stats[names[j]].LongLivedBytes
+		}
+		return names[i] < names[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tFLOWS\tLONG-LIVED FLOWS\tLONG-LIVED BYTES\tOLDEST FLOW\n", kind)
+	for _, name := range names {
+		stat := stats[name]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", name, stat.Flows, stat.LongLivedFlows, stat.LongLivedBytes,
+			stat.OldestFlowAge.Truncate(time.Second))
+	}
+	_ = tw.Flush()
+}
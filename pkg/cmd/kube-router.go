@@ -1,25 +1,38 @@
 package cmd
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	gatewayv1alpha2 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1alpha2"
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	anpv1alpha1 "github.com/cloudnativelabs/kube-router/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/lballoc"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/proxy"
 	"github.com/cloudnativelabs/kube-router/pkg/controllers/routing"
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/tracing"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/cloudnativelabs/kube-router/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/klog/v2"
 
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -27,8 +40,9 @@ const healthControllerChannelLength = 10
 
 // KubeRouter holds the information needed to run server
 type KubeRouter struct {
-	Client kubernetes.Interface
-	Config *options.KubeRouterConfig
+	Client        kubernetes.Interface
+	DynamicClient dynamic.Interface
+	Config        *options.KubeRouterConfig
 }
 
 // NewKubeRouterDefault returns a KubeRouter object
@@ -50,12 +64,27 @@ func NewKubeRouterDefault(config *options.KubeRouterConfig) (*KubeRouter, error)
 		}
 	}
 
+	// The requests of kube-router, thousands of instances of it in large clusters, are rate limited on the client side
+	// so that they stay within a predictable share of the API server, and identified by the user agent. The watches of
+	// the informers are long running requests which aren't rate limited.
+	clientconfig.QPS = config.KubeAPIQPS
+	clientconfig.Burst = config.KubeAPIBurst
+	clientconfig.UserAgent = "kube-router"
+	if version.Version != "" {
+		clientconfig.UserAgent += "/" + version.Version
+	}
+
 	clientset, err := kubernetes.NewForConfig(clientconfig)
 	if err != nil {
 		return nil, errors.New("Failed to create Kubernetes client: " + err.Error())
 	}
 
-	return &KubeRouter{Client: clientset, Config: config}, nil
+	dynamicClient, err := dynamic.NewForConfig(clientconfig)
+	if err != nil {
+		return nil, errors.New("Failed to create Kubernetes dynamic client: " + err.Error())
+	}
+
+	return &KubeRouter{Client: clientset, DynamicClient: dynamicClient, Config: config}, nil
 }
 
 // CleanupConfigAndExit performs Cleanup on all three controllers
@@ -63,6 +92,9 @@ func CleanupConfigAndExit() {
 	npc := netpol.NetworkPolicyController{}
 	npc.Cleanup()
 
+	nfc := netpol.NodeFirewallController{}
+	nfc.Cleanup()
+
 	nsc := proxy.NetworkServicesController{}
 	nsc.Cleanup()
 
@@ -81,6 +113,38 @@ func (kr *KubeRouter) Run() error {
 		os.Exit(0)
 	}
 
+	// A missing kernel module or option aborts the startup instead of failing the health check, as none of the
+	// controllers would be able to program the node, the crash loop of the pod points right at the report in its logs
+	if !kr.Config.SkipKernelModuleCheck {
+		modules := kr.requiredKernelModules()
+		if err = utils.EnsureKernelConfig(kr.requiredKernelConfig(modules)); err != nil {
+			return errors.New("Failed to validate kernel prerequisites: " + err.Error())
+		}
+		if err = utils.EnsureKernelModules(modules); err != nil {
+			return errors.New("Failed to validate kernel prerequisites: " + err.Error())
+		}
+	}
+
+	if err = kr.detectConflictingDataplaneOwners(); err != nil {
+		if !kr.Config.Force {
+			return err
+		}
+		klog.Warningf("Continuing as --force was given, even though: %s", err)
+	}
+
+	if kr.Config.TracingEndpoint != "" {
+		shutdownTracing, err := tracing.Setup(context.Background(), kr.Config.TracingEndpoint,
+			kr.Config.TracingInsecure)
+		if err != nil {
+			return errors.New("Failed to set up tracing: " + err.Error())
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				klog.Errorf("Failed to export the pending spans: %v", err)
+			}
+		}()
+	}
+
 	healthChan := make(chan *healthcheck.ControllerHeartbeat, healthControllerChannelLength)
 	defer close(healthChan)
 	stopCh := make(chan struct{})
@@ -92,6 +156,15 @@ func (kr *KubeRouter) Run() error {
 	wg.Add(1)
 	go hc.RunServer(stopCh, &wg)
 
+	if kr.Config.EnableWatchList {
+		// client-go only lets the reflectors of the informers stream their initial state through its environment,
+		// they fall back to listing when the API server doesn't support it. Watch bookmarks are always used, so that
+		// the informers resume their watches after a network blip instead of listing everything again.
+		if err = os.Setenv("ENABLE_CLIENT_GO_WATCH_LIST_ALPHA", "true"); err != nil {
+			return errors.New("Failed to enable watch lists: " + err.Error())
+		}
+	}
+
 	informerFactory := informers.NewSharedInformerFactory(kr.Client, 0)
 	svcInformer := informerFactory.Core().V1().Services().Informer()
 	epInformer := informerFactory.Core().V1().Endpoints().Informer()
@@ -99,12 +172,74 @@ func (kr *KubeRouter) Run() error {
 	nodeInformer := informerFactory.Core().V1().Nodes().Informer()
 	nsInformer := informerFactory.Core().V1().Namespaces().Informer()
 	npInformer := informerFactory.Networking().V1().NetworkPolicies().Informer()
+	informers := []cache.SharedIndexInformer{svcInformer, epInformer, podInformer}
+	// the terminating endpoints and the topology hints are only published in the EndpointSlices
+	var epSliceInformer cache.SharedIndexInformer
+	if kr.Config.RunServiceProxy && (kr.Config.IpvsTerminatingEndpoints || kr.Config.IpvsTopologyAwareRouting) {
+		epSliceInformer = informerFactory.Discovery().V1().EndpointSlices().Informer()
+		informers = append(informers, epSliceInformer)
+	}
+	for _, informer := range informers {
+		if err = informer.SetTransform(utils.StripUnusedFields); err != nil {
+			return errors.New("Failed to set informer transform: " + err.Error())
+		}
+	}
+	// the ClusterCIDR API is only served with the alpha MultiCIDRRangeAllocator, so its informer is only started when
+	// it is enabled
+	var clusterCIDRInformer cache.SharedIndexInformer
+	if kr.Config.RunRouter && kr.Config.EnableClusterCIDRs {
+		clusterCIDRInformer = informerFactory.Networking().V1alpha1().ClusterCIDRs().Informer()
+	}
+
+	// the informers of the custom resources are only started when the functionality using them is enabled, as their
+	// CRDs may not be installed otherwise
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(kr.DynamicClient, 0)
+	// the Gateway API resources are shared by the routing controller, the service proxy and the load balancer IP
+	// allocation controller
+	var gatewayClassInformer, gatewayInformer, tcpRouteInformer, udpRouteInformer cache.SharedIndexInformer
+	if kr.Config.EnableGatewayAPI {
+		gatewayClassInformer = dynamicInformerFactory.ForResource(gatewayv1.GatewayClassResource).Informer()
+		gatewayInformer = dynamicInformerFactory.ForResource(gatewayv1.GatewayResource).Informer()
+		tcpRouteInformer = dynamicInformerFactory.ForResource(gatewayv1alpha2.TCPRouteResource).Informer()
+		udpRouteInformer = dynamicInformerFactory.ForResource(gatewayv1alpha2.UDPRouteResource).Informer()
+	}
+	var bgpPeerInformer, bgpPolicyInformer, staticRouteInformer, egressIPInformer cache.SharedIndexInformer
+	if kr.Config.RunRouter && kr.Config.EnableBGPPeers {
+		bgpPeerInformer = dynamicInformerFactory.ForResource(v1alpha1.BGPPeerResource).Informer()
+	}
+	if kr.Config.RunRouter && kr.Config.EnableBGPPolicies {
+		bgpPolicyInformer = dynamicInformerFactory.ForResource(v1alpha1.BGPPolicyResource).Informer()
+	}
+	if kr.Config.RunRouter && kr.Config.EnableStaticRoutes {
+		staticRouteInformer = dynamicInformerFactory.ForResource(v1alpha1.StaticRouteResource).Informer()
+	}
+	if kr.Config.RunRouter && kr.Config.EnableEgressIPs {
+		egressIPInformer = dynamicInformerFactory.ForResource(v1alpha1.EgressIPResource).Informer()
+	}
+	// the global network policies are shared by the node firewall and the network policy controller
+	var gnpInformer, nfInformer, anpInformer, banpInformer cache.SharedIndexInformer
+	if kr.Config.EnableGlobalNetworkPolicy {
+		gnpInformer = dynamicInformerFactory.ForResource(v1alpha1.GlobalNetworkPolicyResource).Informer()
+	}
+	if kr.Config.EnableNodeFirewall {
+		nfInformer = dynamicInformerFactory.ForResource(v1alpha1.NodeFirewallResource).Informer()
+	}
+	if kr.Config.RunFirewall && kr.Config.EnableAdminNetworkPolicy {
+		anpInformer = dynamicInformerFactory.ForResource(anpv1alpha1.AdminNetworkPolicyResource).Informer()
+		banpInformer = dynamicInformerFactory.ForResource(anpv1alpha1.BaselineAdminNetworkPolicyResource).Informer()
+	}
+
 	informerFactory.Start(stopCh)
+	dynamicInformerFactory.Start(stopCh)
 
 	err = kr.CacheSyncOrTimeout(informerFactory, stopCh)
 	if err != nil {
 		return errors.New("Failed to synchronize cache: " + err.Error())
 	}
+	err = kr.waitOrTimeout(func() { dynamicInformerFactory.WaitForCacheSync(stopCh) })
+	if err != nil {
+		return errors.New("Failed to synchronize custom resource cache: " + err.Error())
+	}
 
 	hc.SetAlive()
 	wg.Add(1)
@@ -124,6 +259,20 @@ func (kr *KubeRouter) Run() error {
 		kr.Config.MetricsEnabled = false
 	}
 
+	if kr.Config.SysctlSyncPeriod <= 0 {
+		return errors.New("SysctlSyncPeriod must be positive")
+	}
+	sysctls := utils.NewSysctlManager(kr.Config.Sysctls)
+	if kr.Config.MetricsEnabled {
+		prometheus.MustRegister(metrics.ControllerSysctlDrift)
+		sysctls.DriftHandler = func(path string, _, _ int) {
+			metrics.ControllerSysctlDrift.WithLabelValues(path).Inc()
+		}
+	}
+	sysctls.Apply()
+	wg.Add(1)
+	go sysctls.Run(kr.Config.SysctlSyncPeriod, stopCh, &wg)
+
 	if kr.Config.BGPGracefulRestart {
 		if kr.Config.BGPGracefulRestartTime > time.Second*4095 {
 			return errors.New("BGPGracefulRestartTime should be less than 4095 seconds")
@@ -140,9 +289,76 @@ func (kr *KubeRouter) Run() error {
 		}
 	}
 
+	if kr.Config.BGPLongLivedGracefulRestart {
+		if !kr.Config.BGPGracefulRestart {
+			return errors.New("BGPLongLivedGracefulRestart requires BGPGracefulRestart")
+		}
+		if kr.Config.BGPLongLivedStaleTime > time.Second*16777215 {
+			return errors.New("BGPLongLivedStaleTime should be less than 16777215 seconds")
+		}
+		if kr.Config.BGPLongLivedStaleTime <= 0 {
+			return errors.New("BGPLongLivedStaleTime must be positive")
+		}
+	}
+
+	switch kr.Config.RPKIInvalidAction {
+	case options.RPKIInvalidActionReject, options.RPKIInvalidActionDepreference, options.RPKIInvalidActionAccept:
+	default:
+		return errors.New("RPKIInvalidAction must be one of " + options.RPKIInvalidActionReject + ", " +
+			options.RPKIInvalidActionDepreference + " or " + options.RPKIInvalidActionAccept)
+	}
+
+	switch kr.Config.AdvertiseVIPWeight {
+	case options.VIPWeightNone, options.VIPWeightMED, options.VIPWeightLinkBandwidth:
+	default:
+		return errors.New("AdvertiseVIPWeight must be one of " + options.VIPWeightNone + ", " +
+			options.VIPWeightMED + " or " + options.VIPWeightLinkBandwidth)
+	}
+
+	switch kr.Config.OverlayEncapsulation {
+	case options.OverlayEncapsulationIPIP, options.OverlayEncapsulationVXLAN, options.OverlayEncapsulationGeneve,
+		options.OverlayEncapsulationWireGuard:
+	default:
+		return errors.New("OverlayEncapsulation must be one of " + options.OverlayEncapsulationIPIP + ", " +
+			options.OverlayEncapsulationVXLAN + ", " + options.OverlayEncapsulationGeneve + " or " +
+			options.OverlayEncapsulationWireGuard)
+	}
+
+	if kr.Config.CNIMode != options.CNIModeBridge && kr.Config.CNIMode != options.CNIModePTP {
+		return errors.New("CNIMode must be either " + options.CNIModeBridge + " or " + options.CNIModePTP)
+	}
+
+	switch kr.Config.PodCIDRSource {
+	case options.PodCIDRSourceNode:
+	case options.PodCIDRSourceAWSMetadata, options.PodCIDRSourceGCEMetadata, options.PodCIDRSourceAnnotation:
+		if kr.Config.EnableIPPoolIPAM {
+			return errors.New("PodCIDRSource must be " + options.PodCIDRSourceNode + " when EnableIPPoolIPAM is set")
+		}
+		if kr.Config.PodCIDRSource == options.PodCIDRSourceAnnotation && kr.Config.PodCIDRSourceAnnotation == "" {
+			return errors.New("PodCIDRSourceAnnotation must be set when PodCIDRSource is " +
+				options.PodCIDRSourceAnnotation)
+		}
+		_, err = routing.PublishPodCIDRsFromSource(kr.Client, kr.Config.PodCIDRSource,
+			kr.Config.PodCIDRSourceAnnotation, kr.Config.HostnameOverride)
+		if err != nil {
+			return errors.New("Failed to publish pod CIDRs from " + kr.Config.PodCIDRSource + ": " + err.Error())
+		}
+	default:
+		return errors.New("PodCIDRSource must be one of " + options.PodCIDRSourceNode + ", " +
+			options.PodCIDRSourceAWSMetadata + ", " + options.PodCIDRSourceGCEMetadata + " or " +
+			options.PodCIDRSourceAnnotation)
+	}
+
 	if kr.Config.RunRouter {
+		if kr.Config.EnableIPPoolIPAM {
+			_, err = routing.AllocatePodCIDRsFromIPPools(kr.Client, kr.DynamicClient, kr.Config.HostnameOverride)
+			if err != nil {
+				return errors.New("Failed to allocate pod CIDRs from IPPools: " + err.Error())
+			}
+		}
+
 		nrc, err := routing.NewNetworkRoutingController(kr.Client, kr.Config,
-			nodeInformer, svcInformer, epInformer, &ipsetMutex)
+			nodeInformer, svcInformer, epInformer, &ipsetMutex, sysctls)
 		if err != nil {
 			return errors.New("Failed to create network routing controller: " + err.Error())
 		}
@@ -159,10 +375,111 @@ func (kr *KubeRouter) Run() error {
 		if err != nil {
 			return errors.New("Failed to add EndpointsEventHandler: " + err.Error())
 		}
+		if kr.Config.EnableBGPPeers {
+			nrc.EnableBGPPeerResources(bgpPeerInformer, kr.DynamicClient)
+			_, err = bgpPeerInformer.AddEventHandler(nrc.BGPPeerEventHandler)
+			if err != nil {
+				return errors.New("Failed to add BGPPeerEventHandler: " + err.Error())
+			}
+		}
+		if kr.Config.EnableBGPPolicies {
+			nrc.EnableBGPPolicyResources(bgpPolicyInformer)
+			_, err = bgpPolicyInformer.AddEventHandler(nrc.BGPPolicyEventHandler)
+			if err != nil {
+				return errors.New("Failed to add BGPPolicyEventHandler: " + err.Error())
+			}
+		}
+		if kr.Config.EnableStaticRoutes {
+			nrc.EnableStaticRouteResources(staticRouteInformer)
+			_, err = staticRouteInformer.AddEventHandler(nrc.StaticRouteEventHandler)
+			if err != nil {
+				return errors.New("Failed to add StaticRouteEventHandler: " + err.Error())
+			}
+		}
+		if kr.Config.EnableClusterCIDRs {
+			nrc.EnableClusterCIDRs(clusterCIDRInformer)
+			_, err = clusterCIDRInformer.AddEventHandler(nrc.ClusterCIDREventHandler)
+			if err != nil {
+				return errors.New("Failed to add ClusterCIDREventHandler: " + err.Error())
+			}
+		}
+		if kr.Config.EnableEgressIPs {
+			nrc.EnableEgressIPResources(egressIPInformer, podInformer, nsInformer, kr.DynamicClient)
+			_, err = egressIPInformer.AddEventHandler(nrc.EgressIPEventHandler)
+			if err != nil {
+				return errors.New("Failed to add EgressIPEventHandler: " + err.Error())
+			}
+			_, err = podInformer.AddEventHandler(nrc.EgressIPPodEventHandler)
+			if err != nil {
+				return errors.New("Failed to add EgressIPPodEventHandler: " + err.Error())
+			}
+			_, err = nsInformer.AddEventHandler(nrc.EgressIPNamespaceEventHandler)
+			if err != nil {
+				return errors.New("Failed to add EgressIPNamespaceEventHandler: " + err.Error())
+			}
+		}
+		if kr.Config.EnableGatewayAPI {
+			nrc.EnableGatewayAddresses(gatewayClassInformer, gatewayInformer)
+			for _, informer := range []cache.SharedIndexInformer{gatewayClassInformer, gatewayInformer} {
+				_, err = informer.AddEventHandler(nrc.GatewayEventHandler)
+				if err != nil {
+					return errors.New("Failed to add GatewayEventHandler: " + err.Error())
+				}
+			}
+		}
 
 		wg.Add(1)
 		go nrc.Run(healthChan, stopCh, &wg)
 
+		if kr.Config.EnableCNI && kr.Config.CNIMode == options.CNIModeBridge {
+			nbc, err := routing.NewNamespaceBandwidthController(kr.Client, kr.Config, podInformer, nsInformer)
+			if err != nil {
+				return errors.New("Failed to create namespace bandwidth controller: " + err.Error())
+			}
+
+			_, err = podInformer.AddEventHandler(nbc.PodEventHandler)
+			if err != nil {
+				return errors.New("Failed to add PodEventHandler: " + err.Error())
+			}
+			_, err = nsInformer.AddEventHandler(nbc.NamespaceEventHandler)
+			if err != nil {
+				return errors.New("Failed to add NamespaceEventHandler: " + err.Error())
+			}
+
+			wg.Add(1)
+			go nbc.Run(stopCh, &wg)
+
+			if kr.Config.BridgeHairpinMode {
+				hpc, err := routing.NewHairpinController(kr.Config, podInformer)
+				if err != nil {
+					return errors.New("Failed to create hairpin mode controller: " + err.Error())
+				}
+
+				_, err = podInformer.AddEventHandler(hpc.PodEventHandler)
+				if err != nil {
+					return errors.New("Failed to add PodEventHandler: " + err.Error())
+				}
+
+				wg.Add(1)
+				go hpc.Run(stopCh, &wg)
+			}
+		}
+
+		if kr.Config.EnablePodRoutes {
+			prc, err := routing.NewPodRoutesController(kr.Client, kr.Config, podInformer)
+			if err != nil {
+				return errors.New("Failed to create pod routes controller: " + err.Error())
+			}
+
+			_, err = podInformer.AddEventHandler(prc.PodEventHandler)
+			if err != nil {
+				return errors.New("Failed to add PodEventHandler: " + err.Error())
+			}
+
+			wg.Add(1)
+			go prc.Run(stopCh, &wg)
+		}
+
 		// wait for the pod networking related firewall rules to be setup before network policies
 		if kr.Config.RunFirewall {
 			nrc.CNIFirewallSetup.L.Lock()
@@ -173,7 +490,7 @@ func (kr *KubeRouter) Run() error {
 
 	if kr.Config.RunServiceProxy {
 		nsc, err := proxy.NewNetworkServicesController(kr.Client, kr.Config,
-			svcInformer, epInformer, podInformer, &ipsetMutex)
+			svcInformer, epInformer, podInformer, &ipsetMutex, sysctls)
 		if err != nil {
 			return errors.New("Failed to create network services controller: " + err.Error())
 		}
@@ -186,6 +503,32 @@ func (kr *KubeRouter) Run() error {
 		if err != nil {
 			return errors.New("Failed to add EndpointsEventHandler: " + err.Error())
 		}
+		_, err = nodeInformer.AddEventHandler(nsc.NodeEventHandler)
+		if err != nil {
+			return errors.New("Failed to add NodeEventHandler: " + err.Error())
+		}
+		if kr.Config.IpvsTerminatingEndpoints {
+			nsc.EnableTerminatingEndpoints(epSliceInformer)
+		}
+		if kr.Config.IpvsTopologyAwareRouting {
+			nsc.EnableTopologyAwareRouting(epSliceInformer)
+		}
+		if epSliceInformer != nil {
+			_, err = epSliceInformer.AddEventHandler(nsc.EndpointSliceEventHandler)
+			if err != nil {
+				return errors.New("Failed to add EndpointSliceEventHandler: " + err.Error())
+			}
+		}
+		if kr.Config.EnableGatewayAPI {
+			nsc.EnableGatewayAPI(gatewayClassInformer, gatewayInformer, tcpRouteInformer, udpRouteInformer)
+			for _, informer := range []cache.SharedIndexInformer{gatewayClassInformer, gatewayInformer,
+				tcpRouteInformer, udpRouteInformer} {
+				_, err = informer.AddEventHandler(nsc.GatewayEventHandler)
+				if err != nil {
+					return errors.New("Failed to add GatewayEventHandler: " + err.Error())
+				}
+			}
+		}
 
 		wg.Add(1)
 		go nsc.Run(healthChan, stopCh, &wg)
@@ -198,6 +541,58 @@ func (kr *KubeRouter) Run() error {
 		}
 	}
 
+	if kr.Config.RunLoadBalancer {
+		lbc, err := lballoc.NewLoadBalancerController(kr.Client, kr.Config, svcInformer)
+		if err != nil {
+			return errors.New("Failed to create load balancer IP allocation controller: " + err.Error())
+		}
+
+		_, err = svcInformer.AddEventHandler(lbc.ServiceEventHandler)
+		if err != nil {
+			return errors.New("Failed to add ServiceEventHandler: " + err.Error())
+		}
+		if kr.Config.EnableGatewayAPI {
+			lbc.EnableGateways(gatewayClassInformer, gatewayInformer, tcpRouteInformer, udpRouteInformer,
+				kr.DynamicClient)
+			for _, informer := range []cache.SharedIndexInformer{gatewayClassInformer, gatewayInformer,
+				tcpRouteInformer, udpRouteInformer} {
+				_, err = informer.AddEventHandler(lbc.GatewayEventHandler)
+				if err != nil {
+					return errors.New("Failed to add GatewayEventHandler: " + err.Error())
+				}
+			}
+		}
+
+		wg.Add(1)
+		go lbc.Run(stopCh, &wg)
+	}
+
+	if kr.Config.EnableNodeFirewall {
+		nfc, err := netpol.NewNodeFirewallController(kr.Client, kr.Config, nodeInformer, nfInformer, &ipsetMutex)
+		if err != nil {
+			return errors.New("Failed to create node firewall controller: " + err.Error())
+		}
+
+		_, err = nodeInformer.AddEventHandler(nfc.NodeEventHandler)
+		if err != nil {
+			return errors.New("Failed to add NodeEventHandler: " + err.Error())
+		}
+		_, err = nfInformer.AddEventHandler(nfc.NodeFirewallEventHandler)
+		if err != nil {
+			return errors.New("Failed to add NodeFirewallEventHandler: " + err.Error())
+		}
+		if gnpInformer != nil {
+			nfc.EnableGlobalNetworkPolicies(gnpInformer)
+			_, err = gnpInformer.AddEventHandler(nfc.GlobalNetworkPolicyEventHandler)
+			if err != nil {
+				return errors.New("Failed to add GlobalNetworkPolicyEventHandler: " + err.Error())
+			}
+		}
+
+		wg.Add(1)
+		go nfc.Run(stopCh, &wg)
+	}
+
 	if kr.Config.RunFirewall {
 		npc, err := netpol.NewNetworkPolicyController(kr.Client,
 			kr.Config, podInformer, npInformer, nsInformer, &ipsetMutex)
@@ -217,6 +612,39 @@ func (kr *KubeRouter) Run() error {
 		if err != nil {
 			return errors.New("Failed to add NetworkPolicyEventHandler: " + err.Error())
 		}
+		_, err = nodeInformer.AddEventHandler(npc.NodeEventHandler)
+		if err != nil {
+			return errors.New("Failed to add NodeEventHandler: " + err.Error())
+		}
+
+		if kr.Config.EnableAdminNetworkPolicy {
+			npc.EnableAdminNetworkPolicies(anpInformer, banpInformer, nodeInformer)
+			for _, informer := range []cache.SharedIndexInformer{anpInformer, banpInformer} {
+				_, err = informer.AddEventHandler(npc.AdminNetworkPolicyEventHandler)
+				if err != nil {
+					return errors.New("Failed to add AdminNetworkPolicyEventHandler: " + err.Error())
+				}
+			}
+		}
+		if gnpInformer != nil {
+			npc.EnableGlobalNetworkPolicies(gnpInformer, nodeInformer)
+			_, err = gnpInformer.AddEventHandler(npc.GlobalNetworkPolicyEventHandler)
+			if err != nil {
+				return errors.New("Failed to add GlobalNetworkPolicyEventHandler: " + err.Error())
+			}
+		}
+		if kr.Config.EnableNetpolStatus {
+			npc.EnablePolicyStatus(kr.DynamicClient)
+		}
+		// the flows are simulated on the health check server, which serves the default mux
+		http.HandleFunc(netpol.PolicySimulationPath, npc.ServeFlowSimulation)
+		// the nodes resolve the peers of both the admin and the global network policies
+		if npc.AdminNetworkPolicyNodeEventHandler != nil {
+			_, err = nodeInformer.AddEventHandler(npc.AdminNetworkPolicyNodeEventHandler)
+			if err != nil {
+				return errors.New("Failed to add AdminNetworkPolicyNodeEventHandler: " + err.Error())
+			}
+		}
 
 		wg.Add(1)
 		go npc.Run(healthChan, stopCh, &wg)
@@ -237,9 +665,14 @@ func (kr *KubeRouter) Run() error {
 // CacheSyncOrTimeout performs cache synchronization under timeout limit
 func (kr *KubeRouter) CacheSyncOrTimeout(informerFactory informers.SharedInformerFactory,
 	stopCh <-chan struct{}) error {
+	return kr.waitOrTimeout(func() { informerFactory.WaitForCacheSync(stopCh) })
+}
+
+// waitOrTimeout runs the given cache synchronization under the cache sync timeout limit
+func (kr *KubeRouter) waitOrTimeout(waitForCacheSync func()) error {
 	syncOverCh := make(chan struct{})
 	go func() {
-		informerFactory.WaitForCacheSync(stopCh)
+		waitForCacheSync()
 		close(syncOverCh)
 	}()
 
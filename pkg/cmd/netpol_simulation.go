@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/controllers/netpol"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+)
+
+const netpolSimulationTimeout = 10 * time.Second
+
+// SimulateFlow asks the network policy controller of the kube-router running on the node whether the network policies
+// allow the flow of --netpol-simulate, and prints its verdict along with the decisions of the firewall chains of both
+// pods
+func SimulateFlow(w io.Writer, config *options.KubeRouterConfig) error {
+	if config.HealthPort == 0 {
+		return errors.New("--netpol-simulate needs the health check server of kube-router, --health-port is 0")
+	}
+	fields := strings.Split(config.NetpolSimulate, ",")
+	if len(fields) != 3 {
+		return fmt.Errorf("--netpol-simulate must be <source pod>,<destination pod or IP>,<protocol>[/<port>], "+
+			"got %q", config.NetpolSimulate)
+	}
+	query := url.Values{}
+	query.Set("source", strings.TrimSpace(fields[0]))
+	query.Set("destination", strings.TrimSpace(fields[1]))
+	protocol, port, hasPort := strings.Cut(strings.TrimSpace(fields[2]), "/")
+	query.Set("protocol", protocol)
+	if hasPort {
+		query.Set("port", port)
+	}
+
+	client := &http.Client{Timeout: netpolSimulationTimeout}
+	resp, err := client.Get("http://127.0.0.1:" + strconv.Itoa(int(config.HealthPort)) +
+		netpol.PolicySimulationPath + "?" + query.Encode())
+	if err != nil {
+		return errors.New("Failed to simulate the flow: " + err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.New("Failed to simulate the flow: " + err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to simulate the flow: %s", strings.TrimSpace(string(body)))
+	}
+	var simulation netpol.FlowSimulation
+	if err := json.Unmarshal(body, &simulation); err != nil {
+		return errors.New("Failed to parse the flow simulation: " + err.Error())
+	}
+
+	printFlowSimulation(w, &simulation)
+	return nil
+}
+
+func printFlowSimulation(w io.Writer, simulation *netpol.FlowSimulation) {
+	service := simulation.Protocol
+	if simulation.Port != 0 {
+		service += "/" + strconv.Itoa(simulation.Port)
+	}
+	destination := simulation.Destination
+	if destination != simulation.DestinationIP {
+		destination += " (" + simulation.DestinationIP + ")"
+	}
+	fmt.Fprintf(w, "%s (%s) -> %s %s: %s\n", simulation.Source, simulation.SourceIP, destination, service,
+		flowVerdict(simulation.Allowed, ""))
+	fmt.Fprintf(w, "  egress:  %s, %s\n", flowVerdict(simulation.Egress.Allowed, simulation.Egress.Verdict),
+		simulation.Egress.Reason)
+	if simulation.Ingress != nil {
+		fmt.Fprintf(w, "  ingress: %s, %s\n", flowVerdict(simulation.Ingress.Allowed, simulation.Ingress.Verdict),
+			simulation.Ingress.Reason)
+	}
+}
+
+func flowVerdict(allowed bool, verdict string) string {
+	switch {
+	case allowed:
+		return "allowed"
+	case verdict != "":
+		return "denied (" + verdict + ")"
+	}
+	return "denied"
+}
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/vishvananda/netlink"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// requiredKernelModules returns the kernel modules needed by the functionality enabled in the configuration
+func (kr *KubeRouter) requiredKernelModules() []utils.KernelModule {
+	modules := make([]utils.KernelModule, 0)
+
+	if kr.Config.RunServiceProxy || kr.Config.RunFirewall || kr.Config.RunRouter {
+		modules = append(modules,
+			utils.KernelModule{Name: "nf_conntrack", Required: true, Reason: "stateful iptables rules",
+				Config: "CONFIG_NF_CONNTRACK"},
+			utils.KernelModule{Name: "ip_set", Required: true, Reason: "ipsets", Config: "CONFIG_IP_SET"},
+			utils.KernelModule{Name: "xt_set", Required: true, Reason: "iptables ipset matches",
+				Config: "CONFIG_NETFILTER_XT_SET"},
+		)
+	}
+
+	if kr.Config.RunServiceProxy {
+		modules = append(modules,
+			utils.KernelModule{Name: "ip_vs", Required: true, Reason: "the IPVS service proxy", Config: "CONFIG_IP_VS"},
+			utils.KernelModule{Name: "ip_vs_rr", Required: true, Reason: "the default IPVS round-robin scheduler",
+				Config: "CONFIG_IP_VS_RR"},
+			utils.KernelModule{Name: "ip_vs_wrr", Reason: "the weighted round-robin IPVS scheduler",
+				Config: "CONFIG_IP_VS_WRR"},
+			utils.KernelModule{Name: "ip_vs_lc", Reason: "the least-connection IPVS scheduler",
+				Config: "CONFIG_IP_VS_LC"},
+			utils.KernelModule{Name: "ip_vs_wlc", Reason: "the weighted least-connection IPVS scheduler",
+				Config: "CONFIG_IP_VS_WLC"},
+			utils.KernelModule{Name: "ip_vs_dh", Reason: "the destination-hashing IPVS scheduler",
+				Config: "CONFIG_IP_VS_DH"},
+			utils.KernelModule{Name: "ip_vs_sh", Reason: "the source-hashing IPVS scheduler",
+				Config: "CONFIG_IP_VS_SH"},
+			utils.KernelModule{Name: "ip_vs_mh", Reason: "the Maglev hashing IPVS scheduler",
+				Config: "CONFIG_IP_VS_MH"},
+			utils.KernelModule{Name: "sctp", Reason: "SCTP services", Config: "CONFIG_IP_SCTP"},
+			utils.KernelModule{Name: "xt_sctp", Reason: "DSR for SCTP services",
+				Config: "CONFIG_NETFILTER_XT_MATCH_SCTP"},
+		)
+	}
+
+	if kr.Config.RunRouter {
+		if kr.Config.EnableCNI && kr.Config.CNIMode == options.CNIModeBridge {
+			modules = append(modules,
+				utils.KernelModule{Name: "br_netfilter", Required: true, Reason: "filtering of bridged pod traffic",
+					Config: "CONFIG_BRIDGE_NETFILTER"})
+		}
+		switch {
+		case kr.Config.EnableOverlay && kr.Config.OverlayEncapsulation == options.OverlayEncapsulationWireGuard:
+			modules = append(modules,
+				utils.KernelModule{Name: "wireguard", Required: true, Reason: "the WireGuard overlay network",
+					Config: "CONFIG_WIREGUARD"})
+		case kr.Config.EnableOverlay && kr.Config.OverlayEncapsulation == options.OverlayEncapsulationVXLAN:
+			modules = append(modules,
+				utils.KernelModule{Name: "vxlan", Required: true, Reason: "the VXLAN overlay network",
+					Config: "CONFIG_VXLAN"})
+		case kr.Config.EnableOverlay && kr.Config.OverlayEncapsulation == options.OverlayEncapsulationGeneve:
+			modules = append(modules,
+				utils.KernelModule{Name: "geneve", Required: true, Reason: "the Geneve overlay network",
+					Config: "CONFIG_GENEVE"})
+		case kr.Config.EnableOverlay:
+			modules = append(modules,
+				utils.KernelModule{Name: "ipip", Required: true, Reason: "the IP-in-IP overlay network",
+					Config: "CONFIG_NET_IPIP"})
+		}
+	}
+
+	if kr.Config.RunFirewall {
+		if kr.Config.NetpolBridgeMode != options.NetpolBridgeModeOff {
+			modules = append(modules,
+				utils.KernelModule{Name: "xt_physdev", Reason: "network policy for pods on the same bridge",
+					Config: "CONFIG_NETFILTER_XT_MATCH_PHYSDEV"})
+		}
+		modules = append(modules,
+			utils.KernelModule{Name: "nfnetlink_log", Reason: "logging of traffic dropped by network policy",
+				Config: "CONFIG_NETFILTER_NETLINK_LOG"},
+			utils.KernelModule{Name: "xt_sctp", Reason: "network policy ports of SCTP",
+				Config: "CONFIG_NETFILTER_XT_MATCH_SCTP"},
+		)
+	}
+
+	return modules
+}
+
+// requiredKernelConfig returns the options of the kernel configuration needed by the functionality enabled in the
+// configuration: the options building the required modules, and the ones that don't build a module of their own
+func (kr *KubeRouter) requiredKernelConfig(modules []utils.KernelModule) []utils.KernelConfigOption {
+	configOptions := make([]utils.KernelConfigOption, 0, len(modules))
+	seen := make(map[string]bool)
+	for _, module := range modules {
+		if module.Config == "" || seen[module.Config] {
+			continue
+		}
+		seen[module.Config] = true
+		configOptions = append(configOptions,
+			utils.KernelConfigOption{Name: module.Config, Required: module.Required, Reason: module.Reason})
+	}
+
+	if kr.Config.RunServiceProxy {
+		configOptions = append(configOptions, utils.KernelConfigOption{Name: "CONFIG_IP_VS_NFCT", Required: true,
+			Reason: "the connection tracking of the IPVS service proxy"})
+	}
+
+	return configOptions
+}
+
+// dataplaneOwner describes another component that programs the same parts of the host's dataplane as kube-router
+type dataplaneOwner struct {
+	name string
+	// chain prefixes, per iptables table, which identify the component
+	chainPrefixes map[string][]string
+	// interfaces which identify the component
+	interfaces []string
+	// name prefixes of the pods which run the component, the traces of a component none of whose pods run on the node
+	// are considered to be left over from its removal
+	pods []string
+	// hint on how to resolve the conflict
+	resolution string
+}
+
+var (
+	// serviceProxyOwners are the components that conflict with kube-router's service proxy
+	serviceProxyOwners = []dataplaneOwner{
+		{
+			name: "kube-proxy",
+			chainPrefixes: map[string][]string{
+				"nat":    {"KUBE-SERVICES", "KUBE-NODEPORTS", "KUBE-SVC-", "KUBE-SEP-"},
+				"mangle": {"KUBE-PROXY-CANARY"},
+			},
+			interfaces: []string{"kube-ipvs0"},
+			pods:       []string{"kube-proxy"},
+			resolution: "remove the kube-proxy DaemonSet and run `kube-proxy --cleanup` on the node",
+		},
+		{
+			name: "Cilium kube-proxy replacement",
+			chainPrefixes: map[string][]string{
+				"nat": {"CILIUM_"},
+			},
+			interfaces: []string{"cilium_host"},
+			pods:       []string{"cilium-"},
+			resolution: "disable Cilium or run kube-router with --run-service-proxy=false",
+		},
+	}
+	// networkPolicyOwners are the components that conflict with kube-router's network policy controller
+	networkPolicyOwners = []dataplaneOwner{
+		{
+			name: "Calico",
+			chainPrefixes: map[string][]string{
+				"filter": {"cali-"},
+			},
+			pods:       []string{"calico-node"},
+			resolution: "disable Calico policy enforcement or run kube-router with --run-firewall=false",
+		},
+		{
+			name: "Cilium",
+			chainPrefixes: map[string][]string{
+				"filter": {"CILIUM_"},
+			},
+			pods:       []string{"cilium-"},
+			resolution: "disable Cilium or run kube-router with --run-firewall=false",
+		},
+		{
+			name: "Weave Net",
+			chainPrefixes: map[string][]string{
+				"filter": {"WEAVE-NPC"},
+			},
+			pods:       []string{"weave-net"},
+			resolution: "remove the weave-npc container or run kube-router with --run-firewall=false",
+		},
+		{
+			name: "Antrea",
+			chainPrefixes: map[string][]string{
+				"filter": {"ANTREA-"},
+			},
+			pods:       []string{"antrea-agent"},
+			resolution: "disable Antrea or run kube-router with --run-firewall=false",
+		},
+	}
+)
+
+// iptablesFamilies names the iptables command of each protocol in the descriptions of the conflicts
+var iptablesFamilies = map[iptables.Protocol]string{
+	iptables.ProtocolIPv4: "iptables",
+	iptables.ProtocolIPv6: "ip6tables",
+}
+
+// findDataplaneOwnerConflicts returns a description of each of the given owners that have left a trace in the passed
+// iptables chains (keyed by protocol and table) or interfaces. The owners one of whose pods is in the passed pod names
+// are returned as conflicts, the others as leftovers of their removal.
+func findDataplaneOwnerConflicts(owners []dataplaneOwner, chains map[iptables.Protocol]map[string][]string,
+	interfaces map[string]bool, pods []string) ([]string, []string) {
+	conflicts := make([]string, 0)
+	leftovers := make([]string, 0)
+	for _, owner := range owners {
+		evidence := make([]string, 0)
+		for protocol, tables := range chains {
+			for table, prefixes := range owner.chainPrefixes {
+				for _, chain := range tables[table] {
+					for _, prefix := range prefixes {
+						if strings.HasPrefix(chain, prefix) {
+							evidence = append(evidence, fmt.Sprintf("chain %s in %s %s table", chain,
+								iptablesFamilies[protocol], table))
+							break
+						}
+					}
+				}
+			}
+		}
+		for _, iface := range owner.interfaces {
+			if interfaces[iface] {
+				evidence = append(evidence, fmt.Sprintf("interface %s", iface))
+			}
+		}
+		if len(evidence) == 0 {
+			continue
+		}
+		sort.Strings(evidence)
+		if ownerIsRunning(owner, pods) {
+			conflicts = append(conflicts, fmt.Sprintf("%s appears to be managing this node (found %s), to "+
+				"resolve this %s", owner.name, strings.Join(evidence, ", "), owner.resolution))
+		} else {
+			leftovers = append(leftovers, fmt.Sprintf("%s appears to have left %s behind, but none of its pods run "+
+				"on this node", owner.name, strings.Join(evidence, ", ")))
+		}
+	}
+	return conflicts, leftovers
+}
+
+// ownerIsRunning returns whether one of the pods is a pod of the owner
+func ownerIsRunning(owner dataplaneOwner, pods []string) bool {
+	for _, pod := range pods {
+		for _, prefix := range owner.pods {
+			if strings.HasPrefix(pod, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detectConflictingDataplaneOwners looks for other components (like kube-proxy or another network policy controller)
+// which would program rules that interleave with kube-router's own, and returns an error describing those which still
+// run on the node. The traces left behind by the components that have been removed are only logged.
+func (kr *KubeRouter) detectConflictingDataplaneOwners() error {
+	owners := make([]dataplaneOwner, 0)
+	if kr.Config.RunServiceProxy {
+		owners = append(owners, serviceProxyOwners...)
+	}
+	if kr.Config.RunFirewall {
+		owners = append(owners, networkPolicyOwners...)
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+
+	protocols := make([]iptables.Protocol, 0, 2)
+	if kr.Config.EnableIPv4 {
+		protocols = append(protocols, iptables.ProtocolIPv4)
+	}
+	if kr.Config.EnableIPv6 {
+		protocols = append(protocols, iptables.ProtocolIPv6)
+	}
+	chains := make(map[iptables.Protocol]map[string][]string)
+	for _, protocol := range protocols {
+		iptablesCmdHandler, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			return fmt.Errorf("failed to initialize %s executor: %s", iptablesFamilies[protocol], err)
+		}
+		chains[protocol] = make(map[string][]string)
+		for _, table := range []string{"filter", "nat", "mangle"} {
+			tableChains, err := iptablesCmdHandler.ListChains(table)
+			if err != nil {
+				return fmt.Errorf("failed to list chains in %s %s table: %s", iptablesFamilies[protocol], table, err)
+			}
+			chains[protocol][table] = tableChains
+		}
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list interfaces: %s", err)
+	}
+	interfaces := make(map[string]bool)
+	for _, link := range links {
+		interfaces[link.Attrs().Name] = true
+	}
+
+	pods, err := kr.nodePodNames()
+	if err != nil {
+		return fmt.Errorf("failed to list the pods of the node to check for conflicting components: %s, run "+
+			"kube-router with --force to skip the check", err)
+	}
+
+	conflicts, leftovers := findDataplaneOwnerConflicts(owners, chains, interfaces, pods)
+	for _, leftover := range leftovers {
+		klog.Warningf("Ignoring the traces of a removed component: %s", leftover)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("refusing to start as the resulting rules would conflict, either resolve the following "+
+			"or run kube-router with --force: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// nodePodNames returns the names of the pods scheduled on the node which haven't terminated
+func (kr *KubeRouter) nodePodNames() ([]string, error) {
+	node, err := utils.GetNodeObject(kr.Client, kr.Config.HostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+	podList, err := kr.Client.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(),
+		metav1.ListOptions{FieldSelector: "spec.nodeName=" + node.Name})
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]string, 0, len(podList.Items))
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == v1core.PodSucceeded || pod.Status.Phase == v1core.PodFailed {
+			continue
+		}
+		pods = append(pods, pod.Name)
+	}
+	return pods, nil
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_findDataplaneOwnerConflicts(t *testing.T) {
+	owners := append(append([]dataplaneOwner{}, serviceProxyOwners...), networkPolicyOwners...)
+
+	testcases := []struct {
+		name       string
+		chains     map[iptables.Protocol]map[string][]string
+		interfaces map[string]bool
+		pods       []string
+		conflicts  []string
+		leftovers  []string
+	}{
+		{
+			name: "clean host",
+			chains: map[iptables.Protocol]map[string][]string{
+				iptables.ProtocolIPv4: {"filter": {"INPUT", "FORWARD", "OUTPUT", "KUBE-ROUTER-FORWARD"},
+					"nat": {"PREROUTING", "KUBE-ROUTER-HAIRPIN"}},
+				iptables.ProtocolIPv6: {"filter": {"INPUT", "FORWARD", "OUTPUT"}},
+			},
+			interfaces: map[string]bool{"lo": true, "eth0": true, "kube-bridge": true},
+			pods:       []string{"kube-router-abcde", "coredns-5d78c9869d-x2b7k"},
+			conflicts:  []string{},
+			leftovers:  []string{},
+		},
+		{
+			name: "running kube-proxy",
+			chains: map[iptables.Protocol]map[string][]string{
+				iptables.ProtocolIPv4: {"nat": {"PREROUTING", "KUBE-SERVICES", "KUBE-SVC-TCOU7JCQXEZGVUNU"},
+					"mangle": {"KUBE-PROXY-CANARY"}},
+			},
+			interfaces: map[string]bool{"kube-ipvs0": true},
+			pods:       []string{"kube-proxy-7xq2m", "kube-router-abcde"},
+			conflicts: []string{"kube-proxy appears to be managing this node (found chain KUBE-PROXY-CANARY in " +
+				"iptables mangle table, chain KUBE-SERVICES in iptables nat table, chain KUBE-SVC-TCOU7JCQXEZGVUNU " +
+				"in iptables nat table, interface kube-ipvs0), to resolve this remove the kube-proxy DaemonSet and " +
+				"run `kube-proxy --cleanup` on the node"},
+			leftovers: []string{},
+		},
+		{
+			name: "stale kube-proxy chains",
+			chains: map[iptables.Protocol]map[string][]string{
+				iptables.ProtocolIPv4: {"nat": {"PREROUTING", "KUBE-SERVICES"}},
+			},
+			pods:      []string{"kube-router-abcde"},
+			conflicts: []string{},
+			leftovers: []string{"kube-proxy appears to have left chain KUBE-SERVICES in iptables nat table behind, " +
+				"but none of its pods run on this node"},
+		},
+		{
+			name: "kube-proxy chains in ip6tables",
+			chains: map[iptables.Protocol]map[string][]string{
+				iptables.ProtocolIPv4: {"nat": {"PREROUTING"}},
+				iptables.ProtocolIPv6: {"nat": {"PREROUTING", "KUBE-NODEPORTS"}},
+			},
+			pods: []string{"kube-proxy-7xq2m"},
+			conflicts: []string{"kube-proxy appears to be managing this node (found chain KUBE-NODEPORTS in " +
+				"ip6tables nat table), to resolve this remove the kube-proxy DaemonSet and run `kube-proxy --cleanup` " +
+				"on the node"},
+			leftovers: []string{},
+		},
+		{
+			name: "Cilium and Calico chains",
+			chains: map[iptables.Protocol]map[string][]string{
+				iptables.ProtocolIPv4: {"filter": {"FORWARD", "cali-FORWARD", "CILIUM_FORWARD"},
+					"nat": {"CILIUM_POST_nat"}},
+			},
+			interfaces: map[string]bool{"cilium_host": true},
+			pods:       []string{"cilium-8kq4z", "kube-router-abcde"},
+			conflicts: []string{"Cilium kube-proxy replacement appears to be managing this node (found chain " +
+				"CILIUM_POST_nat in iptables nat table, interface cilium_host), to resolve this disable Cilium or " +
+				"run kube-router with --run-service-proxy=false",
+				"Cilium appears to be managing this node (found chain CILIUM_FORWARD in iptables filter table), to " +
+					"resolve this disable Cilium or run kube-router with --run-firewall=false"},
+			leftovers: []string{"Calico appears to have left chain cali-FORWARD in iptables filter table behind, " +
+				"but none of its pods run on this node"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			conflicts, leftovers := findDataplaneOwnerConflicts(owners, testcase.chains, testcase.interfaces,
+				testcase.pods)
+			assert.Equal(t, testcase.conflicts, conflicts)
+			assert.Equal(t, testcase.leftovers, leftovers)
+		})
+	}
+}
+
+func Test_requiredKernelConfig(t *testing.T) {
+	kr := &KubeRouter{Config: options.NewKubeRouterConfig()}
+	kr.Config.RunServiceProxy = true
+	kr.Config.RunFirewall = true
+
+	configOptions := kr.requiredKernelConfig(kr.requiredKernelModules())
+	required := make(map[string]bool)
+	for _, option := range configOptions {
+		if _, duplicate := required[option.Name]; duplicate {
+			t.Errorf("expected kernel option %s to only be checked once", option.Name)
+		}
+		required[option.Name] = option.Required
+	}
+	for name, expected := range map[string]bool{"CONFIG_IP_SET": true, "CONFIG_NETFILTER_XT_SET": true,
+		"CONFIG_IP_VS": true, "CONFIG_IP_VS_NFCT": true, "CONFIG_IP_VS_MH": false,
+		"CONFIG_NETFILTER_XT_MATCH_SCTP": false} {
+		if actual, ok := required[name]; !ok || actual != expected {
+			t.Errorf("expected kernel option %s to be checked with required %t, got %t (checked: %t)", name,
+				expected, actual, ok)
+		}
+	}
+}
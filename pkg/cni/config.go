@@ -0,0 +1,372 @@
+// Package cni manages the CNI conf file that kube-router's pod networking is configured with
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PluginTypeBridge is the CNI plugin which attaches pods to a Linux bridge
+	PluginTypeBridge = "bridge"
+	// PluginTypePTP is the CNI plugin which gives each pod a veth with host routes
+	PluginTypePTP = "ptp"
+
+	// settleTime is how long to wait for writes to the CNI conf file to stop before reconciling it, so that we do not
+	// read a file that another agent is still in the middle of writing
+	settleTime = time.Second
+)
+
+// bridgeOnlyKeys are the settings of the CNI bridge plugin which the ptp plugin does not know about
+var bridgeOnlyKeys = []string{"bridge", "isGateway", "isDefaultGateway", "forceAddress", "hairpinMode",
+	"promiscMode", "vlan"}
+
+// Values are the cluster and node specific values that the CNI conf is rendered with
+type Values struct {
+	// PluginType of the plugin that sets up the pod interface, either PluginTypeBridge or PluginTypePTP
+	PluginType string
+	// BridgeName is the bridge pods are attached to by the bridge plugin
+	BridgeName string
+	// MTU of the pod interfaces, 0 leaves the MTU to the plugin
+	MTU int
+	// PodCIDRs of the node that the host-local IPAM allocates from
+	PodCIDRs []string
+	// ChainedPlugins are the configurations of the plugins chained after the pod interface plugin
+	ChainedPlugins []map[string]interface{}
+}
+
+// IPAMRanges returns the pod CIDRs as host-local IPAM range sets, with one range set per address family, so that a pod
+// gets an address of each family from whichever of the CIDRs still has room
+func (v Values) IPAMRanges() ([][]map[string]string, error) {
+	v4Ranges := make([]map[string]string, 0)
+	v6Ranges := make([]map[string]string, 0)
+	for _, cidr := range v.PodCIDRs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pod CIDR %s: %v", cidr, err)
+		}
+		if ip.To4() != nil {
+			v4Ranges = append(v4Ranges, map[string]string{"subnet": cidr})
+		} else {
+			v6Ranges = append(v6Ranges, map[string]string{"subnet": cidr})
+		}
+	}
+	rangeSets := make([][]map[string]string, 0)
+	for _, rangeSet := range [][]map[string]string{v4Ranges, v6Ranges} {
+		if len(rangeSet) > 0 {
+			rangeSets = append(rangeSets, rangeSet)
+		}
+	}
+	return rangeSets, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"toJson": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+}
+
+// ConfigManager keeps the CNI conf file in line with the values kube-router wants it to have. When a template is given
+// the whole file is rendered from it, otherwise the existing file (usually installed by the install-cni init container)
+// is patched in place. The file is watched for modifications by other agents, which are repaired.
+type ConfigManager struct {
+	path     string
+	template *template.Template
+	values   func() (Values, error)
+	mu       sync.Mutex
+	// synced is the content of the CNI conf file as of the last sync, nil until the first sync
+	synced []byte
+	// DriftHandler, if set, is called whenever the CNI conf file had to be repaired after it was modified externally
+	DriftHandler func(path string)
+}
+
+// NewConfigManager returns a ConfigManager for the CNI conf file at path. The conf is rendered from the Go template
+// found at templatePath, unless it is empty. values is called on every sync to get the current values.
+func NewConfigManager(path, templatePath string, values func() (Values, error)) (*ConfigManager, error) {
+	cm := &ConfigManager{path: path, values: values}
+	if templatePath != "" {
+		tmpl, err := template.New(filepath.Base(templatePath)).Funcs(templateFuncs).Option("missingkey=error").
+			ParseFiles(templatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CNI conf template %s: %v", templatePath, err)
+		}
+		cm.template = tmpl
+	} else if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("CNI conf file %s does not exist and no template was given to render it from", path)
+	}
+	return cm, nil
+}
+
+// Render returns the desired content of the CNI conf file given its current content
+func (cm *ConfigManager) Render(current []byte) ([]byte, error) {
+	values, err := cm.values()
+	if err != nil {
+		return nil, err
+	}
+
+	if cm.template != nil {
+		var buf bytes.Buffer
+		if err = cm.template.Execute(&buf, values); err != nil {
+			return nil, fmt.Errorf("failed to render CNI conf template: %v", err)
+		}
+		var config interface{}
+		if err = json.Unmarshal(buf.Bytes(), &config); err != nil {
+			return nil, fmt.Errorf("CNI conf template did not render valid JSON: %v", err)
+		}
+		return json.Marshal(config)
+	}
+
+	var config map[string]interface{}
+	if err = json.Unmarshal(current, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON from CNI conf file: %v", err)
+	}
+	if err = patchConfig(config, values, strings.HasSuffix(cm.path, ".conflist")); err != nil {
+		return nil, err
+	}
+	return json.Marshal(config)
+}
+
+// Sync makes the CNI conf file match its desired content and returns true if the file had drifted, that is if it was
+// modified externally since the last sync. Rewriting the file because the desired content changed is not drift, and
+// neither is the first sync, which patches the file installed by the install-cni init container.
+func (cm *ConfigManager) Sync() (bool, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	current, err := os.ReadFile(cm.path)
+	if err != nil && (cm.template == nil || !os.IsNotExist(err)) {
+		return false, fmt.Errorf("failed to load CNI conf file: %v", err)
+	}
+	desired, err := cm.Render(current)
+	if err != nil {
+		return false, err
+	}
+	drifted := cm.synced != nil && !jsonEqual(current, cm.synced)
+	if current != nil && jsonEqual(current, desired) {
+		cm.synced = current
+		return drifted, nil
+	}
+	if err = writeFileAtomically(cm.path, desired, 0644); err != nil {
+		return drifted, fmt.Errorf("failed to write CNI conf file: %v", err)
+	}
+	cm.synced = desired
+	return drifted, nil
+}
+
+// Run repairs the CNI conf file whenever it is modified on disk, and in any case every resyncPeriod, till we receive
+// notification on stopCh
+func (cm *ConfigManager) Run(resyncPeriod time.Duration, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Errorf("Failed to watch CNI conf file %s for changes: %v", cm.path, err)
+		return
+	}
+	defer utils.CloseCloserDisregardError(watcher)
+
+	// the directory is watched rather than the file itself, as agents usually replace the file through a rename
+	// which would end a watch on the file
+	if err = watcher.Add(filepath.Dir(cm.path)); err != nil {
+		klog.Errorf("Failed to watch CNI conf file %s for changes: %v", cm.path, err)
+		return
+	}
+
+	settle := time.NewTimer(settleTime)
+	settle.Stop()
+	defer settle.Stop()
+	t := time.NewTicker(resyncPeriod)
+	defer t.Stop()
+
+	klog.Infof("Watching CNI conf file %s for changes", cm.path)
+	for {
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down CNI conf manager")
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == filepath.Clean(cm.path) {
+				settle.Reset(settleTime)
+			}
+			continue
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			klog.Errorf("Error watching CNI conf file %s: %v", cm.path, err)
+			continue
+		case <-settle.C:
+		case <-t.C:
+		}
+
+		drifted, err := cm.Sync()
+		if err != nil {
+			klog.Errorf("Failed to reconcile CNI conf file %s: %v", cm.path, err)
+			continue
+		}
+		if drifted {
+			klog.Warningf("CNI conf file %s was modified externally, repaired it", cm.path)
+			if cm.DriftHandler != nil {
+				cm.DriftHandler(cm.path)
+			}
+		}
+	}
+}
+
+// patchConfig updates the plugin that sets up the pod interface (the one with the IPAM configuration) and the chained
+// plugins of a CNI conf in place
+func patchConfig(config map[string]interface{}, values Values, isConfList bool) error {
+	mainPlugin := config
+	if isConfList {
+		mainPlugin = nil
+		pluginConfigs, _ := config["plugins"].([]interface{})
+		for _, pluginConfig := range pluginConfigs {
+			pluginConfigMap, ok := pluginConfig.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := pluginConfigMap["ipam"]; ok {
+				mainPlugin = pluginConfigMap
+				break
+			}
+		}
+	}
+	ipam, ok := mainPlugin["ipam"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("failed to find the plugin with an IPAM configuration in the CNI conf")
+	}
+
+	setPluginType(mainPlugin, values.PluginType, values.BridgeName)
+
+	switch len(values.PodCIDRs) {
+	case 0:
+	case 1:
+		delete(ipam, "ranges")
+		ipam["subnet"] = values.PodCIDRs[0]
+	default:
+		ranges, err := values.IPAMRanges()
+		if err != nil {
+			return err
+		}
+		delete(ipam, "subnet")
+		ipam["ranges"] = ranges
+	}
+
+	if len(values.ChainedPlugins) > 0 {
+		if !isConfList {
+			return fmt.Errorf("chaining CNI plugins requires a .conflist CNI conf file")
+		}
+		config["plugins"] = chainPlugins(config["plugins"].([]interface{}), values.ChainedPlugins)
+	}
+
+	if values.MTU > 0 {
+		if isConfList {
+			for _, pluginConfig := range config["plugins"].([]interface{}) {
+				if pluginConfigMap, ok := pluginConfig.(map[string]interface{}); ok {
+					pluginConfigMap["mtu"] = values.MTU
+				}
+			}
+		} else {
+			config["mtu"] = values.MTU
+		}
+	}
+	return nil
+}
+
+// setPluginType switches the plugin between the bridge plugin and the ptp plugin. Plugins of any other type are left
+// alone, as they were set up by the administrator on purpose.
+func setPluginType(plugin map[string]interface{}, pluginType, bridgeName string) {
+	if pluginType == "" || plugin["type"] == pluginType ||
+		(plugin["type"] != PluginTypeBridge && plugin["type"] != PluginTypePTP) {
+		return
+	}
+	plugin["type"] = pluginType
+	if pluginType == PluginTypePTP {
+		for _, key := range bridgeOnlyKeys {
+			delete(plugin, key)
+		}
+	} else {
+		plugin["bridge"] = bridgeName
+		plugin["isDefaultGateway"] = true
+	}
+}
+
+// chainPlugins makes sure that a plugin of the same type as each of the given plugin configurations is in the list of
+// plugins, appending the ones that are missing and replacing the ones that differ
+func chainPlugins(pluginConfigs []interface{}, plugins []map[string]interface{}) []interface{} {
+	for _, plugin := range plugins {
+		found := false
+		for idx, pluginConfig := range pluginConfigs {
+			pluginConfigMap, ok := pluginConfig.(map[string]interface{})
+			if !ok || pluginConfigMap["type"] != plugin["type"] {
+				continue
+			}
+			found = true
+			pluginConfigs[idx] = copyPlugin(plugin)
+			break
+		}
+		if !found {
+			pluginConfigs = append(pluginConfigs, copyPlugin(plugin))
+		}
+	}
+	return pluginConfigs
+}
+
+// copyPlugin returns a shallow copy of the plugin configuration, so that setting the MTU on the conf does not change
+// the configuration it was chained from
+func copyPlugin(plugin map[string]interface{}) map[string]interface{} {
+	pluginCopy := make(map[string]interface{}, len(plugin))
+	for key, value := range plugin {
+		pluginCopy[key] = value
+	}
+	return pluginCopy
+}
+
+// jsonEqual returns true if both documents contain the same JSON values
+func jsonEqual(a, b []byte) bool {
+	var aValue, bValue interface{}
+	if json.Unmarshal(a, &aValue) != nil || json.Unmarshal(b, &bValue) != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
+
+// writeFileAtomically writes the file through a temporary file in the same directory, so that readers like the
+// container runtime never see a partially written file
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
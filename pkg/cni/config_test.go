@@ -0,0 +1,217 @@
+package cni
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ConfigManagerSyncPatch(t *testing.T) {
+	bandwidth := map[string]interface{}{
+		"type":         "bandwidth",
+		"capabilities": map[string]interface{}{"bandwidth": true},
+	}
+	testcases := []struct {
+		name        string
+		filename    string
+		values      Values
+		existingCni string
+		newCni      string
+		err         bool
+	}{
+		{
+			"single cidr is inserted as subnet",
+			"10-kuberouter.conf",
+			Values{PodCIDRs: []string{"172.17.0.0/24"}},
+			`{"bridge":"kube-bridge","ipam":{"ranges":[[{"subnet":"172.18.0.0/24"}]],"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			`{"bridge":"kube-bridge","ipam":{"subnet":"172.17.0.0/24","type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}`,
+			false,
+		},
+		{
+			"multiple cidrs are inserted as ranges per family",
+			"10-kuberouter.conflist",
+			Values{PodCIDRs: []string{"172.17.0.0/24", "2001:db8::/64", "10.10.0.0/26"}},
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"subnet":"172.17.0.0/24","type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"},{"type":"portmap"}]}`,
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"ranges":[[{"subnet":"172.17.0.0/24"},{"subnet":"10.10.0.0/26"}],[{"subnet":"2001:db8::/64"}]],"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"},{"type":"portmap"}]}`,
+			false,
+		},
+		{
+			"mtu is set on all plugins",
+			"10-kuberouter.conflist",
+			Values{MTU: 1450},
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"ipam":{"type":"host-local"},"mtu":1500,"type":"bridge"},{"type":"portmap"}]}`,
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"ipam":{"type":"host-local"},"mtu":1450,"type":"bridge"},{"mtu":1450,"type":"portmap"}]}`,
+			false,
+		},
+		{
+			"missing plugin is appended",
+			"10-kuberouter.conflist",
+			Values{ChainedPlugins: []map[string]interface{}{bandwidth}},
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"type":"host-local"},"name":"kubernetes","type":"bridge"}]}`,
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"type":"host-local"},"name":"kubernetes","type":"bridge"},{"capabilities":{"bandwidth":true},"type":"bandwidth"}]}`,
+			false,
+		},
+		{
+			"plugin that differs is replaced in place",
+			"10-kuberouter.conflist",
+			Values{ChainedPlugins: []map[string]interface{}{bandwidth}},
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"ipam":{"type":"host-local"},"type":"bridge"},{"type":"bandwidth"},{"type":"portmap"}]}`,
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"ipam":{"type":"host-local"},"type":"bridge"},{"capabilities":{"bandwidth":true},"type":"bandwidth"},{"type":"portmap"}]}`,
+			false,
+		},
+		{
+			"conf files can not chain plugins",
+			"10-kuberouter.conf",
+			Values{ChainedPlugins: []map[string]interface{}{bandwidth}},
+			`{"bridge":"kube-bridge","ipam":{"type":"host-local"},"name":"kubernetes","type":"bridge"}`,
+			`{"bridge":"kube-bridge","ipam":{"type":"host-local"},"name":"kubernetes","type":"bridge"}`,
+			true,
+		},
+		{
+			"bridge to ptp",
+			"10-kuberouter.conflist",
+			Values{PluginType: PluginTypePTP, BridgeName: "kube-bridge"},
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"type":"host-local"},"isDefaultGateway":true,"mtu":1500,"name":"kubernetes","type":"bridge"},{"type":"portmap"}]}`,
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"ipam":{"type":"host-local"},"mtu":1500,"name":"kubernetes","type":"ptp"},{"type":"portmap"}]}`,
+			false,
+		},
+		{
+			"ptp to bridge",
+			"10-kuberouter.conflist",
+			Values{PluginType: PluginTypeBridge, BridgeName: "kube-bridge"},
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"ipam":{"type":"host-local"},"name":"kubernetes","type":"ptp"}]}`,
+			`{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"type":"host-local"},"isDefaultGateway":true,"name":"kubernetes","type":"bridge"}]}`,
+			false,
+		},
+		{
+			"other plugin types are left alone",
+			"10-kuberouter.conflist",
+			Values{PluginType: PluginTypePTP, BridgeName: "kube-bridge"},
+			`{"cniVersion":"0.3.0", "name":"mynet", "plugins":[{"ipam":{"type":"host-local"},"type":"macvlan"}]}`,
+			`{"cniVersion":"0.3.0", "name":"mynet", "plugins":[{"ipam":{"type":"host-local"},"type":"macvlan"}]}`,
+			false,
+		},
+		{
+			"file already in sync is left alone",
+			"10-kuberouter.conflist",
+			Values{PluginType: PluginTypeBridge, BridgeName: "kube-bridge", MTU: 1500, PodCIDRs: []string{"10.0.0.0/24"},
+				ChainedPlugins: []map[string]interface{}{bandwidth}},
+			`{"cniVersion":"0.3.0", "name":"mynet", "plugins":[{"type":"bridge","mtu":1500,"ipam":{"type":"host-local","subnet":"10.0.0.0/24"}},{"type":"bandwidth","mtu":1500,"capabilities":{"bandwidth":true}}]}`,
+			`{"cniVersion":"0.3.0", "name":"mynet", "plugins":[{"type":"bridge","mtu":1500,"ipam":{"type":"host-local","subnet":"10.0.0.0/24"}},{"type":"bandwidth","mtu":1500,"capabilities":{"bandwidth":true}}]}`,
+			false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), testcase.filename)
+			if err := os.WriteFile(path, []byte(testcase.existingCni), 0644); err != nil {
+				t.Fatalf("failed to create temporary CNI config: %v", err)
+			}
+
+			values := testcase.values
+			cm, err := NewConfigManager(path, "", func() (Values, error) { return values, nil })
+			if err != nil {
+				t.Fatalf("failed to create config manager: %v", err)
+			}
+			drifted, err := cm.Sync()
+			if testcase.err != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", testcase.err, err)
+			}
+			if drifted {
+				t.Error("patching the installed CNI config should not be reported as drift")
+			}
+
+			newContent, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read CNI config file: %v", err)
+			}
+			if string(newContent) != testcase.newCni {
+				t.Logf("actual CNI config: %s", newContent)
+				t.Logf("expected CNI config: %v", testcase.newCni)
+				t.Error("did not get expected CNI config content")
+			}
+		})
+	}
+}
+
+func Test_ConfigManagerSyncTemplate(t *testing.T) {
+	dir := t.TempDir()
+	templatePath := filepath.Join(dir, "kuberouter.conflist.tmpl")
+	template := `{
+  "cniVersion": "0.3.0",
+  "name": "mynet",
+  "plugins": [
+    {
+      "type": "{{ .PluginType }}",
+      "bridge": "{{ .BridgeName }}",
+      "mtu": {{ .MTU }},
+      "ipam": {"type": "host-local", "ranges": {{ toJson .IPAMRanges }}}
+    }{{ range .ChainedPlugins }},
+    {{ toJson . }}{{ end }}
+  ]
+}`
+	if err := os.WriteFile(templatePath, []byte(template), 0644); err != nil {
+		t.Fatalf("failed to create CNI config template: %v", err)
+	}
+	path := filepath.Join(dir, "10-kuberouter.conflist")
+
+	values := Values{PluginType: PluginTypeBridge, BridgeName: "kube-bridge", MTU: 1450,
+		PodCIDRs: []string{"10.0.0.0/24", "2001:db8::/64"},
+		ChainedPlugins: []map[string]interface{}{{"type": "bandwidth",
+			"capabilities": map[string]interface{}{"bandwidth": true}}}}
+	cm, err := NewConfigManager(path, templatePath, func() (Values, error) { return values, nil })
+	if err != nil {
+		t.Fatalf("failed to create config manager: %v", err)
+	}
+
+	drifted, err := cm.Sync()
+	if err != nil {
+		t.Fatalf("failed to sync CNI config: %v", err)
+	}
+	if drifted {
+		t.Error("creating the CNI config should not be reported as drift")
+	}
+	expected := `{"cniVersion":"0.3.0","name":"mynet","plugins":[{"bridge":"kube-bridge","ipam":{"ranges":[[{"subnet":"10.0.0.0/24"}],[{"subnet":"2001:db8::/64"}]],"type":"host-local"},"mtu":1450,"type":"bridge"},{"capabilities":{"bandwidth":true},"type":"bandwidth"}]}`
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read CNI config file: %v", err)
+	}
+	if string(content) != expected {
+		t.Errorf("expected rendered CNI config %s, got %s", expected, content)
+	}
+
+	if drifted, err = cm.Sync(); err != nil || drifted {
+		t.Errorf("expected CNI config in sync to be left alone, drifted: %v, err: %v", drifted, err)
+	}
+
+	if err = os.WriteFile(path, []byte(`{"cniVersion":"0.3.0","name":"mynet","plugins":[]}`), 0644); err != nil {
+		t.Fatalf("failed to modify CNI config file: %v", err)
+	}
+	if drifted, err = cm.Sync(); err != nil || !drifted {
+		t.Errorf("expected modified CNI config to be repaired, drifted: %v, err: %v", drifted, err)
+	}
+	if content, _ = os.ReadFile(path); string(content) != expected {
+		t.Errorf("expected repaired CNI config %s, got %s", expected, content)
+	}
+
+	values.MTU = 1400
+	if drifted, err = cm.Sync(); err != nil || drifted {
+		t.Errorf("expected the change of the desired CNI config not to be drift, drifted: %v, err: %v", drifted, err)
+	}
+	if content, _ = os.ReadFile(path); !strings.Contains(string(content), `"mtu":1400`) {
+		t.Errorf("expected CNI config to be rewritten with the new MTU, got %s", content)
+	}
+}
+
+func Test_NewConfigManager(t *testing.T) {
+	values := func() (Values, error) { return Values{}, nil }
+	if _, err := NewConfigManager(filepath.Join(t.TempDir(), "10-kuberouter.conf"), "", values); err == nil {
+		t.Error("expected an error for a missing CNI config without a template")
+	}
+	if _, err := NewConfigManager("/etc/cni/net.d/10-kuberouter.conf", filepath.Join(t.TempDir(), "missing.tmpl"),
+		values); err == nil {
+		t.Error("expected an error for a missing CNI config template")
+	}
+}
@@ -0,0 +1,294 @@
+package lballoc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	gatewayv1alpha2 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1alpha2"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// syncGatewayConditions sets the Accepted condition of the GatewayClasses of kube-router, the conditions of their
+// Gateways and of the listeners of the Gateways, and the status of the routes for each of the Gateways of kube-router
+// they refer to. The addresses are the ones the Gateways are served on, by namespace and name.
+func (lbc *LoadBalancerController) syncGatewayConditions(ctx context.Context, gateways []*gatewayv1.Gateway,
+	addresses map[string][]string) {
+	now := metav1.Now()
+	for _, class := range utils.ManagedGatewayClasses(lbc.gatewayClassLister) {
+		conditions := mergeConditions(class.Status.Conditions, []metav1.Condition{
+			newCondition(gatewayv1.ConditionAccepted, true, gatewayv1.ReasonAccepted,
+				"the Gateways of the class are programmed by kube-router", class.Generation),
+		}, now)
+		if equality.Semantic.DeepEqual(conditions, class.Status.Conditions) {
+			continue
+		}
+		if err := lbc.patchGatewayStatus(ctx, gatewayv1.GatewayClassResource, "", class.Name, "",
+			map[string]interface{}{
+				"conditions": conditions,
+			}); err != nil {
+			lbc.logger.Error(err, "Failed to update the conditions of GatewayClass", "gatewayClass", class.Name)
+		}
+	}
+
+	routes := utils.GatewayRoutes(lbc.tcpRouteLister, lbc.udpRouteLister)
+	attached := utils.AttachGatewayRoutes(gateways, routes)
+	for _, gateway := range gateways {
+		conditions, listeners := gatewayConditions(gateway, addresses[gateway.Namespace+"/"+gateway.Name], attached,
+			now)
+		if equality.Semantic.DeepEqual(conditions, gateway.Status.Conditions) &&
+			equality.Semantic.DeepEqual(listeners, gateway.Status.Listeners) {
+			continue
+		}
+		if err := lbc.patchGatewayStatus(ctx, gatewayv1.GatewayResource, gateway.Namespace, gateway.Name, "",
+			map[string]interface{}{
+				"conditions": conditions,
+				"listeners":  listeners,
+			}); err != nil {
+			lbc.logger.Error(err, "Failed to update the conditions of Gateway", utils.LogKeyNamespace,
+				gateway.Namespace, "gateway", gateway.Name)
+		}
+	}
+
+	for _, route := range routes {
+		parents := routeParentStatuses(route, gateways, attached, routeResolvedRefs(route, lbc.svcLister), now)
+		if equality.Semantic.DeepEqual(parents, route.Status.Parents) {
+			continue
+		}
+		resource := gatewayv1alpha2.TCPRouteResource
+		if route.Kind == gatewayv1alpha2.UDPRouteKind {
+			resource = gatewayv1alpha2.UDPRouteResource
+		}
+		// the parents of the other controllers are kept, the resource version makes sure none were added since
+		if err := lbc.patchGatewayStatus(ctx, resource, route.Namespace, route.Name, route.ResourceVersion,
+			map[string]interface{}{
+				"parents": parents,
+			}); err != nil {
+			lbc.logger.Error(err, "Failed to update the status of route", "kind", route.Kind, utils.LogKeyNamespace,
+				route.Namespace, "route", route.Name)
+		}
+	}
+}
+
+// patchGatewayStatus merge patches the status of a Gateway API resource, only if it is still of the resource version
+// when one is given
+func (lbc *LoadBalancerController) patchGatewayStatus(ctx context.Context, resource schema.GroupVersionResource,
+	namespace, name, resourceVersion string, status map[string]interface{}) error {
+	patch := map[string]interface{}{"status": status}
+	if resourceVersion != "" {
+		patch["metadata"] = map[string]interface{}{"resourceVersion": resourceVersion}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = lbc.gatewayClient.Resource(resource).Namespace(namespace).Patch(ctx, name, types.MergePatchType, data,
+		metav1.PatchOptions{}, "status")
+	return err
+}
+
+// gatewayConditions returns the conditions of the Gateway and the status of its listeners. The TCP and UDP listeners
+// are accepted, and are programmed along with the Gateway once it has an IPv4 address to be served on.
+func gatewayConditions(gateway *gatewayv1.Gateway, addresses []string, attached map[string]*utils.GatewayRoute,
+	now metav1.Time) ([]metav1.Condition, []gatewayv1.ListenerStatus) {
+	var hasIPv4 bool
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			hasIPv4 = true
+		}
+	}
+
+	listeners := make([]gatewayv1.ListenerStatus, 0, len(gateway.Spec.Listeners))
+	var accepted int
+	for _, listener := range gateway.Spec.Listeners {
+		status := gatewayv1.ListenerStatus{Name: listener.Name, SupportedKinds: []gatewayv1.RouteGroupKind{}}
+		var desired []metav1.Condition
+		if kind := listenerRouteKind(listener); kind == "" {
+			desired = []metav1.Condition{
+				newCondition(gatewayv1.ConditionAccepted, false, gatewayv1.ReasonUnsupportedProtocol,
+					"only the TCP and UDP protocols are supported", gateway.Generation),
+				newCondition(gatewayv1.ConditionProgrammed, false, gatewayv1.ReasonInvalid,
+					"the listener isn't accepted", gateway.Generation),
+			}
+		} else {
+			accepted++
+			group := gatewayv1.GroupName
+			status.SupportedKinds = append(status.SupportedKinds, gatewayv1.RouteGroupKind{Group: &group, Kind: kind})
+			if _, ok := attached[utils.GatewayListenerKey(gateway, listener)]; ok {
+				status.AttachedRoutes = 1
+			}
+			desired = []metav1.Condition{
+				newCondition(gatewayv1.ConditionAccepted, true, gatewayv1.ReasonAccepted, "", gateway.Generation),
+				programmedCondition(hasIPv4, gatewayv1.ReasonPending, gateway.Generation),
+			}
+		}
+		desired = append(desired, newCondition(gatewayv1.ConditionResolvedRefs, true, gatewayv1.ReasonResolvedRefs,
+			"", gateway.Generation))
+		var existing []metav1.Condition
+		for _, listenerStatus := range gateway.Status.Listeners {
+			if listenerStatus.Name == listener.Name {
+				existing = listenerStatus.Conditions
+			}
+		}
+		status.Conditions = mergeConditions(existing, desired, now)
+		listeners = append(listeners, status)
+	}
+
+	var desired []metav1.Condition
+	if accepted == 0 {
+		desired = []metav1.Condition{
+			newCondition(gatewayv1.ConditionAccepted, false, gatewayv1.ReasonListenersNotValid,
+				"none of the listeners is of the TCP or UDP protocol", gateway.Generation),
+			newCondition(gatewayv1.ConditionProgrammed, false, gatewayv1.ReasonInvalid,
+				"the Gateway isn't accepted", gateway.Generation),
+		}
+	} else {
+		desired = []metav1.Condition{
+			newCondition(gatewayv1.ConditionAccepted, true, gatewayv1.ReasonAccepted, "", gateway.Generation),
+			programmedCondition(hasIPv4, gatewayv1.ReasonAddressNotAssigned, gateway.Generation),
+		}
+	}
+	return mergeConditions(gateway.Status.Conditions, desired, now), listeners
+}
+
+// listenerRouteKind returns the kind of the routes the listener accepts, none for the protocols that aren't supported
+func listenerRouteKind(listener gatewayv1.Listener) string {
+	switch listener.Protocol {
+	case gatewayv1.TCPProtocolType:
+		return gatewayv1alpha2.TCPRouteKind
+	case gatewayv1.UDPProtocolType:
+		return gatewayv1alpha2.UDPRouteKind
+	}
+	return ""
+}
+
+func programmedCondition(hasIPv4 bool, pendingReason string, generation int64) metav1.Condition {
+	if !hasIPv4 {
+		return newCondition(gatewayv1.ConditionProgrammed, false, pendingReason,
+			"the Gateway has no IPv4 address to be served on yet", generation)
+	}
+	return newCondition(gatewayv1.ConditionProgrammed, true, gatewayv1.ReasonProgrammed, "", generation)
+}
+
+// routeParentStatuses returns the status of the route for each of its parents: the status set by the controllers of
+// the parents that aren't Gateways of kube-router is kept, the one of the Gateways of kube-router tells whether the
+// route is attached to them, or why it isn't, and whether its backends could be resolved
+func routeParentStatuses(route *utils.GatewayRoute, gateways []*gatewayv1.Gateway,
+	attached map[string]*utils.GatewayRoute, resolvedRefs metav1.Condition,
+	now metav1.Time) []gatewayv1alpha2.RouteParentStatus {
+	parents := make([]gatewayv1alpha2.RouteParentStatus, 0, len(route.Status.Parents))
+	for _, parent := range route.Status.Parents {
+		if parent.ControllerName != utils.GatewayControllerName {
+			parents = append(parents, parent)
+		}
+	}
+	for _, ref := range route.Spec.ParentRefs {
+		for _, gateway := range gateways {
+			if !utils.ParentRefIsGateway(route, ref, gateway) {
+				continue
+			}
+			var existing []metav1.Condition
+			for _, parent := range route.Status.Parents {
+				if parent.ControllerName == utils.GatewayControllerName &&
+					equality.Semantic.DeepEqual(parent.ParentRef, ref) {
+					existing = parent.Conditions
+				}
+			}
+			parents = append(parents, gatewayv1alpha2.RouteParentStatus{
+				ParentRef:      ref,
+				ControllerName: utils.GatewayControllerName,
+				Conditions: mergeConditions(existing, []metav1.Condition{
+					routeAcceptedCondition(route, ref, gateway, attached),
+					resolvedRefs,
+				}, now),
+			})
+		}
+	}
+	return parents
+}
+
+// routeAcceptedCondition returns whether the route is attached to the listeners of the Gateway the parent reference
+// refers to, or why it isn't: no listener matches the reference, the listeners don't allow the route, or they already
+// have an older route attached
+func routeAcceptedCondition(route *utils.GatewayRoute, ref gatewayv1alpha2.ParentReference,
+	gateway *gatewayv1.Gateway, attached map[string]*utils.GatewayRoute) metav1.Condition {
+	var matching, allowed bool
+	conflicts := make([]string, 0)
+	for _, listener := range gateway.Spec.Listeners {
+		if !utils.ParentRefMatchesListener(route, ref, gateway, listener) {
+			continue
+		}
+		matching = true
+		if !utils.ListenerAllowsRoute(route, gateway, listener) {
+			continue
+		}
+		allowed = true
+		older := attached[utils.GatewayListenerKey(gateway, listener)]
+		if older == route {
+			return newCondition(gatewayv1.ConditionAccepted, true, gatewayv1.ReasonAccepted, "", route.Generation)
+		}
+		conflicts = append(conflicts, fmt.Sprintf("listener %s already has the older %s %s/%s", listener.Name,
+			older.Kind, older.Namespace, older.Name))
+	}
+	switch {
+	case !matching:
+		return newCondition(gatewayv1.ConditionAccepted, false, gatewayv1.ReasonNoMatchingParent,
+			fmt.Sprintf("no listener of Gateway %s/%s matches the parentRef", gateway.Namespace, gateway.Name),
+			route.Generation)
+	case !allowed:
+		return newCondition(gatewayv1.ConditionAccepted, false, gatewayv1.ReasonNotAllowedByListeners,
+			fmt.Sprintf("the listeners of Gateway %s/%s don't allow the %ss of namespace %s", gateway.Namespace,
+				gateway.Name, route.Kind, route.Namespace), route.Generation)
+	}
+	return newCondition(gatewayv1.ConditionAccepted, false, gatewayv1.ReasonConflicted,
+		strings.Join(conflicts, ", "), route.Generation)
+}
+
+// routeResolvedRefs returns the ResolvedRefs condition of the route, telling why the first of its backends that can't
+// be resolved is left out
+func routeResolvedRefs(route *utils.GatewayRoute, svcLister cache.Indexer) metav1.Condition {
+	for _, rule := range route.Spec.Rules {
+		for _, ref := range rule.BackendRefs {
+			_, _, err := utils.ResolveGatewayBackend(route, ref, svcLister)
+			var refErr *utils.BackendRefError
+			if errors.As(err, &refErr) {
+				return newCondition(gatewayv1.ConditionResolvedRefs, false, refErr.Reason, refErr.Message,
+					route.Generation)
+			}
+		}
+	}
+	return newCondition(gatewayv1.ConditionResolvedRefs, true, gatewayv1.ReasonResolvedRefs, "", route.Generation)
+}
+
+func newCondition(conditionType string, status bool, reason, message string, generation int64) metav1.Condition {
+	condition := metav1.Condition{Type: conditionType, Status: metav1.ConditionFalse, Reason: reason,
+		Message: message, ObservedGeneration: generation}
+	if status {
+		condition.Status = metav1.ConditionTrue
+	}
+	return condition
+}
+
+// mergeConditions returns the desired conditions with the last transition times of the existing conditions of the
+// same status, so that they only change when the status of a condition does
+func mergeConditions(existing, desired []metav1.Condition, now metav1.Time) []metav1.Condition {
+	conditions := make([]metav1.Condition, 0, len(desired))
+	for _, condition := range desired {
+		condition.LastTransitionTime = now
+		if old := meta.FindStatusCondition(existing, condition.Type); old != nil && old.Status == condition.Status {
+			condition.LastTransitionTime = old.LastTransitionTime
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
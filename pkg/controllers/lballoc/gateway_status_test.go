@@ -0,0 +1,148 @@
+package lballoc
+
+import (
+	"testing"
+	"time"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	gatewayv1alpha2 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1alpha2"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+func tConditionReasons(conditions []metav1.Condition) map[string]string {
+	reasons := make(map[string]string)
+	for _, condition := range conditions {
+		reasons[condition.Type] = string(condition.Status) + "/" + condition.Reason
+	}
+	return reasons
+}
+
+func Test_gatewayConditions(t *testing.T) {
+	now := metav1.NewTime(time.Now())
+	gateway := &gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "public", Generation: 2},
+		Spec: gatewayv1.GatewaySpec{Listeners: []gatewayv1.Listener{
+			{Name: "tcp", Port: 8000, Protocol: gatewayv1.TCPProtocolType},
+			{Name: "http", Port: 80, Protocol: "HTTP"},
+		}}}
+	attached := map[string]*utils.GatewayRoute{"web/public/tcp": {Kind: gatewayv1alpha2.TCPRouteKind}}
+
+	conditions, listeners := gatewayConditions(gateway, []string{"192.0.2.1"}, attached, now)
+	assert.Equal(t, map[string]string{
+		gatewayv1.ConditionAccepted:   "True/" + gatewayv1.ReasonAccepted,
+		gatewayv1.ConditionProgrammed: "True/" + gatewayv1.ReasonProgrammed,
+	}, tConditionReasons(conditions))
+	assert.Equal(t, int64(2), conditions[0].ObservedGeneration)
+	if assert.Len(t, listeners, 2) {
+		assert.Equal(t, int32(1), listeners[0].AttachedRoutes)
+		assert.Equal(t, []gatewayv1.RouteGroupKind{{Group: pointer.String(gatewayv1.GroupName),
+			Kind: gatewayv1alpha2.TCPRouteKind}}, listeners[0].SupportedKinds)
+		assert.Equal(t, map[string]string{
+			gatewayv1.ConditionAccepted:     "True/" + gatewayv1.ReasonAccepted,
+			gatewayv1.ConditionProgrammed:   "True/" + gatewayv1.ReasonProgrammed,
+			gatewayv1.ConditionResolvedRefs: "True/" + gatewayv1.ReasonResolvedRefs,
+		}, tConditionReasons(listeners[0].Conditions))
+		assert.Empty(t, listeners[1].SupportedKinds)
+		assert.Equal(t, "False/"+gatewayv1.ReasonUnsupportedProtocol,
+			tConditionReasons(listeners[1].Conditions)[gatewayv1.ConditionAccepted])
+	}
+
+	conditions, listeners = gatewayConditions(gateway, []string{"2001:db8::1"}, attached, now)
+	assert.Equal(t, "False/"+gatewayv1.ReasonAddressNotAssigned,
+		tConditionReasons(conditions)[gatewayv1.ConditionProgrammed],
+		"expected the Gateway without an IPv4 address not to be programmed")
+	assert.Equal(t, "False/"+gatewayv1.ReasonPending,
+		tConditionReasons(listeners[0].Conditions)[gatewayv1.ConditionProgrammed])
+
+	gateway.Spec.Listeners = gateway.Spec.Listeners[1:]
+	conditions, _ = gatewayConditions(gateway, []string{"192.0.2.1"}, attached, now)
+	assert.Equal(t, "False/"+gatewayv1.ReasonListenersNotValid,
+		tConditionReasons(conditions)[gatewayv1.ConditionAccepted])
+}
+
+func Test_routeParentStatuses(t *testing.T) {
+	now := metav1.NewTime(time.Now())
+	gateways := []*gatewayv1.Gateway{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "public"},
+			Spec: gatewayv1.GatewaySpec{Listeners: []gatewayv1.Listener{
+				{Name: "tcp", Port: 8000, Protocol: gatewayv1.TCPProtocolType},
+			}}},
+	}
+	route := func(namespace, name string, refs ...gatewayv1alpha2.ParentReference) *utils.GatewayRoute {
+		return &utils.GatewayRoute{Kind: gatewayv1alpha2.TCPRouteKind, Namespace: namespace, Name: name,
+			Protocol: gatewayv1.TCPProtocolType, Spec: gatewayv1alpha2.RouteSpec{ParentRefs: refs}}
+	}
+	public := gatewayv1alpha2.ParentReference{Name: "public"}
+	otherGateway := gatewayv1alpha2.ParentReference{Name: "edge"}
+	oldest := route("web", "oldest", public)
+	newer := route("web", "newer", public)
+	otherNamespace := route("db", "postgres", gatewayv1alpha2.ParentReference{Namespace: pointer.String("web"),
+		Name: "public"})
+	otherListener := route("web", "tls", gatewayv1alpha2.ParentReference{Name: "public",
+		SectionName: pointer.String("tls")})
+	notOurs := route("web", "edge", otherGateway)
+	notOurs.Status.Parents = []gatewayv1alpha2.RouteParentStatus{{ParentRef: otherGateway,
+		ControllerName: "example.com/gateway-controller"}}
+	attached := utils.AttachGatewayRoutes(gateways, []*utils.GatewayRoute{oldest, newer, otherNamespace,
+		otherListener, notOurs})
+	resolved := newCondition(gatewayv1.ConditionResolvedRefs, true, gatewayv1.ReasonResolvedRefs, "", 1)
+
+	accepted := func(route *utils.GatewayRoute) metav1.Condition {
+		parents := routeParentStatuses(route, gateways, attached, resolved, now)
+		if !assert.Len(t, parents, 1) {
+			return metav1.Condition{}
+		}
+		assert.Equal(t, utils.GatewayControllerName, parents[0].ControllerName)
+		assert.True(t, meta.IsStatusConditionTrue(parents[0].Conditions, gatewayv1.ConditionResolvedRefs))
+		return *meta.FindStatusCondition(parents[0].Conditions, gatewayv1.ConditionAccepted)
+	}
+	assert.Equal(t, gatewayv1.ReasonAccepted, accepted(oldest).Reason)
+	condition := accepted(newer)
+	assert.Equal(t, gatewayv1.ReasonConflicted, condition.Reason)
+	assert.Contains(t, condition.Message, "web/oldest", "expected the route attached in its place to be named")
+	assert.Equal(t, gatewayv1.ReasonNotAllowedByListeners, accepted(otherNamespace).Reason)
+	assert.Equal(t, gatewayv1.ReasonNoMatchingParent, accepted(otherListener).Reason)
+
+	assert.Equal(t, notOurs.Status.Parents, routeParentStatuses(notOurs, gateways, attached, resolved, now),
+		"expected the status set by the controllers of other Gateways to be kept")
+}
+
+func Test_routeResolvedRefs(t *testing.T) {
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, svcLister.Add(&v1core.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "app"},
+		Spec: v1core.ServiceSpec{Ports: []v1core.ServicePort{{Port: 80, Protocol: v1core.ProtocolTCP}}}}))
+	route := &utils.GatewayRoute{Kind: gatewayv1alpha2.TCPRouteKind, Namespace: "web", Name: "app",
+		Protocol: gatewayv1.TCPProtocolType, Spec: gatewayv1alpha2.RouteSpec{Rules: []gatewayv1alpha2.RouteRule{{
+			BackendRefs: []gatewayv1alpha2.BackendRef{{Name: "app", Port: pointer.Int32(80)}},
+		}}}}
+	assert.Equal(t, gatewayv1.ReasonResolvedRefs, routeResolvedRefs(route, svcLister).Reason)
+
+	route.Spec.Rules[0].BackendRefs = append(route.Spec.Rules[0].BackendRefs, gatewayv1alpha2.BackendRef{
+		Namespace: pointer.String("db"), Name: "postgres", Port: pointer.Int32(5432)})
+	condition := routeResolvedRefs(route, svcLister)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, gatewayv1.ReasonRefNotPermitted, condition.Reason)
+	assert.Contains(t, condition.Message, "db/postgres")
+}
+
+func Test_mergeConditions(t *testing.T) {
+	before := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	now := metav1.NewTime(time.Now())
+	existing := []metav1.Condition{
+		{Type: gatewayv1.ConditionAccepted, Status: metav1.ConditionTrue, Reason: gatewayv1.ReasonAccepted,
+			LastTransitionTime: before},
+		{Type: gatewayv1.ConditionProgrammed, Status: metav1.ConditionFalse, Reason: gatewayv1.ReasonPending,
+			LastTransitionTime: before},
+	}
+	conditions := mergeConditions(existing, []metav1.Condition{
+		newCondition(gatewayv1.ConditionAccepted, true, gatewayv1.ReasonAccepted, "", 1),
+		newCondition(gatewayv1.ConditionProgrammed, true, gatewayv1.ReasonProgrammed, "", 1),
+	}, now)
+	assert.Equal(t, before, conditions[0].LastTransitionTime, "expected the unchanged condition to keep its time")
+	assert.Equal(t, now, conditions[1].LastTransitionTime)
+}
@@ -0,0 +1,142 @@
+package lballoc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sort"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+// EnableGateways makes the controller allocate an IPv4 address from the pools to the Gateways programmed by
+// kube-router that don't ask for addresses in their spec, publishing it in their status, and keep the conditions of
+// the GatewayClasses, the Gateways and the routes attached to them up to date. The informers watch the GatewayClasses,
+// the Gateways, the TCPRoutes and the UDPRoutes, GatewayEventHandler is to be added to all of them.
+func (lbc *LoadBalancerController) EnableGateways(gatewayClassInformer, gatewayInformer, tcpRouteInformer,
+	udpRouteInformer cache.SharedIndexInformer, client dynamic.Interface) {
+	lbc.gatewayClassLister = gatewayClassInformer.GetIndexer()
+	lbc.gatewayLister = gatewayInformer.GetIndexer()
+	lbc.tcpRouteLister = tcpRouteInformer.GetIndexer()
+	lbc.udpRouteLister = udpRouteInformer.GetIndexer()
+	lbc.gatewayClient = client
+	lbc.GatewayEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { lbc.RequestSync() },
+		UpdateFunc: func(interface{}, interface{}) { lbc.RequestSync() },
+		DeleteFunc: func(interface{}) { lbc.RequestSync() },
+	}
+}
+
+// gateways returns the Gateways programmed by kube-router, the oldest first, when they are enabled
+func (lbc *LoadBalancerController) gateways() []*gatewayv1.Gateway {
+	if lbc.gatewayLister == nil {
+		return nil
+	}
+	gateways := utils.ManagedGateways(lbc.gatewayLister, lbc.gatewayClassLister)
+	// the Gateways are sorted by namespace and name already, which orders the ones created at the same time
+	sort.SliceStable(gateways, func(i, j int) bool {
+		return gateways[i].CreationTimestamp.Before(&gateways[j].CreationTimestamp)
+	})
+	return gateways
+}
+
+// syncGateways allocates the addresses of the Gateways that don't ask for any, after the services got theirs, and then
+// updates the conditions of the Gateways and their routes
+func (lbc *LoadBalancerController) syncGateways(ctx context.Context, gateways []*gatewayv1.Gateway,
+	used map[string]bool) {
+	served := make(map[string][]string)
+	for _, gateway := range gateways {
+		key := gateway.Namespace + "/" + gateway.Name
+		served[key] = utils.GatewayAddresses(gateway)
+		if utils.GatewayRequestsAddresses(gateway) {
+			continue
+		}
+		addresses, err := allocateGatewayAddresses(gateway, lbc.pools, used)
+		if err != nil {
+			lbc.logger.Error(err, "Failed to allocate the address of Gateway",
+				utils.LogKeyNamespace, gateway.Namespace, "gateway", gateway.Name)
+		}
+		if gatewayAddressesEqual(addresses, gateway.Status.Addresses) {
+			continue
+		}
+		if err = lbc.updateGatewayAddresses(ctx, gateway, addresses); err != nil {
+			lbc.logger.Error(err, "Failed to update the addresses of Gateway",
+				utils.LogKeyNamespace, gateway.Namespace, "gateway", gateway.Name)
+			continue
+		}
+		served[key] = gatewayAddressValues(addresses)
+		lbc.logger.Info("Updated the addresses of Gateway", utils.LogKeyNamespace, gateway.Namespace,
+			"gateway", gateway.Name, "addresses", served[key])
+	}
+	lbc.syncGatewayConditions(ctx, gateways, served)
+}
+
+// allocateGatewayAddresses returns the addresses of the status of the Gateway: the allocated addresses that are still
+// in a pool, or else a free IPv4 address of the pools, as the listeners are only served over IPv4. The address it
+// allocates is added to used.
+func allocateGatewayAddresses(gateway *gatewayv1.Gateway, pools []*net.IPNet, used map[string]bool) (
+	[]gatewayv1.GatewayAddress, error) {
+	addresses := make([]gatewayv1.GatewayAddress, 0, 1)
+	for _, address := range utils.GatewayAddresses(gateway) {
+		if ip := net.ParseIP(address); ip != nil && inPool(ip, pools) != nil {
+			addresses = append(addresses, newGatewayAddress(address))
+		}
+	}
+	if len(addresses) > 0 {
+		return addresses, nil
+	}
+	ip, err := nextFreeIP(v1core.IPv4Protocol, pools, used)
+	if err != nil {
+		return addresses, err
+	}
+	used[ip.String()] = true
+	return append(addresses, newGatewayAddress(ip.String())), nil
+}
+
+func newGatewayAddress(ip string) gatewayv1.GatewayAddress {
+	addressType := gatewayv1.IPAddressType
+	return gatewayv1.GatewayAddress{Type: &addressType, Value: ip}
+}
+
+// updateGatewayAddresses patches the addresses of the status of the Gateway, the whole list is replaced
+func (lbc *LoadBalancerController) updateGatewayAddresses(ctx context.Context, gateway *gatewayv1.Gateway,
+	addresses []gatewayv1.GatewayAddress) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"addresses": addresses,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = lbc.gatewayClient.Resource(gatewayv1.GatewayResource).Namespace(gateway.Namespace).Patch(ctx,
+		gateway.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	return err
+}
+
+func gatewayAddressValues(addresses []gatewayv1.GatewayAddress) []string {
+	values := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		values = append(values, address.Value)
+	}
+	return values
+}
+
+func gatewayAddressesEqual(a, b []gatewayv1.GatewayAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Value != b[i].Value || (a[i].Type == nil) != (b[i].Type == nil) ||
+			(a[i].Type != nil && *a[i].Type != *b[i].Type) {
+			return false
+		}
+	}
+	return true
+}
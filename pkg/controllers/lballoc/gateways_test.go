@@ -0,0 +1,74 @@
+package lballoc
+
+import (
+	"testing"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_allocateGatewayAddresses(t *testing.T) {
+	pools, err := parsePools([]string{"2001:db8::/126", "192.0.2.0/30"})
+	assert.NoError(t, err)
+
+	testcases := []struct {
+		name      string
+		gateway   *gatewayv1.Gateway
+		used      []string
+		expected  []string
+		expectErr bool
+	}{
+		{
+			"first free IPv4 address of the pools",
+			&gatewayv1.Gateway{},
+			[]string{"192.0.2.0"},
+			[]string{"192.0.2.1"},
+			false,
+		},
+		{
+			"the allocated address is kept",
+			&gatewayv1.Gateway{Status: gatewayv1.GatewayStatus{
+				Addresses: []gatewayv1.GatewayAddress{{Value: "192.0.2.3"}},
+			}},
+			[]string{"192.0.2.3"},
+			[]string{"192.0.2.3"},
+			false,
+		},
+		{
+			"addresses out of the pools are released",
+			&gatewayv1.Gateway{Status: gatewayv1.GatewayStatus{
+				Addresses: []gatewayv1.GatewayAddress{{Value: "198.51.100.1"}},
+			}},
+			[]string{"198.51.100.1"},
+			[]string{"192.0.2.0"},
+			false,
+		},
+		{
+			"no address left",
+			&gatewayv1.Gateway{},
+			[]string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3"},
+			[]string{},
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			used := make(map[string]bool)
+			for _, ip := range testcase.used {
+				used[ip] = true
+			}
+			addresses, err := allocateGatewayAddresses(testcase.gateway, pools, used)
+			if testcase.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testcase.expected, gatewayAddressValues(addresses))
+			for _, address := range addresses {
+				assert.Equal(t, gatewayv1.IPAddressType, *address.Type)
+				assert.True(t, used[address.Value], "expected the allocated addresses to be marked as used")
+			}
+		})
+	}
+}
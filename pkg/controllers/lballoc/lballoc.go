@@ -0,0 +1,391 @@
+package lballoc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LoadBalancerClass is the loadBalancerClass of the services that get their LoadBalancer IPs allocated by
+	// kube-router
+	LoadBalancerClass = "kube-router.io/lballoc"
+
+	leaseName             = "kube-router-lballoc"
+	defaultLeaseNamespace = "kube-system"
+	leaseDuration         = 15 * time.Second
+	leaseRenewDeadline    = 10 * time.Second
+	leaseRetryPeriod      = 2 * time.Second
+)
+
+// LoadBalancerController allocates the LoadBalancer IPs of the services of type LoadBalancer from the configured pools
+// and publishes them in the status of the services, from where the routing controller advertises them to the BGP
+// peers like the LoadBalancer IPs set by any other provider.
+//
+// Only one kube-router instance allocates at a time, the one holding the kube-router-lballoc Lease. The allocations
+// live only in the status of the services: the addresses of deleted services, or of services that aren't of type
+// LoadBalancer anymore, are free again once they are gone from the status.
+type LoadBalancerController struct {
+	clientset       kubernetes.Interface
+	nodeName        string
+	namespace       string
+	pools           []*net.IPNet
+	defaultClass    bool
+	syncPeriod      time.Duration
+	syncRequestChan chan struct{}
+	logger          klog.Logger
+
+	svcLister cache.Indexer
+	// the Gateways get their addresses allocated too when they are enabled, see EnableGateways
+	gatewayLister      cache.Indexer
+	gatewayClassLister cache.Indexer
+	tcpRouteLister     cache.Indexer
+	udpRouteLister     cache.Indexer
+	gatewayClient      dynamic.Interface
+
+	ServiceEventHandler cache.ResourceEventHandler
+	// GatewayEventHandler is only set when the Gateway API resources are enabled
+	GatewayEventHandler cache.ResourceEventHandler
+}
+
+// Run allocates the LoadBalancer IPs of the services while this instance holds the Lease, standing by for the Lease
+// otherwise, till we receive notification on stopCh
+func (lbc *LoadBalancerController) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: leaseName, Namespace: lbc.namespace},
+		Client:     lbc.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: lbc.nodeName},
+	}
+	klog.Info("Starting load balancer IP allocation controller")
+	// RunOrDie returns when the Lease is lost, the instance then goes back to waiting for it
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   leaseRenewDeadline,
+			RetryPeriod:     leaseRetryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: lbc.allocate,
+				OnStoppedLeading: func() {
+					klog.Info("Stopped allocating LoadBalancer IPs, lost the lease " + lbc.namespace + "/" + leaseName)
+				},
+			},
+		})
+	}
+	klog.Info("Shutting down load balancer IP allocation controller")
+}
+
+// allocate syncs the LoadBalancer IPs of the services periodically and whenever a sync is requested, as long as the
+// context of the Lease is not done
+func (lbc *LoadBalancerController) allocate(ctx context.Context) {
+	klog.Info("Allocating LoadBalancer IPs, holding the lease " + lbc.namespace + "/" + leaseName)
+	t := time.NewTicker(lbc.syncPeriod)
+	defer t.Stop()
+	for {
+		lbc.sync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-lbc.syncRequestChan:
+		}
+	}
+}
+
+// RequestSync allows the request of a sync without blocking the callee
+func (lbc *LoadBalancerController) RequestSync() {
+	select {
+	case lbc.syncRequestChan <- struct{}{}:
+	default:
+	}
+}
+
+func (lbc *LoadBalancerController) sync(ctx context.Context) {
+	services := make([]*v1core.Service, 0)
+	for _, obj := range lbc.svcLister.List() {
+		if svc, ok := obj.(*v1core.Service); ok && svc.Spec.Type == v1core.ServiceTypeLoadBalancer {
+			services = append(services, svc)
+		}
+	}
+	// the oldest services get the addresses they ask for with spec.loadBalancerIP when several ask for the same one
+	sort.Slice(services, func(i, j int) bool {
+		if !services[i].CreationTimestamp.Equal(&services[j].CreationTimestamp) {
+			return services[i].CreationTimestamp.Before(&services[j].CreationTimestamp)
+		}
+		return services[i].Namespace+"/"+services[i].Name < services[j].Namespace+"/"+services[j].Name
+	})
+
+	used := make(map[string]bool)
+	for _, svc := range services {
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				used[ingress.IP] = true
+			}
+		}
+	}
+	gateways := lbc.gateways()
+	for _, gateway := range gateways {
+		for _, address := range utils.GatewayAddresses(gateway) {
+			used[address] = true
+		}
+	}
+
+	for _, svc := range services {
+		if !lbc.allocatesFor(svc) {
+			continue
+		}
+		ingress, err := allocateIngress(svc, lbc.pools, used)
+		if err != nil {
+			lbc.logger.Error(err, "Failed to allocate the LoadBalancer IPs of service",
+				utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
+		}
+		if ingressEqual(ingress, svc.Status.LoadBalancer.Ingress) {
+			continue
+		}
+		if err = lbc.updateIngress(ctx, svc, ingress); err != nil {
+			lbc.logger.Error(err, "Failed to update the LoadBalancer IPs of service",
+				utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
+			continue
+		}
+		lbc.logger.Info("Updated the LoadBalancer IPs of service",
+			utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name, "ips", ingressIPs(ingress))
+	}
+	lbc.syncGateways(ctx, gateways, used)
+}
+
+// allocatesFor returns whether the LoadBalancer IPs of the service are allocated by kube-router
+func (lbc *LoadBalancerController) allocatesFor(svc *v1core.Service) bool {
+	if svc.Spec.LoadBalancerClass == nil {
+		return lbc.defaultClass
+	}
+	return *svc.Spec.LoadBalancerClass == LoadBalancerClass
+}
+
+// updateIngress patches the LoadBalancer ingress of the status of the service, the whole list is replaced
+func (lbc *LoadBalancerController) updateIngress(ctx context.Context, svc *v1core.Service,
+	ingress []v1core.LoadBalancerIngress) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"loadBalancer": map[string]interface{}{
+				"ingress": ingress,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = lbc.clientset.CoreV1().Services(svc.Namespace).Patch(ctx, svc.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{}, "status")
+	return err
+}
+
+// allocateIngress returns the LoadBalancer ingress of the service: the allocated addresses it keeps, those that are
+// still in a pool, and an address from the pools for each IP family of the service that has none yet. The address
+// asked for with spec.loadBalancerIP is allocated when it is in a pool and free. The addresses it allocates are added
+// to used. The error is that of the last family no address could be allocated for.
+func allocateIngress(svc *v1core.Service, pools []*net.IPNet, used map[string]bool) ([]v1core.LoadBalancerIngress,
+	error) {
+	ingress := make([]v1core.LoadBalancerIngress, 0, len(svc.Spec.IPFamilies))
+	allocated := make(map[v1core.IPFamily]bool)
+	for _, existing := range svc.Status.LoadBalancer.Ingress {
+		ip := net.ParseIP(existing.IP)
+		if ip == nil || inPool(ip, pools) == nil {
+			continue
+		}
+		ingress = append(ingress, existing)
+		allocated[ipFamily(ip)] = true
+	}
+
+	families := svc.Spec.IPFamilies
+	if len(families) == 0 {
+		families = []v1core.IPFamily{v1core.IPv4Protocol}
+	}
+	var err error
+	for _, family := range families {
+		if allocated[family] {
+			continue
+		}
+		ip := requestedIP(svc, family, pools, used)
+		if ip == nil {
+			var familyErr error
+			if ip, familyErr = nextFreeIP(family, pools, used); familyErr != nil {
+				err = familyErr
+				continue
+			}
+		}
+		used[ip.String()] = true
+		ingress = append(ingress, v1core.LoadBalancerIngress{IP: ip.String()})
+	}
+	return ingress, err
+}
+
+// requestedIP returns the address of the family asked for with spec.loadBalancerIP when it is in a pool and free
+func requestedIP(svc *v1core.Service, family v1core.IPFamily, pools []*net.IPNet, used map[string]bool) net.IP {
+	ip := net.ParseIP(svc.Spec.LoadBalancerIP)
+	if ip == nil || ipFamily(ip) != family || used[ip.String()] || inPool(ip, pools) == nil {
+		return nil
+	}
+	return ip
+}
+
+// nextFreeIP returns the first address of the pools of the family that isn't used
+func nextFreeIP(family v1core.IPFamily, pools []*net.IPNet, used map[string]bool) (net.IP, error) {
+	hasPool := false
+	for _, pool := range pools {
+		if ipFamily(pool.IP) != family {
+			continue
+		}
+		hasPool = true
+		ones, bits := pool.Mask.Size()
+		last := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+		last.Sub(last, big.NewInt(1))
+		last.Or(last, new(big.Int).SetBytes(pool.IP))
+		for candidate := new(big.Int).SetBytes(pool.IP); candidate.Cmp(last) <= 0; candidate.Add(candidate,
+			big.NewInt(1)) {
+			ip := make(net.IP, len(pool.IP))
+			candidate.FillBytes(ip)
+			if !used[ip.String()] {
+				return ip, nil
+			}
+		}
+	}
+	if !hasPool {
+		return nil, fmt.Errorf("no pool of --loadbalancer-ip-range is of the %s family", family)
+	}
+	return nil, fmt.Errorf("the %s pools of --loadbalancer-ip-range have no free address left", family)
+}
+
+// inPool returns the pool the address is in, or nil
+func inPool(ip net.IP, pools []*net.IPNet) *net.IPNet {
+	for _, pool := range pools {
+		if pool.Contains(ip) {
+			return pool
+		}
+	}
+	return nil
+}
+
+func ipFamily(ip net.IP) v1core.IPFamily {
+	if ip.To4() != nil {
+		return v1core.IPv4Protocol
+	}
+	return v1core.IPv6Protocol
+}
+
+func ingressEqual(a, b []v1core.LoadBalancerIngress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].IP != b[i].IP || a[i].Hostname != b[i].Hostname {
+			return false
+		}
+	}
+	return true
+}
+
+func ingressIPs(ingress []v1core.LoadBalancerIngress) []string {
+	ips := make([]string, 0, len(ingress))
+	for _, i := range ingress {
+		ips = append(ips, i.IP)
+	}
+	return ips
+}
+
+// parsePools parses the CIDRs of the pools, normalizing the IPv4 ones to their 4 byte form
+func parsePools(cidrs []string) ([]*net.IPNet, error) {
+	pools := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, pool, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --loadbalancer-ip-range %q: %v", cidr, err)
+		}
+		if ip4 := pool.IP.To4(); ip4 != nil {
+			pool.IP = ip4
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+func (lbc *LoadBalancerController) newServiceEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			lbc.RequestSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			lbc.RequestSync()
+		},
+		// the addresses of the deleted services can go to the services waiting for one
+		DeleteFunc: func(obj interface{}) {
+			lbc.RequestSync()
+		},
+	}
+}
+
+// NewLoadBalancerController returns new LoadBalancerController object
+func NewLoadBalancerController(clientset kubernetes.Interface, config *options.KubeRouterConfig,
+	svcInformer cache.SharedIndexInformer) (*LoadBalancerController, error) {
+	if len(config.LoadBalancerCIDRs) == 0 {
+		return nil, errors.New("LoadBalancer IPs can only be allocated with --loadbalancer-ip-range")
+	}
+	if config.LoadBalancerSyncPeriod <= 0 {
+		return nil, errors.New("LoadBalancerSyncPeriod must be positive")
+	}
+	pools, err := parsePools(config.LoadBalancerCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	lbc := LoadBalancerController{
+		clientset:    clientset,
+		pools:        pools,
+		defaultClass: config.LoadBalancerDefaultClass,
+		syncPeriod:   config.LoadBalancerSyncPeriod,
+		logger:       utils.ControllerLogger("LBC"),
+	}
+	lbc.syncRequestChan = make(chan struct{}, 1)
+
+	// the Lease lives in the namespace of the kube-router pods when it is passed down to them
+	lbc.namespace = os.Getenv("POD_NAMESPACE")
+	if lbc.namespace == "" {
+		lbc.namespace = defaultLeaseNamespace
+	}
+	node, err := utils.GetNodeObject(clientset, config.HostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+	lbc.nodeName = node.Name
+
+	lbc.svcLister = svcInformer.GetIndexer()
+	lbc.ServiceEventHandler = lbc.newServiceEventHandler()
+
+	return &lbc, nil
+}
@@ -0,0 +1,109 @@
+package lballoc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+)
+
+func Test_allocateIngress(t *testing.T) {
+	pools, err := parsePools([]string{"192.0.2.0/30", "2001:db8::/126"})
+	assert.NoError(t, err)
+
+	testcases := []struct {
+		name      string
+		svc       *v1core.Service
+		used      []string
+		expected  []string
+		expectErr bool
+	}{
+		{
+			"first free address of the IPv4 pool",
+			&v1core.Service{},
+			[]string{"192.0.2.0", "192.0.2.1"},
+			[]string{"192.0.2.2"},
+			false,
+		},
+		{
+			"an address of each family for dual-stack services",
+			&v1core.Service{Spec: v1core.ServiceSpec{
+				IPFamilies: []v1core.IPFamily{v1core.IPv6Protocol, v1core.IPv4Protocol},
+			}},
+			nil,
+			[]string{"2001:db8::", "192.0.2.0"},
+			false,
+		},
+		{
+			"the allocated address is kept",
+			&v1core.Service{Status: v1core.ServiceStatus{LoadBalancer: v1core.LoadBalancerStatus{
+				Ingress: []v1core.LoadBalancerIngress{{IP: "192.0.2.3"}},
+			}}},
+			[]string{"192.0.2.3"},
+			[]string{"192.0.2.3"},
+			false,
+		},
+		{
+			"addresses out of the pools are released",
+			&v1core.Service{Status: v1core.ServiceStatus{LoadBalancer: v1core.LoadBalancerStatus{
+				Ingress: []v1core.LoadBalancerIngress{{IP: "198.51.100.1"}},
+			}}},
+			[]string{"198.51.100.1"},
+			[]string{"192.0.2.0"},
+			false,
+		},
+		{
+			"the requested address when it is free",
+			&v1core.Service{Spec: v1core.ServiceSpec{LoadBalancerIP: "192.0.2.2"}},
+			nil,
+			[]string{"192.0.2.2"},
+			false,
+		},
+		{
+			"another address when the requested one is used",
+			&v1core.Service{Spec: v1core.ServiceSpec{LoadBalancerIP: "192.0.2.2"}},
+			[]string{"192.0.2.2"},
+			[]string{"192.0.2.0"},
+			false,
+		},
+		{
+			"no address left",
+			&v1core.Service{},
+			[]string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3"},
+			[]string{},
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			used := make(map[string]bool)
+			for _, ip := range testcase.used {
+				used[ip] = true
+			}
+			ingress, err := allocateIngress(testcase.svc, pools, used)
+			if testcase.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testcase.expected, ingressIPs(ingress))
+			for _, ip := range testcase.expected {
+				assert.True(t, used[ip], "expected the allocated addresses to be marked as used")
+			}
+		})
+	}
+}
+
+func Test_allocatesFor(t *testing.T) {
+	otherClass := "example.com/lb"
+	class := LoadBalancerClass
+	lbc := &LoadBalancerController{defaultClass: true}
+	assert.True(t, lbc.allocatesFor(&v1core.Service{}))
+	assert.True(t, lbc.allocatesFor(&v1core.Service{Spec: v1core.ServiceSpec{LoadBalancerClass: &class}}))
+	assert.False(t, lbc.allocatesFor(&v1core.Service{Spec: v1core.ServiceSpec{LoadBalancerClass: &otherClass}}))
+
+	lbc.defaultClass = false
+	assert.False(t, lbc.allocatesFor(&v1core.Service{}))
+	assert.True(t, lbc.allocatesFor(&v1core.Service{Spec: v1core.ServiceSpec{LoadBalancerClass: &class}}))
+}
@@ -0,0 +1,598 @@
+package netpol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	anpv1alpha1 "github.com/cloudnativelabs/kube-router/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// The AdminNetworkPolicies are evaluated in the pod firewall chains before the network policies, in the order of their
+// priority, and the BaselineAdminNetworkPolicy after them in the directions no network policy selects the pod in. Each
+// of them has a chain per direction that its rules are evaluated in, in order:
+//   - Allow marks the traffic as allowed, like the network policies do, the traffic is then neither evaluated by the
+//     lower priority AdminNetworkPolicies nor dropped by the pod firewall chain
+//   - Pass marks the traffic as passed, it skips the lower priority AdminNetworkPolicies and is evaluated by the
+//     network policies, or by the baseline
+//   - Deny rejects the traffic
+const (
+	kubeAdminNetworkPolicyChainPrefix         = "KUBE-ANP-"
+	kubeBaselineAdminNetworkPolicyChainPrefix = "KUBE-BANP-"
+
+	adminPolicyPassMark = "0x40000/0x40000"
+	// adminPolicyUndecidedMark matches the traffic that is neither allowed nor passed yet
+	adminPolicyUndecidedMark = "0/0x50000"
+)
+
+// internal structure to represent an AdminNetworkPolicy, the BaselineAdminNetworkPolicy or a GlobalNetworkPolicy
+type adminNetworkPolicyInfo struct {
+	name     string
+	baseline bool
+	global   bool
+	priority int32
+
+	// set of pods matching the subject of the policy
+	subjectPods map[string]podInfo
+
+	ingressRules []adminPolicyRule
+	egressRules  []adminPolicyRule
+}
+
+// internal structure to represent an ingress or egress rule of an admin network policy
+type adminPolicyRule struct {
+	action string
+	// peerPods are the pods of the peers, peerIPBlocks the networks and the IPs of the nodes of the egress peers
+	peerPods      []podInfo
+	peerIPBlocks  [][]string
+	matchAllPeers bool
+	matchAllPorts bool
+	ports         []protocolAndPort
+	namedPorts    []endPoints
+}
+
+// EnableAdminNetworkPolicies makes the controller enforce the AdminNetworkPolicies and the BaselineAdminNetworkPolicy
+// of the informers, the nodes resolve the egress peers selecting nodes. It has to be called before Run.
+func (npc *NetworkPolicyController) EnableAdminNetworkPolicies(anpInformer, banpInformer,
+	nodeInformer cache.SharedIndexInformer) {
+	npc.anpLister = anpInformer.GetIndexer()
+	npc.banpLister = banpInformer.GetIndexer()
+	npc.nodeLister = nodeInformer.GetIndexer()
+	npc.AdminNetworkPolicyEventHandler = npc.newAdminNetworkPolicyEventHandler()
+	npc.AdminNetworkPolicyNodeEventHandler = npc.newAdminNetworkPolicyNodeEventHandler()
+}
+
+func (npc *NetworkPolicyController) newAdminNetworkPolicyEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			npc.RequestFullSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			npc.RequestFullSync()
+		},
+		DeleteFunc: func(obj interface{}) {
+			npc.RequestFullSync()
+		},
+	}
+}
+
+// newAdminNetworkPolicyNodeEventHandler syncs the network policies when the nodes the egress peers of the admin network
+// policies may select change
+func (npc *NetworkPolicyController) newAdminNetworkPolicyNodeEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			npc.RequestFullSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*api.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*api.Node)
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+				!reflect.DeepEqual(oldNode.Status.Addresses, newNode.Status.Addresses) {
+				npc.RequestFullSync()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			npc.RequestFullSync()
+		},
+	}
+}
+
+// buildAdminNetworkPoliciesInfo returns the GlobalNetworkPolicies and the AdminNetworkPolicies in the order they are
+// evaluated in followed by the BaselineAdminNetworkPolicy, the invalid policies are skipped
+func (npc *NetworkPolicyController) buildAdminNetworkPoliciesInfo() []adminNetworkPolicyInfo {
+	globalPolicies := npc.buildGlobalNetworkPoliciesInfo()
+	if npc.anpLister == nil {
+		return globalPolicies
+	}
+
+	adminPolicies := make([]adminNetworkPolicyInfo, 0)
+	for _, obj := range npc.anpLister.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		anp := &anpv1alpha1.AdminNetworkPolicy{}
+		if err := v1alpha1.FromUnstructured(u, anp); err != nil {
+			klog.Errorf("Failed to parse AdminNetworkPolicy %s: %v", u.GetName(), err)
+			continue
+		}
+		policy, err := npc.newAdminNetworkPolicyInfo(anp.Name, false, anp.Spec.Subject, anp.Spec.Ingress,
+			anp.Spec.Egress)
+		if err != nil {
+			klog.Errorf("Skipping AdminNetworkPolicy %s: %v", anp.Name, err)
+			continue
+		}
+		policy.priority = anp.Spec.Priority
+		adminPolicies = append(adminPolicies, policy)
+	}
+	// the order of the policies of the same priority is undefined, they are ordered by name to keep it stable
+	sort.Slice(adminPolicies, func(i, j int) bool {
+		if adminPolicies[i].priority != adminPolicies[j].priority {
+			return adminPolicies[i].priority < adminPolicies[j].priority
+		}
+		return adminPolicies[i].name < adminPolicies[j].name
+	})
+	adminPolicies = append(globalPolicies, adminPolicies...)
+
+	for _, obj := range npc.banpLister.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if u.GetName() != anpv1alpha1.BaselineAdminNetworkPolicyName {
+			klog.Warningf("Skipping BaselineAdminNetworkPolicy %s, the baseline has to be named %s", u.GetName(),
+				anpv1alpha1.BaselineAdminNetworkPolicyName)
+			continue
+		}
+		banp := &anpv1alpha1.BaselineAdminNetworkPolicy{}
+		if err := v1alpha1.FromUnstructured(u, banp); err != nil {
+			klog.Errorf("Failed to parse BaselineAdminNetworkPolicy %s: %v", u.GetName(), err)
+			continue
+		}
+		policy, err := npc.newAdminNetworkPolicyInfo(banp.Name, true, banp.Spec.Subject, banp.Spec.Ingress,
+			banp.Spec.Egress)
+		if err != nil {
+			klog.Errorf("Skipping BaselineAdminNetworkPolicy %s: %v", banp.Name, err)
+			continue
+		}
+		adminPolicies = append(adminPolicies, policy)
+	}
+
+	return adminPolicies
+}
+
+func (npc *NetworkPolicyController) newAdminNetworkPolicyInfo(name string, baseline bool,
+	subject anpv1alpha1.AdminNetworkPolicySubject, ingress []anpv1alpha1.AdminNetworkPolicyIngressRule,
+	egress []anpv1alpha1.AdminNetworkPolicyEgressRule) (adminNetworkPolicyInfo, error) {
+	policy := adminNetworkPolicyInfo{name: name, baseline: baseline, subjectPods: make(map[string]podInfo)}
+
+	subjectPods, err := npc.evalAdminPolicyPods(subject.Namespaces, subject.Pods)
+	if err != nil {
+		return policy, fmt.Errorf("invalid subject: %v", err)
+	}
+	// the named ports of the ingress rules are the ones of the pods of the subject
+	namedPort2IngressEps := make(namedPort2eps)
+	for _, pod := range subjectPods {
+		policy.subjectPods[pod.Status.PodIP] = newPodInfo(pod)
+		npc.grabNamedPortFromPod(pod, &namedPort2IngressEps)
+	}
+
+	for ruleIdx, specRule := range ingress {
+		if err := validateAdminPolicyAction(specRule.Action, baseline); err != nil {
+			return policy, fmt.Errorf("ingress rule %d: %v", ruleIdx, err)
+		}
+		rule := adminPolicyRule{action: specRule.Action}
+		for _, peer := range specRule.From {
+			peerPods, err := npc.evalAdminPolicyPods(peer.Namespaces, peer.Pods)
+			if err != nil {
+				return policy, fmt.Errorf("ingress rule %d: invalid peer: %v", ruleIdx, err)
+			}
+			for _, peerPod := range peerPods {
+				rule.peerPods = append(rule.peerPods, newPodInfo(peerPod))
+			}
+		}
+		rule.matchAllPorts = len(specRule.Ports) == 0
+		rule.ports, rule.namedPorts = npc.processNetworkPolicyPorts(adminPolicyPorts(specRule.Ports),
+			namedPort2IngressEps)
+		policy.ingressRules = append(policy.ingressRules, rule)
+	}
+
+	for ruleIdx, specRule := range egress {
+		if err := validateAdminPolicyAction(specRule.Action, baseline); err != nil {
+			return policy, fmt.Errorf("egress rule %d: %v", ruleIdx, err)
+		}
+		rule := adminPolicyRule{action: specRule.Action}
+		// the named ports of the egress rules are the ones of the pods of the peers
+		namedPort2EgressEps := make(namedPort2eps)
+		for _, peer := range specRule.To {
+			switch {
+			case peer.Nodes != nil:
+				nodeIPBlocks, err := npc.evalAdminPolicyNodes(peer.Nodes)
+				if err != nil {
+					return policy, fmt.Errorf("egress rule %d: invalid nodes peer: %v", ruleIdx, err)
+				}
+				rule.peerIPBlocks = append(rule.peerIPBlocks, nodeIPBlocks...)
+			case len(peer.Networks) != 0:
+				for _, network := range peer.Networks {
+					if _, _, err := net.ParseCIDR(network); err != nil {
+						return policy, fmt.Errorf("egress rule %d: invalid network %s", ruleIdx, network)
+					}
+					for _, cidr := range splitDefaultRoute(network) {
+						rule.peerIPBlocks = append(rule.peerIPBlocks, []string{cidr, utils.OptionTimeout, "0"})
+					}
+				}
+			default:
+				peerPods, err := npc.evalAdminPolicyPods(peer.Namespaces, peer.Pods)
+				if err != nil {
+					return policy, fmt.Errorf("egress rule %d: invalid peer: %v", ruleIdx, err)
+				}
+				for _, peerPod := range peerPods {
+					rule.peerPods = append(rule.peerPods, newPodInfo(peerPod))
+					npc.grabNamedPortFromPod(peerPod, &namedPort2EgressEps)
+				}
+			}
+		}
+		rule.matchAllPorts = len(specRule.Ports) == 0
+		rule.ports, rule.namedPorts = npc.processNetworkPolicyPorts(adminPolicyPorts(specRule.Ports),
+			namedPort2EgressEps)
+		policy.egressRules = append(policy.egressRules, rule)
+	}
+
+	return policy, nil
+}
+
+func validateAdminPolicyAction(action string, baseline bool) error {
+	switch action {
+	case anpv1alpha1.AdminNetworkPolicyRuleActionAllow, anpv1alpha1.AdminNetworkPolicyRuleActionDeny:
+		return nil
+	case anpv1alpha1.AdminNetworkPolicyRuleActionPass:
+		if !baseline {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid action %q", action)
+}
+
+// evalAdminPolicyPods returns the actionable pods of the namespaces, or the pods of the namespaced pod selector, of a
+// subject or peer
+func (npc *NetworkPolicyController) evalAdminPolicyPods(namespaces *v1.LabelSelector,
+	pods *anpv1alpha1.NamespacedPod) ([]*api.Pod, error) {
+	var namespaceSelector, podSelector labels.Selector
+	var err error
+	switch {
+	case namespaces != nil && pods == nil:
+		namespaceSelector, err = v1.LabelSelectorAsSelector(namespaces)
+		podSelector = labels.Everything()
+	case pods != nil && namespaces == nil:
+		if namespaceSelector, err = v1.LabelSelectorAsSelector(&pods.NamespaceSelector); err == nil {
+			podSelector, err = v1.LabelSelectorAsSelector(&pods.PodSelector)
+		}
+	default:
+		return nil, errors.New("exactly one of namespaces or pods has to be given")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	matchingNamespaces, err := npc.ListNamespaceByLabels(namespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+	matchingPods := make([]*api.Pod, 0)
+	for _, namespace := range matchingNamespaces {
+		namespacePods, err := npc.ListPodsByNamespaceAndLabels(namespace.Name, podSelector)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range namespacePods {
+			if isNetPolActionable(pod) {
+				matchingPods = append(matchingPods, pod)
+			}
+		}
+	}
+	return matchingPods, nil
+}
+
+// evalAdminPolicyNodes returns the internal and external IPs of the nodes matching the selector as ipBlock entries
+func (npc *NetworkPolicyController) evalAdminPolicyNodes(selector *v1.LabelSelector) ([][]string, error) {
+	nodeSelector, err := v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := listers.NewNodeLister(npc.nodeLister).List(nodeSelector)
+	if err != nil {
+		return nil, err
+	}
+	ipBlocks := make([][]string, 0)
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			if address.Type != api.NodeInternalIP && address.Type != api.NodeExternalIP {
+				continue
+			}
+			if net.ParseIP(address.Address) == nil {
+				continue
+			}
+			ipBlocks = append(ipBlocks, []string{address.Address, utils.OptionTimeout, "0"})
+		}
+	}
+	return ipBlocks, nil
+}
+
+// adminPolicyPorts returns the ports of an admin network policy rule as the ports of a network policy, the protocol
+// defaults to TCP
+func adminPolicyPorts(ports []anpv1alpha1.AdminNetworkPolicyPort) []networking.NetworkPolicyPort {
+	npPorts := make([]networking.NetworkPolicyPort, 0, len(ports))
+	for _, port := range ports {
+		switch {
+		case port.PortNumber != nil:
+			protocol := port.PortNumber.Protocol
+			if protocol == "" {
+				protocol = api.ProtocolTCP
+			}
+			portNumber := intstr.FromInt(int(port.PortNumber.Port))
+			npPorts = append(npPorts, networking.NetworkPolicyPort{Protocol: &protocol, Port: &portNumber})
+		case port.PortRange != nil:
+			protocol := port.PortRange.Protocol
+			if protocol == "" {
+				protocol = api.ProtocolTCP
+			}
+			start, end := intstr.FromInt(int(port.PortRange.Start)), port.PortRange.End
+			npPorts = append(npPorts, networking.NetworkPolicyPort{Protocol: &protocol, Port: &start, EndPort: &end})
+		case port.NamedPort != nil:
+			namedPort := intstr.FromString(*port.NamedPort)
+			npPorts = append(npPorts, networking.NetworkPolicyPort{Port: &namedPort})
+		}
+	}
+	return npPorts
+}
+
+// syncAdminNetworkPolicyChains writes the chains of the admin network policies, each of them has a chain per direction
+// it has rules for
+func (npc *NetworkPolicyController) syncAdminNetworkPolicyChains(adminPoliciesInfo []adminNetworkPolicyInfo,
+	version string, activePolicyChains, activePolicyIPSets map[string]bool) {
+	for _, policy := range adminPoliciesInfo {
+		for _, direction := range []string{kubeIngressPolicyType, kubeEgressPolicyType} {
+			rules := policy.ingressRules
+			if direction == kubeEgressPolicyType {
+				rules = policy.egressRules
+			}
+			if len(rules) == 0 {
+				continue
+			}
+			chainName := adminNetworkPolicyChainName(policy, direction, version)
+			activePolicyChains[chainName] = true
+
+			for _, ipFamily := range npc.ipFamilies {
+				npc.filterTableRules[ipFamily].WriteString(":" + chainName + "\n")
+				for ruleIdx, rule := range rules {
+					npc.appendAdminPolicyRules(policy, chainName, direction, ruleIdx, rule, activePolicyIPSets,
+						ipFamily)
+				}
+			}
+		}
+	}
+}
+
+// appendAdminPolicyRules appends the rules of an admin network policy rule to the chain of its direction, the peers are
+// matched on the source of the ingress traffic and on the destination of the egress traffic
+func (npc *NetworkPolicyController) appendAdminPolicyRules(policy adminNetworkPolicyInfo, chainName, direction string,
+	ruleIdx int, rule adminPolicyRule, activePolicyIPSets map[string]bool, ipFamily api.IPFamily) {
+	peerMatch := "src"
+	if direction == kubeEgressPolicyType {
+		peerMatch = "dst"
+	}
+
+	peerSets := make([]string, 0, 2)
+	if len(rule.peerPods) != 0 {
+		peerPodIPSetName := adminPolicyIndexedPeerIPSetName(policy, direction, ruleIdx, "pod")
+		npc.createPolicyIndexedIPSet(activePolicyIPSets, peerPodIPSetName, utils.TypeHashIP,
+			getIPsFromPods(rule.peerPods), ipFamily)
+		peerSets = append(peerSets, peerPodIPSetName)
+	}
+	peerIPBlockIPSetName := ""
+	if len(rule.peerIPBlocks) != 0 {
+		peerIPBlockIPSetName = adminPolicyIndexedPeerIPSetName(policy, direction, ruleIdx, "ipblock")
+		activePolicyIPSets[peerIPBlockIPSetName] = true
+		npc.refreshIPBlockIPSet(peerIPBlockIPSetName, rule.peerIPBlocks, ipFamily)
+		peerSets = append(peerSets, peerIPBlockIPSetName)
+	}
+
+	namedPortIPSetNames := make([]string, 0, len(rule.namedPorts))
+	for portIdx, eps := range rule.namedPorts {
+		namedPortIPSetName := adminPolicyIndexedNamedPortIPSetName(policy, direction, ruleIdx, portIdx)
+		npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips, ipFamily)
+		namedPortIPSetNames = append(namedPortIPSetNames, namedPortIPSetName)
+	}
+
+	// the rules matching all peers are written without a peer match
+	if rule.matchAllPeers {
+		peerSets = append(peerSets, "")
+	}
+
+	comment := "rule to " + strings.ToUpper(rule.action) + " " + direction + " traffic of " +
+		adminPolicyDescription(policy) + " rule " + strconv.Itoa(ruleIdx)
+	for _, peerSet := range peerSets {
+		var match []string
+		if peerSet != "" {
+			match = []string{"-m", "set", "--match-set", ipSetName(peerSet, ipFamily), peerMatch}
+		}
+		if rule.matchAllPorts {
+			npc.appendAdminPolicyRule(policy, chainName, comment, match, "", "", "", rule.action, ipFamily)
+			continue
+		}
+		for _, port := range rule.ports {
+			npc.appendAdminPolicyRule(policy, chainName, comment, match, port.protocol, port.port, port.endport,
+				rule.action, ipFamily)
+		}
+		// the networks and nodes have no named ports
+		if peerSet != "" && peerSet == peerIPBlockIPSetName {
+			continue
+		}
+		for portIdx, eps := range rule.namedPorts {
+			namedPortMatch := append(append([]string{}, match...),
+				"-m", "set", "--match-set", ipSetName(namedPortIPSetNames[portIdx], ipFamily), "dst")
+			npc.appendAdminPolicyRule(policy, chainName, comment, namedPortMatch, eps.protocol, eps.port, eps.endport,
+				rule.action, ipFamily)
+		}
+	}
+}
+
+func (npc *NetworkPolicyController) appendAdminPolicyRule(policy adminNetworkPolicyInfo, chainName, comment string,
+	match []string, protocol, dPort, endDport, action string, ipFamily api.IPFamily) {
+	if dPort != "" && strings.EqualFold(protocol, "SCTP") && !npc.sctpPortMatch {
+		klog.Warningf("Not matching SCTP port %s in %s as the kernel lacks the SCTP match of iptables", dPort,
+			adminPolicyDescription(policy))
+		return
+	}
+
+	args := []string{"-A", chainName, "-m", "comment", "--comment", "\"" + comment + "\""}
+	args = append(args, match...)
+	if protocol != "" {
+		args = append(args, "-p", protocol)
+	}
+	if dPort != "" {
+		if endDport != "" {
+			args = append(args, "--dport", dPort+":"+endDport)
+		} else {
+			args = append(args, "--dport", dPort)
+		}
+	}
+
+	switch action {
+	case anpv1alpha1.AdminNetworkPolicyRuleActionAllow:
+		//nolint:gocritic // we want to append to a separate array here so that we can re-use args below
+		markArgs := append(args, "-j", "MARK", "--set-xmark", "0x10000/0x10000", "\n")
+		npc.filterTableRules[ipFamily].WriteString(strings.Join(markArgs, " "))
+		args = append(args, "-m", "mark", "--mark", "0x10000/0x10000", "-j", "RETURN", "\n")
+	case anpv1alpha1.AdminNetworkPolicyRuleActionPass:
+		//nolint:gocritic // we want to append to a separate array here so that we can re-use args below
+		markArgs := append(args, "-j", "MARK", "--set-xmark", adminPolicyPassMark, "\n")
+		npc.filterTableRules[ipFamily].WriteString(strings.Join(markArgs, " "))
+		args = append(args, "-m", "mark", "--mark", adminPolicyPassMark, "-j", "RETURN", "\n")
+	case anpv1alpha1.AdminNetworkPolicyRuleActionDeny:
+		// the denied traffic is logged like the traffic the pod firewall chains drop
+		//nolint:gocritic // we want to append to a separate array here so that we can re-use args below
+		logArgs := append(args, npc.nflog().dropTarget()...)
+		npc.filterTableRules[ipFamily].WriteString(strings.Join(append(logArgs, "\n"), " "))
+		for _, denyRule := range npc.denyAction.protocolRules(protocol, ipFamily) {
+			//nolint:gocritic // we want to append to a separate array here so that we can re-use args below
+			denyArgs := append(args, denyRule...)
+			npc.filterTableRules[ipFamily].WriteString(strings.Join(append(denyArgs, "\n"), " "))
+		}
+		return
+	}
+	npc.filterTableRules[ipFamily].WriteString(strings.Join(args, " "))
+}
+
+// setupPodAdminNetpolRules inserts the rules jumping to the chains of the admin network policies selecting the pod at
+// the top of its firewall chain, the AdminNetworkPolicies are evaluated until one allowed or passed the traffic and the
+// baseline only in the directions no network policy selects the pod in, as long as the traffic isn't allowed yet
+func (npc *NetworkPolicyController) setupPodAdminNetpolRules(filterTableRules *bytes.Buffer, pod podInfo,
+	podFwChainName string, adminPoliciesInfo []adminNetworkPolicyInfo, hasIngressPolicy, hasEgressPolicy bool,
+	version string, ipFamily api.IPFamily) {
+	podIP := pod.ipOfFamily(ipFamily)
+
+	// the rules are inserted at the top of the chain, so the baseline goes first and the AdminNetworkPolicies follow
+	// in the reverse order of their priority
+	for i := len(adminPoliciesInfo) - 1; i >= 0; i-- {
+		policy := adminPoliciesInfo[i]
+		if _, ok := policy.subjectPods[pod.ip]; !ok {
+			continue
+		}
+		undecidedMark := adminPolicyUndecidedMark
+		if policy.baseline {
+			undecidedMark = "0/0x10000"
+		}
+		for _, direction := range []string{kubeEgressPolicyType, kubeIngressPolicyType} {
+			rules, addrMatch, hasPolicy := policy.ingressRules, "-d", hasIngressPolicy
+			if direction == kubeEgressPolicyType {
+				rules, addrMatch, hasPolicy = policy.egressRules, "-s", hasEgressPolicy
+			}
+			if len(rules) == 0 || (policy.baseline && hasPolicy) {
+				continue
+			}
+			comment := "\"run through " + direction + " rules of " + adminPolicyDescription(policy) + "\""
+			args := []string{"-I", podFwChainName, "1", addrMatch, podIP, "-m", "comment", "--comment", comment,
+				"-m", "mark", "--mark", undecidedMark, "-j", adminNetworkPolicyChainName(policy, direction, version), "\n"}
+			filterTableRules.WriteString(strings.Join(args, " "))
+		}
+	}
+}
+
+func adminNetworkPolicyChainName(policy adminNetworkPolicyInfo, direction, version string) string {
+	prefix := kubeAdminNetworkPolicyChainPrefix
+	switch {
+	case policy.baseline:
+		prefix = kubeBaselineAdminNetworkPolicyChainPrefix
+	case policy.global:
+		prefix = kubeGlobalNetworkPolicyChainPrefix
+	}
+	hash := sha256.Sum256([]byte(policy.name + direction + version))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+	return prefix + encoded[:16]
+}
+
+func adminPolicyIndexedPeerIPSetName(policy adminNetworkPolicyInfo, direction string, ruleNo int,
+	peerKind string) string {
+	prefix := kubeSourceIPSetPrefix
+	if direction == kubeEgressPolicyType {
+		prefix = kubeDestinationIPSetPrefix
+	}
+	hash := sha256.Sum256([]byte(adminPolicyKind(policy) + policy.name + direction + "rule" + strconv.Itoa(ruleNo) +
+		peerKind))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+	return prefix + encoded[:16]
+}
+
+func adminPolicyIndexedNamedPortIPSetName(policy adminNetworkPolicyInfo, direction string, ruleNo,
+	namedPortNo int) string {
+	hash := sha256.Sum256([]byte(adminPolicyKind(policy) + policy.name + direction + "rule" + strconv.Itoa(ruleNo) +
+		strconv.Itoa(namedPortNo) + "namedport"))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+	return kubeDestinationIPSetPrefix + encoded[:16]
+}
+
+func adminPolicyKind(policy adminNetworkPolicyInfo) string {
+	switch {
+	case policy.baseline:
+		return "baselineadminnetworkpolicy"
+	case policy.global:
+		return "globalnetworkpolicy"
+	}
+	return "adminnetworkpolicy"
+}
+
+// adminPolicyDescription describes the policy in the comments of its rules
+func adminPolicyDescription(policy adminNetworkPolicyInfo) string {
+	switch {
+	case policy.baseline:
+		return "baseline admin network policy"
+	case policy.global:
+		return "global network policy " + policy.name
+	}
+	return "admin network policy " + policy.name
+}
@@ -0,0 +1,198 @@
+package netpol
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	anpv1alpha1 "github.com/cloudnativelabs/kube-router/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func tNewAdminPolicyIndexer(t *testing.T, objs ...interface{}) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, obj := range objs {
+		u, err := v1alpha1.ToUnstructured(obj)
+		assert.NoError(t, err)
+		assert.NoError(t, indexer.Add(u))
+	}
+	return indexer
+}
+
+func Test_buildAdminNetworkPoliciesInfo(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	_, podInformer, nsInformer, npInformer := newFakeInformersFromClient(client)
+	tCreateFakePods(t, podInformer, nsInformer)
+	npc := newUneventfulNetworkPolicyController(podInformer, npInformer, nsInformer)
+
+	teamA := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+	http := "http"
+	anps := []interface{}{
+		&anpv1alpha1.AdminNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "low"},
+			Spec: anpv1alpha1.AdminNetworkPolicySpec{Priority: 50, Subject: anpv1alpha1.AdminNetworkPolicySubject{
+				Namespaces: &metav1.LabelSelector{}}}},
+		&anpv1alpha1.AdminNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "high"},
+			Spec: anpv1alpha1.AdminNetworkPolicySpec{Priority: 10,
+				Subject: anpv1alpha1.AdminNetworkPolicySubject{Pods: &anpv1alpha1.NamespacedPod{
+					NamespaceSelector: *teamA,
+					PodSelector:       metav1.LabelSelector{MatchLabels: map[string]string{"component": "a"}}}},
+				Ingress: []anpv1alpha1.AdminNetworkPolicyIngressRule{{Action: "Pass",
+					From: []anpv1alpha1.AdminNetworkPolicyIngressPeer{{Namespaces: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"name": "c"}}}}}},
+				Egress: []anpv1alpha1.AdminNetworkPolicyEgressRule{{Action: "Deny",
+					To: []anpv1alpha1.AdminNetworkPolicyEgressPeer{{Networks: []string{"0.0.0.0/0"}}},
+					Ports: []anpv1alpha1.AdminNetworkPolicyPort{{NamedPort: &http},
+						{PortRange: &anpv1alpha1.PortRange{Start: 8000, End: 8080}}}}}}},
+		&anpv1alpha1.AdminNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+			Spec: anpv1alpha1.AdminNetworkPolicySpec{Subject: anpv1alpha1.AdminNetworkPolicySubject{
+				Namespaces: teamA, Pods: &anpv1alpha1.NamespacedPod{}}}},
+	}
+	banps := []interface{}{
+		&anpv1alpha1.BaselineAdminNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: anpv1alpha1.BaselineAdminNetworkPolicySpec{Subject: anpv1alpha1.AdminNetworkPolicySubject{
+				Namespaces: teamA}}},
+		&anpv1alpha1.BaselineAdminNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "other"},
+			Spec: anpv1alpha1.BaselineAdminNetworkPolicySpec{Subject: anpv1alpha1.AdminNetworkPolicySubject{
+				Namespaces: teamA}}},
+	}
+	npc.anpLister = tNewAdminPolicyIndexer(t, anps...)
+	npc.banpLister = tNewAdminPolicyIndexer(t, banps...)
+
+	adminPolicies := npc.buildAdminNetworkPoliciesInfo()
+	names := make([]string, 0, len(adminPolicies))
+	for _, policy := range adminPolicies {
+		names = append(names, policy.name)
+	}
+	assert.Equal(t, []string{"high", "low", "default"}, names,
+		"expected the policies in the order of their priority followed by the baseline, without the invalid ones")
+
+	high := adminPolicies[0]
+	assert.Equal(t, []string{"Aaa", "Baa"}, tSortedPodNames(high.subjectPods))
+	assert.Len(t, high.ingressRules, 1)
+	assert.Equal(t, []string{"Ca"}, tSortedPodNames(tPodsByIP(high.ingressRules[0].peerPods)))
+	assert.Len(t, high.egressRules, 1)
+	assert.Equal(t, [][]string{{"0.0.0.0/1", utils.OptionTimeout, "0"}, {"128.0.0.0/1", utils.OptionTimeout, "0"}},
+		high.egressRules[0].peerIPBlocks)
+	assert.Equal(t, []protocolAndPort{{protocol: "TCP", port: "8000", endport: "8080"}}, high.egressRules[0].ports,
+		"expected the protocol of the ports to default to TCP")
+	assert.Empty(t, high.egressRules[0].namedPorts, "expected named ports not to resolve on networks")
+
+	assert.Len(t, adminPolicies[1].subjectPods, 8)
+	assert.True(t, adminPolicies[2].baseline)
+	assert.Len(t, adminPolicies[2].subjectPods, 7)
+}
+
+func Test_validateAdminPolicyAction(t *testing.T) {
+	assert.NoError(t, validateAdminPolicyAction("Pass", false))
+	assert.Error(t, validateAdminPolicyAction("Pass", true), "expected the baseline not to pass")
+	assert.Error(t, validateAdminPolicyAction("Accept", false))
+}
+
+func Test_appendAdminPolicyRules(t *testing.T) {
+	npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules(),
+		ipSetHandlers: map[api.IPFamily]*utils.IPSet{api.IPv4Protocol: {Sets: map[string]*utils.Set{}}}}
+	policy := adminNetworkPolicyInfo{name: "cluster-control"}
+	activePolicyIPSets := make(map[string]bool)
+
+	npc.appendAdminPolicyRules(policy, "KUBE-ANP-TEST", kubeIngressPolicyType, 0, adminPolicyRule{action: "Allow",
+		peerPods: []podInfo{{ip: "10.1.2.1"}}, matchAllPorts: true}, activePolicyIPSets, api.IPv4Protocol)
+	npc.appendAdminPolicyRules(policy, "KUBE-ANP-TEST", kubeIngressPolicyType, 1, adminPolicyRule{action: "Pass",
+		peerPods: []podInfo{{ip: "10.1.2.2"}}, ports: []protocolAndPort{{protocol: "TCP", port: "80"}}},
+		activePolicyIPSets, api.IPv4Protocol)
+	npc.appendAdminPolicyRules(policy, "KUBE-ANP-TEST", kubeEgressPolicyType, 0, adminPolicyRule{action: "Deny",
+		peerPods: []podInfo{{ip: "10.1.2.3"}}, peerIPBlocks: [][]string{{"192.168.0.0/16", utils.OptionTimeout, "0"}},
+		namedPorts: []endPoints{{ips: []string{"10.1.2.3"}, protocolAndPort: protocolAndPort{protocol: "TCP",
+			port: "8080"}}}}, activePolicyIPSets, api.IPv4Protocol)
+	rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+	allowPeers := adminPolicyIndexedPeerIPSetName(policy, kubeIngressPolicyType, 0, "pod")
+	assert.Contains(t, rules, "--match-set "+allowPeers+" src -j MARK --set-xmark 0x10000/0x10000")
+	assert.Contains(t, rules, "--match-set "+allowPeers+" src -m mark --mark 0x10000/0x10000 -j RETURN")
+
+	passPeers := adminPolicyIndexedPeerIPSetName(policy, kubeIngressPolicyType, 1, "pod")
+	assert.Contains(t, rules, "--match-set "+passPeers+" src -p TCP --dport 80 -j MARK --set-xmark 0x40000/0x40000")
+	assert.Contains(t, rules, "--match-set "+passPeers+" src -p TCP --dport 80 -m mark --mark 0x40000/0x40000 -j RETURN")
+
+	denyPeers := adminPolicyIndexedPeerIPSetName(policy, kubeEgressPolicyType, 0, "pod")
+	denyNetworks := adminPolicyIndexedPeerIPSetName(policy, kubeEgressPolicyType, 0, "ipblock")
+	namedPort := adminPolicyIndexedNamedPortIPSetName(policy, kubeEgressPolicyType, 0, 0)
+	assert.Contains(t, rules, "--match-set "+denyPeers+" dst -m set --match-set "+namedPort+" dst -p TCP --dport 8080 "+
+		"-j REJECT")
+	assert.NotContains(t, rules, "--match-set "+denyNetworks+" dst", "expected named ports not to match networks")
+	assert.Equal(t, 1, strings.Count(rules, "-j NFLOG"), "expected the denied traffic to be logged")
+
+	for _, set := range []string{allowPeers, passPeers, denyPeers, denyNetworks, namedPort} {
+		assert.True(t, activePolicyIPSets[set], "expected ipset %s to be active", set)
+		assert.NotNil(t, npc.ipSetHandlers[api.IPv4Protocol].Get(set))
+	}
+}
+
+func Test_setupPodAdminNetpolRules(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", name: "test-pod", namespace: "test-ns"}
+	rules := []adminPolicyRule{{action: "Allow", matchAllPorts: true}}
+	selected := map[string]podInfo{pod.ip: pod}
+	adminPolicies := []adminNetworkPolicyInfo{
+		{name: "high", priority: 10, subjectPods: selected, ingressRules: rules, egressRules: rules},
+		{name: "unrelated", priority: 20, subjectPods: map[string]podInfo{}, ingressRules: rules},
+		{name: "low", priority: 30, subjectPods: selected, ingressRules: rules},
+		{name: "default", baseline: true, subjectPods: selected, ingressRules: rules, egressRules: rules},
+	}
+	networkPolicies := []networkPolicyInfo{{name: "ingress", namespace: "test-ns", policyType: kubeIngressPolicyType,
+		targetPods: selected}}
+
+	npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules()}
+	npc.setupPodNetpolRules(npc.filterTableRules[api.IPv4Protocol], pod,
+		"KUBE-POD-FW-TEST", networkPolicies, adminPolicies, "1", api.IPv4Protocol)
+
+	// the rules are inserted at the top of the chain, the last one written is the first one of the chain
+	var jumps []string
+	for _, rule := range strings.Split(npc.filterTableRules[api.IPv4Protocol].String(), "\n") {
+		if fields := strings.Fields(rule); len(fields) > 0 {
+			jump := fields[len(fields)-1]
+			if strings.HasPrefix(jump, kubeAdminNetworkPolicyChainPrefix) ||
+				strings.HasPrefix(jump, kubeBaselineAdminNetworkPolicyChainPrefix) ||
+				strings.HasPrefix(jump, kubeNetworkPolicyChainPrefix) {
+				jumps = append([]string{jump}, jumps...)
+			}
+		}
+	}
+	assert.Equal(t, []string{
+		adminNetworkPolicyChainName(adminPolicies[0], kubeIngressPolicyType, "1"),
+		adminNetworkPolicyChainName(adminPolicies[0], kubeEgressPolicyType, "1"),
+		adminNetworkPolicyChainName(adminPolicies[2], kubeIngressPolicyType, "1"),
+		adminNetworkPolicyChainName(adminPolicies[3], kubeEgressPolicyType, "1"),
+		kubeDefaultNetpolChain,
+		networkPolicyChainName("test-ns", "ingress", "1"),
+	}, jumps, "expected the admin network policies in order, then the baseline egress rules only as a network "+
+		"policy selects the pod for ingress, then the network policies")
+
+	rule := "-d 10.1.1.1 -m comment --comment \"run through ingress rules of admin network policy high\" " +
+		"-m mark --mark 0/0x50000 -j " + adminNetworkPolicyChainName(adminPolicies[0], kubeIngressPolicyType, "1")
+	assert.Contains(t, npc.filterTableRules[api.IPv4Protocol].String(), rule)
+	rule = "-s 10.1.1.1 -m comment --comment \"run through egress rules of baseline admin network policy\" " +
+		"-m mark --mark 0/0x10000 -j " + adminNetworkPolicyChainName(adminPolicies[3], kubeEgressPolicyType, "1")
+	assert.Contains(t, npc.filterTableRules[api.IPv4Protocol].String(), rule)
+}
+
+func tPodsByIP(pods []podInfo) map[string]podInfo {
+	byIP := make(map[string]podInfo, len(pods))
+	for _, pod := range pods {
+		byIP[pod.ip] = pod
+	}
+	return byIP
+}
+
+func tSortedPodNames(pods map[string]podInfo) []string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,113 @@
+package netpol
+
+import (
+	"fmt"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+)
+
+const (
+	// netpolDenyActionAnnotation overrides --netpol-deny-action for the traffic of the pods the network policy selects
+	netpolDenyActionAnnotation = "kube-router.io/deny-action"
+
+	denyActionDrop   = "DROP"
+	denyActionReject = "REJECT"
+	rejectTCPReset   = "tcp-reset"
+)
+
+// rejectWithIPv6 maps the ICMP types that REJECT can answer with to their ICMPv6 equivalents
+var rejectWithIPv6 = map[string]string{
+	"icmp-net-unreachable":   "icmp6-no-route",
+	"icmp-host-unreachable":  "icmp6-addr-unreachable",
+	"icmp-port-unreachable":  "icmp6-port-unreachable",
+	"icmp-proto-unreachable": "icmp6-port-unreachable",
+	"icmp-net-prohibited":    "icmp6-adm-prohibited",
+	"icmp-host-prohibited":   "icmp6-adm-prohibited",
+	"icmp-admin-prohibited":  "icmp6-adm-prohibited",
+	rejectTCPReset:           rejectTCPReset,
+}
+
+// denyAction is the verdict of the traffic that the network policies don't allow, REJECT answers with the ICMP
+// port unreachable error unless rejectWith is given. The zero value rejects.
+type denyAction struct {
+	target     string
+	rejectWith string
+}
+
+// parseDenyAction parses DROP, REJECT or REJECT:<type> where the type is one the ICMP types of rejectWithIPv6 or
+// tcp-reset
+func parseDenyAction(value string) (denyAction, error) {
+	target, rejectWith, _ := strings.Cut(value, ":")
+	action := denyAction{target: strings.ToUpper(target), rejectWith: strings.ToLower(rejectWith)}
+	switch {
+	case action.target == denyActionDrop && action.rejectWith == "":
+		return action, nil
+	case action.target == denyActionReject:
+		if _, ok := rejectWithIPv6[action.rejectWith]; ok || action.rejectWith == "" {
+			return action, nil
+		}
+	}
+	return denyAction{}, fmt.Errorf("invalid deny action %q, expected DROP, REJECT or REJECT:<type>", value)
+}
+
+// verdict returns DROP or REJECT
+func (a denyAction) verdict() string {
+	if a.target == denyActionDrop {
+		return denyActionDrop
+	}
+	return denyActionReject
+}
+
+// rules returns the matches and targets of the rules that deny the traffic in the address family. Rejecting with a
+// TCP reset only applies to TCP, the traffic of the other protocols is rejected with the default ICMP error.
+func (a denyAction) rules(ipFamily api.IPFamily) [][]string {
+	switch {
+	case a.target == denyActionDrop:
+		return [][]string{{"-j", denyActionDrop}}
+	case a.rejectWith == "":
+		return [][]string{{"-j", denyActionReject}}
+	case a.rejectWith == rejectTCPReset:
+		return [][]string{{"-p", "tcp", "-j", denyActionReject, "--reject-with", rejectTCPReset},
+			{"-j", denyActionReject}}
+	case ipFamily == api.IPv6Protocol:
+		return [][]string{{"-j", denyActionReject, "--reject-with", rejectWithIPv6[a.rejectWith]}}
+	}
+	return [][]string{{"-j", denyActionReject, "--reject-with", a.rejectWith}}
+}
+
+// protocolRules returns the targets of the rules that deny the traffic of the protocol in the address family, or
+// the matches and targets of the traffic of all protocols when the protocol isn't given
+func (a denyAction) protocolRules(protocol string, ipFamily api.IPFamily) [][]string {
+	if protocol == "" || a.rejectWith != rejectTCPReset {
+		return a.rules(ipFamily)
+	}
+	if strings.EqualFold(protocol, "TCP") {
+		return [][]string{{"-j", denyActionReject, "--reject-with", rejectTCPReset}}
+	}
+	return [][]string{{"-j", denyActionReject}}
+}
+
+// podDenyAction returns the deny action of the traffic of the pod, the one of the network policies selecting it that
+// override it, or --netpol-deny-action. When the policies disagree the traffic is dropped, as the most conservative
+// choice.
+func (npc *NetworkPolicyController) podDenyAction(pod podInfo, networkPoliciesInfo []networkPolicyInfo) denyAction {
+	var podAction *denyAction
+	for i := range networkPoliciesInfo {
+		policy := &networkPoliciesInfo[i]
+		if policy.denyAction == nil {
+			continue
+		}
+		if _, ok := policy.targetPods[pod.ip]; !ok {
+			continue
+		}
+		if podAction != nil && *podAction != *policy.denyAction {
+			return denyAction{target: denyActionDrop}
+		}
+		podAction = policy.denyAction
+	}
+	if podAction != nil {
+		return *podAction
+	}
+	return npc.denyAction
+}
@@ -0,0 +1,69 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+)
+
+func Test_parseDenyAction(t *testing.T) {
+	for value, expected := range map[string]denyAction{
+		"DROP":                         {target: "DROP"},
+		"reject":                       {target: "REJECT"},
+		"REJECT:icmp-admin-prohibited": {target: "REJECT", rejectWith: "icmp-admin-prohibited"},
+		"REJECT:tcp-reset":             {target: "REJECT", rejectWith: "tcp-reset"},
+	} {
+		action, err := parseDenyAction(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, action, value)
+	}
+	for _, value := range []string{"", "ACCEPT", "DROP:icmp-admin-prohibited", "REJECT:icmp6-adm-prohibited"} {
+		_, err := parseDenyAction(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func Test_denyActionRules(t *testing.T) {
+	assert.Equal(t, [][]string{{"-j", "REJECT"}}, denyAction{}.rules(api.IPv4Protocol),
+		"expected the zero value to reject")
+	assert.Equal(t, [][]string{{"-j", "DROP"}}, denyAction{target: "DROP"}.rules(api.IPv6Protocol))
+
+	prohibited := denyAction{target: "REJECT", rejectWith: "icmp-admin-prohibited"}
+	assert.Equal(t, [][]string{{"-j", "REJECT", "--reject-with", "icmp-admin-prohibited"}},
+		prohibited.rules(api.IPv4Protocol))
+	assert.Equal(t, [][]string{{"-j", "REJECT", "--reject-with", "icmp6-adm-prohibited"}},
+		prohibited.rules(api.IPv6Protocol))
+
+	reset := denyAction{target: "REJECT", rejectWith: "tcp-reset"}
+	assert.Equal(t, [][]string{{"-p", "tcp", "-j", "REJECT", "--reject-with", "tcp-reset"}, {"-j", "REJECT"}},
+		reset.rules(api.IPv6Protocol), "expected only TCP to be reset")
+	assert.Equal(t, [][]string{{"-j", "REJECT", "--reject-with", "tcp-reset"}}, reset.protocolRules("TCP",
+		api.IPv4Protocol))
+	assert.Equal(t, [][]string{{"-j", "REJECT"}}, reset.protocolRules("UDP", api.IPv4Protocol))
+}
+
+func Test_podDenyAction(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", name: "payments", namespace: "payments"}
+	selected := map[string]podInfo{pod.ip: pod}
+	drop, prohibited := denyAction{target: "DROP"}, denyAction{target: "REJECT", rejectWith: "icmp-admin-prohibited"}
+	npc := &NetworkPolicyController{denyAction: prohibited, filterTableRules: tNewFilterTableRules(),
+		ipFamilies: []api.IPFamily{api.IPv4Protocol}}
+
+	assert.Equal(t, prohibited, npc.podDenyAction(pod, []networkPolicyInfo{{targetPods: selected}}),
+		"expected the deny action of the flag without annotation")
+	assert.Equal(t, prohibited, npc.podDenyAction(pod, []networkPolicyInfo{{denyAction: &drop}}),
+		"expected the annotation of the policies not selecting the pod to be ignored")
+	assert.Equal(t, drop, npc.podDenyAction(pod, []networkPolicyInfo{{targetPods: selected, denyAction: &drop}}))
+	reject := denyAction{target: "REJECT"}
+	assert.Equal(t, drop, npc.podDenyAction(pod, []networkPolicyInfo{{targetPods: selected, denyAction: &reject},
+		{targetPods: selected, denyAction: &prohibited}}), "expected disagreeing policies to drop")
+
+	npc.syncPodFirewallChains([]networkPolicyInfo{{name: "default-deny", namespace: "payments", targetPods: selected,
+		policyType: kubeIngressPolicyType, denyAction: &drop}}, nil, selected, "1")
+	rules := npc.filterTableRules[api.IPv4Protocol].String()
+	assert.Contains(t, rules, "\"rule to DROP traffic destined for POD name:payments namespace: payments\" "+
+		"-m mark ! --mark 0x10000/0x10000 -j DROP \n")
+	assert.False(t, strings.Contains(rules, "-j REJECT"))
+}
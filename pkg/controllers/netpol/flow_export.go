@@ -0,0 +1,276 @@
+package netpol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// flowExportCopyRange is the number of bytes of the dropped packets copied to the flow exporter, which covers the
+	// IP header and the ports
+	flowExportCopyRange = 128
+
+	ipv4HeaderMinLength = 20
+	ipv6HeaderLength    = 40
+)
+
+// flowRecord is a flow dropped by the network policies, exported as a JSON line
+type flowRecord struct {
+	Time      time.Time `json:"time"`
+	Verdict   string    `json:"verdict"`
+	Direction string    `json:"direction,omitempty"`
+	Protocol  string    `json:"protocol"`
+	SrcIP     string    `json:"srcIP"`
+	SrcPort   uint16    `json:"srcPort,omitempty"`
+	DstIP     string    `json:"dstIP"`
+	DstPort   uint16    `json:"dstPort,omitempty"`
+	SrcPod    *flowPod  `json:"srcPod,omitempty"`
+	DstPod    *flowPod  `json:"dstPod,omitempty"`
+	// Policies are the network policies, as namespace/name, which select the pod in the direction the flow was
+	// dropped in, none of them allowed the flow
+	Policies []string `json:"policies,omitempty"`
+}
+
+type flowPod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// flowEndpoint is a pod of this node along with the network policies selecting it
+type flowEndpoint struct {
+	pod             flowPod
+	ingressPolicies []string
+	egressPolicies  []string
+}
+
+// flowExporter reads the flows dropped by the pod firewall chains from their NFLOG group and exports them along with
+// the pods and the network policies involved
+type flowExporter struct {
+	writer io.WriteCloser
+	// group is the NFLOG group of the traffic dropped by the pod firewall chains
+	group uint16
+
+	mu        sync.RWMutex
+	endpoints map[string]flowEndpoint
+}
+
+func newFlowExporter(writer io.WriteCloser, group uint16) *flowExporter {
+	return &flowExporter{writer: writer, group: group, endpoints: make(map[string]flowEndpoint)}
+}
+
+// newFlowWriter opens the destination of the exported flows: stdout, file://<path> or syslog://<host:port>, which
+// sends them over UDP, or syslog+tcp://<host:port>
+func newFlowWriter(target string) (io.WriteCloser, error) {
+	switch {
+	case target == "stdout":
+		return nopWriteCloser{os.Stdout}, nil
+	case strings.HasPrefix(target, "file://"):
+		file, err := os.OpenFile(strings.TrimPrefix(target, "file://"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the file to export the dropped flows to: %v", err)
+		}
+		return file, nil
+	case strings.HasPrefix(target, "syslog://"), strings.HasPrefix(target, "syslog+tcp://"):
+		network, address := "udp", strings.TrimPrefix(target, "syslog://")
+		if strings.HasPrefix(target, "syslog+tcp://") {
+			network, address = "tcp", strings.TrimPrefix(target, "syslog+tcp://")
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid syslog address %q to export the dropped flows to: %v", address, err)
+		}
+		writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, "kube-router")
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog to export the dropped flows to: %v", err)
+		}
+		return writer, nil
+	}
+	return nil, fmt.Errorf("invalid --netpol-flow-export %q, expected stdout, file://<path>, syslog://<host:port> "+
+		"or syslog+tcp://<host:port>", target)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// update replaces the pods of this node and the network policies selecting them with the ones of the last sync
+func (fe *flowExporter) update(localPods map[string]podInfo, networkPoliciesInfo []networkPolicyInfo) {
+	endpoints := make(map[string]flowEndpoint)
+	for _, pod := range localPods {
+		endpoint := flowEndpoint{pod: flowPod{Namespace: pod.namespace, Name: pod.name}}
+		for _, policy := range networkPoliciesInfo {
+			if _, ok := policy.targetPods[pod.ip]; !ok {
+				continue
+			}
+			name := policy.namespace + "/" + policy.name
+			if policy.policyType == kubeBothPolicyType || policy.policyType == kubeIngressPolicyType {
+				endpoint.ingressPolicies = append(endpoint.ingressPolicies, name)
+			}
+			if policy.policyType == kubeBothPolicyType || policy.policyType == kubeEgressPolicyType {
+				endpoint.egressPolicies = append(endpoint.egressPolicies, name)
+			}
+		}
+		sort.Strings(endpoint.ingressPolicies)
+		sort.Strings(endpoint.egressPolicies)
+		for _, ip := range getIPsFromPods([]podInfo{pod}) {
+			endpoints[ip] = endpoint
+		}
+	}
+
+	fe.mu.Lock()
+	fe.endpoints = endpoints
+	fe.mu.Unlock()
+}
+
+// run exports the dropped flows until stopCh is closed
+func (fe *flowExporter) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer func() { _ = fe.writer.Close() }()
+
+	nflog, err := utils.NewNFLog(fe.group, flowExportCopyRange)
+	if err != nil {
+		klog.Errorf("Not exporting the flows dropped by network policies: %v", err)
+		return
+	}
+	defer func() { _ = nflog.Close() }()
+	klog.Infof("Exporting the flows dropped by network policies from NFLOG group %d", fe.group)
+
+	for {
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down the dropped flows exporter")
+			return
+		default:
+		}
+		packets, err := nflog.Receive()
+		if err != nil {
+			if errors.Is(err, unix.ENOBUFS) {
+				klog.Warning("Dropped flows were lost as the flow exporter couldn't keep up")
+				continue
+			}
+			klog.Errorf("Failed to receive the dropped flows: %v", err)
+			continue
+		}
+		for _, packet := range packets {
+			record, ok := fe.record(packet)
+			if !ok {
+				continue
+			}
+			line, err := json.Marshal(record)
+			if err != nil {
+				klog.Errorf("Failed to encode dropped flow: %v", err)
+				continue
+			}
+			if _, err = fe.writer.Write(append(line, '\n')); err != nil {
+				klog.Errorf("Failed to export dropped flow: %v", err)
+			}
+		}
+	}
+}
+
+// record returns the flow of the dropped packet, enriched with the pods of this node it was from or to and the network
+// policies selecting them
+func (fe *flowExporter) record(packet utils.NFLogPacket) (flowRecord, bool) {
+	record, ok := parseFlow(packet.Payload)
+	if !ok {
+		return record, false
+	}
+	record.Time = packet.Timestamp
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Verdict = policyLogDropPrefix
+
+	fe.mu.RLock()
+	src, srcLocal := fe.endpoints[record.SrcIP]
+	dst, dstLocal := fe.endpoints[record.DstIP]
+	fe.mu.RUnlock()
+
+	if srcLocal {
+		record.SrcPod = &flowPod{Namespace: src.pod.Namespace, Name: src.pod.Name}
+	}
+	if dstLocal {
+		record.DstPod = &flowPod{Namespace: dst.pod.Namespace, Name: dst.pod.Name}
+	}
+	// the flows between two pods of this node go through the firewall chains of both pods, the direction is only
+	// known when the network policies of a single one of them apply
+	switch {
+	case dstLocal && (!srcLocal || len(src.egressPolicies) == 0):
+		record.Direction, record.Policies = kubeIngressPolicyType, dst.ingressPolicies
+	case srcLocal && (!dstLocal || len(dst.ingressPolicies) == 0):
+		record.Direction, record.Policies = kubeEgressPolicyType, src.egressPolicies
+	case srcLocal && dstLocal:
+		record.Policies = append(append([]string{}, src.egressPolicies...), dst.ingressPolicies...)
+	}
+	return record, true
+}
+
+// parseFlow returns the addresses, protocol and ports of the IP packet
+func parseFlow(payload []byte) (flowRecord, bool) {
+	var record flowRecord
+	if len(payload) == 0 {
+		return record, false
+	}
+	var protocol uint8
+	var transport []byte
+	switch payload[0] >> 4 {
+	case 4:
+		headerLength := int(payload[0]&0x0f) * 4
+		if len(payload) < ipv4HeaderMinLength || headerLength < ipv4HeaderMinLength || len(payload) < headerLength {
+			return record, false
+		}
+		protocol = payload[9]
+		record.SrcIP, record.DstIP = net.IP(payload[12:16]).String(), net.IP(payload[16:20]).String()
+		transport = payload[headerLength:]
+	case 6:
+		if len(payload) < ipv6HeaderLength {
+			return record, false
+		}
+		// extension headers aren't followed, the flows carrying them are exported without their ports
+		protocol = payload[6]
+		record.SrcIP, record.DstIP = net.IP(payload[8:24]).String(), net.IP(payload[24:40]).String()
+		transport = payload[ipv6HeaderLength:]
+	default:
+		return record, false
+	}
+
+	switch protocol {
+	case unix.IPPROTO_TCP:
+		record.Protocol = "TCP"
+	case unix.IPPROTO_UDP:
+		record.Protocol = "UDP"
+	case unix.IPPROTO_SCTP:
+		record.Protocol = "SCTP"
+	case unix.IPPROTO_ICMP:
+		record.Protocol = "ICMP"
+	case unix.IPPROTO_ICMPV6:
+		record.Protocol = "ICMPv6"
+	default:
+		record.Protocol = strconv.Itoa(int(protocol))
+	}
+	// TCP, UDP and SCTP all start with the source and destination ports
+	if (protocol == unix.IPPROTO_TCP || protocol == unix.IPPROTO_UDP || protocol == unix.IPPROTO_SCTP) &&
+		len(transport) >= 4 {
+		record.SrcPort = binary.BigEndian.Uint16(transport[0:2])
+		record.DstPort = binary.BigEndian.Uint16(transport[2:4])
+	}
+	return record, true
+}
@@ -0,0 +1,92 @@
+package netpol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+)
+
+func tIPv4TCPPacket(src, dst string, srcPort, dstPort byte) []byte {
+	packet := make([]byte, 24)
+	packet[0] = 0x45
+	packet[9] = 6
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	packet[21], packet[23] = srcPort, dstPort
+	return packet
+}
+
+func Test_parseFlow(t *testing.T) {
+	record, ok := parseFlow(tIPv4TCPPacket("10.1.2.1", "10.1.1.1", 200, 80))
+	assert.True(t, ok)
+	assert.Equal(t, flowRecord{Protocol: "TCP", SrcIP: "10.1.2.1", SrcPort: 200, DstIP: "10.1.1.1", DstPort: 80}, record)
+
+	ipv6 := make([]byte, 48)
+	ipv6[0], ipv6[6] = 0x60, 58
+	copy(ipv6[8:24], net.ParseIP("2001:db8::2"))
+	copy(ipv6[24:40], net.ParseIP("2001:db8::1"))
+	record, ok = parseFlow(ipv6)
+	assert.True(t, ok)
+	assert.Equal(t, flowRecord{Protocol: "ICMPv6", SrcIP: "2001:db8::2", DstIP: "2001:db8::1"}, record)
+
+	_, ok = parseFlow([]byte{0x45, 0, 0})
+	assert.False(t, ok, "expected truncated packets to be skipped")
+}
+
+func Test_flowExporterRecord(t *testing.T) {
+	frontend := podInfo{ip: "10.1.1.1", ips: []api.PodIP{{IP: "10.1.1.1"}}, name: "frontend", namespace: "test-ns"}
+	backend := podInfo{ip: "10.1.1.2", name: "backend", namespace: "test-ns"}
+	policies := []networkPolicyInfo{
+		{name: "deny-all", namespace: "test-ns", policyType: kubeIngressPolicyType,
+			targetPods: map[string]podInfo{frontend.ip: frontend, backend.ip: backend}},
+		{name: "egress", namespace: "test-ns", policyType: kubeBothPolicyType,
+			targetPods: map[string]podInfo{backend.ip: backend}},
+	}
+	fe := newFlowExporter(nopWriteCloser{}, defaultNFLogConfig.dropGroup)
+	fe.update(map[string]podInfo{frontend.ip: frontend, backend.ip: backend}, policies)
+	timestamp := time.Unix(1700000000, 0)
+
+	testcases := []struct {
+		name     string
+		payload  []byte
+		expected flowRecord
+	}{
+		{"flows to a pod are dropped by its ingress policies", tIPv4TCPPacket("10.1.2.1", "10.1.1.1", 200, 80),
+			flowRecord{Direction: kubeIngressPolicyType, DstPod: &flowPod{Namespace: "test-ns", Name: "frontend"},
+				Policies: []string{"test-ns/deny-all"}}},
+		{"flows from a pod are dropped by its egress policies", tIPv4TCPPacket("10.1.1.2", "10.1.2.1", 200, 80),
+			flowRecord{Direction: kubeEgressPolicyType, SrcPod: &flowPod{Namespace: "test-ns", Name: "backend"},
+				Policies: []string{"test-ns/egress"}}},
+		{"the direction of flows between pods of the node is ambiguous when policies apply to both",
+			tIPv4TCPPacket("10.1.1.2", "10.1.1.1", 200, 80),
+			flowRecord{SrcPod: &flowPod{Namespace: "test-ns", Name: "backend"},
+				DstPod:   &flowPod{Namespace: "test-ns", Name: "frontend"},
+				Policies: []string{"test-ns/egress", "test-ns/deny-all"}}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			record, ok := fe.record(utils.NFLogPacket{Payload: tc.payload, Timestamp: timestamp})
+			assert.True(t, ok)
+			assert.Equal(t, timestamp, record.Time)
+			assert.Equal(t, policyLogDropPrefix, record.Verdict)
+			assert.Equal(t, tc.expected.Direction, record.Direction)
+			assert.Equal(t, tc.expected.SrcPod, record.SrcPod)
+			assert.Equal(t, tc.expected.DstPod, record.DstPod)
+			assert.Equal(t, tc.expected.Policies, record.Policies)
+		})
+	}
+}
+
+func Test_newFlowWriter(t *testing.T) {
+	for _, target := range []string{"", "stderr", "syslog://localhost", "tcp://localhost:514"} {
+		_, err := newFlowWriter(target)
+		assert.Error(t, err, "expected %q to be rejected", target)
+	}
+	writer, err := newFlowWriter("file://" + t.TempDir() + "/flows.json")
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+}
@@ -0,0 +1,399 @@
+package netpol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	anpv1alpha1 "github.com/cloudnativelabs/kube-router/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// The GlobalNetworkPolicies apply to the pods like the AdminNetworkPolicies do and are evaluated before them, in the
+// order of their order field, with the same Allow, Deny and Pass actions. Their ingress rules also apply to the
+// traffic to the addresses of the nodes their host endpoints select, in a chain that the node firewall chain jumps to
+// before the rules of the NodeFirewalls.
+const (
+	kubeGlobalNetworkPolicyChainPrefix = "KUBE-GNP-"
+	nodeGlobalPolicyChainName          = "KUBE-ROUTER-NODE-GNP"
+)
+
+// EnableGlobalNetworkPolicies makes the controller enforce the GlobalNetworkPolicies of the informer on the pods, the
+// nodes resolve the peers selecting nodes. It has to be called before Run.
+func (npc *NetworkPolicyController) EnableGlobalNetworkPolicies(gnpInformer, nodeInformer cache.SharedIndexInformer) {
+	npc.gnpLister = gnpInformer.GetIndexer()
+	npc.nodeLister = nodeInformer.GetIndexer()
+	npc.GlobalNetworkPolicyEventHandler = npc.newAdminNetworkPolicyEventHandler()
+	npc.AdminNetworkPolicyNodeEventHandler = npc.newAdminNetworkPolicyNodeEventHandler()
+}
+
+// buildGlobalNetworkPoliciesInfo returns the GlobalNetworkPolicies that select pods in the order they are evaluated
+// in, the invalid policies are skipped
+func (npc *NetworkPolicyController) buildGlobalNetworkPoliciesInfo() []adminNetworkPolicyInfo {
+	if npc.gnpLister == nil {
+		return nil
+	}
+
+	globalPolicies := make([]adminNetworkPolicyInfo, 0)
+	for _, gnp := range listGlobalNetworkPolicies(npc.gnpLister) {
+		if gnp.Spec.NamespaceSelector == nil && gnp.Spec.PodSelector == nil {
+			continue
+		}
+		policy, err := npc.newGlobalNetworkPolicyInfo(gnp)
+		if err != nil {
+			klog.Errorf("Skipping GlobalNetworkPolicy %s: %v", gnp.Name, err)
+			continue
+		}
+		globalPolicies = append(globalPolicies, policy)
+	}
+	return globalPolicies
+}
+
+// listGlobalNetworkPolicies returns the GlobalNetworkPolicies of the lister ordered by their order and name
+func listGlobalNetworkPolicies(gnpLister cache.Indexer) []*v1alpha1.GlobalNetworkPolicy {
+	gnps := make([]*v1alpha1.GlobalNetworkPolicy, 0)
+	for _, obj := range gnpLister.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		gnp := &v1alpha1.GlobalNetworkPolicy{}
+		if err := v1alpha1.FromUnstructured(u, gnp); err != nil {
+			klog.Errorf("Failed to parse GlobalNetworkPolicy %s: %v", u.GetName(), err)
+			continue
+		}
+		gnps = append(gnps, gnp)
+	}
+	sort.Slice(gnps, func(i, j int) bool {
+		if gnps[i].Spec.Order != gnps[j].Spec.Order {
+			return gnps[i].Spec.Order < gnps[j].Spec.Order
+		}
+		return gnps[i].Name < gnps[j].Name
+	})
+	return gnps
+}
+
+func (npc *NetworkPolicyController) newGlobalNetworkPolicyInfo(
+	gnp *v1alpha1.GlobalNetworkPolicy) (adminNetworkPolicyInfo, error) {
+	policy := adminNetworkPolicyInfo{name: gnp.Name, global: true, priority: gnp.Spec.Order,
+		subjectPods: make(map[string]podInfo)}
+
+	subjectPods, err := npc.evalGlobalPolicyPods(gnp.Spec.NamespaceSelector, gnp.Spec.PodSelector)
+	if err != nil {
+		return policy, fmt.Errorf("invalid selector: %v", err)
+	}
+	for _, pod := range subjectPods {
+		policy.subjectPods[pod.Status.PodIP] = newPodInfo(pod)
+	}
+
+	for _, direction := range []string{kubeIngressPolicyType, kubeEgressPolicyType} {
+		specRules := gnp.Spec.Ingress
+		if direction == kubeEgressPolicyType {
+			specRules = gnp.Spec.Egress
+		}
+		for ruleIdx, specRule := range specRules {
+			rule, err := npc.newGlobalPolicyRule(specRule)
+			if err != nil {
+				return policy, fmt.Errorf("%s rule %d: %v", direction, ruleIdx, err)
+			}
+			if direction == kubeIngressPolicyType {
+				policy.ingressRules = append(policy.ingressRules, rule)
+			} else {
+				policy.egressRules = append(policy.egressRules, rule)
+			}
+		}
+	}
+	return policy, nil
+}
+
+func (npc *NetworkPolicyController) newGlobalPolicyRule(specRule v1alpha1.GlobalNetworkPolicyRule) (adminPolicyRule,
+	error) {
+	rule := adminPolicyRule{action: specRule.Action}
+	if err := validateGlobalPolicyAction(specRule.Action); err != nil {
+		return rule, err
+	}
+
+	rule.matchAllPeers = len(specRule.Peers) == 0
+	for _, peer := range specRule.Peers {
+		if err := validateGlobalPolicyPeer(peer); err != nil {
+			return rule, fmt.Errorf("invalid peer: %v", err)
+		}
+		switch {
+		case peer.Nodes != nil:
+			nodeIPBlocks, err := npc.evalAdminPolicyNodes(peer.Nodes)
+			if err != nil {
+				return rule, fmt.Errorf("invalid nodes peer: %v", err)
+			}
+			rule.peerIPBlocks = append(rule.peerIPBlocks, nodeIPBlocks...)
+		case peer.CIDR != "":
+			for _, cidr := range splitDefaultRoute(peer.CIDR) {
+				rule.peerIPBlocks = append(rule.peerIPBlocks, []string{cidr, utils.OptionTimeout, "0"})
+			}
+		default:
+			peerPods, err := npc.evalGlobalPolicyPods(peer.NamespaceSelector, peer.PodSelector)
+			if err != nil {
+				return rule, fmt.Errorf("invalid peer: %v", err)
+			}
+			for _, peerPod := range peerPods {
+				rule.peerPods = append(rule.peerPods, newPodInfo(peerPod))
+			}
+		}
+	}
+
+	npPorts, err := globalPolicyPorts(specRule.Ports)
+	if err != nil {
+		return rule, err
+	}
+	rule.matchAllPorts = len(npPorts) == 0
+	rule.ports, _ = npc.processNetworkPolicyPorts(npPorts, nil)
+	return rule, nil
+}
+
+func validateGlobalPolicyAction(action string) error {
+	switch action {
+	case v1alpha1.GlobalNetworkPolicyActionAllow, v1alpha1.GlobalNetworkPolicyActionDeny,
+		v1alpha1.GlobalNetworkPolicyActionPass:
+		return nil
+	}
+	return fmt.Errorf("invalid action %q", action)
+}
+
+// validateGlobalPolicyPeer checks that the peer is exactly one of pods, nodes or a CIDR
+func validateGlobalPolicyPeer(peer v1alpha1.GlobalNetworkPolicyPeer) error {
+	kinds := 0
+	if peer.NamespaceSelector != nil || peer.PodSelector != nil {
+		kinds++
+	}
+	if peer.Nodes != nil {
+		kinds++
+	}
+	if peer.CIDR != "" {
+		if _, _, err := net.ParseCIDR(peer.CIDR); err != nil {
+			return fmt.Errorf("invalid CIDR %q: %v", peer.CIDR, err)
+		}
+		kinds++
+	}
+	if kinds != 1 {
+		return errors.New("exactly one of pods, nodes or a CIDR has to be given")
+	}
+	return nil
+}
+
+// evalGlobalPolicyPods returns the actionable pods matching the pod selector in the namespaces matching the namespace
+// selector, a missing selector matches everything
+func (npc *NetworkPolicyController) evalGlobalPolicyPods(namespaceSelector,
+	podSelector *v1.LabelSelector) ([]*api.Pod, error) {
+	pods := &anpv1alpha1.NamespacedPod{}
+	if namespaceSelector != nil {
+		pods.NamespaceSelector = *namespaceSelector
+	}
+	if podSelector != nil {
+		pods.PodSelector = *podSelector
+	}
+	return npc.evalAdminPolicyPods(nil, pods)
+}
+
+// globalPolicyPorts returns the ports of a global network policy rule as the ports of a network policy, the protocol
+// defaults to TCP and a missing port matches all ports of the protocol
+func globalPolicyPorts(ports []v1alpha1.GlobalNetworkPolicyPort) ([]networking.NetworkPolicyPort, error) {
+	npPorts := make([]networking.NetworkPolicyPort, 0, len(ports))
+	for _, port := range ports {
+		if err := validateGlobalPolicyPort(port); err != nil {
+			return nil, err
+		}
+		protocol := api.Protocol(strings.ToUpper(port.Protocol))
+		if protocol == "" {
+			protocol = api.ProtocolTCP
+		}
+		npPort := networking.NetworkPolicyPort{Protocol: &protocol}
+		if port.Port != 0 {
+			portNumber := intstr.FromInt(int(port.Port))
+			npPort.Port = &portNumber
+		}
+		if port.EndPort != 0 {
+			endPort := port.EndPort
+			npPort.EndPort = &endPort
+		}
+		npPorts = append(npPorts, npPort)
+	}
+	return npPorts, nil
+}
+
+func validateGlobalPolicyPort(port v1alpha1.GlobalNetworkPolicyPort) error {
+	switch strings.ToUpper(port.Protocol) {
+	case "", "TCP", "UDP", "SCTP":
+	default:
+		return fmt.Errorf("unsupported protocol %s", port.Protocol)
+	}
+	switch {
+	case port.Port == 0 && port.EndPort != 0:
+		return fmt.Errorf("endPort %d given without port", port.EndPort)
+	case port.Port < 0 || port.Port > 65535 || port.EndPort < 0 || port.EndPort > 65535:
+		return fmt.Errorf("invalid port %d-%d", port.Port, port.EndPort)
+	case port.EndPort != 0 && port.EndPort < port.Port:
+		return fmt.Errorf("endPort %d is lower than port %d", port.EndPort, port.Port)
+	}
+	return nil
+}
+
+// EnableGlobalNetworkPolicies makes the node firewall enforce the ingress rules of the GlobalNetworkPolicies of the
+// informer whose host endpoints select the node. It has to be called before Run.
+func (nfc *NodeFirewallController) EnableGlobalNetworkPolicies(gnpInformer cache.SharedIndexInformer) {
+	nfc.globalNetworkPolicyLister = gnpInformer.GetIndexer()
+	nfc.GlobalNetworkPolicyEventHandler = nfc.newNodeFirewallEventHandler()
+}
+
+// selectingGlobalNetworkPolicies returns the GlobalNetworkPolicies whose host endpoints select the node in the order
+// they are evaluated in
+func (nfc *NodeFirewallController) selectingGlobalNetworkPolicies(node *api.Node) []*v1alpha1.GlobalNetworkPolicy {
+	globalPolicies := make([]*v1alpha1.GlobalNetworkPolicy, 0)
+	if nfc.globalNetworkPolicyLister == nil {
+		return globalPolicies
+	}
+	for _, gnp := range listGlobalNetworkPolicies(nfc.globalNetworkPolicyLister) {
+		if gnp.Spec.HostEndpoints == nil {
+			continue
+		}
+		selector, err := v1.LabelSelectorAsSelector(gnp.Spec.HostEndpoints)
+		if err != nil {
+			klog.Errorf("Skipping GlobalNetworkPolicy %s: invalid host endpoints: %v", gnp.Name, err)
+			continue
+		}
+		if selector.Matches(labels.Set(node.Labels)) {
+			globalPolicies = append(globalPolicies, gnp)
+		}
+	}
+	return globalPolicies
+}
+
+// globalPolicyHostRules returns the rules of the KUBE-ROUTER-NODE-GNP chain of the address family for the ingress rules
+// of the given GlobalNetworkPolicies: Allow accepts the traffic, Deny drops it and Pass returns it to the rules of the
+// NodeFirewalls. Like the NodeFirewalls, policies with invalid rules are skipped as a whole.
+func (nfc *NodeFirewallController) globalPolicyHostRules(globalPolicies []*v1alpha1.GlobalNetworkPolicy,
+	ipFamily api.IPFamily) [][]string {
+	rules := make([][]string, 0)
+	for _, gnp := range globalPolicies {
+		policyRules, err := nfc.globalPolicyHostIngressRules(gnp, ipFamily)
+		if err != nil {
+			klog.Errorf("Skipping invalid host endpoints of GlobalNetworkPolicy %s: %v", gnp.Name, err)
+			continue
+		}
+		rules = append(rules, policyRules...)
+	}
+	return rules
+}
+
+// globalPolicyHostIngressRules returns a rule for every combination of peer and port of the ingress rules of the
+// policy in the address family
+func (nfc *NodeFirewallController) globalPolicyHostIngressRules(gnp *v1alpha1.GlobalNetworkPolicy,
+	ipFamily api.IPFamily) ([][]string, error) {
+	rules := make([][]string, 0)
+	for ruleIdx, ingress := range gnp.Spec.Ingress {
+		if err := validateGlobalPolicyAction(ingress.Action); err != nil {
+			return nil, fmt.Errorf("ingress rule %d: %v", ruleIdx, err)
+		}
+		target := map[string]string{
+			v1alpha1.GlobalNetworkPolicyActionAllow: "ACCEPT",
+			v1alpha1.GlobalNetworkPolicyActionDeny:  "DROP",
+			v1alpha1.GlobalNetworkPolicyActionPass:  "RETURN",
+		}[ingress.Action]
+		comment := []string{"-m", "comment", "--comment",
+			strings.ToLower(ingress.Action) + " ingress of global network policy " + gnp.Name}
+
+		peers := make([][]string, 0, len(ingress.Peers))
+		for _, peer := range ingress.Peers {
+			if err := validateGlobalPolicyPeer(peer); err != nil {
+				return nil, fmt.Errorf("ingress rule %d: invalid peer: %v", ruleIdx, err)
+			}
+			switch {
+			case peer.Nodes != nil:
+				nodeIPs, err := nfc.globalPolicyNodeIPs(peer.Nodes, ipFamily)
+				if err != nil {
+					return nil, fmt.Errorf("ingress rule %d: invalid nodes peer: %v", ruleIdx, err)
+				}
+				for _, nodeIP := range nodeIPs {
+					peers = append(peers, []string{"-s", nodeIP})
+				}
+			case peer.CIDR != "":
+				peerArgs, err := nodeFirewallPeerArgs(v1alpha1.NodeFirewallPeer{CIDR: peer.CIDR}, ipFamily)
+				if err != nil {
+					return nil, fmt.Errorf("ingress rule %d: %v", ruleIdx, err)
+				}
+				if peerArgs != nil {
+					peers = append(peers, peerArgs)
+				}
+			default:
+				return nil, fmt.Errorf("ingress rule %d: pods can not be the peers of host endpoints", ruleIdx)
+			}
+		}
+		// only the rules without peers match all sources, the peers that resolve to no address match nothing
+		if len(ingress.Peers) == 0 {
+			peers = append(peers, nil)
+		}
+
+		ports := make([][]string, 0, len(ingress.Ports))
+		for _, port := range ingress.Ports {
+			if err := validateGlobalPolicyPort(port); err != nil {
+				return nil, fmt.Errorf("ingress rule %d: %v", ruleIdx, err)
+			}
+			portArgs, err := nodeFirewallPortArgs(v1alpha1.NodeFirewallPort{Protocol: port.Protocol, Port: port.Port,
+				EndPort: port.EndPort}, ipFamily)
+			if err != nil {
+				return nil, fmt.Errorf("ingress rule %d: %v", ruleIdx, err)
+			}
+			ports = append(ports, portArgs)
+		}
+		if len(ports) == 0 {
+			ports = append(ports, nil)
+		}
+
+		for _, peerArgs := range peers {
+			for _, portArgs := range ports {
+				rule := append([]string{}, comment...)
+				rule = append(rule, peerArgs...)
+				rule = append(rule, portArgs...)
+				rules = append(rules, append(rule, "-j", target))
+			}
+		}
+	}
+	return rules, nil
+}
+
+// globalPolicyNodeIPs returns the internal and external addresses of the address family of the nodes matching the
+// selector
+func (nfc *NodeFirewallController) globalPolicyNodeIPs(selector *v1.LabelSelector, ipFamily api.IPFamily) ([]string,
+	error) {
+	nodeSelector, err := v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := listers.NewNodeLister(nfc.nodeLister).List(nodeSelector)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	ips := make([]string, 0)
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			if address.Type != api.NodeInternalIP && address.Type != api.NodeExternalIP {
+				continue
+			}
+			if ip := net.ParseIP(address.Address); ip != nil && (ip.To4() != nil) == (ipFamily == api.IPv4Protocol) {
+				ips = append(ips, ip.String())
+			}
+		}
+	}
+	return ips, nil
+}
@@ -0,0 +1,134 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	anpv1alpha1 "github.com/cloudnativelabs/kube-router/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_buildGlobalNetworkPoliciesInfo(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	_, podInformer, nsInformer, npInformer := newFakeInformersFromClient(client)
+	tCreateFakePods(t, podInformer, nsInformer)
+	npc := newUneventfulNetworkPolicyController(podInformer, npInformer, nsInformer)
+	npc.nodeLister = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	teamA := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+	gnps := []interface{}{
+		&v1alpha1.GlobalNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "restrict-egress"},
+			Spec: v1alpha1.GlobalNetworkPolicySpec{Order: 20, NamespaceSelector: teamA,
+				Egress: []v1alpha1.GlobalNetworkPolicyRule{
+					{Action: "Allow", Peers: []v1alpha1.GlobalNetworkPolicyPeer{{CIDR: "10.0.0.0/8"}},
+						Ports: []v1alpha1.GlobalNetworkPolicyPort{{Protocol: "udp", Port: 53}, {Port: 8000, EndPort: 8080}}},
+					{Action: "Deny"},
+				}}},
+		&v1alpha1.GlobalNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "first"},
+			Spec: v1alpha1.GlobalNetworkPolicySpec{Order: 10,
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"component": "a"}},
+				Ingress: []v1alpha1.GlobalNetworkPolicyRule{{Action: "Pass", Peers: []v1alpha1.GlobalNetworkPolicyPeer{
+					{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"name": "c"}}}}}}}},
+		&v1alpha1.GlobalNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "hosts-only"},
+			Spec: v1alpha1.GlobalNetworkPolicySpec{HostEndpoints: &metav1.LabelSelector{},
+				Ingress: []v1alpha1.GlobalNetworkPolicyRule{{Action: "Deny"}}}},
+		&v1alpha1.GlobalNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "invalid-peer"},
+			Spec: v1alpha1.GlobalNetworkPolicySpec{NamespaceSelector: teamA,
+				Ingress: []v1alpha1.GlobalNetworkPolicyRule{{Action: "Allow", Peers: []v1alpha1.GlobalNetworkPolicyPeer{
+					{CIDR: "10.0.0.0/8", Nodes: &metav1.LabelSelector{}}}}}}},
+		&v1alpha1.GlobalNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "invalid-port"},
+			Spec: v1alpha1.GlobalNetworkPolicySpec{NamespaceSelector: teamA,
+				Egress: []v1alpha1.GlobalNetworkPolicyRule{{Action: "Allow",
+					Ports: []v1alpha1.GlobalNetworkPolicyPort{{Protocol: "ICMP"}}}}}},
+	}
+	npc.gnpLister = tNewAdminPolicyIndexer(t, gnps...)
+	npc.anpLister = tNewAdminPolicyIndexer(t, &anpv1alpha1.AdminNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "anp"},
+		Spec: anpv1alpha1.AdminNetworkPolicySpec{Subject: anpv1alpha1.AdminNetworkPolicySubject{Namespaces: teamA}}})
+	npc.banpLister = tNewAdminPolicyIndexer(t)
+
+	adminPolicies := npc.buildAdminNetworkPoliciesInfo()
+	names := make([]string, 0, len(adminPolicies))
+	for _, policy := range adminPolicies {
+		names = append(names, policy.name)
+	}
+	assert.Equal(t, []string{"first", "restrict-egress", "anp"}, names,
+		"expected the global network policies selecting pods in their order before the admin network policies")
+
+	first := adminPolicies[0]
+	assert.True(t, first.global)
+	assert.Equal(t, []string{"Aaa", "Baa"}, tSortedPodNames(first.subjectPods),
+		"expected the pod selector to select the pods of all namespaces")
+	assert.Equal(t, []string{"Ca"}, tSortedPodNames(tPodsByIP(first.ingressRules[0].peerPods)))
+	assert.True(t, first.ingressRules[0].matchAllPorts)
+
+	restrictEgress := adminPolicies[1]
+	assert.Len(t, restrictEgress.subjectPods, 7)
+	assert.Len(t, restrictEgress.egressRules, 2)
+	assert.Equal(t, [][]string{{"10.0.0.0/8", utils.OptionTimeout, "0"}}, restrictEgress.egressRules[0].peerIPBlocks)
+	assert.Equal(t, []protocolAndPort{{protocol: "UDP", port: "53"}, {protocol: "TCP", port: "8000", endport: "8080"}},
+		restrictEgress.egressRules[0].ports)
+	assert.False(t, restrictEgress.egressRules[0].matchAllPeers)
+	assert.True(t, restrictEgress.egressRules[1].matchAllPeers)
+	assert.True(t, restrictEgress.egressRules[1].matchAllPorts)
+}
+
+func Test_appendAdminPolicyRulesMatchAllPeers(t *testing.T) {
+	npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules(),
+		ipSetHandlers: map[api.IPFamily]*utils.IPSet{api.IPv4Protocol: {Sets: map[string]*utils.Set{}}}}
+	policy := adminNetworkPolicyInfo{name: "restrict-egress", global: true}
+
+	npc.appendAdminPolicyRules(policy, "KUBE-GNP-TEST", kubeEgressPolicyType, 1, adminPolicyRule{action: "Deny",
+		matchAllPeers: true, ports: []protocolAndPort{{protocol: "TCP", port: "25"}}}, map[string]bool{},
+		api.IPv4Protocol)
+
+	assert.Equal(t, "-A KUBE-GNP-TEST -m comment --comment \"rule to DENY egress traffic of global network policy "+
+		"restrict-egress rule 1\" -p TCP --dport 25 -j NFLOG --nflog-group 100 -m limit --limit 10/minute "+
+		"--limit-burst 10 \n"+
+		"-A KUBE-GNP-TEST -m comment --comment \"rule to DENY egress traffic of global network policy "+
+		"restrict-egress rule 1\" -p TCP --dport 25 -j REJECT \n", npc.filterTableRules[api.IPv4Protocol].String())
+}
+
+func Test_globalPolicyHostRules(t *testing.T) {
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, addr := range map[string]string{"node-a": "10.0.0.1", "node-b": "10.0.0.2"} {
+		node := newFakeNode(name, addr)
+		node.Labels = map[string]string{"role": "control-plane"}
+		assert.NoError(t, nodeLister.Add(node))
+	}
+	nfc := &NodeFirewallController{nodeLister: nodeLister}
+	controlPlane := &metav1.LabelSelector{MatchLabels: map[string]string{"role": "control-plane"}}
+
+	gnps := []*v1alpha1.GlobalNetworkPolicy{
+		{ObjectMeta: metav1.ObjectMeta{Name: "apiserver"},
+			Spec: v1alpha1.GlobalNetworkPolicySpec{HostEndpoints: controlPlane, Ingress: []v1alpha1.GlobalNetworkPolicyRule{
+				{Action: "Allow", Peers: []v1alpha1.GlobalNetworkPolicyPeer{{Nodes: controlPlane}},
+					Ports: []v1alpha1.GlobalNetworkPolicyPort{{Port: 2379, EndPort: 2380}}},
+				{Action: "Pass", Peers: []v1alpha1.GlobalNetworkPolicyPeer{{CIDR: "192.168.0.0/16"}}},
+				{Action: "Deny", Ports: []v1alpha1.GlobalNetworkPolicyPort{{Port: 2379, EndPort: 2380}}},
+			}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod-peers"},
+			Spec: v1alpha1.GlobalNetworkPolicySpec{HostEndpoints: controlPlane, Ingress: []v1alpha1.GlobalNetworkPolicyRule{
+				{Action: "Allow", Peers: []v1alpha1.GlobalNetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+			}}},
+	}
+
+	allowComment := []string{"-m", "comment", "--comment", "allow ingress of global network policy apiserver"}
+	assert.Equal(t, [][]string{
+		append(append([]string{}, allowComment...), "-s", "10.0.0.1", "-p", "tcp", "-m", "tcp", "--dport",
+			"2379:2380", "-j", "ACCEPT"),
+		append(append([]string{}, allowComment...), "-s", "10.0.0.2", "-p", "tcp", "-m", "tcp", "--dport",
+			"2379:2380", "-j", "ACCEPT"),
+		{"-m", "comment", "--comment", "pass ingress of global network policy apiserver", "-s", "192.168.0.0/16",
+			"-j", "RETURN"},
+		{"-m", "comment", "--comment", "deny ingress of global network policy apiserver", "-p", "tcp", "-m", "tcp",
+			"--dport", "2379:2380", "-j", "DROP"},
+	}, nfc.globalPolicyHostRules(gnps, api.IPv4Protocol), "expected the policy with pod peers to be skipped")
+
+	assert.Equal(t, []string{"-m", "comment", "--comment", "run through the global network policies",
+		"-j", nodeGlobalPolicyChainName}, nodeFirewallRules(nil, true, api.IPv4Protocol)[2])
+}
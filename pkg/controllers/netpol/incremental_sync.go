@@ -0,0 +1,239 @@
+package netpol
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// syncState is what the last successful full sync programmed, the incremental syncs build on it
+type syncState struct {
+	// version the chains of the network policies and of the pods are named after
+	version string
+	// the pods running on this node, the rules jumping to their firewall chains from the top level chains are only
+	// programmed by full syncs
+	localPods map[string]podInfo
+	// the rules of the chains of the network policies and of the pods, per address family and chain
+	chainRules map[api.IPFamily]map[string][]string
+	// the ipsets of the network policies
+	policyIPSets map[string]bool
+}
+
+// RequestPodSync queues the sync of a pod that changed without blocking the callee, the pods queued while a sync is
+// running are synced together by the next one
+func (npc *NetworkPolicyController) RequestPodSync(podKey string) {
+	npc.pendingPodSyncsMu.Lock()
+	npc.pendingPodSyncs[podKey] = true
+	npc.pendingPodSyncsMu.Unlock()
+
+	select {
+	case npc.podSyncRequestChan <- struct{}{}:
+		klog.V(3).Info("Pod sync request queue was empty so a pod sync request was successfully sent")
+	default: // the pod is synced along with the pods of the request already queued
+	}
+}
+
+// takePendingPodSyncs returns the pods queued for a sync and empties the queue
+func (npc *NetworkPolicyController) takePendingPodSyncs() map[string]bool {
+	npc.pendingPodSyncsMu.Lock()
+	defer npc.pendingPodSyncsMu.Unlock()
+	pending := npc.pendingPodSyncs
+	npc.pendingPodSyncs = make(map[string]bool)
+	return pending
+}
+
+// incrementalPodSync syncs the pods that changed since the last sync. As long as the pods running on this node keep
+// their IPs, the changes of pods only change the ipsets of the network policies and the rules of the chains of the
+// network policies and of the pods, so only the ipsets and the chains whose contents changed are restored. Otherwise,
+// it falls back to a full sync.
+func (npc *NetworkPolicyController) incrementalPodSync() {
+	if !npc.tryIncrementalPodSync() {
+		npc.fullPolicySync()
+	}
+}
+
+func (npc *NetworkPolicyController) tryIncrementalPodSync() bool {
+	pods := npc.takePendingPodSyncs()
+	if len(pods) == 0 {
+		return true
+	}
+
+	npc.mu.Lock()
+	defer npc.mu.Unlock()
+
+	if npc.lastSync == nil {
+		klog.V(1).Infof("No full sync to build on, falling back to a full sync for %d pod changes", len(pods))
+		return false
+	}
+
+	start := time.Now()
+	defer func() {
+		klog.V(1).Infof("incremental sync of %d pod changes took %v", len(pods), time.Since(start))
+	}()
+
+	localPods := *npc.getLocalPods(npc.nodeIP.String())
+	if localPodsChanged(npc.lastSync.localPods, localPods) {
+		klog.V(1).Info("Pods of this node were added, removed or changed IPs, falling back to a full sync")
+		return false
+	}
+
+	networkPoliciesInfo, err := npc.buildNetworkPoliciesInfo()
+	if err != nil {
+		klog.Errorf("Aborting incremental sync. Failed to build network policies: %v", err.Error())
+		return false
+	}
+	adminPoliciesInfo := npc.buildAdminNetworkPoliciesInfo()
+
+	// the rules are generated on their own rather than on top of the output of iptables-save, only the chains of the
+	// network policies and of the pods are picked from them
+	for _, ipFamily := range npc.ipFamilies {
+		npc.filterTableRules[ipFamily].Reset()
+	}
+	activePolicyChains, activePolicyIPSets, err := npc.syncNetworkPolicyChains(networkPoliciesInfo,
+		adminPoliciesInfo, npc.lastSync.version)
+	if err != nil {
+		klog.Errorf("Aborting incremental sync. Failed to sync network policy chains: %v", err.Error())
+		return false
+	}
+	activePodFwChains := npc.syncPodFirewallChains(networkPoliciesInfo, adminPoliciesInfo, localPods,
+		npc.lastSync.version)
+
+	if !reflect.DeepEqual(activePolicyIPSets, npc.lastSync.policyIPSets) {
+		klog.V(1).Info("The ipsets of the network policies changed, falling back to a full sync")
+		return false
+	}
+
+	activeChains := make(map[string]bool, len(activePolicyChains)+len(activePodFwChains))
+	for chain := range activePolicyChains {
+		activeChains[chain] = true
+	}
+	for chain := range activePodFwChains {
+		activeChains[chain] = true
+	}
+	chainRules := make(map[api.IPFamily]map[string][]string)
+	for _, ipFamily := range npc.ipFamilies {
+		chainRules[ipFamily] = rulesOfChains(npc.filterTableRules[ipFamily].String(), activeChains)
+		changedChains := changedChainsOf(npc.lastSync.chainRules[ipFamily], chainRules[ipFamily])
+		if changedChains == nil {
+			klog.V(1).Infof("The %s chains of the network policies or of the pods changed, falling back to a full "+
+				"sync", ipFamily)
+			return false
+		}
+		if len(changedChains) == 0 {
+			continue
+		}
+		restore := buildChainsRestore(chainRules[ipFamily], changedChains)
+		if err := npc.iptablesSaveRestore[ipFamily].RestoreNoFlush("filter", restore); err != nil {
+			klog.Errorf("Aborting incremental sync. Failed to run iptables-restore for %s: %v\n%s", ipFamily,
+				err.Error(), restore)
+			return false
+		}
+		klog.V(2).Infof("Restored %d %s chains for %d pod changes", len(changedChains), ipFamily, len(pods))
+	}
+	npc.lastSync.chainRules = chainRules
+	if npc.flowExporter != nil {
+		npc.flowExporter.update(localPods, networkPoliciesInfo)
+	}
+
+	return true
+}
+
+// localPodsChanged tells whether pods were added to or removed from this node, or whether their IPs changed
+func localPodsChanged(synced, current map[string]podInfo) bool {
+	if len(synced) != len(current) {
+		return true
+	}
+	for ip, pod := range current {
+		syncedPod, ok := synced[ip]
+		if !ok || syncedPod.name != pod.name || syncedPod.namespace != pod.namespace ||
+			!reflect.DeepEqual(syncedPod.ips, pod.ips) {
+			return true
+		}
+	}
+	return false
+}
+
+// rulesOfChains returns the rules appended or inserted to the chains that are declared in the rules of the filter
+// table, in their order
+func rulesOfChains(filterTableRules string, chains map[string]bool) map[string][]string {
+	rules := make(map[string][]string)
+	for _, rule := range strings.Split(filterTableRules, "\n") {
+		fields := strings.Fields(rule)
+		if len(fields) == 1 && strings.HasPrefix(fields[0], ":") && chains[fields[0][1:]] {
+			if _, ok := rules[fields[0][1:]]; !ok {
+				rules[fields[0][1:]] = []string{}
+			}
+			continue
+		}
+		if len(fields) < 2 || (fields[0] != "-A" && fields[0] != "-I") || !chains[fields[1]] {
+			continue
+		}
+		rules[fields[1]] = append(rules[fields[1]], rule)
+	}
+	return rules
+}
+
+// changedChainsOf returns the chains whose rules changed, sorted, or nil when chains were added or removed
+func changedChainsOf(synced, current map[string][]string) []string {
+	if len(synced) != len(current) {
+		return nil
+	}
+	changed := make([]string, 0)
+	for chain, rules := range current {
+		syncedRules, ok := synced[chain]
+		if !ok {
+			return nil
+		}
+		if !reflect.DeepEqual(syncedRules, rules) {
+			changed = append(changed, chain)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// buildChainsRestore returns the iptables-restore --noflush input replacing the rules of the chains, declaring a chain
+// flushes it
+func buildChainsRestore(chainRules map[string][]string, chains []string) []byte {
+	restore := &strings.Builder{}
+	restore.WriteString("*filter\n")
+	for _, chain := range chains {
+		restore.WriteString(":" + chain + " - [0:0]\n")
+	}
+	for _, chain := range chains {
+		for _, rule := range chainRules[chain] {
+			restore.WriteString(rule + "\n")
+		}
+	}
+	restore.WriteString("COMMIT\n")
+	return []byte(restore.String())
+}
+
+// ipSetEntries returns the entries of the sets of the ipset handler, sorted as ipset save lists them in its own order
+func ipSetEntries(ipset *utils.IPSet) map[string][]string {
+	entries := make(map[string][]string, len(ipset.Sets))
+	for name, set := range ipset.Sets {
+		setEntries := make([]string, 0, len(set.Entries))
+		for _, entry := range set.Entries {
+			setEntries = append(setEntries, strings.Join(entry.Options, " "))
+		}
+		sort.Strings(setEntries)
+		entries[name] = setEntries
+	}
+	return entries
+}
+
+// dropUnchangedIPSets removes the sets whose entries are the saved ones from the ipset handler, so that restoring it
+// only swaps the sets that changed
+func dropUnchangedIPSets(ipset *utils.IPSet, saved map[string][]string) {
+	for name, entries := range ipSetEntries(ipset) {
+		if savedEntries, ok := saved[name]; ok && reflect.DeepEqual(savedEntries, entries) {
+			delete(ipset.Sets, name)
+		}
+	}
+}
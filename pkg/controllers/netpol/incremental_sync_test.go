@@ -0,0 +1,99 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+)
+
+func Test_RequestPodSync(t *testing.T) {
+	npc := &NetworkPolicyController{podSyncRequestChan: make(chan struct{}, 1), pendingPodSyncs: map[string]bool{}}
+	npc.RequestPodSync("test-ns/pod-a")
+	npc.RequestPodSync("test-ns/pod-b")
+	npc.RequestPodSync("test-ns/pod-a")
+
+	assert.Len(t, npc.podSyncRequestChan, 1, "expected the requests to be coalesced")
+	assert.Equal(t, map[string]bool{"test-ns/pod-a": true, "test-ns/pod-b": true}, npc.takePendingPodSyncs())
+	assert.Empty(t, npc.takePendingPodSyncs())
+}
+
+func Test_localPodsChanged(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", ips: []api.PodIP{{IP: "10.1.1.1"}}, name: "pod-a", namespace: "test-ns",
+		labels: map[string]string{"app": "a"}}
+	synced := map[string]podInfo{pod.ip: pod}
+
+	relabeled := pod
+	relabeled.labels = map[string]string{"app": "b"}
+	assert.False(t, localPodsChanged(synced, map[string]podInfo{pod.ip: relabeled}),
+		"label changes are synced incrementally")
+
+	dualStack := pod
+	dualStack.ips = []api.PodIP{{IP: "10.1.1.1"}, {IP: "2001:db8::1"}}
+	assert.True(t, localPodsChanged(synced, map[string]podInfo{pod.ip: dualStack}))
+
+	replaced := pod
+	replaced.name = "pod-b"
+	assert.True(t, localPodsChanged(synced, map[string]podInfo{pod.ip: replaced}))
+
+	assert.True(t, localPodsChanged(synced, map[string]podInfo{}))
+}
+
+func Test_rulesOfChains(t *testing.T) {
+	filterTableRules := ":KUBE-POD-FW-A\n" +
+		"-I KUBE-POD-FW-A 1 -d 10.1.1.1 -j KUBE-NWPLCY-A \n" +
+		":KUBE-NWPLCY-A\n" +
+		"-A KUBE-ROUTER-FORWARD -d 10.1.1.1 -j KUBE-POD-FW-A\n" +
+		"-A KUBE-POD-FW-A -j MARK --set-mark 0x20000/0x20000 \n" +
+		"-A KUBE-POD-FW-STALE -j ACCEPT\n"
+
+	rules := rulesOfChains(filterTableRules, map[string]bool{"KUBE-POD-FW-A": true, "KUBE-NWPLCY-A": true})
+	assert.Equal(t, map[string][]string{
+		"KUBE-POD-FW-A": {"-I KUBE-POD-FW-A 1 -d 10.1.1.1 -j KUBE-NWPLCY-A ",
+			"-A KUBE-POD-FW-A -j MARK --set-mark 0x20000/0x20000 "},
+		"KUBE-NWPLCY-A": {},
+	}, rules)
+}
+
+func Test_changedChainsOf(t *testing.T) {
+	synced := map[string][]string{"KUBE-POD-FW-A": {"-A KUBE-POD-FW-A -j ACCEPT"}, "KUBE-NWPLCY-A": {}}
+
+	assert.Empty(t, changedChainsOf(synced, map[string][]string{
+		"KUBE-POD-FW-A": {"-A KUBE-POD-FW-A -j ACCEPT"}, "KUBE-NWPLCY-A": {}}))
+	assert.Equal(t, []string{"KUBE-NWPLCY-A"}, changedChainsOf(synced, map[string][]string{
+		"KUBE-POD-FW-A": {"-A KUBE-POD-FW-A -j ACCEPT"}, "KUBE-NWPLCY-A": {"-A KUBE-NWPLCY-A -j RETURN"}}))
+	assert.Nil(t, changedChainsOf(synced, map[string][]string{"KUBE-POD-FW-A": {"-A KUBE-POD-FW-A -j ACCEPT"}}),
+		"expected removed chains to require a full sync")
+	assert.Nil(t, changedChainsOf(synced, map[string][]string{
+		"KUBE-POD-FW-A": {"-A KUBE-POD-FW-A -j ACCEPT"}, "KUBE-NWPLCY-B": {}}),
+		"expected added chains to require a full sync")
+}
+
+func Test_buildChainsRestore(t *testing.T) {
+	chainRules := map[string][]string{
+		"KUBE-POD-FW-A": {"-A KUBE-POD-FW-A -j ACCEPT"},
+		"KUBE-POD-FW-B": {"-A KUBE-POD-FW-B -j ACCEPT"},
+	}
+	assert.Equal(t, "*filter\n:KUBE-POD-FW-A - [0:0]\n-A KUBE-POD-FW-A -j ACCEPT\nCOMMIT\n",
+		string(buildChainsRestore(chainRules, []string{"KUBE-POD-FW-A"})))
+}
+
+func Test_dropUnchangedIPSets(t *testing.T) {
+	ipset := &utils.IPSet{Sets: map[string]*utils.Set{}}
+	ipset.RefreshSet("KUBE-DST-A", [][]string{{"10.1.1.1", "timeout", "0"}, {"10.1.1.2", "timeout", "0"}},
+		utils.TypeHashIP)
+	ipset.RefreshSet("KUBE-DST-B", [][]string{{"10.1.1.3", "timeout", "0"}}, utils.TypeHashIP)
+	saved := ipSetEntries(ipset)
+
+	// ipset save lists the entries in its own order
+	ipset.RefreshSet("KUBE-DST-A", [][]string{{"10.1.1.2", "timeout", "0"}, {"10.1.1.1", "timeout", "0"}},
+		utils.TypeHashIP)
+	ipset.RefreshSet("KUBE-DST-B", [][]string{{"10.1.1.4", "timeout", "0"}}, utils.TypeHashIP)
+	ipset.RefreshSet("KUBE-DST-C", [][]string{}, utils.TypeHashIP)
+	dropUnchangedIPSets(ipset, saved)
+
+	assert.Nil(t, ipset.Get("KUBE-DST-A"))
+	assert.NotNil(t, ipset.Get("KUBE-DST-B"))
+	assert.NotNil(t, ipset.Get("KUBE-DST-C"), "expected new sets to be restored")
+}
@@ -8,6 +8,13 @@ import (
 	"k8s.io/klog/v2"
 )
 
+const (
+	// netpolNamespaceAnnotation exempts the pods of the namespace from the network policies when set to
+	// netpolNamespaceDisabled, their traffic isn't intercepted at all
+	netpolNamespaceAnnotation = "kube-router.io/netpol"
+	netpolNamespaceDisabled   = "disabled"
+)
+
 func (npc *NetworkPolicyController) newNamespaceEventHandler() cache.ResourceEventHandler {
 	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -34,7 +41,7 @@ func (npc *NetworkPolicyController) newNamespaceEventHandler() cache.ResourceEve
 }
 
 func (npc *NetworkPolicyController) handleNamespaceAdd(obj *api.Namespace) {
-	if obj.Labels == nil {
+	if obj.Labels == nil && !isNetpolDisabledNamespace(obj) {
 		return
 	}
 	klog.V(2).Infof("Received update for namespace: %s", obj.Name)
@@ -43,10 +50,16 @@ func (npc *NetworkPolicyController) handleNamespaceAdd(obj *api.Namespace) {
 }
 
 func (npc *NetworkPolicyController) handleNamespaceUpdate(oldObj, newObj *api.Namespace) {
-	if reflect.DeepEqual(oldObj.Labels, newObj.Labels) {
+	netpolDisabled := isNetpolDisabledNamespace(newObj)
+	if reflect.DeepEqual(oldObj.Labels, newObj.Labels) && isNetpolDisabledNamespace(oldObj) == netpolDisabled {
 		return
 	}
 	klog.V(2).Infof("Received update for namespace: %s", newObj.Name)
+	if netpolDisabled && !isNetpolDisabledNamespace(oldObj) {
+		klog.Infof("Network policies are no longer enforced on the pods of namespace %s", newObj.Name)
+	} else if !netpolDisabled && isNetpolDisabledNamespace(oldObj) {
+		klog.Infof("Network policies are enforced again on the pods of namespace %s", newObj.Name)
+	}
 
 	npc.RequestFullSync()
 }
@@ -59,3 +72,21 @@ func (npc *NetworkPolicyController) handleNamespaceDelete(obj *api.Namespace) {
 
 	npc.RequestFullSync()
 }
+
+// isNetpolDisabledNamespace tells whether the namespace opted out of the network policies with the
+// kube-router.io/netpol=disabled annotation
+func isNetpolDisabledNamespace(namespace *api.Namespace) bool {
+	return namespace.Annotations[netpolNamespaceAnnotation] == netpolNamespaceDisabled
+}
+
+// isNetpolDisabled tells whether the pods of the namespace are exempted from the network policies
+func (npc *NetworkPolicyController) isNetpolDisabled(namespace string) bool {
+	if npc.nsLister == nil {
+		return false
+	}
+	obj, exists, err := npc.nsLister.GetByKey(namespace)
+	if err != nil || !exists {
+		return false
+	}
+	return isNetpolDisabledNamespace(obj.(*api.Namespace))
+}
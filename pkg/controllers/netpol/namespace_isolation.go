@@ -0,0 +1,83 @@
+package netpol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	api "k8s.io/api/core/v1"
+)
+
+// allPodsIPSetName is the ipset of the IPs of all the pods of the cluster, it tells the traffic from the pods of other
+// namespaces apart from the traffic from outside the pod network when the namespaces are isolated
+const allPodsIPSetName = kubeSourceIPSetPrefix + "ALL-PODS"
+
+// isNamespaceIsolated tells whether the pods of the namespace only accept traffic from the pods of their own namespace
+// when no ingress network policy selects them
+func (npc *NetworkPolicyController) isNamespaceIsolated(namespace string) bool {
+	return npc.namespaceIsolation && !npc.namespaceIsolationExempt[namespace]
+}
+
+// syncNamespaceIsolationIPSets creates the ipset of all the pod IPs of the cluster and, for each isolated namespace
+// with pods on this node, the ipset of the pod IPs of the namespace
+func (npc *NetworkPolicyController) syncNamespaceIsolationIPSets(activePolicyIPSets map[string]bool) {
+	if !npc.namespaceIsolation {
+		return
+	}
+
+	localNamespaces := make(map[string]bool)
+	for _, pod := range *npc.getLocalPods(npc.nodeIP.String()) {
+		if npc.isNamespaceIsolated(pod.namespace) {
+			localNamespaces[pod.namespace] = true
+		}
+	}
+
+	allPodIPs := make([]string, 0)
+	namespacePodIPs := make(map[string][]string)
+	for _, obj := range npc.podLister.List() {
+		pod := obj.(*api.Pod)
+		if !isNetPolActionable(pod) {
+			continue
+		}
+		podIPs := getIPsFromPods([]podInfo{newPodInfo(pod)})
+		allPodIPs = append(allPodIPs, podIPs...)
+		if localNamespaces[pod.Namespace] {
+			namespacePodIPs[pod.Namespace] = append(namespacePodIPs[pod.Namespace], podIPs...)
+		}
+	}
+
+	for _, ipFamily := range npc.ipFamilies {
+		npc.createPolicyIndexedIPSet(activePolicyIPSets, allPodsIPSetName, utils.TypeHashIP, allPodIPs, ipFamily)
+		for namespace, ips := range namespacePodIPs {
+			npc.createPolicyIndexedIPSet(activePolicyIPSets, namespacePodsIPSetName(namespace), utils.TypeHashIP, ips,
+				ipFamily)
+		}
+	}
+}
+
+// isolatePodNamespace sets up the rules of a pod that isn't selected by any ingress network policy in an isolated
+// namespace: the traffic from the pods of the same namespace and from outside the pod network runs through the default
+// network policy chain like it does without isolation, the traffic from the pods of the other namespaces is left
+// unmarked and gets rejected
+func (npc *NetworkPolicyController) isolatePodNamespace(filterTableRules *bytes.Buffer, pod podInfo,
+	podFwChainName string, ipFamily api.IPFamily) {
+	podIP := pod.ipOfFamily(ipFamily)
+	comment := "\"run through default ingress network policy chain for traffic from the pod's namespace\""
+	args := []string{"-I", podFwChainName, "1", "-d", podIP, "-m", "comment", "--comment", comment,
+		"-m", "set", "--match-set", ipSetName(namespacePodsIPSetName(pod.namespace), ipFamily), "src",
+		"-j", kubeDefaultNetpolChain, "\n"}
+	filterTableRules.WriteString(strings.Join(args, " "))
+
+	comment = "\"run through default ingress network policy chain for traffic from outside the pod network\""
+	args = []string{"-I", podFwChainName, "1", "-d", podIP, "-m", "comment", "--comment", comment,
+		"-m", "set", "!", "--match-set", ipSetName(allPodsIPSetName, ipFamily), "src", "-j", kubeDefaultNetpolChain, "\n"}
+	filterTableRules.WriteString(strings.Join(args, " "))
+}
+
+func namespacePodsIPSetName(namespace string) string {
+	hash := sha256.Sum256([]byte(namespace + "namespacepods"))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])
+	return kubeSourceIPSetPrefix + encoded[:16]
+}
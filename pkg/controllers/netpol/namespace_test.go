@@ -0,0 +1,44 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_getLocalPodsNetpolDisabled(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	_, podInformer, nsInformer, npInformer := newFakeInformersFromClient(client)
+	tCreateFakePods(t, podInformer, nsInformer)
+	npc := newUneventfulNetworkPolicyController(podInformer, npInformer, nsInformer)
+	assert.Len(t, *npc.getLocalPods(""), 8)
+
+	nsB := &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsB", Labels: map[string]string{"name": "b", "team": "a"},
+		Annotations: map[string]string{netpolNamespaceAnnotation: netpolNamespaceDisabled}}}
+	assert.NoError(t, nsInformer.GetIndexer().Update(nsB))
+	localPods := make([]string, 0)
+	for _, pod := range *npc.getLocalPods("") {
+		localPods = append(localPods, pod.name)
+	}
+	assert.ElementsMatch(t, []string{"Aa", "Aaa", "Aab", "Aac", "Ca"}, localPods,
+		"expected the pods of the disabled namespace to be left out")
+}
+
+func Test_handleNamespaceUpdateNetpolDisabled(t *testing.T) {
+	npc := &NetworkPolicyController{fullSyncRequestChan: make(chan struct{}, 1)}
+	labels := map[string]string{"name": "b"}
+	enabled := &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsB", Labels: labels}}
+	disabled := &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nsB", Labels: labels,
+		Annotations: map[string]string{netpolNamespaceAnnotation: netpolNamespaceDisabled}}}
+
+	npc.handleNamespaceUpdate(enabled, enabled)
+	assert.Len(t, npc.fullSyncRequestChan, 0, "expected no sync without change")
+	npc.handleNamespaceUpdate(enabled, disabled)
+	assert.Len(t, npc.fullSyncRequestChan, 1, "expected disabling the network policies to request a sync")
+	<-npc.fullSyncRequestChan
+	npc.handleNamespaceUpdate(disabled, enabled)
+	assert.Len(t, npc.fullSyncRequestChan, 1, "expected enabling the network policies to request a sync")
+}
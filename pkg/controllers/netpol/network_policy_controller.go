@@ -2,8 +2,10 @@ package netpol
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -11,17 +13,24 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/tracing"
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
-	"github.com/coreos/go-iptables/iptables"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/klog/v2"
 
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -39,6 +48,9 @@ const (
 	kubeBothPolicyType    = "both"
 
 	syncVersionBase = 10
+
+	// networkPolicySyncFailedEventReason is the reason of the Events about the full syncs that were aborted
+	networkPolicySyncFailedEventReason = "NetworkPolicySyncFailed"
 )
 
 var (
@@ -47,6 +59,14 @@ var (
 		"FORWARD": kubeForwardChainName,
 		"OUTPUT":  kubeOutputChainName,
 	}
+
+	// sctpPortKernelModules are the kernel modules needed to match on the ports of SCTP traffic
+	sctpPortKernelModules = []utils.KernelModule{
+		{Name: "xt_sctp", Required: true, Reason: "network policy ports of SCTP"},
+	}
+
+	// tracer creates the spans of the full syncs of the network policies
+	tracer = tracing.Tracer("netpol")
 )
 
 // Network policy controller provides both ingress and egress filtering for the pods as per the defined network
@@ -64,27 +84,73 @@ var (
 type NetworkPolicyController struct {
 	nodeIP                  net.IP
 	nodeHostName            string
-	serviceClusterIPRange   net.IPNet
+	nodeUID                 types.UID
+	serviceClusterIPRanges  []net.IPNet
 	serviceExternalIPRanges []net.IPNet
 	serviceNodePortRange    string
+	nodeLocalDNSIP          net.IP
+	untrackedTraffic        bool
 	mu                      sync.Mutex
 	syncPeriod              time.Duration
 	MetricsEnabled          bool
 	healthChan              chan<- *healthcheck.ControllerHeartbeat
 	fullSyncRequestChan     chan struct{}
 	ipsetMutex              *sync.Mutex
-
-	ipSetHandler *utils.IPSet
+	logger                  klog.Logger
+	eventRecorder           record.EventRecorder
+	// the pods that changed since the last sync are queued in pendingPodSyncs and synced incrementally, lastSync is
+	// nil until a full sync succeeded
+	podSyncRequestChan chan struct{}
+	pendingPodSyncsMu  sync.Mutex
+	pendingPodSyncs    map[string]bool
+	lastSync           *syncState
+	// bridgedPodTraffic is false when traffic between pods on the node is routed (ptp CNI mode or --netpol-bridge-mode
+	// off) rather than switched by a bridge, in which case all pod traffic is intercepted in the FORWARD chain and no
+	// physdev rules are needed
+	bridgedPodTraffic bool
+	// namespaceIsolation isolates the pods which aren't selected by an ingress network policy from the pods of the
+	// other namespaces, except in the namespaceIsolationExempt ones
+	namespaceIsolation       bool
+	namespaceIsolationExempt map[string]bool
+	// denyAction is the verdict of the traffic no network policy allows, unless the policies override it
+	denyAction denyAction
+	// sctpPortMatch is false when the kernel can't match on the ports of SCTP traffic, in which case the rules
+	// allowing SCTP ports are left out rather than failing the whole sync
+	sctpPortMatch bool
+	// nflogConfig is the NFLOG configuration of the logged traffic, defaultNFLogConfig when nil
+	nflogConfig *nflogConfig
+	// flowExporter exports the flows dropped by the network policies when --netpol-flow-export is set
+	flowExporter *flowExporter
+	// policyStatus publishes the outcome of the syncs in the NodeNetworkPolicyStatus of the node when enabled
+	policyStatus *policyStatusReporter
+	// policyCounters publishes the counters of the pod firewall and network policy chains when the metrics are enabled
+	policyCounters *policyCounters
+
+	// ipFamilies are the address families the network policies are enforced for, each of them has its own iptables
+	// rules and ipsets which are kept in the maps below
+	ipFamilies          []api.IPFamily
+	iptablesSaveRestore map[api.IPFamily]*utils.IPTablesSaveRestore
+	ipSetHandlers       map[api.IPFamily]*utils.IPSet
 
 	podLister cache.Indexer
 	npLister  cache.Indexer
 	nsLister  cache.Indexer
-
-	PodEventHandler           cache.ResourceEventHandler
-	NamespaceEventHandler     cache.ResourceEventHandler
-	NetworkPolicyEventHandler cache.ResourceEventHandler
-
-	filterTableRules bytes.Buffer
+	// the listers of the admin and global network policies and of the nodes their peers select are only set when
+	// they are enforced
+	anpLister  cache.Indexer
+	banpLister cache.Indexer
+	gnpLister  cache.Indexer
+	nodeLister cache.Indexer
+
+	PodEventHandler                    cache.ResourceEventHandler
+	NamespaceEventHandler              cache.ResourceEventHandler
+	NetworkPolicyEventHandler          cache.ResourceEventHandler
+	NodeEventHandler                   cache.ResourceEventHandler
+	AdminNetworkPolicyEventHandler     cache.ResourceEventHandler
+	GlobalNetworkPolicyEventHandler    cache.ResourceEventHandler
+	AdminNetworkPolicyNodeEventHandler cache.ResourceEventHandler
+
+	filterTableRules map[api.IPFamily]*bytes.Buffer
 }
 
 // internal structure to represent a network policy
@@ -104,11 +170,20 @@ type networkPolicyInfo struct {
 
 	// policy type "ingress" or "egress" or "both" as defined by PolicyType in the spec
 	policyType string
+
+	// log the traffic allowed by the policy and the traffic it drops, as requested by the kube-router.io/log annotation
+	log bool
+
+	// denyAction overrides the deny action of the pods the policy selects, as requested by the
+	// kube-router.io/deny-action annotation
+	denyAction *denyAction
 }
 
 // internal structure to represent Pod
 type podInfo struct {
+	// ip is the primary IP of the pod, ips its IPs of all address families
 	ip        string
+	ips       []api.PodIP
 	name      string
 	namespace string
 	labels    map[string]string
@@ -159,18 +234,20 @@ func (npc *NetworkPolicyController) Run(healthChan chan<- *healthcheck.Controlle
 	klog.Info("Starting network policy controller")
 	npc.healthChan = healthChan
 
-	// setup kube-router specific top level custom chains (KUBE-ROUTER-INPUT, KUBE-ROUTER-FORWARD, KUBE-ROUTER-OUTPUT)
-	npc.ensureTopLevelChains()
-
-	// setup default network policy chain that is applied to traffic from/to the pods that does not match any network
-	// policy
-	npc.ensureDefaultNetworkPolicyChain()
+	if npc.flowExporter != nil {
+		wg.Add(1)
+		go npc.flowExporter.run(stopCh, wg)
+	}
+	if npc.policyStatus != nil {
+		wg.Add(1)
+		go npc.policyStatus.run(stopCh, wg)
+	}
 
 	// Full syncs of the network policy controller take a lot of time and can only be processed one at a time,
 	// therefore, we start it in it's own goroutine and request a sync through a single item channel
 	klog.Info("Starting network policy controller full sync goroutine")
 	wg.Add(1)
-	go func(fullSyncRequest <-chan struct{}, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	go func(fullSyncRequest, podSyncRequest <-chan struct{}, stopCh <-chan struct{}, wg *sync.WaitGroup) {
 		defer wg.Done()
 		for {
 			// Add an additional non-blocking select to ensure that if the stopCh channel is closed it is handled first
@@ -187,9 +264,19 @@ func (npc *NetworkPolicyController) Run(healthChan chan<- *healthcheck.Controlle
 			case <-fullSyncRequest:
 				klog.V(3).Info("Received request for a full sync, processing")
 				npc.fullPolicySync() // fullPolicySync() is a blocking request here
+			case <-podSyncRequest:
+				// a pending full sync syncs the pods as well
+				select {
+				case <-fullSyncRequest:
+					klog.V(3).Info("Received request for a full sync along with a pod sync, processing")
+					npc.fullPolicySync()
+				default:
+					klog.V(3).Info("Received request for a pod sync, processing")
+					npc.incrementalPodSync()
+				}
 			}
 		}
-	}(npc.fullSyncRequestChan, stopCh, wg)
+	}(npc.fullSyncRequestChan, npc.podSyncRequestChan, stopCh, wg)
 
 	// loop forever till notified to stop on stopCh
 	for {
@@ -230,10 +317,72 @@ func (npc *NetworkPolicyController) fullPolicySync() {
 		if npc.MetricsEnabled {
 			metrics.ControllerIptablesSyncTime.Observe(endTime.Seconds())
 		}
-		klog.V(1).Infof("sync iptables took %v", endTime)
+		npc.logger.V(1).Info("Synced iptables", "version", syncVersion, "duration", endTime.String())
+	}()
+
+	npc.logger.V(1).Info("Starting sync of iptables", "version", syncVersion)
+	ctx, syncSpan := tracer.Start(context.Background(), "netpol.fullPolicySync",
+		trace.WithAttributes(attribute.String("sync.version", syncVersion)))
+	defer func() { tracing.End(syncSpan, err) }()
+
+	// the pods that changed so far are synced along, the incremental syncs only build on a full sync that succeeded
+	npc.takePendingPodSyncs()
+	npc.lastSync = nil
+
+	// the errors aborting the sync are published in the status of the node and as Events on the node, the failures
+	// to program the rules with their own reason
+	eventReason := networkPolicySyncFailedEventReason
+	defer func() {
+		if err == nil {
+			return
+		}
+		if npc.policyStatus != nil {
+			npc.policyStatus.failed(err)
+		}
+		utils.RecordNodeWarning(npc.eventRecorder, npc.nodeHostName, eventReason, "Aborted the sync of the network "+
+			"policies: %v", err)
 	}()
 
-	klog.V(1).Infof("Starting sync of iptables with version: %s", syncVersion)
+	_, span := tracer.Start(ctx, "build network policies")
+	networkPoliciesInfo, err = npc.buildNetworkPoliciesInfo()
+	tracing.End(span, err)
+	if err != nil {
+		npc.logger.Error(err, "Aborting sync. Failed to build network policies", "version", syncVersion)
+		err = fmt.Errorf("failed to build network policies: %v", err)
+		return
+	}
+	_, span = tracer.Start(ctx, "build admin network policies")
+	adminPoliciesInfo := npc.buildAdminNetworkPoliciesInfo()
+	span.End()
+
+	// the rules of kube-router's chains are generated from scratch, the output of iptables-save is only needed to
+	// find its stale chains and its rules in the chains of others, and for the counters of the chains it replaces
+	savedRules := make(map[api.IPFamily]string)
+	_, span = tracer.Start(ctx, "iptables-save")
+	for _, ipFamily := range npc.ipFamilies {
+		npc.filterTableRules[ipFamily].Reset()
+		save := npc.iptablesSaveRestore[ipFamily].SaveInto
+		if npc.policyCounters != nil {
+			save = npc.iptablesSaveRestore[ipFamily].SaveWithCountersInto
+		}
+		if err = save("filter", npc.filterTableRules[ipFamily]); err != nil {
+			npc.logger.Error(err, "Aborting sync. Failed to run iptables-save", "version", syncVersion,
+				"ipFamily", ipFamily)
+			err = fmt.Errorf("failed to run iptables-save for %s: %v", ipFamily, err)
+			eventReason = utils.IptablesSyncFailedEventReason
+			tracing.End(span, err)
+			return
+		}
+		savedRules[ipFamily] = npc.filterTableRules[ipFamily].String()
+		npc.filterTableRules[ipFamily].Reset()
+	}
+	span.End()
+	if npc.policyCounters != nil {
+		npc.policyCounters.publish(savedRules)
+		for ipFamily, rules := range savedRules {
+			savedRules[ipFamily] = stripRuleCounters(rules)
+		}
+	}
 
 	// ensure kube-router specific top level chains and corresponding rules exist
 	npc.ensureTopLevelChains()
@@ -242,303 +391,269 @@ func (npc *NetworkPolicyController) fullPolicySync() {
 	// policy
 	npc.ensureDefaultNetworkPolicyChain()
 
-	networkPoliciesInfo, err = npc.buildNetworkPoliciesInfo()
+	_, span = tracer.Start(ctx, "sync network policy chains and ipsets")
+	activePolicyChains, activePolicyIPSets, err := npc.syncNetworkPolicyChains(networkPoliciesInfo,
+		adminPoliciesInfo, syncVersion)
+	tracing.End(span, err)
 	if err != nil {
-		klog.Errorf("Aborting sync. Failed to build network policies: %v", err.Error())
+		npc.logger.Error(err, "Aborting sync. Failed to sync network policy chains", "version", syncVersion)
+		err = fmt.Errorf("failed to sync network policy chains: %v", err)
 		return
 	}
 
-	npc.filterTableRules.Reset()
-	if err := utils.SaveInto("filter", &npc.filterTableRules); err != nil {
-		klog.Errorf("Aborting sync. Failed to run iptables-save: %v" + err.Error())
-		return
-	}
+	_, span = tracer.Start(ctx, "list local pods")
+	localPods := *npc.getLocalPods(npc.nodeIP.String())
+	span.SetAttributes(attribute.Int("pods", len(localPods)))
+	span.End()
+	_, span = tracer.Start(ctx, "sync pod firewall chains")
+	activePodFwChains := npc.syncPodFirewallChains(networkPoliciesInfo, adminPoliciesInfo, localPods,
+		syncVersion)
+	span.End()
 
-	activePolicyChains, activePolicyIPSets, err := npc.syncNetworkPolicyChains(networkPoliciesInfo, syncVersion)
-	if err != nil {
-		klog.Errorf("Aborting sync. Failed to sync network policy chains: %v" + err.Error())
-		return
+	activeChains := make(map[string]bool, len(activePolicyChains)+len(activePodFwChains))
+	for chain := range activePolicyChains {
+		activeChains[chain] = true
+	}
+	for chain := range activePodFwChains {
+		activeChains[chain] = true
+	}
+	chainRules := make(map[api.IPFamily]map[string][]string)
+	for _, ipFamily := range npc.ipFamilies {
+		chainRules[ipFamily] = rulesOfChains(npc.filterTableRules[ipFamily].String(), activeChains)
 	}
-
-	activePodFwChains := npc.syncPodFirewallChains(networkPoliciesInfo, syncVersion)
 
 	// Makes sure that the ACCEPT rules for packets marked with "0x20000" are added to the end of each of kube-router's
 	// top level chains
 	npc.ensureExplicitAccept()
 
-	err = npc.cleanupStaleRules(activePolicyChains, activePodFwChains, false)
-	if err != nil {
-		klog.Errorf("Aborting sync. Failed to cleanup stale iptables rules: %v", err.Error())
-		return
+	// each address family is synced in a single transaction, the chains of others are left alone
+	_, span = tracer.Start(ctx, "iptables-restore")
+	for _, ipFamily := range npc.ipFamilies {
+		restore := npc.buildFilterTableRestore(savedRules[ipFamily], ipFamily)
+		if err = npc.iptablesSaveRestore[ipFamily].RestoreNoFlush("filter", restore); err != nil {
+			npc.logger.Error(err, "Aborting sync. Failed to run iptables-restore", "version", syncVersion,
+				"ipFamily", ipFamily, "restore", restore)
+			err = fmt.Errorf("failed to run iptables-restore for %s: %v", ipFamily, err)
+			eventReason = utils.IptablesSyncFailedEventReason
+			tracing.End(span, err)
+			return
+		}
 	}
+	span.End()
 
-	if err := utils.Restore("filter", npc.filterTableRules.Bytes()); err != nil {
-		klog.Errorf("Aborting sync. Failed to run iptables-restore: %v\n%s",
-			err.Error(), npc.filterTableRules.String())
-		return
+	// the chains are now named after the version of this sync
+	if npc.policyCounters != nil {
+		npc.policyCounters.update(localPods, networkPoliciesInfo, syncVersion)
 	}
 
+	_, span = tracer.Start(ctx, "cleanup stale ipsets")
 	err = npc.cleanupStaleIPSets(activePolicyIPSets)
+	tracing.End(span, err)
 	if err != nil {
-		klog.Errorf("Failed to cleanup stale ipsets: %v", err.Error())
+		npc.logger.Error(err, "Failed to cleanup stale ipsets", "version", syncVersion)
+		err = fmt.Errorf("failed to cleanup stale ipsets: %v", err)
 		return
 	}
+
+	if npc.flowExporter != nil {
+		npc.flowExporter.update(localPods, networkPoliciesInfo)
+	}
+	npc.lastSync = &syncState{
+		version:      syncVersion,
+		localPods:    localPods,
+		chainRules:   chainRules,
+		policyIPSets: activePolicyIPSets,
+	}
+	if npc.policyStatus != nil {
+		now := metav1.Now()
+		npc.policyStatus.synced(v1alpha1.NodeNetworkPolicyStatusStatus{LastSyncTime: &now, SyncVersion: syncVersion,
+			NetworkPolicies: len(networkPoliciesInfo), AdminNetworkPolicies: len(adminPoliciesInfo),
+			Pods: len(localPods), Chains: len(activeChains), IPSets: len(activePolicyIPSets)})
+	}
 }
 
-// Creates custom chains KUBE-ROUTER-INPUT, KUBE-ROUTER-FORWARD, KUBE-ROUTER-OUTPUT
-// and following rules in the filter table to jump from builtin chain to custom chain
+// Creates custom chains KUBE-ROUTER-INPUT, KUBE-ROUTER-FORWARD, KUBE-ROUTER-OUTPUT, starting with the rules that
+// whitelist the traffic to the services in KUBE-ROUTER-INPUT. buildFilterTableRestore() keeps the following rules at
+// the top of the builtin chains of the filter table to jump from builtin chain to custom chain
 // -A INPUT   -m comment --comment "kube-router netpol" -j KUBE-ROUTER-INPUT
 // -A FORWARD -m comment --comment "kube-router netpol" -j KUBE-ROUTER-FORWARD
 // -A OUTPUT  -m comment --comment "kube-router netpol" -j KUBE-ROUTER-OUTPUT
 func (npc *NetworkPolicyController) ensureTopLevelChains() {
-	const serviceVIPPosition = 1
-	const whitelistTCPNodePortsPosition = 2
-	const whitelistUDPNodePortsPosition = 3
-	const externalIPPositionAdditive = 4
-
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		klog.Fatalf("Failed to initialize iptables executor due to %s", err.Error())
-	}
-
-	addUUIDForRuleSpec := func(chain string, ruleSpec *[]string) (string, error) {
-		hash := sha256.Sum256([]byte(chain + strings.Join(*ruleSpec, "")))
-		encoded := base32.StdEncoding.EncodeToString(hash[:])[:16]
-		for idx, part := range *ruleSpec {
-			if part == "--comment" {
-				(*ruleSpec)[idx+1] = (*ruleSpec)[idx+1] + " - " + encoded
-				return encoded, nil
-			}
+	for _, ipFamily := range npc.ipFamilies {
+		for _, customChain := range []string{kubeInputChainName, kubeForwardChainName, kubeOutputChainName} {
+			npc.filterTableRules[ipFamily].WriteString(":" + customChain + "\n")
 		}
-		return "", fmt.Errorf("could not find a comment in the ruleSpec string given: %s",
-			strings.Join(*ruleSpec, " "))
-	}
 
-	ensureRuleAtPosition := func(chain string, ruleSpec []string, uuid string, position int) {
-		exists, err := iptablesCmdHandler.Exists("filter", chain, ruleSpec...)
-		if err != nil {
-			klog.Fatalf("Failed to verify rule exists in %s chain due to %s", chain, err.Error())
-		}
-		if !exists {
-			err := iptablesCmdHandler.Insert("filter", chain, position, ruleSpec...)
-			if err != nil {
-				klog.Fatalf("Failed to run iptables command to insert in %s chain %s", chain, err.Error())
-			}
-			return
-		}
-		rules, err := iptablesCmdHandler.List("filter", chain)
-		if err != nil {
-			klog.Fatalf("failed to list rules in filter table %s chain due to %s", chain, err.Error())
-		}
-
-		var ruleNo, ruleIndexOffset int
-		for i, rule := range rules {
-			rule = strings.Replace(rule, "\"", "", 2) // removes quote from comment string
-			if strings.HasPrefix(rule, "-P") || strings.HasPrefix(rule, "-N") {
-				// if this chain has a default policy, then it will show as rule #1 from iptablesCmdHandler.List so we
-				// need to account for this offset
-				ruleIndexOffset++
+		// the whitelist rules are kept at the top of KUBE-ROUTER-INPUT in this order, the address family may lack a
+		// cluster IP range or external IP ranges
+		for _, clusterIPRange := range npc.serviceClusterIPRanges {
+			if ipFamilyOfIP(clusterIPRange.IP) != ipFamily {
 				continue
 			}
-			if strings.Contains(rule, uuid) {
-				// range uses a 0 index, but iptables uses a 1 index so we need to increase ruleNo by 1
-				ruleNo = i + 1 - ruleIndexOffset
-				break
-			}
+			args := []string{"-A", kubeInputChainName, "-m", "comment", "--comment", "\"allow traffic to cluster IP\"",
+				"-d", clusterIPRange.String(), "-j", "RETURN", "\n"}
+			npc.filterTableRules[ipFamily].WriteString(strings.Join(args, " "))
 		}
-		if ruleNo != position {
-			err = iptablesCmdHandler.Insert("filter", chain, position, ruleSpec...)
-			if err != nil {
-				klog.Fatalf("Failed to run iptables command to insert in %s chain %s", chain, err.Error())
-			}
-			err = iptablesCmdHandler.Delete("filter", chain, strconv.Itoa(ruleNo+1))
-			if err != nil {
-				klog.Fatalf("Failed to delete incorrect rule in %s chain due to %s", chain, err.Error())
-			}
-		}
-	}
 
-	for builtinChain, customChain := range defaultChains {
-		exists, err := iptablesCmdHandler.ChainExists("filter", customChain)
-		if err != nil {
-			klog.Fatalf("failed to check for the existence of chain %s, error: %v", customChain, err)
+		for _, protocol := range []string{"tcp", "udp", "sctp"} {
+			comment := "\"allow LOCAL " + strings.ToUpper(protocol) + " traffic to node ports\""
+			args := []string{"-A", kubeInputChainName, "-p", protocol, "-m", "comment", "--comment", comment,
+				"-m", "addrtype", "--dst-type", "LOCAL", "-m", "multiport", "--dports", npc.serviceNodePortRange,
+				"-j", "RETURN", "\n"}
+			npc.filterTableRules[ipFamily].WriteString(strings.Join(args, " "))
 		}
-		if !exists {
-			err = iptablesCmdHandler.NewChain("filter", customChain)
-			if err != nil {
-				klog.Fatalf("failed to run iptables command to create %s chain due to %s", customChain,
-					err.Error())
+
+		for _, externalIPRange := range npc.serviceExternalIPRanges {
+			if ipFamilyOfIP(externalIPRange.IP) != ipFamily {
+				continue
 			}
+			comment := "\"allow traffic to external IP range: " + externalIPRange.String() + "\""
+			args := []string{"-A", kubeInputChainName, "-m", "comment", "--comment", comment,
+				"-d", externalIPRange.String(), "-j", "RETURN", "\n"}
+			npc.filterTableRules[ipFamily].WriteString(strings.Join(args, " "))
 		}
-		args := []string{"-m", "comment", "--comment", "kube-router netpol", "-j", customChain}
-		uuid, err := addUUIDForRuleSpec(builtinChain, &args)
-		if err != nil {
-			klog.Fatalf("Failed to get uuid for rule: %s", err.Error())
-		}
-		ensureRuleAtPosition(builtinChain, args, uuid, 1)
-	}
-
-	whitelistServiceVips := []string{"-m", "comment", "--comment", "allow traffic to cluster IP", "-d",
-		npc.serviceClusterIPRange.String(), "-j", "RETURN"}
-	uuid, err := addUUIDForRuleSpec(kubeInputChainName, &whitelistServiceVips)
-	if err != nil {
-		klog.Fatalf("Failed to get uuid for rule: %s", err.Error())
 	}
-	ensureRuleAtPosition(kubeInputChainName, whitelistServiceVips, uuid, serviceVIPPosition)
-
-	whitelistTCPNodeports := []string{"-p", "tcp", "-m", "comment", "--comment",
-		"allow LOCAL TCP traffic to node ports", "-m", "addrtype", "--dst-type", "LOCAL",
-		"-m", "multiport", "--dports", npc.serviceNodePortRange, "-j", "RETURN"}
-	uuid, err = addUUIDForRuleSpec(kubeInputChainName, &whitelistTCPNodeports)
-	if err != nil {
-		klog.Fatalf("Failed to get uuid for rule: %s", err.Error())
-	}
-	ensureRuleAtPosition(kubeInputChainName, whitelistTCPNodeports, uuid, whitelistTCPNodePortsPosition)
-
-	whitelistUDPNodeports := []string{"-p", "udp", "-m", "comment", "--comment",
-		"allow LOCAL UDP traffic to node ports", "-m", "addrtype", "--dst-type", "LOCAL",
-		"-m", "multiport", "--dports", npc.serviceNodePortRange, "-j", "RETURN"}
-	uuid, err = addUUIDForRuleSpec(kubeInputChainName, &whitelistUDPNodeports)
-	if err != nil {
-		klog.Fatalf("Failed to get uuid for rule: %s", err.Error())
-	}
-	ensureRuleAtPosition(kubeInputChainName, whitelistUDPNodeports, uuid, whitelistUDPNodePortsPosition)
+}
 
-	for externalIPIndex, externalIPRange := range npc.serviceExternalIPRanges {
-		whitelistServiceVips := []string{"-m", "comment", "--comment",
-			"allow traffic to external IP range: " + externalIPRange.String(), "-d", externalIPRange.String(),
-			"-j", "RETURN"}
-		uuid, err = addUUIDForRuleSpec(kubeInputChainName, &whitelistServiceVips)
-		if err != nil {
-			klog.Fatalf("Failed to get uuid for rule: %s", err.Error())
-		}
-		ensureRuleAtPosition(kubeInputChainName, whitelistServiceVips, uuid, externalIPIndex+externalIPPositionAdditive)
-	}
+// topLevelChainJumpRule returns the rule jumping from the builtin chain to the kube-router top level chain, its comment
+// carries a hash of the rule so that it is told apart from the rules of older versions
+func topLevelChainJumpRule(builtinChain, customChain string) []string {
+	hash := sha256.Sum256([]byte(builtinChain + "-mcomment--commentkube-router netpol-j" + customChain))
+	encoded := base32.StdEncoding.EncodeToString(hash[:])[:16]
+	return []string{"-m", "comment", "--comment", "\"kube-router netpol - " + encoded + "\"", "-j", customChain}
 }
 
 func (npc *NetworkPolicyController) ensureExplicitAccept() {
 	// for the traffic to/from the local pod's let network policy controller be
 	// authoritative entity to ACCEPT the traffic if it complies to network policies
-	for _, chain := range defaultChains {
-		args := []string{"-m", "comment", "--comment", "\"explicitly ACCEPT traffic that complies with network policies\"",
-			"-m", "mark", "--mark", "0x20000/0x20000", "-j", "ACCEPT"}
-		npc.filterTableRules = utils.AppendUnique(npc.filterTableRules, chain, args)
+	for _, ipFamily := range npc.ipFamilies {
+		for _, chain := range defaultChains {
+			args := []string{"-A", chain, "-m", "comment", "--comment",
+				"\"explicitly ACCEPT traffic that complies with network policies\"",
+				"-m", "mark", "--mark", "0x20000/0x20000", "-j", "ACCEPT", "\n"}
+			npc.filterTableRules[ipFamily].WriteString(strings.Join(args, " "))
+		}
 	}
 }
 
 // Creates custom chains KUBE-NWPLCY-DEFAULT
 func (npc *NetworkPolicyController) ensureDefaultNetworkPolicyChain() {
+	markComment := "\"rule to mark traffic matching a network policy\""
+	args := []string{"-A", kubeDefaultNetpolChain, "-j", "MARK", "-m", "comment", "--comment", markComment,
+		"--set-xmark", "0x10000/0x10000", "\n"}
 
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		klog.Fatalf("Failed to initialize iptables executor due to %s", err.Error())
-	}
-
-	markArgs := make([]string, 0)
-	markComment := "rule to mark traffic matching a network policy"
-	markArgs = append(markArgs, "-j", "MARK", "-m", "comment", "--comment", markComment,
-		"--set-xmark", "0x10000/0x10000")
-
-	exists, err := iptablesCmdHandler.ChainExists("filter", kubeDefaultNetpolChain)
-	if err != nil {
-		klog.Fatalf("failed to check for the existence of chain %s, error: %v", kubeDefaultNetpolChain, err)
-	}
-	if !exists {
-		err = iptablesCmdHandler.NewChain("filter", kubeDefaultNetpolChain)
-		if err != nil {
-			klog.Fatalf("failed to run iptables command to create %s chain due to %s",
-				kubeDefaultNetpolChain, err.Error())
-		}
-	}
-	err = iptablesCmdHandler.AppendUnique("filter", kubeDefaultNetpolChain, markArgs...)
-	if err != nil {
-		klog.Fatalf("Failed to run iptables command: %s", err.Error())
+	for _, ipFamily := range npc.ipFamilies {
+		npc.filterTableRules[ipFamily].WriteString(":" + kubeDefaultNetpolChain + "\n")
+		npc.filterTableRules[ipFamily].WriteString(strings.Join(args, " "))
 	}
 }
 
-func (npc *NetworkPolicyController) cleanupStaleRules(activePolicyChains, activePodFwChains map[string]bool,
-	deleteDefaultChains bool) error {
-
-	cleanupPodFwChains := make([]string, 0)
-	cleanupPolicyChains := make([]string, 0)
+// isNetpolChain tells whether the chain of the filter table is owned by the network policy controller
+func isNetpolChain(chain string) bool {
+	switch chain {
+	case kubeInputChainName, kubeForwardChainName, kubeOutputChainName:
+		return true
+	}
+	return strings.HasPrefix(chain, kubeNetworkPolicyChainPrefix) ||
+		strings.HasPrefix(chain, kubePodFirewallChainPrefix) ||
+		strings.HasPrefix(chain, kubeAdminNetworkPolicyChainPrefix) ||
+		strings.HasPrefix(chain, kubeBaselineAdminNetworkPolicyChainPrefix) ||
+		strings.HasPrefix(chain, kubeGlobalNetworkPolicyChainPrefix)
+}
 
-	// initialize tool sets for working with iptables and ipset
-	iptablesCmdHandler, err := iptables.New()
-	if err != nil {
-		return fmt.Errorf("failed to initialize iptables command executor due to %s", err.Error())
+// buildFilterTableRestore returns the iptables-restore --noflush input that replaces the rules of the chains declared
+// in the filter table rules of the address family and deletes the chains of the network policy controller that are no
+// longer declared, given the output of iptables-save. The chains of others are never flushed, only the rules jumping to
+// the chains of the network policy controller are deleted from them one by one, except for the rules jumping to the top
+// level chains that are already at the top of the builtin chains.
+func (npc *NetworkPolicyController) buildFilterTableRestore(savedRules string, ipFamily api.IPFamily) []byte {
+	declaredChains := make([]string, 0)
+	declared := make(map[string]bool)
+	var rules strings.Builder
+	for _, rule := range strings.Split(npc.filterTableRules[ipFamily].String(), "\n") {
+		if strings.HasPrefix(rule, ":") {
+			chain := strings.Fields(rule[1:])[0]
+			if !declared[chain] {
+				declared[chain] = true
+				declaredChains = append(declaredChains, chain)
+			}
+		} else if strings.HasPrefix(rule, "-") {
+			rules.WriteString(rule + "\n")
+		}
 	}
 
-	// find iptables chains and ipsets that are no longer used by comparing current to the active maps we were passed
-	chains, err := iptablesCmdHandler.ListChains("filter")
-	if err != nil {
-		return fmt.Errorf("unable to list chains: %s", err)
-	}
-	for _, chain := range chains {
-		if strings.HasPrefix(chain, kubeNetworkPolicyChainPrefix) {
-			if chain == kubeDefaultNetpolChain {
-				continue
-			}
-			if _, ok := activePolicyChains[chain]; !ok {
-				cleanupPolicyChains = append(cleanupPolicyChains, chain)
-				continue
-			}
+	staleChains := make([]string, 0)
+	netpolChains := make([]string, 0)
+	foreignRules := make(map[string][]string)
+	foreignChains := make([]string, 0)
+	for _, rule := range strings.Split(savedRules, "\n") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
 		}
-		if strings.HasPrefix(chain, kubePodFirewallChainPrefix) {
-			if _, ok := activePodFwChains[chain]; !ok {
-				cleanupPodFwChains = append(cleanupPodFwChains, chain)
-				continue
+		if strings.HasPrefix(fields[0], ":") {
+			chain := fields[0][1:]
+			if isNetpolChain(chain) {
+				netpolChains = append(netpolChains, chain)
+				if !declared[chain] {
+					staleChains = append(staleChains, chain)
+				}
 			}
+			continue
 		}
+		if fields[0] != "-A" || len(fields) < 2 || isNetpolChain(fields[1]) {
+			continue
+		}
+		if _, ok := foreignRules[fields[1]]; !ok {
+			foreignChains = append(foreignChains, fields[1])
+		}
+		foreignRules[fields[1]] = append(foreignRules[fields[1]], rule)
 	}
 
-	var newChains, newRules, desiredFilterTable bytes.Buffer
-	rules := strings.Split(npc.filterTableRules.String(), "\n")
-	if len(rules) > 0 && rules[len(rules)-1] == "" {
-		rules = rules[:len(rules)-1]
+	var restore strings.Builder
+	restore.WriteString("*filter\n")
+	// declaring a chain creates or flushes it
+	for _, chain := range append(declaredChains, staleChains...) {
+		restore.WriteString(":" + chain + " - [0:0]\n")
 	}
-	for _, rule := range rules {
-		skipRule := false
-		for _, podFWChainName := range cleanupPodFwChains {
-			if strings.Contains(rule, podFWChainName) {
-				skipRule = true
-				break
-			}
-		}
-		for _, policyChainName := range cleanupPolicyChains {
-			if strings.Contains(rule, policyChainName) {
-				skipRule = true
-				break
-			}
-		}
-		if deleteDefaultChains {
-			for _, chain := range []string{kubeInputChainName, kubeForwardChainName, kubeOutputChainName,
-				kubeDefaultNetpolChain} {
-				if strings.Contains(rule, chain) {
-					skipRule = true
+
+	jumpsInPlace := make(map[string]bool)
+	for _, chain := range foreignChains {
+		referencing := make([]string, 0)
+		for _, rule := range foreignRules[chain] {
+			for _, netpolChain := range netpolChains {
+				if ruleReferencesChain(rule, netpolChain) {
+					referencing = append(referencing, rule)
 					break
 				}
 			}
 		}
-		if strings.Contains(rule, "COMMIT") || strings.HasPrefix(rule, "# ") {
-			skipRule = true
+		if customChain, ok := defaultChains[chain]; ok && declared[customChain] {
+			jump := strings.Join(append([]string{"-A", chain}, topLevelChainJumpRule(chain, customChain)...), " ")
+			// the jump to the top level chain is left alone when it is the first rule of the chain and the only one
+			// jumping to a chain of the network policy controller
+			if len(referencing) == 1 && referencing[0] == jump && foreignRules[chain][0] == jump {
+				jumpsInPlace[chain] = true
+				continue
+			}
 		}
-		if skipRule {
-			continue
+		for _, rule := range referencing {
+			restore.WriteString("-D" + strings.TrimPrefix(rule, "-A") + "\n")
 		}
-		if strings.HasPrefix(rule, ":") {
-			newChains.WriteString(rule + " - [0:0]\n")
-		}
-		if strings.HasPrefix(rule, "-") {
-			newRules.WriteString(rule + "\n")
+	}
+	for _, chain := range []string{"INPUT", "FORWARD", "OUTPUT"} {
+		if customChain := defaultChains[chain]; declared[customChain] && !jumpsInPlace[chain] {
+			args := append([]string{"-I", chain, "1"}, topLevelChainJumpRule(chain, customChain)...)
+			restore.WriteString(strings.Join(args, " ") + "\n")
 		}
 	}
-	desiredFilterTable.WriteString("*filter" + "\n")
-	desiredFilterTable.Write(newChains.Bytes())
-	desiredFilterTable.Write(newRules.Bytes())
-	desiredFilterTable.WriteString("COMMIT" + "\n")
-	npc.filterTableRules = desiredFilterTable
 
-	return nil
+	restore.WriteString(rules.String())
+	for _, chain := range staleChains {
+		restore.WriteString("-X " + chain + "\n")
+	}
+	restore.WriteString("COMMIT\n")
+	return []byte(restore.String())
 }
 
 func (npc *NetworkPolicyController) cleanupStaleIPSets(activePolicyIPSets map[string]bool) error {
@@ -557,25 +672,27 @@ func (npc *NetworkPolicyController) cleanupStaleIPSets(activePolicyIPSets map[st
 		}()
 	}
 
-	ipsets, err := utils.NewIPSet(false)
-	if err != nil {
-		return fmt.Errorf("failed to create ipsets command executor due to %s", err.Error())
-	}
-	err = ipsets.Save()
-	if err != nil {
-		klog.Fatalf("failed to initialize ipsets command executor due to %s", err.Error())
-	}
-	for _, set := range ipsets.Sets {
-		if strings.HasPrefix(set.Name, kubeSourceIPSetPrefix) ||
-			strings.HasPrefix(set.Name, kubeDestinationIPSetPrefix) {
-			if _, ok := activePolicyIPSets[set.Name]; !ok {
-				cleanupPolicyIPSets = append(cleanupPolicyIPSets, set)
+	for _, ipFamily := range npc.ipFamilies {
+		ipsets, err := utils.NewIPSet(ipFamily == api.IPv6Protocol)
+		if err != nil {
+			return fmt.Errorf("failed to create ipsets command executor due to %s", err.Error())
+		}
+		err = ipsets.Save()
+		if err != nil {
+			klog.Fatalf("failed to initialize ipsets command executor due to %s", err.Error())
+		}
+		for _, set := range ipsets.Sets {
+			if strings.HasPrefix(set.Name, kubeSourceIPSetPrefix) ||
+				strings.HasPrefix(set.Name, kubeDestinationIPSetPrefix) {
+				if _, ok := activePolicyIPSets[set.Name]; !ok {
+					cleanupPolicyIPSets = append(cleanupPolicyIPSets, set)
+				}
 			}
 		}
 	}
 	// cleanup network policy ipsets
 	for _, set := range cleanupPolicyIPSets {
-		err = set.Destroy()
+		err := set.Destroy()
 		if err != nil {
 			return fmt.Errorf("failed to delete ipset %s due to %s", set.Name, err)
 		}
@@ -583,32 +700,46 @@ func (npc *NetworkPolicyController) cleanupStaleIPSets(activePolicyIPSets map[st
 	return nil
 }
 
+// onNodeIPChange makes the controller treat the pods with the new node IP as host IP as local pods
+func (npc *NetworkPolicyController) onNodeIPChange(nodeIP net.IP) {
+	npc.mu.Lock()
+	npc.nodeIP = nodeIP
+	npc.mu.Unlock()
+	npc.RequestFullSync()
+}
+
 // Cleanup cleanup configurations done
 func (npc *NetworkPolicyController) Cleanup() {
 	klog.Info("Cleaning up NetworkPolicyController configurations...")
 
 	var emptySet map[string]bool
-	// Take a dump (iptables-save) of the current filter table for cleanupStaleRules() to work on
-	if err := utils.SaveInto("filter", &npc.filterTableRules); err != nil {
-		klog.Errorf("error encountered attempting to list iptables rules for cleanup: %v", err)
-		return
-	}
-	// Run cleanupStaleRules() to get rid of most of the kube-router rules (this is the same logic that runs as
-	// part NPC's runtime loop). Setting the last parameter to true causes even the default chains are removed.
-	err := npc.cleanupStaleRules(emptySet, emptySet, true)
-	if err != nil {
-		klog.Errorf("error encountered attempting to cleanup iptables rules: %v", err)
-		return
-	}
-	// Restore (iptables-restore) npc's cleaned up version of the iptables filter chain
-	if err = utils.Restore("filter", npc.filterTableRules.Bytes()); err != nil {
-		klog.Errorf(
-			"error encountered while loading running iptables-restore: %v\n%s", err,
-			npc.filterTableRules.String())
+	// the rules of both address families are cleaned up whatever the configuration, as long as the node has the
+	// tooling for them
+	npc.ipFamilies = nil
+	npc.filterTableRules = make(map[api.IPFamily]*bytes.Buffer)
+	for _, ipFamily := range []api.IPFamily{api.IPv4Protocol, api.IPv6Protocol} {
+		iptablesSaveRestore := utils.NewIPTablesSaveRestore(ipFamily)
+
+		// Take a dump (iptables-save) of the current filter table for buildFilterTableRestore() to work on
+		var savedRules bytes.Buffer
+		if err := iptablesSaveRestore.SaveInto("filter", &savedRules); err != nil {
+			klog.Warningf("Skipping the cleanup of the %s rules: %v", ipFamily, err)
+			continue
+		}
+		npc.ipFamilies = append(npc.ipFamilies, ipFamily)
+		npc.filterTableRules[ipFamily] = &bytes.Buffer{}
+
+		// With no chain declared, buildFilterTableRestore() deletes all of the chains of the network policy
+		// controller along with the rules jumping to them (this is the same logic that runs as part NPC's runtime
+		// loop)
+		restore := npc.buildFilterTableRestore(savedRules.String(), ipFamily)
+		if err := iptablesSaveRestore.RestoreNoFlush("filter", restore); err != nil {
+			klog.Errorf("error encountered while loading running iptables-restore: %v\n%s", err, restore)
+		}
 	}
 
 	// Cleanup ipsets
-	err = npc.cleanupStaleIPSets(emptySet)
+	err := npc.cleanupStaleIPSets(emptySet)
 	if err != nil {
 		klog.Errorf("error encountered while cleaning ipsets: %v", err)
 		return
@@ -622,19 +753,43 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 	config *options.KubeRouterConfig, podInformer cache.SharedIndexInformer,
 	npInformer cache.SharedIndexInformer, nsInformer cache.SharedIndexInformer,
 	ipsetMutex *sync.Mutex) (*NetworkPolicyController, error) {
-	npc := NetworkPolicyController{ipsetMutex: ipsetMutex}
+	npc := NetworkPolicyController{ipsetMutex: ipsetMutex, logger: utils.ControllerLogger("NPC")}
 
 	// Creating a single-item buffered channel to ensure that we only keep a single full sync request at a time,
 	// additional requests would be pointless to queue since after the first one was processed the system would already
 	// be up to date with all of the policy changes from any enqueued request after that
 	npc.fullSyncRequestChan = make(chan struct{}, 1)
+	// Likewise for the pod syncs, the pods queued in the meantime are synced together
+	npc.podSyncRequestChan = make(chan struct{}, 1)
+	npc.pendingPodSyncs = make(map[string]bool)
 
-	// Validate and parse ClusterIP service range
-	_, ipnet, err := net.ParseCIDR(config.ClusterIPCIDR)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get parse --service-cluster-ip-range parameter: %s", err.Error())
+	if config.EnableIPv4 {
+		npc.ipFamilies = append(npc.ipFamilies, api.IPv4Protocol)
+	}
+	if config.EnableIPv6 {
+		npc.ipFamilies = append(npc.ipFamilies, api.IPv6Protocol)
+	}
+	if len(npc.ipFamilies) == 0 {
+		return nil, errors.New("network policies can only be enforced with --enable-ipv4 or --enable-ipv6")
+	}
+	npc.iptablesSaveRestore = make(map[api.IPFamily]*utils.IPTablesSaveRestore)
+	npc.ipSetHandlers = make(map[api.IPFamily]*utils.IPSet)
+	npc.filterTableRules = make(map[api.IPFamily]*bytes.Buffer)
+	for _, ipFamily := range npc.ipFamilies {
+		npc.iptablesSaveRestore[ipFamily] = utils.NewIPTablesSaveRestore(ipFamily)
+		npc.filterTableRules[ipFamily] = &bytes.Buffer{}
+	}
+
+	// Validate and parse ClusterIP service ranges, dual-stack clusters have one per address family
+	for _, clusterIPCIDR := range strings.Split(config.ClusterIPCIDR, ",") {
+		_, ipnet, err := net.ParseCIDR(strings.TrimSpace(clusterIPCIDR))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parse --service-cluster-ip-range parameter: %s", err.Error())
+		}
+		npc.serviceClusterIPRanges = append(npc.serviceClusterIPRanges, *ipnet)
 	}
-	npc.serviceClusterIPRange = *ipnet
+
+	var err error
 
 	// Validate and parse NodePort range
 	if npc.serviceNodePortRange, err = validateNodePortRange(config.NodePortRange); err != nil {
@@ -655,10 +810,57 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 		// Register the metrics for this controller
 		prometheus.MustRegister(metrics.ControllerIptablesSyncTime)
 		prometheus.MustRegister(metrics.ControllerPolicyChainsSyncTime)
+		for _, metric := range policyMetrics {
+			prometheus.MustRegister(metric)
+		}
 		npc.MetricsEnabled = true
+		npc.policyCounters = newPolicyCounters()
 	}
 
 	npc.syncPeriod = config.IPTablesSyncPeriod
+	if config.NetpolDenyAction != "" {
+		if npc.denyAction, err = parseDenyAction(config.NetpolDenyAction); err != nil {
+			return nil, fmt.Errorf("failed to parse --netpol-deny-action parameter: %v", err)
+		}
+	}
+	npc.nodeLocalDNSIP = config.NodeLocalDNSIP
+	npc.untrackedTraffic = len(config.NoTrackCIDRs) > 0 || len(config.NoTrackPorts) > 0
+	npc.namespaceIsolation = config.NamespaceIsolation
+	npc.namespaceIsolationExempt = make(map[string]bool)
+	for _, namespace := range config.NamespaceIsolationExempt {
+		npc.namespaceIsolationExempt[namespace] = true
+	}
+	switch config.NetpolBridgeMode {
+	case options.NetpolBridgeModeOn:
+		npc.bridgedPodTraffic = true
+	case options.NetpolBridgeModeOff:
+		npc.bridgedPodTraffic = false
+	case "", options.NetpolBridgeModeAuto:
+		npc.bridgedPodTraffic = !(config.RunRouter && config.EnableCNI && config.CNIMode == options.CNIModePTP)
+		if npc.bridgedPodTraffic && !utils.SysctlExists(utils.BridgeNFCallIPTables) {
+			klog.Warning("br_netfilter isn't loaded, not intercepting the pod traffic switched by a bridge")
+			npc.bridgedPodTraffic = false
+		}
+	default:
+		return nil, fmt.Errorf("invalid --netpol-bridge-mode %q, expected %s, %s or %s", config.NetpolBridgeMode,
+			options.NetpolBridgeModeAuto, options.NetpolBridgeModeOn, options.NetpolBridgeModeOff)
+	}
+	npc.sctpPortMatch = utils.EnsureKernelModules(sctpPortKernelModules) == nil
+	npc.nflogConfig, err = newNFLogConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.NetpolFlowExport != "" {
+		writer, err := newFlowWriter(config.NetpolFlowExport)
+		if err != nil {
+			return nil, err
+		}
+		npc.flowExporter = newFlowExporter(writer, npc.nflogConfig.dropGroup)
+		if npc.nflogConfig.size != 0 && npc.nflogConfig.size < flowExportCopyRange {
+			klog.Warningf("--netpol-nflog-size %d is too small for the flow exporter to always parse the headers of the "+
+				"dropped packets, which needs %d bytes", npc.nflogConfig.size, flowExportCopyRange)
+		}
+	}
 
 	node, err := utils.GetNodeObject(clientset, config.HostnameOverride)
 	if err != nil {
@@ -666,6 +868,8 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 	}
 
 	npc.nodeHostName = node.Name
+	npc.eventRecorder = utils.NewEventRecorder(clientset, npc.nodeHostName)
+	npc.nodeUID = node.UID
 
 	nodeIP, err := utils.GetNodeIP(node)
 	if err != nil {
@@ -682,5 +886,7 @@ func NewNetworkPolicyController(clientset kubernetes.Interface,
 	npc.npLister = npInformer.GetIndexer()
 	npc.NetworkPolicyEventHandler = npc.newNetworkPolicyEventHandler()
 
+	npc.NodeEventHandler = utils.NewLocalNodeIPEventHandler(npc.nodeHostName, npc.onNodeIPChange)
+
 	return &npc, nil
 }
@@ -23,6 +23,7 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 
 	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 )
 
 // newFakeInformersFromClient creates the different informers used in the uneventful network policy controller
@@ -198,10 +199,18 @@ func newUneventfulNetworkPolicyController(podInformer cache.SharedIndexInformer,
 	npc.podLister = podInformer.GetIndexer()
 	npc.nsLister = nsInformer.GetIndexer()
 	npc.npLister = npInformer.GetIndexer()
+	npc.ipFamilies = []v1.IPFamily{v1.IPv4Protocol}
+	npc.ipSetHandlers = make(map[v1.IPFamily]*utils.IPSet)
+	npc.filterTableRules = tNewFilterTableRules()
 
 	return &npc
 }
 
+// tNewFilterTableRules returns the buffers of the rules of the filter table of both address families
+func tNewFilterTableRules() map[v1.IPFamily]*bytes.Buffer {
+	return map[v1.IPFamily]*bytes.Buffer{v1.IPv4Protocol: {}, v1.IPv6Protocol: {}}
+}
+
 // tNetpolTestCase helper struct to define the inputs to the test case (netpols) and
 //
 //	the expected selected targets (targetPods, inSourcePods for ingress targets, and outDestPods
@@ -544,21 +553,21 @@ func TestNetworkPolicyBuilder(t *testing.T) {
 		for _, np := range netpols {
 			fmt.Printf(np.policyType)
 			if np.policyType == kubeEgressPolicyType || np.policyType == kubeBothPolicyType {
-				err = krNetPol.processEgressRules(np, "", nil, "1")
+				err = krNetPol.processEgressRules(np, "", nil, "1", v1.IPv4Protocol)
 				if err != nil {
 					t.Errorf("Error syncing the rules: %s", err)
 				}
 			}
 			if np.policyType == kubeIngressPolicyType || np.policyType == kubeBothPolicyType {
-				err = krNetPol.processIngressRules(np, "", nil, "1")
+				err = krNetPol.processIngressRules(np, "", nil, "1", v1.IPv4Protocol)
 				if err != nil {
 					t.Errorf("Error syncing the rules: %s", err)
 				}
 			}
 		}
 
-		if !bytes.Equal([]byte(test.expectedRule), krNetPol.filterTableRules.Bytes()) {
-			t.Errorf("Invalid rule %s created:\nExpected:\n%s \nGot:\n%s", test.name, test.expectedRule, krNetPol.filterTableRules.String())
+		if !bytes.Equal([]byte(test.expectedRule), krNetPol.filterTableRules[v1.IPv4Protocol].Bytes()) {
+			t.Errorf("Invalid rule %s created:\nExpected:\n%s \nGot:\n%s", test.name, test.expectedRule, krNetPol.filterTableRules[v1.IPv4Protocol].String())
 		}
 		key := fmt.Sprintf("%s/%s", test.netpol.namespace, test.netpol.name)
 		obj, exists, err := krNetPol.npLister.GetByKey(key)
@@ -571,13 +580,17 @@ func TestNetworkPolicyBuilder(t *testing.T) {
 				t.Errorf("Failed to remove Netpol from store: %s", err)
 			}
 		}
-		krNetPol.filterTableRules.Reset()
+		krNetPol.filterTableRules[v1.IPv4Protocol].Reset()
 
 	}
 
 }
 
 func TestNetworkPolicyController(t *testing.T) {
+	bridgeModeOff := newMinimalKubeRouterConfig("", "", "node", nil)
+	bridgeModeOff.NetpolBridgeMode = options.NetpolBridgeModeOff
+	badBridgeMode := newMinimalKubeRouterConfig("", "", "node", nil)
+	badBridgeMode.NetpolBridgeMode = "bridged"
 	testCases := []tNetPolConfigTestCase{
 		{
 			"Default options are successful",
@@ -699,6 +712,18 @@ func TestNetworkPolicyController(t *testing.T) {
 			false,
 			"",
 		},
+		{
+			"Test bridge mode off",
+			bridgeModeOff,
+			false,
+			"",
+		},
+		{
+			"Test bad bridge mode",
+			badBridgeMode,
+			true,
+			"invalid --netpol-bridge-mode \"bridged\", expected auto, on or off",
+		},
 	}
 	client := fake.NewSimpleClientset(&v1.NodeList{Items: []v1.Node{*newFakeNode("node", "10.10.10.10")}})
 	_, podInformer, nsInformer, netpolInformer := newFakeInformersFromClient(client)
@@ -725,3 +750,71 @@ func TestNetworkPolicyController(t *testing.T) {
 // Ref:
 // https://github.com/kubernetes/kubernetes/blob/master/pkg/controller/podgc/gc_controller_test.go
 // https://github.com/kubernetes/kubernetes/blob/master/pkg/controller/testutil/test_utils.go
+
+func TestBuildFilterTableRestore(t *testing.T) {
+	inputJump := strings.Join(append([]string{"-A", "INPUT"}, topLevelChainJumpRule("INPUT", kubeInputChainName)...), " ")
+	forwardJump := strings.Join(append([]string{"-A", "FORWARD"},
+		topLevelChainJumpRule("FORWARD", kubeForwardChainName)...), " ")
+	savedRules := "*filter\n" +
+		":INPUT ACCEPT [0:0]\n" +
+		":FORWARD ACCEPT [0:0]\n" +
+		":OUTPUT ACCEPT [0:0]\n" +
+		":DOCKER-USER - [0:0]\n" +
+		":KUBE-ROUTER-INPUT - [0:0]\n" +
+		":KUBE-ROUTER-FORWARD - [0:0]\n" +
+		":KUBE-POD-FW-STALE - [0:0]\n" +
+		inputJump + "\n" +
+		"-A INPUT -j ACCEPT\n" +
+		"-A FORWARD -j DOCKER-USER\n" +
+		forwardJump + "\n" +
+		"-A FORWARD -j KUBE-POD-FW-STALE\n" +
+		"-A DOCKER-USER -m comment --comment \"before KUBE-POD-FW-STALE\" -j RETURN\n" +
+		"-A KUBE-ROUTER-FORWARD -j KUBE-POD-FW-STALE\n" +
+		"-A KUBE-POD-FW-STALE -j ACCEPT\n" +
+		"COMMIT\n"
+
+	t.Run("the chains are replaced and the stale ones deleted without flushing the chains of others", func(t *testing.T) {
+		npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules()}
+		npc.filterTableRules[v1.IPv4Protocol].WriteString(":" + kubeInputChainName + "\n" +
+			":" + kubeForwardChainName + "\n" +
+			":" + kubeOutputChainName + "\n" +
+			"-A " + kubeForwardChainName + " -j ACCEPT \n")
+		restore := string(npc.buildFilterTableRestore(savedRules, v1.IPv4Protocol))
+
+		expected := "*filter\n" +
+			":KUBE-ROUTER-INPUT - [0:0]\n" +
+			":KUBE-ROUTER-FORWARD - [0:0]\n" +
+			":KUBE-ROUTER-OUTPUT - [0:0]\n" +
+			":KUBE-POD-FW-STALE - [0:0]\n" +
+			"-D FORWARD" + strings.TrimPrefix(forwardJump, "-A FORWARD") + "\n" +
+			"-D FORWARD -j KUBE-POD-FW-STALE\n" +
+			"-I FORWARD 1" + strings.TrimPrefix(forwardJump, "-A FORWARD") + "\n" +
+			"-I OUTPUT 1 " + strings.Join(topLevelChainJumpRule("OUTPUT", kubeOutputChainName), " ") + "\n" +
+			"-A KUBE-ROUTER-FORWARD -j ACCEPT \n" +
+			"-X KUBE-POD-FW-STALE\n" +
+			"COMMIT\n"
+		if restore != expected {
+			t.Errorf("Invalid restore created:\nExpected:\n%s\nGot:\n%s", expected, restore)
+		}
+	})
+
+	t.Run("all the chains are deleted when none is declared", func(t *testing.T) {
+		npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules()}
+		restore := string(npc.buildFilterTableRestore(savedRules, v1.IPv4Protocol))
+
+		expected := "*filter\n" +
+			":KUBE-ROUTER-INPUT - [0:0]\n" +
+			":KUBE-ROUTER-FORWARD - [0:0]\n" +
+			":KUBE-POD-FW-STALE - [0:0]\n" +
+			"-D INPUT" + strings.TrimPrefix(inputJump, "-A INPUT") + "\n" +
+			"-D FORWARD" + strings.TrimPrefix(forwardJump, "-A FORWARD") + "\n" +
+			"-D FORWARD -j KUBE-POD-FW-STALE\n" +
+			"-X KUBE-ROUTER-INPUT\n" +
+			"-X KUBE-ROUTER-FORWARD\n" +
+			"-X KUBE-POD-FW-STALE\n" +
+			"COMMIT\n"
+		if restore != expected {
+			t.Errorf("Invalid restore created:\nExpected:\n%s\nGot:\n%s", expected, restore)
+		}
+	})
+}
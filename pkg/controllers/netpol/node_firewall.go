@@ -0,0 +1,657 @@
+package netpol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/vishvananda/netlink"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	nodeFirewallChainName      = "KUBE-ROUTER-NODE-FW"
+	nodeFirewallLocalIPSetName = "kube-router-node-fw-local"
+	nodeFirewallNodesIPSetName = "kube-router-node-fw-nodes"
+	// service VIPs are assigned to the dummy interface, traffic to them is left to the proxy and network policies
+	kubeDummyIfName = "kube-dummy-if"
+)
+
+// NodeFirewallController enforces the NodeFirewall custom resources that select the node on the traffic to the node's
+// own addresses. The rules live in the KUBE-ROUTER-NODE-FW chain which is jumped to from the end of the INPUT chain,
+// so they are evaluated after the chains of the service proxy and network policies. Each enabled address family has
+// its own chains, which are written in a single iptables-restore transaction per family.
+type NodeFirewallController struct {
+	nodeName        string
+	syncPeriod      time.Duration
+	syncRequestChan chan struct{}
+	ipsetMutex      *sync.Mutex
+	// ipFamilies are the address families the node firewall is enforced for
+	ipFamilies          []v1core.IPFamily
+	iptablesSaveRestore map[v1core.IPFamily]*utils.IPTablesSaveRestore
+	// appliedRules are the rules last written to the chains of each address family
+	appliedRules map[v1core.IPFamily]map[string][][]string
+
+	nodeLister         cache.Indexer
+	nodeFirewallLister cache.Indexer
+	// globalNetworkPolicyLister is only set when the global network policies are enforced
+	globalNetworkPolicyLister cache.Indexer
+
+	NodeEventHandler                cache.ResourceEventHandler
+	NodeFirewallEventHandler        cache.ResourceEventHandler
+	GlobalNetworkPolicyEventHandler cache.ResourceEventHandler
+}
+
+// Run syncs the node firewall periodically, whenever addresses change on the node and whenever a sync is requested
+// till we receive notification on stopCh
+func (nfc *NodeFirewallController) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	t := time.NewTicker(nfc.syncPeriod)
+	defer t.Stop()
+	defer wg.Done()
+
+	addrUpdates := make(chan netlink.AddrUpdate)
+	done := make(chan struct{})
+	defer close(done)
+	if err := netlink.AddrSubscribe(addrUpdates, done); err != nil {
+		klog.Errorf("Failed to subscribe to address updates, new node addresses will only be firewalled after %s: %v",
+			nfc.syncPeriod, err)
+	} else {
+		// the channel is closed once done is closed
+		go func() {
+			for range addrUpdates {
+				nfc.RequestSync()
+			}
+		}()
+	}
+
+	klog.Info("Starting node firewall controller")
+	for {
+		if err := nfc.sync(); err != nil {
+			klog.Errorf("Failed to sync node firewall: %v", err)
+		}
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down node firewall controller")
+			return
+		case <-t.C:
+		case <-nfc.syncRequestChan:
+		}
+	}
+}
+
+// RequestSync allows the request of a sync without blocking the callee
+func (nfc *NodeFirewallController) RequestSync() {
+	select {
+	case nfc.syncRequestChan <- struct{}{}:
+	default:
+	}
+}
+
+func (nfc *NodeFirewallController) sync() error {
+	obj, exists, err := nfc.nodeLister.GetByKey(nfc.nodeName)
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %v", nfc.nodeName, err)
+	}
+	if !exists {
+		return fmt.Errorf("node %s not found", nfc.nodeName)
+	}
+	node := obj.(*v1core.Node)
+
+	firewalls := nfc.selectingNodeFirewalls(node)
+	globalPolicies := nfc.selectingGlobalNetworkPolicies(node)
+	if len(firewalls) == 0 && len(globalPolicies) == 0 {
+		return nfc.cleanup()
+	}
+
+	for _, ipFamily := range nfc.ipFamilies {
+		if err = nfc.syncIPSets(ipFamily); err != nil {
+			return err
+		}
+
+		// the chain of the global network policies is declared first as the node firewall chain jumps to it
+		chains := make([]string, 0, 2)
+		chainRules := make(map[string][][]string)
+		if nfc.globalNetworkPolicyLister != nil {
+			chains = append(chains, nodeGlobalPolicyChainName)
+			chainRules[nodeGlobalPolicyChainName] = nfc.globalPolicyHostRules(globalPolicies, ipFamily)
+		}
+		chains = append(chains, nodeFirewallChainName)
+		chainRules[nodeFirewallChainName] = nodeFirewallRules(firewalls, len(globalPolicies) != 0, ipFamily)
+
+		changed, err := nfc.syncChains(ipFamily, chains, chainRules)
+		if err != nil {
+			return err
+		}
+		if changed {
+			names := make([]string, 0, len(firewalls))
+			for _, firewall := range firewalls {
+				names = append(names, firewall.Name)
+			}
+			gnpNames := make([]string, 0, len(globalPolicies))
+			for _, gnp := range globalPolicies {
+				gnpNames = append(gnpNames, gnp.Name)
+			}
+			klog.Infof("Applied %s node firewalls %v and the host endpoints of global network policies %v", ipFamily,
+				names, gnpNames)
+		}
+	}
+	return nil
+}
+
+// syncChains writes the rules of the chains of the address family along with the jump from the INPUT chain in a single
+// iptables-restore --noflush transaction, so that the node is never left with a flushed or partially written chain,
+// and returns whether they had to be written. The rules are only rewritten when they changed or the chains were
+// modified externally.
+func (nfc *NodeFirewallController) syncChains(ipFamily v1core.IPFamily, chains []string,
+	chainRules map[string][][]string) (bool, error) {
+	var savedRules bytes.Buffer
+	if err := nfc.iptablesSaveRestore[ipFamily].SaveInto("filter", &savedRules); err != nil {
+		return false, fmt.Errorf("failed to run iptables-save for %s: %v", ipFamily, err)
+	}
+	ruleCounts, jumpExists := nodeFirewallChainsState(savedRules.String(), chains)
+
+	inSync := jumpExists && reflect.DeepEqual(chainRules, nfc.appliedRules[ipFamily])
+	for _, chain := range chains {
+		if count, ok := ruleCounts[chain]; !ok || count != len(chainRules[chain]) {
+			inSync = false
+		}
+	}
+	if inSync {
+		return false, nil
+	}
+
+	restore := buildNodeFirewallRestore(chains, chainRules, !jumpExists, ipFamily)
+	if err := nfc.iptablesSaveRestore[ipFamily].RestoreNoFlush("filter", restore); err != nil {
+		delete(nfc.appliedRules, ipFamily)
+		return false, fmt.Errorf("failed to run iptables-restore for %s: %v\n%s", ipFamily, err, restore)
+	}
+	nfc.appliedRules[ipFamily] = chainRules
+	return true, nil
+}
+
+// nodeFirewallChainsState returns the number of rules of each of the chains that exist in the output of iptables-save
+// and whether the INPUT chain jumps to the node firewall chain
+func nodeFirewallChainsState(savedRules string, chains []string) (map[string]int, bool) {
+	wanted := make(map[string]bool, len(chains))
+	for _, chain := range chains {
+		wanted[chain] = true
+	}
+	ruleCounts := make(map[string]int)
+	jumpExists := false
+	for _, rule := range strings.Split(savedRules, "\n") {
+		fields := strings.Fields(rule)
+		switch {
+		case len(fields) == 0:
+		case strings.HasPrefix(fields[0], ":") && wanted[fields[0][1:]]:
+			// iptables-save declares the chains before their rules
+			ruleCounts[fields[0][1:]] = 0
+		case fields[0] == "-A" && len(fields) > 1 && wanted[fields[1]]:
+			ruleCounts[fields[1]]++
+		case fields[0] == "-A" && len(fields) > 1 && fields[1] == "INPUT":
+			jumpExists = jumpExists || ruleReferencesChain(rule, nodeFirewallChainName)
+		}
+	}
+	return ruleCounts, jumpExists
+}
+
+// buildNodeFirewallRestore returns the iptables-restore --noflush input replacing the rules of the chains, declaring a
+// chain creates or flushes it. The jump from the INPUT chain is appended when it does not exist yet.
+func buildNodeFirewallRestore(chains []string, chainRules map[string][][]string, addJump bool,
+	ipFamily v1core.IPFamily) []byte {
+	restore := &strings.Builder{}
+	restore.WriteString("*filter\n")
+	for _, chain := range chains {
+		restore.WriteString(":" + chain + " - [0:0]\n")
+	}
+	for _, chain := range chains {
+		for _, rule := range chainRules[chain] {
+			restore.WriteString(restoreRule(chain, rule) + "\n")
+		}
+	}
+	if addJump {
+		restore.WriteString(restoreRule("INPUT", nodeFirewallInputChainRule(ipFamily)) + "\n")
+	}
+	restore.WriteString("COMMIT\n")
+	return []byte(restore.String())
+}
+
+// restoreRule returns the rule appended to the chain in the format of iptables-restore, quoting the arguments with
+// spaces such as the comments
+func restoreRule(chain string, rule []string) string {
+	args := []string{"-A", chain}
+	for _, arg := range rule {
+		if strings.Contains(arg, " ") {
+			arg = "\"" + arg + "\""
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, " ")
+}
+
+// selectingNodeFirewalls returns the valid NodeFirewalls that select the node ordered by their name
+func (nfc *NodeFirewallController) selectingNodeFirewalls(node *v1core.Node) []*v1alpha1.NodeFirewall {
+	firewalls := make([]*v1alpha1.NodeFirewall, 0)
+	for _, obj := range nfc.nodeFirewallLister.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		firewall := &v1alpha1.NodeFirewall{}
+		if err := v1alpha1.FromUnstructured(u, firewall); err != nil {
+			klog.Errorf("Failed to parse NodeFirewall %s: %v", u.GetName(), err)
+			continue
+		}
+		if !labels.SelectorFromSet(firewall.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		firewalls = append(firewalls, firewall)
+	}
+	sort.Slice(firewalls, func(i, j int) bool { return firewalls[i].Name < firewalls[j].Name })
+	return firewalls
+}
+
+// nodeFirewallRules returns the rules of the KUBE-ROUTER-NODE-FW chain of the address family for the given firewalls.
+// Loopback traffic, return traffic of connections initiated by the node and, for IPv6, neighbor discovery are always
+// allowed, the rest is first run through the global network policies when they select the node. Firewalls with
+// invalid rules are skipped as a whole including their default deny, so that a typo can not lock everybody out of the
+// node.
+func nodeFirewallRules(firewalls []*v1alpha1.NodeFirewall, jumpToGlobalPolicies bool,
+	ipFamily v1core.IPFamily) [][]string {
+	rules := [][]string{
+		{"-m", "comment", "--comment", "allow loopback traffic to the node", "-i", "lo", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "allow return traffic to the node",
+			"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	if ipFamily == v1core.IPv6Protocol {
+		for _, icmpType := range []string{"neighbour-solicitation", "neighbour-advertisement"} {
+			rules = append(rules, []string{"-m", "comment", "--comment", "allow neighbor discovery",
+				"-p", "ipv6-icmp", "-m", "icmp6", "--icmpv6-type", icmpType, "-j", "ACCEPT"})
+		}
+	}
+	if jumpToGlobalPolicies {
+		rules = append(rules, []string{"-m", "comment", "--comment", "run through the global network policies",
+			"-j", nodeGlobalPolicyChainName})
+	}
+	defaultDeny := false
+	for _, firewall := range firewalls {
+		firewallRules, err := nodeFirewallIngressRules(firewall, ipFamily)
+		if err != nil {
+			klog.Errorf("Skipping invalid NodeFirewall %s: %v", firewall.Name, err)
+			continue
+		}
+		rules = append(rules, firewallRules...)
+		defaultDeny = defaultDeny || firewall.Spec.DefaultDeny
+	}
+	if defaultDeny {
+		rules = append(rules, []string{"-m", "comment", "--comment", "default deny of the node firewalls",
+			"-j", "DROP"})
+	}
+	return rules
+}
+
+// nodeFirewallIngressRules returns a rule for every combination of peer and port of the ingress rules of the firewall
+// in the address family
+func nodeFirewallIngressRules(firewall *v1alpha1.NodeFirewall, ipFamily v1core.IPFamily) ([][]string, error) {
+	comment := []string{"-m", "comment", "--comment", "allow ingress of node firewall " + firewall.Name}
+	rules := make([][]string, 0)
+	for _, ingress := range firewall.Spec.Ingress {
+		peers := make([][]string, 0, len(ingress.From))
+		for _, peer := range ingress.From {
+			peerArgs, err := nodeFirewallPeerArgs(peer, ipFamily)
+			if err != nil {
+				return nil, err
+			}
+			if peerArgs != nil {
+				peers = append(peers, peerArgs)
+			}
+		}
+		// only the rules without peers match all sources, the CIDRs of the other address family match nothing
+		if len(ingress.From) == 0 {
+			peers = append(peers, nil)
+		}
+
+		ports := make([][]string, 0, len(ingress.Ports))
+		for _, port := range ingress.Ports {
+			portArgs, err := nodeFirewallPortArgs(port, ipFamily)
+			if err != nil {
+				return nil, err
+			}
+			ports = append(ports, portArgs)
+		}
+		if len(ports) == 0 {
+			ports = append(ports, nil)
+		}
+
+		for _, peerArgs := range peers {
+			for _, portArgs := range ports {
+				rule := append([]string{}, comment...)
+				rule = append(rule, peerArgs...)
+				rule = append(rule, portArgs...)
+				rules = append(rules, append(rule, "-j", "ACCEPT"))
+			}
+		}
+	}
+	return rules, nil
+}
+
+// nodeFirewallPeerArgs returns the source match of the peer in the address family, or nil when the peer is a CIDR of
+// the other address family
+func nodeFirewallPeerArgs(peer v1alpha1.NodeFirewallPeer, ipFamily v1core.IPFamily) ([]string, error) {
+	switch {
+	case peer.ClusterNodes && peer.CIDR != "":
+		return nil, fmt.Errorf("peer can not have both a CIDR and match the cluster nodes")
+	case peer.ClusterNodes:
+		return []string{"-m", "set", "--match-set", ipSetName(nodeFirewallNodesIPSetName, ipFamily), "src"}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(peer.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %v", peer.CIDR, err)
+	}
+	if (ipNet.IP.To4() != nil) != (ipFamily == v1core.IPv4Protocol) {
+		return nil, nil
+	}
+	return []string{"-s", ipNet.String()}, nil
+}
+
+func nodeFirewallPortArgs(port v1alpha1.NodeFirewallPort, ipFamily v1core.IPFamily) ([]string, error) {
+	protocol := strings.ToUpper(port.Protocol)
+	var args []string
+	switch protocol {
+	case "", "TCP", "UDP", "SCTP":
+		if protocol == "" {
+			protocol = "TCP"
+		}
+		args = []string{"-p", strings.ToLower(protocol)}
+	case "ICMP":
+		args = []string{"-p", "icmp"}
+		if ipFamily == v1core.IPv6Protocol {
+			args = []string{"-p", "ipv6-icmp"}
+		}
+	case "IPIP":
+		// IP in IP encapsulation as used by the overlay tunnels
+		args = []string{"-p", "4"}
+	default:
+		return nil, fmt.Errorf("unsupported protocol %s", port.Protocol)
+	}
+
+	if port.Port == 0 {
+		if port.EndPort != 0 {
+			return nil, fmt.Errorf("endPort %d given without port", port.EndPort)
+		}
+		return args, nil
+	}
+	if protocol != "TCP" && protocol != "UDP" && protocol != "SCTP" {
+		return nil, fmt.Errorf("ports can not be given for protocol %s", protocol)
+	}
+	if port.Port < 1 || port.Port > 65535 || port.EndPort < 0 || port.EndPort > 65535 {
+		return nil, fmt.Errorf("invalid port %d-%d", port.Port, port.EndPort)
+	}
+	dport := strconv.Itoa(int(port.Port))
+	if port.EndPort != 0 {
+		if port.EndPort < port.Port {
+			return nil, fmt.Errorf("endPort %d is lower than port %d", port.EndPort, port.Port)
+		}
+		dport += ":" + strconv.Itoa(int(port.EndPort))
+	}
+	return append(args, "-m", strings.ToLower(protocol), "--dport", dport), nil
+}
+
+func nodeFirewallInputChainRule(ipFamily v1core.IPFamily) []string {
+	return []string{"-m", "comment", "--comment", "kube-router node firewall",
+		"-m", "set", "--match-set", ipSetName(nodeFirewallLocalIPSetName, ipFamily), "dst",
+		"-j", nodeFirewallChainName}
+}
+
+// syncIPSets refreshes the ipsets of the addresses of this node and of the nodes of the cluster in the address family
+func (nfc *NodeFirewallController) syncIPSets(ipFamily v1core.IPFamily) error {
+	localIPs, err := nodeFirewallLocalIPs(ipFamily)
+	if err != nil {
+		return err
+	}
+
+	nodeIPs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, obj := range nfc.nodeLister.List() {
+		node := obj.(*v1core.Node)
+		candidates := make([]string, 0)
+		if nodeIP, err := utils.GetNodeIP(node); err == nil {
+			candidates = append(candidates, nodeIP.String())
+		}
+		for _, annotation := range []string{utils.NodeBGPAddressAnnotation, utils.NodeOverlayAddressAnnotation} {
+			if value, ok := node.Annotations[annotation]; ok {
+				candidates = append(candidates, value)
+			}
+		}
+		for _, candidate := range candidates {
+			ip := net.ParseIP(candidate)
+			if ip != nil && (ip.To4() != nil) == (ipFamily == v1core.IPv4Protocol) && !seen[ip.String()] {
+				seen[ip.String()] = true
+				nodeIPs = append(nodeIPs, ip.String())
+			}
+		}
+	}
+
+	nfc.ipsetMutex.Lock()
+	defer nfc.ipsetMutex.Unlock()
+	ipSetHandler, err := utils.NewIPSet(ipFamily == v1core.IPv6Protocol)
+	if err != nil {
+		return fmt.Errorf("failed to create ipsets command executor: %v", err)
+	}
+	for setName, entries := range map[string][]string{
+		nodeFirewallLocalIPSetName: localIPs,
+		nodeFirewallNodesIPSetName: nodeIPs,
+	} {
+		set, err := ipSetHandler.Create(setName, utils.TypeHashIP, utils.OptionTimeout, "0")
+		if err != nil {
+			return fmt.Errorf("failed to create ipset %s: %v", setName, err)
+		}
+		if err = set.Refresh(entries); err != nil {
+			return fmt.Errorf("failed to sync ipset %s: %v", setName, err)
+		}
+	}
+	return nil
+}
+
+// nodeFirewallLocalIPs returns the addresses of the address family of the node that are firewalled, which are all of
+// them but the service VIPs and the IPv6 link-local addresses
+func nodeFirewallLocalIPs(ipFamily v1core.IPFamily) ([]string, error) {
+	family := netlink.FAMILY_V4
+	if ipFamily == v1core.IPv6Protocol {
+		family = netlink.FAMILY_V6
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %v", err)
+	}
+	ips := make([]string, 0)
+	for _, link := range links {
+		if link.Attrs().Name == kubeDummyIfName {
+			continue
+		}
+		addrs, err := netlink.AddrList(link, family)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %v", link.Attrs().Name, err)
+		}
+		for _, addr := range addrs {
+			if ipFamily == v1core.IPv6Protocol && addr.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ips = append(ips, addr.IP.String())
+		}
+	}
+	return ips, nil
+}
+
+// cleanup removes the node firewall rules and ipsets of the address families, it is a no-op when they do not exist
+func (nfc *NodeFirewallController) cleanup() error {
+	if nfc.ipsetMutex != nil {
+		nfc.ipsetMutex.Lock()
+		defer nfc.ipsetMutex.Unlock()
+	}
+	// the rules of both address families are cleaned up when the controller was not configured, as long as the node
+	// has the tooling for them
+	ipFamilies := nfc.ipFamilies
+	if len(ipFamilies) == 0 {
+		ipFamilies = []v1core.IPFamily{v1core.IPv4Protocol, v1core.IPv6Protocol}
+	}
+	removed := false
+	for _, ipFamily := range ipFamilies {
+		protocol := iptables.ProtocolIPv4
+		if ipFamily == v1core.IPv6Protocol {
+			protocol = iptables.ProtocolIPv6
+		}
+		iptablesCmdHandler, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			if len(nfc.ipFamilies) == 0 {
+				klog.Warningf("Skipping the cleanup of the %s node firewall: %v", ipFamily, err)
+				continue
+			}
+			return fmt.Errorf("failed to initialize iptables executor: %v", err)
+		}
+		ipSetHandler, err := utils.NewIPSet(ipFamily == v1core.IPv6Protocol)
+		if err != nil {
+			return fmt.Errorf("failed to create ipsets command executor: %v", err)
+		}
+		if err = ipSetHandler.Save(); err != nil {
+			return fmt.Errorf("failed to list ipsets: %v", err)
+		}
+
+		// the jump matches on the ipset of the local addresses, so it can only exist as long as the ipset does
+		if ipSetHandler.Get(nodeFirewallLocalIPSetName) != nil {
+			err = iptablesCmdHandler.DeleteIfExists("filter", "INPUT", nodeFirewallInputChainRule(ipFamily)...)
+			if err != nil {
+				return fmt.Errorf("failed to delete jump to chain %s: %v", nodeFirewallChainName, err)
+			}
+		}
+		for _, chain := range []string{nodeFirewallChainName, nodeGlobalPolicyChainName} {
+			exists, err := iptablesCmdHandler.ChainExists("filter", chain)
+			if err != nil {
+				return fmt.Errorf("failed to check for chain %s: %v", chain, err)
+			}
+			if !exists {
+				continue
+			}
+			if err = iptablesCmdHandler.ClearAndDeleteChain("filter", chain); err != nil {
+				return fmt.Errorf("failed to delete chain %s: %v", chain, err)
+			}
+			removed = removed || chain == nodeFirewallChainName
+		}
+		delete(nfc.appliedRules, ipFamily)
+
+		for _, setName := range []string{nodeFirewallLocalIPSetName, nodeFirewallNodesIPSetName} {
+			if set := ipSetHandler.Get(setName); set != nil {
+				if err = set.Destroy(); err != nil {
+					return fmt.Errorf("failed to delete ipset %s: %v", setName, err)
+				}
+			}
+		}
+	}
+	if removed {
+		klog.Info("Removed node firewall as neither a NodeFirewall nor a GlobalNetworkPolicy selects the node")
+	}
+	return nil
+}
+
+// Cleanup removes the node firewall configuration done by kube-router
+func (nfc *NodeFirewallController) Cleanup() {
+	klog.Info("Cleaning up NodeFirewallController configurations...")
+	if err := nfc.cleanup(); err != nil {
+		klog.Errorf("Failed to clean up node firewall: %v", err)
+		return
+	}
+	klog.Info("Successfully cleaned the NodeFirewallController configurations done by kube-router")
+}
+
+func (nfc *NodeFirewallController) newNodeEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nfc.RequestSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*v1core.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*v1core.Node)
+			if !ok {
+				return
+			}
+			// labels select the firewalls of the node, addresses and annotations make up the cluster nodes ipset
+			if !reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+				!reflect.DeepEqual(oldNode.Annotations, newNode.Annotations) ||
+				!reflect.DeepEqual(oldNode.Status.Addresses, newNode.Status.Addresses) {
+				nfc.RequestSync()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			nfc.RequestSync()
+		},
+	}
+}
+
+func (nfc *NodeFirewallController) newNodeFirewallEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nfc.RequestSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nfc.RequestSync()
+		},
+		DeleteFunc: func(obj interface{}) {
+			nfc.RequestSync()
+		},
+	}
+}
+
+// NewNodeFirewallController returns new NodeFirewallController object
+func NewNodeFirewallController(clientset kubernetes.Interface, config *options.KubeRouterConfig,
+	nodeInformer cache.SharedIndexInformer, nodeFirewallInformer cache.SharedIndexInformer,
+	ipsetMutex *sync.Mutex) (*NodeFirewallController, error) {
+	nfc := NodeFirewallController{syncPeriod: config.IPTablesSyncPeriod, ipsetMutex: ipsetMutex}
+	nfc.syncRequestChan = make(chan struct{}, 1)
+
+	if config.EnableIPv4 {
+		nfc.ipFamilies = append(nfc.ipFamilies, v1core.IPv4Protocol)
+	}
+	if config.EnableIPv6 {
+		nfc.ipFamilies = append(nfc.ipFamilies, v1core.IPv6Protocol)
+	}
+	if len(nfc.ipFamilies) == 0 {
+		return nil, errors.New("the node firewall can only be enforced with --enable-ipv4 or --enable-ipv6")
+	}
+	nfc.iptablesSaveRestore = make(map[v1core.IPFamily]*utils.IPTablesSaveRestore)
+	for _, ipFamily := range nfc.ipFamilies {
+		nfc.iptablesSaveRestore[ipFamily] = utils.NewIPTablesSaveRestore(ipFamily)
+	}
+	nfc.appliedRules = make(map[v1core.IPFamily]map[string][][]string)
+
+	node, err := utils.GetNodeObject(clientset, config.HostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+	nfc.nodeName = node.Name
+
+	nfc.nodeLister = nodeInformer.GetIndexer()
+	nfc.NodeEventHandler = nfc.newNodeEventHandler()
+
+	nfc.nodeFirewallLister = nodeFirewallInformer.GetIndexer()
+	nfc.NodeFirewallEventHandler = nfc.newNodeFirewallEventHandler()
+
+	return &nfc, nil
+}
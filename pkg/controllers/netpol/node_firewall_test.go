@@ -0,0 +1,160 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newNodeFirewall(name string, defaultDeny bool, ingress ...v1alpha1.NodeFirewallIngressRule) *v1alpha1.NodeFirewall {
+	return &v1alpha1.NodeFirewall{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1alpha1.NodeFirewallSpec{DefaultDeny: defaultDeny, Ingress: ingress},
+	}
+}
+
+func Test_nodeFirewallRules(t *testing.T) {
+	baseRules := [][]string{
+		{"-m", "comment", "--comment", "allow loopback traffic to the node", "-i", "lo", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "allow return traffic to the node",
+			"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	denyRule := []string{"-m", "comment", "--comment", "default deny of the node firewalls", "-j", "DROP"}
+	sshComment := []string{"-m", "comment", "--comment", "allow ingress of node firewall ssh"}
+	nodesComment := []string{"-m", "comment", "--comment", "allow ingress of node firewall nodes"}
+
+	testcases := []struct {
+		name      string
+		firewalls []*v1alpha1.NodeFirewall
+		rules     [][]string
+	}{
+		{
+			"ports from CIDRs with default deny",
+			[]*v1alpha1.NodeFirewall{newNodeFirewall("ssh", true, v1alpha1.NodeFirewallIngressRule{
+				Ports: []v1alpha1.NodeFirewallPort{{Port: 22}, {Protocol: "udp", Port: 30000, EndPort: 32767}},
+				From:  []v1alpha1.NodeFirewallPeer{{CIDR: "192.168.0.0/16"}},
+			})},
+			append(append(baseRules,
+				append(append([]string{}, sshComment...),
+					"-s", "192.168.0.0/16", "-p", "tcp", "-m", "tcp", "--dport", "22", "-j", "ACCEPT"),
+				append(append([]string{}, sshComment...),
+					"-s", "192.168.0.0/16", "-p", "udp", "-m", "udp", "--dport", "30000:32767", "-j", "ACCEPT"),
+			), denyRule),
+		},
+		{
+			"all traffic from the cluster nodes without default deny",
+			[]*v1alpha1.NodeFirewall{newNodeFirewall("nodes", false, v1alpha1.NodeFirewallIngressRule{
+				From: []v1alpha1.NodeFirewallPeer{{ClusterNodes: true}},
+			})},
+			append(baseRules,
+				append(append([]string{}, nodesComment...),
+					"-m", "set", "--match-set", nodeFirewallNodesIPSetName, "src", "-j", "ACCEPT"),
+			),
+		},
+		{
+			"invalid firewall is skipped including its default deny",
+			[]*v1alpha1.NodeFirewall{
+				newNodeFirewall("nodes", false, v1alpha1.NodeFirewallIngressRule{
+					Ports: []v1alpha1.NodeFirewallPort{{Protocol: "IPIP"}},
+					From:  []v1alpha1.NodeFirewallPeer{{ClusterNodes: true}},
+				}),
+				newNodeFirewall("ssh", true, v1alpha1.NodeFirewallIngressRule{
+					Ports: []v1alpha1.NodeFirewallPort{{Protocol: "ICMP", Port: 22}},
+				}),
+			},
+			append(baseRules,
+				append(append([]string{}, nodesComment...),
+					"-m", "set", "--match-set", nodeFirewallNodesIPSetName, "src", "-p", "4", "-j", "ACCEPT"),
+			),
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			assert.Equal(t, testcase.rules, nodeFirewallRules(testcase.firewalls, false, v1core.IPv4Protocol))
+		})
+	}
+}
+
+func Test_nodeFirewallRulesIPv6(t *testing.T) {
+	firewall := newNodeFirewall("mixed", true, v1alpha1.NodeFirewallIngressRule{
+		Ports: []v1alpha1.NodeFirewallPort{{Protocol: "ICMP"}},
+		From:  []v1alpha1.NodeFirewallPeer{{CIDR: "192.168.0.0/16"}, {CIDR: "2001:db8::/64"}, {ClusterNodes: true}},
+	}, v1alpha1.NodeFirewallIngressRule{
+		Ports: []v1alpha1.NodeFirewallPort{{Port: 22}},
+		From:  []v1alpha1.NodeFirewallPeer{{CIDR: "192.168.0.0/16"}},
+	})
+	comment := []string{"-m", "comment", "--comment", "allow ingress of node firewall mixed"}
+
+	rules := nodeFirewallRules([]*v1alpha1.NodeFirewall{firewall}, false, v1core.IPv6Protocol)
+	assert.Equal(t, [][]string{
+		{"-m", "comment", "--comment", "allow neighbor discovery", "-p", "ipv6-icmp", "-m", "icmp6",
+			"--icmpv6-type", "neighbour-solicitation", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "allow neighbor discovery", "-p", "ipv6-icmp", "-m", "icmp6",
+			"--icmpv6-type", "neighbour-advertisement", "-j", "ACCEPT"},
+		append(append([]string{}, comment...), "-s", "2001:db8::/64", "-p", "ipv6-icmp", "-j", "ACCEPT"),
+		append(append([]string{}, comment...), "-m", "set", "--match-set", "inet6:"+nodeFirewallNodesIPSetName,
+			"src", "-p", "ipv6-icmp", "-j", "ACCEPT"),
+		{"-m", "comment", "--comment", "default deny of the node firewalls", "-j", "DROP"},
+	}, rules[2:], "expected the rule with only IPv4 peers to match nothing in IPv6")
+}
+
+func Test_buildNodeFirewallRestore(t *testing.T) {
+	chains := []string{nodeGlobalPolicyChainName, nodeFirewallChainName}
+	chainRules := map[string][][]string{
+		nodeGlobalPolicyChainName: {},
+		nodeFirewallChainName: {{"-m", "comment", "--comment", "allow loopback traffic to the node", "-i", "lo",
+			"-j", "ACCEPT"}},
+	}
+
+	assert.Equal(t, "*filter\n"+
+		":KUBE-ROUTER-NODE-GNP - [0:0]\n"+
+		":KUBE-ROUTER-NODE-FW - [0:0]\n"+
+		"-A KUBE-ROUTER-NODE-FW -m comment --comment \"allow loopback traffic to the node\" -i lo -j ACCEPT\n"+
+		"-A INPUT -m comment --comment \"kube-router node firewall\" -m set --match-set "+
+		"inet6:kube-router-node-fw-local dst -j KUBE-ROUTER-NODE-FW\n"+
+		"COMMIT\n", string(buildNodeFirewallRestore(chains, chainRules, true, v1core.IPv6Protocol)))
+
+	ruleCounts, jumpExists := nodeFirewallChainsState("*filter\n"+
+		":INPUT ACCEPT [0:0]\n"+
+		":KUBE-ROUTER-NODE-FW - [0:0]\n"+
+		":KUBE-ROUTER-NODE-GNP - [0:0]\n"+
+		"-A INPUT -m comment --comment \"kube-router node firewall\" -m set --match-set "+
+		"kube-router-node-fw-local dst -j KUBE-ROUTER-NODE-FW\n"+
+		"-A KUBE-ROUTER-NODE-FW -i lo -m comment --comment \"allow loopback traffic to the node\" -j ACCEPT\n"+
+		"COMMIT\n", chains)
+	assert.True(t, jumpExists)
+	assert.Equal(t, map[string]int{nodeFirewallChainName: 1, nodeGlobalPolicyChainName: 0}, ruleCounts)
+
+	ruleCounts, jumpExists = nodeFirewallChainsState("*filter\n:INPUT ACCEPT [0:0]\nCOMMIT\n", chains)
+	assert.False(t, jumpExists)
+	assert.Empty(t, ruleCounts)
+}
+
+func Test_nodeFirewallPortArgs(t *testing.T) {
+	testcases := []struct {
+		name string
+		port v1alpha1.NodeFirewallPort
+		args []string
+		err  bool
+	}{
+		{"defaults to TCP", v1alpha1.NodeFirewallPort{Port: 10250}, []string{"-p", "tcp", "-m", "tcp", "--dport", "10250"},
+			false},
+		{"all ports of protocol", v1alpha1.NodeFirewallPort{Protocol: "SCTP"}, []string{"-p", "sctp"}, false},
+		{"unknown protocol", v1alpha1.NodeFirewallPort{Protocol: "GRE"}, nil, true},
+		{"end port without port", v1alpha1.NodeFirewallPort{EndPort: 80}, nil, true},
+		{"end port lower than port", v1alpha1.NodeFirewallPort{Port: 80, EndPort: 79}, nil, true},
+		{"port out of range", v1alpha1.NodeFirewallPort{Port: 65536}, nil, true},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			args, err := nodeFirewallPortArgs(testcase.port, v1core.IPv4Protocol)
+			assert.Equal(t, testcase.err, err != nil)
+			assert.Equal(t, testcase.args, args)
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package netpol
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base32"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	api "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
@@ -50,9 +55,9 @@ func (npc *NetworkPolicyController) newPodEventHandler() cache.ResourceEventHand
 // OnPodUpdate handles updates to pods from the Kubernetes api server
 func (npc *NetworkPolicyController) OnPodUpdate(obj interface{}) {
 	pod := obj.(*api.Pod)
-	klog.V(2).Infof("Received update to pod: %s/%s", pod.Namespace, pod.Name)
+	npc.logger.V(2).Info("Received update to pod", utils.LogKeyNamespace, pod.Namespace, utils.LogKeyPod, pod.Name)
 
-	npc.RequestFullSync()
+	npc.RequestPodSync(pod.Namespace + "/" + pod.Name)
 }
 
 func (npc *NetworkPolicyController) handlePodDelete(obj interface{}) {
@@ -68,75 +73,143 @@ func (npc *NetworkPolicyController) handlePodDelete(obj interface{}) {
 			return
 		}
 	}
-	klog.V(2).Infof("Received pod: %s/%s delete event", pod.Namespace, pod.Name)
+	npc.logger.V(2).Info("Received pod delete event", utils.LogKeyNamespace, pod.Namespace, utils.LogKeyPod, pod.Name)
 
-	npc.RequestFullSync()
+	npc.RequestPodSync(pod.Namespace + "/" + pod.Name)
 }
 
 func (npc *NetworkPolicyController) syncPodFirewallChains(networkPoliciesInfo []networkPolicyInfo,
-	version string) map[string]bool {
+	adminPoliciesInfo []adminNetworkPolicyInfo, localPods map[string]podInfo, version string) map[string]bool {
 
 	activePodFwChains := make(map[string]bool)
 
-	dropUnmarkedTrafficRules := func(podName, podNamespace, podFwChainName string) {
-		// add rule to log the packets that will be dropped due to network policy enforcement
-		comment := "\"rule to log dropped traffic POD name:" + podName + " namespace: " + podNamespace + "\""
-		args := []string{"-A", podFwChainName, "-m", "comment", "--comment", comment,
-			"-m", "mark", "!", "--mark", "0x10000/0x10000", "-j", "NFLOG",
-			"--nflog-group", "100", "-m", "limit", "--limit", "10/minute", "--limit-burst", "10", "\n"}
-		// This used to be AppendUnique when we were using iptables directly, this checks to make sure we didn't drop
-		// unmarked for this chain already
-		if strings.Contains(npc.filterTableRules.String(), strings.Join(args, " ")) {
-			return
+	// the pods are sorted so that their rules are merged in the same order in every sync
+	pods := make([]podInfo, 0, len(localPods))
+	for _, pod := range localPods {
+		pods = append(pods, pod)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].namespace != pods[j].namespace {
+			return pods[i].namespace < pods[j].namespace
+		}
+		return pods[i].name < pods[j].name
+	})
+
+	// the rules of each pod are generated into buffers of their own by a bounded pool of workers, as this is most of
+	// the work of a sync on nodes with many pods, and are merged in the order of the pods before iptables-restore
+	podRules := make([]map[api.IPFamily]*bytes.Buffer, len(pods))
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(pods) {
+		workers = len(pods)
+	}
+	podIndexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range podIndexes {
+				podRules[i] = npc.podFirewallChainRules(pods[i], podFirewallChainName(pods[i].namespace, pods[i].name,
+					version), networkPoliciesInfo, adminPoliciesInfo, version)
+			}
+		}()
+	}
+	for i := range pods {
+		podIndexes <- i
+	}
+	close(podIndexes)
+	wg.Wait()
+
+	for i, pod := range pods {
+		activePodFwChains[podFirewallChainName(pod.namespace, pod.name, version)] = true
+		for _, ipFamily := range npc.ipFamilies {
+			if rules, ok := podRules[i][ipFamily]; ok {
+				npc.filterTableRules[ipFamily].Write(rules.Bytes())
+			}
 		}
-		npc.filterTableRules.WriteString(strings.Join(args, " "))
-
-		// add rule to DROP if no applicable network policy permits the traffic
-		comment = "\"rule to REJECT traffic destined for POD name:" + podName + " namespace: " + podNamespace + "\""
-		args = []string{"-A", podFwChainName, "-m", "comment", "--comment", comment,
-			"-m", "mark", "!", "--mark", "0x10000/0x10000", "-j", "REJECT", "\n"}
-		npc.filterTableRules.WriteString(strings.Join(args, " "))
-
-		// reset mark to let traffic pass through rest of the chains
-		args = []string{"-A", podFwChainName, "-j", "MARK", "--set-mark", "0/0x10000", "\n"}
-		npc.filterTableRules.WriteString(strings.Join(args, " "))
 	}
 
-	// loop through the pods running on the node
-	allLocalPods := npc.getLocalPods(npc.nodeIP.String())
-	for _, pod := range *allLocalPods {
-
-		// ensure pod specific firewall chain exist for all the pods that need ingress firewall
-		podFwChainName := podFirewallChainName(pod.namespace, pod.name, version)
-		npc.filterTableRules.WriteString(":" + podFwChainName + "\n")
+	return activePodFwChains
+}
 
-		activePodFwChains[podFwChainName] = true
+// podFirewallChainRules returns the rules of the firewall chain of the pod, and of the rules intercepting its traffic,
+// in each address family the pod has an IP of. It only reads the state of the controller so that the rules of
+// several pods can be generated concurrently.
+func (npc *NetworkPolicyController) podFirewallChainRules(pod podInfo, podFwChainName string,
+	networkPoliciesInfo []networkPolicyInfo, adminPoliciesInfo []adminNetworkPolicyInfo,
+	version string) map[api.IPFamily]*bytes.Buffer {
+	podRules := make(map[api.IPFamily]*bytes.Buffer, len(npc.ipFamilies))
+
+	// the pod firewall chain is set up in each address family the pod has an IP of
+	for _, ipFamily := range npc.ipFamilies {
+		if pod.ipOfFamily(ipFamily) == "" {
+			continue
+		}
+		filterTableRules := &bytes.Buffer{}
+		podRules[ipFamily] = filterTableRules
+		filterTableRules.WriteString(":" + podFwChainName + "\n")
 
 		// setup rules to run through applicable ingress/egress network policies for the pod
-		npc.setupPodNetpolRules(pod, podFwChainName, networkPoliciesInfo, version)
+		npc.setupPodNetpolRules(filterTableRules, pod, podFwChainName, networkPoliciesInfo, adminPoliciesInfo, version,
+			ipFamily)
 
 		// setup rules to intercept inbound traffic to the pods
-		npc.interceptPodInboundTraffic(pod, podFwChainName)
+		npc.interceptPodInboundTraffic(filterTableRules, pod, podFwChainName, ipFamily)
 
 		// setup rules to intercept inbound traffic to the pods
-		npc.interceptPodOutboundTraffic(pod, podFwChainName)
+		npc.interceptPodOutboundTraffic(filterTableRules, pod, podFwChainName, ipFamily)
 
-		dropUnmarkedTrafficRules(pod.name, pod.namespace, podFwChainName)
+		// log the traffic dropped because of the policies with logging enabled, before it gets dropped
+		npc.logPodPolicyDenies(filterTableRules, pod, podFwChainName, networkPoliciesInfo, ipFamily)
+
+		npc.dropUnmarkedTrafficRules(filterTableRules, pod.name, pod.namespace, podFwChainName,
+			npc.podDenyAction(pod, networkPoliciesInfo), ipFamily)
 
 		// set mark to indicate traffic from/to the pod passed network policies.
 		// Mark will be checked to explicitly ACCEPT the traffic
 		comment := "\"set mark to ACCEPT traffic that comply to network policies\""
 		args := []string{"-A", podFwChainName, "-m", "comment", "--comment", comment,
 			"-j", "MARK", "--set-mark", "0x20000/0x20000", "\n"}
-		npc.filterTableRules.WriteString(strings.Join(args, " "))
+		filterTableRules.WriteString(strings.Join(args, " "))
 	}
 
-	return activePodFwChains
+	return podRules
+}
+
+func (npc *NetworkPolicyController) dropUnmarkedTrafficRules(filterTableRules *bytes.Buffer, podName, podNamespace,
+	podFwChainName string, action denyAction, ipFamily api.IPFamily) {
+	// add rule to log the packets that will be dropped due to network policy enforcement
+	comment := "\"rule to log dropped traffic POD name:" + podName + " namespace: " + podNamespace + "\""
+	args := []string{"-A", podFwChainName, "-m", "comment", "--comment", comment,
+		"-m", "mark", "!", "--mark", "0x10000/0x10000"}
+	args = append(append(args, npc.nflog().dropTarget()...), "\n")
+	// This used to be AppendUnique when we were using iptables directly, this checks to make sure we didn't drop
+	// unmarked for this chain already
+	if strings.Contains(filterTableRules.String(), strings.Join(args, " ")) {
+		return
+	}
+	filterTableRules.WriteString(strings.Join(args, " "))
+
+	// add rule to DROP if no applicable network policy permits the traffic
+	comment = "\"rule to " + action.verdict() + " traffic destined for POD name:" + podName + " namespace: " +
+		podNamespace + "\""
+	for _, denyRule := range action.rules(ipFamily) {
+		args = []string{"-A", podFwChainName, "-m", "comment", "--comment", comment,
+			"-m", "mark", "!", "--mark", "0x10000/0x10000"}
+		args = append(append(args, denyRule...), "\n")
+		filterTableRules.WriteString(strings.Join(args, " "))
+	}
+
+	// reset the marks of the policies to let traffic pass through rest of the chains
+	args = []string{"-A", podFwChainName, "-j", "MARK", "--set-mark", "0/0x50000", "\n"}
+	filterTableRules.WriteString(strings.Join(args, " "))
 }
 
 // setup rules to jump to applicable network policy chains for the traffic from/to the pod
-func (npc *NetworkPolicyController) setupPodNetpolRules(pod podInfo, podFwChainName string,
-	networkPoliciesInfo []networkPolicyInfo, version string) {
+func (npc *NetworkPolicyController) setupPodNetpolRules(filterTableRules *bytes.Buffer, pod podInfo,
+	podFwChainName string, networkPoliciesInfo []networkPolicyInfo, adminPoliciesInfo []adminNetworkPolicyInfo,
+	version string, ipFamily api.IPFamily) {
+	podIP := pod.ipOfFamily(ipFamily)
 
 	hasIngressPolicy := false
 	hasEgressPolicy := false
@@ -157,72 +230,105 @@ func (npc *NetworkPolicyController) setupPodNetpolRules(pod podInfo, podFwChainN
 				"-j", policyChainName, "\n"}
 		case kubeIngressPolicyType:
 			hasIngressPolicy = true
-			args = []string{"-I", podFwChainName, "1", "-d", pod.ip, "-m", "comment", "--comment", comment,
+			args = []string{"-I", podFwChainName, "1", "-d", podIP, "-m", "comment", "--comment", comment,
 				"-j", policyChainName, "\n"}
 		case kubeEgressPolicyType:
 			hasEgressPolicy = true
-			args = []string{"-I", podFwChainName, "1", "-s", pod.ip, "-m", "comment", "--comment", comment,
+			args = []string{"-I", podFwChainName, "1", "-s", podIP, "-m", "comment", "--comment", comment,
 				"-j", policyChainName, "\n"}
 		}
-		npc.filterTableRules.WriteString(strings.Join(args, " "))
+		filterTableRules.WriteString(strings.Join(args, " "))
 	}
 
 	// if pod does not have any network policy which applies rules for pod's ingress traffic
 	// then apply default network policy
-	if !hasIngressPolicy {
+	// or, when its namespace is isolated, only for the traffic from its own namespace and from outside the pod network
+	if !hasIngressPolicy && npc.isNamespaceIsolated(pod.namespace) {
+		npc.isolatePodNamespace(filterTableRules, pod, podFwChainName, ipFamily)
+	} else if !hasIngressPolicy {
 		comment := "\"run through default ingress network policy  chain\""
-		args := []string{"-I", podFwChainName, "1", "-d", pod.ip, "-m", "comment", "--comment", comment,
+		args := []string{"-I", podFwChainName, "1", "-d", podIP, "-m", "comment", "--comment", comment,
 			"-j", kubeDefaultNetpolChain, "\n"}
-		npc.filterTableRules.WriteString(strings.Join(args, " "))
+		filterTableRules.WriteString(strings.Join(args, " "))
 	}
 
 	// if pod does not have any network policy which applies rules for pod's egress traffic
 	// then apply default network policy
 	if !hasEgressPolicy {
 		comment := "\"run through default egress network policy  chain\""
-		args := []string{"-I", podFwChainName, "1", "-s", pod.ip, "-m", "comment", "--comment", comment,
+		args := []string{"-I", podFwChainName, "1", "-s", podIP, "-m", "comment", "--comment", comment,
 			"-j", kubeDefaultNetpolChain, "\n"}
-		npc.filterTableRules.WriteString(strings.Join(args, " "))
+		filterTableRules.WriteString(strings.Join(args, " "))
 	}
 
+	// the admin network policies are evaluated before the network policies and the default network policy chain
+	npc.setupPodAdminNetpolRules(filterTableRules, pod, podFwChainName, adminPoliciesInfo, hasIngressPolicy,
+		hasEgressPolicy, version, ipFamily)
+
 	comment := "\"rule to permit the traffic to pods when source is the pod's local node\""
 	args := []string{"-I", podFwChainName, "1", "-m", "comment", "--comment", comment,
-		"-m", "addrtype", "--src-type", "LOCAL", "-d", pod.ip, "-j", "ACCEPT", "\n"}
-	npc.filterTableRules.WriteString(strings.Join(args, " "))
+		"-m", "addrtype", "--src-type", "LOCAL", "-d", podIP, "-j", "ACCEPT", "\n"}
+	filterTableRules.WriteString(strings.Join(args, " "))
+
+	// the replies of the node-local DNS cache are permitted by the rule above as it is a local address, the queries
+	// to it are permitted as well since the pods are configured to use it instead of the cluster DNS service
+	if npc.nodeLocalDNSIP != nil && ipFamilyOfIP(npc.nodeLocalDNSIP) == ipFamily {
+		comment = "\"rule to permit the DNS queries from the pod to the node-local DNS cache\""
+		for _, protocol := range []string{"udp", "tcp"} {
+			args = []string{"-I", podFwChainName, "1", "-m", "comment", "--comment", comment,
+				"-s", podIP, "-d", npc.nodeLocalDNSIP.String(), "-p", protocol, "--dport", "53", "-j", "ACCEPT", "\n"}
+			filterTableRules.WriteString(strings.Join(args, " "))
+		}
+	}
+
+	// the traffic exempted from connection tracking with --notrack-cidrs and --notrack-ports is never seen as
+	// RELATED,ESTABLISHED, so its replies would be dropped by the policies that only permit one direction of the flow.
+	// It is permitted as a whole instead, the operator opting out of conntrack opts out of the network policies too.
+	if npc.untrackedTraffic {
+		comment = "\"rule to permit the traffic from/to the pod exempted from connection tracking\""
+		args = []string{"-I", podFwChainName, "1", "-m", "comment", "--comment", comment,
+			"-m", "conntrack", "--ctstate", "UNTRACKED", "-j", "ACCEPT", "\n"}
+		filterTableRules.WriteString(strings.Join(args, " "))
+	}
 
 	// ensure statefull firewall drops INVALID state traffic from/to the pod
 	// For full context see: https://bugzilla.netfilter.org/show_bug.cgi?id=693
 	// The NAT engine ignores any packet with state INVALID, because there's no reliable way to determine what kind of
 	// NAT should be performed. So the proper way to prevent the leakage is to drop INVALID packets.
-	// In the future, if we ever allow services or nodes to disable conntrack checking, we may need to make this
-	// conditional so that non-tracked traffic doesn't get dropped as invalid.
+	// Untracked traffic is in the UNTRACKED state rather than INVALID, so it doesn't get dropped here.
 	comment = "\"rule to drop invalid state for pod\""
 	args = []string{"-I", podFwChainName, "1", "-m", "comment", "--comment", comment,
 		"-m", "conntrack", "--ctstate", "INVALID", "-j", "DROP", "\n"}
-	npc.filterTableRules.WriteString(strings.Join(args, " "))
+	filterTableRules.WriteString(strings.Join(args, " "))
 
 	// ensure statefull firewall that permits RELATED,ESTABLISHED traffic from/to the pod
 	comment = "\"rule for stateful firewall for pod\""
 	args = []string{"-I", podFwChainName, "1", "-m", "comment", "--comment", comment,
 		"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT", "\n"}
-	npc.filterTableRules.WriteString(strings.Join(args, " "))
+	filterTableRules.WriteString(strings.Join(args, " "))
 
 }
 
-func (npc *NetworkPolicyController) interceptPodInboundTraffic(pod podInfo, podFwChainName string) {
+func (npc *NetworkPolicyController) interceptPodInboundTraffic(filterTableRules *bytes.Buffer, pod podInfo,
+	podFwChainName string, ipFamily api.IPFamily) {
+	podIP := pod.ipOfFamily(ipFamily)
 	// ensure there is rule in filter table and FORWARD chain to jump to pod specific firewall chain
 	// this rule applies to the traffic getting routed (coming for other node pods)
 	comment := "\"rule to jump traffic destined to POD name:" + pod.name + " namespace: " + pod.namespace +
 		" to chain " + podFwChainName + "\""
-	args := []string{"-A", kubeForwardChainName, "-m", "comment", "--comment", comment, "-d", pod.ip,
+	args := []string{"-A", kubeForwardChainName, "-m", "comment", "--comment", comment, "-d", podIP,
 		"-j", podFwChainName + "\n"}
-	npc.filterTableRules.WriteString(strings.Join(args, " "))
+	filterTableRules.WriteString(strings.Join(args, " "))
 
 	// ensure there is rule in filter table and OUTPUT chain to jump to pod specific firewall chain
 	// this rule applies to the traffic from a pod getting routed back to another pod on same node by service proxy
-	args = []string{"-A", kubeOutputChainName, "-m", "comment", "--comment", comment, "-d", pod.ip,
+	args = []string{"-A", kubeOutputChainName, "-m", "comment", "--comment", comment, "-d", podIP,
 		"-j", podFwChainName + "\n"}
-	npc.filterTableRules.WriteString(strings.Join(args, " "))
+	filterTableRules.WriteString(strings.Join(args, " "))
+
+	if !npc.bridgedPodTraffic {
+		return
+	}
 
 	// ensure there is rule in filter table and forward chain to jump to pod specific firewall chain
 	// this rule applies to the traffic getting switched (coming for same node pods)
@@ -230,22 +336,28 @@ func (npc *NetworkPolicyController) interceptPodInboundTraffic(pod podInfo, podF
 		" to chain " + podFwChainName + "\""
 	args = []string{"-A", kubeForwardChainName, "-m", "physdev", "--physdev-is-bridged",
 		"-m", "comment", "--comment", comment,
-		"-d", pod.ip,
+		"-d", podIP,
 		"-j", podFwChainName, "\n"}
-	npc.filterTableRules.WriteString(strings.Join(args, " "))
+	filterTableRules.WriteString(strings.Join(args, " "))
 }
 
 // setup iptable rules to intercept outbound traffic from pods and run it across the
 // firewall chain corresponding to the pod so that egress network policies are enforced
-func (npc *NetworkPolicyController) interceptPodOutboundTraffic(pod podInfo, podFwChainName string) {
+func (npc *NetworkPolicyController) interceptPodOutboundTraffic(filterTableRules *bytes.Buffer, pod podInfo,
+	podFwChainName string, ipFamily api.IPFamily) {
+	podIP := pod.ipOfFamily(ipFamily)
 	for _, chain := range defaultChains {
 		// ensure there is rule in filter table and FORWARD chain to jump to pod specific firewall chain
 		// this rule applies to the traffic getting forwarded/routed (traffic from the pod destined
 		// to pod on a different node)
 		comment := "\"rule to jump traffic from POD name:" + pod.name + " namespace: " + pod.namespace +
 			" to chain " + podFwChainName + "\""
-		args := []string{"-A", chain, "-m", "comment", "--comment", comment, "-s", pod.ip, "-j", podFwChainName, "\n"}
-		npc.filterTableRules.WriteString(strings.Join(args, " "))
+		args := []string{"-A", chain, "-m", "comment", "--comment", comment, "-s", podIP, "-j", podFwChainName, "\n"}
+		filterTableRules.WriteString(strings.Join(args, " "))
+	}
+
+	if !npc.bridgedPodTraffic {
+		return
 	}
 
 	// ensure there is rule in filter table and forward chain to jump to pod specific firewall chain
@@ -254,9 +366,9 @@ func (npc *NetworkPolicyController) interceptPodOutboundTraffic(pod podInfo, pod
 		" to chain " + podFwChainName + "\""
 	args := []string{"-A", kubeForwardChainName, "-m", "physdev", "--physdev-is-bridged",
 		"-m", "comment", "--comment", comment,
-		"-s", pod.ip,
+		"-s", podIP,
 		"-j", podFwChainName, "\n"}
-	npc.filterTableRules.WriteString(strings.Join(args, " "))
+	filterTableRules.WriteString(strings.Join(args, " "))
 }
 
 func (npc *NetworkPolicyController) getLocalPods(nodeIP string) *map[string]podInfo {
@@ -267,10 +379,12 @@ func (npc *NetworkPolicyController) getLocalPods(nodeIP string) *map[string]podI
 		if strings.Compare(pod.Status.HostIP, nodeIP) != 0 || !isNetPolActionable(pod) {
 			continue
 		}
-		localPods[pod.Status.PodIP] = podInfo{ip: pod.Status.PodIP,
-			name:      pod.ObjectMeta.Name,
-			namespace: pod.ObjectMeta.Namespace,
-			labels:    pod.ObjectMeta.Labels}
+		// the pods of the namespaces that opted out of the network policies get no firewall chain, so that their
+		// traffic isn't intercepted
+		if npc.isNetpolDisabled(pod.Namespace) {
+			continue
+		}
+		localPods[pod.Status.PodIP] = newPodInfo(pod)
 	}
 	return &localPods
 }
@@ -0,0 +1,193 @@
+package netpol
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	api "k8s.io/api/core/v1"
+)
+
+func Test_interceptPodTrafficPhysdevRules(t *testing.T) {
+	testcases := []struct {
+		name              string
+		bridgedPodTraffic bool
+		expectPhysdev     bool
+	}{
+		{"bridged pod traffic is also intercepted on the bridge", true, true},
+		{"routed pod traffic is only intercepted in the FORWARD chain", false, false},
+	}
+
+	pod := podInfo{ip: "10.1.1.1", name: "test-pod", namespace: "test-ns"}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			npc := &NetworkPolicyController{bridgedPodTraffic: tc.bridgedPodTraffic,
+				filterTableRules: tNewFilterTableRules()}
+			npc.interceptPodInboundTraffic(npc.filterTableRules[api.IPv4Protocol], pod,
+				"KUBE-POD-FW-TEST", api.IPv4Protocol)
+			npc.interceptPodOutboundTraffic(npc.filterTableRules[api.IPv4Protocol], pod,
+				"KUBE-POD-FW-TEST", api.IPv4Protocol)
+			rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+			if hasPhysdev := strings.Contains(rules, "--physdev-is-bridged"); hasPhysdev != tc.expectPhysdev {
+				t.Errorf("expected physdev rules: %v, got rules:\n%s", tc.expectPhysdev, rules)
+			}
+			for _, match := range []string{"-d 10.1.1.1", "-s 10.1.1.1"} {
+				if !strings.Contains(rules, "-A "+kubeForwardChainName) || !strings.Contains(rules, match) {
+					t.Errorf("expected FORWARD chain rule matching %s, got rules:\n%s", match, rules)
+				}
+			}
+		})
+	}
+}
+
+func Test_setupPodNetpolRulesNodeLocalDNS(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", name: "test-pod", namespace: "test-ns"}
+	for _, nodeLocalDNSIP := range []net.IP{nil, net.ParseIP("169.254.20.10")} {
+		npc := &NetworkPolicyController{nodeLocalDNSIP: nodeLocalDNSIP, filterTableRules: tNewFilterTableRules()}
+		npc.setupPodNetpolRules(npc.filterTableRules[api.IPv4Protocol], pod,
+			"KUBE-POD-FW-TEST", nil, nil, "1", api.IPv4Protocol)
+		rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+		for _, protocol := range []string{"udp", "tcp"} {
+			rule := "-s 10.1.1.1 -d 169.254.20.10 -p " + protocol + " --dport 53 -j ACCEPT"
+			if strings.Contains(rules, rule) != (nodeLocalDNSIP != nil) {
+				t.Errorf("expected rule %q: %v, got rules:\n%s", rule, nodeLocalDNSIP != nil, rules)
+			}
+		}
+	}
+}
+
+func Test_setupPodNetpolRulesUntrackedTraffic(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", name: "test-pod", namespace: "test-ns"}
+	for _, untrackedTraffic := range []bool{false, true} {
+		npc := &NetworkPolicyController{untrackedTraffic: untrackedTraffic, filterTableRules: tNewFilterTableRules()}
+		npc.setupPodNetpolRules(npc.filterTableRules[api.IPv4Protocol], pod,
+			"KUBE-POD-FW-TEST", nil, nil, "1", api.IPv4Protocol)
+		rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+		if strings.Contains(rules, "--ctstate UNTRACKED -j ACCEPT") != untrackedTraffic {
+			t.Errorf("expected untracked traffic rule: %v, got rules:\n%s", untrackedTraffic, rules)
+		}
+	}
+}
+
+func Test_setupPodNetpolRulesNamespaceIsolation(t *testing.T) {
+	testcases := []struct {
+		name               string
+		namespace          string
+		namespaceIsolation bool
+		expectIsolation    bool
+	}{
+		{"namespaces aren't isolated by default", "test-ns", false, false},
+		{"pods of isolated namespaces only accept traffic from their namespace", "test-ns", true, true},
+		{"pods of exempt namespaces accept traffic from all namespaces", "kube-system", true, false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := podInfo{ip: "10.1.1.1", name: "test-pod", namespace: tc.namespace}
+			npc := &NetworkPolicyController{
+				namespaceIsolation:       tc.namespaceIsolation,
+				namespaceIsolationExempt: map[string]bool{"kube-system": true},
+				filterTableRules:         tNewFilterTableRules(),
+			}
+			npc.setupPodNetpolRules(npc.filterTableRules[api.IPv4Protocol], pod,
+				"KUBE-POD-FW-TEST", nil, nil, "1", api.IPv4Protocol)
+			rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+			isolationRules := []string{
+				"--match-set " + namespacePodsIPSetName(tc.namespace) + " src -j " + kubeDefaultNetpolChain,
+				"! --match-set " + allPodsIPSetName + " src -j " + kubeDefaultNetpolChain,
+			}
+			for _, rule := range isolationRules {
+				if strings.Contains(rules, rule) != tc.expectIsolation {
+					t.Errorf("expected rule %q: %v, got rules:\n%s", rule, tc.expectIsolation, rules)
+				}
+			}
+			if strings.Contains(rules, "-d 10.1.1.1 -m comment --comment \"run through default ingress network "+
+				"policy  chain\" -j "+kubeDefaultNetpolChain) == tc.expectIsolation {
+				t.Errorf("expected unconditional default ingress rule: %v, got rules:\n%s", !tc.expectIsolation, rules)
+			}
+		})
+	}
+}
+
+func Test_setupPodNetpolRulesDualStack(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", ips: []api.PodIP{{IP: "10.1.1.1"}, {IP: "2001:db8::1"}}, name: "test-pod",
+		namespace: "test-ns"}
+	npc := &NetworkPolicyController{
+		ipFamilies:               []api.IPFamily{api.IPv4Protocol, api.IPv6Protocol},
+		nodeLocalDNSIP:           net.ParseIP("169.254.20.10"),
+		namespaceIsolation:       true,
+		namespaceIsolationExempt: map[string]bool{},
+		filterTableRules:         tNewFilterTableRules(),
+	}
+	for _, ipFamily := range npc.ipFamilies {
+		npc.setupPodNetpolRules(npc.filterTableRules[ipFamily], pod, "KUBE-POD-FW-TEST", nil, nil, "1", ipFamily)
+	}
+	v4Rules := npc.filterTableRules[api.IPv4Protocol].String()
+	v6Rules := npc.filterTableRules[api.IPv6Protocol].String()
+
+	if !strings.Contains(v4Rules, "-d 10.1.1.1") || strings.Contains(v4Rules, "2001:db8::1") {
+		t.Errorf("expected only IPv4 rules, got rules:\n%s", v4Rules)
+	}
+	if !strings.Contains(v6Rules, "-d 2001:db8::1") || strings.Contains(v6Rules, "10.1.1.1") {
+		t.Errorf("expected only IPv6 rules, got rules:\n%s", v6Rules)
+	}
+	if strings.Contains(v6Rules, "169.254.20.10") {
+		t.Errorf("expected no IPv6 rule for the IPv4 node-local DNS cache, got rules:\n%s", v6Rules)
+	}
+	if !strings.Contains(v6Rules, "--match-set inet6:"+namespacePodsIPSetName("test-ns")+" src") {
+		t.Errorf("expected the IPv6 rules to match the IPv6 ipsets, got rules:\n%s", v6Rules)
+	}
+}
+
+func Test_syncPodFirewallChainsParallel(t *testing.T) {
+	localPods := make(map[string]podInfo)
+	for i := 0; i < 250; i++ {
+		ip := fmt.Sprintf("10.1.%d.%d", i/100, i%100+1)
+		localPods[ip] = podInfo{ip: ip, ips: []api.PodIP{{IP: ip}, {IP: fmt.Sprintf("2001:db8::%x", i+1)}},
+			name: fmt.Sprintf("pod-%03d", i), namespace: "test-ns"}
+	}
+	policies := []networkPolicyInfo{{name: "default-deny", namespace: "test-ns", targetPods: localPods,
+		policyType: kubeIngressPolicyType}}
+	syncRules := func() map[api.IPFamily]string {
+		npc := &NetworkPolicyController{ipFamilies: []api.IPFamily{api.IPv4Protocol, api.IPv6Protocol},
+			filterTableRules: tNewFilterTableRules()}
+		activePodFwChains := npc.syncPodFirewallChains(policies, nil, localPods, "1")
+		if len(activePodFwChains) != len(localPods) {
+			t.Errorf("expected %d pod firewall chains, got %d", len(localPods), len(activePodFwChains))
+		}
+		return map[api.IPFamily]string{api.IPv4Protocol: npc.filterTableRules[api.IPv4Protocol].String(),
+			api.IPv6Protocol: npc.filterTableRules[api.IPv6Protocol].String()}
+	}
+
+	rules := syncRules()
+	for ipFamily, familyRules := range rules {
+		chains := rulesOfChains(familyRules, map[string]bool{podFirewallChainName("test-ns", "pod-000", "1"): true,
+			podFirewallChainName("test-ns", "pod-249", "1"): true})
+		if len(chains) != 2 {
+			t.Errorf("expected the %s rules of the first and last pods, got rules:\n%s", ipFamily, familyRules)
+		}
+		for chain, chainRules := range chains {
+			if len(chainRules) == 0 {
+				t.Errorf("expected the %s rules of chain %s, got rules:\n%s", ipFamily, chain, familyRules)
+			}
+		}
+	}
+	if !strings.HasPrefix(rules[api.IPv4Protocol], ":"+podFirewallChainName("test-ns", "pod-000", "1")+"\n") {
+		t.Errorf("expected the rules of the pods to be in the order of the pods")
+	}
+	sortedRules := func(rules string) []string {
+		lines := strings.Split(rules, "\n")
+		sort.Strings(lines)
+		return lines
+	}
+	if !reflect.DeepEqual(sortedRules(syncRules()[api.IPv4Protocol]), sortedRules(rules[api.IPv4Protocol])) {
+		t.Errorf("expected the syncs to generate the same rules")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base32"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -40,7 +41,8 @@ func (npc *NetworkPolicyController) newNetworkPolicyEventHandler() cache.Resourc
 // OnNetworkPolicyUpdate handles updates to network policy from the kubernetes api server
 func (npc *NetworkPolicyController) OnNetworkPolicyUpdate(obj interface{}) {
 	netpol := obj.(*networking.NetworkPolicy)
-	klog.V(2).Infof("Received update for network policy: %s/%s", netpol.Namespace, netpol.Name)
+	npc.logger.V(2).Info("Received update for network policy", utils.LogKeyNamespace, netpol.Namespace,
+		utils.LogKeyPolicy, netpol.Name)
 
 	npc.RequestFullSync()
 }
@@ -58,7 +60,8 @@ func (npc *NetworkPolicyController) handleNetworkPolicyDelete(obj interface{}) {
 			return
 		}
 	}
-	klog.V(2).Infof("Received network policy: %s/%s delete event", netpol.Namespace, netpol.Name)
+	npc.logger.V(2).Info("Received network policy delete event", utils.LogKeyNamespace, netpol.Namespace,
+		utils.LogKeyPolicy, netpol.Name)
 
 	npc.RequestFullSync()
 }
@@ -69,7 +72,7 @@ func (npc *NetworkPolicyController) handleNetworkPolicyDelete(obj interface{}) {
 // policyspec is evaluated to set of matching pods, which are grouped in to a
 // ipset used for source ip addr matching.
 func (npc *NetworkPolicyController) syncNetworkPolicyChains(networkPoliciesInfo []networkPolicyInfo,
-	version string) (map[string]bool, map[string]bool, error) {
+	adminPoliciesInfo []adminNetworkPolicyInfo, version string) (map[string]bool, map[string]bool, error) {
 	start := time.Now()
 	defer func() {
 		endTime := time.Since(start)
@@ -85,15 +88,19 @@ func (npc *NetworkPolicyController) syncNetworkPolicyChains(networkPoliciesInfo
 		klog.V(1).Infof("Returned ipset mutex lock")
 	}()
 
-	ipset, err := utils.NewIPSet(false)
-	if err != nil {
-		return nil, nil, err
-	}
-	err = ipset.Save()
-	if err != nil {
-		return nil, nil, err
+	savedIPSetEntries := make(map[api.IPFamily]map[string][]string)
+	for _, ipFamily := range npc.ipFamilies {
+		ipset, err := utils.NewIPSet(ipFamily == api.IPv6Protocol)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = ipset.Save()
+		if err != nil {
+			return nil, nil, err
+		}
+		npc.ipSetHandlers[ipFamily] = ipset
+		savedIPSetEntries[ipFamily] = ipSetEntries(ipset)
 	}
-	npc.ipSetHandler = ipset
 
 	activePolicyChains := make(map[string]bool)
 	activePolicyIPSets := make(map[string]bool)
@@ -103,40 +110,52 @@ func (npc *NetworkPolicyController) syncNetworkPolicyChains(networkPoliciesInfo
 
 		// ensure there is a unique chain per network policy in filter table
 		policyChainName := networkPolicyChainName(policy.namespace, policy.name, version)
-		npc.filterTableRules.WriteString(":" + policyChainName + "\n")
-
 		activePolicyChains[policyChainName] = true
 
-		currentPodIPs := make([]string, 0, len(policy.targetPods))
-		for ip := range policy.targetPods {
-			currentPodIPs = append(currentPodIPs, ip)
+		targetPods := make([]podInfo, 0, len(policy.targetPods))
+		for _, pod := range policy.targetPods {
+			targetPods = append(targetPods, pod)
 		}
+		currentPodIPs := getIPsFromPods(targetPods)
 
-		if policy.policyType == kubeBothPolicyType || policy.policyType == kubeIngressPolicyType {
-			// create a ipset for all destination pod ip's matched by the policy spec PodSelector
-			targetDestPodIPSetName := policyDestinationPodIPSetName(policy.namespace, policy.name)
-			npc.createGenericHashIPSet(targetDestPodIPSetName, utils.TypeHashIP, currentPodIPs)
-			err = npc.processIngressRules(policy, targetDestPodIPSetName, activePolicyIPSets, version)
-			if err != nil {
-				return nil, nil, err
-			}
-			activePolicyIPSets[targetDestPodIPSetName] = true
-		}
-		if policy.policyType == kubeBothPolicyType || policy.policyType == kubeEgressPolicyType {
-			// create a ipset for all source pod ip's matched by the policy spec PodSelector
-			targetSourcePodIPSetName := policySourcePodIPSetName(policy.namespace, policy.name)
-			npc.createGenericHashIPSet(targetSourcePodIPSetName, utils.TypeHashIP, currentPodIPs)
-			err = npc.processEgressRules(policy, targetSourcePodIPSetName, activePolicyIPSets, version)
-			if err != nil {
-				return nil, nil, err
+		// the chains and ipsets are named alike in both address families
+		for _, ipFamily := range npc.ipFamilies {
+			npc.filterTableRules[ipFamily].WriteString(":" + policyChainName + "\n")
+
+			if policy.policyType == kubeBothPolicyType || policy.policyType == kubeIngressPolicyType {
+				// create a ipset for all destination pod ip's matched by the policy spec PodSelector
+				targetDestPodIPSetName := policyDestinationPodIPSetName(policy.namespace, policy.name)
+				npc.createGenericHashIPSet(targetDestPodIPSetName, utils.TypeHashIP, currentPodIPs, ipFamily)
+				err := npc.processIngressRules(policy, targetDestPodIPSetName, activePolicyIPSets, version, ipFamily)
+				if err != nil {
+					return nil, nil, err
+				}
+				activePolicyIPSets[targetDestPodIPSetName] = true
+			}
+			if policy.policyType == kubeBothPolicyType || policy.policyType == kubeEgressPolicyType {
+				// create a ipset for all source pod ip's matched by the policy spec PodSelector
+				targetSourcePodIPSetName := policySourcePodIPSetName(policy.namespace, policy.name)
+				npc.createGenericHashIPSet(targetSourcePodIPSetName, utils.TypeHashIP, currentPodIPs, ipFamily)
+				err := npc.processEgressRules(policy, targetSourcePodIPSetName, activePolicyIPSets, version, ipFamily)
+				if err != nil {
+					return nil, nil, err
+				}
+				activePolicyIPSets[targetSourcePodIPSetName] = true
 			}
-			activePolicyIPSets[targetSourcePodIPSetName] = true
 		}
 	}
 
-	err = npc.ipSetHandler.Restore()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to perform ipset restore: %s", err.Error())
+	npc.syncAdminNetworkPolicyChains(adminPoliciesInfo, version, activePolicyChains, activePolicyIPSets)
+
+	npc.syncNamespaceIsolationIPSets(activePolicyIPSets)
+
+	for _, ipFamily := range npc.ipFamilies {
+		// most pod changes only change a few sets, the others are left alone
+		dropUnchangedIPSets(npc.ipSetHandlers[ipFamily], savedIPSetEntries[ipFamily])
+		err := npc.ipSetHandlers[ipFamily].Restore()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to perform %s ipset restore: %s", ipFamily, err.Error())
+		}
 	}
 
 	klog.V(2).Infof("Iptables chains in the filter table are synchronized with the network policies.")
@@ -146,7 +165,7 @@ func (npc *NetworkPolicyController) syncNetworkPolicyChains(networkPoliciesInfo
 
 //nolint:dupl // This is as simple as this function gets even though it repeats some of processEgressRules
 func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo,
-	targetDestPodIPSetName string, activePolicyIPSets map[string]bool, version string) error {
+	targetDestPodIPSetName string, activePolicyIPSets map[string]bool, version string, ipFamily api.IPFamily) error {
 
 	// From network policy spec: "If field 'Ingress' is empty then this NetworkPolicy does not allow any traffic "
 	// so no whitelist rules to be added to the network policy
@@ -165,12 +184,12 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 
 			// Create policy based ipset with source pod IPs
 			npc.createPolicyIndexedIPSet(activePolicyIPSets, srcPodIPSetName, utils.TypeHashIP,
-				getIPsFromPods(ingressRule.srcPods))
+				getIPsFromPods(ingressRule.srcPods), ipFamily)
 
 			// If the ingress policy contains port declarations, we need to make sure that we match on pod IP and port
 			if len(ingressRule.ports) != 0 {
 				if err := npc.createPodWithPortPolicyRule(ingressRule.ports, policy, policyChainName,
-					srcPodIPSetName, targetDestPodIPSetName); err != nil {
+					srcPodIPSetName, targetDestPodIPSetName, ipFamily); err != nil {
 					return err
 				}
 			}
@@ -181,12 +200,12 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 				for portIdx, eps := range ingressRule.namedPorts {
 					namedPortIPSetName := policyIndexedIngressNamedPortIPSetName(policy.namespace, policy.name, ruleIdx,
 						portIdx)
-					npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips)
+					npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips, ipFamily)
 
 					comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
 						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcPodIPSetName, namedPortIPSetName,
-						eps.protocol, eps.port, eps.endport); err != nil {
+					if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, srcPodIPSetName, namedPortIPSetName,
+						eps.protocol, eps.port, eps.endport, ipFamily); err != nil {
 						return err
 					}
 				}
@@ -198,8 +217,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 				// so match on specified source and destination ip with all port and protocol
 				comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
 					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcPodIPSetName, targetDestPodIPSetName,
-					"", "", ""); err != nil {
+				if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, srcPodIPSetName, targetDestPodIPSetName,
+					"", "", "", ipFamily); err != nil {
 					return err
 				}
 			}
@@ -211,8 +230,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 			for _, portProtocol := range ingressRule.ports {
 				comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
 					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, "", targetDestPodIPSetName,
-					portProtocol.protocol, portProtocol.port, portProtocol.endport); err != nil {
+				if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, "", targetDestPodIPSetName,
+					portProtocol.protocol, portProtocol.port, portProtocol.endport, ipFamily); err != nil {
 					return err
 				}
 			}
@@ -220,12 +239,12 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 			for portIdx, eps := range ingressRule.namedPorts {
 				namedPortIPSetName := policyIndexedIngressNamedPortIPSetName(policy.namespace, policy.name, ruleIdx,
 					portIdx)
-				npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips)
+				npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips, ipFamily)
 
 				comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
 					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, "", namedPortIPSetName,
-					eps.protocol, eps.port, eps.endport); err != nil {
+				if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, "", namedPortIPSetName,
+					eps.protocol, eps.port, eps.endport, ipFamily); err != nil {
 					return err
 				}
 			}
@@ -236,8 +255,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 		if ingressRule.matchAllSource && ingressRule.matchAllPorts {
 			comment := "rule to ACCEPT traffic from all sources to dest pods selected by policy name: " +
 				policy.name + " namespace " + policy.namespace
-			if err := npc.appendRuleToPolicyChain(policyChainName, comment, "", targetDestPodIPSetName,
-				"", "", ""); err != nil {
+			if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, "", targetDestPodIPSetName,
+				"", "", "", ipFamily); err != nil {
 				return err
 			}
 		}
@@ -245,15 +264,15 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 		if len(ingressRule.srcIPBlocks) != 0 {
 			srcIPBlockIPSetName := policyIndexedSourceIPBlockIPSetName(policy.namespace, policy.name, ruleIdx)
 			activePolicyIPSets[srcIPBlockIPSetName] = true
-			npc.ipSetHandler.RefreshSet(srcIPBlockIPSetName, ingressRule.srcIPBlocks, utils.TypeHashNet)
+			npc.refreshIPBlockIPSet(srcIPBlockIPSetName, ingressRule.srcIPBlocks, ipFamily)
 
 			if !ingressRule.matchAllPorts {
 				for _, portProtocol := range ingressRule.ports {
 					comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
 						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcIPBlockIPSetName,
+					if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, srcIPBlockIPSetName,
 						targetDestPodIPSetName, portProtocol.protocol, portProtocol.port,
-						portProtocol.endport); err != nil {
+						portProtocol.endport, ipFamily); err != nil {
 						return err
 					}
 				}
@@ -261,12 +280,12 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 				for portIdx, eps := range ingressRule.namedPorts {
 					namedPortIPSetName := policyIndexedIngressNamedPortIPSetName(policy.namespace, policy.name, ruleIdx,
 						portIdx)
-					npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashNet, eps.ips)
+					npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips, ipFamily)
 
 					comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
 						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcIPBlockIPSetName,
-						namedPortIPSetName, eps.protocol, eps.port, eps.endport); err != nil {
+					if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, srcIPBlockIPSetName,
+						namedPortIPSetName, eps.protocol, eps.port, eps.endport, ipFamily); err != nil {
 						return err
 					}
 				}
@@ -274,8 +293,8 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 			if ingressRule.matchAllPorts {
 				comment := "rule to ACCEPT traffic from specified ipBlocks to dest pods selected by policy name: " +
 					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, srcIPBlockIPSetName,
-					targetDestPodIPSetName, "", "", ""); err != nil {
+				if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, srcIPBlockIPSetName,
+					targetDestPodIPSetName, "", "", "", ipFamily); err != nil {
 					return err
 				}
 			}
@@ -287,7 +306,7 @@ func (npc *NetworkPolicyController) processIngressRules(policy networkPolicyInfo
 
 //nolint:dupl // This is as simple as this function gets even though it repeats some of ProcessIngressRules
 func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
-	targetSourcePodIPSetName string, activePolicyIPSets map[string]bool, version string) error {
+	targetSourcePodIPSetName string, activePolicyIPSets map[string]bool, version string, ipFamily api.IPFamily) error {
 
 	// From network policy spec: "If field 'Ingress' is empty then this NetworkPolicy does not allow any traffic "
 	// so no whitelist rules to be added to the network policy
@@ -306,12 +325,12 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 
 			// Create policy based ipset with destination pod IPs
 			npc.createPolicyIndexedIPSet(activePolicyIPSets, dstPodIPSetName, utils.TypeHashIP,
-				getIPsFromPods(egressRule.dstPods))
+				getIPsFromPods(egressRule.dstPods), ipFamily)
 
 			// If the egress policy contains port declarations, we need to make sure that we match on pod IP and port
 			if len(egressRule.ports) != 0 {
 				if err := npc.createPodWithPortPolicyRule(egressRule.ports, policy, policyChainName,
-					targetSourcePodIPSetName, dstPodIPSetName); err != nil {
+					targetSourcePodIPSetName, dstPodIPSetName, ipFamily); err != nil {
 					return err
 				}
 			}
@@ -322,12 +341,12 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 				for portIdx, eps := range egressRule.namedPorts {
 					namedPortIPSetName := policyIndexedEgressNamedPortIPSetName(policy.namespace, policy.name, ruleIdx,
 						portIdx)
-					npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips)
+					npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips, ipFamily)
 
 					comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
 						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-						namedPortIPSetName, eps.protocol, eps.port, eps.endport); err != nil {
+					if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, targetSourcePodIPSetName,
+						namedPortIPSetName, eps.protocol, eps.port, eps.endport, ipFamily); err != nil {
 						return err
 					}
 				}
@@ -339,8 +358,8 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 				// so match on specified source and destination ip with all port and protocol
 				comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
 					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-					dstPodIPSetName, "", "", ""); err != nil {
+				if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, targetSourcePodIPSetName,
+					dstPodIPSetName, "", "", "", ipFamily); err != nil {
 					return err
 				}
 			}
@@ -352,16 +371,22 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 			for _, portProtocol := range egressRule.ports {
 				comment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
 					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-					"", portProtocol.protocol, portProtocol.port, portProtocol.endport); err != nil {
+				if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, targetSourcePodIPSetName,
+					"", portProtocol.protocol, portProtocol.port, portProtocol.endport, ipFamily); err != nil {
 					return err
 				}
 			}
-			for _, portProtocol := range egressRule.namedPorts {
+			// a named port only resolves to a port number on the pods that have a container port of that name, so the
+			// traffic is only allowed to those pods rather than to the port number on all destinations
+			for portIdx, eps := range egressRule.namedPorts {
+				namedPortIPSetName := policyIndexedEgressNamedPortIPSetName(policy.namespace, policy.name, ruleIdx,
+					portIdx)
+				npc.createPolicyIndexedIPSet(activePolicyIPSets, namedPortIPSetName, utils.TypeHashIP, eps.ips, ipFamily)
+
 				comment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
 					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-					"", portProtocol.protocol, portProtocol.port, portProtocol.endport); err != nil {
+				if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, targetSourcePodIPSetName,
+					namedPortIPSetName, eps.protocol, eps.port, eps.endport, ipFamily); err != nil {
 					return err
 				}
 			}
@@ -372,8 +397,8 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 		if egressRule.matchAllDestinations && egressRule.matchAllPorts {
 			comment := "rule to ACCEPT traffic from source pods to all destinations selected by policy name: " +
 				policy.name + " namespace " + policy.namespace
-			if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-				"", "", "", ""); err != nil {
+			if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, targetSourcePodIPSetName,
+				"", "", "", "", ipFamily); err != nil {
 				return err
 			}
 		}
@@ -381,14 +406,14 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 		if len(egressRule.dstIPBlocks) != 0 {
 			dstIPBlockIPSetName := policyIndexedDestinationIPBlockIPSetName(policy.namespace, policy.name, ruleIdx)
 			activePolicyIPSets[dstIPBlockIPSetName] = true
-			npc.ipSetHandler.RefreshSet(dstIPBlockIPSetName, egressRule.dstIPBlocks, utils.TypeHashNet)
+			npc.refreshIPBlockIPSet(dstIPBlockIPSetName, egressRule.dstIPBlocks, ipFamily)
 			if !egressRule.matchAllPorts {
 				for _, portProtocol := range egressRule.ports {
 					comment := "rule to ACCEPT traffic from source pods to specified ipBlocks selected by policy name: " +
 						policy.name + " namespace " + policy.namespace
-					if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
+					if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, targetSourcePodIPSetName,
 						dstIPBlockIPSetName, portProtocol.protocol, portProtocol.port,
-						portProtocol.endport); err != nil {
+						portProtocol.endport, ipFamily); err != nil {
 						return err
 					}
 				}
@@ -396,8 +421,8 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 			if egressRule.matchAllPorts {
 				comment := "rule to ACCEPT traffic from source pods to specified ipBlocks selected by policy name: " +
 					policy.name + " namespace " + policy.namespace
-				if err := npc.appendRuleToPolicyChain(policyChainName, comment, targetSourcePodIPSetName,
-					dstIPBlockIPSetName, "", "", ""); err != nil {
+				if err := npc.appendRuleToPolicyChain(policy, policyChainName, comment, targetSourcePodIPSetName,
+					dstIPBlockIPSetName, "", "", "", ipFamily); err != nil {
 					return err
 				}
 			}
@@ -406,8 +431,14 @@ func (npc *NetworkPolicyController) processEgressRules(policy networkPolicyInfo,
 	return nil
 }
 
-func (npc *NetworkPolicyController) appendRuleToPolicyChain(policyChainName, comment, srcIPSetName, dstIPSetName,
-	protocol, dPort, endDport string) error {
+func (npc *NetworkPolicyController) appendRuleToPolicyChain(policy networkPolicyInfo, policyChainName, comment,
+	srcIPSetName, dstIPSetName, protocol, dPort, endDport string, ipFamily api.IPFamily) error {
+
+	if dPort != "" && strings.EqualFold(protocol, "SCTP") && !npc.sctpPortMatch {
+		npc.logger.Info("Not allowing SCTP port in network policy as the kernel lacks the SCTP match of iptables",
+			"port", dPort, utils.LogKeyNamespace, policy.namespace, utils.LogKeyPolicy, policy.name)
+		return nil
+	}
 
 	args := make([]string, 0)
 	args = append(args, "-A", policyChainName)
@@ -416,10 +447,10 @@ func (npc *NetworkPolicyController) appendRuleToPolicyChain(policyChainName, com
 		args = append(args, "-m", "comment", "--comment", "\""+comment+"\"")
 	}
 	if srcIPSetName != "" {
-		args = append(args, "-m", "set", "--match-set", srcIPSetName, "src")
+		args = append(args, "-m", "set", "--match-set", ipSetName(srcIPSetName, ipFamily), "src")
 	}
 	if dstIPSetName != "" {
-		args = append(args, "-m", "set", "--match-set", dstIPSetName, "dst")
+		args = append(args, "-m", "set", "--match-set", ipSetName(dstIPSetName, ipFamily), "dst")
 	}
 	if protocol != "" {
 		args = append(args, "-p", protocol)
@@ -433,12 +464,18 @@ func (npc *NetworkPolicyController) appendRuleToPolicyChain(policyChainName, com
 		}
 	}
 
+	if policy.log {
+		//nolint:gocritic // we want to append to a separate array here so that we can re-use args below
+		logArgs := append(args, npc.nflog().policyLogTarget(policyLogAllowPrefix, policy)...)
+		npc.filterTableRules[ipFamily].WriteString(strings.Join(append(logArgs, "\n"), " "))
+	}
+
 	//nolint:gocritic // we want to append to a separate array here so that we can re-use args below
 	markArgs := append(args, "-j", "MARK", "--set-xmark", "0x10000/0x10000", "\n")
-	npc.filterTableRules.WriteString(strings.Join(markArgs, " "))
+	npc.filterTableRules[ipFamily].WriteString(strings.Join(markArgs, " "))
 
 	args = append(args, "-m", "mark", "--mark", "0x10000/0x10000", "-j", "RETURN", "\n")
-	npc.filterTableRules.WriteString(strings.Join(args, " "))
+	npc.filterTableRules[ipFamily].WriteString(strings.Join(args, " "))
 
 	return nil
 }
@@ -459,6 +496,15 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() ([]networkPolicyI
 			namespace:   policy.Namespace,
 			podSelector: podSelector,
 			policyType:  kubeIngressPolicyType,
+			log:         policy.Annotations[netpolLogAnnotation] == "true",
+		}
+		if value, ok := policy.Annotations[netpolDenyActionAnnotation]; ok {
+			if action, err := parseDenyAction(value); err != nil {
+				npc.logger.Error(err, "Ignoring the "+netpolDenyActionAnnotation+" annotation of network policy",
+					utils.LogKeyNamespace, policy.Namespace, utils.LogKeyPolicy, policy.Name)
+			} else {
+				newPolicy.denyAction = &action
+			}
 		}
 
 		ingressType, egressType := false, false
@@ -487,10 +533,7 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() ([]networkPolicyI
 				if !isNetPolActionable(matchingPod) {
 					continue
 				}
-				newPolicy.targetPods[matchingPod.Status.PodIP] = podInfo{ip: matchingPod.Status.PodIP,
-					name:      matchingPod.ObjectMeta.Name,
-					namespace: matchingPod.ObjectMeta.Namespace,
-					labels:    matchingPod.ObjectMeta.Labels}
+				newPolicy.targetPods[matchingPod.Status.PodIP] = newPodInfo(matchingPod)
 				npc.grabNamedPortFromPod(matchingPod, &namedPort2IngressEps)
 			}
 		}
@@ -523,11 +566,7 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() ([]networkPolicyI
 							if !isNetPolActionable(peerPod) {
 								continue
 							}
-							ingressRule.srcPods = append(ingressRule.srcPods,
-								podInfo{ip: peerPod.Status.PodIP,
-									name:      peerPod.ObjectMeta.Name,
-									namespace: peerPod.ObjectMeta.Namespace,
-									labels:    peerPod.ObjectMeta.Labels})
+							ingressRule.srcPods = append(ingressRule.srcPods, newPodInfo(peerPod))
 						}
 					}
 					ingressRule.srcIPBlocks = append(ingressRule.srcIPBlocks, npc.evalIPBlockPeer(peer)...)
@@ -557,11 +596,11 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() ([]networkPolicyI
 			// If this field is empty or missing in the spec, this rule matches all sources
 			if len(specEgressRule.To) == 0 {
 				egressRule.matchAllDestinations = true
-				// if rule.To is empty but rule.Ports not, we must try to grab NamedPort from pods that in same
-				// namespace, so that we can design iptables rule to describe "match all dst but match some named
+				// if rule.To is empty but rule.Ports not, we must try to grab NamedPort from the pods of all the
+				// namespaces, so that we can design iptables rule to describe "match all dst but match some named
 				// dst-port" egress rule
 				if policyRulePortsHasNamedPort(specEgressRule.Ports) {
-					matchingPeerPods, _ := npc.ListPodsByNamespaceAndLabels(policy.Namespace, labels.Everything())
+					matchingPeerPods, _ := listers.NewPodLister(npc.podLister).List(labels.Everything())
 					for _, peerPod := range matchingPeerPods {
 						if !isNetPolActionable(peerPod) {
 							continue
@@ -577,11 +616,7 @@ func (npc *NetworkPolicyController) buildNetworkPoliciesInfo() ([]networkPolicyI
 							if !isNetPolActionable(peerPod) {
 								continue
 							}
-							egressRule.dstPods = append(egressRule.dstPods,
-								podInfo{ip: peerPod.Status.PodIP,
-									name:      peerPod.ObjectMeta.Name,
-									namespace: peerPod.ObjectMeta.Namespace,
-									labels:    peerPod.ObjectMeta.Labels})
+							egressRule.dstPods = append(egressRule.dstPods, newPodInfo(peerPod))
 							npc.grabNamedPortFromPod(peerPod, &namedPort2EgressEps)
 						}
 
@@ -650,6 +685,11 @@ func (npc *NetworkPolicyController) processNetworkPolicyPorts(npPorts []networki
 		if npPort.Protocol != nil {
 			protocol = string(*npPort.Protocol)
 		}
+		// the protocol of the ports defaults to TCP, for the container ports as well
+		namedPortProtocol := protocol
+		if namedPortProtocol == "" {
+			namedPortProtocol = string(api.ProtocolTCP)
+		}
 		if npPort.Port == nil {
 			numericPorts = append(numericPorts, protocolAndPort{port: "", protocol: protocol})
 		} else if npPort.Port.Type == intstr.Int {
@@ -662,9 +702,19 @@ func (npc *NetworkPolicyController) processNetworkPolicyPorts(npPorts []networki
 			portProto.protocol, portProto.port = protocol, npPort.Port.String()
 			numericPorts = append(numericPorts, portProto)
 		} else if protocol2eps, ok := namedPort2eps[npPort.Port.String()]; ok {
-			if numericPort2eps, ok := protocol2eps[protocol]; ok {
-				for _, eps := range numericPort2eps {
-					namedPorts = append(namedPorts, *eps)
+			if numericPort2eps, ok := protocol2eps[namedPortProtocol]; ok {
+				// the ipsets of the named ports are indexed by their position, which is kept stable across syncs
+				ports := make([]string, 0, len(numericPort2eps))
+				for port := range numericPort2eps {
+					ports = append(ports, port)
+				}
+				sort.Slice(ports, func(i, j int) bool {
+					first, _ := strconv.Atoi(ports[i])
+					second, _ := strconv.Atoi(ports[j])
+					return first < second
+				})
+				for _, port := range ports {
+					namedPorts = append(namedPorts, *numericPort2eps[port])
 				}
 			}
 		}
@@ -694,24 +744,34 @@ func (npc *NetworkPolicyController) ListNamespaceByLabels(namespaceSelector labe
 func (npc *NetworkPolicyController) evalIPBlockPeer(peer networking.NetworkPolicyPeer) [][]string {
 	ipBlock := make([][]string, 0)
 	if peer.PodSelector == nil && peer.NamespaceSelector == nil && peer.IPBlock != nil {
-		if cidr := peer.IPBlock.CIDR; strings.HasSuffix(cidr, "/0") {
-			ipBlock = append(ipBlock, []string{"0.0.0.0/1", utils.OptionTimeout, "0"},
-				[]string{"128.0.0.0/1", utils.OptionTimeout, "0"})
-		} else {
+		if !npc.isIPFamilyEnabled(ipFamilyOfEntry(peer.IPBlock.CIDR)) {
+			klog.Warningf("IPBlock %s is of an address family network policies aren't enforced on, enable it with "+
+				"--enable-ipv4 or --enable-ipv6", peer.IPBlock.CIDR)
+		}
+		// hash:net sets can't hold a /0, it is split into both halves of the address space of its family
+		for _, cidr := range splitDefaultRoute(peer.IPBlock.CIDR) {
 			ipBlock = append(ipBlock, []string{cidr, utils.OptionTimeout, "0"})
 		}
 		for _, except := range peer.IPBlock.Except {
-			if strings.HasSuffix(except, "/0") {
-				ipBlock = append(ipBlock, []string{"0.0.0.0/1", utils.OptionTimeout, "0", utils.OptionNoMatch},
-					[]string{"128.0.0.0/1", utils.OptionTimeout, "0", utils.OptionNoMatch})
-			} else {
-				ipBlock = append(ipBlock, []string{except, utils.OptionTimeout, "0", utils.OptionNoMatch})
+			for _, cidr := range splitDefaultRoute(except) {
+				ipBlock = append(ipBlock, []string{cidr, utils.OptionTimeout, "0", utils.OptionNoMatch})
 			}
 		}
 	}
 	return ipBlock
 }
 
+// splitDefaultRoute returns the halves of the address space for a /0 CIDR, or the CIDR itself otherwise
+func splitDefaultRoute(cidr string) []string {
+	if !strings.HasSuffix(cidr, "/0") {
+		return []string{cidr}
+	}
+	if strings.Contains(cidr, ":") {
+		return []string{"::/1", "8000::/1"}
+	}
+	return []string{"0.0.0.0/1", "128.0.0.0/1"}
+}
+
 func (npc *NetworkPolicyController) grabNamedPortFromPod(pod *api.Pod, namedPort2eps *namedPort2eps) {
 	if pod == nil || namedPort2eps == nil {
 		return
@@ -719,7 +779,13 @@ func (npc *NetworkPolicyController) grabNamedPortFromPod(pod *api.Pod, namedPort
 	for k := range pod.Spec.Containers {
 		for _, port := range pod.Spec.Containers[k].Ports {
 			name := port.Name
+			if name == "" {
+				continue
+			}
 			protocol := string(port.Protocol)
+			if protocol == "" {
+				protocol = string(api.ProtocolTCP)
+			}
 			containerPort := strconv.Itoa(int(port.ContainerPort))
 
 			if (*namedPort2eps)[name] == nil {
@@ -728,13 +794,14 @@ func (npc *NetworkPolicyController) grabNamedPortFromPod(pod *api.Pod, namedPort
 			if (*namedPort2eps)[name][protocol] == nil {
 				(*namedPort2eps)[name][protocol] = make(numericPort2eps)
 			}
+			podIPs := getIPsFromPods([]podInfo{newPodInfo(pod)})
 			if eps, ok := (*namedPort2eps)[name][protocol][containerPort]; !ok {
 				(*namedPort2eps)[name][protocol][containerPort] = &endPoints{
-					ips:             []string{pod.Status.PodIP},
+					ips:             podIPs,
 					protocolAndPort: protocolAndPort{port: containerPort, protocol: protocol},
 				}
 			} else {
-				eps.ips = append(eps.ips, pod.Status.PodIP)
+				eps.ips = append(eps.ips, podIPs...)
 			}
 		}
 	}
@@ -0,0 +1,129 @@
+package netpol
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	api "k8s.io/api/core/v1"
+)
+
+const (
+	// netpolLogAnnotation enables the logging of a single network policy when set to "true"
+	netpolLogAnnotation = "kube-router.io/log"
+
+	policyLogAllowPrefix = "ALLOW"
+	policyLogDropPrefix  = "DROP"
+
+	// the kernel truncates longer NFLOG prefixes
+	maxNFLogPrefixLength = 64
+)
+
+// nflogLimitRegex matches the rate limits of the iptables limit match
+var nflogLimitRegex = regexp.MustCompile(`^[1-9][0-9]*/(s|sec|second|m|min|minute|h|hour|d|day)$`)
+
+// nflogConfig is the NFLOG configuration of the logged traffic. The traffic dropped by all network policies is logged
+// under dropGroup, the traffic logged for the network policies with logging enabled under policyLogGroup, separately
+// so that it can be observed on its own.
+type nflogConfig struct {
+	dropGroup      uint16
+	policyLogGroup uint16
+	// size is the number of bytes of the packets copied to the groups, 0 copies the whole packets
+	size uint32
+	// limit and limitBurst rate limit the logs of each rule, which aren't limited when limit is empty
+	limit      string
+	limitBurst uint
+}
+
+// defaultNFLogConfig is the NFLOG configuration of the controllers whose configuration doesn't set one
+var defaultNFLogConfig = nflogConfig{dropGroup: 100, policyLogGroup: 101, limit: "10/minute", limitBurst: 10}
+
+// newNFLogConfig validates the NFLOG configuration of the --netpol-nflog-* flags
+func newNFLogConfig(config *options.KubeRouterConfig) (*nflogConfig, error) {
+	nflog := &nflogConfig{dropGroup: config.NetpolNFLogGroup, policyLogGroup: config.NetpolPolicyLogNFLogGroup,
+		size: config.NetpolNFLogSize, limit: config.NetpolNFLogLimit, limitBurst: config.NetpolNFLogLimitBurst}
+	if nflog.limit != "" {
+		if !nflogLimitRegex.MatchString(nflog.limit) {
+			return nil, fmt.Errorf("invalid --netpol-nflog-limit %q, expected <count>/<second|minute|hour|day>",
+				nflog.limit)
+		}
+		if nflog.limitBurst == 0 {
+			return nil, fmt.Errorf("--netpol-nflog-limit-burst must be at least 1")
+		}
+	}
+	if config.NetpolFlowExport != "" && nflog.dropGroup == nflog.policyLogGroup {
+		return nil, fmt.Errorf("--netpol-policy-log-nflog-group must differ from --netpol-nflog-group to export "+
+			"the dropped flows, both are %d", nflog.dropGroup)
+	}
+	return nflog, nil
+}
+
+// target returns the NFLOG target of the group, with the prefix unless it is empty, followed by the rate limit
+func (c nflogConfig) target(group uint16, prefix string) []string {
+	args := []string{"-j", "NFLOG", "--nflog-group", strconv.Itoa(int(group))}
+	if prefix != "" {
+		args = append(args, "--nflog-prefix", "\""+prefix+"\"")
+	}
+	if c.size != 0 {
+		args = append(args, "--nflog-size", strconv.FormatUint(uint64(c.size), 10))
+	}
+	if c.limit != "" {
+		args = append(args, "-m", "limit", "--limit", c.limit, "--limit-burst", strconv.FormatUint(uint64(c.limitBurst), 10))
+	}
+	return args
+}
+
+// dropTarget returns the NFLOG target of the traffic the network policies drop
+func (c nflogConfig) dropTarget() []string {
+	return c.target(c.dropGroup, "")
+}
+
+// policyLogTarget returns the NFLOG target of the traffic allowed or dropped because of a network policy, prefixed with
+// the verdict and the namespace and name of the policy
+func (c nflogConfig) policyLogTarget(verdict string, policy networkPolicyInfo) []string {
+	prefix := verdict + " " + policy.namespace + "/" + policy.name
+	if len(prefix) > maxNFLogPrefixLength {
+		prefix = prefix[:maxNFLogPrefixLength]
+	}
+	return c.target(c.policyLogGroup, prefix)
+}
+
+// nflog returns the NFLOG configuration of the controller, or the default one
+func (npc *NetworkPolicyController) nflog() nflogConfig {
+	if npc.nflogConfig == nil {
+		return defaultNFLogConfig
+	}
+	return *npc.nflogConfig
+}
+
+// logPodPolicyDenies logs the traffic from/to the pod that none of the network policies allowed, for each of the
+// policies with logging enabled that select the pod, in the directions the policy applies to
+func (npc *NetworkPolicyController) logPodPolicyDenies(filterTableRules *bytes.Buffer, pod podInfo,
+	podFwChainName string, networkPoliciesInfo []networkPolicyInfo, ipFamily api.IPFamily) {
+	podIP := pod.ipOfFamily(ipFamily)
+	for _, policy := range networkPoliciesInfo {
+		if !policy.log {
+			continue
+		}
+		if _, ok := policy.targetPods[pod.ip]; !ok {
+			continue
+		}
+		directions := make([]string, 0, 2)
+		if policy.policyType == kubeBothPolicyType || policy.policyType == kubeIngressPolicyType {
+			directions = append(directions, "-d")
+		}
+		if policy.policyType == kubeBothPolicyType || policy.policyType == kubeEgressPolicyType {
+			directions = append(directions, "-s")
+		}
+		comment := "\"rule to log traffic dropped by nw policy " + policy.name + "\""
+		for _, direction := range directions {
+			args := []string{"-A", podFwChainName, "-m", "comment", "--comment", comment, direction, podIP,
+				"-m", "mark", "!", "--mark", "0x10000/0x10000"}
+			args = append(args, npc.nflog().policyLogTarget(policyLogDropPrefix, policy)...)
+			filterTableRules.WriteString(strings.Join(append(args, "\n"), " "))
+		}
+	}
+}
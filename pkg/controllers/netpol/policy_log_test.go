@@ -0,0 +1,128 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+)
+
+func Test_policyLogTarget(t *testing.T) {
+	policy := networkPolicyInfo{name: "allow-frontend", namespace: "test-ns"}
+	assert.Equal(t, []string{"-j", "NFLOG", "--nflog-group", "101", "--nflog-prefix", "\"ALLOW test-ns/allow-frontend\"",
+		"-m", "limit", "--limit", "10/minute", "--limit-burst", "10"},
+		defaultNFLogConfig.policyLogTarget(policyLogAllowPrefix, policy))
+
+	policy.name = strings.Repeat("x", 100)
+	target := defaultNFLogConfig.policyLogTarget(policyLogDropPrefix, policy)
+	assert.Len(t, strings.Trim(target[5], "\""), maxNFLogPrefixLength, "expected the prefix to be truncated")
+}
+
+func Test_newNFLogConfig(t *testing.T) {
+	config := &options.KubeRouterConfig{NetpolNFLogGroup: 5, NetpolPolicyLogNFLogGroup: 6, NetpolNFLogSize: 256,
+		NetpolNFLogLimit: "100/second", NetpolNFLogLimitBurst: 20}
+	nflog, err := newNFLogConfig(config)
+	assert.NoError(t, err)
+	assert.Equal(t, &nflogConfig{dropGroup: 5, policyLogGroup: 6, size: 256, limit: "100/second", limitBurst: 20}, nflog)
+	assert.Equal(t, []string{"-j", "NFLOG", "--nflog-group", "5", "--nflog-size", "256", "-m", "limit", "--limit",
+		"100/second", "--limit-burst", "20"}, nflog.dropTarget())
+
+	config.NetpolNFLogLimit = ""
+	nflog, err = newNFLogConfig(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-j", "NFLOG", "--nflog-group", "6", "--nflog-prefix", "\"ALLOW test-ns/allow-frontend\"",
+		"--nflog-size", "256"}, nflog.policyLogTarget(policyLogAllowPrefix,
+		networkPolicyInfo{name: "allow-frontend", namespace: "test-ns"}), "expected the logs not to be limited")
+
+	for _, limit := range []string{"10", "0/minute", "10/week", "ten/minute"} {
+		config.NetpolNFLogLimit = limit
+		_, err = newNFLogConfig(config)
+		assert.Error(t, err, limit)
+	}
+	config.NetpolNFLogLimit, config.NetpolNFLogLimitBurst = "10/minute", 0
+	_, err = newNFLogConfig(config)
+	assert.Error(t, err, "expected a limit without burst to be rejected")
+
+	config.NetpolNFLogLimitBurst, config.NetpolPolicyLogNFLogGroup = 10, 5
+	_, err = newNFLogConfig(config)
+	assert.NoError(t, err, "expected a single group to be allowed without flow export")
+	config.NetpolFlowExport = "stdout"
+	_, err = newNFLogConfig(config)
+	assert.Error(t, err, "expected the flow exporter to need a group of its own")
+}
+
+func Test_dropUnmarkedTrafficNFLogConfig(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", name: "payments", namespace: "payments"}
+	selected := map[string]podInfo{pod.ip: pod}
+	npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules(), ipFamilies: []api.IPFamily{api.IPv4Protocol},
+		nflogConfig: &nflogConfig{dropGroup: 42, policyLogGroup: 43}}
+
+	npc.syncPodFirewallChains([]networkPolicyInfo{{name: "default-deny", namespace: "payments", targetPods: selected,
+		policyType: kubeIngressPolicyType}}, nil, selected, "1")
+	assert.Contains(t, npc.filterTableRules[api.IPv4Protocol].String(), "\"rule to log dropped traffic POD "+
+		"name:payments namespace: payments\" -m mark ! --mark 0x10000/0x10000 -j NFLOG --nflog-group 42 \n")
+}
+
+func Test_appendRuleToPolicyChainLog(t *testing.T) {
+	for _, log := range []bool{false, true} {
+		npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules()}
+		policy := networkPolicyInfo{name: "allow-frontend", namespace: "test-ns", log: log}
+		assert.NoError(t, npc.appendRuleToPolicyChain(policy, "KUBE-NWPLCY-TEST", "", "KUBE-SRC-TEST", "KUBE-DST-TEST",
+			"tcp", "80", "", api.IPv4Protocol))
+		rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+		assert.Equal(t, log, strings.Contains(rules, "--dport 80 -j NFLOG --nflog-group 101 "+
+			"--nflog-prefix \"ALLOW test-ns/allow-frontend\""), "unexpected allow log rule in:\n%s", rules)
+		assert.Contains(t, rules, "--dport 80 -j MARK --set-xmark 0x10000/0x10000")
+	}
+}
+
+func Test_logPodPolicyDenies(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", name: "test-pod", namespace: "test-ns"}
+	targetPods := map[string]podInfo{pod.ip: pod}
+	testcases := []struct {
+		name       string
+		policies   []networkPolicyInfo
+		directions []string
+	}{
+		{
+			"policies without logging aren't logged",
+			[]networkPolicyInfo{{name: "p", namespace: "test-ns", targetPods: targetPods, policyType: kubeBothPolicyType}},
+			nil,
+		},
+		{
+			"policies that don't select the pod aren't logged",
+			[]networkPolicyInfo{{name: "p", namespace: "test-ns", policyType: kubeBothPolicyType, log: true}},
+			nil,
+		},
+		{
+			"ingress policies log the traffic to the pod",
+			[]networkPolicyInfo{{name: "p", namespace: "test-ns", targetPods: targetPods,
+				policyType: kubeIngressPolicyType, log: true}},
+			[]string{"-d"},
+		},
+		{
+			"policies of both types log the traffic from and to the pod",
+			[]networkPolicyInfo{{name: "p", namespace: "test-ns", targetPods: targetPods,
+				policyType: kubeBothPolicyType, log: true}},
+			[]string{"-d", "-s"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules()}
+			npc.logPodPolicyDenies(npc.filterTableRules[api.IPv4Protocol], pod,
+				"KUBE-POD-FW-TEST", tc.policies, api.IPv4Protocol)
+			rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+			assert.Equal(t, len(tc.directions), strings.Count(rules, "--nflog-prefix \"DROP test-ns/p\""),
+				"unexpected drop log rules in:\n%s", rules)
+			for _, direction := range tc.directions {
+				assert.Contains(t, rules, direction+" 10.1.1.1 -m mark ! --mark 0x10000/0x10000 -j NFLOG")
+			}
+		})
+	}
+}
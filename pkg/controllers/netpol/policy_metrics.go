@@ -0,0 +1,190 @@
+package netpol
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	api "k8s.io/api/core/v1"
+)
+
+const (
+	verdictAccepted = "accepted"
+	verdictDropped  = "dropped"
+)
+
+// policyMetrics are the metrics of the traffic the network policies allowed or dropped, per pod and per policy
+var policyMetrics = []*prometheus.CounterVec{
+	metrics.NetpolPodAcceptedPackets,
+	metrics.NetpolPodAcceptedBytes,
+	metrics.NetpolPodDroppedPackets,
+	metrics.NetpolPodDroppedBytes,
+	metrics.NetpolPolicyAcceptedPackets,
+	metrics.NetpolPolicyAcceptedBytes,
+}
+
+// ruleCounterKey identifies the rules whose counters are published, the counters of the rules of a chain with the same
+// verdict are summed
+type ruleCounterKey struct {
+	ipFamily api.IPFamily
+	chain    string
+	verdict  string
+}
+
+type ruleCounters struct {
+	packets uint64
+	bytes   uint64
+}
+
+// policyCounters publishes the counters of the rules of the pod firewall and network policy chains as Prometheus
+// counters. The chains are replaced, and their counters restart from zero, at every full sync and whenever an
+// incremental sync changes their rules, so only the increase of the counters since they were last published is added.
+type policyCounters struct {
+	// the namespace/name of the pods and of the network policies the chains were named after by the last full sync
+	podChains    map[string]string
+	policyChains map[string]string
+	published    map[ruleCounterKey]ruleCounters
+}
+
+func newPolicyCounters() *policyCounters {
+	return &policyCounters{podChains: make(map[string]string), policyChains: make(map[string]string),
+		published: make(map[ruleCounterKey]ruleCounters)}
+}
+
+// publish adds the increase of the counters of the rules of the output of iptables-save -c of each address family
+func (pc *policyCounters) publish(savedRules map[api.IPFamily]string) {
+	current := make(map[ruleCounterKey]ruleCounters)
+	for ipFamily, rules := range savedRules {
+		for key, counters := range parseRuleCounters(rules) {
+			key.ipFamily = ipFamily
+			current[key] = counters
+		}
+	}
+
+	for key, counters := range current {
+		var packetsMetric, bytesMetric *prometheus.CounterVec
+		owner, ok := pc.podChains[key.chain]
+		switch {
+		case ok && key.verdict == verdictAccepted:
+			packetsMetric, bytesMetric = metrics.NetpolPodAcceptedPackets, metrics.NetpolPodAcceptedBytes
+		case ok && key.verdict == verdictDropped:
+			packetsMetric, bytesMetric = metrics.NetpolPodDroppedPackets, metrics.NetpolPodDroppedBytes
+		default:
+			if owner, ok = pc.policyChains[key.chain]; !ok {
+				continue
+			}
+			packetsMetric, bytesMetric = metrics.NetpolPolicyAcceptedPackets, metrics.NetpolPolicyAcceptedBytes
+		}
+		namespace, name, _ := strings.Cut(owner, "/")
+
+		published, ok := pc.published[key]
+		if !ok || counters.packets < published.packets || counters.bytes < published.bytes {
+			// the chain is new or its rules were replaced since the counters were published
+			published = ruleCounters{}
+		}
+		packetsMetric.WithLabelValues(namespace, name).Add(float64(counters.packets - published.packets))
+		bytesMetric.WithLabelValues(namespace, name).Add(float64(counters.bytes - published.bytes))
+	}
+	pc.published = current
+}
+
+// update records the chains of the pods and network policies synced with the version, the metrics of the pods and
+// network policies which are gone are deleted
+func (pc *policyCounters) update(localPods map[string]podInfo, networkPoliciesInfo []networkPolicyInfo,
+	version string) {
+	podChains := make(map[string]string, len(localPods))
+	pods := make(map[string]bool, len(localPods))
+	for _, pod := range localPods {
+		podChains[podFirewallChainName(pod.namespace, pod.name, version)] = pod.namespace + "/" + pod.name
+		pods[pod.namespace+"/"+pod.name] = true
+	}
+	policyChains := make(map[string]string, len(networkPoliciesInfo))
+	policies := make(map[string]bool, len(networkPoliciesInfo))
+	for _, policy := range networkPoliciesInfo {
+		policyChains[networkPolicyChainName(policy.namespace, policy.name, version)] =
+			policy.namespace + "/" + policy.name
+		policies[policy.namespace+"/"+policy.name] = true
+	}
+
+	for _, pod := range pc.podChains {
+		if !pods[pod] {
+			namespace, name, _ := strings.Cut(pod, "/")
+			for _, metric := range policyMetrics[:4] {
+				metric.DeleteLabelValues(namespace, name)
+			}
+		}
+	}
+	for _, policy := range pc.policyChains {
+		if !policies[policy] {
+			namespace, name, _ := strings.Cut(policy, "/")
+			for _, metric := range policyMetrics[4:] {
+				metric.DeleteLabelValues(namespace, name)
+			}
+		}
+	}
+	pc.podChains, pc.policyChains = podChains, policyChains
+}
+
+// parseRuleCounters returns the counters of the rules of iptables-save -c that drop or mark as accepted the traffic
+// of the pod firewall chains and the rules that match the traffic of the network policy chains, summed per chain
+func parseRuleCounters(savedRules string) map[ruleCounterKey]ruleCounters {
+	counters := make(map[ruleCounterKey]ruleCounters)
+	for _, rule := range strings.Split(savedRules, "\n") {
+		if !strings.HasPrefix(rule, "[") {
+			continue
+		}
+		ruleCounter, rule, found := strings.Cut(rule[1:], "] ")
+		if !found {
+			continue
+		}
+		packets, bytes, found := strings.Cut(ruleCounter, ":")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(rule)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		key := ruleCounterKey{chain: fields[1]}
+		switch {
+		case strings.HasPrefix(key.chain, kubePodFirewallChainPrefix) && (strings.Contains(rule, " -j REJECT") ||
+			strings.Contains(rule, " -j DROP") && !strings.Contains(rule, "INVALID")):
+			key.verdict = verdictDropped
+		case strings.HasPrefix(key.chain, kubePodFirewallChainPrefix) &&
+			strings.Contains(rule, " -j MARK --set-xmark 0x20000/0x20000"):
+			key.verdict = verdictAccepted
+		case strings.HasPrefix(key.chain, kubeNetworkPolicyChainPrefix) &&
+			strings.Contains(rule, " -j MARK --set-xmark 0x10000/0x10000"):
+			key.verdict = verdictAccepted
+		default:
+			continue
+		}
+		packetCount, err := strconv.ParseUint(packets, 10, 64)
+		if err != nil {
+			continue
+		}
+		byteCount, err := strconv.ParseUint(bytes, 10, 64)
+		if err != nil {
+			continue
+		}
+		sum := counters[key]
+		sum.packets += packetCount
+		sum.bytes += byteCount
+		counters[key] = sum
+	}
+	return counters
+}
+
+// stripRuleCounters removes the counters iptables-save -c prefixes the rules with
+func stripRuleCounters(savedRules string) string {
+	lines := strings.Split(savedRules, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "[") {
+			if _, rule, found := strings.Cut(line, "] "); found {
+				lines[i] = rule
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
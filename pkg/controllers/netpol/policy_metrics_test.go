@@ -0,0 +1,62 @@
+package netpol
+
+import (
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+)
+
+func Test_parseRuleCounters(t *testing.T) {
+	savedRules := "*filter\n" +
+		":KUBE-POD-FW-A - [0:0]\n" +
+		"[5:300] -A KUBE-POD-FW-A -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT\n" +
+		"[2:120] -A KUBE-POD-FW-A -m mark ! --mark 0x10000/0x10000 -j REJECT --reject-with icmp-port-unreachable\n" +
+		"[7:420] -A KUBE-POD-FW-A -j MARK --set-xmark 0x20000/0x20000\n" +
+		"[3:180] -A KUBE-NWPLCY-A -m set --match-set KUBE-SRC-A src -j MARK --set-xmark 0x10000/0x10000\n" +
+		"[3:180] -A KUBE-NWPLCY-A -m mark --mark 0x10000/0x10000 -j RETURN\n" +
+		"[1:60] -A KUBE-NWPLCY-A -p tcp --dport 80 -j MARK --set-xmark 0x10000/0x10000\n" +
+		"[9:540] -A FORWARD -j REJECT\n" +
+		"COMMIT\n"
+
+	assert.Equal(t, map[ruleCounterKey]ruleCounters{
+		{chain: "KUBE-POD-FW-A", verdict: verdictDropped}:  {packets: 2, bytes: 120},
+		{chain: "KUBE-POD-FW-A", verdict: verdictAccepted}: {packets: 7, bytes: 420},
+		{chain: "KUBE-NWPLCY-A", verdict: verdictAccepted}: {packets: 4, bytes: 240},
+	}, parseRuleCounters(savedRules))
+
+	assert.Equal(t, "*filter\n:KUBE-POD-FW-A - [0:0]\n-A KUBE-POD-FW-A -j MARK --set-xmark 0x20000/0x20000\nCOMMIT\n",
+		stripRuleCounters("*filter\n:KUBE-POD-FW-A - [0:0]\n[7:420] -A KUBE-POD-FW-A -j MARK --set-xmark "+
+			"0x20000/0x20000\nCOMMIT\n"))
+}
+
+func Test_policyCountersPublish(t *testing.T) {
+	pod := podInfo{ip: "10.1.1.1", name: "frontend", namespace: "metrics-ns"}
+	policy := networkPolicyInfo{name: "allow-frontend", namespace: "metrics-ns"}
+	podChain := podFirewallChainName(pod.namespace, pod.name, "1")
+	policyChain := networkPolicyChainName(policy.namespace, policy.name, "1")
+	savedRules := func(dropped, accepted string) map[api.IPFamily]string {
+		return map[api.IPFamily]string{api.IPv4Protocol: "[" + dropped + "] -A " + podChain + " -j REJECT\n" +
+			"[" + accepted + "] -A " + policyChain + " -j MARK --set-xmark 0x10000/0x10000\n"}
+	}
+
+	pc := newPolicyCounters()
+	pc.update(map[string]podInfo{pod.ip: pod}, []networkPolicyInfo{policy}, "1")
+	pc.publish(savedRules("2:100", "4:400"))
+	pc.publish(savedRules("3:150", "4:400"))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.NetpolPodDroppedPackets.WithLabelValues("metrics-ns", "frontend")))
+	assert.Equal(t, 150.0, testutil.ToFloat64(metrics.NetpolPodDroppedBytes.WithLabelValues("metrics-ns", "frontend")))
+	assert.Equal(t, 4.0, testutil.ToFloat64(
+		metrics.NetpolPolicyAcceptedPackets.WithLabelValues("metrics-ns", "allow-frontend")))
+
+	// the counters of replaced chains restart from zero
+	pc.publish(savedRules("1:50", "4:400"))
+	assert.Equal(t, 4.0, testutil.ToFloat64(metrics.NetpolPodDroppedPackets.WithLabelValues("metrics-ns", "frontend")))
+
+	pc.update(map[string]podInfo{}, []networkPolicyInfo{policy}, "2")
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.NetpolPodDroppedPackets),
+		"expected the metrics of the pods that are gone to be deleted")
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.NetpolPolicyAcceptedPackets))
+}
@@ -0,0 +1,459 @@
+package netpol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	anpv1alpha1 "github.com/cloudnativelabs/kube-router/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	api "k8s.io/api/core/v1"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// The flows are simulated on the policy model the syncs build, and evaluated the way the pod firewall chains evaluate
+// the first packet of a new connection: the egress of the flow in the chain of the source pod, then its ingress in the
+// chain of the destination pod when the destination is a pod. The flow is allowed when both of them allow it, both are
+// evaluated so that the rules deciding either of them are known.
+const (
+	// PolicySimulationPath is the path of the health check server the flows are simulated on, it only answers the
+	// requests from the node itself
+	PolicySimulationPath = "/netpol/simulate"
+
+	policyKindNetworkPolicy              = "NetworkPolicy"
+	policyKindAdminNetworkPolicy         = "AdminNetworkPolicy"
+	policyKindBaselineAdminNetworkPolicy = "BaselineAdminNetworkPolicy"
+	policyKindGlobalNetworkPolicy        = "GlobalNetworkPolicy"
+
+	// nodeLocalDNSPort is the port of the DNS queries the pods are always allowed to send to the node-local DNS cache
+	nodeLocalDNSPort = 53
+)
+
+// FlowSimulation is the outcome of the simulation of a flow from a pod to a pod or an IP
+type FlowSimulation struct {
+	Source        string `json:"source"`
+	SourceIP      string `json:"sourceIP"`
+	Destination   string `json:"destination"`
+	DestinationIP string `json:"destinationIP"`
+	Protocol      string `json:"protocol"`
+	Port          int    `json:"port,omitempty"`
+	Allowed       bool   `json:"allowed"`
+	// Egress is the decision of the firewall chain of the source pod, Ingress the one of the firewall chain of the
+	// destination pod when the destination is a pod
+	Egress  FlowDecision  `json:"egress"`
+	Ingress *FlowDecision `json:"ingress,omitempty"`
+}
+
+// FlowDecision is the decision of the firewall chain of a pod on a simulated flow, along with the policy rule that took
+// it if any
+type FlowDecision struct {
+	Allowed bool `json:"allowed"`
+	// Verdict is DROP or REJECT when the flow is denied
+	Verdict string `json:"verdict,omitempty"`
+	// PolicyKind is NetworkPolicy, AdminNetworkPolicy, BaselineAdminNetworkPolicy or GlobalNetworkPolicy, Policy the
+	// name of the policy, prefixed by its namespace for the NetworkPolicies, and Rule the index of its rule
+	PolicyKind string `json:"policyKind,omitempty"`
+	Policy     string `json:"policy,omitempty"`
+	Rule       *int   `json:"rule,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// simulatedFlow is a flow along with the pods at both ends of it, dstPod is nil when the destination isn't a pod
+type simulatedFlow struct {
+	srcPod   podInfo
+	dstPod   *podInfo
+	srcIP    string
+	dstIP    string
+	protocol string
+	port     int
+}
+
+// ServeFlowSimulation answers with the FlowSimulation, as JSON, of the flow from the source pod to the destination pod
+// or IP with the protocol and port of the query parameters. The requests that don't come from the node are refused as
+// the simulations reveal the pods and policies of all namespaces.
+func (npc *NetworkPolicyController) ServeFlowSimulation(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		http.Error(w, "flows can only be simulated from the node", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	port := 0
+	if value := query.Get("port"); value != "" {
+		if port, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "invalid port "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	simulation, err := npc.SimulateFlow(query.Get("source"), query.Get("destination"), query.Get("protocol"), port)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(simulation); err != nil {
+		klog.Errorf("Failed to write the flow simulation: %v", err)
+	}
+}
+
+// SimulateFlow tells whether the network policies allow the flow from the source pod, given as namespace/name, to the
+// destination pod or IP, and which policy rule decided it. The port is only given for TCP, UDP and SCTP.
+func (npc *NetworkPolicyController) SimulateFlow(source, destination, protocol string,
+	port int) (*FlowSimulation, error) {
+	protocol = strings.ToUpper(protocol)
+	switch protocol {
+	case "":
+		return nil, errors.New("the protocol of the flow is missing")
+	case string(api.ProtocolTCP), string(api.ProtocolUDP), string(api.ProtocolSCTP):
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid %s port %d", protocol, port)
+		}
+	default:
+		if port != 0 {
+			return nil, fmt.Errorf("protocol %s has no ports", protocol)
+		}
+	}
+
+	srcPod, err := npc.simulatedFlowPod(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %v", err)
+	}
+	flow := simulatedFlow{srcPod: newPodInfo(srcPod), protocol: protocol, port: port}
+
+	var ipFamily api.IPFamily
+	if dstIP := net.ParseIP(destination); dstIP != nil {
+		flow.dstIP = dstIP.String()
+		ipFamily = ipFamilyOfIP(dstIP)
+		if dstPod := npc.podOfIP(flow.dstIP); dstPod != nil {
+			dstPodInfo := newPodInfo(dstPod)
+			flow.dstPod = &dstPodInfo
+		}
+	} else {
+		dstPod, err := npc.simulatedFlowPod(destination)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination: %v", err)
+		}
+		dstPodInfo := newPodInfo(dstPod)
+		flow.dstPod = &dstPodInfo
+		// the flow uses the address family of the primary IP of the source pod if the destination pod has an IP of it
+		ipFamily = ipFamilyOfEntry(flow.srcPod.ip)
+		if dstPodInfo.ipOfFamily(ipFamily) == "" {
+			ipFamily = ipFamilyOfEntry(dstPodInfo.ip)
+		}
+		flow.dstIP = dstPodInfo.ipOfFamily(ipFamily)
+	}
+	if flow.srcIP = flow.srcPod.ipOfFamily(ipFamily); flow.srcIP == "" || flow.dstIP == "" {
+		return nil, fmt.Errorf("%s and %s have no address family in common", source, destination)
+	}
+
+	simulation := &FlowSimulation{Source: flow.srcPod.namespace + "/" + flow.srcPod.name, SourceIP: flow.srcIP,
+		Destination: flow.dstIP, DestinationIP: flow.dstIP, Protocol: protocol, Port: port}
+	if flow.dstPod != nil {
+		simulation.Destination = flow.dstPod.namespace + "/" + flow.dstPod.name
+	}
+
+	if !npc.isIPFamilyEnabled(ipFamily) {
+		simulation.Allowed = true
+		simulation.Egress = FlowDecision{Allowed: true,
+			Reason: "the network policies aren't enforced on the " + string(ipFamily) + " traffic"}
+		return simulation, nil
+	}
+
+	networkPoliciesInfo, err := npc.buildNetworkPoliciesInfo()
+	if err != nil {
+		return nil, errors.New("Failed to build the network policies: " + err.Error())
+	}
+	adminPoliciesInfo := npc.buildAdminNetworkPoliciesInfo()
+
+	simulation.Egress = npc.simulatePodFlow(kubeEgressPolicyType, flow.srcPod, flow, networkPoliciesInfo,
+		adminPoliciesInfo)
+	simulation.Allowed = simulation.Egress.Allowed
+	if flow.dstPod != nil {
+		ingress := npc.simulatePodFlow(kubeIngressPolicyType, *flow.dstPod, flow, networkPoliciesInfo,
+			adminPoliciesInfo)
+		simulation.Ingress = &ingress
+		simulation.Allowed = simulation.Allowed && ingress.Allowed
+	}
+	return simulation, nil
+}
+
+// simulatedFlowPod returns the pod given as namespace/name, as long as the network policies apply to it
+func (npc *NetworkPolicyController) simulatedFlowPod(key string) (*api.Pod, error) {
+	namespace, name, found := strings.Cut(key, "/")
+	if !found || namespace == "" || name == "" {
+		return nil, fmt.Errorf("%q is neither an IP nor a pod given as namespace/name", key)
+	}
+	pod, err := listers.NewPodLister(npc.podLister).Pods(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if !isNetPolActionable(pod) {
+		return nil, fmt.Errorf("pod %s has no IP, is finished or uses the host network", key)
+	}
+	return pod, nil
+}
+
+// podOfIP returns the pod the network policies apply to with the IP, or nil if there is none
+func (npc *NetworkPolicyController) podOfIP(ip string) *api.Pod {
+	for _, obj := range npc.podLister.List() {
+		pod, ok := obj.(*api.Pod)
+		if !ok || !isNetPolActionable(pod) {
+			continue
+		}
+		for _, podIP := range getIPsFromPods([]podInfo{newPodInfo(pod)}) {
+			if podIP == ip {
+				return pod
+			}
+		}
+	}
+	return nil
+}
+
+// simulatePodFlow evaluates the flow in the given direction in the firewall chain of the pod: the admin and global
+// network policies first, then the network policies selecting the pod in the direction, or the baseline and the
+// default network policy chain when there are none
+func (npc *NetworkPolicyController) simulatePodFlow(direction string, pod podInfo, flow simulatedFlow,
+	networkPoliciesInfo []networkPolicyInfo, adminPoliciesInfo []adminNetworkPolicyInfo) FlowDecision {
+	podKey := pod.namespace + "/" + pod.name
+	if npc.isNetpolDisabled(pod.namespace) {
+		return FlowDecision{Allowed: true,
+			Reason: "the network policies are disabled in namespace " + pod.namespace}
+	}
+	if direction == kubeEgressPolicyType && npc.nodeLocalDNSIP != nil && flow.dstIP == npc.nodeLocalDNSIP.String() &&
+		(flow.protocol == string(api.ProtocolUDP) || flow.protocol == string(api.ProtocolTCP)) &&
+		flow.port == nodeLocalDNSPort {
+		return FlowDecision{Allowed: true, Reason: "the DNS queries to the node-local DNS cache are always allowed"}
+	}
+
+	selectingPolicies := make([]*networkPolicyInfo, 0)
+	for i := range networkPoliciesInfo {
+		policy := &networkPoliciesInfo[i]
+		if _, ok := policy.targetPods[pod.ip]; !ok {
+			continue
+		}
+		if policy.policyType == kubeBothPolicyType || policy.policyType == direction {
+			selectingPolicies = append(selectingPolicies, policy)
+		}
+	}
+
+	// the peer of the pod is the destination of the egress traffic and the source of the ingress traffic
+	peerIP := flow.srcIP
+	if direction == kubeEgressPolicyType {
+		peerIP = flow.dstIP
+	}
+	passed := false
+	for _, policy := range adminPoliciesInfo {
+		if _, ok := policy.subjectPods[pod.ip]; !ok {
+			continue
+		}
+		// the AdminNetworkPolicies are skipped once one passed the traffic, the baseline only applies in the
+		// directions no network policy selects the pod in
+		if (policy.baseline && len(selectingPolicies) != 0) || (passed && !policy.baseline) {
+			continue
+		}
+		rules := policy.ingressRules
+		if direction == kubeEgressPolicyType {
+			rules = policy.egressRules
+		}
+		for ruleIdx, rule := range rules {
+			if !npc.adminPolicyRuleMatches(rule, peerIP, flow) {
+				continue
+			}
+			decision := FlowDecision{PolicyKind: adminPolicyKindName(policy), Policy: policy.name,
+				Rule: &ruleIdx}
+			switch rule.action {
+			case anpv1alpha1.AdminNetworkPolicyRuleActionAllow:
+				decision.Allowed = true
+				decision.Reason = fmt.Sprintf("allowed by %s rule %d of %s", direction, ruleIdx,
+					adminPolicyDescription(policy))
+				return decision
+			case anpv1alpha1.AdminNetworkPolicyRuleActionDeny:
+				decision.Verdict = npc.denyAction.verdict()
+				decision.Reason = fmt.Sprintf("denied by %s rule %d of %s", direction, ruleIdx,
+					adminPolicyDescription(policy))
+				return decision
+			}
+			passed = true
+			break
+		}
+	}
+
+	if len(selectingPolicies) != 0 {
+		names := make([]string, 0, len(selectingPolicies))
+		for _, policy := range selectingPolicies {
+			names = append(names, policy.namespace+"/"+policy.name)
+			for ruleIdx := range policy.ingressRules {
+				if direction == kubeIngressPolicyType && npc.ingressRuleMatches(policy.ingressRules[ruleIdx], flow) {
+					return networkPolicyRuleDecision(policy, direction, ruleIdx)
+				}
+			}
+			for ruleIdx := range policy.egressRules {
+				if direction == kubeEgressPolicyType && npc.egressRuleMatches(policy.egressRules[ruleIdx], flow) {
+					return networkPolicyRuleDecision(policy, direction, ruleIdx)
+				}
+			}
+		}
+		return FlowDecision{Verdict: npc.podDenyAction(pod, networkPoliciesInfo).verdict(),
+			Reason: fmt.Sprintf("no %s rule of the network policies selecting %s (%s) allows it", direction, podKey,
+				strings.Join(names, ", "))}
+	}
+
+	if direction == kubeIngressPolicyType && npc.isNamespaceIsolated(pod.namespace) &&
+		flow.srcPod.namespace != pod.namespace {
+		return FlowDecision{Verdict: npc.podDenyAction(pod, networkPoliciesInfo).verdict(),
+			Reason: fmt.Sprintf("namespace %s is isolated and no ingress network policy selects %s", pod.namespace,
+				podKey)}
+	}
+	return FlowDecision{Allowed: true,
+		Reason: fmt.Sprintf("no network policy selects %s for %s", podKey, direction)}
+}
+
+func networkPolicyRuleDecision(policy *networkPolicyInfo, direction string, ruleIdx int) FlowDecision {
+	return FlowDecision{Allowed: true, PolicyKind: policyKindNetworkPolicy,
+		Policy: policy.namespace + "/" + policy.name, Rule: &ruleIdx,
+		Reason: fmt.Sprintf("allowed by %s rule %d of network policy %s/%s", direction, ruleIdx, policy.namespace,
+			policy.name)}
+}
+
+// ingressRuleMatches tells whether the rules of the chain of the network policy for the ingress rule match the flow
+func (npc *NetworkPolicyController) ingressRuleMatches(rule ingressRule, flow simulatedFlow) bool {
+	if rule.matchAllSource {
+		return rule.matchAllPorts || npc.policyPortsMatch(rule.ports, rule.namedPorts, flow)
+	}
+	// when none of its ports resolved, the rule of the pod peers matches all the ports like its iptables rule does
+	if containsIP(getIPsFromPods(rule.srcPods), flow.srcIP) && ((len(rule.ports) == 0 && len(rule.namedPorts) == 0) ||
+		npc.policyPortsMatch(rule.ports, rule.namedPorts, flow)) {
+		return true
+	}
+	return ipBlocksContain(rule.srcIPBlocks, flow.srcIP) &&
+		(rule.matchAllPorts || npc.policyPortsMatch(rule.ports, rule.namedPorts, flow))
+}
+
+// egressRuleMatches tells whether the rules of the chain of the network policy for the egress rule match the flow
+func (npc *NetworkPolicyController) egressRuleMatches(rule egressRule, flow simulatedFlow) bool {
+	if containsIP(getIPsFromPods(rule.dstPods), flow.dstIP) && ((len(rule.ports) == 0 && len(rule.namedPorts) == 0) ||
+		npc.policyPortsMatch(rule.ports, rule.namedPorts, flow)) {
+		return true
+	}
+	if rule.matchAllDestinations && (rule.matchAllPorts || npc.policyPortsMatch(rule.ports, rule.namedPorts, flow)) {
+		return true
+	}
+	// the ipBlocks have no named ports
+	return ipBlocksContain(rule.dstIPBlocks, flow.dstIP) &&
+		(rule.matchAllPorts || npc.policyPortsMatch(rule.ports, nil, flow))
+}
+
+// adminPolicyRuleMatches tells whether the rules of the chain of the admin network policy for the rule match the flow
+// with the peer
+func (npc *NetworkPolicyController) adminPolicyRuleMatches(rule adminPolicyRule, peerIP string,
+	flow simulatedFlow) bool {
+	if (rule.matchAllPeers || containsIP(getIPsFromPods(rule.peerPods), peerIP)) &&
+		(rule.matchAllPorts || npc.policyPortsMatch(rule.ports, rule.namedPorts, flow)) {
+		return true
+	}
+	// the networks and nodes have no named ports
+	return ipBlocksContain(rule.peerIPBlocks, peerIP) &&
+		(rule.matchAllPorts || npc.policyPortsMatch(rule.ports, nil, flow))
+}
+
+// policyPortsMatch tells whether the protocol and port of the flow match one of the ports, or one of the named ports
+// of the pod the flow is destined to
+func (npc *NetworkPolicyController) policyPortsMatch(ports []protocolAndPort, namedPorts []endPoints,
+	flow simulatedFlow) bool {
+	for _, port := range ports {
+		if npc.policyPortMatches(port, flow) {
+			return true
+		}
+	}
+	for _, eps := range namedPorts {
+		if npc.policyPortMatches(eps.protocolAndPort, flow) && containsIP(eps.ips, flow.dstIP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (npc *NetworkPolicyController) policyPortMatches(port protocolAndPort, flow simulatedFlow) bool {
+	if port.protocol != "" && !strings.EqualFold(port.protocol, flow.protocol) {
+		return false
+	}
+	if port.port == "" {
+		return true
+	}
+	// the rules of the SCTP ports are left out when the kernel can't match on them
+	if strings.EqualFold(port.protocol, string(api.ProtocolSCTP)) && !npc.sctpPortMatch {
+		return false
+	}
+	start, err := strconv.Atoi(port.port)
+	if err != nil {
+		return false
+	}
+	end := start
+	if port.endport != "" {
+		if end, err = strconv.Atoi(port.endport); err != nil {
+			return false
+		}
+	}
+	return flow.port >= start && flow.port <= end
+}
+
+func containsIP(ips []string, ip string) bool {
+	for _, candidate := range ips {
+		if candidate == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// ipBlocksContain tells whether the IP matches the ipset entries of the ipBlocks, like a hash:net ipset the most
+// specific entry containing the IP decides and the IP doesn't match when it is a nomatch entry
+func ipBlocksContain(ipBlocks [][]string, ip string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	bestPrefix, matches := -1, false
+	for _, entry := range ipBlocks {
+		if len(entry) == 0 {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry[0])
+		if err != nil {
+			entryIP := net.ParseIP(entry[0])
+			if entryIP == nil {
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if entryIP.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			ipNet = &net.IPNet{IP: entryIP, Mask: net.CIDRMask(bits, bits)}
+		}
+		if !ipNet.Contains(parsedIP) {
+			continue
+		}
+		if prefix, _ := ipNet.Mask.Size(); prefix > bestPrefix {
+			bestPrefix = prefix
+			matches = !containsIP(entry[1:], utils.OptionNoMatch)
+		}
+	}
+	return matches
+}
+
+// adminPolicyKindName returns the kind of the resource of the admin or global network policy
+func adminPolicyKindName(policy adminNetworkPolicyInfo) string {
+	switch {
+	case policy.baseline:
+		return policyKindBaselineAdminNetworkPolicy
+	case policy.global:
+		return policyKindGlobalNetworkPolicy
+	}
+	return policyKindAdminNetworkPolicy
+}
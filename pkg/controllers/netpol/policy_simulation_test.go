@@ -0,0 +1,186 @@
+package netpol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	anpv1alpha1 "github.com/cloudnativelabs/kube-router/pkg/apis/policy/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func tNewSimulationController(t *testing.T, netpols ...tNetpol) *NetworkPolicyController {
+	client := fake.NewSimpleClientset()
+	_, podInformer, nsInformer, npInformer := newFakeInformersFromClient(client)
+	tCreateFakePods(t, podInformer, nsInformer)
+	for i := range netpols {
+		netpols[i].createFakeNetpol(t, npInformer)
+	}
+	return newUneventfulNetworkPolicyController(podInformer, npInformer, nsInformer)
+}
+
+func Test_SimulateFlow(t *testing.T) {
+	tcp := v1.ProtocolTCP
+	port80 := intstr.FromInt(80)
+	npc := tNewSimulationController(t,
+		tNetpol{name: "allow-app-a", namespace: "nsA",
+			podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"component": "a"}},
+			ingress: []netv1.NetworkPolicyIngressRule{{
+				From: []netv1.NetworkPolicyPeer{{
+					PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "a"}}}},
+				Ports: []netv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port80}}}}},
+		tNetpol{name: "egress-internal", namespace: "nsA",
+			podSelector: metav1.LabelSelector{MatchLabels: map[string]string{"component": "b"}},
+			egress: []netv1.NetworkPolicyEgressRule{{
+				To: []netv1.NetworkPolicyPeer{{IPBlock: &netv1.IPBlock{CIDR: "10.0.0.0/8",
+					Except: []string{"10.1.0.0/16"}}}}}}})
+
+	simulation, err := npc.SimulateFlow("nsA/Aa", "nsA/Aaa", "tcp", 80)
+	assert.NoError(t, err)
+	assert.True(t, simulation.Allowed)
+	assert.Equal(t, "1.1.1.1", simulation.SourceIP)
+	assert.Equal(t, "1.1.1.2", simulation.DestinationIP)
+	assert.True(t, simulation.Egress.Allowed, "expected the egress of a pod no policy selects to be allowed")
+	assert.Empty(t, simulation.Egress.Policy)
+	if assert.NotNil(t, simulation.Ingress) {
+		assert.Equal(t, policyKindNetworkPolicy, simulation.Ingress.PolicyKind)
+		assert.Equal(t, "nsA/allow-app-a", simulation.Ingress.Policy)
+		assert.Equal(t, 0, *simulation.Ingress.Rule)
+	}
+
+	simulation, err = npc.SimulateFlow("nsA/Aa", "1.1.1.2", "TCP", 8080)
+	assert.NoError(t, err)
+	assert.False(t, simulation.Allowed, "expected the port no rule allows to be denied")
+	assert.Equal(t, "nsA/Aaa", simulation.Destination, "expected the destination IP to be resolved to its pod")
+	assert.Equal(t, denyActionReject, simulation.Ingress.Verdict)
+	assert.Contains(t, simulation.Ingress.Reason, "nsA/allow-app-a")
+
+	simulation, err = npc.SimulateFlow("nsB/Ba", "nsA/Aaa", "TCP", 80)
+	assert.NoError(t, err)
+	assert.False(t, simulation.Allowed, "expected the pod selector peer to only select the pods of the namespace")
+
+	simulation, err = npc.SimulateFlow("nsA/Aab", "10.2.3.4", "UDP", 53)
+	assert.NoError(t, err)
+	assert.True(t, simulation.Allowed)
+	assert.Equal(t, "nsA/egress-internal", simulation.Egress.Policy)
+	assert.Nil(t, simulation.Ingress, "expected no ingress decision for a destination outside the pod network")
+
+	simulation, err = npc.SimulateFlow("nsA/Aab", "10.1.2.3", "UDP", 53)
+	assert.NoError(t, err)
+	assert.False(t, simulation.Allowed, "expected the IPs of the except ranges to be denied")
+
+	for _, flow := range [][]string{{"nsA/missing", "nsA/Aaa", "TCP"}, {"nsA/Aa", "Aaa", "TCP"},
+		{"nsA/Aa", "nsA/Aaa", ""}} {
+		_, err = npc.SimulateFlow(flow[0], flow[1], flow[2], 80)
+		assert.Error(t, err, "expected flow %v to be invalid", flow)
+	}
+	_, err = npc.SimulateFlow("nsA/Aa", "nsA/Aaa", "TCP", 0)
+	assert.Error(t, err, "expected the port of a TCP flow to be required")
+	_, err = npc.SimulateFlow("nsA/Aa", "nsA/Aaa", "ICMP", 0)
+	assert.NoError(t, err)
+}
+
+func Test_SimulateFlowAdminNetworkPolicies(t *testing.T) {
+	npc := tNewSimulationController(t,
+		tNetpol{name: "allow-all", namespace: "nsB", podSelector: metav1.LabelSelector{},
+			ingress: []netv1.NetworkPolicyIngressRule{{}}})
+	nsC := &metav1.LabelSelector{MatchLabels: map[string]string{"name": "c"}}
+	npc.anpLister = tNewAdminPolicyIndexer(t,
+		&anpv1alpha1.AdminNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "deny-c"},
+			Spec: anpv1alpha1.AdminNetworkPolicySpec{Priority: 10, Subject: anpv1alpha1.AdminNetworkPolicySubject{
+				Namespaces: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}},
+				Ingress: []anpv1alpha1.AdminNetworkPolicyIngressRule{
+					{Action: "Pass", From: []anpv1alpha1.AdminNetworkPolicyIngressPeer{{Namespaces: nsC}},
+						Ports: []anpv1alpha1.AdminNetworkPolicyPort{{PortNumber: &anpv1alpha1.Port{Port: 443}}}},
+					{Action: "Deny", From: []anpv1alpha1.AdminNetworkPolicyIngressPeer{{Namespaces: nsC}}}}}})
+	npc.banpLister = tNewAdminPolicyIndexer(t,
+		&anpv1alpha1.BaselineAdminNetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: anpv1alpha1.BaselineAdminNetworkPolicySpec{Subject: anpv1alpha1.AdminNetworkPolicySubject{
+				Namespaces: &metav1.LabelSelector{}},
+				Ingress: []anpv1alpha1.AdminNetworkPolicyIngressRule{{Action: "Deny",
+					From: []anpv1alpha1.AdminNetworkPolicyIngressPeer{{Namespaces: nsC}}}}}})
+
+	simulation, err := npc.SimulateFlow("nsC/Ca", "nsB/Ba", "TCP", 80)
+	assert.NoError(t, err)
+	assert.False(t, simulation.Allowed)
+	assert.Equal(t, policyKindAdminNetworkPolicy, simulation.Ingress.PolicyKind)
+	assert.Equal(t, "deny-c", simulation.Ingress.Policy)
+	assert.Equal(t, 1, *simulation.Ingress.Rule)
+
+	simulation, err = npc.SimulateFlow("nsC/Ca", "nsB/Ba", "TCP", 443)
+	assert.NoError(t, err)
+	assert.True(t, simulation.Allowed, "expected the passed traffic to be allowed by the network policy")
+	assert.Equal(t, "nsB/allow-all", simulation.Ingress.Policy)
+
+	simulation, err = npc.SimulateFlow("nsC/Ca", "nsA/Aa", "TCP", 443)
+	assert.NoError(t, err)
+	assert.False(t, simulation.Allowed, "expected the baseline to apply to the passed traffic of pods without policies")
+	assert.Equal(t, policyKindBaselineAdminNetworkPolicy, simulation.Ingress.PolicyKind)
+
+	simulation, err = npc.SimulateFlow("nsA/Aa", "nsC/Ca", "TCP", 443)
+	assert.NoError(t, err)
+	assert.True(t, simulation.Allowed)
+}
+
+func Test_SimulateFlowNamespaceIsolation(t *testing.T) {
+	npc := tNewSimulationController(t)
+	npc.namespaceIsolation = true
+	npc.namespaceIsolationExempt = map[string]bool{"nsC": true}
+	npc.denyAction = denyAction{target: denyActionDrop}
+
+	simulation, err := npc.SimulateFlow("nsB/Ba", "nsA/Aa", "TCP", 80)
+	assert.NoError(t, err)
+	assert.False(t, simulation.Allowed)
+	assert.Equal(t, denyActionDrop, simulation.Ingress.Verdict)
+	assert.Contains(t, simulation.Ingress.Reason, "isolated")
+
+	for _, flow := range [][]string{{"nsA/Aab", "nsA/Aa"}, {"nsB/Ba", "nsC/Ca"}} {
+		simulation, err = npc.SimulateFlow(flow[0], flow[1], "TCP", 80)
+		assert.NoError(t, err)
+		assert.True(t, simulation.Allowed, "expected flow %v to be allowed", flow)
+	}
+}
+
+func Test_ServeFlowSimulation(t *testing.T) {
+	npc := tNewSimulationController(t)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet,
+		PolicySimulationPath+"?source=nsA/Aa&destination=nsB/Ba&protocol=tcp&port=80", nil)
+	request.RemoteAddr = "127.0.0.1:43210"
+	npc.ServeFlowSimulation(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var simulation FlowSimulation
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &simulation))
+	assert.True(t, simulation.Allowed)
+	assert.Equal(t, "nsB/Ba", simulation.Destination)
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, PolicySimulationPath+"?source=nsA/Aa&destination=nsB/Ba", nil)
+	request.RemoteAddr = "127.0.0.1:43210"
+	npc.ServeFlowSimulation(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	request.RemoteAddr = "10.0.0.1:43210"
+	npc.ServeFlowSimulation(recorder, request)
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "expected the requests from other hosts to be refused")
+}
+
+func Test_ipBlocksContain(t *testing.T) {
+	ipBlocks := [][]string{{"10.0.0.0/8", utils.OptionTimeout, "0"},
+		{"10.1.0.0/16", utils.OptionTimeout, "0", utils.OptionNoMatch},
+		{"10.1.2.0/24", utils.OptionTimeout, "0"}, {"192.168.1.1", utils.OptionTimeout, "0"}}
+	assert.True(t, ipBlocksContain(ipBlocks, "10.2.0.1"))
+	assert.False(t, ipBlocksContain(ipBlocks, "10.1.3.1"), "expected the except range to take precedence")
+	assert.True(t, ipBlocksContain(ipBlocks, "10.1.2.1"), "expected the most specific entry to take precedence")
+	assert.True(t, ipBlocksContain(ipBlocks, "192.168.1.1"))
+	assert.False(t, ipBlocksContain(ipBlocks, "192.168.1.2"))
+}
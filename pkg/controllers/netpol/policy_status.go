@@ -0,0 +1,118 @@
+package netpol
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+// policyStatusWriteTimeout bounds the requests writing the NodeNetworkPolicyStatus of the node
+const policyStatusWriteTimeout = 30 * time.Second
+
+// policyStatusReporter publishes the outcome of the syncs in the NodeNetworkPolicyStatus of the node. The syncs only
+// record it, the object is written by run so that the syncs never wait on the API server.
+type policyStatusReporter struct {
+	client   dynamic.Interface
+	nodeName string
+	nodeUID  types.UID
+
+	mu      sync.Mutex
+	status  v1alpha1.NodeNetworkPolicyStatusStatus
+	updated chan struct{}
+}
+
+func newPolicyStatusReporter(client dynamic.Interface, nodeName string, nodeUID types.UID) *policyStatusReporter {
+	return &policyStatusReporter{client: client, nodeName: nodeName, nodeUID: nodeUID, updated: make(chan struct{}, 1)}
+}
+
+// EnablePolicyStatus makes the controller publish the outcome of its syncs in the NodeNetworkPolicyStatus named
+// after the node. It has to be called before Run.
+func (npc *NetworkPolicyController) EnablePolicyStatus(client dynamic.Interface) {
+	npc.policyStatus = newPolicyStatusReporter(client, npc.nodeHostName, npc.nodeUID)
+}
+
+// synced records a successful sync, which clears the error of the previous sync
+func (r *policyStatusReporter) synced(status v1alpha1.NodeNetworkPolicyStatusStatus) {
+	r.mu.Lock()
+	r.status = status
+	r.mu.Unlock()
+	r.notify()
+}
+
+// failed records the error of a sync, the counts of the last successful sync are kept
+func (r *policyStatusReporter) failed(err error) {
+	now := metav1.Now()
+	r.mu.Lock()
+	r.status.Error = err.Error()
+	r.status.ErrorTime = &now
+	r.mu.Unlock()
+	r.notify()
+}
+
+func (r *policyStatusReporter) notify() {
+	select {
+	case r.updated <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the status recorded since the previous write until stopCh is closed, the status that fails to be
+// written is written again along with the next sync
+func (r *policyStatusReporter) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down the network policy status reporter")
+			return
+		case <-r.updated:
+			if err := r.write(); err != nil {
+				klog.Warningf("Failed to update the NodeNetworkPolicyStatus of node %s: %v", r.nodeName, err)
+			}
+		}
+	}
+}
+
+// write creates or updates the NodeNetworkPolicyStatus of the node with the recorded status
+func (r *policyStatusReporter) write() error {
+	r.mu.Lock()
+	status := r.status
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyStatusWriteTimeout)
+	defer cancel()
+	policyStatus := &v1alpha1.NodeNetworkPolicyStatus{
+		TypeMeta: metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind: v1alpha1.NodeNetworkPolicyStatusKind},
+		ObjectMeta: metav1.ObjectMeta{Name: r.nodeName, OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "v1", Kind: "Node", Name: r.nodeName, UID: r.nodeUID}}},
+		Status: status,
+	}
+	resource := r.client.Resource(v1alpha1.NodeNetworkPolicyStatusResource)
+	existing, err := resource.Get(ctx, r.nodeName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		obj, err := v1alpha1.ToUnstructured(policyStatus)
+		if err != nil {
+			return err
+		}
+		_, err = resource.Create(ctx, obj, metav1.CreateOptions{})
+		return err
+	case err != nil:
+		return err
+	}
+	policyStatus.ResourceVersion = existing.GetResourceVersion()
+	obj, err := v1alpha1.ToUnstructured(policyStatus)
+	if err != nil {
+		return err
+	}
+	_, err = resource.Update(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,51 @@
+package netpol
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func Test_policyStatusReporter(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	reporter := newPolicyStatusReporter(client, "node-a", "uid-a")
+	readStatus := func() *v1alpha1.NodeNetworkPolicyStatus {
+		obj, err := client.Resource(v1alpha1.NodeNetworkPolicyStatusResource).Get(context.Background(), "node-a",
+			metav1.GetOptions{})
+		assert.NoError(t, err)
+		policyStatus := &v1alpha1.NodeNetworkPolicyStatus{}
+		assert.NoError(t, v1alpha1.FromUnstructured(obj, policyStatus))
+		return policyStatus
+	}
+
+	now := metav1.Now()
+	reporter.synced(v1alpha1.NodeNetworkPolicyStatusStatus{LastSyncTime: &now, SyncVersion: "1", NetworkPolicies: 3,
+		Pods: 10, Chains: 14, IPSets: 6})
+	assert.NoError(t, reporter.write(), "expected the status to be created")
+	policyStatus := readStatus()
+	assert.Equal(t, []metav1.OwnerReference{{APIVersion: "v1", Kind: "Node", Name: "node-a", UID: "uid-a"}},
+		policyStatus.OwnerReferences)
+	assert.Equal(t, 3, policyStatus.Status.NetworkPolicies)
+	assert.Empty(t, policyStatus.Status.Error)
+
+	reporter.failed(errors.New("failed to run iptables-restore for IPv4: exit status 1"))
+	assert.NoError(t, reporter.write(), "expected the status to be updated")
+	policyStatus = readStatus()
+	assert.Equal(t, "failed to run iptables-restore for IPv4: exit status 1", policyStatus.Status.Error)
+	assert.NotNil(t, policyStatus.Status.ErrorTime)
+	assert.Equal(t, "1", policyStatus.Status.SyncVersion, "expected the last successful sync to be kept")
+	assert.Equal(t, 14, policyStatus.Status.Chains)
+
+	reporter.synced(v1alpha1.NodeNetworkPolicyStatusStatus{LastSyncTime: &now, SyncVersion: "2", NetworkPolicies: 4})
+	assert.NoError(t, reporter.write())
+	policyStatus = readStatus()
+	assert.Equal(t, "2", policyStatus.Status.SyncVersion)
+	assert.Empty(t, policyStatus.Status.Error, "expected a successful sync to clear the error")
+	assert.Nil(t, policyStatus.Status.ErrorTime)
+}
@@ -0,0 +1,74 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func Test_appendRuleToPolicyChainSCTP(t *testing.T) {
+	policy := networkPolicyInfo{name: "allow-diameter", namespace: "test-ns"}
+	for _, sctpPortMatch := range []bool{false, true} {
+		npc := &NetworkPolicyController{sctpPortMatch: sctpPortMatch, filterTableRules: tNewFilterTableRules()}
+		assert.NoError(t, npc.appendRuleToPolicyChain(policy, "KUBE-NWPLCY-TEST", "", "KUBE-SRC-TEST", "KUBE-DST-TEST",
+			"SCTP", "3868", "", api.IPv4Protocol))
+		assert.NoError(t, npc.appendRuleToPolicyChain(policy, "KUBE-NWPLCY-TEST", "", "KUBE-SRC-TEST", "KUBE-DST-TEST",
+			"SCTP", "", "", api.IPv4Protocol))
+		rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+		assert.Equal(t, sctpPortMatch, strings.Contains(rules, "-p SCTP --dport 3868 -j MARK"),
+			"unexpected SCTP port rule in:\n%s", rules)
+		assert.Contains(t, rules, "-p SCTP -j MARK", "expected the rules without ports to be kept")
+	}
+}
+
+func Test_processNetworkPolicyPortsNamedPorts(t *testing.T) {
+	npc := &NetworkPolicyController{}
+	namedPort2eps := make(namedPort2eps)
+	for _, pod := range []*api.Pod{
+		tNamedPortPod("10.1.1.1", api.ContainerPort{Name: "http", ContainerPort: 8080}),
+		tNamedPortPod("10.1.1.2", api.ContainerPort{Name: "http", ContainerPort: 80, Protocol: api.ProtocolTCP}),
+		tNamedPortPod("10.1.1.3", api.ContainerPort{Name: "http", ContainerPort: 80, Protocol: api.ProtocolUDP},
+			api.ContainerPort{ContainerPort: 9090}),
+	} {
+		npc.grabNamedPortFromPod(pod, &namedPort2eps)
+	}
+
+	httpPort := intstr.FromString("http")
+	_, namedPorts := npc.processNetworkPolicyPorts([]networking.NetworkPolicyPort{{Port: &httpPort}}, namedPort2eps)
+	assert.Equal(t, []endPoints{
+		{ips: []string{"10.1.1.2"}, protocolAndPort: protocolAndPort{protocol: "TCP", port: "80"}},
+		{ips: []string{"10.1.1.1"}, protocolAndPort: protocolAndPort{protocol: "TCP", port: "8080"}},
+	}, namedPorts, "expected the named port to resolve to the TCP ports of the pods, sorted")
+	assert.NotContains(t, namedPort2eps, "", "expected the unnamed ports to be left out")
+}
+
+func Test_processEgressRulesNamedPortToAllDestinations(t *testing.T) {
+	npc := &NetworkPolicyController{filterTableRules: tNewFilterTableRules(),
+		ipSetHandlers: map[api.IPFamily]*utils.IPSet{api.IPv4Protocol: {Sets: map[string]*utils.Set{}}}}
+	policy := networkPolicyInfo{name: "allow-http", namespace: "test-ns", egressRules: []egressRule{{
+		matchAllDestinations: true,
+		namedPorts: []endPoints{
+			{ips: []string{"10.1.1.2"}, protocolAndPort: protocolAndPort{protocol: "TCP", port: "8080"}},
+		},
+	}}}
+	assert.NoError(t, npc.processEgressRules(policy, "KUBE-SRC-TEST", map[string]bool{}, "1", api.IPv4Protocol))
+	rules := npc.filterTableRules[api.IPv4Protocol].String()
+
+	namedPortIPSetName := policyIndexedEgressNamedPortIPSetName(policy.namespace, policy.name, 0, 0)
+	assert.Contains(t, rules, "--match-set "+namedPortIPSetName+" dst -p TCP --dport 8080 -j MARK",
+		"expected the traffic to be allowed to the pods of the named port only")
+	assert.NotNil(t, npc.ipSetHandlers[api.IPv4Protocol].Get(namedPortIPSetName))
+}
+
+func tNamedPortPod(ip string, ports ...api.ContainerPort) *api.Pod {
+	return &api.Pod{
+		Spec:   api.PodSpec{Containers: []api.Container{{Name: "test", Ports: ports}}},
+		Status: api.PodStatus{PodIP: ip},
+	}
+}
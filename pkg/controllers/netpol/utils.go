@@ -2,9 +2,11 @@ package netpol
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	api "k8s.io/api/core/v1"
@@ -69,38 +71,144 @@ func validateNodePortRange(nodePortOption string) (string, error) {
 	return fmt.Sprintf("%d:%d", port1, port2), nil
 }
 
+// ruleReferencesChain checks whether a line of iptables-save declares the chain, appends a rule to it or jumps to it.
+// Only those lines are dropped along with the chains kube-router deletes, the rules of others merely mentioning the
+// chain, e.g. in a comment, are left alone.
+func ruleReferencesChain(rule, chain string) bool {
+	fields := strings.Fields(rule)
+	if len(fields) > 0 && fields[0] == ":"+chain {
+		return true
+	}
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "-A", "-j", "-g":
+			if fields[i+1] == chain {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newPodInfo returns the podInfo of the pod
+func newPodInfo(pod *api.Pod) podInfo {
+	ips := pod.Status.PodIPs
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = []api.PodIP{{IP: pod.Status.PodIP}}
+	}
+	return podInfo{ip: pod.Status.PodIP,
+		ips:       ips,
+		name:      pod.ObjectMeta.Name,
+		namespace: pod.ObjectMeta.Namespace,
+		labels:    pod.ObjectMeta.Labels}
+}
+
+// ipOfFamily returns the IP of the pod of the address family, or an empty string when the pod has none
+func (pod podInfo) ipOfFamily(ipFamily api.IPFamily) string {
+	ips := pod.ips
+	if len(ips) == 0 {
+		ips = []api.PodIP{{IP: pod.ip}}
+	}
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip.IP); parsed != nil && ipFamilyOfIP(parsed) == ipFamily {
+			return ip.IP
+		}
+	}
+	return ""
+}
+
+// ipFamilyOfIP returns the address family of the IP
+func ipFamilyOfIP(ip net.IP) api.IPFamily {
+	if ip.To4() != nil {
+		return api.IPv4Protocol
+	}
+	return api.IPv6Protocol
+}
+
+// isIPFamilyEnabled tells whether the network policies are enforced on the traffic of the address family
+func (npc *NetworkPolicyController) isIPFamilyEnabled(ipFamily api.IPFamily) bool {
+	for _, enabled := range npc.ipFamilies {
+		if enabled == ipFamily {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFamilyOfEntry returns the address family of the IP or CIDR of an ipset entry
+func ipFamilyOfEntry(entry string) api.IPFamily {
+	if ip, _, err := net.ParseCIDR(entry); err == nil {
+		return ipFamilyOfIP(ip)
+	}
+	if ip := net.ParseIP(entry); ip != nil {
+		return ipFamilyOfIP(ip)
+	}
+	return ""
+}
+
+// ipSetName returns the name of the ipset to match on in the iptables rules of the address family
+func ipSetName(setName string, ipFamily api.IPFamily) string {
+	if ipFamily == api.IPv6Protocol {
+		return "inet6:" + setName
+	}
+	return setName
+}
+
+// getIPsFromPods returns the IPs of the pods of all address families
 func getIPsFromPods(pods []podInfo) []string {
-	ips := make([]string, len(pods))
-	for idx, pod := range pods {
-		ips[idx] = pod.ip
+	ips := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		if len(pod.ips) == 0 {
+			ips = append(ips, pod.ip)
+			continue
+		}
+		for _, ip := range pod.ips {
+			ips = append(ips, ip.IP)
+		}
 	}
 	return ips
 }
 
-func (npc *NetworkPolicyController) createGenericHashIPSet(ipsetName, hashType string, ips []string) {
+// createGenericHashIPSet refreshes the ipset of the address family with the IPs of the family
+func (npc *NetworkPolicyController) createGenericHashIPSet(ipsetName, hashType string, ips []string,
+	ipFamily api.IPFamily) {
 	setEntries := make([][]string, 0)
 	for _, ip := range ips {
-		setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
+		if ipFamilyOfEntry(ip) == ipFamily {
+			setEntries = append(setEntries, []string{ip, utils.OptionTimeout, "0"})
+		}
 	}
-	npc.ipSetHandler.RefreshSet(ipsetName, setEntries, hashType)
+	npc.ipSetHandlers[ipFamily].RefreshSet(ipsetName, setEntries, hashType)
 }
 
 // createPolicyIndexedIPSet creates a policy based ipset and indexes it as an active ipset
 func (npc *NetworkPolicyController) createPolicyIndexedIPSet(
-	activePolicyIPSets map[string]bool, ipsetName, hashType string, ips []string) {
+	activePolicyIPSets map[string]bool, ipsetName, hashType string, ips []string, ipFamily api.IPFamily) {
 	activePolicyIPSets[ipsetName] = true
-	npc.createGenericHashIPSet(ipsetName, hashType, ips)
+	npc.createGenericHashIPSet(ipsetName, hashType, ips, ipFamily)
+}
+
+// refreshIPBlockIPSet refreshes the ipset of the address family with the ipBlock entries of the family
+func (npc *NetworkPolicyController) refreshIPBlockIPSet(ipsetName string, ipBlocks [][]string,
+	ipFamily api.IPFamily) {
+	setEntries := make([][]string, 0, len(ipBlocks))
+	for _, entry := range ipBlocks {
+		if ipFamilyOfEntry(entry[0]) == ipFamily {
+			setEntries = append(setEntries, entry)
+		}
+	}
+	npc.ipSetHandlers[ipFamily].RefreshSet(ipsetName, setEntries, utils.TypeHashNet)
 }
 
 // createPodWithPortPolicyRule handles the case where port details are provided by the ingress/egress rule and creates
 // an iptables rule that matches on both the source/dest IPs and the port
-func (npc *NetworkPolicyController) createPodWithPortPolicyRule(
-	ports []protocolAndPort, policy networkPolicyInfo, policyName string, srcSetName string, dstSetName string) error {
+func (npc *NetworkPolicyController) createPodWithPortPolicyRule(ports []protocolAndPort, policy networkPolicyInfo,
+	policyName string, srcSetName string, dstSetName string, ipFamily api.IPFamily) error {
 	for _, portProtocol := range ports {
 		comment := "rule to ACCEPT traffic from source pods to dest pods selected by policy name " +
 			policy.name + " namespace " + policy.namespace
-		if err := npc.appendRuleToPolicyChain(policyName, comment, srcSetName, dstSetName, portProtocol.protocol,
-			portProtocol.port, portProtocol.endport); err != nil {
+		if err := npc.appendRuleToPolicyChain(policy, policyName, comment, srcSetName, dstSetName, portProtocol.protocol,
+			portProtocol.port, portProtocol.endport, ipFamily); err != nil {
 			return err
 		}
 	}
@@ -160,3 +160,47 @@ func Test_NewNetworkPolicyController(t *testing.T) {
 		assert.Empty(t, portRange)
 	})
 }
+
+func Test_ruleReferencesChain(t *testing.T) {
+	const chain = "KUBE-POD-FW-ABCDEFGHIJKLMNOP"
+	t.Run("Chain declaration should reference the chain", func(t *testing.T) {
+		assert.True(t, ruleReferencesChain(":"+chain+" - [0:0]", chain))
+	})
+	t.Run("Rule in the chain should reference the chain", func(t *testing.T) {
+		assert.True(t, ruleReferencesChain("-A "+chain+" -j ACCEPT", chain))
+	})
+	t.Run("Jump to the chain should reference the chain", func(t *testing.T) {
+		assert.True(t, ruleReferencesChain("-A KUBE-ROUTER-FORWARD -d 10.1.0.5/32 -j "+chain, chain))
+	})
+	t.Run("Rule mentioning the chain in its comment should not reference the chain", func(t *testing.T) {
+		assert.False(t, ruleReferencesChain(`-A FORWARD -m comment --comment "before `+chain+`" -j ACCEPT`, chain))
+	})
+	t.Run("Chain with the chain name as prefix should not reference the chain", func(t *testing.T) {
+		assert.False(t, ruleReferencesChain("-A "+chain+"Q -j ACCEPT", chain))
+	})
+}
+
+func Test_podInfoIPOfFamily(t *testing.T) {
+	t.Run("Dual-stack pods have an IP of both families", func(t *testing.T) {
+		pod := fakePod.DeepCopy()
+		pod.Status.PodIP = "172.16.0.1"
+		pod.Status.PodIPs = []api.PodIP{{IP: "172.16.0.1"}, {IP: "2001:db8::1"}}
+		info := newPodInfo(pod)
+		assert.Equal(t, "172.16.0.1", info.ipOfFamily(api.IPv4Protocol))
+		assert.Equal(t, "2001:db8::1", info.ipOfFamily(api.IPv6Protocol))
+	})
+	t.Run("Pods without PodIPs fall back to their PodIP", func(t *testing.T) {
+		pod := fakePod.DeepCopy()
+		pod.Status.PodIP = "172.16.0.1"
+		pod.Status.PodIPs = nil
+		info := newPodInfo(pod)
+		assert.Equal(t, "172.16.0.1", info.ipOfFamily(api.IPv4Protocol))
+		assert.Empty(t, info.ipOfFamily(api.IPv6Protocol))
+	})
+}
+
+func Test_splitDefaultRoute(t *testing.T) {
+	assert.Equal(t, []string{"10.0.0.0/8"}, splitDefaultRoute("10.0.0.0/8"))
+	assert.Equal(t, []string{"0.0.0.0/1", "128.0.0.0/1"}, splitDefaultRoute("0.0.0.0/0"))
+	assert.Equal(t, []string{"::/1", "8000::/1"}, splitDefaultRoute("::/0"))
+}
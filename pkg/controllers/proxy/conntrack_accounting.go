@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const conntrackAccountingEnable = 1
+
+// conntrackMetrics are the metrics of the conntrack flows of each service and pod
+var conntrackMetrics = []*prometheus.GaugeVec{
+	metrics.ServiceConntrackFlows,
+	metrics.ServiceConntrackLongLivedFlows,
+	metrics.ServiceConntrackLongLivedBytes,
+	metrics.ServiceConntrackOldestFlowAge,
+	metrics.PodConntrackFlows,
+	metrics.PodConntrackLongLivedFlows,
+	metrics.PodConntrackLongLivedBytes,
+	metrics.PodConntrackOldestFlowAge,
+}
+
+// ensureConntrackAccounting has conntrack count the bytes of the flows and record when they started, so that the
+// long-lived flows and the traffic they carry can be told apart
+func (nsc *NetworkServicesController) ensureConntrackAccounting() {
+	for _, path := range []string{utils.NetfilterConntrackAcct, utils.NetfilterConntrackTstamp} {
+		sysctlErr := nsc.sysctls.Ensure(utils.SysctlSetting{Path: path, Value: conntrackAccountingEnable,
+			Reason: "conntrack accounting"})
+		if sysctlErr != nil {
+			klog.Error(sysctlErr.Error())
+		}
+	}
+}
+
+// publishConntrackMetrics publishes the aggregates of the conntrack flows of the node per service and per pod. The
+// metrics are reset first so that the services and pods without flows anymore disappear.
+func (nsc *NetworkServicesController) publishConntrackMetrics() error {
+	start := time.Now()
+	defer func() {
+		klog.V(2).Infof("Publishing conntrack metrics took %v", time.Since(start))
+	}()
+
+	flows, err := utils.ListConntrackFlows()
+	if err != nil {
+		return err
+	}
+
+	services := make([]*api.Service, 0)
+	for _, obj := range nsc.svcLister.List() {
+		if svc, ok := obj.(*api.Service); ok {
+			services = append(services, svc)
+		}
+	}
+	pods := make([]*api.Pod, 0)
+	for _, obj := range nsc.podLister.List() {
+		if pod, ok := obj.(*api.Pod); ok {
+			pods = append(pods, pod)
+		}
+	}
+	serviceStats, podStats := utils.AggregateConntrackFlows(flows,
+		utils.ConntrackServiceFrontends(services, nsc.nodeIP), utils.ConntrackPodIPs(pods),
+		nsc.longLivedFlowAge, start)
+
+	for _, metric := range conntrackMetrics {
+		metric.Reset()
+	}
+	publish := func(stats map[string]*utils.ConntrackFlowStats,
+		flowsMetric, longLivedFlowsMetric, longLivedBytesMetric, oldestFlowAgeMetric *prometheus.GaugeVec) {
+		for name, stat := range stats {
+			namespace, name, found := strings.Cut(name, "/")
+			if !found {
+				continue
+			}
+			flowsMetric.WithLabelValues(namespace, name).Set(float64(stat.Flows))
+			longLivedFlowsMetric.WithLabelValues(namespace, name).Set(float64(stat.LongLivedFlows))
+			longLivedBytesMetric.WithLabelValues(namespace, name).Set(float64(stat.LongLivedBytes))
+			oldestFlowAgeMetric.WithLabelValues(namespace, name).Set(stat.OldestFlowAge.Seconds())
+		}
+	}
+	publish(serviceStats, metrics.ServiceConntrackFlows, metrics.ServiceConntrackLongLivedFlows,
+		metrics.ServiceConntrackLongLivedBytes, metrics.ServiceConntrackOldestFlowAge)
+	publish(podStats, metrics.PodConntrackFlows, metrics.PodConntrackLongLivedFlows,
+		metrics.PodConntrackLongLivedBytes, metrics.PodConntrackOldestFlowAge)
+	return nil
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/moby/ipvs"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// dsrEncapIPIP forwards the traffic of DSR services to the pods on other nodes in plain ipip packets
+	dsrEncapIPIP = "ipip"
+	// dsrEncapGUE wraps the ipip packets in Generic UDP Encapsulation, for the fabrics that drop the ipip protocol
+	dsrEncapGUE = "gue"
+
+	// the parts of the generic netlink API of IPVS that moby/ipvs leaves out, which only knows of ipip tunnels
+	ipvsGenlName           = "IPVS"
+	ipvsGenlVersion        = 1
+	ipvsCmdNewDest         = 5
+	ipvsCmdSetDest         = 6
+	ipvsCmdAttrService     = 1
+	ipvsCmdAttrDest        = 2
+	ipvsSvcAttrAF          = 1
+	ipvsSvcAttrProtocol    = 2
+	ipvsSvcAttrAddr        = 3
+	ipvsSvcAttrPort        = 4
+	ipvsSvcAttrFWMark      = 5
+	ipvsDestAttrAddr       = 1
+	ipvsDestAttrPort       = 2
+	ipvsDestAttrFwdMethod  = 3
+	ipvsDestAttrWeight     = 4
+	ipvsDestAttrUThresh    = 5
+	ipvsDestAttrLThresh    = 6
+	ipvsDestAttrAddrFamily = 11
+	ipvsDestAttrTunType    = 13
+	ipvsDestAttrTunPort    = 14
+	ipvsTunnelTypeGUE      = 1
+)
+
+// parseDSREncapsulation returns the encapsulation of the traffic of a DSR service given by its annotation, or the
+// default one when the annotation is not set or not valid
+func parseDSREncapsulation(namespace, name, annotation, defaultEncap string) string {
+	switch annotation {
+	case dsrEncapIPIP, dsrEncapGUE:
+		return annotation
+	case "":
+	default:
+		klog.Warningf("Ignoring the unknown DSR encapsulation %q of service %s/%s", annotation, namespace, name)
+	}
+	return defaultEncap
+}
+
+// dsrGUEPort returns the port the pods of the service receive the GUE encapsulated traffic on, 0 when the traffic of
+// the service is forwarded in plain ipip packets
+func (nsc *NetworkServicesController) dsrGUEPort(svc *serviceInfo) uint16 {
+	if svc.dsrEncapsulation != dsrEncapGUE {
+		return 0
+	}
+	return nsc.guePort
+}
+
+// ipvsAddGUEServer adds the destination to the IPVS service, or updates it, forwarding the traffic to it through a GUE
+// tunnel to the port. Updating the destination with moby/ipvs would turn it back into an ipip tunnel, which is the
+// only tunnel type it knows, so such destinations are set up through the generic netlink API of IPVS directly.
+func ipvsAddGUEServer(svc *ipvs.Service, dst *ipvs.Destination, port uint16) error {
+	family, err := netlink.GenlFamilyGet(ipvsGenlName)
+	if err != nil {
+		return fmt.Errorf("failed to get the IPVS generic netlink family: %v", err)
+	}
+	err = executeIpvsDestinationRequest(int(family.ID), ipvsCmdNewDest, svc, dst, port)
+	if errors.Is(err, syscall.EEXIST) {
+		err = executeIpvsDestinationRequest(int(family.ID), ipvsCmdSetDest, svc, dst, port)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set up GUE destination %s of the ipvs service %s due to: %v",
+			ipvsDestinationString(dst), ipvsServiceString(svc), err)
+	}
+	klog.V(2).Infof("Successfully set up GUE destination %s of the service %s", ipvsDestinationString(dst),
+		ipvsServiceString(svc))
+	return nil
+}
+
+func executeIpvsDestinationRequest(familyID int, cmd uint8, svc *ipvs.Service, dst *ipvs.Destination,
+	port uint16) error {
+	req := nl.NewNetlinkRequest(familyID, syscall.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: cmd, Version: ipvsGenlVersion})
+	svcAttr, dstAttr := ipvsGUEDestinationAttrs(svc, dst, port)
+	req.AddData(svcAttr)
+	req.AddData(dstAttr)
+	_, err := req.Execute(syscall.NETLINK_GENERIC, 0)
+	return err
+}
+
+// ipvsGUEDestinationAttrs returns the netlink attributes identifying the IPVS service and describing its destination
+// with a GUE tunnel to the port
+func ipvsGUEDestinationAttrs(svc *ipvs.Service, dst *ipvs.Destination, port uint16) (*nl.RtAttr, *nl.RtAttr) {
+	svcAttr := nl.NewRtAttr(ipvsCmdAttrService, nil)
+	svcAttr.AddRtAttr(ipvsSvcAttrAF, nl.Uint16Attr(svc.AddressFamily))
+	if svc.FWMark != 0 {
+		svcAttr.AddRtAttr(ipvsSvcAttrFWMark, nl.Uint32Attr(svc.FWMark))
+	} else {
+		svcAttr.AddRtAttr(ipvsSvcAttrProtocol, nl.Uint16Attr(svc.Protocol))
+		svcAttr.AddRtAttr(ipvsSvcAttrAddr, ipvsAddrAttr(svc.Address))
+		svcAttr.AddRtAttr(ipvsSvcAttrPort, bigEndianUint16(svc.Port))
+	}
+
+	dstAttr := nl.NewRtAttr(ipvsCmdAttrDest, nil)
+	dstAttr.AddRtAttr(ipvsDestAttrAddr, ipvsAddrAttr(dst.Address))
+	dstAttr.AddRtAttr(ipvsDestAttrPort, bigEndianUint16(dst.Port))
+	dstAttr.AddRtAttr(ipvsDestAttrFwdMethod, nl.Uint32Attr(ipvs.ConnectionFlagTunnel))
+	dstAttr.AddRtAttr(ipvsDestAttrWeight, nl.Uint32Attr(uint32(dst.Weight)))
+	dstAttr.AddRtAttr(ipvsDestAttrUThresh, nl.Uint32Attr(dst.UpperThreshold))
+	dstAttr.AddRtAttr(ipvsDestAttrLThresh, nl.Uint32Attr(dst.LowerThreshold))
+	dstAttr.AddRtAttr(ipvsDestAttrAddrFamily, nl.Uint16Attr(dst.AddressFamily))
+	dstAttr.AddRtAttr(ipvsDestAttrTunType, nl.Uint8Attr(ipvsTunnelTypeGUE))
+	dstAttr.AddRtAttr(ipvsDestAttrTunPort, bigEndianUint16(port))
+	return svcAttr, dstAttr
+}
+
+// ipvsAddrAttr returns the address as IPVS expects it, the IPv4 addresses in their 4 byte form
+func ipvsAddrAttr(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+func bigEndianUint16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
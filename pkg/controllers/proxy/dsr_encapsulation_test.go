@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/moby/ipvs"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink/nl"
+)
+
+func Test_parseDSREncapsulation(t *testing.T) {
+	assert.Equal(t, dsrEncapGUE, parseDSREncapsulation("default", "web", "gue", dsrEncapIPIP))
+	assert.Equal(t, dsrEncapIPIP, parseDSREncapsulation("default", "web", "ipip", dsrEncapGUE))
+	assert.Equal(t, dsrEncapGUE, parseDSREncapsulation("default", "web", "", dsrEncapGUE),
+		"expected the default encapsulation without the annotation")
+	assert.Equal(t, dsrEncapIPIP, parseDSREncapsulation("default", "web", "vxlan", dsrEncapIPIP),
+		"expected the default encapsulation for an unknown one")
+}
+
+func Test_ipvsGUEDestinationAttrs(t *testing.T) {
+	svc := &ipvs.Service{AddressFamily: syscall.AF_INET, FWMark: 3000}
+	dst := &ipvs.Destination{Address: net.ParseIP("10.1.2.3"), AddressFamily: syscall.AF_INET, Port: 8080,
+		Weight: 1}
+	svcAttr, dstAttr := ipvsGUEDestinationAttrs(svc, dst, 6080)
+
+	svcAttrs, err := nl.ParseRouteAttr(svcAttr.Serialize()[syscall.SizeofRtAttr:])
+	assert.NoError(t, err)
+	svcValues := make(map[uint16][]byte)
+	for _, attr := range svcAttrs {
+		svcValues[attr.Attr.Type] = attr.Value
+	}
+	assert.Equal(t, nl.Uint32Attr(3000), svcValues[ipvsSvcAttrFWMark], "expected the service by its FW mark")
+	assert.NotContains(t, svcValues, uint16(ipvsSvcAttrAddr))
+
+	dstAttrs, err := nl.ParseRouteAttr(dstAttr.Serialize()[syscall.SizeofRtAttr:])
+	assert.NoError(t, err)
+	dstValues := make(map[uint16][]byte)
+	for _, attr := range dstAttrs {
+		dstValues[attr.Attr.Type] = attr.Value
+	}
+	assert.Equal(t, []byte{10, 1, 2, 3}, dstValues[ipvsDestAttrAddr])
+	assert.Equal(t, []byte{0x1f, 0x90}, dstValues[ipvsDestAttrPort], "expected the port in network byte order")
+	assert.Equal(t, nl.Uint32Attr(ipvs.ConnectionFlagTunnel), dstValues[ipvsDestAttrFwdMethod])
+	assert.Equal(t, []byte{ipvsTunnelTypeGUE}, dstValues[ipvsDestAttrTunType])
+	assert.Equal(t, []byte{0x17, 0xc0}, dstValues[ipvsDestAttrTunPort], "expected the GUE port in network byte order")
+}
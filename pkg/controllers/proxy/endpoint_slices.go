@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	api "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// EnableTerminatingEndpoints makes the services fall back to their terminating endpoints that are still serving when
+// they have no ready endpoints left, as kube-proxy does. The terminating endpoints are only published in the
+// EndpointSlices of the services, which the informer watches, EndpointSliceEventHandler is to be added to it.
+func (nsc *NetworkServicesController) EnableTerminatingEndpoints(epSliceInformer cache.SharedIndexInformer) {
+	nsc.terminatingEndpoints = true
+	nsc.watchEndpointSlices(epSliceInformer)
+}
+
+// EnableTopologyAwareRouting makes the services prefer the endpoints in the zone of the node, following the topology
+// hints that the EndpointSlice controller publishes for the services with the service.kubernetes.io/topology-mode
+// annotation or the PreferClose traffic distribution. The endpoints of all zones are used as long as the hints don't
+// give the zone of the node a ready endpoint. The informer watches the EndpointSlices, EndpointSliceEventHandler is to
+// be added to it.
+func (nsc *NetworkServicesController) EnableTopologyAwareRouting(epSliceInformer cache.SharedIndexInformer) {
+	nsc.topologyAwareRouting = true
+	nsc.watchEndpointSlices(epSliceInformer)
+}
+
+func (nsc *NetworkServicesController) watchEndpointSlices(epSliceInformer cache.SharedIndexInformer) {
+	if nsc.epSliceLister != nil {
+		return
+	}
+	nsc.epSliceLister = epSliceInformer.GetIndexer()
+	nsc.EndpointSliceEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nsc.handleEndpointSliceUpdate(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nsc.handleEndpointSliceUpdate(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			nsc.handleEndpointSliceUpdate(obj)
+		},
+	}
+}
+
+// handleEndpointSliceUpdate processes the Endpoints of the service of the EndpointSlice again, the terminating
+// endpoints and the topology hints change in the EndpointSlices without a change of the Endpoints
+func (nsc *NetworkServicesController) handleEndpointSliceUpdate(obj interface{}) {
+	slice, ok := obj.(*discovery.EndpointSlice)
+	if !ok {
+		klog.Errorf("unexpected object type: %v", obj)
+		return
+	}
+	svcName, ok := slice.Labels[discovery.LabelServiceName]
+	if !ok {
+		return
+	}
+	epObj, exists, err := nsc.epLister.GetByKey(slice.Namespace + "/" + svcName)
+	if err != nil || !exists {
+		return
+	}
+	nsc.OnEndpointsUpdate(epObj.(*api.Endpoints))
+}
+
+// addEndpointSliceInfo adds what only the EndpointSlices publish to the endpoints of their services: the endpoints
+// that are terminating but still serving, as the Endpoints leave the terminating pods out, their services not even
+// having the ports anymore once all their pods are terminating, and the topology hints of the endpoints.
+func (nsc *NetworkServicesController) addEndpointSliceInfo(endpointsMap endpointsInfoMap) {
+	if nsc.epSliceLister == nil {
+		return
+	}
+	zone := nsc.nodeLabels[api.LabelTopologyZone]
+	for _, obj := range nsc.epSliceLister.List() {
+		slice := obj.(*discovery.EndpointSlice)
+		svcName, ok := slice.Labels[discovery.LabelServiceName]
+		if !ok {
+			continue
+		}
+		for _, port := range slice.Ports {
+			if port.Port == nil {
+				continue
+			}
+			portName := ""
+			if port.Name != nil {
+				portName = *port.Name
+			}
+			svcID := generateServiceID(slice.Namespace, svcName, portName)
+			for _, ep := range slice.Endpoints {
+				terminating := isTerminatingServing(ep)
+				zoneHinted, inZone := false, false
+				if nsc.topologyAwareRouting && ep.Hints != nil && len(ep.Hints.ForZones) > 0 {
+					zoneHinted, inZone = true, hintsForZone(ep.Hints, zone)
+				}
+				isLocal := ep.NodeName != nil && *ep.NodeName == nsc.nodeHostName
+				for _, addr := range ep.Addresses {
+					i := endpointIndex(endpointsMap[svcID], addr)
+					switch {
+					case i >= 0:
+						endpointsMap[svcID][i].zoneHinted, endpointsMap[svcID][i].inZone = zoneHinted, inZone
+					case terminating && nsc.terminatingEndpoints:
+						endpointsMap[svcID] = append(endpointsMap[svcID], endpointsInfo{ip: addr, port: int(*port.Port),
+							isLocal: isLocal, terminating: true, zoneHinted: zoneHinted, inZone: inZone})
+					}
+				}
+			}
+		}
+	}
+}
+
+// endpointIndex returns the index of the endpoint with the address, or -1
+func endpointIndex(endpoints []endpointsInfo, ip string) int {
+	for i, endpoint := range endpoints {
+		if endpoint.ip == ip {
+			return i
+		}
+	}
+	return -1
+}
+
+// hintsForZone returns whether the hints give the endpoint to the zone
+func hintsForZone(hints *discovery.EndpointHints, zone string) bool {
+	if zone == "" {
+		return false
+	}
+	for _, forZone := range hints.ForZones {
+		if forZone.Name == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// isTerminatingServing returns whether the endpoint is terminating but still serving, the terminating endpoints that
+// aren't serving anymore, for failing their readiness probe, are left out like the endpoints that aren't ready
+func isTerminatingServing(ep discovery.Endpoint) bool {
+	return ep.Conditions.Terminating != nil && *ep.Conditions.Terminating &&
+		ep.Conditions.Serving != nil && *ep.Conditions.Serving
+}
+
+// topologyEndpoints returns the endpoints of the service that the traffic goes to: those the topology hints give to
+// the zone of the node when all the ready endpoints have hints and at least one of them is in the zone, all of them
+// otherwise. The services with a Local traffic policy already use the endpoints of the node and ignore the hints, as
+// kube-proxy does.
+func topologyEndpoints(svc *serviceInfo, endpoints []endpointsInfo) []endpointsInfo {
+	if svc.local {
+		return endpoints
+	}
+	readyInZone := false
+	for _, endpoint := range endpoints {
+		if endpoint.terminating {
+			continue
+		}
+		if !endpoint.zoneHinted {
+			return endpoints
+		}
+		readyInZone = readyInZone || endpoint.inZone
+	}
+	if !readyInZone {
+		return endpoints
+	}
+	zoneEndpoints := make([]endpointsInfo, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if endpoint.inZone {
+			zoneEndpoints = append(zoneEndpoints, endpoint)
+		}
+	}
+	return zoneEndpoints
+}
+
+// endpointWeight returns the IPVS weight of the endpoint of a service, 0 to drain the terminating endpoints as long
+// as the service has ready endpoints, the ones on this node when only the local endpoints are used. The endpoints of
+// the listeners of the Gateways have the weight of their share of their backend.
+func endpointWeight(endpoint endpointsInfo, endpoints []endpointsInfo, local bool) int {
+	weight := 1
+	if endpoint.weight > 0 {
+		weight = endpoint.weight
+	}
+	if !endpoint.terminating {
+		return weight
+	}
+	for _, ep := range endpoints {
+		if !ep.terminating && (!local || ep.isLocal) {
+			return 0
+		}
+	}
+	return weight
+}
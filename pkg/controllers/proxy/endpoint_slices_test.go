@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+func Test_buildEndpointsInfoTerminating(t *testing.T) {
+	epLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, epLister.Add(&v1core.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Subsets: []v1core.EndpointSubset{{Addresses: []v1core.EndpointAddress{{IP: "10.1.0.1"}},
+			Ports: []v1core.EndpointPort{{Name: "http", Port: 8080}}}}}))
+
+	endpoint := func(ip string, ready, serving, terminating bool, node string) discovery.Endpoint {
+		return discovery.Endpoint{Addresses: []string{ip}, NodeName: pointer.String(node),
+			Conditions: discovery.EndpointConditions{Ready: pointer.Bool(ready), Serving: pointer.Bool(serving),
+				Terminating: pointer.Bool(terminating)}}
+	}
+	slice := func(name, svcName string, endpoints ...discovery.Endpoint) *discovery.EndpointSlice {
+		return &discovery.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+			Labels: map[string]string{discovery.LabelServiceName: svcName}},
+			Ports:     []discovery.EndpointPort{{Name: pointer.String("http"), Port: pointer.Int32(8080)}},
+			Endpoints: endpoints}
+	}
+	epSliceLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, epSliceLister.Add(slice("web-abc", "web", endpoint("10.1.0.1", true, true, false, "node-a"),
+		endpoint("10.1.0.2", false, true, true, "node-b"), endpoint("10.1.0.3", false, false, true, "node-b"))))
+	assert.NoError(t, epSliceLister.Add(slice("db-abc", "db", endpoint("10.1.0.4", false, true, true, "node-a"))))
+
+	web, db := generateServiceID("default", "web", "http"), generateServiceID("default", "db", "http")
+	nsc := &NetworkServicesController{epLister: epLister, nodeHostName: "node-a"}
+	assert.Equal(t, endpointsInfoMap{web: {{ip: "10.1.0.1", port: 8080}}}, nsc.buildEndpointsInfo(),
+		"expected no terminating endpoints without the EndpointSlices")
+
+	nsc.epSliceLister, nsc.terminatingEndpoints = epSliceLister, true
+	assert.Equal(t, endpointsInfoMap{
+		web: {{ip: "10.1.0.1", port: 8080}, {ip: "10.1.0.2", port: 8080, terminating: true}},
+		db:  {{ip: "10.1.0.4", port: 8080, isLocal: true, terminating: true}},
+	}, nsc.buildEndpointsInfo(), "expected the terminating endpoints that are still serving, even of the services "+
+		"whose Endpoints have none left")
+}
+
+func Test_endpointWeight(t *testing.T) {
+	ready := endpointsInfo{ip: "10.1.0.1"}
+	terminating := endpointsInfo{ip: "10.1.0.2", isLocal: true, terminating: true}
+	endpoints := []endpointsInfo{ready, terminating}
+
+	assert.Equal(t, 1, endpointWeight(ready, endpoints, false))
+	assert.Equal(t, 0, endpointWeight(terminating, endpoints, false),
+		"expected the terminating endpoint to be drained while there are ready endpoints")
+	assert.Equal(t, 1, endpointWeight(terminating, endpoints, true),
+		"expected the terminating endpoint to be used without ready local endpoints")
+	assert.Equal(t, 1, endpointWeight(terminating, []endpointsInfo{terminating}, false),
+		"expected the terminating endpoint to be used without ready endpoints")
+}
+
+func Test_buildEndpointsInfoTopologyHints(t *testing.T) {
+	epLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, epLister.Add(&v1core.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Subsets: []v1core.EndpointSubset{{Addresses: []v1core.EndpointAddress{{IP: "10.1.0.1"}, {IP: "10.1.0.2"},
+			{IP: "10.1.0.3"}}, Ports: []v1core.EndpointPort{{Port: 8080}}}}}))
+	hinted := func(ip string, zones ...string) discovery.Endpoint {
+		endpoint := discovery.Endpoint{Addresses: []string{ip}, Conditions: discovery.EndpointConditions{
+			Ready: pointer.Bool(true)}}
+		if len(zones) > 0 {
+			endpoint.Hints = &discovery.EndpointHints{}
+			for _, zone := range zones {
+				endpoint.Hints.ForZones = append(endpoint.Hints.ForZones, discovery.ForZone{Name: zone})
+			}
+		}
+		return endpoint
+	}
+	epSliceLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, epSliceLister.Add(&discovery.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "web-abc",
+		Namespace: "default", Labels: map[string]string{discovery.LabelServiceName: "web"}},
+		Ports: []discovery.EndpointPort{{Port: pointer.Int32(8080)}}, Endpoints: []discovery.Endpoint{
+			hinted("10.1.0.1", "zone-a"), hinted("10.1.0.2", "zone-b", "zone-c"), hinted("10.1.0.3")}}))
+
+	nsc := &NetworkServicesController{epLister: epLister, epSliceLister: epSliceLister, topologyAwareRouting: true,
+		nodeLabels: map[string]string{v1core.LabelTopologyZone: "zone-b"}}
+	assert.ElementsMatch(t, []endpointsInfo{{ip: "10.1.0.1", port: 8080, zoneHinted: true},
+		{ip: "10.1.0.2", port: 8080, zoneHinted: true, inZone: true}, {ip: "10.1.0.3", port: 8080}},
+		nsc.buildEndpointsInfo()[generateServiceID("default", "web", "")])
+}
+
+func Test_topologyEndpoints(t *testing.T) {
+	zoneA := endpointsInfo{ip: "10.1.0.1", zoneHinted: true}
+	zoneB := endpointsInfo{ip: "10.1.0.2", zoneHinted: true, inZone: true}
+	unhinted := endpointsInfo{ip: "10.1.0.3"}
+	cluster, local := &serviceInfo{}, &serviceInfo{local: true}
+
+	assert.Equal(t, []endpointsInfo{zoneB}, topologyEndpoints(cluster, []endpointsInfo{zoneA, zoneB}),
+		"expected the endpoints in the zone of the node")
+	assert.Equal(t, []endpointsInfo{zoneA, zoneB}, topologyEndpoints(local, []endpointsInfo{zoneA, zoneB}),
+		"expected a local service to ignore the hints")
+	assert.Equal(t, []endpointsInfo{zoneA, zoneB, unhinted},
+		topologyEndpoints(cluster, []endpointsInfo{zoneA, zoneB, unhinted}),
+		"expected all the endpoints when one of them has no hints")
+	assert.Equal(t, []endpointsInfo{zoneA}, topologyEndpoints(cluster, []endpointsInfo{zoneA}),
+		"expected all the endpoints when none is in the zone of the node")
+	terminatingB := endpointsInfo{ip: "10.1.0.4", zoneHinted: true, inZone: true, terminating: true}
+	assert.Equal(t, []endpointsInfo{zoneA, terminatingB}, topologyEndpoints(cluster, []endpointsInfo{zoneA,
+		terminatingB}), "expected all the endpoints when the zone of the node only has terminating ones")
+}
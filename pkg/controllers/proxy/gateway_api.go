@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/moby/ipvs"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// gatewayServicePrefix prefixes the Gateway names in the IDs of the services of their listeners, as it isn't
+	// valid in the names of the Services the IDs can't collide with theirs
+	gatewayServicePrefix = "gateway:"
+	// gatewayWeightScale is the IPVS weight of the endpoints of a backend of weight 1 with a single endpoint, the
+	// weight of a backend is split between its endpoints
+	gatewayWeightScale = 100
+	// maxGatewayEndpointWeight caps the IPVS weight of the endpoints of the backends, which the slow start raises
+	// further
+	maxGatewayEndpointWeight = 10000
+)
+
+// gatewayBackend is a port of a Service that a listener forwards to, by the ID of the port in the endpoints map
+type gatewayBackend struct {
+	svcID  string
+	weight int
+}
+
+// gatewayListener is a TCP or UDP listener of a Gateway, served like a LoadBalancer service on the addresses of the
+// Gateway, forwarding to the backends of the route attached to it
+type gatewayListener struct {
+	svcID    string
+	svc      *serviceInfo
+	backends []gatewayBackend
+}
+
+// EnableGatewayAPI makes the proxy serve the TCP and UDP listeners of the Gateways of the GatewayClasses whose
+// controllerName is utils.GatewayControllerName on the addresses of the Gateways, forwarding the traffic to the
+// backends of the TCPRoutes and UDPRoutes attached to them. The informers watch the GatewayClasses, the Gateways, the
+// TCPRoutes and the UDPRoutes, GatewayEventHandler is to be added to all of them.
+func (nsc *NetworkServicesController) EnableGatewayAPI(gatewayClassInformer, gatewayInformer, tcpRouteInformer,
+	udpRouteInformer cache.SharedIndexInformer) {
+	nsc.gatewayClassLister = gatewayClassInformer.GetIndexer()
+	nsc.gatewayLister = gatewayInformer.GetIndexer()
+	nsc.tcpRouteLister = tcpRouteInformer.GetIndexer()
+	nsc.udpRouteLister = udpRouteInformer.GetIndexer()
+	nsc.GatewayEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { nsc.OnGatewayUpdate() },
+		UpdateFunc: func(interface{}, interface{}) { nsc.OnGatewayUpdate() },
+		DeleteFunc: func(interface{}) { nsc.OnGatewayUpdate() },
+	}
+}
+
+// OnGatewayUpdate handles the changes of the GatewayClasses, Gateways and routes, which change the services of the
+// listeners and their endpoints
+func (nsc *NetworkServicesController) OnGatewayUpdate() {
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+
+	if !nsc.readyForUpdates {
+		klog.V(3).Info("Skipping update to the Gateway API resources as controller is not ready to process updates")
+		return
+	}
+
+	newServiceMap := nsc.buildServicesInfo()
+	newEndpointsMap := nsc.buildEndpointsInfo()
+
+	if !reflect.DeepEqual(newServiceMap, nsc.serviceMap) ||
+		!endpointsMapsEquivalent(newEndpointsMap, nsc.endpointsMap) {
+		nsc.endpointsMap = newEndpointsMap
+		nsc.serviceMap = newServiceMap
+		klog.V(1).Info("Syncing IPVS services on update to the Gateway API resources")
+		nsc.sync(synctypeIpvs)
+	} else {
+		klog.V(1).Info("Skipping syncing IPVS services for update to the Gateway API resources as nothing changed")
+	}
+}
+
+// addGatewayServiceInfo adds the services of the listeners of the Gateways to the services map
+func (nsc *NetworkServicesController) addGatewayServiceInfo(serviceMap serviceInfoMap) {
+	for _, listener := range nsc.gatewayListeners() {
+		serviceMap[listener.svcID] = listener.svc
+	}
+}
+
+// addGatewayEndpointsInfo adds the endpoints of the listeners of the Gateways to the endpoints map, the endpoints of
+// their backends weighted by the weights of the backends
+func (nsc *NetworkServicesController) addGatewayEndpointsInfo(endpointsMap endpointsInfoMap) {
+	for _, listener := range nsc.gatewayListeners() {
+		endpointsMap[listener.svcID] = gatewayEndpoints(listener.backends, endpointsMap)
+	}
+}
+
+// gatewayEndpoints returns the endpoints of the backends, the weight of each backend split between its endpoints. The
+// weights of the endpoints shared by several backends add up.
+func gatewayEndpoints(backends []gatewayBackend, endpointsMap endpointsInfoMap) []endpointsInfo {
+	endpoints := make([]endpointsInfo, 0)
+	index := make(map[string]int)
+	for _, backend := range backends {
+		backendEndpoints := endpointsMap[backend.svcID]
+		if len(backendEndpoints) == 0 {
+			continue
+		}
+		weight := backend.weight * gatewayWeightScale / len(backendEndpoints)
+		for _, endpoint := range backendEndpoints {
+			id := generateEndpointID(endpoint.ip, strconv.Itoa(endpoint.port))
+			if i, ok := index[id]; ok {
+				endpoints[i].weight = clampGatewayWeight(endpoints[i].weight + weight)
+				continue
+			}
+			endpoint.weight = clampGatewayWeight(weight)
+			index[id] = len(endpoints)
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+func clampGatewayWeight(weight int) int {
+	switch {
+	case weight < 1:
+		return 1
+	case weight > maxGatewayEndpointWeight:
+		return maxGatewayEndpointWeight
+	}
+	return weight
+}
+
+// gatewayListeners returns the TCP and UDP listeners of the Gateways of kube-router that have addresses, with the
+// backends of the oldest route attached to each of them
+func (nsc *NetworkServicesController) gatewayListeners() []gatewayListener {
+	if nsc.gatewayLister == nil {
+		return nil
+	}
+	gateways := utils.ManagedGateways(nsc.gatewayLister, nsc.gatewayClassLister)
+	// the routes that can't be attached are reported in their status by the load balancer controller
+	attached := utils.AttachGatewayRoutes(gateways, utils.GatewayRoutes(nsc.tcpRouteLister, nsc.udpRouteLister))
+	listeners := make([]gatewayListener, 0)
+	for _, gateway := range gateways {
+		addresses := make([]string, 0)
+		for _, address := range utils.GatewayAddresses(gateway) {
+			// only IPv4 services are proxied through IPVS
+			if net.ParseIP(address).To4() == nil {
+				continue
+			}
+			addresses = append(addresses, address)
+		}
+		if len(addresses) == 0 {
+			klog.V(2).Infof("Skipping Gateway %s/%s as it has no IPv4 address yet", gateway.Namespace, gateway.Name)
+			continue
+		}
+		for _, listener := range gateway.Spec.Listeners {
+			if listener.Protocol != gatewayv1.TCPProtocolType && listener.Protocol != gatewayv1.UDPProtocolType {
+				continue
+			}
+			gl := gatewayListener{
+				svcID: generateServiceID(gateway.Namespace, gatewayServicePrefix+gateway.Name, listener.Name),
+				svc: &serviceInfo{
+					name:            gateway.Name,
+					namespace:       gateway.Namespace,
+					port:            int(listener.Port),
+					protocol:        strings.ToLower(listener.Protocol),
+					scheduler:       ipvs.WeightedRoundRobin,
+					externalIPs:     make([]string, 0),
+					loadBalancerIPs: addresses,
+				},
+			}
+			if route, ok := attached[utils.GatewayListenerKey(gateway, listener)]; ok {
+				gl.backends = nsc.routeBackends(route)
+			}
+			listeners = append(listeners, gl)
+		}
+	}
+	return listeners
+}
+
+// routeBackends returns the Service ports the rules of the route forward to. The backends of weight 0, or that can't
+// be resolved to a port of a Service of the namespace and protocol of the route are left out.
+func (nsc *NetworkServicesController) routeBackends(route *utils.GatewayRoute) []gatewayBackend {
+	backends := make([]gatewayBackend, 0)
+	for _, rule := range route.Spec.Rules {
+		for _, ref := range rule.BackendRefs {
+			weight := 1
+			if ref.Weight != nil {
+				weight = int(*ref.Weight)
+			}
+			if weight <= 0 {
+				continue
+			}
+			svc, portName, err := utils.ResolveGatewayBackend(route, ref, nsc.svcLister)
+			if err != nil {
+				klog.V(1).Infof("Skipping backend of %s %s/%s: %v", route.Kind, route.Namespace, route.Name, err)
+				continue
+			}
+			backends = append(backends, gatewayBackend{
+				svcID:  generateServiceID(svc.Namespace, svc.Name, portName),
+				weight: weight,
+			})
+		}
+	}
+	return backends
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	gatewayv1alpha2 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1alpha2"
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/moby/ipvs"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+func tGatewayIndexer(t *testing.T, objs ...interface{}) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, obj := range objs {
+		u, err := v1alpha1.ToUnstructured(obj)
+		if err != nil {
+			t.Fatalf("failed to convert %v: %v", obj, err)
+		}
+		assert.NoError(t, indexer.Add(u))
+	}
+	return indexer
+}
+
+func tBackendRef(name string, port, weight int32) gatewayv1alpha2.BackendRef {
+	return gatewayv1alpha2.BackendRef{Name: name, Port: pointer.Int32(port), Weight: pointer.Int32(weight)}
+}
+
+func Test_buildServicesInfoGateways(t *testing.T) {
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	epLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range []struct {
+		name string
+		ips  []string
+	}{{"app", []string{"10.1.0.1", "10.1.0.2"}}, {"canary", []string{"10.1.0.3"}}} {
+		assert.NoError(t, svcLister.Add(&v1core.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: svc.name},
+			Spec: v1core.ServiceSpec{ClusterIP: "10.96.0.10", Ports: []v1core.ServicePort{
+				{Name: "http", Port: 80, Protocol: v1core.ProtocolTCP},
+				{Name: "dns", Port: 53, Protocol: v1core.ProtocolUDP},
+			}},
+		}))
+		addresses := make([]v1core.EndpointAddress, 0, len(svc.ips))
+		for _, ip := range svc.ips {
+			addresses = append(addresses, v1core.EndpointAddress{IP: ip})
+		}
+		assert.NoError(t, epLister.Add(&v1core.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: svc.name},
+			Subsets: []v1core.EndpointSubset{{Addresses: addresses, Ports: []v1core.EndpointPort{
+				{Name: "http", Port: 8080, Protocol: v1core.ProtocolTCP},
+				{Name: "dns", Port: 5353, Protocol: v1core.ProtocolUDP},
+			}}},
+		}))
+	}
+
+	nsc := &NetworkServicesController{svcLister: svcLister, epLister: epLister}
+	nsc.gatewayClassLister = tGatewayIndexer(t, &gatewayv1.GatewayClass{ObjectMeta: metav1.ObjectMeta{Name: "l4"},
+		Spec: gatewayv1.GatewayClassSpec{ControllerName: utils.GatewayControllerName}})
+	nsc.gatewayLister = tGatewayIndexer(t,
+		&gatewayv1.Gateway{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "public"},
+			Spec: gatewayv1.GatewaySpec{GatewayClassName: "l4", Listeners: []gatewayv1.Listener{
+				{Name: "tcp", Port: 8000, Protocol: gatewayv1.TCPProtocolType},
+				{Name: "udp", Port: 53, Protocol: gatewayv1.UDPProtocolType},
+				{Name: "http", Port: 80, Protocol: "HTTP"},
+			}},
+			Status: gatewayv1.GatewayStatus{Addresses: []gatewayv1.GatewayAddress{{Value: "192.0.2.1"}}},
+		},
+		&gatewayv1.Gateway{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "pending"},
+			Spec: gatewayv1.GatewaySpec{GatewayClassName: "l4", Listeners: []gatewayv1.Listener{
+				{Name: "tcp", Port: 8000, Protocol: gatewayv1.TCPProtocolType},
+			}},
+		})
+	created := metav1.NewTime(time.Now())
+	parent := []gatewayv1alpha2.ParentReference{{Name: "public"}}
+	nsc.tcpRouteLister = tGatewayIndexer(t,
+		&gatewayv1alpha2.TCPRoute{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "app", CreationTimestamp: created},
+			Spec: gatewayv1alpha2.RouteSpec{ParentRefs: parent, Rules: []gatewayv1alpha2.RouteRule{{
+				BackendRefs: []gatewayv1alpha2.BackendRef{tBackendRef("app", 80, 3), tBackendRef("canary", 80, 1),
+					tBackendRef("missing", 80, 1), tBackendRef("app", 53, 1)},
+			}}},
+		},
+		&gatewayv1alpha2.TCPRoute{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "newer",
+				CreationTimestamp: metav1.NewTime(created.Add(time.Minute))},
+			Spec: gatewayv1alpha2.RouteSpec{ParentRefs: parent, Rules: []gatewayv1alpha2.RouteRule{{
+				BackendRefs: []gatewayv1alpha2.BackendRef{tBackendRef("canary", 80, 1)},
+			}}},
+		})
+	nsc.udpRouteLister = tGatewayIndexer(t, &gatewayv1alpha2.UDPRoute{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "dns"},
+		Spec: gatewayv1alpha2.RouteSpec{
+			ParentRefs: []gatewayv1alpha2.ParentReference{{Name: "public", SectionName: pointer.String("udp")}},
+			Rules: []gatewayv1alpha2.RouteRule{{BackendRefs: []gatewayv1alpha2.BackendRef{
+				tBackendRef("app", 53, 1), tBackendRef("canary", 53, 0)}}},
+		},
+	})
+
+	tcpID := generateServiceID("web", gatewayServicePrefix+"public", "tcp")
+	udpID := generateServiceID("web", gatewayServicePrefix+"public", "udp")
+	serviceMap := nsc.buildServicesInfo()
+	assert.Len(t, serviceMap, 6, "expected the ports of the services and the TCP and UDP listeners with an address")
+	if assert.Contains(t, serviceMap, tcpID) {
+		assert.Equal(t, &serviceInfo{name: "public", namespace: "web", port: 8000, protocol: tcpProtocol,
+			scheduler: ipvs.WeightedRoundRobin, externalIPs: []string{}, loadBalancerIPs: []string{"192.0.2.1"}},
+			serviceMap[tcpID])
+	}
+	assert.Contains(t, serviceMap, udpID)
+
+	endpointsMap := nsc.buildEndpointsInfo()
+	assert.ElementsMatch(t, []endpointsInfo{
+		{ip: "10.1.0.1", port: 8080, weight: 150},
+		{ip: "10.1.0.2", port: 8080, weight: 150},
+		{ip: "10.1.0.3", port: 8080, weight: 100},
+	}, endpointsMap[tcpID], "expected the weights of the backends of the oldest route split between their endpoints")
+	assert.ElementsMatch(t, []endpointsInfo{
+		{ip: "10.1.0.1", port: 5353, weight: 50},
+		{ip: "10.1.0.2", port: 5353, weight: 50},
+	}, endpointsMap[udpID], "expected the backends of weight 0 to be left out")
+}
+
+func Test_endpointWeightGateway(t *testing.T) {
+	ready := endpointsInfo{ip: "10.1.0.1", weight: 150}
+	terminating := endpointsInfo{ip: "10.1.0.2", terminating: true, weight: 50}
+
+	assert.Equal(t, 150, endpointWeight(ready, []endpointsInfo{ready, terminating}, false),
+		"expected the weight of the share of the backend")
+	assert.Equal(t, 0, endpointWeight(terminating, []endpointsInfo{ready, terminating}, false))
+	assert.Equal(t, 50, endpointWeight(terminating, []endpointsInfo{terminating}, false))
+}
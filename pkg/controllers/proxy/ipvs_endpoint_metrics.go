@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/moby/ipvs"
+	"github.com/prometheus/client_golang/prometheus"
+	api "k8s.io/api/core/v1"
+)
+
+// ipvsEndpointMetrics are the metrics of the IPVS destinations of each service, its endpoints
+var ipvsEndpointMetrics = []*prometheus.GaugeVec{
+	metrics.ServiceEndpointActiveConn,
+	metrics.ServiceEndpointInactiveConn,
+	metrics.ServiceEndpointTotalConn,
+	metrics.ServiceEndpointPacketsIn,
+	metrics.ServiceEndpointPacketsOut,
+	metrics.ServiceEndpointBytesIn,
+	metrics.ServiceEndpointBytesOut,
+}
+
+// podNamesByIP returns the names of the pods by their IPs, leaving out the pods of the host network which share the
+// IPs of their node
+func (nsc *NetworkServicesController) podNamesByIP() map[string]string {
+	podNames := make(map[string]string)
+	for _, obj := range nsc.podLister.List() {
+		pod := obj.(*api.Pod)
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			podNames[podIP.IP] = pod.Name
+		}
+	}
+	return podNames
+}
+
+// publishEndpointMetrics publishes the connections and the traffic of the IPVS destinations of the IPVS service,
+// labeled with the labels of the service, the address of the endpoint and the name of its pod
+func (nsc *NetworkServicesController) publishEndpointMetrics(ipvsSvc *ipvs.Service, svcLabelValues []string,
+	podNames map[string]string) error {
+	dsts, err := nsc.ln.ipvsGetDestinations(ipvsSvc)
+	if err != nil {
+		return fmt.Errorf("failed to list the destinations of IPVS service %s: %v", ipvsServiceString(ipvsSvc), err)
+	}
+	for _, dst := range dsts {
+		labelValues := make([]string, 0, len(svcLabelValues)+2)
+		labelValues = append(labelValues, svcLabelValues...)
+		labelValues = append(labelValues, net.JoinHostPort(dst.Address.String(), strconv.Itoa(int(dst.Port))),
+			podNames[dst.Address.String()])
+		metrics.ServiceEndpointActiveConn.WithLabelValues(labelValues...).Set(float64(dst.ActiveConnections))
+		metrics.ServiceEndpointInactiveConn.WithLabelValues(labelValues...).Set(float64(dst.InactiveConnections))
+		metrics.ServiceEndpointTotalConn.WithLabelValues(labelValues...).Set(float64(dst.Stats.Connections))
+		metrics.ServiceEndpointPacketsIn.WithLabelValues(labelValues...).Set(float64(dst.Stats.PacketsIn))
+		metrics.ServiceEndpointPacketsOut.WithLabelValues(labelValues...).Set(float64(dst.Stats.PacketsOut))
+		metrics.ServiceEndpointBytesIn.WithLabelValues(labelValues...).Set(float64(dst.Stats.BytesIn))
+		metrics.ServiceEndpointBytesOut.WithLabelValues(labelValues...).Set(float64(dst.Stats.BytesOut))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/moby/ipvs"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_publishEndpointMetrics(t *testing.T) {
+	podLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, podLister.Add(&v1core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"},
+		Status: v1core.PodStatus{PodIPs: []v1core.PodIP{{IP: "10.1.0.5"}, {IP: "2001:db8::5"}}}}))
+	assert.NoError(t, podLister.Add(&v1core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec: v1core.PodSpec{HostNetwork: true}, Status: v1core.PodStatus{PodIPs: []v1core.PodIP{{IP: "10.0.0.1"}}}}))
+
+	mock := &LinuxNetworkingMock{ipvsGetDestinationsFunc: func(*ipvs.Service) ([]*ipvs.Destination, error) {
+		return []*ipvs.Destination{
+			{Address: net.ParseIP("10.1.0.5"), Port: 8080, ActiveConnections: 3, InactiveConnections: 1,
+				Stats: ipvs.DstStats{Connections: 42, BytesIn: 1000}},
+			{Address: net.ParseIP("10.0.0.1"), Port: 8080, ActiveConnections: 2},
+		}, nil
+	}}
+	nsc := &NetworkServicesController{ln: mock, podLister: podLister}
+	podNames := nsc.podNamesByIP()
+	assert.Equal(t, map[string]string{"10.1.0.5": "web-1", "2001:db8::5": "web-1"}, podNames,
+		"expected the pods of the host network to be left out")
+
+	svcLabels := []string{"default", "web", "10.96.0.10", "tcp", "80"}
+	assert.NoError(t, nsc.publishEndpointMetrics(&ipvs.Service{}, svcLabels, podNames))
+	web := append(append([]string{}, svcLabels...), "10.1.0.5:8080", "web-1")
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.ServiceEndpointActiveConn.WithLabelValues(web...)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ServiceEndpointInactiveConn.WithLabelValues(web...)))
+	assert.Equal(t, 42.0, testutil.ToFloat64(metrics.ServiceEndpointTotalConn.WithLabelValues(web...)))
+	assert.Equal(t, 1000.0, testutil.ToFloat64(metrics.ServiceEndpointBytesIn.WithLabelValues(web...)))
+	host := append(append([]string{}, svcLabels...), "10.0.0.1:8080", "")
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.ServiceEndpointActiveConn.WithLabelValues(host...)))
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/moby/ipvs"
+	"k8s.io/klog/v2"
+)
+
+// ipvsState is the IPVS configuration of the node as read at the start of a sync, which the desired destinations are
+// compared against so that only the differences are applied. The destinations already in place, like the ones set up
+// by the kube-router that ran before a restart, are left as they are when nothing changed about them.
+type ipvsState struct {
+	// destinations are keyed by ipvsDestinationID
+	destinations map[string]*ipvs.Destination
+}
+
+// readIPVSState reads the IPVS services of the node and their destinations
+func readIPVSState(ln LinuxNetworking) (*ipvsState, error) {
+	ipvsSvcs, err := ln.ipvsGetServices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list IPVS services: %v", err)
+	}
+	state := &ipvsState{destinations: make(map[string]*ipvs.Destination)}
+	for _, ipvsSvc := range ipvsSvcs {
+		dsts, err := ln.ipvsGetDestinations(ipvsSvc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the destinations of IPVS service %s: %v",
+				ipvsServiceString(ipvsSvc), err)
+		}
+		for _, dst := range dsts {
+			state.destinations[ipvsDestinationID(ipvsSvc, dst)] = dst
+		}
+	}
+	return state, nil
+}
+
+// ipvsDestinationID identifies the destination of an IPVS service, the service by its FW mark or its address,
+// protocol and port
+func ipvsDestinationID(ipvsSvc *ipvs.Service, dst *ipvs.Destination) string {
+	serviceID := fmt.Sprint(ipvsSvc.FWMark)
+	if ipvsSvc.FWMark == 0 {
+		serviceID = generateIPPortID(ipvsSvc.Address.String(), convertSysCallProtoToSvcProto(ipvsSvc.Protocol),
+			strconv.Itoa(int(ipvsSvc.Port)))
+	}
+	return serviceID + "/" + generateEndpointID(dst.Address.String(), strconv.Itoa(int(dst.Port)))
+}
+
+// ipvsDestinationUnchanged tells whether the destination in place already forwards the traffic as desired
+func ipvsDestinationUnchanged(current, desired *ipvs.Destination) bool {
+	return current.Weight == desired.Weight &&
+		current.ConnectionFlags&ipvs.ConnectionFlagFwdMask == desired.ConnectionFlags&ipvs.ConnectionFlagFwdMask &&
+		current.UpperThreshold == desired.UpperThreshold && current.LowerThreshold == desired.LowerThreshold
+}
+
+// ipvsApplyDestination adds the destination to the IPVS service or updates it, unless the destination in place
+// already is as desired
+func (nsc *NetworkServicesController) ipvsApplyDestination(ipvsSvc *ipvs.Service, dst *ipvs.Destination) error {
+	if nsc.ipvsState != nil {
+		current, ok := nsc.ipvsState.destinations[ipvsDestinationID(ipvsSvc, dst)]
+		if ok && ipvsDestinationUnchanged(current, dst) {
+			klog.V(3).Infof("ipvs destination %s of the service %s is unchanged", ipvsDestinationString(dst),
+				ipvsServiceString(ipvsSvc))
+			return nil
+		}
+	}
+	return nsc.ln.ipvsAddServer(ipvsSvc, dst)
+}
+
+// parseFWMarkRules returns the services of the FW marks set by the rules of the mangle table, as kept in the
+// fwMarkMap, given the rules as iptables-save prints them
+func parseFWMarkRules(rules []string) map[uint32]string {
+	fwMarks := make(map[uint32]string)
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule, "-A PREROUTING ") || !strings.Contains(rule, "mark traffic to VIP with its FWMARK") {
+			continue
+		}
+		var ip, protocol, port string
+		var fwMark uint64
+		var err error
+		fields := strings.Fields(rule)
+		for i := 0; i < len(fields)-1; i++ {
+			switch fields[i] {
+			case "-d":
+				ip = strings.TrimSuffix(fields[i+1], "/32")
+			case "-p":
+				protocol = fields[i+1]
+			case "--dport":
+				port = fields[i+1]
+			case "--set-xmark":
+				fwMark, err = strconv.ParseUint(strings.TrimPrefix(strings.Split(fields[i+1], "/")[0], "0x"), 16, 32)
+			}
+		}
+		if ip == "" || protocol == "" || port == "" || fwMark == 0 || err != nil {
+			continue
+		}
+		if _, ok := fwMarks[uint32(fwMark)]; !ok {
+			fwMarks[uint32(fwMark)] = fmt.Sprintf("%s-%s-%s", ip, protocol, port)
+		}
+	}
+	return fwMarks
+}
+
+// restoreFWMarkMap rebuilds the fwMarkMap from the rules marking the traffic of the FW mark based IPVS services, which
+// outlive a restart of kube-router, so that the services keep their FW marks and the rules of the ones that went
+// away while kube-router was not running can still be cleaned up
+func (nsc *NetworkServicesController) restoreFWMarkMap() {
+	mangleTableRulesDump := bytes.Buffer{}
+	if err := utils.SaveInto("mangle", &mangleTableRulesDump); err != nil {
+		klog.Errorf("Failed to run iptables-save to restore the FW marks of services: %v", err)
+		return
+	}
+	for fwMark, serviceKey := range parseFWMarkRules(strings.Split(mangleTableRulesDump.String(), "\n")) {
+		if _, ok := nsc.fwMarkMap[fwMark]; !ok {
+			nsc.fwMarkMap[fwMark] = serviceKey
+		}
+	}
+	klog.V(1).Infof("Restored %d FW marks of services", len(nsc.fwMarkMap))
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/moby/ipvs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseFWMarkRules(t *testing.T) {
+	rules := []string{
+		"*mangle",
+		":PREROUTING ACCEPT [0:0]",
+		`-A PREROUTING -d 1.1.1.1/32 -p tcp -m comment --comment "kube-router mark traffic to VIP with its FWMARK" ` +
+			`-m tcp --dport 8080 -j MARK --set-xmark 0x1f4/0xffffffff`,
+		`-A OUTPUT -d 1.1.1.1/32 -p tcp -m comment --comment "kube-router mark traffic to VIP with its FWMARK" ` +
+			`-m tcp --dport 8080 -j MARK --set-xmark 0x1f4/0xffffffff`,
+		`-A PREROUTING -d 2.2.2.2/32 -p udp -m comment --comment "kube-router mark traffic to VIP with its FWMARK" ` +
+			`-m udp --dport 10000:20000 -j MARK --set-xmark 0x2a/0xffffffff`,
+		`-A PREROUTING -d 3.3.3.3/32 -p tcp -m tcp --dport 80 -j MARK --set-xmark 0x10/0xffffffff`,
+		`-A PREROUTING -d 1.1.1.1/32 -m comment --comment "kube-router clamp MSS of DSR traffic" -p tcp -m tcp ` +
+			`--tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1440`,
+		"COMMIT",
+	}
+	assert.Equal(t, map[uint32]string{
+		500: "1.1.1.1-tcp-8080",
+		42:  "2.2.2.2-udp-10000:20000",
+	}, parseFWMarkRules(rules))
+}
+
+func TestNetworkServicesController_ipvsApplyDestination(t *testing.T) {
+	svc := &ipvs.Service{Address: net.ParseIP("10.0.0.1"), AddressFamily: syscall.AF_INET,
+		Protocol: syscall.IPPROTO_TCP, Port: 8080}
+	current := &ipvs.Destination{Address: net.ParseIP("172.20.1.1"), AddressFamily: syscall.AF_INET, Port: 80,
+		Weight: 1}
+	mock := &LinuxNetworkingMock{
+		ipvsGetServicesFunc: func() ([]*ipvs.Service, error) { return []*ipvs.Service{svc}, nil },
+		ipvsGetDestinationsFunc: func(*ipvs.Service) ([]*ipvs.Destination, error) {
+			return []*ipvs.Destination{current}, nil
+		},
+		ipvsAddServerFunc: func(*ipvs.Service, *ipvs.Destination) error { return nil },
+	}
+	nsc := &NetworkServicesController{ln: mock}
+	var err error
+	nsc.ipvsState, err = readIPVSState(mock)
+	assert.NoError(t, err)
+
+	unchanged := *current
+	assert.NoError(t, nsc.ipvsApplyDestination(svc, &unchanged))
+	assert.Empty(t, mock.ipvsAddServerCalls(), "expected the destination in place to be left alone")
+
+	reweighted := *current
+	reweighted.Weight = 0
+	assert.NoError(t, nsc.ipvsApplyDestination(svc, &reweighted))
+	tunneled := *current
+	tunneled.ConnectionFlags = ipvs.ConnectionFlagTunnel
+	assert.NoError(t, nsc.ipvsApplyDestination(svc, &tunneled))
+	added := ipvs.Destination{Address: net.ParseIP("172.20.1.2"), AddressFamily: syscall.AF_INET, Port: 80, Weight: 1}
+	assert.NoError(t, nsc.ipvsApplyDestination(svc, &added))
+	assert.Len(t, mock.ipvsAddServerCalls(), 3, "expected the changed and the new destinations to be applied")
+
+	nsc.ipvsState = nil
+	assert.NoError(t, nsc.ipvsApplyDestination(svc, &unchanged))
+	assert.Len(t, mock.ipvsAddServerCalls(), 4, "expected all the destinations to be applied without the IPVS state")
+}
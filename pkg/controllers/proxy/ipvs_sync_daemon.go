@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	ipvsSyncDaemonMaster = "master"
+	ipvsSyncDaemonBackup = "backup"
+)
+
+// ipvsSyncDaemonRegex matches the sync daemons listed by ipvsadm -L --daemon, e.g.
+// master sync daemon (mcast=eth0, syncid=1, maxlen=1472, group=224.0.0.81, port=8848, ttl=1)
+var ipvsSyncDaemonRegex = regexp.MustCompile(`(?m)^(master|backup) sync daemon \(mcast=([^,)]+), syncid=(\d+)`)
+
+// ipvsSyncDaemon is the interface and sync ID of an IPVS sync daemon
+type ipvsSyncDaemon struct {
+	iface  string
+	syncID int
+}
+
+// newIpvsSyncDaemon returns the sync daemon of the flags, unset without an interface
+func newIpvsSyncDaemon(iface string, syncID int) (ipvsSyncDaemon, error) {
+	if iface == "" {
+		return ipvsSyncDaemon{}, nil
+	}
+	if syncID < 0 || syncID > 255 {
+		return ipvsSyncDaemon{}, fmt.Errorf("invalid --ipvs-sync-daemon-id %d, expected 0 to 255", syncID)
+	}
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return ipvsSyncDaemon{}, fmt.Errorf("invalid --ipvs-sync-daemon-interface %s: %v", iface, err)
+	}
+	return ipvsSyncDaemon{iface: iface, syncID: syncID}, nil
+}
+
+// parseIpvsSyncDaemons returns the sync daemons of the output of ipvsadm -L --daemon by their state
+func parseIpvsSyncDaemons(out string) map[string]ipvsSyncDaemon {
+	daemons := make(map[string]ipvsSyncDaemon)
+	for _, match := range ipvsSyncDaemonRegex.FindAllStringSubmatch(out, -1) {
+		syncID, _ := strconv.Atoi(match[3])
+		daemons[match[1]] = ipvsSyncDaemon{iface: match[2], syncID: syncID}
+	}
+	return daemons
+}
+
+// ensureIpvsSyncDaemons runs the master and the backup IPVS sync daemons on the interface of
+// --ipvs-sync-daemon-interface, so that the nodes multicast the state of the connections their IPVS services schedule
+// to each other. When a VIP
+// moves to another node, e.g. when the routers fail over to another BGP path, the node picks up the established
+// connections with the endpoints they were scheduled to.
+func (nsc *NetworkServicesController) ensureIpvsSyncDaemons() error {
+	if nsc.ipvsSyncDaemon.iface == "" {
+		return nil
+	}
+	out, err := exec.Command("ipvsadm", "-L", "--daemon").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to list the IPVS sync daemons: %v: %s", err, out)
+	}
+	running := parseIpvsSyncDaemons(string(out))
+	for _, state := range []string{ipvsSyncDaemonMaster, ipvsSyncDaemonBackup} {
+		daemon, ok := running[state]
+		if ok && daemon == nsc.ipvsSyncDaemon {
+			continue
+		}
+		if ok {
+			klog.Infof("Restarting the IPVS %s sync daemon on %s with sync ID %d", state,
+				nsc.ipvsSyncDaemon.iface, nsc.ipvsSyncDaemon.syncID)
+			//nolint:gosec // the state is one of the constants
+			if out, err = exec.Command("ipvsadm", "--stop-daemon", state).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to stop the IPVS %s sync daemon: %v: %s", state, err, out)
+			}
+		}
+		//nolint:gosec // the interface and the sync ID are validated by the controller
+		out, err = exec.Command("ipvsadm", "--start-daemon", state, "--mcast-interface", nsc.ipvsSyncDaemon.iface,
+			"--syncid", strconv.Itoa(nsc.ipvsSyncDaemon.syncID)).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to start the IPVS %s sync daemon: %v: %s", state, err, out)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseIpvsSyncDaemons(t *testing.T) {
+	assert.Equal(t, map[string]ipvsSyncDaemon{
+		ipvsSyncDaemonMaster: {iface: "eth0", syncID: 7},
+		ipvsSyncDaemonBackup: {iface: "eth1", syncID: 0},
+	}, parseIpvsSyncDaemons("master sync daemon (mcast=eth0, syncid=7, maxlen=1472, group=224.0.0.81, port=8848, "+
+		"ttl=1)\nbackup sync daemon (mcast=eth1, syncid=0)\n"))
+	assert.Empty(t, parseIpvsSyncDaemons(""))
+}
+
+func Test_newIpvsSyncDaemon(t *testing.T) {
+	daemon, err := newIpvsSyncDaemon("", 300)
+	assert.NoError(t, err, "expected the sync ID to be ignored without an interface")
+	assert.Equal(t, ipvsSyncDaemon{}, daemon)
+
+	daemon, err = newIpvsSyncDaemon("lo", 42)
+	assert.NoError(t, err)
+	assert.Equal(t, ipvsSyncDaemon{iface: "lo", syncID: 42}, daemon)
+
+	_, err = newIpvsSyncDaemon("lo", 256)
+	assert.Error(t, err)
+	_, err = newIpvsSyncDaemon("no-such-interface", 0)
+	assert.Error(t, err)
+}
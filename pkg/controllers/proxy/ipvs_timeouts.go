@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/moby/ipvs"
+)
+
+// newIpvsTimeouts returns the timeouts of the IPVS connections, like ipvsadm --set, for the TCP, the TCP connections
+// after their FIN and the UDP connections. A timeout of 0 keeps the timeout of the kernel.
+func newIpvsTimeouts(tcp, tcpFin, udp time.Duration) (ipvs.Config, error) {
+	for flag, timeout := range map[string]time.Duration{"--ipvs-tcp-timeout": tcp, "--ipvs-tcpfin-timeout": tcpFin,
+		"--ipvs-udp-timeout": udp} {
+		if timeout < 0 || (timeout > 0 && timeout < time.Second) {
+			return ipvs.Config{}, fmt.Errorf("invalid %s %s, expected 0 or at least 1s", flag, timeout)
+		}
+	}
+	return ipvs.Config{TimeoutTCP: tcp, TimeoutTCPFin: tcpFin, TimeoutUDP: udp}, nil
+}
+
+// ensureIpvsTimeouts sets the timeouts of the IPVS connections. They are shared by all the IPVS services of the node,
+// the kernel has no timeouts per service.
+func (nsc *NetworkServicesController) ensureIpvsTimeouts() error {
+	if nsc.ipvsTimeouts == (ipvs.Config{}) {
+		return nil
+	}
+	handle, err := ipvs.New("")
+	if err != nil {
+		return fmt.Errorf("failed to open an IPVS handle: %v", err)
+	}
+	defer handle.Close()
+	if err = handle.SetConfig(&nsc.ipvsTimeouts); err != nil {
+		return fmt.Errorf("failed to set the IPVS timeouts: %v", err)
+	}
+	return nil
+}
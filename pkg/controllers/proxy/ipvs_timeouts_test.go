@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moby/ipvs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newIpvsTimeouts(t *testing.T) {
+	timeouts, err := newIpvsTimeouts(time.Hour, 0, 10*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, ipvs.Config{TimeoutTCP: time.Hour, TimeoutUDP: 10 * time.Minute}, timeouts)
+
+	_, err = newIpvsTimeouts(0, -time.Second, 0)
+	assert.EqualError(t, err, "invalid --ipvs-tcpfin-timeout -1s, expected 0 or at least 1s")
+	_, err = newIpvsTimeouts(0, 0, 500*time.Millisecond)
+	assert.Error(t, err, "expected a timeout below the resolution of the kernel to be rejected")
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// getNDPProxyVIPs returns the IPv6 external and LoadBalancer IPs of the services that this node is able to serve, which
+// are the ones that L2 neighbors should be able to resolve to this node
+func getNDPProxyVIPs(serviceInfoMap serviceInfoMap, endpointsInfoMap endpointsInfoMap) sets.String {
+	vips := sets.NewString()
+	for _, vip := range getServedExternalVIPs(serviceInfoMap, endpointsInfoMap).List() {
+		if net.ParseIP(vip).To4() == nil {
+			vips.Insert(vip)
+		}
+	}
+	return vips
+}
+
+// getServiceIPv6VIPs returns the IPv6 external and LoadBalancer IPs of all services, whether this node serves them or
+// not
+func getServiceIPv6VIPs(serviceInfoMap serviceInfoMap) sets.String {
+	vips := sets.NewString()
+	for _, svc := range serviceInfoMap {
+		for _, vip := range append(append([]string{}, svc.externalIPs...), svc.loadBalancerIPs...) {
+			if ip := net.ParseIP(vip); ip != nil && ip.To4() == nil {
+				vips.Insert(ip.String())
+			}
+		}
+	}
+	return vips
+}
+
+// getStaleNDPProxyEntries returns the NDP proxy entries that kube-router is responsible for and that have to be
+// deleted, either because their VIP is no longer served from this node or because they were added on an interface
+// other than the one of the node IP
+func getStaleNDPProxyEntries(neighs []netlink.Neigh, nodeIfIndex int, desired sets.String,
+	owned func(ip net.IP) bool) []netlink.Neigh {
+	stale := make([]netlink.Neigh, 0)
+	for _, neigh := range neighs {
+		if neigh.LinkIndex == nodeIfIndex && desired.Has(neigh.IP.String()) {
+			continue
+		}
+		if owned(neigh.IP) {
+			stale = append(stale, neigh)
+		}
+	}
+	return stale
+}
+
+// ownsNDPProxyEntry tells whether the NDP proxy entry of the IP is one that kube-router manages. As the kernel has no
+// way to tag the entries, these are the ones that were added since kube-router started along with the ones of the
+// VIPs of the services and of the --loadbalancer-ip-range ranges, so that the entries that were left behind by a
+// previous run are cleaned up as well
+func (nsc *NetworkServicesController) ownsNDPProxyEntry(ip net.IP, serviceVIPs sets.String) bool {
+	if nsc.ndpProxyVIPs.Has(ip.String()) || serviceVIPs.Has(ip.String()) {
+		return true
+	}
+	for _, ipRange := range nsc.ndpProxyRanges {
+		if ipRange.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncNDPProxyEntries ensures that there is an NDP proxy entry on the node's interface for every IPv6 service VIP that
+// this node serves, so that the kernel answers neighbor solicitations for them, and removes the entries of the VIPs
+// kube-router manages that are no longer served from this node or that are on another interface. The entries of all
+// interfaces are listed on every sync, so that the ones that were added before a restart or an interface change are
+// reconciled too.
+func (nsc *NetworkServicesController) syncNDPProxyEntries(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap) error {
+	nodeIf, err := utils.GetNodeInterfaceFromNodeIP(nsc.nodeIP)
+	if err != nil {
+		return fmt.Errorf("failed to find the interface of the node IP %s: %v", nsc.nodeIP, err)
+	}
+	ifName := nodeIf.Attrs().Name
+
+	if sysctlErr := nsc.sysctls.Ensure(utils.SysctlSetting{
+		Path:   fmt.Sprintf(utils.IPv6ConfProxyNDPTemplate, ifName),
+		Value:  1,
+		Reason: "NDP proxy for service VIPs"}); sysctlErr != nil {
+		return fmt.Errorf("failed to enable proxy_ndp on %s: %v", ifName, sysctlErr)
+	}
+
+	// a link index of 0 lists the entries of all interfaces
+	neighs, err := netlink.NeighProxyList(0, netlink.FAMILY_V6)
+	if err != nil {
+		return fmt.Errorf("failed to list NDP proxy entries: %v", err)
+	}
+	existing := sets.NewString()
+	for _, neigh := range neighs {
+		if neigh.LinkIndex == nodeIf.Attrs().Index {
+			existing.Insert(neigh.IP.String())
+		}
+	}
+
+	desired := getNDPProxyVIPs(serviceInfoMap, endpointsInfoMap)
+	serviceVIPs := getServiceIPv6VIPs(serviceInfoMap)
+	stale := getStaleNDPProxyEntries(neighs, nodeIf.Attrs().Index, desired, func(ip net.IP) bool {
+		return nsc.ownsNDPProxyEntry(ip, serviceVIPs)
+	})
+	for i := range stale {
+		if err := netlink.NeighDel(&stale[i]); err != nil {
+			klog.Errorf("Failed to delete stale NDP proxy entry for service VIP %s: %v", stale[i].IP, err)
+			continue
+		}
+		klog.V(2).Infof("Deleted stale NDP proxy entry for service VIP %s", stale[i].IP)
+	}
+
+	for _, vip := range desired.Difference(existing).List() {
+		neigh := &netlink.Neigh{
+			LinkIndex: nodeIf.Attrs().Index,
+			Family:    netlink.FAMILY_V6,
+			Flags:     netlink.NTF_PROXY,
+			IP:        net.ParseIP(vip),
+		}
+		if err := netlink.NeighSet(neigh); err != nil {
+			klog.Errorf("Failed to add NDP proxy entry for service VIP %s on %s: %v", vip, ifName, err)
+			continue
+		}
+		klog.V(2).Infof("Added NDP proxy entry for service VIP %s on %s", vip, ifName)
+	}
+	nsc.ndpProxyVIPs = desired
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func Test_getNDPProxyVIPs(t *testing.T) {
+	svcs := serviceInfoMap{
+		"default/dual-stack": &serviceInfo{
+			externalIPs:     []string{"1.1.1.1", "2001:db8::1"},
+			loadBalancerIPs: []string{"2001:db8::2"},
+		},
+		"default/skip-lb": &serviceInfo{
+			externalIPs:     []string{"2001:db8::3"},
+			loadBalancerIPs: []string{"2001:db8::4"},
+			skipLbIps:       true,
+		},
+		"default/local-without-endpoints": &serviceInfo{
+			externalIPs: []string{"2001:db8::5"},
+			local:       true,
+		},
+		"default/local-with-endpoints": &serviceInfo{
+			externalIPs: []string{"2001:0db8:0000::6"},
+			local:       true,
+		},
+	}
+	eps := endpointsInfoMap{
+		"default/local-with-endpoints": []endpointsInfo{{ip: "2001:db8:1::10", port: 80, isLocal: true}},
+	}
+
+	vips := getNDPProxyVIPs(svcs, eps)
+
+	assert.ElementsMatch(t, []string{"2001:db8::1", "2001:db8::2", "2001:db8::3", "2001:db8::6"}, vips.List(),
+		"expected only IPv6 VIPs that are served by this node, in canonical form")
+}
+
+func Test_getStaleNDPProxyEntries(t *testing.T) {
+	_, lbRange, _ := net.ParseCIDR("2001:db8:2::/64")
+	nsc := &NetworkServicesController{ndpProxyVIPs: sets.NewString("2001:db8::3"),
+		ndpProxyRanges: []*net.IPNet{lbRange}}
+	svcs := serviceInfoMap{
+		"default/served": &serviceInfo{loadBalancerIPs: []string{"2001:db8::1"}},
+		"default/remote": &serviceInfo{externalIPs: []string{"1.1.1.1", "2001:db8:0::4"}},
+	}
+	serviceVIPs := getServiceIPv6VIPs(svcs)
+	assert.ElementsMatch(t, []string{"2001:db8::1", "2001:db8::4"}, serviceVIPs.List())
+
+	neighs := []netlink.Neigh{
+		{LinkIndex: 2, IP: net.ParseIP("2001:db8::1")},
+		// served VIP left on the former interface of the node IP
+		{LinkIndex: 3, IP: net.ParseIP("2001:db8::1")},
+		// added since kube-router started, no longer served
+		{LinkIndex: 2, IP: net.ParseIP("2001:db8::3")},
+		// left behind by a previous run for a service that is no longer served from this node
+		{LinkIndex: 2, IP: net.ParseIP("2001:db8::4")},
+		// left behind by a previous run for a service that was deleted since
+		{LinkIndex: 2, IP: net.ParseIP("2001:db8:2::5")},
+		// managed by someone else
+		{LinkIndex: 2, IP: net.ParseIP("2001:db8:3::6")},
+	}
+	stale := getStaleNDPProxyEntries(neighs, 2, sets.NewString("2001:db8::1"), func(ip net.IP) bool {
+		return nsc.ownsNDPProxyEntry(ip, serviceVIPs)
+	})
+
+	assert.Equal(t, []netlink.Neigh{neighs[1], neighs[2], neighs[3], neighs[4]}, stale,
+		"expected the entries kube-router manages that aren't on the node's interface or no longer served to be stale")
+}
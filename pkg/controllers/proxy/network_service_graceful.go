@@ -2,13 +2,12 @@ package proxy
 
 import (
 	"fmt"
-	"os/exec"
-	"regexp"
-	"strconv"
+	"net"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/moby/ipvs"
 	"k8s.io/klog/v2"
 )
@@ -46,15 +45,37 @@ func (nsc *NetworkServicesController) ipvsDeleteDestination(svc *ipvs.Service, d
 			return err
 		}
 	}
-	// flush conntrack when Destination for a UDP service changes
-	if svc.Protocol == syscall.IPPROTO_UDP {
-		if err := nsc.flushConntrackUDP(svc); err != nil {
+	// flush the conntrack entries of the destination when it is removed from a UDP service, so that the clients
+	// don't keep sending to it until their entries time out. Only the masqueraded flows carry the address of the
+	// destination they are answered by, the DSR ones are answered by the VIP, so all the flows to those are flushed.
+	if address, port, ok := nsc.ipvsServiceUDPAddress(svc); ok {
+		var endpoint net.IP
+		if dst.ConnectionFlags&ipvs.ConnectionFlagFwdMask == ipvs.ConnectionFlagMasq {
+			endpoint = dst.Address
+		}
+		if err := flushConntrackUDP(address, port, endpoint); err != nil {
 			klog.Errorf("Failed to flush conntrack: %s", err.Error())
 		}
 	}
 	return nil
 }
 
+// ipvsServiceUDPAddress returns the address and port of the IPVS service if it is a UDP one. The FWMark services of
+// DSR don't carry them, they are found through the FW mark of the service instead.
+func (nsc *NetworkServicesController) ipvsServiceUDPAddress(svc *ipvs.Service) (string, int, bool) {
+	if svc.FWMark != 0 {
+		address, protocol, port, err := nsc.lookupServiceByFWMark(svc.FWMark)
+		if err != nil || protocol != udpProtocol {
+			return "", 0, false
+		}
+		return address, port, true
+	}
+	if svc.Protocol != syscall.IPPROTO_UDP {
+		return "", 0, false
+	}
+	return svc.Address.String(), int(svc.Port), true
+}
+
 func (nsc *NetworkServicesController) addToGracefulQueue(req *gracefulRequest) {
 	nsc.gracefulQueue.mu.Lock()
 	defer nsc.gracefulQueue.mu.Unlock()
@@ -148,22 +169,13 @@ func (nsc *NetworkServicesController) getIpvsDestinationConnStats(ipvsSvc *ipvs.
 		ipvsDestinationString(dest), ipvsServiceString(ipvsSvc))
 }
 
-// flushConntrackUDP flushes UDP conntrack records for the given service destination
-func (nsc *NetworkServicesController) flushConntrackUDP(svc *ipvs.Service) error {
-	// Conntrack exits with non zero exit code when exiting if 0 flow entries have been deleted, use regex to
-	// check output and don't Error when matching
-	re := regexp.MustCompile("([[:space:]]0 flow entries have been deleted.)")
-
-	// Shell out and flush conntrack records
-	//nolint:gosec // this exec should be safe from command injection given the parameter's context
-	out, err := exec.Command("conntrack", "-D", "--orig-dst", svc.Address.String(), "-p", udpProtocol,
-		"--dport", strconv.Itoa(int(svc.Port))).CombinedOutput()
+// flushConntrackUDP flushes the UDP conntrack records of the given service address and port, only those of the
+// endpoint when one is given
+func flushConntrackUDP(address string, port int, endpoint net.IP) error {
+	deleted, err := utils.DeleteUDPConntrackFlows(net.ParseIP(address), uint16(port), endpoint)
 	if err != nil {
-		if matched := re.MatchString(string(out)); !matched {
-			return fmt.Errorf("failed to delete conntrack entry for endpoint: %s:%d due to %s",
-				svc.Address.String(), svc.Port, err.Error())
-		}
+		return err
 	}
-	klog.V(1).Infof("Deleted conntrack entry for endpoint: %s:%d", svc.Address.String(), svc.Port)
+	klog.V(1).Infof("Deleted %d conntrack entries of the UDP service %s:%d", deleted, address, port)
 	return nil
 }
@@ -21,6 +21,7 @@ import (
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/tracing"
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/docker/docker/client"
@@ -29,9 +30,11 @@ import (
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
@@ -65,37 +68,69 @@ const (
 	ipvsExpireQuiescentTemplateEnable       = 1
 	ipvsExpireNodestConnEnable              = 1
 	ipvsConntrackEnable                     = 1
+	ipvsPMTUDiscEnable                      = 1
+	ipvsRunEstimationEnable                 = 1
+	ipvsRunEstimationDisable                = 0
 
 	// Taken from https://www.kernel.org/doc/Documentation/networking/ip-sysctl.txt
 	arpAnnounceUseBestLocalAddress      = 2
 	arpIgnoreReplyOnlyIfTargetIPIsLocal = 1
 
 	svcDSRAnnotation                = "kube-router.io/service.dsr"
+	svcDSREncapAnnotation           = "kube-router.io/service.dsr.encapsulation"
 	svcSchedulerAnnotation          = "kube-router.io/service.scheduler"
 	svcHairpinAnnotation            = "kube-router.io/service.hairpin"
 	svcHairpinExternalIPsAnnotation = "kube-router.io/service.hairpin.externalips"
 	svcLocalAnnotation              = "kube-router.io/service.local"
 	svcSkipLbIpsAnnotation          = "kube-router.io/service.skiplbips"
+	svcNoMasqueradeAnnotation       = "kube-router.io/service.no-masquerade"
+	svcPortRangeAnnotation          = "kube-router.io/service.port-range"
 	svcSchedFlagsAnnotation         = "kube-router.io/service.schedflags"
 
+	nodePortInterfaceAnnotation = "kube-router.io/nodeport-interface"
+
 	localIPsIPSetName     = "kube-router-local-ips"
 	ipvsServicesIPSetName = "kube-router-ipvs-services"
 	serviceIPsIPSetName   = "kube-router-service-ips"
 	ipvsFirewallChainName = "KUBE-ROUTER-SERVICES"
 	ipvsHairpinChainName  = "KUBE-ROUTER-HAIRPIN"
+	ipvsMasqueradeComment = utils.OwnerComment + " masquerade outbound IPVS traffic"
 	synctypeAll           = iota
 	synctypeIpvs
 
 	tcpProtocol         = "tcp"
 	udpProtocol         = "udp"
+	sctpProtocol        = "sctp"
 	noneProtocol        = "none"
 	tunnelInterfaceType = "tunnel"
+	directRouteMethod   = "direct"
 
 	gracefulTermServiceTickTime = 5 * time.Second
+
+	// serviceProxySyncFailedEventReason is the reason of the Events about the syncs of the IPVS services that failed
+	serviceProxySyncFailedEventReason = "ServiceProxySyncFailed"
 )
 
 var (
-	NodeIP net.IP
+	// tracer creates the spans of the syncs of the IPVS services
+	tracer = tracing.Tracer("proxy")
+
+	// sctpDSRKernelModules are the kernel modules DSR needs for SCTP services
+	sctpDSRKernelModules = []utils.KernelModule{
+		{Name: "sctp", Required: true, Reason: "SCTP services"},
+		{Name: "xt_sctp", Required: true, Reason: "DSR for SCTP services"},
+	}
+
+	// ipvsSchedulers are the IPVS schedulers that the services can be annotated with
+	ipvsSchedulers = sets.NewString(ipvs.RoundRobin, ipvs.WeightedRoundRobin, ipvs.LeastConnection,
+		ipvs.WeightedLeastConnection, ipvs.DestinationHashing, ipvs.SourceHashing, IpvsMaglevHashing)
+
+	// schedFlagNames are the names the source and Maglev hashing schedulers give their flags, the flags of the other
+	// schedulers are ignored as they have none
+	schedFlagNames = map[string]map[string]string{
+		ipvs.SourceHashing: {"sh-fallback": IpvsSvcFSched1, "sh-port": IpvsSvcFSched2},
+		IpvsMaglevHashing:  {"mh-fallback": IpvsSvcFSched1, "mh-port": IpvsSvcFSched2},
+	}
 )
 
 type ipvsCalls interface {
@@ -115,13 +150,13 @@ type ipvsCalls interface {
 }
 
 type netlinkCalls interface {
-	ipAddrAdd(iface netlink.Link, ip string, addRoute bool) error
-	ipAddrDel(iface netlink.Link, ip string) error
-	prepareEndpointForDsrWithDocker(containerID string, endpointIP string, vip string) error
+	ipAddrAdd(iface netlink.Link, ip string, nodeIP string, addRoute bool) error
+	ipAddrDel(iface netlink.Link, ip string, nodeIP string) error
+	prepareEndpointForDsrWithDocker(containerID string, endpointIP string, vip, dsrMethod string, guePort uint16) error
 	getKubeDummyInterface() (netlink.Link, error)
 	setupRoutesForExternalIPForDSR(serviceInfoMap) error
-	prepareEndpointForDsrWithCRI(runtimeEndpoint, containerID, endpointIP, vip string) error
-	configureContainerForDSR(vip, endpointIP, containerID string, pid int,
+	prepareEndpointForDsrWithCRI(runtimeEndpoint, containerID, endpointIP, vip, dsrMethod string, guePort uint16) error
+	configureContainerForDSR(vip, dsrMethod string, guePort uint16, endpointIP, containerID string, pid int,
 		hostNetworkNamespaceHandle netns.NsHandle) error
 	setupPolicyRoutingForDSR() error
 	cleanupMangleTableRule(ip string, protocol string, port string, fwmark string, tcpMSS int) error
@@ -139,7 +174,7 @@ type linuxNetworking struct {
 	ipvsHandle *ipvs.Handle
 }
 
-func (ln *linuxNetworking) ipAddrDel(iface netlink.Link, ip string) error {
+func (ln *linuxNetworking) ipAddrDel(iface netlink.Link, ip string, nodeIP string) error {
 	naddr := &netlink.Addr{IPNet: &net.IPNet{
 		IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255),
 	}, Scope: syscall.RT_SCOPE_LINK}
@@ -153,7 +188,7 @@ func (ln *linuxNetworking) ipAddrDel(iface netlink.Link, ip string) error {
 		// #nosec G204
 		out, err := exec.Command("ip", "route", "delete", "local", ip, "dev", KubeDummyIf,
 			"table", "local", "proto", "kernel", "scope", "host", "src",
-			NodeIP.String(), "table", "local").CombinedOutput()
+			nodeIP, "table", "local").CombinedOutput()
 		if err != nil && !strings.Contains(string(out), "No such process") {
 			klog.Errorf("Failed to delete route to service VIP %s configured on %s. Error: %v, Output: %s",
 				ip, KubeDummyIf, err, out)
@@ -165,7 +200,7 @@ func (ln *linuxNetworking) ipAddrDel(iface netlink.Link, ip string) error {
 // utility method to assign an IP to an interface. Mainly used to assign service VIP's
 // to kube-dummy-if. Also when DSR is used, used to assign VIP to dummy interface
 // inside the container.
-func (ln *linuxNetworking) ipAddrAdd(iface netlink.Link, ip string, addRoute bool) error {
+func (ln *linuxNetworking) ipAddrAdd(iface netlink.Link, ip string, nodeIP string, addRoute bool) error {
 	naddr := &netlink.Addr{IPNet: &net.IPNet{
 		IP: net.ParseIP(ip), Mask: net.IPv4Mask(255, 255, 255, 255),
 	}, Scope: syscall.RT_SCOPE_LINK}
@@ -190,7 +225,7 @@ func (ln *linuxNetworking) ipAddrAdd(iface netlink.Link, ip string, addRoute boo
 	// #nosec G204
 	out, err := exec.Command("ip", "route", "replace", "local", ip, "dev", KubeDummyIf,
 		"table", "local", "proto", "kernel", "scope", "host", "src",
-		NodeIP.String(), "table", "local").CombinedOutput()
+		nodeIP, "table", "local").CombinedOutput()
 	if err != nil {
 		klog.Errorf("Failed to replace route to service VIP %s configured on %s. Error: %v, Output: %s",
 			ip, KubeDummyIf, err, out)
@@ -250,25 +285,46 @@ func newLinuxNetworking() (*linuxNetworking, error) {
 // NetworkServicesController struct stores information needed by the controller
 type NetworkServicesController struct {
 	nodeIP              net.IP
+	nodePortIP          net.IP
+	nodePortIPFromNode  bool
 	nodeHostName        string
+	nodeLabels          map[string]string
 	syncPeriod          time.Duration
 	mu                  sync.Mutex
+	logger              klog.Logger
+	eventRecorder       record.EventRecorder
 	serviceMap          serviceInfoMap
 	endpointsMap        endpointsInfoMap
-	podCidr             string
+	podCIDRs            []string
 	excludedCidrs       []net.IPNet
 	masqueradeAll       bool
 	globalHairpin       bool
 	ipvsPermitAll       bool
+	ipvsStatsEstimation bool
 	client              kubernetes.Interface
 	nodeportBindOnAllIP bool
-	MetricsEnabled      bool
-	metricsMap          map[string][]string
-	ln                  LinuxNetworking
-	readyForUpdates     bool
-	ProxyFirewallSetup  *sync.Cond
-	ipsetMutex          *sync.Mutex
-	fwMarkMap           map[uint32]string
+	// nodePortAddresses are the CIDRs of the node addresses that serve NodePorts, when not empty
+	nodePortAddresses []*net.IPNet
+	// nodePortAllowedCIDRs are the client CIDRs allowed to reach NodePorts of services without their own annotation
+	nodePortAllowedCIDRs []string
+	noTrack              noTrackConfig
+	noMasquerade         noMasqueradeConfig
+	MetricsEnabled       bool
+	metricsMap           map[string][]string
+	ln                   LinuxNetworking
+	readyForUpdates      bool
+	ProxyFirewallSetup   *sync.Cond
+	ipsetMutex           *sync.Mutex
+	sysctls              *utils.SysctlManager
+	fwMarkMap            map[uint32]string
+	// ipvsState is the IPVS configuration in place during a sync of the IPVS services
+	ipvsState *ipvsState
+	// conntrackAccounting publishes the conntrack flows of the services and pods, those older than
+	// longLivedFlowAge counting as long-lived
+	conntrackAccounting bool
+	longLivedFlowAge    time.Duration
+	// endpointMetrics publishes the metrics of the IPVS destinations of the services
+	endpointMetrics bool
 
 	// Map of ipsets that we use.
 	ipsetMap map[string]*utils.Set
@@ -276,16 +332,43 @@ type NetworkServicesController struct {
 	svcLister cache.Indexer
 	epLister  cache.Indexer
 	podLister cache.Indexer
+	// epSliceLister is only set when the terminating endpoints or the topology aware routing are used
+	epSliceLister cache.Indexer
+	// the listers of the Gateway API resources are only set when the Gateways are served, see EnableGatewayAPI
+	gatewayClassLister cache.Indexer
+	gatewayLister      cache.Indexer
+	tcpRouteLister     cache.Indexer
+	udpRouteLister     cache.Indexer
 
 	EndpointsEventHandler cache.ResourceEventHandler
 	ServiceEventHandler   cache.ResourceEventHandler
+	NodeEventHandler      cache.ResourceEventHandler
+	// EndpointSliceEventHandler is only set when the terminating endpoints or the topology aware routing are used
+	EndpointSliceEventHandler cache.ResourceEventHandler
+	// GatewayEventHandler is only set when the Gateways are served
+	GatewayEventHandler cache.ResourceEventHandler
 
 	gracefulPeriod      time.Duration
 	gracefulQueue       gracefulQueue
 	gracefulTermination bool
+	slowStart           *slowStart
+	ipvsTimeouts        ipvs.Config
+	ipvsSyncDaemon      ipvsSyncDaemon
 	syncChan            chan int
+	nodeIPChangeChan    chan net.IP
 	dsr                 *dsrOpt
 	dsrTCPMSS           int
+	dsrSCTP             bool
+	dsrEncapsulation    string
+	guePort             uint16
+	ndpProxy            bool
+	ndpProxyVIPs        sets.String
+	ndpProxyRanges      []*net.IPNet
+	announceVIPs        bool
+	announcedVIPs       sets.String
+	// terminatingEndpoints and topologyAwareRouting use the EndpointSlices
+	terminatingEndpoints bool
+	topologyAwareRouting bool
 }
 
 // DSR related options
@@ -307,13 +390,18 @@ type serviceInfo struct {
 	directServerReturn            bool
 	scheduler                     string
 	directServerReturnMethod      string
+	dsrEncapsulation              string
 	hairpin                       bool
 	hairpinExternalIPs            bool
 	skipLbIps                     bool
+	noMasquerade                  bool
+	portRange                     string
 	externalIPs                   []string
 	loadBalancerIPs               []string
 	local                         bool
 	flags                         schedFlags
+	nodePortAllowedCIDRs          []string
+	loadBalancerSourceRanges      []string
 }
 
 // IPVS scheduler flags
@@ -331,6 +419,15 @@ type endpointsInfo struct {
 	ip      string
 	port    int
 	isLocal bool
+	// terminating endpoints are only used when the service has no ready endpoints, see EnableTerminatingEndpoints
+	terminating bool
+	// zoneHinted endpoints have topology hints, inZone when they give them to the zone of the node, see
+	// EnableTopologyAwareRouting
+	zoneHinted bool
+	inZone     bool
+	// weight scales the IPVS weight of the endpoints of the listeners of the Gateways by the weights of their
+	// backends, the endpoints of the services have none
+	weight int
 }
 
 // map of all endpoints, with unique service id(namespace name, service name, port) as key
@@ -352,32 +449,42 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 		klog.Errorf("Error cleaning up old/bad masquerade rules: %s", err.Error())
 	}
 
-	// enable masquerade rule
+	// enable masquerade rule, which matches on the pod CIDRs of the node
+	if len(nsc.podCIDRs) > 0 {
+		if err = nsc.syncLocalPodCIDRsIPSet(); err != nil {
+			klog.Errorf("Failed to sync the ipset of the pod CIDRs of the node: %s", err.Error())
+		}
+	}
 	err = nsc.ensureMasqueradeIptablesRule()
 	if err != nil {
 		klog.Errorf("Failed to do add masquerade rule in POSTROUTING chain of nat table due to: %s", err.Error())
+		nsc.recordIptablesSyncFailure("masquerade", err)
 	}
 	// https://www.kernel.org/doc/Documentation/networking/ipvs-sysctl.txt
 	// enable ipvs connection tracking
-	sysctlErr := utils.SetSysctl(utils.IPv4IPVSConntrack, ipvsConntrackEnable)
+	sysctlErr := nsc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4IPVSConntrack,
+		Value: ipvsConntrackEnable, Reason: "IPVS connection tracking"})
 	if sysctlErr != nil {
 		klog.Error(sysctlErr.Error())
 	}
 
 	// LVS failover not working with UDP packets https://access.redhat.com/solutions/58653
-	sysctlErr = utils.SetSysctl(utils.IPv4IPVSExpireNodestConn, ipvsExpireNodestConnEnable)
+	sysctlErr = nsc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4IPVSExpireNodestConn,
+		Value: ipvsExpireNodestConnEnable, Reason: "IPVS UDP failover"})
 	if sysctlErr != nil {
 		klog.Error(sysctlErr.Error())
 	}
 
 	// LVS failover not working with UDP packets https://access.redhat.com/solutions/58653
-	sysctlErr = utils.SetSysctl(utils.IPv4IPVSExpireQuiescent, ipvsExpireQuiescentTemplateEnable)
+	sysctlErr = nsc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4IPVSExpireQuiescent,
+		Value: ipvsExpireQuiescentTemplateEnable, Reason: "IPVS UDP failover"})
 	if sysctlErr != nil {
 		klog.Error(sysctlErr.Error())
 	}
 
 	// https://github.com/kubernetes/kubernetes/pull/71114
-	sysctlErr = utils.SetSysctl(utils.IPv4IPVSConnReuseMode, ipvsConnReuseModeDisableSpecialHandling)
+	sysctlErr = nsc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4IPVSConnReuseMode,
+		Value: ipvsConnReuseModeDisableSpecialHandling, Reason: "IPVS connection reuse"})
 	if sysctlErr != nil {
 		// Check if the error is fatal, on older kernels this option does not exist and the same behaviour is default
 		// if option is not found just log it
@@ -388,25 +495,84 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 		}
 	}
 
+	// Have IPVS send ICMP fragmentation needed messages to the clients when a packet with DF set doesn't fit the path to
+	// the real server anymore after being encapsulated, as with tunneled DSR, so that path MTU discovery keeps working
+	sysctlErr = nsc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4IPVSPMTUDisc,
+		Value: ipvsPMTUDiscEnable, Reason: "path MTU discovery through IPVS tunnels"})
+	if sysctlErr != nil {
+		// the option only exists on kernels that support turning path MTU discovery of IPVS off, where it is on by
+		// default
+		if sysctlErr.IsFatal() {
+			klog.Error(sysctlErr.Error())
+		} else {
+			klog.Info(sysctlErr.Error())
+		}
+	}
+
+	// The rate estimator of IPVS walks all the services and destinations every 2 seconds, which costs a lot of CPU on
+	// nodes with tens of thousands of them. Kernels that can't suspend it don't have the option, they always run it.
+	runEstimation := ipvsRunEstimationEnable
+	if !nsc.ipvsStatsEstimation {
+		runEstimation = ipvsRunEstimationDisable
+	}
+	sysctlErr = nsc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4IPVSRunEstimation,
+		Value: runEstimation, Reason: "IPVS stats estimation"})
+	if sysctlErr != nil {
+		if sysctlErr.IsFatal() || !nsc.ipvsStatsEstimation {
+			klog.Error(sysctlErr.Error())
+		} else {
+			klog.V(1).Info(sysctlErr.Error())
+		}
+	}
+
 	// https://github.com/kubernetes/kubernetes/pull/70530/files
-	sysctlErr = utils.SetSysctl(utils.IPv4ConfAllArpIgnore, arpIgnoreReplyOnlyIfTargetIPIsLocal)
+	sysctlErr = nsc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4ConfAllArpIgnore,
+		Value: arpIgnoreReplyOnlyIfTargetIPIsLocal, Reason: "ARP handling of service VIPs"})
 	if sysctlErr != nil {
 		klog.Error(sysctlErr.Error())
 	}
 
 	// https://github.com/kubernetes/kubernetes/pull/70530/files
-	sysctlErr = utils.SetSysctl(utils.IPv4ConfAllArpAnnounce, arpAnnounceUseBestLocalAddress)
+	sysctlErr = nsc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4ConfAllArpAnnounce,
+		Value: arpAnnounceUseBestLocalAddress, Reason: "ARP handling of service VIPs"})
 	if sysctlErr != nil {
 		klog.Error(sysctlErr.Error())
 	}
 
+	if nsc.conntrackAccounting {
+		nsc.ensureConntrackAccounting()
+	}
+
+	if err = nsc.ensureIpvsTimeouts(); err != nil {
+		klog.Error(err.Error())
+	}
+	if err = nsc.ensureIpvsSyncDaemons(); err != nil {
+		klog.Error(err.Error())
+	}
+
 	// https://github.com/cloudnativelabs/kube-router/issues/282
 	err = nsc.setupIpvsFirewall()
 	if err != nil {
 		klog.Error("Error setting up ipvs firewall: " + err.Error())
 	}
+	err = nsc.setupNodePortFirewall()
+	if err != nil {
+		klog.Error("Error setting up NodePort firewall: " + err.Error())
+	}
+	err = nsc.setupServiceRejectRule()
+	if err != nil {
+		klog.Error("Error setting up reject rule for services without endpoints: " + err.Error())
+	}
+	err = nsc.setupNoTrackRules()
+	if err != nil {
+		klog.Error("Error setting up NOTRACK rules: " + err.Error())
+	}
 	nsc.ProxyFirewallSetup.Broadcast()
 
+	// the IPVS services, their destinations and the VIPs of the dummy interface left by a previous run are reconciled
+	// by the initial sync rather than set up from scratch, which needs the FW marks they were given
+	nsc.restoreFWMarkMap()
+
 	gracefulTicker := time.NewTicker(gracefulTermServiceTickTime)
 	defer gracefulTicker.Stop()
 
@@ -438,6 +604,13 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 				nsc.gracefulSync()
 			}
 
+		case nodeIP := <-nsc.nodeIPChangeChan:
+			nsc.applyNodeIPChange(nodeIP)
+			klog.V(1).Infof("Performing full sync of services for the new node IP %s", nodeIP)
+			if err = nsc.doSync(); err != nil {
+				klog.Errorf("Error during full sync in network service controller. Error: " + err.Error())
+			}
+
 		case perform := <-nsc.syncChan:
 			healthcheck.SendHeartBeat(healthChan, "NSC")
 			switch perform {
@@ -460,6 +633,12 @@ func (nsc *NetworkServicesController) Run(healthChan chan<- *healthcheck.Control
 				err = nsc.syncHairpinIptablesRules()
 				if err != nil {
 					klog.Errorf("Error syncing hairpin iptables rules: %s", err.Error())
+					nsc.recordIptablesSyncFailure("hairpin", err)
+				}
+				err = nsc.syncNoMasqueradeRules(nsc.serviceMap, nsc.endpointsMap)
+				if err != nil {
+					klog.Errorf("Error syncing iptables rules exempting traffic from masquerading: %s", err.Error())
+					nsc.recordIptablesSyncFailure("no-masquerade", err)
 				}
 				nsc.mu.Unlock()
 			}
@@ -498,6 +677,7 @@ func (nsc *NetworkServicesController) doSync() error {
 	err = nsc.ensureMasqueradeIptablesRule()
 	if err != nil {
 		klog.Errorf("Failed to do add masquerade rule in POSTROUTING chain of nat table due to: %s", err.Error())
+		nsc.recordIptablesSyncFailure("masquerade", err)
 	}
 
 	nsc.serviceMap = nsc.buildServicesInfo()
@@ -505,6 +685,12 @@ func (nsc *NetworkServicesController) doSync() error {
 	err = nsc.syncHairpinIptablesRules()
 	if err != nil {
 		klog.Errorf("Error syncing hairpin iptables rules: %s", err.Error())
+		nsc.recordIptablesSyncFailure("hairpin", err)
+	}
+	err = nsc.syncNoMasqueradeRules(nsc.serviceMap, nsc.endpointsMap)
+	if err != nil {
+		klog.Errorf("Error syncing iptables rules exempting traffic from masquerading: %s", err.Error())
+		nsc.recordIptablesSyncFailure("no-masquerade", err)
 	}
 
 	err = nsc.syncIpvsServices(nsc.serviceMap, nsc.endpointsMap)
@@ -519,10 +705,22 @@ func (nsc *NetworkServicesController) doSync() error {
 			klog.Errorf("Error publishing metrics: %v", err)
 			return err
 		}
+		if nsc.conntrackAccounting {
+			if err = nsc.publishConntrackMetrics(); err != nil {
+				klog.Errorf("Error publishing conntrack metrics: %v", err)
+			}
+		}
 	}
 	return nil
 }
 
+// recordIptablesSyncFailure records a Warning Event on the node about the iptables rules of the services that couldn't
+// be synced
+func (nsc *NetworkServicesController) recordIptablesSyncFailure(rules string, err error) {
+	utils.RecordNodeWarning(nsc.eventRecorder, nsc.nodeHostName, utils.IptablesSyncFailedEventReason,
+		"Failed to sync the %s iptables rules of the services: %v", rules, err)
+}
+
 func getIpvsFirewallInputChainRule() []string {
 	// The iptables rule for use in {setup,cleanup}IpvsFirewall.
 	return []string{
@@ -531,6 +729,20 @@ func getIpvsFirewallInputChainRule() []string {
 		"-j", ipvsFirewallChainName}
 }
 
+// getIpvsFirewallICMPRules returns the rules of the IPVS firewall accepting the ICMP messages to service IPs.
+// Fragmentation needed messages are destination unreachable messages, which have to reach IPVS so that it can relay
+// them to the real server of the connection they relate to for path MTU discovery to work.
+func getIpvsFirewallICMPRules() [][]string {
+	return [][]string{
+		{"-m", "comment", "--comment", "allow icmp echo requests to service IPs",
+			"-p", "icmp", "--icmp-type", "echo-request", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "allow icmp destination unreachable messages to service IPs",
+			"-p", "icmp", "--icmp-type", "destination-unreachable", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "allow icmp ttl exceeded messages to service IPs",
+			"-p", "icmp", "--icmp-type", "time-exceeded", "-j", "ACCEPT"},
+	}
+}
+
 func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 	/*
 	   - create ipsets
@@ -605,31 +817,11 @@ func (nsc *NetworkServicesController) setupIpvsFirewall() error {
 		}
 	}
 
-	comment = "allow icmp echo requests to service IPs"
-	args = []string{"-m", "comment", "--comment", comment,
-		"-p", "icmp", "--icmp-type", "echo-request",
-		"-j", "ACCEPT"}
-	err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, args...)
-	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
-	}
-
-	comment = "allow icmp destination unreachable messages to service IPs"
-	args = []string{"-m", "comment", "--comment", comment,
-		"-p", "icmp", "--icmp-type", "destination-unreachable",
-		"-j", "ACCEPT"}
-	err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, args...)
-	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
-	}
-
-	comment = "allow icmp ttl exceeded messages to service IPs"
-	args = []string{"-m", "comment", "--comment", comment,
-		"-p", "icmp", "--icmp-type", "time-exceeded",
-		"-j", "ACCEPT"}
-	err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, args...)
-	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	for _, args = range getIpvsFirewallICMPRules() {
+		err = iptablesCmdHandler.AppendUnique("filter", ipvsFirewallChainName, args...)
+		if err != nil {
+			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		}
 	}
 
 	// We exclude the local addresses here as that would otherwise block all
@@ -691,6 +883,9 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 				klog.Errorf("Failed to run iptables command: %s", err.Error())
 			}
 		}
+
+		cleanupNodePortFirewallRules(iptablesCmdHandler)
+		cleanupServiceRejectRule(iptablesCmdHandler)
 	}
 
 	// For some reason, if we go too fast into the ipset logic below it causes the system to think that the above
@@ -741,9 +936,20 @@ func (nsc *NetworkServicesController) cleanupIpvsFirewall() {
 			klog.Errorf("failed to destroy ipset: %s", err.Error())
 		}
 	}
+
+	for _, ipSetName := range []string{nodePortsIPSetName, nodePortClientsIPSetName, serviceNoEndpointsIPSetName,
+		localPodCIDRsIPSetName} {
+		if _, ok := ipSetHandler.Sets[ipSetName]; ok {
+			err = ipSetHandler.Destroy(ipSetName)
+			if err != nil {
+				klog.Errorf("failed to destroy ipset: %s", err.Error())
+			}
+		}
+	}
 }
 
-func (nsc *NetworkServicesController) syncIpvsFirewall() error {
+func (nsc *NetworkServicesController) syncIpvsFirewall(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap) error {
 	/*
 	   - update ipsets based on currently active IPVS services
 	*/
@@ -772,6 +978,16 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 		return fmt.Errorf("failed to sync ipset: %s", err.Error())
 	}
 
+	err = nsc.syncNodePortFirewall(serviceInfoMap, localIPsSets)
+	if err != nil {
+		return err
+	}
+
+	err = nsc.syncServiceRejectRule(serviceInfoMap, endpointsInfoMap)
+	if err != nil {
+		return err
+	}
+
 	// Populate service ipsets.
 	ipvsServices, err := nsc.ln.ipvsGetServices()
 	if err != nil {
@@ -793,6 +1009,12 @@ func (nsc *NetworkServicesController) syncIpvsFirewall() error {
 				continue
 			}
 			port = int(ipvsService.Port)
+		} else if vip, proto, portRange, ok := nsc.lookupPortRangeByFWMark(ipvsService.FWMark); ok {
+			// ipset takes the ranges of ports of its hash:ip,port sets as first-last
+			serviceIPsSets = append(serviceIPsSets, vip)
+			ipvsServicesSets = append(ipvsServicesSets,
+				fmt.Sprintf("%s,%s:%s", vip, proto, strings.Replace(portRange, ":", "-", 1)))
+			continue
 		} else if ipvsService.FWMark != 0 {
 			address, protocol, port, err = nsc.lookupServiceByFWMark(ipvsService.FWMark)
 			if err != nil {
@@ -841,6 +1063,14 @@ func (nsc *NetworkServicesController) publishMetrics(serviceInfoMap serviceInfoM
 	}
 
 	klog.V(1).Info("Publishing IPVS metrics")
+	// the endpoint metrics are published again from scratch, to drop those of the endpoints that are gone
+	var podNames map[string]string
+	if nsc.endpointMetrics {
+		for _, metric := range ipvsEndpointMetrics {
+			metric.Reset()
+		}
+		podNames = nsc.podNamesByIP()
+	}
 	for _, svc := range serviceInfoMap {
 		var protocol uint16
 		var pushMetric bool
@@ -857,10 +1087,10 @@ func (nsc *NetworkServicesController) publishMetrics(serviceInfoMap serviceInfoM
 				} else {
 					pushMetric = false
 				}
-			case nsc.nodeIP.String():
+			case nsc.nodePortIP.String():
 				if protocol == ipvsSvc.Protocol && uint16(svc.port) == ipvsSvc.Port {
 					pushMetric = true
-					svcVip = nsc.nodeIP.String()
+					svcVip = nsc.nodePortIP.String()
 				} else {
 					pushMetric = false
 				}
@@ -885,17 +1115,25 @@ func (nsc *NetworkServicesController) publishMetrics(serviceInfoMap serviceInfoM
 				key := generateIPPortID(svcVip, svc.protocol, strconv.Itoa(svc.port))
 				nsc.metricsMap[key] = labelValues
 				// these same metrics should be deleted when the service is deleted.
-				metrics.ServiceBpsIn.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.BPSIn))
-				metrics.ServiceBpsOut.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.BPSOut))
 				metrics.ServiceBytesIn.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.BytesIn))
 				metrics.ServiceBytesOut.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.BytesOut))
-				metrics.ServiceCPS.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.CPS))
 				metrics.ServicePacketsIn.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.PacketsIn))
 				metrics.ServicePacketsOut.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.PacketsOut))
-				metrics.ServicePpsIn.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.PPSIn))
-				metrics.ServicePpsOut.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.PPSOut))
+				// the rates are computed by the estimator, without it they would always be reported as 0
+				if nsc.ipvsStatsEstimation {
+					metrics.ServiceBpsIn.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.BPSIn))
+					metrics.ServiceBpsOut.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.BPSOut))
+					metrics.ServiceCPS.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.CPS))
+					metrics.ServicePpsIn.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.PPSIn))
+					metrics.ServicePpsOut.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.PPSOut))
+				}
 				metrics.ServiceTotalConn.WithLabelValues(labelValues...).Set(float64(ipvsSvc.Stats.Connections))
 				metrics.ControllerIpvsServices.Set(float64(len(ipvsSvcs)))
+				if nsc.endpointMetrics {
+					if err = nsc.publishEndpointMetrics(ipvsSvc, labelValues, podNames); err != nil {
+						klog.Error(err.Error())
+					}
+				}
 			}
 		}
 	}
@@ -911,11 +1149,12 @@ func (nsc *NetworkServicesController) OnEndpointsUpdate(ep *api.Endpoints) {
 
 	nsc.mu.Lock()
 	defer nsc.mu.Unlock()
-	klog.V(1).Infof("Received update to endpoint: %s/%s from watch API", ep.Namespace, ep.Name)
+	nsc.logger.V(1).Info("Received update to endpoints from watch API",
+		utils.LogKeyNamespace, ep.Namespace, utils.LogKeyService, ep.Name)
 	if !nsc.readyForUpdates {
-		klog.V(3).Infof(
-			"Skipping update to endpoint: %s/%s as controller is not ready to process service and endpoints updates",
-			ep.Namespace, ep.Name)
+		nsc.logger.V(3).Info(
+			"Skipping update to endpoints as controller is not ready to process service and endpoints updates",
+			utils.LogKeyNamespace, ep.Namespace, utils.LogKeyService, ep.Name)
 		return
 	}
 
@@ -933,8 +1172,8 @@ func (nsc *NetworkServicesController) OnEndpointsUpdate(ep *api.Endpoints) {
 		return
 	}
 	if utils.ServiceIsHeadless(svc) {
-		klog.V(1).Infof("The service associated with endpoint: %s/%s is headless, skipping...",
-			ep.Namespace, ep.Name)
+		nsc.logger.V(1).Info("The service associated with endpoints is headless, skipping...",
+			utils.LogKeyNamespace, ep.Namespace, utils.LogKeyService, ep.Name)
 		return
 	}
 
@@ -945,11 +1184,12 @@ func (nsc *NetworkServicesController) OnEndpointsUpdate(ep *api.Endpoints) {
 	if !endpointsMapsEquivalent(newEndpointsMap, nsc.endpointsMap) {
 		nsc.endpointsMap = newEndpointsMap
 		nsc.serviceMap = newServiceMap
-		klog.V(1).Infof("Syncing IPVS services sync for update to endpoint: %s/%s", ep.Namespace, ep.Name)
+		nsc.logger.V(1).Info("Syncing IPVS services for update to endpoints",
+			utils.LogKeyNamespace, ep.Namespace, utils.LogKeyService, ep.Name)
 		nsc.sync(synctypeIpvs)
 	} else {
-		klog.V(1).Infof("Skipping IPVS services sync on endpoint: %s/%s update as nothing changed",
-			ep.Namespace, ep.Name)
+		nsc.logger.V(1).Info("Skipping IPVS services sync on endpoints update as nothing changed",
+			utils.LogKeyNamespace, ep.Namespace, utils.LogKeyService, ep.Name)
 	}
 }
 
@@ -959,11 +1199,12 @@ func (nsc *NetworkServicesController) OnServiceUpdate(svc *api.Service) {
 	nsc.mu.Lock()
 	defer nsc.mu.Unlock()
 
-	klog.V(1).Infof("Received update to service: %s/%s from watch API", svc.Namespace, svc.Name)
+	nsc.logger.V(1).Info("Received update to service from watch API",
+		utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
 	if !nsc.readyForUpdates {
-		klog.V(3).Infof(
-			"Skipping update to service: %s/%s as controller is not ready to process service and endpoints updates",
-			svc.Namespace, svc.Name)
+		nsc.logger.V(3).Info(
+			"Skipping update to service as controller is not ready to process service and endpoints updates",
+			utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
 		return
 	}
 
@@ -972,7 +1213,8 @@ func (nsc *NetworkServicesController) OnServiceUpdate(svc *api.Service) {
 	// need to consider previous versions of the service here as we are guaranteed if is a ClusterIP now, it was a
 	// ClusterIP before.
 	if utils.ServiceIsHeadless(svc) {
-		klog.V(1).Infof("%s/%s is headless, skipping...", svc.Namespace, svc.Name)
+		nsc.logger.V(1).Info("The service is headless, skipping...",
+			utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
 		return
 	}
 
@@ -983,11 +1225,12 @@ func (nsc *NetworkServicesController) OnServiceUpdate(svc *api.Service) {
 	if len(newServiceMap) != len(nsc.serviceMap) || !reflect.DeepEqual(newServiceMap, nsc.serviceMap) {
 		nsc.endpointsMap = newEndpointsMap
 		nsc.serviceMap = newServiceMap
-		klog.V(1).Infof("Syncing IPVS services sync on update to service: %s/%s", svc.Namespace, svc.Name)
+		nsc.logger.V(1).Info("Syncing IPVS services on update to service",
+			utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
 		nsc.sync(synctypeIpvs)
 	} else {
-		klog.V(1).Infof("Skipping syncing IPVS services for update to service: %s/%s as nothing changed",
-			svc.Namespace, svc.Name)
+		nsc.logger.V(1).Info("Skipping syncing IPVS services for update to service as nothing changed",
+			utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
 	}
 }
 
@@ -1015,10 +1258,12 @@ func (nsc *NetworkServicesController) getPodObjectForEndpoint(endpointIP string)
 // - get the container id from pod spec
 // - from the container id, use docker client to get the pid
 // - enter process network namespace and create ipip tunnel
+// - listen for GUE encapsulated traffic on guePort, unless it is 0
 // - add VIP to the tunnel interface
 // - disable rp_filter
 // WARN: This method is deprecated and will be removed once docker-shim is removed from kubelet.
-func (ln *linuxNetworking) prepareEndpointForDsrWithDocker(containerID string, endpointIP string, vip string) error {
+func (ln *linuxNetworking) prepareEndpointForDsrWithDocker(containerID string, endpointIP string,
+	vip, dsrMethod string, guePort uint16) error {
 
 	// Its possible switch namespaces may never work safely in GO without hacks.
 	//	 https://groups.google.com/forum/#!topic/golang-nuts/ss1gEOcehjk/discussion
@@ -1056,11 +1301,13 @@ func (ln *linuxNetworking) prepareEndpointForDsrWithDocker(containerID string, e
 	}
 
 	pid := containerSpec.State.Pid
-	return ln.configureContainerForDSR(vip, endpointIP, containerID, pid, hostNetworkNamespaceHandle)
+	return ln.configureContainerForDSR(vip, dsrMethod, guePort, endpointIP, containerID, pid,
+		hostNetworkNamespaceHandle)
 }
 
 // The same as prepareEndpointForDsr but using CRI instead of docker.
-func (ln *linuxNetworking) prepareEndpointForDsrWithCRI(runtimeEndpoint, containerID, endpointIP, vip string) error {
+func (ln *linuxNetworking) prepareEndpointForDsrWithCRI(runtimeEndpoint, containerID, endpointIP, vip,
+	dsrMethod string, guePort uint16) error {
 
 	// It's possible switch namespaces may never work safely in GO without hacks.
 	//	 https://groups.google.com/forum/#!topic/golang-nuts/ss1gEOcehjk/discussion
@@ -1094,7 +1341,8 @@ func (ln *linuxNetworking) prepareEndpointForDsrWithCRI(runtimeEndpoint, contain
 	}
 
 	pid := info.Pid
-	return ln.configureContainerForDSR(vip, endpointIP, containerID, pid, hostNetworkNamespaceHandle)
+	return ln.configureContainerForDSR(vip, dsrMethod, guePort, endpointIP, containerID, pid,
+		hostNetworkNamespaceHandle)
 }
 
 func (nsc *NetworkServicesController) buildServicesInfo() serviceInfoMap {
@@ -1114,6 +1362,12 @@ func (nsc *NetworkServicesController) buildServicesInfo() serviceInfoMap {
 			continue
 		}
 
+		// the external and LoadBalancer IPs of services pinned to other nodes aren't served by this node
+		servedByNode, err := utils.ServiceVIPsServedByNode(svc, nsc.nodeLabels)
+		if err != nil {
+			klog.Errorf("Not serving the external and LoadBalancer IPs of the service: %v", err)
+		}
+
 		for _, port := range svc.Spec.Ports {
 			svcInfo := serviceInfo{
 				clusterIP:   net.ParseIP(svc.Spec.ClusterIP),
@@ -1123,40 +1377,45 @@ func (nsc *NetworkServicesController) buildServicesInfo() serviceInfoMap {
 				nodePort:    int(port.NodePort),
 				name:        svc.ObjectMeta.Name,
 				namespace:   svc.ObjectMeta.Namespace,
-				externalIPs: make([]string, len(svc.Spec.ExternalIPs)),
+				externalIPs: make([]string, 0, len(svc.Spec.ExternalIPs)),
 				local:       false,
 			}
 			dsrMethod, ok := svc.ObjectMeta.Annotations[svcDSRAnnotation]
-			if ok {
+			switch {
+			case ok && svcInfo.protocol == sctpProtocol && !nsc.dsrSCTP:
+				nsc.logger.Info("Serving port of the SCTP service without DSR as the SCTP kernel modules are not "+
+					"available", "port", port.Port, utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
+			case ok:
 				svcInfo.directServerReturn = true
 				svcInfo.directServerReturnMethod = dsrMethod
+				svcInfo.dsrEncapsulation = parseDSREncapsulation(svc.Namespace, svc.Name,
+					svc.Annotations[svcDSREncapAnnotation], nsc.dsrEncapsulation)
 			}
 			svcInfo.scheduler = ipvs.RoundRobin
-			schedulingMethod, ok := svc.ObjectMeta.Annotations[svcSchedulerAnnotation]
-			if ok {
-				switch {
-				case schedulingMethod == ipvs.RoundRobin:
-					svcInfo.scheduler = ipvs.RoundRobin
-				case schedulingMethod == ipvs.LeastConnection:
-					svcInfo.scheduler = ipvs.LeastConnection
-				case schedulingMethod == ipvs.DestinationHashing:
-					svcInfo.scheduler = ipvs.DestinationHashing
-				case schedulingMethod == ipvs.SourceHashing:
-					svcInfo.scheduler = ipvs.SourceHashing
-				case schedulingMethod == IpvsMaglevHashing:
-					svcInfo.scheduler = IpvsMaglevHashing
+			if scheduler, ok := svc.ObjectMeta.Annotations[svcSchedulerAnnotation]; ok {
+				if ipvsSchedulers.Has(scheduler) {
+					svcInfo.scheduler = scheduler
+				} else {
+					nsc.logger.Info("Ignoring the unknown IPVS scheduler of service", "scheduler", scheduler,
+						utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
 				}
 			}
 
 			flags, ok := svc.ObjectMeta.Annotations[svcSchedFlagsAnnotation]
-			if ok && svcInfo.scheduler == IpvsMaglevHashing {
-				svcInfo.flags = parseSchedFlags(flags)
+			if _, hashing := schedFlagNames[svcInfo.scheduler]; ok && hashing {
+				var err error
+				if svcInfo.flags, err = parseSchedFlags(svcInfo.scheduler, flags); err != nil {
+					nsc.logger.Error(err, "Ignoring some scheduler flags of service",
+						utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
+				}
 			}
 
-			copy(svcInfo.externalIPs, svc.Spec.ExternalIPs)
-			for _, lbIngress := range svc.Status.LoadBalancer.Ingress {
-				if len(lbIngress.IP) > 0 {
-					svcInfo.loadBalancerIPs = append(svcInfo.loadBalancerIPs, lbIngress.IP)
+			if servedByNode {
+				svcInfo.externalIPs = append(svcInfo.externalIPs, svc.Spec.ExternalIPs...)
+				for _, lbIngress := range svc.Status.LoadBalancer.Ingress {
+					if len(lbIngress.IP) > 0 {
+						svcInfo.loadBalancerIPs = append(svcInfo.loadBalancerIPs, lbIngress.IP)
+					}
 				}
 			}
 			svcInfo.sessionAffinity = svc.Spec.SessionAffinity == api.ServiceAffinityClientIP
@@ -1171,38 +1430,63 @@ func (nsc *NetworkServicesController) buildServicesInfo() serviceInfoMap {
 			_, svcInfo.hairpinExternalIPs = svc.ObjectMeta.Annotations[svcHairpinExternalIPsAnnotation]
 			_, svcInfo.local = svc.ObjectMeta.Annotations[svcLocalAnnotation]
 			_, svcInfo.skipLbIps = svc.ObjectMeta.Annotations[svcSkipLbIpsAnnotation]
+			_, svcInfo.noMasquerade = svc.ObjectMeta.Annotations[svcNoMasqueradeAnnotation]
+			if portRange, ok := svc.ObjectMeta.Annotations[svcPortRangeAnnotation]; ok {
+				var err error
+				switch svcInfo.portRange, err = parsePortRange(portRange, svcInfo.port); {
+				case err != nil:
+					nsc.logger.Error(err, "Ignoring the port range of service",
+						utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
+				case svcInfo.portRange != "" && svcInfo.directServerReturn:
+					nsc.logger.Info("Ignoring the port range of the DSR service",
+						utils.LogKeyNamespace, svc.Namespace, utils.LogKeyService, svc.Name)
+					svcInfo.portRange = ""
+				}
+			}
 			if svc.Spec.ExternalTrafficPolicy == api.ServiceExternalTrafficPolicyTypeLocal {
 				svcInfo.local = true
 			}
+			svcInfo.nodePortAllowedCIDRs = nsc.nodePortAllowedCIDRs
+			if allowedCIDRs, ok := svc.ObjectMeta.Annotations[svcNodePortAllowedCIDRsAnnotation]; ok {
+				svcInfo.nodePortAllowedCIDRs = parseNodePortAllowedCIDRs(allowedCIDRs)
+			}
+			svcInfo.loadBalancerSourceRanges = parseLoadBalancerSourceRanges(svc.Namespace, svc.Name,
+				svc.Spec.LoadBalancerSourceRanges)
 
 			svcID := generateServiceID(svc.Namespace, svc.Name, port.Name)
 			serviceMap[svcID] = &svcInfo
 		}
 	}
+	nsc.addGatewayServiceInfo(serviceMap)
 	return serviceMap
 }
 
-func parseSchedFlags(value string) schedFlags {
-	var flag1, flag2, flag3 bool
-
-	if len(value) < 1 {
-		return schedFlags{}
-	}
-
-	flags := strings.Split(value, ",")
-	for _, flag := range flags {
-		switch strings.Trim(flag, " ") {
+// parseSchedFlags parses the comma separated scheduler flags of a service, flag-1, flag-2 and flag-3 or the names the
+// scheduler gives them. The flags that the scheduler doesn't have are returned in the error.
+func parseSchedFlags(scheduler, value string) (schedFlags, error) {
+	var flags schedFlags
+	var invalid []string
+	for _, flag := range strings.Split(value, ",") {
+		flag = strings.TrimSpace(flag)
+		if name, ok := schedFlagNames[scheduler][flag]; ok {
+			flag = name
+		}
+		switch flag {
+		case "":
 		case IpvsSvcFSched1:
-			flag1 = true
+			flags.flag1 = true
 		case IpvsSvcFSched2:
-			flag2 = true
+			flags.flag2 = true
 		case IpvsSvcFSched3:
-			flag3 = true
+			flags.flag3 = true
 		default:
+			invalid = append(invalid, flag)
 		}
 	}
-
-	return schedFlags{flag1, flag2, flag3}
+	if len(invalid) > 0 {
+		return flags, fmt.Errorf("unknown flags %s of the %s scheduler", strings.Join(invalid, ","), scheduler)
+	}
+	return flags, nil
 }
 
 func shuffle(endPoints []endpointsInfo) []endpointsInfo {
@@ -1231,6 +1515,8 @@ func (nsc *NetworkServicesController) buildEndpointsInfo() endpointsInfoMap {
 			}
 		}
 	}
+	nsc.addEndpointSliceInfo(endpointsMap)
+	nsc.addGatewayEndpointsInfo(endpointsMap)
 	return endpointsMap
 }
 
@@ -1242,8 +1528,12 @@ func (nsc *NetworkServicesController) ensureMasqueradeIptablesRule() error {
 	if err != nil {
 		return errors.New("Failed to initialize iptables executor" + err.Error())
 	}
+	// the versions of kube-router before the rules were tagged created them with an empty comment
+	if err = nsc.deleteMasqueradeIptablesRulesWithComments(nsc.nodeIP, ""); err != nil {
+		return err
+	}
 	var args = []string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
-		"-m", "comment", "--comment", "", "-j", "SNAT", "--to-source", nsc.nodeIP.String()}
+		"-m", "comment", "--comment", ipvsMasqueradeComment, "-j", "SNAT", "--to-source", nsc.nodeIP.String()}
 	if iptablesCmdHandler.HasRandomFully() {
 		args = append(args, "--random-fully")
 	}
@@ -1266,11 +1556,10 @@ func (nsc *NetworkServicesController) ensureMasqueradeIptablesRule() error {
 			klog.Infof("Deleted iptables rule to masquerade all outbound IVPS traffic.")
 		}
 	}
-	if len(nsc.podCidr) > 0 {
-		// TODO: ipset should be used for destination podCidr(s) match after multiple podCidr(s) per node get supported
-		args = []string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
-			"-m", "comment", "--comment", "", "!", "-s", nsc.podCidr, "!", "-d", nsc.podCidr,
-			"-j", "SNAT", "--to-source", nsc.nodeIP.String()}
+	if len(nsc.podCIDRs) > 0 {
+		args = append([]string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
+			"-m", "comment", "--comment", ipvsMasqueradeComment}, localPodCIDRsMatch...)
+		args = append(args, "-j", "SNAT", "--to-source", nsc.nodeIP.String())
 		if iptablesCmdHandler.HasRandomFully() {
 			args = append(args, "--random-fully")
 		}
@@ -1284,6 +1573,99 @@ func (nsc *NetworkServicesController) ensureMasqueradeIptablesRule() error {
 	return nil
 }
 
+// deleteMasqueradeIptablesRules deletes the iptables rules that masquerade outbound IPVS traffic to the given node IP
+func (nsc *NetworkServicesController) deleteMasqueradeIptablesRules(nodeIP net.IP) error {
+	return nsc.deleteMasqueradeIptablesRulesWithComments(nodeIP, ipvsMasqueradeComment, "")
+}
+
+// deleteMasqueradeIptablesRulesWithComments deletes the iptables rules that masquerade outbound IPVS traffic to the
+// given node IP and carry one of the given comments
+func (nsc *NetworkServicesController) deleteMasqueradeIptablesRulesWithComments(nodeIP net.IP,
+	comments ...string) error {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("Failed create iptables handler:" + err.Error())
+	}
+
+	var rules [][]string
+	for _, comment := range comments {
+		rules = append(rules, []string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
+			"-m", "comment", "--comment", comment, "-j", "SNAT", "--to-source", nodeIP.String()})
+		// the rules matching on the pod CIDRs of the node, through their ipset or the first CIDR like the versions of
+		// kube-router before the ipset did
+		for _, match := range append([][]string{localPodCIDRsMatch}, nsc.legacyPodCIDRMatches()...) {
+			rule := append([]string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
+				"-m", "comment", "--comment", comment}, match...)
+			rules = append(rules, append(rule, "-j", "SNAT", "--to-source", nodeIP.String()))
+		}
+	}
+	for _, args := range rules {
+		for _, ruleArgs := range [][]string{args, append(args, "--random-fully")} {
+			exists, err := iptablesCmdHandler.Exists("nat", "POSTROUTING", ruleArgs...)
+			if err != nil {
+				return fmt.Errorf("failed to lookup iptables rule: %s", err.Error())
+			}
+			if !exists {
+				continue
+			}
+			if err = iptablesCmdHandler.Delete("nat", "POSTROUTING", ruleArgs...); err != nil {
+				return fmt.Errorf("failed to delete iptables rule to masquerade outbound IPVS traffic to %s: %s",
+					nodeIP, err)
+			}
+		}
+	}
+	return nil
+}
+
+// onNodeIPChange hands the new node IP over to the sync goroutine without blocking the informer, a change that is
+// still pending is replaced by the new one
+func (nsc *NetworkServicesController) onNodeIPChange(nodeIP net.IP) {
+	select {
+	case <-nsc.nodeIPChangeChan:
+	default:
+	}
+	nsc.nodeIPChangeChan <- nodeIP
+}
+
+// applyNodeIPChange moves the NodePort services (unless they are served on another selected address) and the
+// masquerading of outbound IPVS traffic over to the new node IP. The IPVS services on the old node IP are cleaned up by
+// the full sync that follows as stale services.
+func (nsc *NetworkServicesController) applyNodeIPChange(nodeIP net.IP) {
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+	if nodeIP.Equal(nsc.nodeIP) {
+		return
+	}
+	oldNodeIP := nsc.nodeIP
+	nsc.nodeIP = nodeIP
+	if nsc.nodePortIPFromNode {
+		nsc.nodePortIP = nodeIP
+	}
+	if err := nsc.deleteMasqueradeIptablesRules(oldNodeIP); err != nil {
+		klog.Errorf("Failed to delete masquerade rules of the old node IP %s: %s", oldNodeIP, err.Error())
+	}
+}
+
+// onNodeUpdate resyncs the services when the labels of the node change, as they select the nodes that serve the
+// external and LoadBalancer IPs of the services pinned to some nodes and give the zone of the node
+func (nsc *NetworkServicesController) onNodeUpdate(obj interface{}) {
+	node, ok := obj.(*api.Node)
+	if !ok || node.Name != nsc.nodeHostName {
+		return
+	}
+	nsc.mu.Lock()
+	defer nsc.mu.Unlock()
+	if reflect.DeepEqual(nsc.nodeLabels, node.Labels) {
+		return
+	}
+	nsc.nodeLabels = node.Labels
+	nsc.serviceMap = nsc.buildServicesInfo()
+	// the topology hints of the endpoints depend on the zone of the node
+	nsc.endpointsMap = nsc.buildEndpointsInfo()
+	klog.V(1).Infof("Syncing IPVS services for the update of the labels of node %s", node.Name)
+	nsc.sync(synctypeIpvs)
+}
+
 // Delete old/bad iptables rules to masquerade outbound IPVS traffic.
 func (nsc *NetworkServicesController) deleteBadMasqueradeIptablesRules() error {
 	iptablesCmdHandler, err := iptables.New()
@@ -1296,9 +1678,10 @@ func (nsc *NetworkServicesController) deleteBadMasqueradeIptablesRules() error {
 			"-j", "MASQUERADE"},
 	}
 
-	if len(nsc.podCidr) > 0 {
-		argsBad = append(argsBad, []string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
-			"-m", "comment", "--comment", "", "!", "-s", nsc.podCidr, "!", "-d", nsc.podCidr, "-j", "MASQUERADE"})
+	for _, match := range nsc.legacyPodCIDRMatches() {
+		args := append([]string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
+			"-m", "comment", "--comment", ""}, match...)
+		argsBad = append(argsBad, append(args, "-j", "MASQUERADE"))
 	}
 
 	// If random fully is supported remove the original rules as well
@@ -1306,13 +1689,21 @@ func (nsc *NetworkServicesController) deleteBadMasqueradeIptablesRules() error {
 		argsBad = append(argsBad, []string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
 			"-m", "comment", "--comment", "", "-j", "SNAT", "--to-source", nsc.nodeIP.String()})
 
-		if len(nsc.podCidr) > 0 {
-			argsBad = append(argsBad, []string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
-				"-m", "comment", "--comment", "",
-				"!", "-s", nsc.podCidr, "!", "-d", nsc.podCidr, "-j", "SNAT", "--to-source", nsc.nodeIP.String()})
+		for _, match := range nsc.legacyPodCIDRMatches() {
+			args := append([]string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
+				"-m", "comment", "--comment", ""}, match...)
+			argsBad = append(argsBad, append(args, "-j", "SNAT", "--to-source", nsc.nodeIP.String()))
 		}
 	}
 
+	// the rules of the versions of kube-router that matched on the first pod CIDR of the node instead of its ipset
+	for _, match := range nsc.legacyPodCIDRMatches() {
+		args := append([]string{"-m", "ipvs", "--ipvs", "--vdir", "ORIGINAL", "--vmethod", "MASQ",
+			"-m", "comment", "--comment", ipvsMasqueradeComment}, match...)
+		args = append(args, "-j", "SNAT", "--to-source", nsc.nodeIP.String())
+		argsBad = append(argsBad, args, append(args, "--random-fully"))
+	}
+
 	for _, args := range argsBad {
 		exists, err := iptablesCmdHandler.Exists("nat", "POSTROUTING", args...)
 		if err != nil {
@@ -1360,9 +1751,11 @@ func (nsc *NetworkServicesController) syncHairpinIptablesRules() error {
 					continue
 				}
 
-				// Handle ClusterIP Service
-				rule, ruleArgs := hairpinRuleFrom(svcInfo.clusterIP.String(), ep.ip, svcInfo.port)
-				rulesNeeded[rule] = ruleArgs
+				// Handle ClusterIP Service, the listeners of the Gateways have none
+				if svcInfo.clusterIP != nil {
+					rule, ruleArgs := hairpinRuleFrom(svcInfo.clusterIP.String(), ep.ip, svcInfo.port)
+					rulesNeeded[rule] = ruleArgs
+				}
 
 				// Handle ExternalIPs if requested
 				if svcInfo.hairpinExternalIPs {
@@ -1374,7 +1767,7 @@ func (nsc *NetworkServicesController) syncHairpinIptablesRules() error {
 
 				// Handle NodePort Service
 				if svcInfo.nodePort != 0 {
-					rule, ruleArgs := hairpinRuleFrom(nsc.nodeIP.String(), ep.ip, svcInfo.nodePort)
+					rule, ruleArgs := hairpinRuleFrom(nsc.nodePortIP.String(), ep.ip, svcInfo.nodePort)
 					rulesNeeded[rule] = ruleArgs
 				}
 			}
@@ -1554,15 +1947,21 @@ func deleteMasqueradeIptablesRule() error {
 	if err != nil {
 		return errors.New("Failed to list iptables rules in POSTROUTING chain in nat table" + err.Error())
 	}
-	for i, rule := range postRoutingChainRules {
-		if strings.Contains(rule, "ipvs") && strings.Contains(rule, "SNAT") {
-			err = iptablesCmdHandler.Delete("nat", "POSTROUTING", strconv.Itoa(i))
-			if err != nil {
-				return errors.New("Failed to run iptables command" + err.Error())
-			}
-			klog.V(2).Infof("Deleted iptables masquerade rule: %s", rule)
-			break
+	// the rules are deleted by their position starting from the last one, so that the positions of the others don't
+	// change, and only the rules kube-router added, including the ones of the versions which didn't tag them yet
+	for i := len(postRoutingChainRules) - 1; i > 0; i-- {
+		rule := postRoutingChainRules[i]
+		if !strings.Contains(rule, "ipvs") || !strings.Contains(rule, "SNAT") {
+			continue
+		}
+		if !utils.IsOwnedRule(rule) && !strings.Contains(rule, `--comment ""`) {
+			continue
 		}
+		err = iptablesCmdHandler.Delete("nat", "POSTROUTING", strconv.Itoa(i))
+		if err != nil {
+			return errors.New("Failed to run iptables command" + err.Error())
+		}
+		klog.V(2).Infof("Deleted iptables masquerade rule: %s", rule)
 	}
 	return nil
 }
@@ -1814,13 +2213,33 @@ func (ln *linuxNetworking) ipvsAddServer(service *ipvs.Service, dest *ipvs.Desti
 	return nil
 }
 
+// mangleTableRuleArgs returns the arguments of the rule marking the traffic to the VIP with the FWMARK of its IPVS
+// service and of the rules clamping the MSS of the TCP connections to and from the VIP, tagged with the owner or, like
+// the older versions of kube-router created them, untagged
+func mangleTableRuleArgs(ip, protocol, port, fwmark string, tcpMSS int,
+	tagged bool) (markArgs, mssToArgs, mssFromArgs []string) {
+	tag := func(comment string) []string {
+		if !tagged {
+			return []string{}
+		}
+		return []string{"-m", "comment", "--comment", utils.OwnerComment + " " + comment}
+	}
+	markArgs = append(tag("mark traffic to VIP with its FWMARK"),
+		"-d", ip, "-m", protocol, "-p", protocol, "--dport", port, "-j", "MARK", "--set-mark", fwmark)
+	mssArgs := []string{"-m", tcpProtocol, "-p", tcpProtocol, "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS",
+		"--set-mss", strconv.Itoa(tcpMSS)}
+	mssToArgs = append(append(tag("clamp MSS of DSR traffic"), "-d", ip), mssArgs...)
+	mssFromArgs = append(append(tag("clamp MSS of DSR traffic"), "-s", ip), mssArgs...)
+	return markArgs, mssToArgs, mssFromArgs
+}
+
 // setupMangleTableRule: sets up iptables rule to FWMARK the traffic to external IP vip
 func setupMangleTableRule(ip string, protocol string, port string, fwmark string, tcpMSS int) error {
 	iptablesCmdHandler, err := iptables.New()
 	if err != nil {
 		return errors.New("Failed to initialize iptables executor" + err.Error())
 	}
-	args := []string{"-d", ip, "-m", protocol, "-p", protocol, "--dport", port, "-j", "MARK", "--set-mark", fwmark}
+	args, mtuToArgs, mtuFromArgs := mangleTableRuleArgs(ip, protocol, port, fwmark, tcpMSS, true)
 	err = iptablesCmdHandler.AppendUnique("mangle", "PREROUTING", args...)
 	if err != nil {
 		return errors.New("Failed to run iptables command to set up FWMARK due to " + err.Error())
@@ -1831,14 +2250,11 @@ func setupMangleTableRule(ip string, protocol string, port string, fwmark string
 	}
 
 	// setup iptables rule TCPMSS for DSR mode to fix mtu problem
-	mtuArgs := []string{"-d", ip, "-m", tcpProtocol, "-p", tcpProtocol, "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS",
-		"--set-mss", strconv.Itoa(tcpMSS)}
-	err = iptablesCmdHandler.AppendUnique("mangle", "PREROUTING", mtuArgs...)
+	err = iptablesCmdHandler.AppendUnique("mangle", "PREROUTING", mtuToArgs...)
 	if err != nil {
 		return errors.New("Failed to run iptables command to set up TCPMSS due to " + err.Error())
 	}
-	mtuArgs[0] = "-s"
-	err = iptablesCmdHandler.AppendUnique("mangle", "POSTROUTING", mtuArgs...)
+	err = iptablesCmdHandler.AppendUnique("mangle", "POSTROUTING", mtuFromArgs...)
 	if err != nil {
 		return errors.New("Failed to run iptables command to set up TCPMSS due to " + err.Error())
 	}
@@ -1851,54 +2267,35 @@ func (ln *linuxNetworking) cleanupMangleTableRule(ip string, protocol string, po
 	if err != nil {
 		return errors.New("Failed to initialize iptables executor" + err.Error())
 	}
-	args := []string{"-d", ip, "-m", protocol, "-p", protocol, "--dport", port, "-j", "MARK", "--set-mark", fwmark}
-	exists, err := iptablesCmdHandler.Exists("mangle", "PREROUTING", args...)
-	if err != nil {
-		return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
-	}
-	if exists {
-		klog.V(2).Infof("removing mangle rule with: iptables -D PREROUTING -t mangle %s", args)
-		err = iptablesCmdHandler.Delete("mangle", "PREROUTING", args...)
-		if err != nil {
-			return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
-		}
-	}
-	exists, err = iptablesCmdHandler.Exists("mangle", "OUTPUT", args...)
-	if err != nil {
-		return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
-	}
-	if exists {
-		klog.V(2).Infof("removing mangle rule with: iptables -D OUTPUT -t mangle %s", args)
-		err = iptablesCmdHandler.Delete("mangle", "OUTPUT", args...)
-		if err != nil {
-			return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
+	for _, tagged := range []bool{true, false} {
+		args, mtuToArgs, mtuFromArgs := mangleTableRuleArgs(ip, protocol, port, fwmark, tcpMSS, tagged)
+		for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+			exists, err := iptablesCmdHandler.Exists("mangle", chain, args...)
+			if err != nil {
+				return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
+			}
+			if exists {
+				klog.V(2).Infof("removing mangle rule with: iptables -D %s -t mangle %s", chain, args)
+				err = iptablesCmdHandler.Delete("mangle", chain, args...)
+				if err != nil {
+					return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
+				}
+			}
 		}
-	}
 
-	// cleanup iptables rule TCPMSS
-	mtuArgs := []string{"-d", ip, "-m", tcpProtocol, "-p", tcpProtocol, "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS",
-		"--set-mss", strconv.Itoa(tcpMSS)}
-	exists, err = iptablesCmdHandler.Exists("mangle", "PREROUTING", mtuArgs...)
-	if err != nil {
-		return errors.New("Failed to cleanup iptables command to set up TCPMSS due to " + err.Error())
-	}
-	if exists {
-		klog.V(2).Infof("removing mangle rule with: iptables -D PREROUTING -t mangle %s", args)
-		err = iptablesCmdHandler.Delete("mangle", "PREROUTING", mtuArgs...)
-		if err != nil {
-			return errors.New("Failed to cleanup iptables command to set up TCPMSS due to " + err.Error())
-		}
-	}
-	mtuArgs[0] = "-s"
-	exists, err = iptablesCmdHandler.Exists("mangle", "POSTROUTING", mtuArgs...)
-	if err != nil {
-		return errors.New("Failed to cleanup iptables command to set up TCPMSS due to " + err.Error())
-	}
-	if exists {
-		klog.V(2).Infof("removing mangle rule with: iptables -D POSTROUTING -t mangle %s", args)
-		err = iptablesCmdHandler.Delete("mangle", "POSTROUTING", mtuArgs...)
-		if err != nil {
-			return errors.New("Failed to cleanup iptables command to set up TCPMSS due to " + err.Error())
+		// cleanup iptables rule TCPMSS
+		for chain, mtuArgs := range map[string][]string{"PREROUTING": mtuToArgs, "POSTROUTING": mtuFromArgs} {
+			exists, err := iptablesCmdHandler.Exists("mangle", chain, mtuArgs...)
+			if err != nil {
+				return errors.New("Failed to cleanup iptables command to set up TCPMSS due to " + err.Error())
+			}
+			if exists {
+				klog.V(2).Infof("removing mangle rule with: iptables -D %s -t mangle %s", chain, mtuArgs)
+				err = iptablesCmdHandler.Delete("mangle", chain, mtuArgs...)
+				if err != nil {
+					return errors.New("Failed to cleanup iptables command to set up TCPMSS due to " + err.Error())
+				}
+			}
 		}
 	}
 
@@ -2088,6 +2485,41 @@ func getAllLocalIPs() ([]netlink.Addr, error) {
 	return addrs, nil
 }
 
+// getNodePortIPs returns the addresses of the node NodePort services are served on: its NodePort address, or all of
+// its addresses with --nodeport-bindon-all-ip, the ones within --nodeport-addresses when it is set
+func (nsc *NetworkServicesController) getNodePortIPs() ([]string, error) {
+	if !nsc.nodeportBindOnAllIP && len(nsc.nodePortAddresses) == 0 {
+		return []string{nsc.nodePortIP.String()}, nil
+	}
+	addrs, err := getAllLocalIPs()
+	if err != nil {
+		return nil, err
+	}
+	localIPs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		localIPs = append(localIPs, addr.IP.String())
+	}
+	return selectNodePortIPs(localIPs, nsc.nodePortAddresses), nil
+}
+
+// selectNodePortIPs returns the addresses within the CIDRs, all of them when no CIDR is given
+func selectNodePortIPs(localIPs []string, cidrs []*net.IPNet) []string {
+	if len(cidrs) == 0 {
+		return localIPs
+	}
+	selected := make([]string, 0, len(localIPs))
+	for _, localIP := range localIPs {
+		ip := net.ParseIP(localIP)
+		for _, cidr := range cidrs {
+			if cidr.Contains(ip) {
+				selected = append(selected, localIP)
+				break
+			}
+		}
+	}
+	return selected
+}
+
 func (ln *linuxNetworking) getKubeDummyInterface() (netlink.Link, error) {
 	var dummyVipInterface netlink.Link
 	dummyVipInterface, err := netlink.LinkByName(KubeDummyIf)
@@ -2144,6 +2576,10 @@ func (nsc *NetworkServicesController) Cleanup() {
 
 	nsc.cleanupIpvsFirewall()
 
+	cleanupNoTrackRules()
+
+	cleanupNoMasqueradeRules()
+
 	// delete dummy interface used to assign cluster IP's
 	dummyVipInterface, err := netlink.LinkByName(KubeDummyIf)
 	if err != nil {
@@ -2272,7 +2708,7 @@ func (nsc *NetworkServicesController) handleServiceDelete(obj interface{}) {
 func NewNetworkServicesController(clientset kubernetes.Interface,
 	config *options.KubeRouterConfig, svcInformer cache.SharedIndexInformer,
 	epInformer cache.SharedIndexInformer, podInformer cache.SharedIndexInformer,
-	ipsetMutex *sync.Mutex) (*NetworkServicesController, error) {
+	ipsetMutex *sync.Mutex, sysctls *utils.SysctlManager) (*NetworkServicesController, error) {
 
 	var err error
 	ln, err := newLinuxNetworking()
@@ -2280,8 +2716,8 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 		return nil, err
 	}
 
-	nsc := NetworkServicesController{ln: ln, ipsetMutex: ipsetMutex, metricsMap: make(map[string][]string),
-		fwMarkMap: map[uint32]string{}}
+	nsc := NetworkServicesController{ln: ln, ipsetMutex: ipsetMutex, sysctls: sysctls,
+		metricsMap: make(map[string][]string), fwMarkMap: map[uint32]string{}, logger: utils.ControllerLogger("NSC")}
 
 	if config.MetricsEnabled {
 		// Register the metrics for this controller
@@ -2297,14 +2733,48 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 		prometheus.MustRegister(metrics.ServicePpsIn)
 		prometheus.MustRegister(metrics.ServicePpsOut)
 		prometheus.MustRegister(metrics.ServiceTotalConn)
+		if config.ConntrackAccounting {
+			for _, metric := range conntrackMetrics {
+				prometheus.MustRegister(metric)
+			}
+		}
+		if config.MetricsIpvsEndpoints {
+			for _, metric := range ipvsEndpointMetrics {
+				prometheus.MustRegister(metric)
+			}
+			nsc.endpointMetrics = true
+		}
 		nsc.MetricsEnabled = true
 	}
 
 	nsc.syncPeriod = config.IpvsSyncPeriod
 	nsc.syncChan = make(chan int, 2)
+	nsc.nodeIPChangeChan = make(chan net.IP, 1)
 	nsc.gracefulPeriod = config.IpvsGracefulPeriod
 	nsc.gracefulTermination = config.IpvsGracefulTermination
+	if config.IpvsSlowStartPeriod > 0 {
+		nsc.slowStart = newSlowStart(config.IpvsSlowStartPeriod)
+	}
+	nsc.ipvsTimeouts, err = newIpvsTimeouts(config.IpvsTCPTimeout, config.IpvsTCPFinTimeout, config.IpvsUDPTimeout)
+	if err != nil {
+		return nil, err
+	}
+	nsc.ipvsSyncDaemon, err = newIpvsSyncDaemon(config.IpvsSyncDaemonInterface, config.IpvsSyncDaemonID)
+	if err != nil {
+		return nil, err
+	}
+	nsc.conntrackAccounting = config.ConntrackAccounting
+	nsc.longLivedFlowAge = config.ConntrackLongLivedAge
 	nsc.globalHairpin = config.GlobalHairpinMode
+	nsc.ndpProxy = config.EnableNDPProxy
+	nsc.ndpProxyVIPs = sets.NewString()
+	for _, cidr := range config.LoadBalancerCIDRs {
+		if _, ipRange, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil && ipRange.IP.To4() == nil {
+			nsc.ndpProxyRanges = append(nsc.ndpProxyRanges, ipRange)
+		}
+	}
+	nsc.announceVIPs = config.AnnounceVIPs
+	nsc.announcedVIPs = sets.NewString()
 
 	nsc.serviceMap = make(serviceInfoMap)
 	nsc.endpointsMap = make(endpointsInfoMap)
@@ -2323,11 +2793,53 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	}
 
 	if config.RunRouter {
-		cidr, err := utils.GetPodCidrFromNodeSpec(nsc.client, config.HostnameOverride)
+		cidrs, err := utils.GetPodCIDRsFromNodeSpec(nsc.client, config.HostnameOverride)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get pod CIDR details from Node.spec: %s", err.Error())
 		}
-		nsc.podCidr = cidr
+		nsc.podCIDRs = ipv4PodCIDRs(cidrs)
+	}
+
+	for _, nodePortAddress := range config.NodePortAddresses {
+		ip, ipnet, err := net.ParseCIDR(nodePortAddress)
+		if err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 CIDR %q given with --nodeport-addresses", nodePortAddress)
+		}
+		nsc.nodePortAddresses = append(nsc.nodePortAddresses, ipnet)
+	}
+
+	nsc.nodePortAllowedCIDRs = make([]string, 0, len(config.NodePortAllowedCIDRs))
+	for _, allowedCIDR := range config.NodePortAllowedCIDRs {
+		ip, ipnet, err := net.ParseCIDR(allowedCIDR)
+		if err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 NodePort client CIDR %q given with --nodeport-allowed-cidrs",
+				allowedCIDR)
+		}
+		nsc.nodePortAllowedCIDRs = append(nsc.nodePortAllowedCIDRs, ipnet.String())
+	}
+
+	for _, noMasqueradeCIDR := range config.NoMasqueradeCIDRs {
+		ip, ipnet, err := net.ParseCIDR(noMasqueradeCIDR)
+		if err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 CIDR %q given with --no-masquerade-cidrs", noMasqueradeCIDR)
+		}
+		nsc.noMasquerade.cidrs = append(nsc.noMasquerade.cidrs, ipnet)
+	}
+
+	nsc.noTrack.nodeLocalDNSIP = config.NodeLocalDNSIP
+	for _, noTrackCIDR := range config.NoTrackCIDRs {
+		_, ipnet, err := net.ParseCIDR(noTrackCIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q given with --notrack-cidrs: %s", noTrackCIDR, err.Error())
+		}
+		nsc.noTrack.cidrs = append(nsc.noTrack.cidrs, ipnet)
+	}
+	for _, noTrackPort := range config.NoTrackPorts {
+		port, err := parseNoTrackPort(noTrackPort)
+		if err != nil {
+			return nil, err
+		}
+		nsc.noTrack.ports = append(nsc.noTrack.ports, port)
 	}
 
 	nsc.excludedCidrs = make([]net.IPNet, len(config.ExcludedCidrs))
@@ -2345,11 +2857,27 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	}
 
 	nsc.nodeHostName = node.Name
-	NodeIP, err = utils.GetNodeIP(node)
+	nsc.eventRecorder = utils.NewEventRecorder(clientset, nsc.nodeHostName)
+	nsc.nodeLabels = node.Labels
+	nsc.nodeIP, err = utils.GetNodeIP(node)
 	if err != nil {
 		return nil, err
 	}
-	nsc.nodeIP = NodeIP
+	nsc.nodePortIP, err = utils.SelectNodeAddress(node, nodePortInterfaceAnnotation, config.NodePortInterface,
+		nsc.nodeIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select the NodePort address of the node: %v", err)
+	}
+	nsc.nodePortIPFromNode = nsc.nodePortIP.Equal(nsc.nodeIP)
+	if podCIDRs, err := utils.GetPodCIDRsFromNode(node); err == nil {
+		for _, podCIDR := range podCIDRs {
+			if ip, _, err := net.ParseCIDR(podCIDR); err == nil && ip.To4() != nil {
+				nsc.noMasquerade.podCIDRs = append(nsc.noMasquerade.podCIDRs, podCIDR)
+			}
+		}
+	} else if len(nsc.noMasquerade.cidrs) > 0 {
+		klog.Warningf("Not exempting the traffic to --no-masquerade-cidrs from masquerading: %v", err)
+	}
 	automtu, err := utils.GetMTUFromNodeIP(nsc.nodeIP)
 	if err != nil {
 		return nil, err
@@ -2359,6 +2887,18 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	// remove 60 bytes (internet headers and additional ip-ip because MTU includes internet headers. MSS does not.)
 	// This needs also a condition to deal with auto-mtu=false
 	nsc.dsrTCPMSS = automtu - utils.IPInIPHeaderLength*3
+	// The traffic to DSR services is marked by the ports of their protocol, which for SCTP needs the SCTP match of
+	// iptables, and the endpoints need SCTP support to accept the tunneled traffic. Without them the SCTP services
+	// are served without DSR.
+	nsc.dsrSCTP = utils.EnsureKernelModules(sctpDSRKernelModules) == nil
+	if config.DSREncapsulation != dsrEncapIPIP && config.DSREncapsulation != dsrEncapGUE {
+		return nil, errors.New("DSREncapsulation must be either " + dsrEncapIPIP + " or " + dsrEncapGUE)
+	}
+	if config.DSRGUEPort == 0 {
+		return nil, errors.New("DSRGUEPort must be positive")
+	}
+	nsc.dsrEncapsulation = config.DSREncapsulation
+	nsc.guePort = config.DSRGUEPort
 
 	nsc.podLister = podInformer.GetIndexer()
 
@@ -2366,10 +2906,19 @@ func NewNetworkServicesController(clientset kubernetes.Interface,
 	nsc.ServiceEventHandler = nsc.newSvcEventHandler()
 
 	nsc.ipvsPermitAll = config.IpvsPermitAll
+	nsc.ipvsStatsEstimation = config.IpvsStatsEstimation
 
 	nsc.epLister = epInformer.GetIndexer()
 	nsc.EndpointsEventHandler = nsc.newEndpointsEventHandler()
 
+	nodeIPEventHandler := utils.NewLocalNodeIPEventHandler(nsc.nodeHostName, nsc.onNodeIPChange)
+	nsc.NodeEventHandler = cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			nodeIPEventHandler.OnUpdate(oldObj, newObj)
+			nsc.onNodeUpdate(newObj)
+		},
+	}
+
 	rand.Seed(time.Now().UnixNano())
 
 	return &nsc, nil
@@ -24,16 +24,16 @@ var _ LinuxNetworking = &LinuxNetworkingMock{}
 // 			cleanupMangleTableRuleFunc: func(ip string, protocol string, port string, fwmark string, tcpMSS int) error {
 // 				panic("mock out the cleanupMangleTableRule method")
 // 			},
-// 			configureContainerForDSRFunc: func(vip string, endpointIP string, containerID string, pid int, hostNetworkNamespaceHandle netns.NsHandle) error {
+// 			configureContainerForDSRFunc: func(vip string, dsrMethod string, guePort uint16, endpointIP string, containerID string, pid int, hostNetworkNamespaceHandle netns.NsHandle) error {
 // 				panic("mock out the configureContainerForDSR method")
 // 			},
 // 			getKubeDummyInterfaceFunc: func() (netlink.Link, error) {
 // 				panic("mock out the getKubeDummyInterface method")
 // 			},
-// 			ipAddrAddFunc: func(iface netlink.Link, ip string, addRoute bool) error {
+// 			ipAddrAddFunc: func(iface netlink.Link, ip string, nodeIP string, addRoute bool) error {
 // 				panic("mock out the ipAddrAdd method")
 // 			},
-// 			ipAddrDelFunc: func(iface netlink.Link, ip string) error {
+// 			ipAddrDelFunc: func(iface netlink.Link, ip string, nodeIP string) error {
 // 				panic("mock out the ipAddrDel method")
 // 			},
 // 			ipvsAddFWMarkServiceFunc: func(svcs []*ipvs.Service, fwMark uint32, protocol uint16, port uint16, persistent bool, persistentTimeout int32, scheduler string, flags schedFlags) (*ipvs.Service, error) {
@@ -69,10 +69,10 @@ var _ LinuxNetworking = &LinuxNetworkingMock{}
 // 			ipvsUpdateServiceFunc: func(ipvsSvc *ipvs.Service) error {
 // 				panic("mock out the ipvsUpdateService method")
 // 			},
-// 			prepareEndpointForDsrWithCRIFunc: func(runtimeEndpoint string, containerID string, endpointIP string, vip string) error {
+// 			prepareEndpointForDsrWithCRIFunc: func(runtimeEndpoint string, containerID string, endpointIP string, vip string, dsrMethod string, guePort uint16) error {
 // 				panic("mock out the prepareEndpointForDsrWithCRI method")
 // 			},
-// 			prepareEndpointForDsrWithDockerFunc: func(containerID string, endpointIP string, vip string) error {
+// 			prepareEndpointForDsrWithDockerFunc: func(containerID string, endpointIP string, vip string, dsrMethod string, guePort uint16) error {
 // 				panic("mock out the prepareEndpointForDsrWithDocker method")
 // 			},
 // 			setupPolicyRoutingForDSRFunc: func() error {
@@ -92,16 +92,16 @@ type LinuxNetworkingMock struct {
 	cleanupMangleTableRuleFunc func(ip string, protocol string, port string, fwmark string, tcpMSS int) error
 
 	// configureContainerForDSRFunc mocks the configureContainerForDSR method.
-	configureContainerForDSRFunc func(vip string, endpointIP string, containerID string, pid int, hostNetworkNamespaceHandle netns.NsHandle) error
+	configureContainerForDSRFunc func(vip string, dsrMethod string, guePort uint16, endpointIP string, containerID string, pid int, hostNetworkNamespaceHandle netns.NsHandle) error
 
 	// getKubeDummyInterfaceFunc mocks the getKubeDummyInterface method.
 	getKubeDummyInterfaceFunc func() (netlink.Link, error)
 
 	// ipAddrAddFunc mocks the ipAddrAdd method.
-	ipAddrAddFunc func(iface netlink.Link, ip string, addRoute bool) error
+	ipAddrAddFunc func(iface netlink.Link, ip string, nodeIP string, addRoute bool) error
 
 	// ipAddrDelFunc mocks the ipAddrDel method.
-	ipAddrDelFunc func(iface netlink.Link, ip string) error
+	ipAddrDelFunc func(iface netlink.Link, ip string, nodeIP string) error
 
 	// ipvsAddFWMarkServiceFunc mocks the ipvsAddFWMarkService method.
 	ipvsAddFWMarkServiceFunc func(svcs []*ipvs.Service, fwMark uint32, protocol uint16, port uint16, persistent bool, persistentTimeout int32, scheduler string, flags schedFlags) (*ipvs.Service, error)
@@ -137,10 +137,10 @@ type LinuxNetworkingMock struct {
 	ipvsUpdateServiceFunc func(ipvsSvc *ipvs.Service) error
 
 	// prepareEndpointForDsrWithCRIFunc mocks the prepareEndpointForDsrWithCRI method.
-	prepareEndpointForDsrWithCRIFunc func(runtimeEndpoint string, containerID string, endpointIP string, vip string) error
+	prepareEndpointForDsrWithCRIFunc func(runtimeEndpoint string, containerID string, endpointIP string, vip string, dsrMethod string, guePort uint16) error
 
 	// prepareEndpointForDsrWithDockerFunc mocks the prepareEndpointForDsrWithDocker method.
-	prepareEndpointForDsrWithDockerFunc func(containerID string, endpointIP string, vip string) error
+	prepareEndpointForDsrWithDockerFunc func(containerID string, endpointIP string, vip string, dsrMethod string, guePort uint16) error
 
 	// setupPolicyRoutingForDSRFunc mocks the setupPolicyRoutingForDSR method.
 	setupPolicyRoutingForDSRFunc func() error
@@ -167,6 +167,10 @@ type LinuxNetworkingMock struct {
 		configureContainerForDSR []struct {
 			// Vip is the vip argument value.
 			Vip string
+			// DsrMethod is the dsrMethod argument value.
+			DsrMethod string
+			// GuePort is the guePort argument value.
+			GuePort uint16
 			// EndpointIP is the endpointIP argument value.
 			EndpointIP string
 			// ContainerID is the containerID argument value.
@@ -185,6 +189,8 @@ type LinuxNetworkingMock struct {
 			Iface netlink.Link
 			// IP is the ip argument value.
 			IP string
+			// NodeIP is the nodeIP argument value.
+			NodeIP string
 			// AddRoute is the addRoute argument value.
 			AddRoute bool
 		}
@@ -194,6 +200,8 @@ type LinuxNetworkingMock struct {
 			Iface netlink.Link
 			// IP is the ip argument value.
 			IP string
+			// NodeIP is the nodeIP argument value.
+			NodeIP string
 		}
 		// ipvsAddFWMarkService holds details about calls to the ipvsAddFWMarkService method.
 		ipvsAddFWMarkService []struct {
@@ -294,6 +302,10 @@ type LinuxNetworkingMock struct {
 			EndpointIP string
 			// Vip is the vip argument value.
 			Vip string
+			// DsrMethod is the dsrMethod argument value.
+			DsrMethod string
+			// GuePort is the guePort argument value.
+			GuePort uint16
 		}
 		// prepareEndpointForDsrWithDocker holds details about calls to the prepareEndpointForDsrWithDocker method.
 		prepareEndpointForDsrWithDocker []struct {
@@ -303,6 +315,10 @@ type LinuxNetworkingMock struct {
 			EndpointIP string
 			// Vip is the vip argument value.
 			Vip string
+			// DsrMethod is the dsrMethod argument value.
+			DsrMethod string
+			// GuePort is the guePort argument value.
+			GuePort uint16
 		}
 		// setupPolicyRoutingForDSR holds details about calls to the setupPolicyRoutingForDSR method.
 		setupPolicyRoutingForDSR []struct {
@@ -383,18 +399,22 @@ func (mock *LinuxNetworkingMock) cleanupMangleTableRuleCalls() []struct {
 }
 
 // configureContainerForDSR calls configureContainerForDSRFunc.
-func (mock *LinuxNetworkingMock) configureContainerForDSR(vip string, endpointIP string, containerID string, pid int, hostNetworkNamespaceHandle netns.NsHandle) error {
+func (mock *LinuxNetworkingMock) configureContainerForDSR(vip string, dsrMethod string, guePort uint16, endpointIP string, containerID string, pid int, hostNetworkNamespaceHandle netns.NsHandle) error {
 	if mock.configureContainerForDSRFunc == nil {
 		panic("LinuxNetworkingMock.configureContainerForDSRFunc: method is nil but LinuxNetworking.configureContainerForDSR was just called")
 	}
 	callInfo := struct {
 		Vip                        string
+		DsrMethod                  string
+		GuePort                    uint16
 		EndpointIP                 string
 		ContainerID                string
 		Pid                        int
 		HostNetworkNamespaceHandle netns.NsHandle
 	}{
 		Vip:                        vip,
+		DsrMethod:                  dsrMethod,
+		GuePort:                    guePort,
 		EndpointIP:                 endpointIP,
 		ContainerID:                containerID,
 		Pid:                        pid,
@@ -403,7 +423,7 @@ func (mock *LinuxNetworkingMock) configureContainerForDSR(vip string, endpointIP
 	mock.lockconfigureContainerForDSR.Lock()
 	mock.calls.configureContainerForDSR = append(mock.calls.configureContainerForDSR, callInfo)
 	mock.lockconfigureContainerForDSR.Unlock()
-	return mock.configureContainerForDSRFunc(vip, endpointIP, containerID, pid, hostNetworkNamespaceHandle)
+	return mock.configureContainerForDSRFunc(vip, dsrMethod, guePort, endpointIP, containerID, pid, hostNetworkNamespaceHandle)
 }
 
 // configureContainerForDSRCalls gets all the calls that were made to configureContainerForDSR.
@@ -411,6 +431,8 @@ func (mock *LinuxNetworkingMock) configureContainerForDSR(vip string, endpointIP
 //     len(mockedLinuxNetworking.configureContainerForDSRCalls())
 func (mock *LinuxNetworkingMock) configureContainerForDSRCalls() []struct {
 	Vip                        string
+	DsrMethod                  string
+	GuePort                    uint16
 	EndpointIP                 string
 	ContainerID                string
 	Pid                        int
@@ -418,6 +440,8 @@ func (mock *LinuxNetworkingMock) configureContainerForDSRCalls() []struct {
 } {
 	var calls []struct {
 		Vip                        string
+		DsrMethod                  string
+		GuePort                    uint16
 		EndpointIP                 string
 		ContainerID                string
 		Pid                        int
@@ -456,23 +480,25 @@ func (mock *LinuxNetworkingMock) getKubeDummyInterfaceCalls() []struct {
 }
 
 // ipAddrAdd calls ipAddrAddFunc.
-func (mock *LinuxNetworkingMock) ipAddrAdd(iface netlink.Link, ip string, addRoute bool) error {
+func (mock *LinuxNetworkingMock) ipAddrAdd(iface netlink.Link, ip string, nodeIP string, addRoute bool) error {
 	if mock.ipAddrAddFunc == nil {
 		panic("LinuxNetworkingMock.ipAddrAddFunc: method is nil but LinuxNetworking.ipAddrAdd was just called")
 	}
 	callInfo := struct {
 		Iface    netlink.Link
 		IP       string
+		NodeIP   string
 		AddRoute bool
 	}{
 		Iface:    iface,
 		IP:       ip,
+		NodeIP:   nodeIP,
 		AddRoute: addRoute,
 	}
 	mock.lockipAddrAdd.Lock()
 	mock.calls.ipAddrAdd = append(mock.calls.ipAddrAdd, callInfo)
 	mock.lockipAddrAdd.Unlock()
-	return mock.ipAddrAddFunc(iface, ip, addRoute)
+	return mock.ipAddrAddFunc(iface, ip, nodeIP, addRoute)
 }
 
 // ipAddrAddCalls gets all the calls that were made to ipAddrAdd.
@@ -481,11 +507,13 @@ func (mock *LinuxNetworkingMock) ipAddrAdd(iface netlink.Link, ip string, addRou
 func (mock *LinuxNetworkingMock) ipAddrAddCalls() []struct {
 	Iface    netlink.Link
 	IP       string
+	NodeIP   string
 	AddRoute bool
 } {
 	var calls []struct {
 		Iface    netlink.Link
 		IP       string
+		NodeIP   string
 		AddRoute bool
 	}
 	mock.lockipAddrAdd.RLock()
@@ -495,33 +523,37 @@ func (mock *LinuxNetworkingMock) ipAddrAddCalls() []struct {
 }
 
 // ipAddrDel calls ipAddrDelFunc.
-func (mock *LinuxNetworkingMock) ipAddrDel(iface netlink.Link, ip string) error {
+func (mock *LinuxNetworkingMock) ipAddrDel(iface netlink.Link, ip string, nodeIP string) error {
 	if mock.ipAddrDelFunc == nil {
 		panic("LinuxNetworkingMock.ipAddrDelFunc: method is nil but LinuxNetworking.ipAddrDel was just called")
 	}
 	callInfo := struct {
-		Iface netlink.Link
-		IP    string
+		Iface  netlink.Link
+		IP     string
+		NodeIP string
 	}{
-		Iface: iface,
-		IP:    ip,
+		Iface:  iface,
+		IP:     ip,
+		NodeIP: nodeIP,
 	}
 	mock.lockipAddrDel.Lock()
 	mock.calls.ipAddrDel = append(mock.calls.ipAddrDel, callInfo)
 	mock.lockipAddrDel.Unlock()
-	return mock.ipAddrDelFunc(iface, ip)
+	return mock.ipAddrDelFunc(iface, ip, nodeIP)
 }
 
 // ipAddrDelCalls gets all the calls that were made to ipAddrDel.
 // Check the length with:
 //     len(mockedLinuxNetworking.ipAddrDelCalls())
 func (mock *LinuxNetworkingMock) ipAddrDelCalls() []struct {
-	Iface netlink.Link
-	IP    string
+	Iface  netlink.Link
+	IP     string
+	NodeIP string
 } {
 	var calls []struct {
-		Iface netlink.Link
-		IP    string
+		Iface  netlink.Link
+		IP     string
+		NodeIP string
 	}
 	mock.lockipAddrDel.RLock()
 	calls = mock.calls.ipAddrDel
@@ -938,7 +970,7 @@ func (mock *LinuxNetworkingMock) ipvsUpdateServiceCalls() []struct {
 }
 
 // prepareEndpointForDsrWithCRI calls prepareEndpointForDsrWithCRIFunc.
-func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithCRI(runtimeEndpoint string, containerID string, endpointIP string, vip string) error {
+func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithCRI(runtimeEndpoint string, containerID string, endpointIP string, vip string, dsrMethod string, guePort uint16) error {
 	if mock.prepareEndpointForDsrWithCRIFunc == nil {
 		panic("LinuxNetworkingMock.prepareEndpointForDsrWithCRIFunc: method is nil but LinuxNetworking.prepareEndpointForDsrWithCRI was just called")
 	}
@@ -947,16 +979,20 @@ func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithCRI(runtimeEndpoint st
 		ContainerID     string
 		EndpointIP      string
 		Vip             string
+		DsrMethod       string
+		GuePort         uint16
 	}{
 		RuntimeEndpoint: runtimeEndpoint,
 		ContainerID:     containerID,
 		EndpointIP:      endpointIP,
 		Vip:             vip,
+		DsrMethod:       dsrMethod,
+		GuePort:         guePort,
 	}
 	mock.lockprepareEndpointForDsrWithCRI.Lock()
 	mock.calls.prepareEndpointForDsrWithCRI = append(mock.calls.prepareEndpointForDsrWithCRI, callInfo)
 	mock.lockprepareEndpointForDsrWithCRI.Unlock()
-	return mock.prepareEndpointForDsrWithCRIFunc(runtimeEndpoint, containerID, endpointIP, vip)
+	return mock.prepareEndpointForDsrWithCRIFunc(runtimeEndpoint, containerID, endpointIP, vip, dsrMethod, guePort)
 }
 
 // prepareEndpointForDsrWithCRICalls gets all the calls that were made to prepareEndpointForDsrWithCRI.
@@ -967,12 +1003,16 @@ func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithCRICalls() []struct {
 	ContainerID     string
 	EndpointIP      string
 	Vip             string
+	DsrMethod       string
+	GuePort         uint16
 } {
 	var calls []struct {
 		RuntimeEndpoint string
 		ContainerID     string
 		EndpointIP      string
 		Vip             string
+		DsrMethod       string
+		GuePort         uint16
 	}
 	mock.lockprepareEndpointForDsrWithCRI.RLock()
 	calls = mock.calls.prepareEndpointForDsrWithCRI
@@ -981,7 +1021,7 @@ func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithCRICalls() []struct {
 }
 
 // prepareEndpointForDsrWithDocker calls prepareEndpointForDsrWithDockerFunc.
-func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithDocker(containerID string, endpointIP string, vip string) error {
+func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithDocker(containerID string, endpointIP string, vip string, dsrMethod string, guePort uint16) error {
 	if mock.prepareEndpointForDsrWithDockerFunc == nil {
 		panic("LinuxNetworkingMock.prepareEndpointForDsrWithDockerFunc: method is nil but LinuxNetworking.prepareEndpointForDsrWithDocker was just called")
 	}
@@ -989,15 +1029,19 @@ func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithDocker(containerID str
 		ContainerID string
 		EndpointIP  string
 		Vip         string
+		DsrMethod   string
+		GuePort     uint16
 	}{
 		ContainerID: containerID,
 		EndpointIP:  endpointIP,
 		Vip:         vip,
+		DsrMethod:   dsrMethod,
+		GuePort:     guePort,
 	}
 	mock.lockprepareEndpointForDsrWithDocker.Lock()
 	mock.calls.prepareEndpointForDsrWithDocker = append(mock.calls.prepareEndpointForDsrWithDocker, callInfo)
 	mock.lockprepareEndpointForDsrWithDocker.Unlock()
-	return mock.prepareEndpointForDsrWithDockerFunc(containerID, endpointIP, vip)
+	return mock.prepareEndpointForDsrWithDockerFunc(containerID, endpointIP, vip, dsrMethod, guePort)
 }
 
 // prepareEndpointForDsrWithDockerCalls gets all the calls that were made to prepareEndpointForDsrWithDocker.
@@ -1007,11 +1051,15 @@ func (mock *LinuxNetworkingMock) prepareEndpointForDsrWithDockerCalls() []struct
 	ContainerID string
 	EndpointIP  string
 	Vip         string
+	DsrMethod   string
+	GuePort     uint16
 } {
 	var calls []struct {
 		ContainerID string
 		EndpointIP  string
 		Vip         string
+		DsrMethod   string
+		GuePort     uint16
 	}
 	mock.lockprepareEndpointForDsrWithDocker.RLock()
 	calls = mock.calls.prepareEndpointForDsrWithDocker
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"testing"
 	"time"
 
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/moby/ipvs"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
 	v1core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -47,7 +50,7 @@ func (lnm *LinuxNetworkingMockImpl) ipvsGetServices() ([]*ipvs.Service, error) {
 	copy(svcsCopy, lnm.ipvsSvcs)
 	return svcsCopy, nil
 }
-func (lnm *LinuxNetworkingMockImpl) ipAddrAdd(iface netlink.Link, addr string, addRouter bool) error {
+func (lnm *LinuxNetworkingMockImpl) ipAddrAdd(iface netlink.Link, addr string, nodeIP string, addRouter bool) error {
 	return nil
 }
 func (lnm *LinuxNetworkingMockImpl) ipvsAddServer(ipvsSvc *ipvs.Service, ipvsDst *ipvs.Destination) error {
@@ -520,3 +523,161 @@ func startInformersForServiceProxy(nsc *NetworkServicesController, clientset kub
 func ptrToString(str string) *string {
 	return &str
 }
+
+func Test_getIpvsFirewallICMPRules(t *testing.T) {
+	icmpTypes := make([]string, 0)
+	for _, rule := range getIpvsFirewallICMPRules() {
+		assert.Equal(t, "ACCEPT", rule[len(rule)-1], "expected ICMP rule %v to accept the messages", rule)
+		for i, arg := range rule {
+			if arg == "--icmp-type" {
+				icmpTypes = append(icmpTypes, rule[i+1])
+			}
+		}
+	}
+	// fragmentation needed messages are destination unreachable messages, without them path MTU discovery breaks
+	assert.ElementsMatch(t, []string{"echo-request", "destination-unreachable", "time-exceeded"}, icmpTypes)
+}
+
+func Test_buildServicesInfoSCTPDSR(t *testing.T) {
+	svc := &v1core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default",
+			Annotations: map[string]string{svcDSRAnnotation: tunnelInterfaceType}},
+		Spec: v1core.ServiceSpec{
+			Type:        "ClusterIP",
+			ClusterIP:   "10.0.0.1",
+			ExternalIPs: []string{"1.1.1.1"},
+			Ports: []v1core.ServicePort{
+				{Name: "sigtran", Port: 2905, Protocol: "SCTP"},
+				{Name: "http", Port: 80, Protocol: "TCP"},
+			},
+		},
+	}
+
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, svcLister.Add(svc))
+
+	for _, dsrSCTP := range []bool{true, false} {
+		nsc := &NetworkServicesController{dsrSCTP: dsrSCTP, svcLister: svcLister}
+
+		serviceMap := nsc.buildServicesInfo()
+		assert.Len(t, serviceMap, 2)
+		for _, svcInfo := range serviceMap {
+			if svcInfo.protocol == sctpProtocol {
+				assert.Equal(t, dsrSCTP, svcInfo.directServerReturn,
+					"expected the SCTP port to use DSR only with the SCTP kernel modules")
+			} else {
+				assert.True(t, svcInfo.directServerReturn, "expected the TCP port to use DSR")
+			}
+		}
+	}
+}
+
+func Test_dsrConnectionFlags(t *testing.T) {
+	testcases := []struct {
+		name      string
+		dsrMethod string
+		isLocal   bool
+		expected  uint32
+	}{
+		{"tunnel method local endpoint", tunnelInterfaceType, true, ipvs.ConnectionFlagTunnel},
+		{"tunnel method remote endpoint", tunnelInterfaceType, false, ipvs.ConnectionFlagTunnel},
+		{"direct method local endpoint", directRouteMethod, true, ipvs.ConnectionFlagDirectRoute},
+		{"direct method remote endpoint", directRouteMethod, false, ipvs.ConnectionFlagTunnel},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			svc := &serviceInfo{directServerReturn: true, directServerReturnMethod: testcase.dsrMethod}
+			assert.Equal(t, testcase.expected, dsrConnectionFlags(svc, endpointsInfo{ip: "10.1.0.5",
+				isLocal: testcase.isLocal}))
+		})
+	}
+}
+
+func Test_buildServicesInfoVIPNodeSelector(t *testing.T) {
+	svc := &v1core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default",
+			Annotations: map[string]string{utils.SvcVIPNodeSelectorAnnotation: "node-role.kubernetes.io/ingress"}},
+		Spec: v1core.ServiceSpec{
+			Type:        "LoadBalancer",
+			ClusterIP:   "10.0.0.1",
+			ExternalIPs: []string{"1.1.1.1"},
+			Ports:       []v1core.ServicePort{{Name: "http", Port: 80, Protocol: "TCP"}},
+		},
+		Status: v1core.ServiceStatus{
+			LoadBalancer: v1core.LoadBalancerStatus{Ingress: []v1core.LoadBalancerIngress{{IP: "10.255.0.1"}}},
+		},
+	}
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, svcLister.Add(svc))
+
+	nsc := &NetworkServicesController{svcLister: svcLister,
+		nodeLabels: map[string]string{"node-role.kubernetes.io/ingress": ""}}
+	for _, svcInfo := range nsc.buildServicesInfo() {
+		assert.Equal(t, []string{"1.1.1.1"}, svcInfo.externalIPs)
+		assert.Equal(t, []string{"10.255.0.1"}, svcInfo.loadBalancerIPs)
+	}
+
+	nsc.nodeLabels = map[string]string{"node-role.kubernetes.io/worker": ""}
+	serviceMap := nsc.buildServicesInfo()
+	assert.Len(t, serviceMap, 1, "expected the cluster IP of the service to still be served")
+	for _, svcInfo := range serviceMap {
+		assert.Empty(t, svcInfo.externalIPs)
+		assert.Empty(t, svcInfo.loadBalancerIPs)
+	}
+}
+
+func Test_parseSchedFlags(t *testing.T) {
+	flags, err := parseSchedFlags(IpvsMaglevHashing, "mh-fallback, mh-port")
+	assert.NoError(t, err)
+	assert.Equal(t, schedFlags{flag1: true, flag2: true}, flags)
+
+	flags, err = parseSchedFlags(ipvs.SourceHashing, "sh-port,flag-3")
+	assert.NoError(t, err)
+	assert.Equal(t, schedFlags{flag2: true, flag3: true}, flags)
+
+	flags, err = parseSchedFlags(ipvs.SourceHashing, "mh-port,flag-1")
+	assert.Error(t, err, "expected the flags of another scheduler to be rejected")
+	assert.Equal(t, schedFlags{flag1: true}, flags)
+}
+
+func Test_buildServicesInfoScheduler(t *testing.T) {
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, annotations := range map[string]map[string]string{
+		"maglev":  {svcSchedulerAnnotation: IpvsMaglevHashing, svcSchedFlagsAnnotation: "mh-port"},
+		"wlc":     {svcSchedulerAnnotation: ipvs.WeightedLeastConnection, svcSchedFlagsAnnotation: "flag-1"},
+		"unknown": {svcSchedulerAnnotation: "fifo"},
+	} {
+		assert.NoError(t, svcLister.Add(&v1core.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+			Spec: v1core.ServiceSpec{Type: "ClusterIP", ClusterIP: "10.0.0.1",
+				Ports: []v1core.ServicePort{{Port: 80, Protocol: "TCP"}}},
+		}))
+	}
+
+	serviceMap := (&NetworkServicesController{svcLister: svcLister}).buildServicesInfo()
+	maglev := serviceMap[generateServiceID("default", "maglev", "")]
+	assert.Equal(t, IpvsMaglevHashing, maglev.scheduler)
+	assert.Equal(t, schedFlags{flag2: true}, maglev.flags)
+	wlc := serviceMap[generateServiceID("default", "wlc", "")]
+	assert.Equal(t, ipvs.WeightedLeastConnection, wlc.scheduler)
+	assert.Equal(t, schedFlags{}, wlc.flags, "expected the flags of a scheduler without flags to be ignored")
+	assert.Equal(t, ipvs.RoundRobin, serviceMap[generateServiceID("default", "unknown", "")].scheduler)
+}
+
+func Test_onNodeIPChange(t *testing.T) {
+	nsc := &NetworkServicesController{nodeIPChangeChan: make(chan net.IP, 1)}
+
+	done := make(chan struct{})
+	go func() {
+		nsc.onNodeIPChange(net.ParseIP("10.0.0.2"))
+		nsc.onNodeIPChange(net.ParseIP("10.0.0.3"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the node IP changes to be handed over without blocking the informer")
+	}
+	assert.Equal(t, net.ParseIP("10.0.0.3"), <-nsc.nodeIPChangeChan, "expected the latest node IP to be applied")
+	assert.Empty(t, nsc.nodeIPChangeChan)
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const noMasqueradeChainName = "KUBE-ROUTER-NO-MASQUERADE"
+
+// noMasqueradeConfig is the traffic of the pods of the node that keeps their IPs as its source
+type noMasqueradeConfig struct {
+	// podCIDRs are the IPv4 pod CIDRs of the node, the sources of the traffic exempted from masquerading
+	podCIDRs []string
+	cidrs    []*net.IPNet
+}
+
+func getNoMasqueradeJumpRule() []string {
+	return []string{"-m", "comment", "--comment", "kube-router skip masquerading", "-j", noMasqueradeChainName}
+}
+
+// getNoMasqueradeRules returns the rules of the NO-MASQUERADE chain.
+//
+// The traffic of the pods of the node to the --no-masquerade-cidrs and to the services annotated with
+// kube-router.io/service.no-masquerade is accepted before any of the masquerading rules of the nat POSTROUTING chain,
+// the ones of --masquerade-all and of the pod egress included, sees it, so it reaches its destination with the IP of
+// the pod as its source. The hairpin traffic of the local endpoints of those services to themselves still needs to be
+// SNATed though, it is returned to POSTROUTING first.
+func (c *noMasqueradeConfig) getNoMasqueradeRules(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap) [][]string {
+	rules := make([][]string, 0)
+	if len(c.podCIDRs) == 0 {
+		return rules
+	}
+
+	serviceIDs := make([]string, 0, len(serviceInfoMap))
+	for id, svc := range serviceInfoMap {
+		if svc.noMasquerade {
+			serviceIDs = append(serviceIDs, id)
+		}
+	}
+	sort.Strings(serviceIDs)
+
+	for _, id := range serviceIDs {
+		for _, ep := range endpointsInfoMap[id] {
+			if ep.isLocal && net.ParseIP(ep.ip).To4() != nil {
+				rules = append(rules, []string{"-m", "comment", "--comment", "hairpin traffic", "-s", ep.ip + "/32",
+					"-d", ep.ip + "/32", "-j", "RETURN"})
+			}
+		}
+	}
+	for _, podCIDR := range c.podCIDRs {
+		for _, cidr := range c.cidrs {
+			rules = append(rules, []string{"-m", "comment", "--comment", "unmasqueraded CIDR", "-s", podCIDR,
+				"-d", cidr.String(), "-j", "ACCEPT"})
+		}
+		for _, id := range serviceIDs {
+			svc := serviceInfoMap[id]
+			vips := make([]string, 0)
+			if svc.clusterIP != nil {
+				vips = append(vips, svc.clusterIP.String())
+			}
+			vips = append(vips, svc.externalIPs...)
+			vips = append(vips, svc.loadBalancerIPs...)
+			for _, vip := range vips {
+				if net.ParseIP(vip).To4() == nil {
+					continue
+				}
+				rules = append(rules, []string{"-m", "comment", "--comment", "unmasqueraded service", "-s", podCIDR,
+					"-m", "ipvs", "--vaddr", vip + "/32", "--vport", strconv.Itoa(svc.port), "-j", "ACCEPT"})
+			}
+		}
+	}
+	return rules
+}
+
+// syncNoMasqueradeRules replaces the rules of the NO-MASQUERADE chain in the nat table at once and jumps to it at the
+// top of the POSTROUTING chain, or removes them when there is no traffic to exempt from masquerading
+func (nsc *NetworkServicesController) syncNoMasqueradeRules(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap) error {
+	rules := nsc.noMasquerade.getNoMasqueradeRules(serviceInfoMap, endpointsInfoMap)
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return fmt.Errorf("failed to initialize iptables executor: %s", err.Error())
+	}
+	if len(rules) == 0 {
+		cleanupNoMasqueradeChain(iptablesCmdHandler)
+		return nil
+	}
+
+	restore := bytes.Buffer{}
+	restore.WriteString("*nat\n:" + noMasqueradeChainName + " - [0:0]\n")
+	for _, rule := range rules {
+		quoted := make([]string, len(rule))
+		for i, arg := range rule {
+			quoted[i] = arg
+			if i > 0 && rule[i-1] == "--comment" {
+				quoted[i] = "\"" + arg + "\""
+			}
+		}
+		restore = utils.Append(restore, noMasqueradeChainName, quoted)
+	}
+	restore.WriteString("COMMIT\n")
+	if err = utils.NewIPTablesSaveRestore(api.IPv4Protocol).RestoreNoFlush("nat", restore.Bytes()); err != nil {
+		return fmt.Errorf("failed to run iptables-restore: %s", err.Error())
+	}
+
+	jumpRule := getNoMasqueradeJumpRule()
+	exists, err := iptablesCmdHandler.Exists("nat", "POSTROUTING", jumpRule...)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+	if !exists {
+		if err = iptablesCmdHandler.Insert("nat", "POSTROUTING", 1, jumpRule...); err != nil {
+			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// cleanupNoMasqueradeChain removes the NO-MASQUERADE chain and the jump to it
+func cleanupNoMasqueradeChain(iptablesCmdHandler *iptables.IPTables) {
+	exists, err := iptablesCmdHandler.ChainExists("nat", noMasqueradeChainName)
+	if err != nil || !exists {
+		return
+	}
+	if err = iptablesCmdHandler.DeleteIfExists("nat", "POSTROUTING", getNoMasqueradeJumpRule()...); err != nil {
+		klog.Errorf("failed to run iptables command: %v", err)
+	}
+	if err = iptablesCmdHandler.ClearAndDeleteChain("nat", noMasqueradeChainName); err != nil {
+		klog.Errorf("failed to run iptables command: %v", err)
+	}
+}
+
+// cleanupNoMasqueradeRules removes the NO-MASQUERADE chain and the jump to it
+func cleanupNoMasqueradeRules() {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		klog.V(1).Infof("failed to initialize iptables executor: %v", err)
+		return
+	}
+	cleanupNoMasqueradeChain(iptablesCmdHandler)
+}
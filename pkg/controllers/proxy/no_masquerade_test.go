@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getNoMasqueradeRules(t *testing.T) {
+	_, onPrem, _ := net.ParseCIDR("172.16.0.0/12")
+	services := serviceInfoMap{
+		"default/web:http": &serviceInfo{clusterIP: net.ParseIP("10.96.0.20"), port: 80,
+			externalIPs: []string{"192.0.2.10"}, noMasquerade: true},
+		"default/api:http": &serviceInfo{clusterIP: net.ParseIP("10.96.0.30"), port: 8080},
+	}
+	endpoints := endpointsInfoMap{
+		"default/web:http": {{ip: "10.1.0.5", port: 8080, isLocal: true}, {ip: "10.1.1.5", port: 8080}},
+	}
+
+	assert.Empty(t, (&noMasqueradeConfig{cidrs: []*net.IPNet{onPrem}}).getNoMasqueradeRules(services, endpoints),
+		"expected no rules without the pod CIDR of the node")
+	assert.Empty(t, (&noMasqueradeConfig{podCIDRs: []string{"10.1.0.0/24"}}).getNoMasqueradeRules(
+		serviceInfoMap{"default/api:http": services["default/api:http"]}, endpoints),
+		"expected no rules without traffic to exempt from masquerading")
+
+	config := &noMasqueradeConfig{podCIDRs: []string{"10.1.0.0/24"}, cidrs: []*net.IPNet{onPrem}}
+	assert.Equal(t, [][]string{
+		{"-m", "comment", "--comment", "hairpin traffic", "-s", "10.1.0.5/32", "-d", "10.1.0.5/32", "-j", "RETURN"},
+		{"-m", "comment", "--comment", "unmasqueraded CIDR", "-s", "10.1.0.0/24", "-d", "172.16.0.0/12",
+			"-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "unmasqueraded service", "-s", "10.1.0.0/24",
+			"-m", "ipvs", "--vaddr", "10.96.0.20/32", "--vport", "80", "-j", "ACCEPT"},
+		{"-m", "comment", "--comment", "unmasqueraded service", "-s", "10.1.0.0/24",
+			"-m", "ipvs", "--vaddr", "192.0.2.10/32", "--vport", "80", "-j", "ACCEPT"},
+	}, config.getNoMasqueradeRules(services, endpoints),
+		"expected the traffic of the pods to the CIDRs and the annotated services to be accepted, but for hairpinning")
+}
@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"k8s.io/klog/v2"
+)
+
+const (
+	svcNodePortAllowedCIDRsAnnotation = "kube-router.io/service.nodeport.allowed-cidrs"
+
+	nodePortsIPSetName        = "kube-router-nodeport-ports"
+	nodePortClientsIPSetName  = "kube-router-nodeport-clients"
+	nodePortFirewallChainName = "KUBE-ROUTER-NODEPORTS"
+)
+
+// parseNodePortAllowedCIDRs parses a comma separated list of client CIDRs, ignoring (and logging) the invalid ones and
+// the ones that are not IPv4 as NodePorts are only served on IPv4 addresses
+func parseNodePortAllowedCIDRs(value string) []string {
+	cidrs := make([]string, 0)
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || ip.To4() == nil {
+			klog.Errorf("Ignoring invalid IPv4 NodePort client CIDR %q", cidr)
+			continue
+		}
+		cidrs = append(cidrs, ipNet.String())
+	}
+	return cidrs
+}
+
+// parseLoadBalancerSourceRanges parses the loadBalancerSourceRanges of a service, ignoring (and logging) the invalid
+// ones and leaving out the IPv6 ones as only the IPv4 LoadBalancer IPs are restricted
+func parseLoadBalancerSourceRanges(namespace, name string, sourceRanges []string) []string {
+	cidrs := make([]string, 0, len(sourceRanges))
+	for _, cidr := range sourceRanges {
+		cidr = strings.TrimSpace(cidr)
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		switch {
+		case err != nil:
+			klog.Errorf("Ignoring invalid loadBalancerSourceRange %q of service %s/%s", cidr, namespace, name)
+		case ip.To4() != nil:
+			cidrs = append(cidrs, ipNet.String())
+		}
+	}
+	return cidrs
+}
+
+// allowsAllClients returns whether the client CIDRs allow every client, which hash:ip,port,net sets can't hold as a /0
+// and is the same as not restricting the clients
+func allowsAllClients(cidrs []string) bool {
+	for _, cidr := range cidrs {
+		if strings.HasSuffix(cidr, "/0") {
+			return true
+		}
+	}
+	return len(cidrs) == 0
+}
+
+// getNodePortFirewallEntries returns the entries of the ipset of the NodePorts and LoadBalancer IPs that are restricted
+// to allowed clients ("ip,protocol:port") and of the ipset of the clients allowed to reach them
+// ("ip,protocol:port,cidr"), for NodePorts served on any of the given addresses and for the IPv4 LoadBalancer IPs of
+// the services with loadBalancerSourceRanges
+func getNodePortFirewallEntries(serviceInfoMap serviceInfoMap, nodePortIPs []string) ([]string, []string) {
+	nodePorts := make([]string, 0)
+	clients := make([]string, 0)
+	restrict := func(ip, protocol string, port int, cidrs []string) {
+		restricted := fmt.Sprintf("%s,%s:%d", ip, protocol, port)
+		nodePorts = append(nodePorts, restricted)
+		for _, cidr := range cidrs {
+			clients = append(clients, restricted+","+cidr)
+		}
+	}
+	for _, svc := range serviceInfoMap {
+		if svc.nodePort != 0 && !allowsAllClients(svc.nodePortAllowedCIDRs) {
+			for _, ip := range nodePortIPs {
+				restrict(ip, svc.protocol, svc.nodePort, svc.nodePortAllowedCIDRs)
+			}
+		}
+		if svc.skipLbIps || allowsAllClients(svc.loadBalancerSourceRanges) {
+			continue
+		}
+		for _, ip := range svc.loadBalancerIPs {
+			if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+				restrict(ip, svc.protocol, svc.port, svc.loadBalancerSourceRanges)
+			}
+		}
+	}
+	return nodePorts, clients
+}
+
+func getNodePortFirewallInputChainRule() []string {
+	// The iptables rule for use in {setup,cleanup}NodePortFirewall.
+	return []string{
+		"-m", "comment", "--comment", "restrict the clients of NodePort services in custom chain",
+		"-m", "set", "--match-set", nodePortsIPSetName, "dst,dst",
+		"-j", nodePortFirewallChainName}
+}
+
+// setupNodePortFirewall creates the ipsets and the chain that drop traffic to NodePorts and LoadBalancer IPs from
+// clients that are not in their allowed CIDRs or loadBalancerSourceRanges. It has to run after setupIpvsFirewall, as
+// the chain needs to be jumped to before the traffic is accepted by the IPVS firewall.
+func (nsc *NetworkServicesController) setupNodePortFirewall() error {
+	ipSetHandler, err := utils.NewIPSet(false)
+	if err != nil {
+		return err
+	}
+	if nsc.ipsetMap == nil {
+		nsc.ipsetMap = make(map[string]*utils.Set)
+	}
+
+	ipset, err := ipSetHandler.Create(nodePortsIPSetName, utils.TypeHashIPPort, utils.OptionTimeout, "0")
+	if err != nil {
+		return fmt.Errorf("failed to create ipset: %s", err.Error())
+	}
+	nsc.ipsetMap[nodePortsIPSetName] = ipset
+
+	ipset, err = ipSetHandler.Create(nodePortClientsIPSetName, utils.TypeHashIPPortNet, utils.OptionTimeout, "0")
+	if err != nil {
+		return fmt.Errorf("failed to create ipset: %s", err.Error())
+	}
+	nsc.ipsetMap[nodePortClientsIPSetName] = ipset
+
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("failed to initialize iptables executor" + err.Error())
+	}
+
+	// ClearChain either clears an existing chain or creates a new one.
+	err = iptablesCmdHandler.ClearChain("filter", nodePortFirewallChainName)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+
+	rules := [][]string{
+		{"-m", "comment", "--comment", "allow traffic to NodePort services from the node itself",
+			"-i", "lo", "-j", "RETURN"},
+		{"-m", "comment", "--comment", "allow traffic to NodePorts and LoadBalancer IPs from allowed clients",
+			"-m", "set", "--match-set", nodePortClientsIPSetName, "dst,dst,src", "-j", "RETURN"},
+		{"-m", "comment", "--comment", "drop traffic to NodePorts and LoadBalancer IPs from other clients",
+			"-j", "DROP"},
+	}
+	for _, rule := range rules {
+		err = iptablesCmdHandler.Append("filter", nodePortFirewallChainName, rule...)
+		if err != nil {
+			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		}
+	}
+
+	// Re-insert the jump at the top of the INPUT chain, so that it is ahead of the jump to the IPVS firewall which
+	// accepts the traffic to IPVS services
+	nodePortFirewallInputChainRule := getNodePortFirewallInputChainRule()
+	exists, err := iptablesCmdHandler.Exists("filter", "INPUT", nodePortFirewallInputChainRule...)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+	if exists {
+		err = iptablesCmdHandler.Delete("filter", "INPUT", nodePortFirewallInputChainRule...)
+		if err != nil {
+			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		}
+	}
+	err = iptablesCmdHandler.Insert("filter", "INPUT", 1, nodePortFirewallInputChainRule...)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+
+	return nil
+}
+
+// syncNodePortFirewall populates the ipsets of the NodePort firewall, it must be called with the ipset mutex held
+func (nsc *NetworkServicesController) syncNodePortFirewall(serviceInfoMap serviceInfoMap, localIPs []string) error {
+	nodePortsIPSet, ok1 := nsc.ipsetMap[nodePortsIPSetName]
+	nodePortClientsIPSet, ok2 := nsc.ipsetMap[nodePortClientsIPSetName]
+	if !ok1 || !ok2 {
+		return fmt.Errorf("ipsets of the NodePort firewall have not been set up")
+	}
+
+	nodePortIPs := []string{nsc.nodePortIP.String()}
+	if nsc.nodeportBindOnAllIP || len(nsc.nodePortAddresses) > 0 {
+		nodePortIPs = selectNodePortIPs(localIPs, nsc.nodePortAddresses)
+	}
+	nodePorts, clients := getNodePortFirewallEntries(serviceInfoMap, nodePortIPs)
+
+	// Refresh the clients first, so that no NodePort is restricted without its clients being allowed
+	err := nodePortClientsIPSet.Refresh(clients)
+	if err != nil {
+		return fmt.Errorf("failed to sync ipset: %s", err.Error())
+	}
+	err = nodePortsIPSet.Refresh(nodePorts)
+	if err != nil {
+		return fmt.Errorf("failed to sync ipset: %s", err.Error())
+	}
+	return nil
+}
+
+// cleanupNodePortFirewallRules removes the jump to the NodePort firewall chain and the chain itself, the ipsets are
+// destroyed by cleanupIpvsFirewall along with its own
+func cleanupNodePortFirewallRules(iptablesCmdHandler *iptables.IPTables) {
+	nodePortFirewallInputChainRule := getNodePortFirewallInputChainRule()
+	exists, err := iptablesCmdHandler.Exists("filter", "INPUT", nodePortFirewallInputChainRule...)
+	if err != nil {
+		klog.V(1).Infof("failed to check if iptables rules exists: %v", err)
+	} else if exists {
+		err = iptablesCmdHandler.Delete("filter", "INPUT", nodePortFirewallInputChainRule...)
+		if err != nil {
+			klog.Errorf("failed to run iptables command: %v", err)
+		}
+	}
+
+	exists, err = iptablesCmdHandler.ChainExists("filter", nodePortFirewallChainName)
+	if err != nil {
+		klog.Errorf("failed to check if chain exists for deletion: %v", err)
+	} else if exists {
+		err = iptablesCmdHandler.ClearChain("filter", nodePortFirewallChainName)
+		if err != nil {
+			klog.Errorf("Failed to run iptables command: %s", err.Error())
+		}
+
+		err = iptablesCmdHandler.DeleteChain("filter", nodePortFirewallChainName)
+		if err != nil {
+			klog.Errorf("Failed to run iptables command: %s", err.Error())
+		}
+	}
+}
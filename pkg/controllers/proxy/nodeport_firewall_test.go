@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseNodePortAllowedCIDRs(t *testing.T) {
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.0/24"},
+		parseNodePortAllowedCIDRs("10.0.0.0/8, 192.168.1.10/24,,2001:db8::/64,foo"),
+		"expected only the valid IPv4 CIDRs, normalized to their network address")
+	assert.Empty(t, parseNodePortAllowedCIDRs(""), "expected no CIDRs for an empty annotation")
+}
+
+func Test_getNodePortFirewallEntries(t *testing.T) {
+	svcs := serviceInfoMap{
+		"default/restricted": &serviceInfo{
+			protocol:             "tcp",
+			nodePort:             30080,
+			nodePortAllowedCIDRs: []string{"10.0.0.0/8", "192.168.1.0/24"},
+		},
+		"default/unrestricted": &serviceInfo{
+			protocol: "tcp",
+			nodePort: 30081,
+		},
+		"default/all-clients": &serviceInfo{
+			protocol:             "udp",
+			nodePort:             30082,
+			nodePortAllowedCIDRs: []string{"10.0.0.0/8", "0.0.0.0/0"},
+		},
+		"default/cluster-ip": &serviceInfo{
+			protocol:             "tcp",
+			nodePortAllowedCIDRs: []string{"10.0.0.0/8"},
+		},
+		"default/source-ranges": &serviceInfo{
+			protocol:                 "tcp",
+			port:                     443,
+			loadBalancerIPs:          []string{"10.255.0.1", "2001:db8::1"},
+			loadBalancerSourceRanges: []string{"203.0.113.0/24"},
+		},
+		"default/source-ranges-skiplbips": &serviceInfo{
+			protocol:                 "tcp",
+			port:                     443,
+			loadBalancerIPs:          []string{"10.255.0.2"},
+			loadBalancerSourceRanges: []string{"203.0.113.0/24"},
+			skipLbIps:                true,
+		},
+	}
+
+	nodePorts, clients := getNodePortFirewallEntries(svcs, []string{"1.1.1.1", "2.2.2.2"})
+
+	assert.ElementsMatch(t, []string{"1.1.1.1,tcp:30080", "2.2.2.2,tcp:30080", "10.255.0.1,tcp:443"}, nodePorts,
+		"expected only the NodePorts restricted to some clients, on every NodePort address, and the IPv4 "+
+			"LoadBalancer IPs of the services with source ranges")
+	assert.ElementsMatch(t, []string{
+		"1.1.1.1,tcp:30080,10.0.0.0/8", "1.1.1.1,tcp:30080,192.168.1.0/24",
+		"2.2.2.2,tcp:30080,10.0.0.0/8", "2.2.2.2,tcp:30080,192.168.1.0/24",
+		"10.255.0.1,tcp:443,203.0.113.0/24",
+	}, clients, "expected every allowed client CIDR of the restricted NodePorts and LoadBalancer IPs")
+}
+
+func Test_parseLoadBalancerSourceRanges(t *testing.T) {
+	assert.Equal(t, []string{"203.0.113.0/24", "10.0.0.0/8"},
+		parseLoadBalancerSourceRanges("default", "web", []string{"203.0.113.7/24", " 10.0.0.0/8", "2001:db8::/64",
+			"foo"}), "expected only the valid IPv4 ranges, normalized to their network address")
+	assert.Empty(t, parseLoadBalancerSourceRanges("default", "web", nil))
+}
+
+func Test_selectNodePortIPs(t *testing.T) {
+	localIPs := []string{"10.0.0.5", "203.0.113.7", "192.168.1.5"}
+	_, internal, _ := net.ParseCIDR("10.0.0.0/8")
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	assert.Equal(t, []string{"10.0.0.5", "192.168.1.5"}, selectNodePortIPs(localIPs, []*net.IPNet{internal, lan}),
+		"expected only the addresses within the CIDRs")
+	assert.Equal(t, localIPs, selectNodePortIPs(localIPs, nil), "expected all the addresses without CIDRs")
+	_, other, _ := net.ParseCIDR("172.16.0.0/12")
+	assert.Empty(t, selectNodePortIPs(localIPs, []*net.IPNet{other}))
+}
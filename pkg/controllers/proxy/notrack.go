@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"k8s.io/klog/v2"
+)
+
+const (
+	noTrackChainName = "KUBE-ROUTER-NOTRACK"
+	dnsPort          = "53"
+)
+
+// noTrackBuiltinChains are the chains of the raw table that jump to the NOTRACK chain, for the traffic arriving from
+// the pods and for the traffic sent by the node itself respectively
+var noTrackBuiltinChains = []string{"PREROUTING", "OUTPUT"}
+
+// noTrackPort is a port (or port range) of a protocol whose traffic bypasses connection tracking
+type noTrackPort struct {
+	protocol string
+	// ports is either a single port or a range in the first:last form of the iptables --dport match
+	ports string
+}
+
+// noTrackConfig is the traffic that is exempted from connection tracking
+type noTrackConfig struct {
+	nodeLocalDNSIP net.IP
+	cidrs          []*net.IPNet
+	ports          []noTrackPort
+}
+
+// parseNoTrackPort parses the protocol:port and protocol:first-last entries of --notrack-ports
+func parseNoTrackPort(entry string) (noTrackPort, error) {
+	const portBitSize = 16
+
+	parts := strings.Split(entry, ":")
+	if len(parts) != 2 {
+		return noTrackPort{}, fmt.Errorf("invalid NOTRACK port %q, expected protocol:port or protocol:first-last",
+			entry)
+	}
+	protocol := strings.ToLower(parts[0])
+	if protocol != tcpProtocol && protocol != udpProtocol {
+		return noTrackPort{}, fmt.Errorf("invalid protocol in NOTRACK port %q, only tcp and udp are supported", entry)
+	}
+	bounds := strings.Split(parts[1], "-")
+	if len(bounds) > 2 {
+		return noTrackPort{}, fmt.Errorf("invalid port range in NOTRACK port %q", entry)
+	}
+	ports := make([]string, 0, len(bounds))
+	for _, bound := range bounds {
+		port, err := strconv.ParseUint(bound, 10, portBitSize)
+		if err != nil || port == 0 {
+			return noTrackPort{}, fmt.Errorf("invalid port in NOTRACK port %q", entry)
+		}
+		ports = append(ports, strconv.FormatUint(port, 10))
+	}
+	if len(ports) == 2 {
+		first, _ := strconv.Atoi(ports[0])
+		last, _ := strconv.Atoi(ports[1])
+		if first >= last {
+			return noTrackPort{}, fmt.Errorf("first port is greater than or equal to the last one in NOTRACK "+
+				"port %q", entry)
+		}
+	}
+	return noTrackPort{protocol: protocol, ports: strings.Join(ports, ":")}, nil
+}
+
+func getNoTrackJumpRule() []string {
+	return []string{"-m", "comment", "--comment", "kube-router skip connection tracking", "-j", noTrackChainName}
+}
+
+// getNoTrackRules returns the rules of the NOTRACK chain of the given address family.
+//
+// The queries to a node-local DNS cache and its replies are exempted from connection tracking: the cache listens on a
+// link-local IP that isn't an IPVS service, and tracking the high volume of short lived DNS flows only fills up the
+// conntrack table and exposes the queries to the conntrack races that caused the 5 second DNS delays. The same goes
+// for the CIDRs and ports the operator lists, typically high packet rate UDP workloads. Untracked traffic doesn't go
+// through the nat table either, so it is never masqueraded.
+func (c *noTrackConfig) getNoTrackRules(ipv6 bool) [][]string {
+	rules := make([][]string, 0)
+	if c.nodeLocalDNSIP != nil && (c.nodeLocalDNSIP.To4() == nil) == ipv6 {
+		for _, protocol := range []string{udpProtocol, tcpProtocol} {
+			rules = append(rules,
+				[]string{"-m", "comment", "--comment", "node-local DNS cache queries", "-d", c.nodeLocalDNSIP.String(),
+					"-p", protocol, "--dport", dnsPort, "-j", "NOTRACK"},
+				[]string{"-m", "comment", "--comment", "node-local DNS cache replies", "-s", c.nodeLocalDNSIP.String(),
+					"-p", protocol, "--sport", dnsPort, "-j", "NOTRACK"})
+		}
+	}
+	for _, cidr := range c.cidrs {
+		if (cidr.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		rules = append(rules,
+			[]string{"-m", "comment", "--comment", "untracked CIDR", "-d", cidr.String(), "-j", "NOTRACK"},
+			[]string{"-m", "comment", "--comment", "untracked CIDR", "-s", cidr.String(), "-j", "NOTRACK"})
+	}
+	for _, port := range c.ports {
+		rules = append(rules,
+			[]string{"-m", "comment", "--comment", "untracked port", "-p", port.protocol, "--dport", port.ports,
+				"-j", "NOTRACK"},
+			[]string{"-m", "comment", "--comment", "untracked port", "-p", port.protocol, "--sport", port.ports,
+				"-j", "NOTRACK"})
+	}
+	return rules
+}
+
+// setupNoTrackRules programs the NOTRACK chain in the raw table of each address family and jumps to it at the top of
+// the PREROUTING and OUTPUT chains, or removes them from the families without traffic to exempt from connection
+// tracking
+func (nsc *NetworkServicesController) setupNoTrackRules() error {
+	for _, protocol := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		rules := nsc.noTrack.getNoTrackRules(protocol == iptables.ProtocolIPv6)
+		iptablesCmdHandler, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			if len(rules) == 0 {
+				continue
+			}
+			return fmt.Errorf("failed to initialize iptables executor: %s", err.Error())
+		}
+		if len(rules) == 0 {
+			cleanupNoTrackChain(iptablesCmdHandler)
+			continue
+		}
+
+		// ClearChain creates the chain if it doesn't exist yet
+		if err = iptablesCmdHandler.ClearChain("raw", noTrackChainName); err != nil {
+			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		}
+		for _, rule := range rules {
+			if err = iptablesCmdHandler.Append("raw", noTrackChainName, rule...); err != nil {
+				return fmt.Errorf("failed to run iptables command: %s", err.Error())
+			}
+		}
+
+		jumpRule := getNoTrackJumpRule()
+		for _, chain := range noTrackBuiltinChains {
+			exists, err := iptablesCmdHandler.Exists("raw", chain, jumpRule...)
+			if err != nil {
+				return fmt.Errorf("failed to run iptables command: %s", err.Error())
+			}
+			if !exists {
+				if err = iptablesCmdHandler.Insert("raw", chain, 1, jumpRule...); err != nil {
+					return fmt.Errorf("failed to run iptables command: %s", err.Error())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cleanupNoTrackChain removes the NOTRACK chain and the jumps to it
+func cleanupNoTrackChain(iptablesCmdHandler *iptables.IPTables) {
+	exists, err := iptablesCmdHandler.ChainExists("raw", noTrackChainName)
+	if err != nil || !exists {
+		return
+	}
+	jumpRule := getNoTrackJumpRule()
+	for _, chain := range noTrackBuiltinChains {
+		if err = iptablesCmdHandler.DeleteIfExists("raw", chain, jumpRule...); err != nil {
+			klog.Errorf("failed to run iptables command: %v", err)
+		}
+	}
+	if err = iptablesCmdHandler.ClearAndDeleteChain("raw", noTrackChainName); err != nil {
+		klog.Errorf("failed to run iptables command: %v", err)
+	}
+}
+
+// cleanupNoTrackRules removes the NOTRACK chain and the jumps to it, for both address families
+func cleanupNoTrackRules() {
+	for _, protocol := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		iptablesCmdHandler, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			klog.V(1).Infof("failed to initialize iptables executor: %v", err)
+			continue
+		}
+		cleanupNoTrackChain(iptablesCmdHandler)
+	}
+}
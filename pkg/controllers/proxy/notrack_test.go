@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getNoTrackRules(t *testing.T) {
+	assert.Empty(t, (&noTrackConfig{}).getNoTrackRules(false), "expected no rules without untracked traffic")
+
+	dnsConfig := &noTrackConfig{nodeLocalDNSIP: net.ParseIP("169.254.20.10")}
+	assert.Equal(t, [][]string{
+		{"-m", "comment", "--comment", "node-local DNS cache queries", "-d", "169.254.20.10",
+			"-p", "udp", "--dport", "53", "-j", "NOTRACK"},
+		{"-m", "comment", "--comment", "node-local DNS cache replies", "-s", "169.254.20.10",
+			"-p", "udp", "--sport", "53", "-j", "NOTRACK"},
+		{"-m", "comment", "--comment", "node-local DNS cache queries", "-d", "169.254.20.10",
+			"-p", "tcp", "--dport", "53", "-j", "NOTRACK"},
+		{"-m", "comment", "--comment", "node-local DNS cache replies", "-s", "169.254.20.10",
+			"-p", "tcp", "--sport", "53", "-j", "NOTRACK"},
+	}, dnsConfig.getNoTrackRules(false),
+		"expected the queries to and replies from the node-local DNS cache to be untracked")
+	assert.Empty(t, dnsConfig.getNoTrackRules(true), "expected no IPv6 rules for an IPv4 node-local DNS cache")
+
+	_, ipv4CIDR, _ := net.ParseCIDR("10.10.0.0/16")
+	_, ipv6CIDR, _ := net.ParseCIDR("2001:db8::/64")
+	config := &noTrackConfig{
+		cidrs: []*net.IPNet{ipv4CIDR, ipv6CIDR},
+		ports: []noTrackPort{{protocol: "udp", ports: "5000:5010"}},
+	}
+	portRules := [][]string{
+		{"-m", "comment", "--comment", "untracked port", "-p", "udp", "--dport", "5000:5010", "-j", "NOTRACK"},
+		{"-m", "comment", "--comment", "untracked port", "-p", "udp", "--sport", "5000:5010", "-j", "NOTRACK"},
+	}
+	assert.Equal(t, append([][]string{
+		{"-m", "comment", "--comment", "untracked CIDR", "-d", "10.10.0.0/16", "-j", "NOTRACK"},
+		{"-m", "comment", "--comment", "untracked CIDR", "-s", "10.10.0.0/16", "-j", "NOTRACK"},
+	}, portRules...), config.getNoTrackRules(false),
+		"expected the IPv4 CIDRs and the ports to be untracked in both directions")
+	assert.Equal(t, append([][]string{
+		{"-m", "comment", "--comment", "untracked CIDR", "-d", "2001:db8::/64", "-j", "NOTRACK"},
+		{"-m", "comment", "--comment", "untracked CIDR", "-s", "2001:db8::/64", "-j", "NOTRACK"},
+	}, portRules...), config.getNoTrackRules(true),
+		"expected the IPv6 CIDRs and the ports to be untracked in both directions")
+}
+
+func Test_parseNoTrackPort(t *testing.T) {
+	testcases := []struct {
+		entry    string
+		expected noTrackPort
+		valid    bool
+	}{
+		{"udp:5000", noTrackPort{protocol: "udp", ports: "5000"}, true},
+		{"TCP:5000-5010", noTrackPort{protocol: "tcp", ports: "5000:5010"}, true},
+		{"5000", noTrackPort{}, false},
+		{"sctp:5000", noTrackPort{}, false},
+		{"udp:0", noTrackPort{}, false},
+		{"udp:70000", noTrackPort{}, false},
+		{"udp:5010-5000", noTrackPort{}, false},
+		{"udp:5000-5005-5010", noTrackPort{}, false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.entry, func(t *testing.T) {
+			port, err := parseNoTrackPort(tc.entry)
+			if !tc.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, port)
+		})
+	}
+}
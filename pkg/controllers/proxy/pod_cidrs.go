@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"k8s.io/klog/v2"
+)
+
+// localPodCIDRsIPSetName is the ipset of the pod CIDRs of the node, which the outbound IPVS traffic from or to the
+// pods of the node isn't masqueraded for
+const localPodCIDRsIPSetName = "kube-router-local-pod-cidrs"
+
+// localPodCIDRsMatch matches the traffic that neither comes from nor goes to the pod CIDRs of the node
+var localPodCIDRsMatch = []string{"-m", "set", "!", "--match-set", localPodCIDRsIPSetName, "src",
+	"-m", "set", "!", "--match-set", localPodCIDRsIPSetName, "dst"}
+
+// ipv4PodCIDRs returns the IPv4 pod CIDRs among the given ones, as the IPVS traffic is only masqueraded for IPv4
+func ipv4PodCIDRs(cidrs []string) []string {
+	ipv4CIDRs := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			klog.Warningf("Ignoring invalid pod CIDR %s: %v", cidr, err)
+			continue
+		}
+		if ip.To4() != nil {
+			ipv4CIDRs = append(ipv4CIDRs, ipNet.String())
+		}
+	}
+	return ipv4CIDRs
+}
+
+// legacyPodCIDRMatches returns the matches of the pod CIDR of the masquerade rules of the versions of kube-router that
+// only handled the first pod CIDR of the node, so that their rules can be removed
+func (nsc *NetworkServicesController) legacyPodCIDRMatches() [][]string {
+	matches := make([][]string, 0, len(nsc.podCIDRs))
+	for _, cidr := range nsc.podCIDRs {
+		matches = append(matches, []string{"!", "-s", cidr, "!", "-d", cidr})
+	}
+	return matches
+}
+
+// syncLocalPodCIDRsIPSet creates the ipset of the pod CIDRs of the node and refreshes its members
+func (nsc *NetworkServicesController) syncLocalPodCIDRsIPSet() error {
+	if nsc.ipsetMutex != nil {
+		nsc.ipsetMutex.Lock()
+		defer nsc.ipsetMutex.Unlock()
+	}
+	ipSetHandler, err := utils.NewIPSet(false)
+	if err != nil {
+		return err
+	}
+	ipset, err := ipSetHandler.Create(localPodCIDRsIPSetName, utils.TypeHashNet, utils.OptionTimeout, "0")
+	if err != nil {
+		return fmt.Errorf("failed to create ipset: %s", err.Error())
+	}
+	if err = ipset.Refresh(nsc.podCIDRs); err != nil {
+		return fmt.Errorf("failed to sync ipset: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ipv4PodCIDRs(t *testing.T) {
+	assert.Equal(t, []string{"10.1.0.0/24", "10.200.0.0/24"},
+		ipv4PodCIDRs([]string{"10.1.0.0/24", "2001:db8:42::/64", "invalid", "10.200.0.1/24"}),
+		"expected the valid IPv4 pod CIDRs, normalized")
+}
+
+func Test_legacyPodCIDRMatches(t *testing.T) {
+	nsc := &NetworkServicesController{podCIDRs: []string{"10.1.0.0/24", "10.200.0.0/24"}}
+	assert.Equal(t, [][]string{
+		{"!", "-s", "10.1.0.0/24", "!", "-d", "10.1.0.0/24"},
+		{"!", "-s", "10.200.0.0/24", "!", "-d", "10.200.0.0/24"},
+	}, nsc.legacyPodCIDRMatches())
+}
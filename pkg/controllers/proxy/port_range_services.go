@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/moby/ipvs"
+	"k8s.io/klog/v2"
+)
+
+// parsePortRange returns the range of ports given by the port range annotation of a service, formatted as iptables
+// expects it (first:last), or an empty string when the port of the service is not within the range
+func parsePortRange(annotation string, port int) (string, error) {
+	bounds := strings.Split(strings.TrimSpace(annotation), "-")
+	if len(bounds) != 2 {
+		return "", fmt.Errorf("port range %q is not of the first-last form", annotation)
+	}
+	first, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 16)
+	if err != nil || first == 0 {
+		return "", fmt.Errorf("first port of the port range %q is not a valid port", annotation)
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 16)
+	if err != nil || last < first {
+		return "", fmt.Errorf("last port of the port range %q is not a valid port after the first one", annotation)
+	}
+	if uint64(port) < first || uint64(port) > last {
+		return "", nil
+	}
+	return fmt.Sprintf("%d:%d", first, last), nil
+}
+
+// lookupPortRangeByFWMark finds the VIP, protocol and range of ports of the port range service of the FW mark, it
+// returns false when the FW mark belongs to no port range service
+func (nsc *NetworkServicesController) lookupPortRangeByFWMark(fwMark uint32) (string, string, string, bool) {
+	serviceKeySplit := strings.Split(nsc.fwMarkMap[fwMark], "-")
+	if len(serviceKeySplit) != 3 || !strings.Contains(serviceKeySplit[2], ":") {
+		return "", "", "", false
+	}
+	return serviceKeySplit[0], serviceKeySplit[1], serviceKeySplit[2], true
+}
+
+// setupPortRangeService sets up the IPVS service serving the whole port range of the service on the VIP. Rather than
+// an IPVS service per port, the traffic to the ports of the range is marked with the FW mark of a single IPVS service
+// whose destinations have no port, so that IPVS forwards the traffic to the port it was sent to. The VIP is expected
+// to be assigned to the dummy interface already.
+func (nsc *NetworkServicesController) setupPortRangeService(ipvsSvcs []*ipvs.Service, svc *serviceInfo, vip string,
+	endpoints []endpointsInfo, activeServiceEndpointMap map[string][]string) error {
+	fwMark, err := nsc.generateUniqueFWMark(vip, svc.protocol, svc.portRange)
+	if err != nil {
+		return fmt.Errorf("failed to generate FW mark: %v", err)
+	}
+	ipvsPortRangeSvc, err := nsc.ln.ipvsAddFWMarkService(ipvsSvcs, fwMark, convertSvcProtoToSysCallProto(svc.protocol),
+		uint16(svc.port), svc.sessionAffinity, svc.sessionAffinityTimeoutSeconds, svc.scheduler, svc.flags)
+	if err != nil {
+		return fmt.Errorf("failed to create IPVS service for the port range %s of %s: %v", svc.portRange, vip, err)
+	}
+	if err = setupPortRangeMarkRule(vip, svc.protocol, svc.portRange, fwMark); err != nil {
+		return err
+	}
+
+	// several ports of the service may fall within the same range, they are all served by the same IPVS service
+	portRangeServiceID := fmt.Sprint(fwMark)
+	if _, ok := activeServiceEndpointMap[portRangeServiceID]; !ok {
+		activeServiceEndpointMap[portRangeServiceID] = make([]string, 0)
+	}
+	local := svc.local && hasActiveEndpoints(endpoints)
+	for _, endpoint := range endpoints {
+		if local && !endpoint.isLocal {
+			continue
+		}
+		dst := ipvs.Destination{
+			Address:       net.ParseIP(endpoint.ip),
+			AddressFamily: syscall.AF_INET,
+			Weight:        nsc.destinationWeight(endpoint, endpoints, local),
+		}
+		if err = nsc.ipvsApplyDestination(ipvsPortRangeSvc, &dst); err != nil {
+			klog.Errorf(err.Error())
+			continue
+		}
+		activeServiceEndpointMap[portRangeServiceID] = append(activeServiceEndpointMap[portRangeServiceID],
+			generateEndpointID(endpoint.ip, "0"))
+	}
+	return nil
+}
+
+// setupExternalIPForPortRangeService assigns the external IP to the dummy interface and sets up the IPVS service
+// serving the port range of the service on it
+func (nsc *NetworkServicesController) setupExternalIPForPortRangeService(svc *serviceInfo, externalIP string,
+	endpoints []endpointsInfo, activeServiceEndpointMap map[string][]string) error {
+	dummyVipInterface, err := nsc.ln.getKubeDummyInterface()
+	if err != nil {
+		return fmt.Errorf("failed creating dummy interface: %v", err)
+	}
+	ipvsSvcs, err := nsc.ln.ipvsGetServices()
+	if err != nil {
+		return fmt.Errorf("failed get list of IPVS services due to: %v", err)
+	}
+	err = nsc.ln.ipAddrAdd(dummyVipInterface, externalIP, nsc.nodeIP.String(), true)
+	if err != nil && err.Error() != IfaceHasAddr {
+		return fmt.Errorf("failed to assign external ip %s to dummy interface %s due to %v",
+			externalIP, KubeDummyIf, err)
+	}
+	return nsc.setupPortRangeService(ipvsSvcs, svc, externalIP, endpoints, activeServiceEndpointMap)
+}
+
+// setupPortRangeMarkRule sets up the iptables rules marking the traffic to the range of ports of the VIP with the FW
+// mark of its IPVS service
+func setupPortRangeMarkRule(vip, protocol, portRange string, fwMark uint32) error {
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("Failed to initialize iptables executor" + err.Error())
+	}
+	args, _, _ := mangleTableRuleArgs(vip, protocol, portRange, strconv.Itoa(int(fwMark)), 0, true)
+	for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+		if err = iptablesCmdHandler.AppendUnique("mangle", chain, args...); err != nil {
+			return errors.New("Failed to run iptables command to set up FWMARK due to " + err.Error())
+		}
+	}
+	return nil
+}
+
+// cleanupPortRangeService removes the iptables rules marking the traffic of the port range service of the FW mark and
+// forgets the FW mark
+func (nsc *NetworkServicesController) cleanupPortRangeService(fwMark uint32) error {
+	vip, protocol, portRange, ok := nsc.lookupPortRangeByFWMark(fwMark)
+	if !ok {
+		return fmt.Errorf("no port range service was found for FW mark: %d", fwMark)
+	}
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("Failed to initialize iptables executor" + err.Error())
+	}
+	args, _, _ := mangleTableRuleArgs(vip, protocol, portRange, strconv.Itoa(int(fwMark)), 0, true)
+	for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+		if err = iptablesCmdHandler.DeleteIfExists("mangle", chain, args...); err != nil {
+			return errors.New("Failed to cleanup iptables command to set up FWMARK due to " + err.Error())
+		}
+	}
+	delete(nsc.fwMarkMap, fwMark)
+	return nil
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parsePortRange(t *testing.T) {
+	testcases := []struct {
+		name       string
+		annotation string
+		port       int
+		expected   string
+		expectErr  bool
+	}{
+		{"port at the start of the range", "10000-20000", 10000, "10000:20000", false},
+		{"port within the range", " 10000 - 20000 ", 15000, "10000:20000", false},
+		{"port at the end of the range", "10000-20000", 20000, "10000:20000", false},
+		{"port out of the range", "10000-20000", 80, "", false},
+		{"single port range", "10000-10000", 10000, "10000:10000", false},
+		{"not a range", "10000", 10000, "", true},
+		{"reversed range", "20000-10000", 10000, "", true},
+		{"port zero", "0-100", 10, "", true},
+		{"port too high", "60000-70000", 60000, "", true},
+		{"not a number", "a-b", 10, "", true},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			portRange, err := parsePortRange(testcase.annotation, testcase.port)
+			if testcase.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testcase.expected, portRange)
+		})
+	}
+}
+
+func TestNetworkServicesController_lookupPortRangeByFWMark(t *testing.T) {
+	nsc := &NetworkServicesController{fwMarkMap: map[uint32]string{}}
+	rangeMark, err := nsc.generateUniqueFWMark("10.0.0.1", "udp", "10000:20000")
+	assert.NoError(t, err)
+	portMark, err := nsc.generateUniqueFWMark("10.0.0.1", "udp", "53")
+	assert.NoError(t, err)
+
+	vip, protocol, portRange, ok := nsc.lookupPortRangeByFWMark(rangeMark)
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1", vip)
+	assert.Equal(t, "udp", protocol)
+	assert.Equal(t, "10000:20000", portRange)
+
+	_, _, _, ok = nsc.lookupPortRangeByFWMark(portMark)
+	assert.False(t, ok, "expected the FW mark of a single port not to be a port range service")
+	_, _, _, ok = nsc.lookupPortRangeByFWMark(rangeMark + portMark + 1)
+	assert.False(t, ok, "expected an unknown FW mark not to be a port range service")
+
+	_, _, _, err = nsc.lookupServiceByFWMark(rangeMark)
+	assert.Error(t, err, "expected the port range service not to be mistaken for a single port service")
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -11,9 +12,13 @@ import (
 	"time"
 
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/tracing"
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/moby/ipvs"
 	"github.com/vishvananda/netlink"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 )
@@ -32,54 +37,102 @@ func (nsc *NetworkServicesController) syncIpvsServices(serviceInfoMap serviceInf
 	}()
 
 	var err error
-	var syncErrors bool
+	// the errors of the parts of the sync that failed, which doesn't stop the other parts, are reported together
+	var syncErrors []string
+	ctx, syncSpan := tracer.Start(context.Background(), "proxy.syncIpvsServices",
+		trace.WithAttributes(attribute.Int("services", len(serviceInfoMap))))
+	defer syncSpan.End()
 
 	// map to track all active IPVS services and servers that are setup during sync of
 	// cluster IP, nodeport and external IP services
 	activeServiceEndpointMap := make(map[string][]string)
 
+	nsc.trackSlowStart(endpointsInfoMap)
+	// only the destinations that differ from the ones in place are applied
+	_, span := tracer.Start(ctx, "read IPVS state")
+	nsc.ipvsState, err = readIPVSState(nsc.ln)
+	tracing.End(span, err)
+	if err != nil {
+		klog.Errorf("Failed to read the IPVS state, applying all the destinations: %s", err.Error())
+	}
+	defer func() { nsc.ipvsState = nil }()
+	_, span = tracer.Start(ctx, "setup cluster IP services")
 	err = nsc.setupClusterIPServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
+	tracing.End(span, err)
 	if err != nil {
-		syncErrors = true
 		klog.Errorf("Error setting up IPVS services for service cluster IP's: %s", err.Error())
+		syncErrors = append(syncErrors, "setup cluster IP services: "+err.Error())
 	}
+	_, span = tracer.Start(ctx, "setup NodePort services")
 	err = nsc.setupNodePortServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
+	tracing.End(span, err)
 	if err != nil {
-		syncErrors = true
 		klog.Errorf("Error setting up IPVS services for service nodeport's: %s", err.Error())
+		syncErrors = append(syncErrors, "setup NodePort services: "+err.Error())
 	}
+	_, span = tracer.Start(ctx, "setup external IP services")
 	err = nsc.setupExternalIPServices(serviceInfoMap, endpointsInfoMap, activeServiceEndpointMap)
+	tracing.End(span, err)
 	if err != nil {
-		syncErrors = true
 		klog.Errorf("Error setting up IPVS services for service external IP's and load balancer IP's: %s",
 			err.Error())
+		syncErrors = append(syncErrors, "setup external IP services: "+err.Error())
 	}
+	_, span = tracer.Start(ctx, "cleanup stale VIPs")
 	err = nsc.cleanupStaleVIPs(activeServiceEndpointMap)
+	tracing.End(span, err)
 	if err != nil {
-		syncErrors = true
 		klog.Errorf("Error cleaning up stale VIP's configured on the dummy interface: %s", err.Error())
+		syncErrors = append(syncErrors, "cleanup stale VIPs: "+err.Error())
 	}
+	_, span = tracer.Start(ctx, "cleanup stale IPVS services")
 	err = nsc.cleanupStaleIPVSConfig(activeServiceEndpointMap)
+	tracing.End(span, err)
 	if err != nil {
-		syncErrors = true
 		klog.Errorf("Error cleaning up stale IPVS services and servers: %s", err.Error())
+		syncErrors = append(syncErrors, "cleanup stale IPVS services: "+err.Error())
 	}
 
 	nsc.cleanupStaleMetrics(activeServiceEndpointMap)
 
-	err = nsc.syncIpvsFirewall()
+	_, span = tracer.Start(ctx, "sync IPVS firewall")
+	err = nsc.syncIpvsFirewall(serviceInfoMap, endpointsInfoMap)
+	tracing.End(span, err)
 	if err != nil {
-		syncErrors = true
 		klog.Errorf("Error syncing ipvs svc iptables rules to permit traffic to service VIP's: %s", err.Error())
+		nsc.recordIptablesSyncFailure("IPVS firewall", err)
 	}
+	if nsc.ndpProxy {
+		_, span = tracer.Start(ctx, "sync NDP proxy entries")
+		err = nsc.syncNDPProxyEntries(serviceInfoMap, endpointsInfoMap)
+		tracing.End(span, err)
+		if err != nil {
+			klog.Errorf("Error syncing NDP proxy entries for IPv6 service VIP's: %s", err.Error())
+			syncErrors = append(syncErrors, "sync NDP proxy entries: "+err.Error())
+		}
+	}
+	if nsc.announceVIPs {
+		_, span = tracer.Start(ctx, "announce VIPs")
+		err = nsc.announceNewVIPs(serviceInfoMap, endpointsInfoMap)
+		tracing.End(span, err)
+		if err != nil {
+			klog.Errorf("Error announcing service VIP's on the node's L2 network: %s", err.Error())
+			syncErrors = append(syncErrors, "announce VIPs: "+err.Error())
+		}
+	}
+	_, span = tracer.Start(ctx, "setup DSR")
 	err = nsc.setupForDSR(serviceInfoMap)
+	tracing.End(span, err)
 	if err != nil {
-		syncErrors = true
 		klog.Errorf("Error setting up necessary policy based routing configuration needed for "+
 			"direct server return: %s", err.Error())
+		syncErrors = append(syncErrors, "setup DSR: "+err.Error())
 	}
 
-	if syncErrors {
+	if len(syncErrors) > 0 {
+		utils.RecordNodeWarning(nsc.eventRecorder, nsc.nodeHostName, serviceProxySyncFailedEventReason,
+			"Failed to sync the IPVS services: %s", strings.Join(syncErrors, "; "))
+		syncSpan.SetStatus(codes.Error, strings.Join(syncErrors, "; "))
 		klog.V(1).Info("One or more errors encountered during sync of IPVS services and servers " +
 			"to desired state")
 	} else {
@@ -96,19 +149,32 @@ func (nsc *NetworkServicesController) setupClusterIPServices(serviceInfoMap serv
 		return errors.New("Failed get list of IPVS services due to: " + err.Error())
 	}
 	for k, svc := range serviceInfoMap {
+		// the listeners of the Gateways are only served on the addresses of the Gateways
+		if svc.clusterIP == nil {
+			continue
+		}
 		protocol := convertSvcProtoToSysCallProto(svc.protocol)
 
-		endpoints := endpointsInfoMap[k]
+		endpoints := topologyEndpoints(svc, endpointsInfoMap[k])
 		dummyVipInterface, err := nsc.ln.getKubeDummyInterface()
 		if err != nil {
 			return errors.New("Failed creating dummy interface: " + err.Error())
 		}
 		// assign cluster IP of the service to the dummy interface so that its routable from the pod's on the node
-		err = nsc.ln.ipAddrAdd(dummyVipInterface, svc.clusterIP.String(), true)
+		err = nsc.ln.ipAddrAdd(dummyVipInterface, svc.clusterIP.String(), nsc.nodeIP.String(), true)
 		if err != nil {
 			continue
 		}
 
+		if svc.portRange != "" {
+			err = nsc.setupPortRangeService(ipvsSvcs, svc, svc.clusterIP.String(), endpoints,
+				activeServiceEndpointMap)
+			if err != nil {
+				klog.Errorf("Failed to create ipvs service for the port range of cluster ip: %s", err.Error())
+			}
+			continue
+		}
+
 		// create IPVS service for the service to be exposed through the cluster ip
 		ipvsClusterVipSvc, err := nsc.ln.ipvsAddService(ipvsSvcs, svc.clusterIP, protocol, uint16(svc.port),
 			svc.sessionAffinity, svc.sessionAffinityTimeoutSeconds, svc.scheduler, svc.flags)
@@ -125,7 +191,7 @@ func (nsc *NetworkServicesController) setupClusterIPServices(serviceInfoMap serv
 				Address:       net.ParseIP(endpoint.ip),
 				AddressFamily: syscall.AF_INET,
 				Port:          uint16(endpoint.port),
-				Weight:        1,
+				Weight:        nsc.destinationWeight(endpoint, endpoints, svc.local && hasActiveEndpoints(endpoints)),
 			}
 			// Conditions on which to add an endpoint on this node:
 			// 1) Service is not a local service
@@ -137,7 +203,7 @@ func (nsc *NetworkServicesController) setupClusterIPServices(serviceInfoMap serv
 				}
 			}
 
-			err := nsc.ln.ipvsAddServer(ipvsClusterVipSvc, &dst)
+			err := nsc.ipvsApplyDestination(ipvsClusterVipSvc, &dst)
 			if err != nil {
 				klog.Errorf(err.Error())
 			} else {
@@ -155,6 +221,13 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 	if err != nil {
 		return errors.New("Failed get list of IPVS services due to: " + err.Error())
 	}
+	nodePortIPs, err := nsc.getNodePortIPs()
+	if err != nil {
+		return fmt.Errorf("failed to get the addresses of the node serving NodePorts: %v", err)
+	}
+	if len(nodePortIPs) == 0 {
+		klog.Errorf("No IP addresses returned for nodeport service creation!")
+	}
 	for k, svc := range serviceInfoMap {
 		protocol := convertSvcProtoToSysCallProto(svc.protocol)
 
@@ -162,7 +235,7 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 			// service is not NodePort type
 			continue
 		}
-		endpoints := endpointsInfoMap[k]
+		endpoints := topologyEndpoints(svc, endpointsInfoMap[k])
 		if svc.local && !hasActiveEndpoints(endpoints) {
 			klog.V(1).Infof("Skipping setting up NodePort service %s/%s as it does not have active endpoints",
 				svc.namespace, svc.name)
@@ -170,50 +243,20 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 		}
 
 		// create IPVS service for the service to be exposed through the nodeport
-		var ipvsNodeportSvcs []*ipvs.Service
-
-		var nodeServiceIds []string
-
-		if nsc.nodeportBindOnAllIP {
-			// bind on all interfaces instead
-			addrs, err := getAllLocalIPs()
-
-			if err != nil {
-				klog.Errorf("Could not get list of system addresses for ipvs services: %s", err.Error())
-				continue
-			}
-
-			if len(addrs) == 0 {
-				klog.Errorf("No IP addresses returned for nodeport service creation!")
-				continue
-			}
-
-			ipvsNodeportSvcs = make([]*ipvs.Service, len(addrs))
-			nodeServiceIds = make([]string, len(addrs))
-
-			for i, addr := range addrs {
-				ipvsNodeportSvcs[i], err = nsc.ln.ipvsAddService(ipvsSvcs, addr.IP, protocol, uint16(svc.nodePort),
-					svc.sessionAffinity, svc.sessionAffinityTimeoutSeconds, svc.scheduler, svc.flags)
-				if err != nil {
-					klog.Errorf("Failed to create ipvs service for node port due to: %s", err.Error())
-					continue
-				}
-
-				nodeServiceIds[i] = generateIPPortID(addr.IP.String(), svc.protocol, strconv.Itoa(svc.nodePort))
-				activeServiceEndpointMap[nodeServiceIds[i]] = make([]string, 0)
-			}
-		} else {
-			ipvsNodeportSvcs = make([]*ipvs.Service, 1)
-			ipvsNodeportSvcs[0], err = nsc.ln.ipvsAddService(ipvsSvcs, nsc.nodeIP, protocol, uint16(svc.nodePort),
-				svc.sessionAffinity, svc.sessionAffinityTimeoutSeconds, svc.scheduler, svc.flags)
+		ipvsNodeportSvcs := make([]*ipvs.Service, 0, len(nodePortIPs))
+		nodeServiceIds := make([]string, 0, len(nodePortIPs))
+		for _, nodePortIP := range nodePortIPs {
+			ipvsNodeportSvc, err := nsc.ln.ipvsAddService(ipvsSvcs, net.ParseIP(nodePortIP), protocol,
+				uint16(svc.nodePort), svc.sessionAffinity, svc.sessionAffinityTimeoutSeconds, svc.scheduler, svc.flags)
 			if err != nil {
 				klog.Errorf("Failed to create ipvs service for node port due to: %s", err.Error())
 				continue
 			}
 
-			nodeServiceIds = make([]string, 1)
-			nodeServiceIds[0] = generateIPPortID(nsc.nodeIP.String(), svc.protocol, strconv.Itoa(svc.nodePort))
-			activeServiceEndpointMap[nodeServiceIds[0]] = make([]string, 0)
+			nodeServiceID := generateIPPortID(nodePortIP, svc.protocol, strconv.Itoa(svc.nodePort))
+			activeServiceEndpointMap[nodeServiceID] = make([]string, 0)
+			ipvsNodeportSvcs = append(ipvsNodeportSvcs, ipvsNodeportSvc)
+			nodeServiceIds = append(nodeServiceIds, nodeServiceID)
 		}
 
 		for _, endpoint := range endpoints {
@@ -221,11 +264,11 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 				Address:       net.ParseIP(endpoint.ip),
 				AddressFamily: syscall.AF_INET,
 				Port:          uint16(endpoint.port),
-				Weight:        1,
+				Weight:        nsc.destinationWeight(endpoint, endpoints, svc.local),
 			}
 			for i := 0; i < len(ipvsNodeportSvcs); i++ {
 				if !svc.local || (svc.local && endpoint.isLocal) {
-					err := nsc.ln.ipvsAddServer(ipvsNodeportSvcs[i], &dst)
+					err := nsc.ipvsApplyDestination(ipvsNodeportSvcs[i], &dst)
 					if err != nil {
 						klog.Errorf(err.Error())
 					} else {
@@ -243,7 +286,7 @@ func (nsc *NetworkServicesController) setupNodePortServices(serviceInfoMap servi
 func (nsc *NetworkServicesController) setupExternalIPServices(serviceInfoMap serviceInfoMap,
 	endpointsInfoMap endpointsInfoMap, activeServiceEndpointMap map[string][]string) error {
 	for k, svc := range serviceInfoMap {
-		endpoints := endpointsInfoMap[k]
+		endpoints := topologyEndpoints(svc, endpointsInfoMap[k])
 
 		extIPSet := sets.NewString(svc.externalIPs...)
 		if !svc.skipLbIps {
@@ -261,8 +304,18 @@ func (nsc *NetworkServicesController) setupExternalIPServices(serviceInfoMap ser
 			continue
 		}
 		for _, externalIP := range extIPSet.List() {
+			if svc.portRange != "" {
+				// the port range is served by a single IPVS service, which adds itself to the
+				// activeServiceEndpointMap
+				err := nsc.setupExternalIPForPortRangeService(svc, externalIP, endpoints, activeServiceEndpointMap)
+				if err != nil {
+					return fmt.Errorf("failed to setup port range service endpoint %s: %v", externalIP, err)
+				}
+				continue
+			}
 			var externalIPServiceID string
-			if svc.directServerReturn && svc.directServerReturnMethod == tunnelInterfaceType {
+			if svc.directServerReturn && (svc.directServerReturnMethod == tunnelInterfaceType ||
+				svc.directServerReturnMethod == directRouteMethod) {
 				// for a DSR service, do the work necessary to set up the IPVS service for DSR, then use the FW mark
 				// that was generated to add this external IP to the activeServiceEndpointMap
 				if err := nsc.setupExternalIPForDSRService(svc, externalIP, endpoints); err != nil {
@@ -314,7 +367,7 @@ func (nsc *NetworkServicesController) setupExternalIPForService(svc *serviceInfo
 	}
 
 	// ensure director with vip assigned
-	err = nsc.ln.ipAddrAdd(dummyVipInterface, externalIP, true)
+	err = nsc.ln.ipAddrAdd(dummyVipInterface, externalIP, nsc.nodeIP.String(), true)
 	if err != nil && err.Error() != IfaceHasAddr {
 		return fmt.Errorf("failed to assign external ip %s to dummy interface %s due to %v",
 			externalIP, KubeDummyIf, err)
@@ -353,10 +406,10 @@ func (nsc *NetworkServicesController) setupExternalIPForService(svc *serviceInfo
 			Address:       net.ParseIP(endpoint.ip),
 			AddressFamily: syscall.AF_INET,
 			Port:          uint16(endpoint.port),
-			Weight:        1,
+			Weight:        nsc.destinationWeight(endpoint, endpoints, svc.local),
 		}
 
-		if err = nsc.ln.ipvsAddServer(ipvsExternalIPSvc, &dst); err != nil {
+		if err = nsc.ipvsApplyDestination(ipvsExternalIPSvc, &dst); err != nil {
 			return fmt.Errorf("unable to add destination %s to externalIP service %s: %v",
 				endpoint.ip, externalIP, err)
 		}
@@ -407,7 +460,7 @@ func (nsc *NetworkServicesController) setupExternalIPForDSRService(svc *serviceI
 	}
 
 	// ensure VIP less director. we dont assign VIP to any interface
-	err = nsc.ln.ipAddrDel(dummyVipInterface, externalIP)
+	err = nsc.ln.ipAddrDel(dummyVipInterface, externalIP, nsc.nodeIP.String())
 	if err != nil && err.Error() != IfaceHasNoAddr {
 		return fmt.Errorf("failed to delete external ip address from dummyVipInterface due to %v", err)
 	}
@@ -430,19 +483,25 @@ func (nsc *NetworkServicesController) setupExternalIPForDSRService(svc *serviceI
 		dst := ipvs.Destination{
 			Address:         net.ParseIP(endpoint.ip),
 			AddressFamily:   syscall.AF_INET,
-			ConnectionFlags: ipvs.ConnectionFlagTunnel,
+			ConnectionFlags: dsrConnectionFlags(svc, endpoint),
 			Port:            uint16(endpoint.port),
-			Weight:          1,
+			Weight:          nsc.destinationWeight(endpoint, endpoints, svc.local),
 		}
 
 		// add the destination for the IPVS service for this external IP
-		if err = nsc.ln.ipvsAddServer(ipvsExternalIPSvc, &dst); err != nil {
+		if guePort := nsc.dsrGUEPort(svc); guePort != 0 && dst.ConnectionFlags == ipvs.ConnectionFlagTunnel {
+			err = ipvsAddGUEServer(ipvsExternalIPSvc, &dst, guePort)
+		} else {
+			err = nsc.ipvsApplyDestination(ipvsExternalIPSvc, &dst)
+		}
+		if err != nil {
 			return fmt.Errorf("unable to add destination %s to externalIP service %s: %v",
 				endpoint.ip, externalIP, err)
 		}
 
 		// add the external IP to a virtual interface inside the pod so that the pod can receive it
-		if err = nsc.addDSRIPInsidePodNetNamespace(externalIP, endpoint.ip); err != nil {
+		if err = nsc.addDSRIPInsidePodNetNamespace(externalIP, endpoint.ip, svc.directServerReturnMethod,
+			nsc.dsrGUEPort(svc)); err != nil {
 			return fmt.Errorf("unable to setup DSR receiver inside pod: %v", err)
 		}
 	}
@@ -450,6 +509,16 @@ func (nsc *NetworkServicesController) setupExternalIPForDSRService(svc *serviceI
 	return nil
 }
 
+// dsrConnectionFlags returns the IPVS forwarding method of the endpoint of a DSR service. Direct routing only rewrites
+// the destination MAC of the packets, so it can only reach the pods of this node, which share the kube-bridge with it,
+// the pods on other nodes are reached through an ipip tunnel like with the tunnel method.
+func dsrConnectionFlags(svc *serviceInfo, endpoint endpointsInfo) uint32 {
+	if svc.directServerReturnMethod == directRouteMethod && endpoint.isLocal {
+		return ipvs.ConnectionFlagDirectRoute
+	}
+	return ipvs.ConnectionFlagTunnel
+}
+
 func (nsc *NetworkServicesController) setupForDSR(serviceInfoMap serviceInfoMap) error {
 	klog.V(1).Infof("Setting up policy routing required for Direct Server Return functionality.")
 	err := nsc.ln.setupPolicyRoutingForDSR()
@@ -484,6 +553,11 @@ func (nsc *NetworkServicesController) cleanupStaleVIPs(activeServiceEndpointMap
 		if strings.Contains(k, "-") {
 			parts := strings.SplitN(k, "-", expectedServiceIDParts)
 			addrActive[parts[0]] = true
+		} else if fwMark, err := strconv.ParseUint(k, 10, 32); err == nil {
+			// the VIPs of port range services are kept on the dummy interface, unlike the ones of DSR services
+			if vip, _, _, ok := nsc.lookupPortRangeByFWMark(uint32(fwMark)); ok {
+				addrActive[vip] = true
+			}
 		}
 	}
 
@@ -500,7 +574,7 @@ func (nsc *NetworkServicesController) cleanupStaleVIPs(activeServiceEndpointMap
 		isActive := addrActive[addr.IP.String()]
 		if !isActive {
 			klog.V(1).Infof("Found an IP %s which is no longer needed so cleaning up", addr.IP.String())
-			err := nsc.ln.ipAddrDel(dummyVipInterface, addr.IP.String())
+			err := nsc.ln.ipAddrDel(dummyVipInterface, addr.IP.String(), nsc.nodeIP.String())
 			if err != nil {
 				klog.Errorf("Failed to delete stale IP %s due to: %s",
 					addr.IP.String(), err.Error())
@@ -523,7 +597,7 @@ func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpoi
 	var protocol string
 	for _, ipvsSvc := range ipvsSvcs {
 		// Note that this isn't all that safe of an assumption because FWMark services have a completely different
-		// protocol. So do SCTP services. However, we don't deal with SCTP in kube-router and FWMark is handled below.
+		// protocol. FWMark is handled below.
 		protocol = convertSysCallProtoToSvcProto(ipvsSvc.Protocol)
 		// FWMark services by definition don't have a protocol, so we exclude those from the conditional so that they
 		// can be cleaned up correctly.
@@ -563,7 +637,12 @@ func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpoi
 
 			klog.V(1).Infof("Found a IPVS service %s which is no longer needed so cleaning up",
 				ipvsServiceString(ipvsSvc))
-			if ipvsSvc.FWMark != 0 {
+			udpAddress, udpPort, isUDP := nsc.ipvsServiceUDPAddress(ipvsSvc)
+			if _, _, _, isPortRange := nsc.lookupPortRangeByFWMark(ipvsSvc.FWMark); isPortRange {
+				if err = nsc.cleanupPortRangeService(ipvsSvc.FWMark); err != nil {
+					klog.Errorf("failed to cleanup port range service: %v", err)
+				}
+			} else if ipvsSvc.FWMark != 0 {
 				_, _, _, err = nsc.lookupServiceByFWMark(ipvsSvc.FWMark)
 				if err != nil {
 					klog.V(1).Infof("no FW mark found for service, nothing to cleanup: %v", err)
@@ -577,6 +656,13 @@ func (nsc *NetworkServicesController) cleanupStaleIPVSConfig(activeServiceEndpoi
 					ipvsServiceString(ipvsSvc), err.Error())
 				continue
 			}
+			// the clients of a removed UDP port would otherwise keep sending to its former destinations until their
+			// conntrack entries time out
+			if isUDP {
+				if err = flushConntrackUDP(udpAddress, udpPort, nil); err != nil {
+					klog.Errorf("Failed to flush conntrack: %s", err.Error())
+				}
+			}
 		} else {
 			dsts, err := nsc.ln.ipvsGetDestinations(ipvsSvc)
 			if err != nil {
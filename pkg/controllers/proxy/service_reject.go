@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"k8s.io/klog/v2"
+)
+
+const serviceNoEndpointsIPSetName = "kube-router-svc-no-endpoints"
+
+// getServicesWithoutEndpointsEntries returns the "ip,protocol:port" entries of the cluster, external and LoadBalancer
+// IPs of the services that have no ready endpoints at all. IPVS silently drops the traffic to these, which leaves the
+// clients hanging until they time out.
+func getServicesWithoutEndpointsEntries(serviceInfoMap serviceInfoMap, endpointsInfoMap endpointsInfoMap) []string {
+	entries := make([]string, 0)
+	for svcID, svc := range serviceInfoMap {
+		if len(endpointsInfoMap[svcID]) > 0 {
+			continue
+		}
+		vips := make([]string, 0)
+		if svc.clusterIP != nil {
+			vips = append(vips, svc.clusterIP.String())
+		}
+		vips = append(vips, svc.externalIPs...)
+		if !svc.skipLbIps {
+			vips = append(vips, svc.loadBalancerIPs...)
+		}
+		for _, vip := range vips {
+			// only IPv4 services are proxied through IPVS
+			if ip := net.ParseIP(vip); ip == nil || ip.To4() == nil {
+				continue
+			}
+			entries = append(entries, fmt.Sprintf("%s,%s:%d", vip, svc.protocol, svc.port))
+		}
+	}
+	return entries
+}
+
+func getServiceRejectInputChainRule() []string {
+	// The iptables rule for use in {setup,cleanup}ServiceRejectRule.
+	return []string{
+		"-m", "comment", "--comment", "reject traffic to services without endpoints",
+		"-m", "set", "--match-set", serviceNoEndpointsIPSetName, "dst,dst",
+		"-j", "REJECT", "--reject-with", "icmp-port-unreachable"}
+}
+
+// setupServiceRejectRule creates the ipset of the services without endpoints and the rule rejecting the traffic to
+// them. Like the NodePort firewall it has to run after setupIpvsFirewall, so that the rule is ahead of the jump to the
+// IPVS firewall which accepts the traffic to IPVS services.
+func (nsc *NetworkServicesController) setupServiceRejectRule() error {
+	ipSetHandler, err := utils.NewIPSet(false)
+	if err != nil {
+		return err
+	}
+	if nsc.ipsetMap == nil {
+		nsc.ipsetMap = make(map[string]*utils.Set)
+	}
+
+	ipset, err := ipSetHandler.Create(serviceNoEndpointsIPSetName, utils.TypeHashIPPort, utils.OptionTimeout, "0")
+	if err != nil {
+		return fmt.Errorf("failed to create ipset: %s", err.Error())
+	}
+	nsc.ipsetMap[serviceNoEndpointsIPSetName] = ipset
+
+	iptablesCmdHandler, err := iptables.New()
+	if err != nil {
+		return errors.New("failed to initialize iptables executor" + err.Error())
+	}
+
+	serviceRejectInputChainRule := getServiceRejectInputChainRule()
+	exists, err := iptablesCmdHandler.Exists("filter", "INPUT", serviceRejectInputChainRule...)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+	if exists {
+		err = iptablesCmdHandler.Delete("filter", "INPUT", serviceRejectInputChainRule...)
+		if err != nil {
+			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		}
+	}
+	err = iptablesCmdHandler.Insert("filter", "INPUT", 1, serviceRejectInputChainRule...)
+	if err != nil {
+		return fmt.Errorf("failed to run iptables command: %s", err.Error())
+	}
+
+	return nil
+}
+
+// syncServiceRejectRule populates the ipset of the services without endpoints, it must be called with the ipset mutex
+// held
+func (nsc *NetworkServicesController) syncServiceRejectRule(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap) error {
+	serviceNoEndpointsIPSet, ok := nsc.ipsetMap[serviceNoEndpointsIPSetName]
+	if !ok {
+		return fmt.Errorf("ipset of the services without endpoints has not been set up")
+	}
+
+	err := serviceNoEndpointsIPSet.Refresh(getServicesWithoutEndpointsEntries(serviceInfoMap, endpointsInfoMap))
+	if err != nil {
+		return fmt.Errorf("failed to sync ipset: %s", err.Error())
+	}
+	return nil
+}
+
+// cleanupServiceRejectRule removes the rule rejecting the traffic to services without endpoints, the ipset is
+// destroyed by cleanupIpvsFirewall along with its own
+func cleanupServiceRejectRule(iptablesCmdHandler *iptables.IPTables) {
+	serviceRejectInputChainRule := getServiceRejectInputChainRule()
+	exists, err := iptablesCmdHandler.Exists("filter", "INPUT", serviceRejectInputChainRule...)
+	if err != nil {
+		klog.V(1).Infof("failed to check if iptables rules exists: %v", err)
+	} else if exists {
+		err = iptablesCmdHandler.Delete("filter", "INPUT", serviceRejectInputChainRule...)
+		if err != nil {
+			klog.Errorf("failed to run iptables command: %v", err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getServicesWithoutEndpointsEntries(t *testing.T) {
+	svcs := serviceInfoMap{
+		"default/with-endpoints": &serviceInfo{
+			clusterIP:   net.ParseIP("10.96.0.10"),
+			protocol:    "tcp",
+			port:        80,
+			externalIPs: []string{"1.1.1.1"},
+		},
+		"default/without-endpoints": &serviceInfo{
+			clusterIP:       net.ParseIP("10.96.0.11"),
+			protocol:        "udp",
+			port:            53,
+			externalIPs:     []string{"1.1.1.2", "2001:db8::1"},
+			loadBalancerIPs: []string{"1.1.1.3"},
+		},
+		"default/without-endpoints-skip-lb": &serviceInfo{
+			clusterIP:       net.ParseIP("10.96.0.12"),
+			protocol:        "tcp",
+			port:            443,
+			loadBalancerIPs: []string{"1.1.1.4"},
+			skipLbIps:       true,
+		},
+	}
+	eps := endpointsInfoMap{
+		"default/with-endpoints": []endpointsInfo{{ip: "10.1.0.10", port: 8080}},
+	}
+
+	assert.ElementsMatch(t, []string{"10.96.0.11,udp:53", "1.1.1.2,udp:53", "1.1.1.3,udp:53", "10.96.0.12,tcp:443"},
+		getServicesWithoutEndpointsEntries(svcs, eps),
+		"expected the IPv4 VIPs of the services without endpoints, except skipped LoadBalancer IPs")
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// slowStartFullWeight is the IPVS weight of the endpoints once their slow start is over, the weight of the
+	// endpoints in slow start ramps up to it
+	slowStartFullWeight = 100
+	// slowStartSteps is the number of times the weight of the endpoints is raised during their slow start
+	slowStartSteps = 10
+)
+
+// slowStart ramps up the IPVS weight of the new endpoints of the services over its period, to not send a newly
+// started pod its full share of the connections of a service at once
+type slowStart struct {
+	period time.Duration
+	// firstSeen is when the endpoints, by their endpoint ID, were first given to a service, the zero time for those of
+	// the first sync, which have been there before kube-router started
+	firstSeen map[string]time.Time
+	synced    bool
+	// resyncPending when a sync to raise the weights of the endpoints in slow start is scheduled
+	resyncPending bool
+}
+
+func newSlowStart(period time.Duration) *slowStart {
+	return &slowStart{period: period, firstSeen: make(map[string]time.Time)}
+}
+
+// trackSlowStart records when the endpoints were first seen, and schedules a sync of the IPVS services to raise their
+// weight as long as some of them are in slow start. The caller holds the lock of the controller.
+func (nsc *NetworkServicesController) trackSlowStart(endpointsMap endpointsInfoMap) {
+	if nsc.slowStart == nil {
+		return
+	}
+	s := nsc.slowStart
+	now := time.Now()
+	seen := make(map[string]bool, len(s.firstSeen))
+	ramping := false
+	for _, endpoints := range endpointsMap {
+		for _, endpoint := range endpoints {
+			id := generateEndpointID(endpoint.ip, strconv.Itoa(endpoint.port))
+			seen[id] = true
+			if _, ok := s.firstSeen[id]; !ok {
+				s.firstSeen[id] = time.Time{}
+				if s.synced {
+					s.firstSeen[id] = now
+				}
+			}
+			ramping = ramping || now.Sub(s.firstSeen[id]) < s.period
+		}
+	}
+	for id := range s.firstSeen {
+		if !seen[id] {
+			delete(s.firstSeen, id)
+		}
+	}
+	s.synced = true
+
+	if !ramping || s.resyncPending {
+		return
+	}
+	s.resyncPending = true
+	time.AfterFunc(s.period/slowStartSteps, func() {
+		nsc.mu.Lock()
+		defer nsc.mu.Unlock()
+		s.resyncPending = false
+		if !nsc.readyForUpdates {
+			return
+		}
+		klog.V(2).Info("Syncing IPVS services to raise the weight of the endpoints in slow start")
+		nsc.sync(synctypeIpvs)
+	})
+}
+
+// destinationWeight returns the IPVS weight of the endpoint of a service, see endpointWeight, raised to
+// slowStartFullWeight over the slow start period of the endpoints with slow start
+func (nsc *NetworkServicesController) destinationWeight(endpoint endpointsInfo, endpoints []endpointsInfo,
+	local bool) int {
+	weight := endpointWeight(endpoint, endpoints, local)
+	if nsc.slowStart == nil || weight == 0 {
+		return weight
+	}
+	firstSeen, ok := nsc.slowStart.firstSeen[generateEndpointID(endpoint.ip, strconv.Itoa(endpoint.port))]
+	elapsed := time.Since(firstSeen)
+	if !ok || elapsed >= nsc.slowStart.period {
+		return weight * slowStartFullWeight
+	}
+	rampedWeight := int(int64(weight*slowStartFullWeight) * int64(elapsed) / int64(nsc.slowStart.period))
+	if rampedWeight < 1 {
+		return 1
+	}
+	return rampedWeight
+}
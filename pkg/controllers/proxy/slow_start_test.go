@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_slowStartWeights(t *testing.T) {
+	established := endpointsInfo{ip: "10.1.0.1", port: 8080}
+	started := endpointsInfo{ip: "10.1.0.2", port: 8080}
+	terminating := endpointsInfo{ip: "10.1.0.3", port: 8080, terminating: true}
+	nsc := &NetworkServicesController{slowStart: newSlowStart(time.Hour)}
+	// the resync is only scheduled once and returns without the controller being ready for updates
+	nsc.slowStart.resyncPending = true
+
+	nsc.trackSlowStart(endpointsInfoMap{"svc": {established}})
+	endpoints := []endpointsInfo{established, started, terminating}
+	nsc.trackSlowStart(endpointsInfoMap{"svc": endpoints})
+	assert.Equal(t, slowStartFullWeight, nsc.destinationWeight(established, endpoints, false),
+		"expected the endpoints of the first sync to have their full weight")
+	assert.Equal(t, 1, nsc.destinationWeight(started, endpoints, false),
+		"expected a new endpoint to start with the lowest weight")
+	assert.Equal(t, 0, nsc.destinationWeight(terminating, endpoints, false))
+
+	nsc.slowStart.firstSeen[generateEndpointID("10.1.0.2", "8080")] = time.Now().Add(-30 * time.Minute)
+	assert.InDelta(t, slowStartFullWeight/2, nsc.destinationWeight(started, endpoints, false), 1)
+
+	nsc.trackSlowStart(endpointsInfoMap{"svc": {started}})
+	assert.Len(t, nsc.slowStart.firstSeen, 1, "expected the removed endpoints to be forgotten")
+
+	assert.Equal(t, 1, (&NetworkServicesController{}).destinationWeight(started, endpoints, false),
+		"expected the weight of 1 without slow start")
+}
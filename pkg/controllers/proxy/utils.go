@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"net"
@@ -37,8 +38,8 @@ func attemptNamespaceResetAfterError(hostNSHandle netns.NsHandle) {
 	_ = activeNetworkNamespaceHandle.Close()
 }
 
-func (ln *linuxNetworking) configureContainerForDSR(
-	vip, endpointIP, containerID string, pid int, hostNetworkNamespaceHandle netns.NsHandle) error {
+func (ln *linuxNetworking) configureContainerForDSR(vip, dsrMethod string, guePort uint16, endpointIP,
+	containerID string, pid int, hostNetworkNamespaceHandle netns.NsHandle) error {
 	endpointNamespaceHandle, err := netns.GetFromPid(pid)
 	if err != nil {
 		return fmt.Errorf("failed to get endpoint namespace (containerID=%s, pid=%d, error=%v)",
@@ -118,11 +119,32 @@ func (ln *linuxNetworking) configureContainerForDSR(
 		return fmt.Errorf("failed to bring up ipip tunnel interface in endpoint namespace due to %v", err)
 	}
 
-	// assign VIP to the KUBE_TUNNEL_IF interface
-	err = ln.ipAddrAdd(tunIf, vip, false)
-	if err != nil && err.Error() != IfaceHasAddr {
-		attemptNamespaceResetAfterError(hostNetworkNamespaceHandle)
-		return fmt.Errorf("failed to assign vip %s to kube-tunnel-if interface", vip)
+	// the GUE encapsulated traffic is decapsulated by the UDP port, which hands the ipip packets it carries to the
+	// tunnel interface
+	if guePort != 0 {
+		err = netlink.FouAdd(netlink.Fou{Family: syscall.AF_INET, Port: int(guePort), EncapType: netlink.FOU_ENCAP_GUE})
+		if err != nil && !errors.Is(err, syscall.EEXIST) {
+			attemptNamespaceResetAfterError(hostNetworkNamespaceHandle)
+			return fmt.Errorf("failed to listen for GUE traffic on port %d in endpoint namespace due to %v",
+				guePort, err)
+		}
+	}
+
+	if dsrMethod == directRouteMethod {
+		// the packets forwarded by the director of this node aren't encapsulated, the pod receives them for the VIP
+		// on its eth0, so the VIP is assigned to the loopback interface where the traffic tunneled from the other
+		// nodes is delivered as well
+		if err = ln.configureLoopbackForDirectRoute(vip); err != nil {
+			attemptNamespaceResetAfterError(hostNetworkNamespaceHandle)
+			return err
+		}
+	} else {
+		// assign VIP to the KUBE_TUNNEL_IF interface
+		err = ln.ipAddrAdd(tunIf, vip, "", false)
+		if err != nil && err.Error() != IfaceHasAddr {
+			attemptNamespaceResetAfterError(hostNetworkNamespaceHandle)
+			return fmt.Errorf("failed to assign vip %s to kube-tunnel-if interface", vip)
+		}
 	}
 	klog.Infof("Successfully assigned VIP: %s in endpoint %s.", vip, endpointIP)
 
@@ -166,6 +188,30 @@ func (ln *linuxNetworking) configureContainerForDSR(
 	return nil
 }
 
+// configureLoopbackForDirectRoute assigns the VIP to the loopback interface of the network namespace it is run in, and
+// keeps the namespace from answering ARP requests for the VIP or using it as source of its own, which would steal the
+// VIP from the director on the kube-bridge
+func (ln *linuxNetworking) configureLoopbackForDirectRoute(vip string) error {
+	loIf, err := netlink.LinkByName("lo")
+	if err != nil {
+		return fmt.Errorf("failed to get the loopback interface of the endpoint namespace due to %v", err)
+	}
+	err = ln.ipAddrAdd(loIf, vip, "", false)
+	if err != nil && err.Error() != IfaceHasAddr {
+		return fmt.Errorf("failed to assign vip %s to the loopback interface", vip)
+	}
+
+	sysctlErr := utils.SetSysctl(utils.IPv4ConfAllArpIgnore, arpIgnoreReplyOnlyIfTargetIPIsLocal)
+	if sysctlErr != nil {
+		return fmt.Errorf("failed to set arp_ignore in the endpoint container: %s", sysctlErr.Error())
+	}
+	sysctlErr = utils.SetSysctl(utils.IPv4ConfAllArpAnnounce, arpAnnounceUseBestLocalAddress)
+	if sysctlErr != nil {
+		return fmt.Errorf("failed to set arp_announce in the endpoint container: %s", sysctlErr.Error())
+	}
+	return nil
+}
+
 // generateUniqueFWMark generates a unique uint32 hash value using the IP address, port, and protocol. This can then
 // be used in IPVS, ip rules, and iptables to mark and later identify packets. FWMarks along with ip, port, and protocol
 // are then stored in a map on the NSC and can be used later for lookup and as a general translation layer. If after
@@ -294,6 +340,8 @@ func convertSvcProtoToSysCallProto(svcProtocol string) uint16 {
 		return syscall.IPPROTO_TCP
 	case udpProtocol:
 		return syscall.IPPROTO_UDP
+	case sctpProtocol:
+		return syscall.IPPROTO_SCTP
 	default:
 		return syscall.IPPROTO_NONE
 	}
@@ -307,6 +355,8 @@ func convertSysCallProtoToSvcProto(sysProtocol uint16) string {
 		return tcpProtocol
 	case syscall.IPPROTO_UDP:
 		return udpProtocol
+	case syscall.IPPROTO_SCTP:
+		return sctpProtocol
 	default:
 		return noneProtocol
 	}
@@ -315,7 +365,8 @@ func convertSysCallProtoToSvcProto(sysProtocol uint16) string {
 // addDSRIPInsidePodNetNamespace takes a given external IP and endpoint IP for a DSR service and then uses the container
 // runtime to add the external IP to a virtual interface inside the pod so that it can receive DSR traffic inside its
 // network namespace.
-func (nsc *NetworkServicesController) addDSRIPInsidePodNetNamespace(externalIP, endpointIP string) error {
+func (nsc *NetworkServicesController) addDSRIPInsidePodNetNamespace(externalIP, endpointIP, dsrMethod string,
+	guePort uint16) error {
 	podObj, err := nsc.getPodObjectForEndpoint(endpointIP)
 	if err != nil {
 		return fmt.Errorf("failed to find endpoint with ip: %s. so skipping preparing endpoint for DSR",
@@ -341,7 +392,7 @@ func (nsc *NetworkServicesController) addDSRIPInsidePodNetNamespace(externalIP,
 
 	if runtime == "docker" {
 		// WARN: This method is deprecated and will be removed once docker-shim is removed from kubelet.
-		err = nsc.ln.prepareEndpointForDsrWithDocker(containerID, endpointIP, externalIP)
+		err = nsc.ln.prepareEndpointForDsrWithDocker(containerID, endpointIP, externalIP, dsrMethod, guePort)
 		if err != nil {
 			return fmt.Errorf("failed to prepare endpoint %s to do direct server return due to %v",
 				endpointIP, err)
@@ -350,7 +401,7 @@ func (nsc *NetworkServicesController) addDSRIPInsidePodNetNamespace(externalIP,
 		// We expect CRI compliant runtimes here
 		// ugly workaround, refactoring of pkg/Proxy is required
 		err = nsc.ln.(*linuxNetworking).prepareEndpointForDsrWithCRI(nsc.dsr.runtimeEndpoint,
-			containerID, endpointIP, externalIP)
+			containerID, endpointIP, externalIP, dsrMethod, guePort)
 		if err != nil {
 			return fmt.Errorf("failed to prepare endpoint %s to do DSR due to: %v", endpointIP, err)
 		}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"syscall"
 	"testing"
 
+	"github.com/moby/ipvs"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -132,3 +134,38 @@ func TestNetworkServicesController_lookupServiceByFWMark(t *testing.T) {
 		assert.Zero(t, foundPort, "port should be zero on error")
 	})
 }
+
+func Test_convertSvcProtoToSysCallProto(t *testing.T) {
+	for _, protocol := range []string{tcpProtocol, udpProtocol, sctpProtocol} {
+		assert.Equal(t, protocol, convertSysCallProtoToSvcProto(convertSvcProtoToSysCallProto(protocol)),
+			"expected protocol %s to be converted both ways", protocol)
+	}
+	assert.Equal(t, noneProtocol, convertSysCallProtoToSvcProto(convertSvcProtoToSysCallProto("icmp")))
+}
+
+func TestNetworkServicesController_ipvsServiceUDPAddress(t *testing.T) {
+	nsc := getMoqNSC()
+	udpMark, err := nsc.generateUniqueFWMark("10.255.0.1", udpProtocol, "53")
+	assert.NoError(t, err)
+	tcpMark, err := nsc.generateUniqueFWMark("10.255.0.1", tcpProtocol, "53")
+	assert.NoError(t, err)
+
+	address, port, ok := nsc.ipvsServiceUDPAddress(&ipvs.Service{FWMark: udpMark})
+	assert.True(t, ok, "expected the UDP DSR service to be found by its FW mark")
+	assert.Equal(t, "10.255.0.1", address)
+	assert.Equal(t, 53, port)
+
+	_, _, ok = nsc.ipvsServiceUDPAddress(&ipvs.Service{FWMark: tcpMark})
+	assert.False(t, ok, "expected the TCP DSR service not to be a UDP one")
+	_, _, ok = nsc.ipvsServiceUDPAddress(&ipvs.Service{FWMark: 1002})
+	assert.False(t, ok, "expected an unknown FW mark not to be a UDP service")
+
+	address, port, ok = nsc.ipvsServiceUDPAddress(&ipvs.Service{Address: net.ParseIP("10.96.0.10"),
+		Protocol: syscall.IPPROTO_UDP, Port: 53})
+	assert.True(t, ok)
+	assert.Equal(t, "10.96.0.10", address)
+	assert.Equal(t, 53, port)
+	_, _, ok = nsc.ipvsServiceUDPAddress(&ipvs.Service{Address: net.ParseIP("10.96.0.10"),
+		Protocol: syscall.IPPROTO_TCP, Port: 53})
+	assert.False(t, ok)
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// getServedExternalVIPs returns the external and LoadBalancer IPs (in canonical form) of the services that this node
+// is able to serve
+func getServedExternalVIPs(serviceInfoMap serviceInfoMap, endpointsInfoMap endpointsInfoMap) sets.String {
+	vips := sets.NewString()
+	for k, svc := range serviceInfoMap {
+		// services with a local traffic policy can only be served from nodes that have endpoints for them
+		if svc.local && !hasActiveEndpoints(endpointsInfoMap[k]) {
+			continue
+		}
+		extIPSet := sets.NewString(svc.externalIPs...)
+		if !svc.skipLbIps {
+			extIPSet = extIPSet.Union(sets.NewString(svc.loadBalancerIPs...))
+		}
+		for _, externalIP := range extIPSet.List() {
+			if ip := net.ParseIP(externalIP); ip != nil {
+				vips.Insert(ip.String())
+			}
+		}
+	}
+	return vips
+}
+
+// announceNewVIPs sends a gratuitous ARP (or unsolicited neighbor advertisement for IPv6) on the node's interface for
+// each external VIP that this node started serving since the last sync. This way, when a VIP moves between nodes, L2
+// neighbors update their caches immediately instead of sending traffic to the old node until their entries expire.
+func (nsc *NetworkServicesController) announceNewVIPs(serviceInfoMap serviceInfoMap,
+	endpointsInfoMap endpointsInfoMap) error {
+	served := getServedExternalVIPs(serviceInfoMap, endpointsInfoMap)
+	newVIPs := served.Difference(nsc.announcedVIPs)
+	nsc.announcedVIPs = served
+	if newVIPs.Len() == 0 {
+		return nil
+	}
+
+	nodeIf, err := utils.GetNodeInterfaceFromNodeIP(nsc.nodeIP)
+	if err != nil {
+		return fmt.Errorf("failed to find the interface of the node IP %s: %v", nsc.nodeIP, err)
+	}
+	ifName := nodeIf.Attrs().Name
+
+	var announceErrs int
+	for _, vip := range newVIPs.List() {
+		if err := utils.AnnounceIP(ifName, net.ParseIP(vip)); err != nil {
+			klog.Errorf("Failed to announce service VIP %s on %s: %v", vip, ifName, err)
+			// make sure that we try again on the next sync
+			nsc.announcedVIPs.Delete(vip)
+			announceErrs++
+			continue
+		}
+		klog.V(2).Infof("Announced service VIP %s on %s", vip, ifName)
+	}
+	if announceErrs > 0 {
+		return fmt.Errorf("failed to announce %d of %d service VIPs", announceErrs, newVIPs.Len())
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	svcAdvertiseHealthCheckAnnotation = "kube-router.io/service.advertise.healthcheck"
+	svcAdvertiseLeaseAnnotation       = "kube-router.io/service.advertise.lease"
+
+	execHealthCheckPrefix = "exec:"
+)
+
+// healthCheck reports whether what it checks is healthy by returning nil
+type healthCheck func(ctx context.Context) error
+
+// newHealthCheck parses a health check, either an http:// or https:// URL which has to answer a GET with a 2xx or 3xx
+// status, or exec: followed by a command which has to exit with 0
+func newHealthCheck(spec string) (healthCheck, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, spec, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+				return fmt.Errorf("%s answered with status %d", spec, resp.StatusCode)
+			}
+			return nil
+		}, nil
+	case strings.HasPrefix(spec, execHealthCheckPrefix):
+		command := strings.Fields(strings.TrimPrefix(spec, execHealthCheckPrefix))
+		if len(command) == 0 {
+			return nil, errors.New("no command given")
+		}
+		return func(ctx context.Context) error {
+			// #nosec G204 -- the command is configured by the administrator of the node
+			out, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("%s failed: %v, output: %s", strings.Join(command, " "), err,
+					strings.TrimSpace(string(out)))
+			}
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("%q is neither an http(s) URL nor an exec: command", spec)
+	}
+}
+
+// advertiseGates evaluates the external signals that services can gate the advertisement of their VIPs by this node
+// on, so that kube-router takes part in failover schemes of the applications: the health checks defined on the node,
+// which services reference by name, and the Leases in the namespace of the services which another system keeps held
+// by the nodes that should advertise them. The signals are evaluated every period, and onChange is called whenever
+// one of them changes.
+type advertiseGates struct {
+	checks    map[string]healthCheck
+	period    time.Duration
+	nodeName  string
+	clientset kubernetes.Interface
+	svcLister cache.Indexer
+	onChange  func()
+
+	mu           sync.RWMutex
+	passedChecks map[string]bool
+	heldLeases   map[string]bool
+}
+
+func newAdvertiseGates(checkSpecs map[string]string, period time.Duration, nodeName string,
+	clientset kubernetes.Interface, svcLister cache.Indexer, onChange func()) (*advertiseGates, error) {
+	if period <= 0 {
+		return nil, errors.New("AdvertiseHealthCheckPeriod must be positive")
+	}
+	checks := make(map[string]healthCheck, len(checkSpecs))
+	for name, spec := range checkSpecs {
+		check, err := newHealthCheck(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid advertise health check %s: %v", name, err)
+		}
+		checks[name] = check
+	}
+	return &advertiseGates{
+		checks:       checks,
+		period:       period,
+		nodeName:     nodeName,
+		clientset:    clientset,
+		svcLister:    svcLister,
+		onChange:     onChange,
+		passedChecks: make(map[string]bool),
+		heldLeases:   make(map[string]bool),
+	}, nil
+}
+
+// allowAdvertisement checks whether the signals the service gates the advertisement of its VIPs on allow this node to
+// advertise them. Services referencing a health check that isn't defined on this node or a Lease this node doesn't
+// hold aren't advertised. Without gates everything is allowed.
+func (g *advertiseGates) allowAdvertisement(svc *v1core.Service) bool {
+	checkName, hasCheck := svc.Annotations[svcAdvertiseHealthCheckAnnotation]
+	leaseName, hasLease := svc.Annotations[svcAdvertiseLeaseAnnotation]
+	if !hasCheck && !hasLease {
+		return true
+	}
+	if g == nil {
+		return false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if hasCheck && !g.passedChecks[checkName] {
+		return false
+	}
+	if hasLease && !g.heldLeases[svc.Namespace+"/"+leaseName] {
+		return false
+	}
+	return true
+}
+
+// evaluate runs the health checks and looks up the Leases referenced by the services, and reports whether any of the
+// results changed since the last evaluation
+func (g *advertiseGates) evaluate() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), g.period)
+	defer cancel()
+
+	passedChecks := make(map[string]bool, len(g.checks))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range g.checks {
+		wg.Add(1)
+		go func(name string, check healthCheck) {
+			defer wg.Done()
+			err := check(ctx)
+			if err != nil {
+				klog.V(2).Infof("Advertise health check %s failed: %v", name, err)
+			}
+			resultsMu.Lock()
+			passedChecks[name] = err == nil
+			resultsMu.Unlock()
+		}(name, check)
+	}
+	heldLeases := g.evaluateLeases(ctx)
+	wg.Wait()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	changed := !mapsEqual(g.passedChecks, passedChecks) || !mapsEqual(g.heldLeases, heldLeases)
+	g.passedChecks = passedChecks
+	g.heldLeases = heldLeases
+	return changed
+}
+
+func (g *advertiseGates) evaluateLeases(ctx context.Context) map[string]bool {
+	heldLeases := make(map[string]bool)
+	for _, obj := range g.svcLister.List() {
+		svc, ok := obj.(*v1core.Service)
+		if !ok {
+			continue
+		}
+		leaseName, ok := svc.Annotations[svcAdvertiseLeaseAnnotation]
+		if !ok {
+			continue
+		}
+		key := svc.Namespace + "/" + leaseName
+		if _, done := heldLeases[key]; done {
+			continue
+		}
+		lease, err := g.clientset.CoordinationV1().Leases(svc.Namespace).Get(ctx, leaseName, metav1.GetOptions{})
+		if err != nil {
+			klog.V(2).Infof("Failed to get the Lease %s gating the advertisement of the service %s/%s: %v",
+				key, svc.Namespace, svc.Name, err)
+			heldLeases[key] = false
+			continue
+		}
+		spec := lease.Spec
+		heldLeases[key] = spec.HolderIdentity != nil && *spec.HolderIdentity == g.nodeName &&
+			spec.RenewTime != nil && spec.LeaseDurationSeconds != nil &&
+			time.Since(spec.RenewTime.Time) < time.Duration(*spec.LeaseDurationSeconds)*time.Second
+	}
+	return heldLeases
+}
+
+// run starts a goroutine that evaluates the gates every period and calls onChange when one of them changed
+func (g *advertiseGates) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		t := time.NewTicker(g.period)
+		defer t.Stop()
+		for {
+			if g.evaluate() {
+				klog.V(1).Info("Signals gating the advertisement of service VIPs changed, syncing the VIPs")
+				g.onChange()
+			}
+			select {
+			case <-t.C:
+			case <-stopCh:
+				klog.Infof("Shutting down evaluation of the advertise gates")
+				return
+			}
+		}
+	}(stopCh, wg)
+}
+
+func mapsEqual(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package routing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_newHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	testcases := []struct {
+		name        string
+		spec        string
+		expectErr   bool
+		expectFails bool
+	}{
+		{"healthy URL passes", server.URL + "/healthz", false, false},
+		{"unhealthy URL fails", server.URL + "/unhealthy", false, true},
+		{"command exiting with 0 passes", "exec:true", false, false},
+		{"command exiting with 1 fails", "exec:false", false, true},
+		{"exec without command is invalid", "exec:", true, false},
+		{"unknown kind of check is invalid", "tcp://127.0.0.1:80", true, false},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			check, err := newHealthCheck(testcase.spec)
+			if (err != nil) != testcase.expectErr {
+				t.Fatalf("expected error: %v, got: %v", testcase.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			err = check(context.Background())
+			if (err != nil) != testcase.expectFails {
+				t.Errorf("expected failure: %v, got: %v", testcase.expectFails, err)
+			}
+		})
+	}
+}
+
+func Test_advertiseGates(t *testing.T) {
+	holder, other := "node-1", "node-2"
+	duration := int32(15)
+	renewed := metav1.NewMicroTime(time.Now())
+	expired := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	newLease := func(name string, holder *string, renewTime *metav1.MicroTime) *coordinationv1.Lease {
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: coordinationv1.LeaseSpec{HolderIdentity: holder, LeaseDurationSeconds: &duration,
+				RenewTime: renewTime},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		newLease("held", &holder, &renewed),
+		newLease("held-by-other", &other, &renewed),
+		newLease("expired", &holder, &expired),
+	)
+
+	testcases := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{"service without gates is advertised", nil, true},
+		{"service gated on a passing check is advertised",
+			map[string]string{svcAdvertiseHealthCheckAnnotation: "passing"}, true},
+		{"service gated on a failing check is withdrawn",
+			map[string]string{svcAdvertiseHealthCheckAnnotation: "failing"}, false},
+		{"service gated on a check not defined on the node is withdrawn",
+			map[string]string{svcAdvertiseHealthCheckAnnotation: "undefined"}, false},
+		{"service gated on a Lease held by the node is advertised",
+			map[string]string{svcAdvertiseLeaseAnnotation: "held"}, true},
+		{"service gated on a Lease held by another node is withdrawn",
+			map[string]string{svcAdvertiseLeaseAnnotation: "held-by-other"}, false},
+		{"service gated on an expired Lease is withdrawn",
+			map[string]string{svcAdvertiseLeaseAnnotation: "expired"}, false},
+		{"service gated on a missing Lease is withdrawn",
+			map[string]string{svcAdvertiseLeaseAnnotation: "missing"}, false},
+		{"service gated on a passing check and a held Lease is advertised",
+			map[string]string{svcAdvertiseHealthCheckAnnotation: "passing", svcAdvertiseLeaseAnnotation: "held"},
+			true},
+		{"service gated on a failing check and a held Lease is withdrawn",
+			map[string]string{svcAdvertiseHealthCheckAnnotation: "failing", svcAdvertiseLeaseAnnotation: "held"},
+			false},
+	}
+
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	services := make([]*v1core.Service, 0, len(testcases))
+	for i, testcase := range testcases {
+		svc := &v1core.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc-" + string(rune('a'+i)),
+			Namespace: "default", Annotations: testcase.annotations}}
+		if err := svcLister.Add(svc); err != nil {
+			t.Fatalf("failed to add service: %v", err)
+		}
+		services = append(services, svc)
+	}
+
+	gates, err := newAdvertiseGates(map[string]string{"passing": "exec:true", "failing": "exec:false"},
+		time.Second, holder, clientset, svcLister, func() {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gates.allowAdvertisement(services[1]) {
+		t.Errorf("service gated on a check was advertised before the check was evaluated")
+	}
+	if !gates.evaluate() {
+		t.Errorf("expected the first evaluation to report a change")
+	}
+	if gates.evaluate() {
+		t.Errorf("expected an evaluation with the same results to report no change")
+	}
+
+	for i, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if allowed := gates.allowAdvertisement(services[i]); allowed != testcase.expected {
+				t.Errorf("expected advertisement allowed: %v, got: %v", testcase.expected, allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,550 @@
+package routing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	nsIngressBandwidthAnnotation = "kube-router.io/ingress-bandwidth"
+	nsEgressBandwidthAnnotation  = "kube-router.io/egress-bandwidth"
+
+	kubeBridgeIfName = "kube-bridge"
+	// bandwidthIfbName is the intermediate functional block device that traffic received from the pods on kube-bridge
+	// is redirected to, so that it can be shaped on egress of the IFB device
+	bandwidthIfbName = "kube-bridge-ifb"
+
+	// offsets of the source and destination addresses in the IPv4 and IPv6 headers, used by the u32 classifier
+	ipv4SrcOffset = 12
+	ipv4DstOffset = 16
+	ipv6SrcOffset = 8
+	ipv6DstOffset = 24
+
+	bandwidthHTBMajor = 1
+	// the filters of each protocol need a priority of their own
+	bandwidthFilterPrio    = 1
+	bandwidthFilterPrioV6  = 2
+	bandwidthIngressHandle = 0xffff
+)
+
+// namespaceBandwidth holds the aggregate bandwidth limits of a namespace along with the IPs of its pods on this node
+type namespaceBandwidth struct {
+	namespace string
+	// limits in bits per second, 0 means unlimited
+	ingress uint64
+	egress  uint64
+	podIPs  []net.IP
+}
+
+// NamespaceBandwidthController enforces the aggregate bandwidth limits that are set with the
+// kube-router.io/ingress-bandwidth and kube-router.io/egress-bandwidth namespace annotations, across all pods of the
+// namespace that run on this node.
+//
+// All pod traffic that is routed by the node passes through kube-bridge, so each limited namespace gets an HTB class
+// with the pods' IPv4 and IPv6 addresses classified into it. Traffic towards the pods is shaped on the egress of
+// kube-bridge itself, traffic coming from the pods is redirected from the ingress of kube-bridge to an IFB device and
+// shaped there. Traffic between pods on the same node is switched by the bridge from one veth to the other without
+// going through the qdiscs of kube-bridge, so it is not limited. Shaping it would need a qdisc on the veth of every
+// pod, which the limits being aggregated over all pods of a namespace rule out.
+type NamespaceBandwidthController struct {
+	nodeName        string
+	syncPeriod      time.Duration
+	syncRequestChan chan struct{}
+
+	podLister cache.Indexer
+	nsLister  cache.Indexer
+
+	PodEventHandler       cache.ResourceEventHandler
+	NamespaceEventHandler cache.ResourceEventHandler
+}
+
+// parseBandwidth parses a bandwidth annotation value (e.g. 10M for 10 megabits per second) into bits per second
+func parseBandwidth(value string) (uint64, error) {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, err
+	}
+	if quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("bandwidth must be positive")
+	}
+	return uint64(quantity.Value()), nil
+}
+
+// Run syncs the traffic control configuration periodically and whenever a sync is requested till we receive
+// notification on stopCh
+func (nbc *NamespaceBandwidthController) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	t := time.NewTicker(nbc.syncPeriod)
+	defer t.Stop()
+	defer wg.Done()
+
+	klog.Info("Starting namespace bandwidth controller")
+	for {
+		if err := nbc.sync(); err != nil {
+			klog.Errorf("Failed to sync namespace bandwidth limits: %v", err)
+		}
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down namespace bandwidth controller")
+			return
+		case <-t.C:
+		case <-nbc.syncRequestChan:
+		}
+	}
+}
+
+// RequestSync allows the request of a sync without blocking the callee
+func (nbc *NamespaceBandwidthController) RequestSync() {
+	select {
+	case nbc.syncRequestChan <- struct{}{}:
+	default:
+	}
+}
+
+// getNamespaceBandwidths builds the list of namespaces that have bandwidth limits, along with their local pod IPs,
+// sorted by namespace name so that class IDs stay stable between syncs
+func (nbc *NamespaceBandwidthController) getNamespaceBandwidths() []namespaceBandwidth {
+	podLister := listers.NewPodLister(nbc.podLister)
+	limits := make([]namespaceBandwidth, 0)
+
+	for _, obj := range nbc.nsLister.List() {
+		ns, ok := obj.(*v1core.Namespace)
+		if !ok {
+			continue
+		}
+		var err error
+		nsb := namespaceBandwidth{namespace: ns.Name}
+		if value, ok := ns.Annotations[nsIngressBandwidthAnnotation]; ok {
+			if nsb.ingress, err = parseBandwidth(value); err != nil {
+				klog.Errorf("Ignoring invalid %s annotation on namespace %s: %v", nsIngressBandwidthAnnotation,
+					ns.Name, err)
+			}
+		}
+		if value, ok := ns.Annotations[nsEgressBandwidthAnnotation]; ok {
+			if nsb.egress, err = parseBandwidth(value); err != nil {
+				klog.Errorf("Ignoring invalid %s annotation on namespace %s: %v", nsEgressBandwidthAnnotation,
+					ns.Name, err)
+			}
+		}
+		if nsb.ingress == 0 && nsb.egress == 0 {
+			continue
+		}
+
+		pods, err := podLister.Pods(ns.Name).List(labels.Everything())
+		if err != nil {
+			klog.Errorf("Failed to list pods of namespace %s: %v", ns.Name, err)
+			continue
+		}
+		for _, pod := range pods {
+			// pods are matched by node name rather than host IP, so that a change of the node IP does not matter
+			if pod.Spec.HostNetwork || pod.Spec.NodeName != nbc.nodeName {
+				continue
+			}
+			for _, podIP := range pod.Status.PodIPs {
+				if ip := net.ParseIP(podIP.IP); ip != nil {
+					nsb.podIPs = append(nsb.podIPs, ip)
+				}
+			}
+		}
+		limits = append(limits, nsb)
+	}
+
+	sort.Slice(limits, func(i, j int) bool { return limits[i].namespace < limits[j].namespace })
+	return limits
+}
+
+func (nbc *NamespaceBandwidthController) sync() error {
+	start := time.Now()
+	defer func() {
+		klog.V(2).Infof("sync of namespace bandwidth limits took %v", time.Since(start))
+	}()
+
+	bridge, err := netlink.LinkByName(kubeBridgeIfName)
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %v", kubeBridgeIfName, err)
+	}
+	limits := nbc.getNamespaceBandwidths()
+
+	ingressLimits := make([]namespaceBandwidth, 0)
+	egressLimits := make([]namespaceBandwidth, 0)
+	for _, nsb := range limits {
+		if nsb.ingress > 0 {
+			ingressLimits = append(ingressLimits, nsb)
+		}
+		if nsb.egress > 0 {
+			egressLimits = append(egressLimits, nsb)
+		}
+	}
+
+	// traffic towards the pods leaves through kube-bridge, so it is classified by destination IP there
+	if err = syncHTB(bridge, ingressLimits, false, func(nsb namespaceBandwidth) uint64 {
+		return nsb.ingress
+	}); err != nil {
+		return fmt.Errorf("failed to sync ingress limits on %s: %v", kubeBridgeIfName, err)
+	}
+
+	if len(egressLimits) == 0 {
+		return deleteBandwidthIfb(bridge)
+	}
+	ifb, err := ensureBandwidthIfb(bridge)
+	if err != nil {
+		return err
+	}
+	if err = syncHTB(ifb, egressLimits, true, func(nsb namespaceBandwidth) uint64 {
+		return nsb.egress
+	}); err != nil {
+		return fmt.Errorf("failed to sync egress limits on %s: %v", bandwidthIfbName, err)
+	}
+	return nil
+}
+
+// bandwidthFilter identifies the u32 filter that classifies the traffic of a pod IP into the class of its namespace
+type bandwidthFilter struct {
+	classID uint32
+	ip      string
+}
+
+// bandwidthFilterKeys returns the u32 keys that match the IP as the source (or destination) address of the packets
+func bandwidthFilterKeys(ip net.IP, matchSource bool) []nl.TcU32Key {
+	addr, offset := ip.To4(), int32(ipv4DstOffset)
+	if matchSource {
+		offset = ipv4SrcOffset
+	}
+	if addr == nil {
+		addr, offset = ip.To16(), ipv6DstOffset
+		if matchSource {
+			offset = ipv6SrcOffset
+		}
+	}
+	keys := make([]nl.TcU32Key, 0, len(addr)/4)
+	for i := 0; i < len(addr); i += 4 {
+		// the values are given in host byte order, netlink converts them to network byte order
+		keys = append(keys, nl.TcU32Key{Mask: 0xffffffff, Val: binary.BigEndian.Uint32(addr[i : i+4]),
+			Off: offset + int32(i)})
+	}
+	return keys
+}
+
+// newBandwidthFilter returns the u32 filter that classifies the traffic of the IP into the given class
+func newBandwidthFilter(linkIndex int, classID uint32, ip net.IP, matchSource bool) *netlink.U32 {
+	priority, protocol := uint16(bandwidthFilterPrio), uint16(unix.ETH_P_IP)
+	if ip.To4() == nil {
+		priority, protocol = bandwidthFilterPrioV6, unix.ETH_P_IPV6
+	}
+	return &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{LinkIndex: linkIndex, Parent: netlink.MakeHandle(bandwidthHTBMajor, 0),
+			Priority: priority, Protocol: protocol},
+		ClassId: classID,
+		Sel: &nl.TcU32Sel{
+			Flags: nl.TC_U32_TERMINAL,
+			Keys:  bandwidthFilterKeys(ip, matchSource),
+		},
+	}
+}
+
+// parseBandwidthFilter returns the class and IP a filter that was added by newBandwidthFilter classifies, false is
+// returned for any other filter
+func parseBandwidthFilter(filter netlink.Filter, matchSource bool) (bandwidthFilter, bool) {
+	u32, ok := filter.(*netlink.U32)
+	if !ok || u32.Sel == nil || (len(u32.Sel.Keys) != net.IPv4len/4 && len(u32.Sel.Keys) != net.IPv6len/4) {
+		return bandwidthFilter{}, false
+	}
+	ip := make(net.IP, 4*len(u32.Sel.Keys))
+	for i, key := range u32.Sel.Keys {
+		binary.BigEndian.PutUint32(ip[4*i:], key.Val)
+	}
+	expected := newBandwidthFilter(u32.LinkIndex, u32.ClassId, ip, matchSource)
+	if u32.Priority != expected.Priority || u32.Protocol != expected.Protocol ||
+		!reflect.DeepEqual(u32.Sel.Keys, expected.Sel.Keys) {
+		return bandwidthFilter{}, false
+	}
+	return bandwidthFilter{classID: u32.ClassId, ip: ip.String()}, true
+}
+
+// syncHTB makes the HTB configuration of the link match the given limits, using one class per namespace and one u32
+// filter per pod IP, matching on the source or destination address of the pods' traffic. Only the filters that
+// changed are added or deleted, so that the classification of the traffic isn't interrupted on every sync.
+func syncHTB(link netlink.Link, limits []namespaceBandwidth, matchSource bool,
+	rate func(nsb namespaceBandwidth) uint64) error {
+	rootHandle := netlink.MakeHandle(bandwidthHTBMajor, 0)
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("failed to list qdiscs: %v", err)
+	}
+	var haveRoot bool
+	for _, qdisc := range qdiscs {
+		attrs := qdisc.Attrs()
+		if attrs.Parent == netlink.HANDLE_ROOT && attrs.Handle == rootHandle && qdisc.Type() == "htb" {
+			haveRoot = true
+		}
+	}
+
+	if len(limits) == 0 {
+		if haveRoot {
+			return netlink.QdiscDel(netlink.NewHtb(netlink.QdiscAttrs{LinkIndex: link.Attrs().Index,
+				Handle: rootHandle, Parent: netlink.HANDLE_ROOT}))
+		}
+		return nil
+	}
+
+	if !haveRoot {
+		// with a default class of 0 unclassified traffic (i.e. pods in namespaces without limits) is not shaped
+		root := netlink.NewHtb(netlink.QdiscAttrs{LinkIndex: link.Attrs().Index, Handle: rootHandle,
+			Parent: netlink.HANDLE_ROOT})
+		root.Defcls = 0
+		if err = netlink.QdiscReplace(root); err != nil {
+			return fmt.Errorf("failed to add htb qdisc: %v", err)
+		}
+	}
+
+	activeClasses := make(map[uint32]bool)
+	activeFilters := make(map[bandwidthFilter]bool)
+	for idx, nsb := range limits {
+		classID := netlink.MakeHandle(bandwidthHTBMajor, uint16(idx+1))
+		activeClasses[classID] = true
+		for _, ip := range nsb.podIPs {
+			activeFilters[bandwidthFilter{classID: classID, ip: ip.String()}] = true
+		}
+	}
+
+	// stale filters are removed first, so that the traffic of a pod IP is never classified into the class of a
+	// namespace it no longer belongs to
+	filters, err := netlink.FilterList(link, rootHandle)
+	if err != nil {
+		return fmt.Errorf("failed to list filters: %v", err)
+	}
+	existingFilters := make(map[bandwidthFilter]bool)
+	for _, filter := range filters {
+		if u32, ok := filter.(*netlink.U32); ok && u32.Sel == nil {
+			// the hash tables of the u32 classifier can't be deleted on their own
+			continue
+		}
+		if bf, ok := parseBandwidthFilter(filter, matchSource); ok && activeFilters[bf] && !existingFilters[bf] {
+			existingFilters[bf] = true
+			continue
+		}
+		if err = netlink.FilterDel(filter); err != nil {
+			return fmt.Errorf("failed to delete filter: %v", err)
+		}
+	}
+
+	for idx, nsb := range limits {
+		classID := netlink.MakeHandle(bandwidthHTBMajor, uint16(idx+1))
+		class := netlink.NewHtbClass(netlink.ClassAttrs{LinkIndex: link.Attrs().Index, Parent: rootHandle,
+			Handle: classID}, netlink.HtbClassAttrs{Rate: rate(nsb)})
+		if err = netlink.ClassReplace(class); err != nil {
+			return fmt.Errorf("failed to set class for namespace %s: %v", nsb.namespace, err)
+		}
+		for _, ip := range nsb.podIPs {
+			if existingFilters[bandwidthFilter{classID: classID, ip: ip.String()}] {
+				continue
+			}
+			if err = netlink.FilterAdd(newBandwidthFilter(link.Attrs().Index, classID, ip, matchSource)); err != nil {
+				return fmt.Errorf("failed to add filter for pod IP %s of namespace %s: %v", ip, nsb.namespace, err)
+			}
+		}
+	}
+
+	classes, err := netlink.ClassList(link, rootHandle)
+	if err != nil {
+		return fmt.Errorf("failed to list classes: %v", err)
+	}
+	for _, class := range classes {
+		if class.Attrs().Parent == rootHandle && !activeClasses[class.Attrs().Handle] {
+			if err = netlink.ClassDel(class); err != nil {
+				return fmt.Errorf("failed to delete stale class: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// isBandwidthRedirect tells whether the filter is the one that redirects all traffic received on kube-bridge to the
+// IFB device with the given index
+func isBandwidthRedirect(filter netlink.Filter, ifbIndex int) bool {
+	u32, ok := filter.(*netlink.U32)
+	if !ok || u32.Priority != bandwidthFilterPrio || u32.Protocol != unix.ETH_P_ALL {
+		return false
+	}
+	for _, action := range u32.Actions {
+		if mirred, ok := action.(*netlink.MirredAction); ok && mirred.MirredAction == netlink.TCA_EGRESS_REDIR &&
+			mirred.Ifindex == ifbIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureBandwidthIfb creates the IFB device and redirects all traffic received on kube-bridge from the pods to it
+func ensureBandwidthIfb(bridge netlink.Link) (netlink.Link, error) {
+	ifb, err := netlink.LinkByName(bandwidthIfbName)
+	if err != nil {
+		if err = netlink.LinkAdd(&netlink.Ifb{LinkAttrs: netlink.LinkAttrs{Name: bandwidthIfbName,
+			TxQLen: bridge.Attrs().TxQLen}}); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", bandwidthIfbName, err)
+		}
+		if ifb, err = netlink.LinkByName(bandwidthIfbName); err != nil {
+			return nil, fmt.Errorf("failed to find %s: %v", bandwidthIfbName, err)
+		}
+	}
+	if err = netlink.LinkSetUp(ifb); err != nil {
+		return nil, fmt.Errorf("failed to bring up %s: %v", bandwidthIfbName, err)
+	}
+
+	ingressHandle := netlink.MakeHandle(bandwidthIngressHandle, 0)
+	qdiscs, err := netlink.QdiscList(bridge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list qdiscs of %s: %v", kubeBridgeIfName, err)
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Type() != "ingress" {
+			continue
+		}
+		filters, err := netlink.FilterList(bridge, ingressHandle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ingress filters of %s: %v", kubeBridgeIfName, err)
+		}
+		for _, filter := range filters {
+			if isBandwidthRedirect(filter, ifb.Attrs().Index) {
+				return ifb, nil
+			}
+		}
+		// the redirect is missing or points to an IFB device that was recreated since, the ingress qdisc is owned by
+		// kube-router so it is simply set up again
+		klog.Infof("Resetting the ingress qdisc of %s as it doesn't redirect the traffic to %s", kubeBridgeIfName,
+			bandwidthIfbName)
+		if err = netlink.QdiscDel(qdisc); err != nil {
+			return nil, fmt.Errorf("failed to delete ingress qdisc of %s: %v", kubeBridgeIfName, err)
+		}
+	}
+	ingress := &netlink.Ingress{QdiscAttrs: netlink.QdiscAttrs{LinkIndex: bridge.Attrs().Index,
+		Handle: ingressHandle, Parent: netlink.HANDLE_INGRESS}}
+	if err = netlink.QdiscAdd(ingress); err != nil {
+		return nil, fmt.Errorf("failed to add ingress qdisc to %s: %v", kubeBridgeIfName, err)
+	}
+	redirect := &netlink.U32{
+		// the traffic of all protocols is redirected, so that the IPv6 traffic of the pods is shaped as well
+		FilterAttrs: netlink.FilterAttrs{LinkIndex: bridge.Attrs().Index, Parent: ingressHandle,
+			Priority: bandwidthFilterPrio, Protocol: unix.ETH_P_ALL},
+		// a single key with an empty mask matches all packets
+		Sel:     &nl.TcU32Sel{Flags: nl.TC_U32_TERMINAL, Keys: []nl.TcU32Key{{}}},
+		Actions: []netlink.Action{netlink.NewMirredAction(ifb.Attrs().Index)},
+	}
+	if err = netlink.FilterAdd(redirect); err != nil {
+		return nil, fmt.Errorf("failed to redirect traffic from %s to %s: %v", kubeBridgeIfName, bandwidthIfbName,
+			err)
+	}
+	return ifb, nil
+}
+
+// deleteBandwidthIfb removes the redirection of pod traffic to the IFB device along with the device itself
+func deleteBandwidthIfb(bridge netlink.Link) error {
+	ifb, err := netlink.LinkByName(bandwidthIfbName)
+	if err != nil {
+		// nothing to clean up
+		return nil
+	}
+	qdiscs, err := netlink.QdiscList(bridge)
+	if err != nil {
+		return fmt.Errorf("failed to list qdiscs of %s: %v", kubeBridgeIfName, err)
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Type() == "ingress" {
+			if err = netlink.QdiscDel(qdisc); err != nil {
+				return fmt.Errorf("failed to delete ingress qdisc of %s: %v", kubeBridgeIfName, err)
+			}
+		}
+	}
+	if err = netlink.LinkDel(ifb); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", bandwidthIfbName, err)
+	}
+	return nil
+}
+
+func (nbc *NamespaceBandwidthController) newPodEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nbc.RequestSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok := oldObj.(*v1core.Pod)
+			if !ok {
+				return
+			}
+			newPod, ok := newObj.(*v1core.Pod)
+			if !ok {
+				return
+			}
+			if oldPod.Status.PodIP != newPod.Status.PodIP || oldPod.Spec.NodeName != newPod.Spec.NodeName {
+				nbc.RequestSync()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			nbc.RequestSync()
+		},
+	}
+}
+
+func (nbc *NamespaceBandwidthController) newNamespaceEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			nbc.RequestSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNs, ok := oldObj.(*v1core.Namespace)
+			if !ok {
+				return
+			}
+			newNs, ok := newObj.(*v1core.Namespace)
+			if !ok {
+				return
+			}
+			if oldNs.Annotations[nsIngressBandwidthAnnotation] != newNs.Annotations[nsIngressBandwidthAnnotation] ||
+				oldNs.Annotations[nsEgressBandwidthAnnotation] != newNs.Annotations[nsEgressBandwidthAnnotation] {
+				nbc.RequestSync()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			nbc.RequestSync()
+		},
+	}
+}
+
+// NewNamespaceBandwidthController returns new NamespaceBandwidthController object
+func NewNamespaceBandwidthController(clientset kubernetes.Interface, config *options.KubeRouterConfig,
+	podInformer cache.SharedIndexInformer, nsInformer cache.SharedIndexInformer) (*NamespaceBandwidthController,
+	error) {
+	nbc := NamespaceBandwidthController{syncPeriod: config.RoutesSyncPeriod}
+	nbc.syncRequestChan = make(chan struct{}, 1)
+
+	node, err := utils.GetNodeObject(clientset, config.HostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+	nbc.nodeName = node.Name
+
+	nbc.podLister = podInformer.GetIndexer()
+	nbc.PodEventHandler = nbc.newPodEventHandler()
+
+	nbc.nsLister = nsInformer.GetIndexer()
+	nbc.NamespaceEventHandler = nbc.newNamespaceEventHandler()
+
+	return &nbc, nil
+}
@@ -0,0 +1,166 @@
+package routing
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_parseBandwidth(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected uint64
+		err      bool
+	}{
+		{"10M", 10000000, false},
+		{"1G", 1000000000, false},
+		{"1500k", 1500000, false},
+		{"0", 0, true},
+		{"-1M", 0, true},
+		{"fast", 0, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.value, func(t *testing.T) {
+			actual, err := parseBandwidth(tc.value)
+			if tc.err != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.err, err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_getNamespaceBandwidths(t *testing.T) {
+	namespaces := []*v1core.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "tenant-b", Annotations: map[string]string{
+			nsIngressBandwidthAnnotation: "10M"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Annotations: map[string]string{
+			nsIngressBandwidthAnnotation: "5M", nsEgressBandwidthAnnotation: "1M"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unlimited"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "invalid", Annotations: map[string]string{
+			nsEgressBandwidthAnnotation: "lots"}}},
+	}
+	pods := []*v1core.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "local", Namespace: "tenant-a"},
+			Spec:       v1core.PodSpec{NodeName: "node-1"},
+			Status: v1core.PodStatus{HostIP: "10.0.0.1", PodIP: "172.20.0.2",
+				PodIPs: []v1core.PodIP{{IP: "172.20.0.2"}, {IP: "2001:db8::2"}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "remote", Namespace: "tenant-a"},
+			Spec:       v1core.PodSpec{NodeName: "node-2"},
+			Status: v1core.PodStatus{HostIP: "10.0.0.2", PodIP: "172.20.1.2",
+				PodIPs: []v1core.PodIP{{IP: "172.20.1.2"}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "host-network", Namespace: "tenant-a"},
+			Spec:       v1core.PodSpec{NodeName: "node-1", HostNetwork: true},
+			Status: v1core.PodStatus{HostIP: "10.0.0.1", PodIP: "10.0.0.1",
+				PodIPs: []v1core.PodIP{{IP: "10.0.0.1"}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "local", Namespace: "unlimited"},
+			Spec:       v1core.PodSpec{NodeName: "node-1"},
+			Status: v1core.PodStatus{HostIP: "10.0.0.1", PodIP: "172.20.0.3",
+				PodIPs: []v1core.PodIP{{IP: "172.20.0.3"}}},
+		},
+	}
+
+	nbc := &NamespaceBandwidthController{
+		nodeName:  "node-1",
+		podLister: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+		nsLister:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+	for _, ns := range namespaces {
+		_ = nbc.nsLister.Add(ns)
+	}
+	for _, pod := range pods {
+		_ = nbc.podLister.Add(pod)
+	}
+
+	expected := []namespaceBandwidth{
+		{namespace: "tenant-a", ingress: 5000000, egress: 1000000, podIPs: []net.IP{net.ParseIP("172.20.0.2"),
+			net.ParseIP("2001:db8::2")}},
+		{namespace: "tenant-b", ingress: 10000000},
+	}
+	actual := nbc.getNamespaceBandwidths()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func Test_bandwidthFilterKeys(t *testing.T) {
+	testcases := []struct {
+		name        string
+		ip          string
+		matchSource bool
+		expected    []nl.TcU32Key
+	}{
+		{"IPv4 destination", "172.20.0.2", false, []nl.TcU32Key{{Mask: 0xffffffff, Val: 0xac140002, Off: 16}}},
+		{"IPv4 source", "172.20.0.2", true, []nl.TcU32Key{{Mask: 0xffffffff, Val: 0xac140002, Off: 12}}},
+		{"IPv6 source", "2001:db8::1:2", true, []nl.TcU32Key{
+			{Mask: 0xffffffff, Val: 0x20010db8, Off: 8}, {Mask: 0xffffffff, Val: 0, Off: 12},
+			{Mask: 0xffffffff, Val: 0, Off: 16}, {Mask: 0xffffffff, Val: 0x00010002, Off: 20}}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := bandwidthFilterKeys(net.ParseIP(tc.ip), tc.matchSource)
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_parseBandwidthFilter(t *testing.T) {
+	classID := netlink.MakeHandle(bandwidthHTBMajor, 2)
+	for _, ip := range []string{"172.20.0.2", "2001:db8::2"} {
+		filter := newBandwidthFilter(3, classID, net.ParseIP(ip), true)
+		actual, ok := parseBandwidthFilter(filter, true)
+		if expected := (bandwidthFilter{classID: classID, ip: ip}); !ok || actual != expected {
+			t.Errorf("expected %+v, got %+v (%v)", expected, actual, ok)
+		}
+		if _, ok = parseBandwidthFilter(filter, false); ok {
+			t.Errorf("expected the source filter of %s not to match its destination", ip)
+		}
+	}
+
+	hashTable := &netlink.U32{FilterAttrs: netlink.FilterAttrs{Priority: bandwidthFilterPrio, Protocol: unix.ETH_P_IP}}
+	if _, ok := parseBandwidthFilter(hashTable, false); ok {
+		t.Errorf("expected the u32 hash table not to be parsed as a filter")
+	}
+	filter := newBandwidthFilter(3, classID, net.ParseIP("172.20.0.2"), false)
+	filter.Protocol = unix.ETH_P_IPV6
+	if _, ok := parseBandwidthFilter(filter, false); ok {
+		t.Errorf("expected the filter of the wrong protocol not to be parsed")
+	}
+}
+
+func Test_isBandwidthRedirect(t *testing.T) {
+	redirect := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{Priority: bandwidthFilterPrio, Protocol: unix.ETH_P_ALL},
+		Actions:     []netlink.Action{netlink.NewMirredAction(7)},
+	}
+	if !isBandwidthRedirect(redirect, 7) {
+		t.Errorf("expected the redirect to the IFB device to be found")
+	}
+	if isBandwidthRedirect(redirect, 8) {
+		t.Errorf("expected the redirect to a former IFB device not to be accepted")
+	}
+	redirect.Protocol = unix.ETH_P_IP
+	if isBandwidthRedirect(redirect, 7) {
+		t.Errorf("expected the redirect of the IPv4 traffic only not to be accepted")
+	}
+}
@@ -0,0 +1,97 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/bfd"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+)
+
+// syncBFDSessions runs a BFD session with each of the directly connected external BGP peers, from the same local
+// address as the BGP session and with the timers of the peer's BGPPeer resource if it has one, and stops the sessions
+// of the peers that are gone
+func (nrc *NetworkRoutingController) syncBFDSessions(peers []*gobgpapi.Peer) {
+	if nrc.bfdServer == nil {
+		return
+	}
+
+	wanted := make(map[string]bool)
+	for _, peer := range peers {
+		remote := net.ParseIP(peer.Conf.NeighborAddress)
+		if remote == nil {
+			continue
+		}
+		if peer.EbgpMultihop != nil && peer.EbgpMultihop.Enabled {
+			nrc.logger.V(1).Info("Not running a BFD session with BGP peer as it isn't directly connected",
+				utils.LogKeyPeer, remote.String())
+			continue
+		}
+		var local net.IP
+		if peer.Transport != nil {
+			local = net.ParseIP(peer.Transport.LocalAddress)
+		}
+		wanted[remote.String()] = true
+		interval, multiplier := nrc.peerBFDTimers(remote.String())
+		err := nrc.bfdServer.AddSession(bfd.SessionConfig{
+			LocalAddress:     local,
+			RemoteAddress:    remote,
+			MinInterval:      interval,
+			DetectMultiplier: multiplier,
+			OnStateChange:    nrc.handleBFDStateChange,
+		})
+		if err != nil {
+			nrc.logger.Error(err, "Failed to start BFD session with BGP peer", utils.LogKeyPeer, remote.String())
+		}
+	}
+
+	for _, remote := range nrc.bfdServer.Peers() {
+		if !wanted[remote] {
+			nrc.bfdServer.DeleteSession(net.ParseIP(remote))
+		}
+	}
+}
+
+// peerBFDTimers returns the interval and the multiplier of the BFD session with a peer, the ones of the flags unless
+// the BGPPeer resource of the peer sets them
+func (nrc *NetworkRoutingController) peerBFDTimers(address string) (time.Duration, uint8) {
+	interval, multiplier := nrc.peerBFDInterval, nrc.peerBFDMultiplier
+	if resourcePeer, ok := nrc.bgpPeerResourcePeers[address]; ok {
+		if resourcePeer.config.bfd.interval > 0 {
+			interval = resourcePeer.config.bfd.interval
+		}
+		if resourcePeer.config.bfd.multiplier > 0 {
+			multiplier = resourcePeer.config.bfd.multiplier
+		}
+	}
+	return interval, multiplier
+}
+
+// handleBFDStateChange shuts down the BGP session with a peer as soon as the BFD session with it goes down, GoBGP then
+// withdraws the routes learned from the peer right away and the routes are moved to the remaining peers (or to the
+// other next hops of ECMP routes). The BGP session is allowed to come back once BFD is up again.
+func (nrc *NetworkRoutingController) handleBFDStateChange(remote net.IP, state bfd.State) {
+	if !nrc.bgpServerStarted {
+		return
+	}
+	switch state {
+	case bfd.StateUp:
+		nrc.logger.Info("BFD session with BGP peer is up, enabling the BGP session", utils.LogKeyPeer, remote.String())
+		err := nrc.bgpServer.EnablePeer(context.Background(), &gobgpapi.EnablePeerRequest{Address: remote.String()})
+		if err != nil {
+			nrc.logger.Error(err, "Failed to enable BGP peer", utils.LogKeyPeer, remote.String())
+		}
+	case bfd.StateDown:
+		nrc.logger.Info("BFD session with BGP peer is down, shutting down the BGP session",
+			utils.LogKeyPeer, remote.String())
+		err := nrc.bgpServer.DisablePeer(context.Background(), &gobgpapi.DisablePeerRequest{
+			Address:       remote.String(),
+			Communication: "BFD session down",
+		})
+		if err != nil {
+			nrc.logger.Error(err, "Failed to disable BGP peer", utils.LogKeyPeer, remote.String())
+		}
+	}
+}
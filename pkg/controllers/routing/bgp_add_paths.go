@@ -0,0 +1,24 @@
+package routing
+
+import (
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+)
+
+// withAddPaths returns the address families of a peer with Add-Path (RFC 7911) enabled on them, so that up to
+// --bgp-add-paths paths of each prefix are sent to the peer instead of only the best one and the paths the peer sends
+// are received. A peer without address families gets the one of its address. Add-Path is only used with the peers
+// that support it as well.
+func (nrc *NetworkRoutingController) withAddPaths(afiSafis []*gobgpapi.AfiSafi, ipv6 bool) []*gobgpapi.AfiSafi {
+	if nrc.bgpAddPaths == 0 {
+		return afiSafis
+	}
+	if len(afiSafis) == 0 {
+		afiSafis = []*gobgpapi.AfiSafi{{Config: &gobgpapi.AfiSafiConfig{Family: unicastFamily(ipv6), Enabled: true}}}
+	}
+	for _, afiSafi := range afiSafis {
+		afiSafi.AddPaths = &gobgpapi.AddPaths{
+			Config: &gobgpapi.AddPathsConfig{Receive: true, SendMax: uint32(nrc.bgpAddPaths)},
+		}
+	}
+	return afiSafis
+}
@@ -0,0 +1,30 @@
+package routing
+
+import (
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+)
+
+func Test_withAddPaths(t *testing.T) {
+	nrc := &NetworkRoutingController{}
+	if afiSafis := nrc.withAddPaths(nil, false); afiSafis != nil {
+		t.Errorf("expected the address families to be left as is without --bgp-add-paths, got %v", afiSafis)
+	}
+
+	nrc.bgpAddPaths = 4
+	afiSafis := nrc.withAddPaths(nil, true)
+	if len(afiSafis) != 1 || afiSafis[0].Config.Family.Afi != gobgpapi.Family_AFI_IP6 {
+		t.Fatalf("expected the IPv6 unicast address family of the peer, got %v", afiSafis)
+	}
+	afiSafis = nrc.withAddPaths([]*gobgpapi.AfiSafi{
+		{Config: &gobgpapi.AfiSafiConfig{Family: unicastFamily(false), Enabled: true}},
+		{Config: &gobgpapi.AfiSafiConfig{Family: unicastFamily(true), Enabled: true}},
+	}, false)
+	for _, afiSafi := range afiSafis {
+		if afiSafi.AddPaths == nil || !afiSafi.AddPaths.Config.Receive || afiSafi.AddPaths.Config.SendMax != 4 {
+			t.Errorf("expected Add-Path to send 4 paths and receive on %v, got %v", afiSafi.Config.Family,
+				afiSafi.AddPaths)
+		}
+	}
+}
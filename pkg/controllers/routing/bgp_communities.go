@@ -0,0 +1,99 @@
+package routing
+
+import (
+	"fmt"
+	"sort"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"google.golang.org/protobuf/types/known/anypb"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	svcAdvertiseClusterCommunitiesAnnotation      = "kube-router.io/service.advertise.clusterip.communities"
+	svcAdvertiseExternalCommunitiesAnnotation     = "kube-router.io/service.advertise.externalip.communities"
+	svcAdvertiseLoadBalancerCommunitiesAnnotation = "kube-router.io/service.advertise.loadbalancerip.communities"
+)
+
+// parseCommunities parses the BGP communities given to a flag
+func parseCommunities(communities []string) ([]uint32, error) {
+	values := make([]uint32, 0, len(communities))
+	for _, community := range communities {
+		value, err := parseCommunity(community)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// serviceCommunities returns the BGP communities a class of VIPs of the service is advertised with, the ones of the
+// annotation when the service has it or the given defaults otherwise. Invalid communities of the annotation are
+// logged and left out.
+func serviceCommunities(svc *v1core.Service, annotation string, defaults []uint32) []uint32 {
+	value, ok := svc.Annotations[annotation]
+	if !ok {
+		return defaults
+	}
+	communities := make([]uint32, 0)
+	for _, community := range stringToSlice(value, ",") {
+		parsed, err := parseCommunity(community)
+		if err != nil {
+			klog.Warningf("Ignoring BGP community %q of the %s annotation of service %s/%s: %v", community,
+				annotation, svc.Namespace, svc.Name, err)
+			continue
+		}
+		communities = append(communities, parsed)
+	}
+	return communities
+}
+
+// vipCommunities returns the BGP communities the VIPs of the services are advertised with, by VIP. A VIP shared by
+// several services, or by several classes of VIPs, is advertised with the communities of all of them.
+func (nrc *NetworkRoutingController) vipCommunities() map[string][]uint32 {
+	communities := make(map[string]map[uint32]bool)
+	add := func(vips []string, values []uint32) {
+		if len(values) == 0 {
+			return
+		}
+		for _, vip := range vips {
+			if communities[vip] == nil {
+				communities[vip] = make(map[uint32]bool)
+			}
+			for _, value := range values {
+				communities[vip][value] = true
+			}
+		}
+	}
+	for _, obj := range nrc.svcLister.List() {
+		svc := obj.(*v1core.Service)
+		add(nrc.getClusterIPs(svc), serviceCommunities(svc, svcAdvertiseClusterCommunitiesAnnotation,
+			nrc.clusterIPCommunities))
+		add(nrc.getExternalIPs(svc), serviceCommunities(svc, svcAdvertiseExternalCommunitiesAnnotation,
+			nrc.externalIPCommunities))
+		add(nrc.getLoadBalancerIPs(svc), serviceCommunities(svc, svcAdvertiseLoadBalancerCommunitiesAnnotation,
+			nrc.loadBalancerIPCommunities))
+	}
+
+	vipCommunities := make(map[string][]uint32, len(communities))
+	for vip, values := range communities {
+		list := make([]uint32, 0, len(values))
+		for value := range values {
+			list = append(list, value)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+		vipCommunities[vip] = list
+	}
+	return vipCommunities
+}
+
+// newCommunitiesAttribute returns the path attribute carrying the BGP communities of an advertised route
+func newCommunitiesAttribute(communities []uint32) (*anypb.Any, error) {
+	attr, err := anypb.New(&gobgpapi.CommunitiesAttribute{Communities: communities})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the communities attribute: %v", err)
+	}
+	return attr, nil
+}
@@ -0,0 +1,57 @@
+package routing
+
+import (
+	"reflect"
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_vipCommunities(t *testing.T) {
+	services := []*v1core.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "defaults"},
+			Spec: v1core.ServiceSpec{Type: LoadBalancerST, ClusterIP: "10.96.0.1",
+				ExternalIPs: []string{"1.1.1.1"}},
+			Status: v1core.ServiceStatus{LoadBalancer: v1core.LoadBalancerStatus{
+				Ingress: []v1core.LoadBalancerIngress{{IP: "2.2.2.2"}}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "annotated", Annotations: map[string]string{
+				svcAdvertiseClusterCommunitiesAnnotation:  "",
+				svcAdvertiseExternalCommunitiesAnnotation: "65000:300,invalid,no-export",
+			}},
+			Spec: v1core.ServiceSpec{Type: ClusterIPST, ClusterIP: "10.96.0.2", ExternalIPs: []string{"3.3.3.3"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "shared", Annotations: map[string]string{
+				svcAdvertiseExternalCommunitiesAnnotation: "65000:400",
+			}},
+			Spec: v1core.ServiceSpec{Type: ClusterIPST, ClusterIP: "10.96.0.3", ExternalIPs: []string{"1.1.1.1"}},
+		},
+	}
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, svc := range services {
+		if err := svcLister.Add(svc); err != nil {
+			t.Fatalf("failed to add service: %v", err)
+		}
+	}
+	nrc := &NetworkRoutingController{svcLister: svcLister,
+		clusterIPCommunities:      []uint32{65000<<16 | 100},
+		externalIPCommunities:     []uint32{65000<<16 | 200},
+		loadBalancerIPCommunities: []uint32{65000<<16 | 250, 65000<<16 | 200},
+	}
+
+	expected := map[string][]uint32{
+		"10.96.0.1": {65000<<16 | 100},
+		"10.96.0.3": {65000<<16 | 100},
+		"1.1.1.1":   {65000<<16 | 200, 65000<<16 | 400},
+		"2.2.2.2":   {65000<<16 | 200, 65000<<16 | 250},
+		"3.3.3.3":   {65000<<16 | 300, 0xFFFFFF01},
+	}
+	if communities := nrc.vipCommunities(); !reflect.DeepEqual(communities, expected) {
+		t.Errorf("expected %v, got %v", expected, communities)
+	}
+}
@@ -0,0 +1,183 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"k8s.io/klog/v2"
+)
+
+// dynamicNeighborRange is a prefix from which the node accepts BGP sessions from the routers of a range of ASNs
+type dynamicNeighborRange struct {
+	prefix *net.IPNet
+	minASN uint32
+	maxASN uint32
+}
+
+// parseASNRange parses a single ASN or a range of ASNs like 65000-65099
+func parseASNRange(asns string) (uint32, uint32, error) {
+	bounds := strings.SplitN(strings.TrimSpace(asns), "-", 2)
+	values := make([]uint32, 0, len(bounds))
+	for _, bound := range bounds {
+		value, err := strconv.ParseUint(strings.TrimSpace(bound), 10, asnMaxBitSize)
+		if err != nil || value == 0 {
+			return 0, 0, fmt.Errorf("invalid ASN range %q", asns)
+		}
+		values = append(values, uint32(value))
+	}
+	if len(values) == 1 {
+		return values[0], values[0], nil
+	}
+	if values[0] > values[1] {
+		return 0, 0, fmt.Errorf("invalid ASN range %q, the first ASN is greater than the last one", asns)
+	}
+	return values[0], values[1], nil
+}
+
+// newDynamicNeighborRanges returns the ranges of the dynamic neighbors of the flags, the prefixes and their ASN ranges
+func newDynamicNeighborRanges(prefixes []string, asns []string) ([]dynamicNeighborRange, error) {
+	if len(prefixes) != len(asns) {
+		return nil, errors.New("invalid dynamic neighbor config, the number of listen ranges and ASN ranges must be " +
+			"equal")
+	}
+	ranges := make([]dynamicNeighborRange, 0, len(prefixes))
+	for i, prefix := range prefixes {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid dynamic neighbor listen range %q: %v", prefix, err)
+		}
+		minASN, maxASN, err := parseASNRange(asns[i])
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, dynamicNeighborRange{prefix: ipNet, minASN: minASN, maxASN: maxASN})
+	}
+	return ranges, nil
+}
+
+// dynamicNeighborPeerGroup returns the name of the peer group of the dynamic neighbors of a range
+func dynamicNeighborPeerGroup(i int) string {
+	return fmt.Sprintf("dynamicneighbors%d", i)
+}
+
+// newDynamicNeighborPeerGroup returns the peer group of the dynamic neighbors of a range. It has no peer ASN so that
+// the sessions are accepted whatever the ASN of the router, which is checked against the range once the router has
+// sent its OPEN message.
+func (nrc *NetworkRoutingController) newDynamicNeighborPeerGroup(name string,
+	r dynamicNeighborRange) *gobgpapi.PeerGroup {
+	afiSafi := &gobgpapi.AfiSafi{Config: &gobgpapi.AfiSafiConfig{Family: unicastFamily(r.prefix.IP.To4() == nil),
+		Enabled: true}}
+	peerGroup := &gobgpapi.PeerGroup{
+		Conf:     &gobgpapi.PeerGroupConf{PeerGroupName: name},
+		Timers:   &gobgpapi.Timers{Config: &gobgpapi.TimersConfig{HoldTime: uint64(nrc.bgpHoldtime)}},
+		AfiSafis: []*gobgpapi.AfiSafi{afiSafi},
+	}
+	if nrc.bgpGracefulRestart {
+		peerGroup.GracefulRestart = &gobgpapi.GracefulRestart{
+			Enabled:          true,
+			RestartTime:      uint32(nrc.bgpGracefulRestartTime.Seconds()),
+			DeferralTime:     uint32(nrc.bgpGracefulRestartDeferralTime.Seconds()),
+			LocalRestarting:  true,
+			LonglivedEnabled: nrc.bgpLongLivedGracefulRestart,
+		}
+		afiSafi.MpGracefulRestart = &gobgpapi.MpGracefulRestart{
+			Config: &gobgpapi.MpGracefulRestartConfig{Enabled: true},
+		}
+		if nrc.bgpLongLivedGracefulRestart {
+			afiSafi.LongLivedGracefulRestart = newLongLivedGracefulRestart(nrc.bgpLongLivedStaleTime)
+		}
+	}
+	peerGroup.AfiSafis = nrc.withAddPaths(peerGroup.AfiSafis, r.prefix.IP.To4() == nil)
+	if nrc.peerMultihopTTL > 1 {
+		peerGroup.EbgpMultihop = &gobgpapi.EbgpMultihop{Enabled: true, MultihopTtl: uint32(nrc.peerMultihopTTL)}
+	}
+	return peerGroup
+}
+
+// addDynamicNeighbors makes the BGP server accept the sessions of the routers of the listen ranges, and tear down the
+// ones of the routers whose ASN is out of the ASN range of their listen range
+func (nrc *NetworkRoutingController) addDynamicNeighbors() error {
+	if len(nrc.dynamicNeighborRanges) == 0 {
+		return nil
+	}
+	err := nrc.bgpServer.WatchEvent(context.Background(), &gobgpapi.WatchEventRequest{
+		Peer: &gobgpapi.WatchEventRequest_Peer{},
+	}, func(r *gobgpapi.WatchEventResponse) {
+		if event := r.GetPeer(); event != nil && event.Type == gobgpapi.WatchEventResponse_PeerEvent_STATE {
+			nrc.checkDynamicNeighbor(event.Peer)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch the BGP peers for the dynamic neighbors: %v", err)
+	}
+	for i, r := range nrc.dynamicNeighborRanges {
+		name := dynamicNeighborPeerGroup(i)
+		err = nrc.bgpServer.AddPeerGroup(context.Background(),
+			&gobgpapi.AddPeerGroupRequest{PeerGroup: nrc.newDynamicNeighborPeerGroup(name, r)})
+		if err != nil {
+			return fmt.Errorf("failed to add the peer group of the dynamic neighbors of %s: %v", r.prefix, err)
+		}
+		err = nrc.bgpServer.AddDynamicNeighbor(context.Background(), &gobgpapi.AddDynamicNeighborRequest{
+			DynamicNeighbor: &gobgpapi.DynamicNeighbor{Prefix: r.prefix.String(), PeerGroup: name},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add the dynamic neighbors of %s: %v", r.prefix, err)
+		}
+		klog.Infof("Accepting BGP sessions from the routers of %s in ASN %d-%d", r.prefix, r.minASN, r.maxASN)
+	}
+	return nil
+}
+
+// dynamicNeighborASNAllowed returns the range of the peer group of a peer and whether the ASN of the peer is in its ASN
+// range, the peers that aren't dynamic neighbors are always allowed
+func (nrc *NetworkRoutingController) dynamicNeighborASNAllowed(peerGroup string,
+	asn uint32) (*dynamicNeighborRange, bool) {
+	// the peers configured one by one take precedence over the dynamic neighbors of their address and have no peer
+	// group
+	for i := range nrc.dynamicNeighborRanges {
+		if peerGroup == dynamicNeighborPeerGroup(i) {
+			r := &nrc.dynamicNeighborRanges[i]
+			return r, asn >= r.minASN && asn <= r.maxASN
+		}
+	}
+	return nil, true
+}
+
+// checkDynamicNeighbor tears down the session with a dynamic neighbor whose ASN is out of the ASN range of its listen
+// range, before the session is established
+func (nrc *NetworkRoutingController) checkDynamicNeighbor(event *gobgpapi.Peer) {
+	if event == nil || event.State == nil {
+		return
+	}
+	if event.State.SessionState != gobgpapi.PeerState_OPENCONFIRM &&
+		event.State.SessionState != gobgpapi.PeerState_ESTABLISHED {
+		return
+	}
+	address := event.State.NeighborAddress
+	// the BGP server is not to be called from its own event handlers
+	go func() {
+		var peer *gobgpapi.Peer
+		err := nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{Address: address},
+			func(p *gobgpapi.Peer) {
+				peer = p
+			})
+		if err != nil || peer == nil || peer.Conf == nil || peer.State == nil {
+			return
+		}
+		r, ok := nrc.dynamicNeighborASNAllowed(peer.Conf.PeerGroup, peer.State.PeerAsn)
+		if ok {
+			return
+		}
+		klog.Warningf("Tearing down the BGP session with dynamic neighbor %s as its ASN %d is out of the ASN range "+
+			"%d-%d of %s", address, peer.State.PeerAsn, r.minASN, r.maxASN, r.prefix)
+		err = nrc.bgpServer.DeletePeer(context.Background(), &gobgpapi.DeletePeerRequest{Address: address})
+		if err != nil {
+			klog.Errorf("Failed to tear down the BGP session with dynamic neighbor %s: %v", address, err)
+		}
+	}()
+}
@@ -0,0 +1,108 @@
+package routing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+)
+
+func Test_newDynamicNeighborRanges(t *testing.T) {
+	ranges, err := newDynamicNeighborRanges([]string{"192.168.0.0/24", "2001:db8::/64"},
+		[]string{"65000-65099", "65100"})
+	if err != nil {
+		t.Fatalf("failed to parse the dynamic neighbor ranges: %v", err)
+	}
+	got := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		got = append(got, r.prefix.String())
+		if r.minASN == 0 || r.maxASN < r.minASN {
+			t.Errorf("expected a valid ASN range for %s, got %d-%d", r.prefix, r.minASN, r.maxASN)
+		}
+	}
+	if expected := []string{"192.168.0.0/24", "2001:db8::/64"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the listen ranges %v, got %v", expected, got)
+	}
+	if ranges[0].minASN != 65000 || ranges[0].maxASN != 65099 || ranges[1].minASN != 65100 ||
+		ranges[1].maxASN != 65100 {
+		t.Errorf("expected the ASN ranges 65000-65099 and 65100-65100, got %v", ranges)
+	}
+
+	testcases := []struct {
+		prefixes []string
+		asns     []string
+	}{
+		{[]string{"192.168.0.0/24"}, nil},
+		{[]string{"192.168.0.0"}, []string{"65000"}},
+		{[]string{"192.168.0.0/24"}, []string{"65099-65000"}},
+		{[]string{"192.168.0.0/24"}, []string{"0"}},
+		{[]string{"192.168.0.0/24"}, []string{"as65000"}},
+	}
+	for _, testcase := range testcases {
+		if _, err = newDynamicNeighborRanges(testcase.prefixes, testcase.asns); err == nil {
+			t.Errorf("expected an error for the listen ranges %v with the ASNs %v", testcase.prefixes,
+				testcase.asns)
+		}
+	}
+}
+
+func Test_addDynamicNeighbors(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	nrc := &NetworkRoutingController{bgpServer: bgpServer, bgpHoldtime: 90}
+	nrc.dynamicNeighborRanges, err = newDynamicNeighborRanges([]string{"192.168.0.0/24"}, []string{"65000-65099"})
+	if err != nil {
+		t.Fatalf("failed to parse the dynamic neighbor ranges: %v", err)
+	}
+	if err = nrc.addDynamicNeighbors(); err != nil {
+		t.Fatalf("failed to add the dynamic neighbors: %v", err)
+	}
+	var neighbors []string
+	err = bgpServer.ListDynamicNeighbor(context.Background(), &gobgpapi.ListDynamicNeighborRequest{},
+		func(neighbor *gobgpapi.DynamicNeighbor) {
+			neighbors = append(neighbors, neighbor.Prefix+" "+neighbor.PeerGroup)
+		})
+	if err != nil {
+		t.Fatalf("failed to list the dynamic neighbors: %v", err)
+	}
+	if expected := []string{"192.168.0.0/24 dynamicneighbors0"}; !reflect.DeepEqual(neighbors, expected) {
+		t.Errorf("expected the dynamic neighbors %v, got %v", expected, neighbors)
+	}
+	externalPeerCIDRs, err := nrc.addExternalBGPPeersDefinedSet()
+	if err != nil {
+		t.Fatalf("failed to add the external peer set: %v", err)
+	}
+	if expected := []string{"192.168.0.0/24"}; !reflect.DeepEqual(externalPeerCIDRs, expected) {
+		t.Errorf("expected the listen range in the external peer set, got %v", externalPeerCIDRs)
+	}
+
+	testcases := []struct {
+		peerGroup string
+		asn       uint32
+		allowed   bool
+	}{
+		{"dynamicneighbors0", 65000, true},
+		{"dynamicneighbors0", 65099, true},
+		{"dynamicneighbors0", 65100, false},
+		{"dynamicneighbors0", 100, false},
+		{"", 100, true},
+	}
+	for _, testcase := range testcases {
+		if _, allowed := nrc.dynamicNeighborASNAllowed(testcase.peerGroup, testcase.asn); allowed != testcase.allowed {
+			t.Errorf("expected a peer of peer group %q in ASN %d to be allowed: %t, got %t", testcase.peerGroup,
+				testcase.asn, testcase.allowed, allowed)
+		}
+	}
+}
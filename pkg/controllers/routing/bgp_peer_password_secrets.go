@@ -0,0 +1,86 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"k8s.io/klog/v2"
+)
+
+// parsePeerPasswordSecrets parses the namespace/name[/key] references to the Secrets holding the passwords of the
+// external peers, an empty reference is a peer whose password, if any, isn't read from a Secret
+func parsePeerPasswordSecrets(refs []string, peers int, passwords []string) ([]*v1alpha1.SecretKeyReference, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	if len(refs) != peers {
+		return nil, fmt.Errorf("invalid peer router config. The number of password secrets must match the number " +
+			"of IPs")
+	}
+	secretRefs := make([]*v1alpha1.SecretKeyReference, 0, len(refs))
+	for i, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			secretRefs = append(secretRefs, nil)
+			continue
+		}
+		if i < len(passwords) && passwords[i] != "" {
+			return nil, fmt.Errorf("invalid peer router config. Peer %d has both a password and a password secret",
+				i)
+		}
+		parts := strings.Split(ref, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid peer password secret %q, expected namespace/name[/key]", ref)
+		}
+		secretRef := &v1alpha1.SecretKeyReference{Namespace: parts[0], Name: parts[1]}
+		if len(parts) == 3 {
+			secretRef.Key = parts[2]
+		}
+		secretRefs = append(secretRefs, secretRef)
+	}
+	return secretRefs, nil
+}
+
+// syncPeerPasswordSecrets reads the passwords of the external peers whose passwords are held in Secrets. When
+// reconnect is true, the sessions with the peers whose passwords have been rotated are set up again, as the password of
+// a running session can't be changed. A Secret that can't be read leaves the password of the peer as it is.
+func (nrc *NetworkRoutingController) syncPeerPasswordSecrets(reconnect bool) {
+	for i, ref := range nrc.globalPeerPasswordSecrets {
+		if ref == nil || i >= len(nrc.globalPeerRouters) {
+			continue
+		}
+		peer := nrc.globalPeerRouters[i]
+		password, err := nrc.bgpPeerPassword(ref)
+		if err != nil {
+			klog.Errorf("Failed to read the password of BGP peer %s from secret %s/%s: %v",
+				peer.Conf.NeighborAddress, ref.Namespace, ref.Name, err)
+			continue
+		}
+		if password == peer.Conf.AuthPassword {
+			continue
+		}
+		previous := peer.Conf.AuthPassword
+		peer.Conf.AuthPassword = password
+		if !reconnect {
+			continue
+		}
+		klog.Infof("The password of BGP peer %s has changed, setting up the session again",
+			peer.Conf.NeighborAddress)
+		err = nrc.bgpServer.DeletePeer(context.Background(),
+			&gobgpapi.DeletePeerRequest{Address: peer.Conf.NeighborAddress})
+		if err != nil {
+			// the peer may be gone already if it couldn't be added back on a previous sync
+			klog.Warningf("Failed to remove BGP peer %s to change its password: %v", peer.Conf.NeighborAddress, err)
+		}
+		err = nrc.connectToExternalBGPPeers(nrc.bgpServer, []*gobgpapi.Peer{peer}, nrc.bgpGracefulRestart,
+			nrc.bgpGracefulRestartDeferralTime, nrc.bgpGracefulRestartTime, nrc.peerMultihopTTL)
+		if err != nil {
+			klog.Errorf("Failed to peer with BGP peer %s with its new password, retrying on the next sync: %v",
+				peer.Conf.NeighborAddress, err)
+			peer.Conf.AuthPassword = previous
+		}
+	}
+}
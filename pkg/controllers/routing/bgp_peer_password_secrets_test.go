@@ -0,0 +1,118 @@
+package routing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_parsePeerPasswordSecrets(t *testing.T) {
+	testcases := []struct {
+		name      string
+		refs      []string
+		passwords []string
+		expected  []*v1alpha1.SecretKeyReference
+		err       bool
+	}{
+		{"no secrets", nil, nil, nil, false},
+		{"secrets", []string{"kube-system/tor", "", "kube-system/spine/key"}, []string{"", "secret", ""},
+			[]*v1alpha1.SecretKeyReference{{Namespace: "kube-system", Name: "tor"}, nil,
+				{Namespace: "kube-system", Name: "spine", Key: "key"}}, false},
+		{"fewer secrets than peers", []string{"kube-system/tor"}, nil, nil, true},
+		{"password and secret", []string{"kube-system/tor", "", ""}, []string{"secret"}, nil, true},
+		{"no namespace", []string{"tor", "", ""}, nil, nil, true},
+		{"too many parts", []string{"kube-system/tor/key/other", "", ""}, nil, nil, true},
+	}
+	for _, testcase := range testcases {
+		refs, err := parsePeerPasswordSecrets(testcase.refs, 3, testcase.passwords)
+		if testcase.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", testcase.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", testcase.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(refs, testcase.expected) {
+			t.Errorf("%s: expected %v, got %v", testcase.name, testcase.expected, refs)
+		}
+	}
+}
+
+func Test_syncPeerPasswordSecrets(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+
+	secret := &v1core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "tor"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	clientset := fake.NewSimpleClientset(secret)
+	nrc := &NetworkRoutingController{
+		bgpServer: bgpServer,
+		clientset: clientset,
+		globalPeerRouters: []*gobgpapi.Peer{
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "192.168.0.1", PeerAsn: 65000}},
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "192.168.0.2", PeerAsn: 65000, AuthPassword: "plain"}},
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "192.168.0.3", PeerAsn: 65000}},
+		},
+		globalPeerPasswordSecrets: []*v1alpha1.SecretKeyReference{{Namespace: "kube-system", Name: "tor"}, nil,
+			{Namespace: "kube-system", Name: "missing"}},
+	}
+
+	nrc.syncPeerPasswordSecrets(false)
+	passwords := func() []string {
+		passwords := make([]string, 0, len(nrc.globalPeerRouters))
+		for _, peer := range nrc.globalPeerRouters {
+			passwords = append(passwords, peer.Conf.AuthPassword)
+		}
+		return passwords
+	}
+	if expected := []string{"secret", "plain", ""}; !reflect.DeepEqual(passwords(), expected) {
+		t.Errorf("expected the passwords %v, got %v", expected, passwords())
+	}
+	err = nrc.connectToExternalBGPPeers(bgpServer, nrc.globalPeerRouters, false, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to add the peers: %v", err)
+	}
+
+	secret.Data["password"] = []byte("rotated")
+	if _, err = clientset.CoreV1().Secrets("kube-system").Update(context.Background(), secret,
+		metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update the secret: %v", err)
+	}
+	nrc.syncPeerPasswordSecrets(true)
+	if expected := []string{"rotated", "plain", ""}; !reflect.DeepEqual(passwords(), expected) {
+		t.Errorf("expected the rotated password, got %v", passwords())
+	}
+	var peerPassword string
+	err = bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{Address: "192.168.0.1"},
+		func(peer *gobgpapi.Peer) {
+			peerPassword = peer.Conf.AuthPassword
+		})
+	if err != nil {
+		t.Fatalf("failed to list the peers: %v", err)
+	}
+	if peerPassword != "rotated" {
+		t.Errorf("expected the peer to be set up again with the rotated password, got %q", peerPassword)
+	}
+}
@@ -0,0 +1,287 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const defaultBGPPeerPasswordKey = "password"
+
+// bgpPeerConfig is the BGP session with the peer router of a BGPPeer resource, as it was set up
+type bgpPeerConfig struct {
+	// name of the BGPPeer resource, whose status records the session
+	name        string
+	asn         uint32
+	port        uint32
+	password    string
+	holdTime    float64
+	multihopTTL uint8
+	// ttlSecurityHops are the hops to the peer with GTSM, zero without GTSM
+	ttlSecurityHops uint8
+	maxPrefixes     uint32
+	// restartTime and staleTime are the graceful restart and long-lived stale times, zero values stand for the ones of
+	// the flags
+	restartTime time.Duration
+	staleTime   time.Duration
+	bfd         bgpPeerBFDConfig
+	// rpkiInvalidAction is what is done with the invalid routes of the peer, the action of the flag when empty
+	rpkiInvalidAction string
+}
+
+// bgpPeerBFDConfig are the timers of the BFD session with the peer router of a BGPPeer resource, zero values stand for
+// the ones of the flags
+type bgpPeerBFDConfig struct {
+	interval   time.Duration
+	multiplier uint8
+}
+
+// sessionConfig returns the configuration of the BGP session, without the name of the resource, the BFD timers and the
+// RPKI invalid action which can change without the BGP session being set up again
+func (c bgpPeerConfig) sessionConfig() bgpPeerConfig {
+	c.name = ""
+	c.bfd = bgpPeerBFDConfig{}
+	c.rpkiInvalidAction = ""
+	return c
+}
+
+// bgpPeerResourcePeer is a peer router of a BGPPeer resource the node peers with
+type bgpPeerResourcePeer struct {
+	config bgpPeerConfig
+	peer   *gobgpapi.Peer
+}
+
+// EnableBGPPeerResources makes the controller peer with the routers of the BGPPeer custom resources that select the
+// node, next to the ones of the flags or of the node annotations, and record its sessions with them in the status of
+// the resources with the given client. The informer watches the BGPPeers, BGPPeerEventHandler is to be added to it.
+func (nrc *NetworkRoutingController) EnableBGPPeerResources(bgpPeerInformer cache.SharedIndexInformer,
+	client dynamic.Interface) {
+	nrc.bgpPeerLister = bgpPeerInformer.GetIndexer()
+	nrc.bgpPeerStatusClient = client
+	if nrc.bgpResourceSyncChan == nil {
+		nrc.bgpResourceSyncChan = make(chan struct{}, 1)
+	}
+	nrc.BGPPeerEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { nrc.requestBGPResourceSync() },
+		UpdateFunc: func(interface{}, interface{}) { nrc.requestBGPResourceSync() },
+		DeleteFunc: func(interface{}) { nrc.requestBGPResourceSync() },
+	}
+}
+
+// bgpPeerSelectsNode returns true if all of the labels of the peer's node selector are set on the node
+func bgpPeerSelectsNode(peer *v1alpha1.BGPPeer, node *v1core.Node) bool {
+	for key, value := range peer.Spec.NodeSelector {
+		if nodeValue, ok := node.Labels[key]; !ok || nodeValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// bgpPeerConfigs returns the sessions the node is to have with the peer routers of the BGPPeer resources that select
+// it, by the address of the peer router. The peers of invalid resources, or whose password can't be read, are left out
+// and logged, as are the ones with the address of a peer that sorts before them by name.
+func bgpPeerConfigs(objs []interface{}, node *v1core.Node, defaultHoldTime float64, defaultMultihopTTL uint8,
+	password func(ref *v1alpha1.SecretKeyReference) (string, error)) map[string]bgpPeerConfig {
+	peers := make([]*v1alpha1.BGPPeer, 0, len(objs))
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		peer := &v1alpha1.BGPPeer{}
+		if err := v1alpha1.FromUnstructured(u, peer); err != nil {
+			klog.Errorf("Ignoring BGPPeer %s that could not be parsed: %v", u.GetName(), err)
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+
+	configs := make(map[string]bgpPeerConfig)
+	for _, peer := range peers {
+		if !bgpPeerSelectsNode(peer, node) {
+			continue
+		}
+		ip := net.ParseIP(peer.Spec.PeerIP)
+		if ip == nil {
+			klog.Errorf("Ignoring BGPPeer %s with the invalid peer IP %q", peer.Name, peer.Spec.PeerIP)
+			continue
+		}
+		if _, ok := configs[ip.String()]; ok {
+			klog.Errorf("Ignoring BGPPeer %s as another BGPPeer already peers with %s", peer.Name, ip)
+			continue
+		}
+		config := bgpPeerConfig{name: peer.Name, asn: peer.Spec.PeerASN, port: peer.Spec.Port, holdTime: defaultHoldTime,
+			multihopTTL: defaultMultihopTTL, maxPrefixes: peer.Spec.MaxPrefixes}
+		if peer.Spec.HoldTime != nil {
+			config.holdTime = peer.Spec.HoldTime.Seconds()
+		}
+		if peer.Spec.MultihopTTL != 0 {
+			config.multihopTTL = peer.Spec.MultihopTTL
+		}
+		if peer.Spec.TTLSecurityHops != 0 {
+			if peer.Spec.MultihopTTL > 1 {
+				klog.Errorf("Ignoring BGPPeer %s with both a multihop TTL and TTL security hops", peer.Name)
+				continue
+			}
+			config.ttlSecurityHops = peer.Spec.TTLSecurityHops
+		}
+		if peer.Spec.BFD != nil {
+			config.bfd.multiplier = peer.Spec.BFD.Multiplier
+			if peer.Spec.BFD.Interval != nil {
+				if peer.Spec.BFD.Interval.Duration <= 0 {
+					klog.Errorf("Ignoring BGPPeer %s with the invalid BFD interval %s", peer.Name,
+						peer.Spec.BFD.Interval.Duration)
+					continue
+				}
+				config.bfd.interval = peer.Spec.BFD.Interval.Duration
+			}
+		}
+		if peer.Spec.GracefulRestart != nil {
+			if peer.Spec.GracefulRestart.RestartTime != nil {
+				config.restartTime = peer.Spec.GracefulRestart.RestartTime.Duration
+			}
+			if peer.Spec.GracefulRestart.LongLivedStaleTime != nil {
+				config.staleTime = peer.Spec.GracefulRestart.LongLivedStaleTime.Duration
+			}
+		}
+		if peer.Spec.RPKIInvalidAction != "" && !isRPKIInvalidAction(peer.Spec.RPKIInvalidAction) {
+			klog.Errorf("Ignoring BGPPeer %s with the invalid RPKI invalid action %q", peer.Name,
+				peer.Spec.RPKIInvalidAction)
+			continue
+		}
+		config.rpkiInvalidAction = peer.Spec.RPKIInvalidAction
+		if peer.Spec.PasswordSecretRef != nil {
+			var err error
+			if config.password, err = password(peer.Spec.PasswordSecretRef); err != nil {
+				klog.Errorf("Ignoring BGPPeer %s as its password could not be read: %v", peer.Name, err)
+				continue
+			}
+		}
+		configs[ip.String()] = config
+	}
+	return configs
+}
+
+// bgpPeerPassword reads the password of a BGPPeer from its Secret
+func (nrc *NetworkRoutingController) bgpPeerPassword(ref *v1alpha1.SecretKeyReference) (string, error) {
+	secret, err := nrc.clientset.CoreV1().Secrets(ref.Namespace).Get(context.Background(), ref.Name,
+		metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	key := ref.Key
+	if key == "" {
+		key = defaultBGPPeerPasswordKey
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %s", ref.Namespace, ref.Name, key)
+	}
+	return string(value), nil
+}
+
+// syncBGPPeerResources peers with the routers of the BGPPeer resources that select the node, changing and removing
+// the sessions set up for them before as the resources change. The peers of the flags or of the node annotations
+// take precedence over the resources with the same peer IP.
+func (nrc *NetworkRoutingController) syncBGPPeerResources() {
+	obj, exists, err := nrc.nodeLister.GetByKey(nrc.nodeName)
+	if err != nil || !exists {
+		klog.Errorf("Failed to get node %s from the cache to select its BGPPeers: %v", nrc.nodeName, err)
+		return
+	}
+	configs := bgpPeerConfigs(nrc.bgpPeerLister.List(), obj.(*v1core.Node), nrc.bgpHoldtime, nrc.peerMultihopTTL,
+		nrc.bgpPeerPassword)
+
+	if nrc.bgpPeerResourcePeers == nil {
+		nrc.bgpPeerResourcePeers = make(map[string]*bgpPeerResourcePeer)
+	}
+	for address, current := range nrc.bgpPeerResourcePeers {
+		if config, ok := configs[address]; ok && config.sessionConfig() == current.config.sessionConfig() {
+			// changed BFD timers are applied to the running BFD session by syncBFDSessions and changed RPKI invalid
+			// actions to the RPKI defined sets by AddPolicies
+			current.config = config
+			continue
+		}
+		err = nrc.bgpServer.DeletePeer(context.Background(), &gobgpapi.DeletePeerRequest{Address: address})
+		if err != nil {
+			klog.Errorf("Failed to remove BGP peer %s of a BGPPeer resource: %v", address, err)
+			continue
+		}
+		klog.Infof("Removed BGP peer %s of a BGPPeer resource", address)
+		delete(nrc.bgpPeerResourcePeers, address)
+	}
+
+	configured := make(map[string]bool)
+	for _, peer := range nrc.globalPeerRouters {
+		configured[net.ParseIP(peer.Conf.NeighborAddress).String()] = true
+	}
+	for address, config := range configs {
+		if _, ok := nrc.bgpPeerResourcePeers[address]; ok {
+			continue
+		}
+		if configured[address] {
+			klog.Warningf("Ignoring the BGPPeer of %s as it is configured as peer router already", address)
+			continue
+		}
+		var ports []uint32
+		if config.port != 0 {
+			ports = []uint32{config.port}
+		}
+		peers, err := newGlobalPeers([]net.IP{net.ParseIP(address)}, ports, []uint32{config.asn},
+			[]string{config.password}, nil, []uint32{config.maxPrefixes}, nrc.peerMaxPrefixesWarningPct,
+			[]time.Duration{config.restartTime}, []time.Duration{config.staleTime}, config.holdTime, nrc.bgpIP.String())
+		if err == nil {
+			err = setPeerTTLs(peers, nil, []uint32{uint32(config.ttlSecurityHops)})
+		}
+		if err != nil {
+			klog.Errorf("Ignoring the BGPPeer of %s: %v", address, err)
+			continue
+		}
+		err = nrc.connectToExternalBGPPeers(nrc.bgpServer, peers, nrc.bgpGracefulRestart,
+			nrc.bgpGracefulRestartDeferralTime, nrc.bgpGracefulRestartTime, config.multihopTTL)
+		if err != nil {
+			klog.Errorf("Failed to peer with the router of a BGPPeer resource: %v", err)
+			continue
+		}
+		nrc.bgpPeerResourcePeers[address] = &bgpPeerResourcePeer{config: config, peer: peers[0]}
+	}
+
+	addresses := make([]string, 0, len(nrc.bgpPeerResourcePeers))
+	names := make(map[string]string, len(nrc.bgpPeerResourcePeers))
+	for address, current := range nrc.bgpPeerResourcePeers {
+		addresses = append(addresses, address)
+		names[address] = current.config.name
+	}
+	nrc.setBGPPeerResourceNames(names)
+	sort.Strings(addresses)
+	resourcePeers := make([]*gobgpapi.Peer, 0, len(addresses))
+	for _, address := range addresses {
+		resourcePeers = append(resourcePeers, nrc.bgpPeerResourcePeers[address].peer)
+	}
+	nrc.resourcePeerRouters = resourcePeers
+	nrc.syncBFDSessions(nrc.externalPeers())
+}
+
+// externalPeers returns the external BGP peers of the node, the ones of the flags or of the node annotations and the
+// ones of the BGPPeer resources
+func (nrc *NetworkRoutingController) externalPeers() []*gobgpapi.Peer {
+	if len(nrc.resourcePeerRouters) == 0 {
+		return nrc.globalPeerRouters
+	}
+	peers := make([]*gobgpapi.Peer, 0, len(nrc.globalPeerRouters)+len(nrc.resourcePeerRouters))
+	peers = append(peers, nrc.globalPeerRouters...)
+	return append(peers, nrc.resourcePeerRouters...)
+}
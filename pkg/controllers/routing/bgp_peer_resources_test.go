@@ -0,0 +1,211 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_bgpPeerConfigs(t *testing.T) {
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"rack": "a"}}}
+	peers := []*v1alpha1.BGPPeer{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "all"},
+			Spec:       v1alpha1.BGPPeerSpec{PeerIP: "192.168.0.1", PeerASN: 65000, TTLSecurityHops: 1},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "rack-a"},
+			Spec: v1alpha1.BGPPeerSpec{PeerIP: "192.168.1.1", PeerASN: 65001, Port: 1179,
+				HoldTime: &metav1.Duration{Duration: 30 * time.Second}, MultihopTTL: 2, MaxPrefixes: 1000,
+				PasswordSecretRef: &v1alpha1.SecretKeyReference{Namespace: "kube-system", Name: "rack-a"},
+				BFD:               &v1alpha1.BGPPeerBFD{Interval: &metav1.Duration{Duration: 100 * time.Millisecond}},
+				NodeSelector:      map[string]string{"rack": "a"},
+				GracefulRestart: &v1alpha1.BGPPeerGracefulRestart{
+					RestartTime:        &metav1.Duration{Duration: 2 * time.Minute},
+					LongLivedStaleTime: &metav1.Duration{Duration: 24 * time.Hour}},
+				RPKIInvalidAction: "depreference"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "rack-b"},
+			Spec: v1alpha1.BGPPeerSpec{PeerIP: "192.168.2.1", PeerASN: 65002,
+				NodeSelector: map[string]string{"rack": "b"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "duplicate"},
+			Spec:       v1alpha1.BGPPeerSpec{PeerIP: "192.168.0.1", PeerASN: 65003},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+			Spec:       v1alpha1.BGPPeerSpec{PeerIP: "192.168.0", PeerASN: 65004},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid-ttl-security"},
+			Spec: v1alpha1.BGPPeerSpec{PeerIP: "192.168.5.1", PeerASN: 65007, MultihopTTL: 2,
+				TTLSecurityHops: 1},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid-rpki-action"},
+			Spec:       v1alpha1.BGPPeerSpec{PeerIP: "192.168.4.1", PeerASN: 65006, RPKIInvalidAction: "drop"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "missing-password"},
+			Spec: v1alpha1.BGPPeerSpec{PeerIP: "192.168.3.1", PeerASN: 65005,
+				PasswordSecretRef: &v1alpha1.SecretKeyReference{Namespace: "kube-system", Name: "missing"}},
+		},
+	}
+	objs := make([]interface{}, 0, len(peers))
+	for _, peer := range peers {
+		obj, err := v1alpha1.ToUnstructured(peer)
+		if err != nil {
+			t.Fatalf("failed to convert BGPPeer: %v", err)
+		}
+		objs = append(objs, obj)
+	}
+	password := func(ref *v1alpha1.SecretKeyReference) (string, error) {
+		if ref.Name == "missing" {
+			return "", errors.New("not found")
+		}
+		return "secret", nil
+	}
+
+	expected := map[string]bgpPeerConfig{
+		"192.168.0.1": {name: "all", asn: 65000, holdTime: 90, multihopTTL: 1, ttlSecurityHops: 1},
+		"192.168.1.1": {name: "rack-a", asn: 65001, port: 1179, password: "secret", holdTime: 30, multihopTTL: 2,
+			maxPrefixes: 1000, restartTime: 2 * time.Minute, staleTime: 24 * time.Hour,
+			bfd: bgpPeerBFDConfig{interval: 100 * time.Millisecond}, rpkiInvalidAction: "depreference"},
+	}
+	if configs := bgpPeerConfigs(objs, node, 90, 1, password); !reflect.DeepEqual(configs, expected) {
+		t.Errorf("expected %v, got %v", expected, configs)
+	}
+}
+
+func Test_peerBFDTimers(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		peerBFDInterval:   300 * time.Millisecond,
+		peerBFDMultiplier: 3,
+		bgpPeerResourcePeers: map[string]*bgpPeerResourcePeer{
+			"192.168.0.1": {config: bgpPeerConfig{bfd: bgpPeerBFDConfig{interval: 100 * time.Millisecond}}},
+			"192.168.0.2": {config: bgpPeerConfig{bfd: bgpPeerBFDConfig{multiplier: 5}}},
+		},
+	}
+	testcases := []struct {
+		address    string
+		interval   time.Duration
+		multiplier uint8
+	}{
+		{"192.168.0.1", 100 * time.Millisecond, 3},
+		{"192.168.0.2", 300 * time.Millisecond, 5},
+		{"192.168.0.3", 300 * time.Millisecond, 3},
+	}
+	for _, testcase := range testcases {
+		interval, multiplier := nrc.peerBFDTimers(testcase.address)
+		if interval != testcase.interval || multiplier != testcase.multiplier {
+			t.Errorf("expected the BFD timers %s and %d for %s, got %s and %d", testcase.interval,
+				testcase.multiplier, testcase.address, interval, multiplier)
+		}
+	}
+
+	config := bgpPeerConfig{asn: 65000, bfd: bgpPeerBFDConfig{interval: 100 * time.Millisecond},
+		rpkiInvalidAction: "accept"}
+	if config.sessionConfig() != (bgpPeerConfig{asn: 65000}) {
+		t.Error("expected the BFD timers and the RPKI invalid action to be left out of the configuration of the BGP " +
+			"session")
+	}
+}
+
+func Test_bgpPeerPassword(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "tor"},
+		Data:       map[string][]byte{"password": []byte("secret"), "other": []byte("other-secret")},
+	})
+	nrc := &NetworkRoutingController{clientset: clientset}
+
+	password, err := nrc.bgpPeerPassword(&v1alpha1.SecretKeyReference{Namespace: "kube-system", Name: "tor"})
+	if err != nil || password != "secret" {
+		t.Errorf("expected the password key of the secret, got %q: %v", password, err)
+	}
+	password, err = nrc.bgpPeerPassword(&v1alpha1.SecretKeyReference{Namespace: "kube-system", Name: "tor",
+		Key: "other"})
+	if err != nil || password != "other-secret" {
+		t.Errorf("expected the given key of the secret, got %q: %v", password, err)
+	}
+	if _, err = nrc.bgpPeerPassword(&v1alpha1.SecretKeyReference{Namespace: "kube-system", Name: "tor",
+		Key: "missing"}); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+	if _, err = nrc.bgpPeerPassword(&v1alpha1.SecretKeyReference{Namespace: "default",
+		Name: "tor"}); err == nil {
+		t.Error("expected an error for a missing secret")
+	}
+}
+
+func Test_addExternalBGPPeersDefinedSetWithBGPPeerResources(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	nrc := &NetworkRoutingController{bgpServer: bgpServer,
+		bgpPeerLister: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})}
+
+	externalPeerSet := func() []string {
+		var list []string
+		err := bgpServer.ListDefinedSet(context.Background(), &gobgpapi.ListDefinedSetRequest{
+			DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: "externalpeerset"}, func(ds *gobgpapi.DefinedSet) {
+			list = ds.List
+		})
+		if err != nil {
+			t.Fatalf("failed to list the external peer set: %v", err)
+		}
+		return list
+	}
+
+	if _, err = nrc.addExternalBGPPeersDefinedSet(); err != nil {
+		t.Fatalf("failed to add the external peer set: %v", err)
+	}
+	if list := externalPeerSet(); len(list) != 0 {
+		t.Errorf("expected an empty external peer set without peers, got %v", list)
+	}
+
+	nrc.resourcePeerRouters = []*gobgpapi.Peer{{Conf: &gobgpapi.PeerConf{NeighborAddress: "192.168.0.1"}}}
+	if _, err = nrc.addExternalBGPPeersDefinedSet(); err != nil {
+		t.Fatalf("failed to update the external peer set: %v", err)
+	}
+	if list := externalPeerSet(); !reflect.DeepEqual(list, []string{"192.168.0.1/32"}) {
+		t.Errorf("expected the peer of the BGPPeer resource in the external peer set, got %v", list)
+	}
+
+	nrc.resourcePeerRouters = append(nrc.resourcePeerRouters,
+		&gobgpapi.Peer{Conf: &gobgpapi.PeerConf{NeighborAddress: "2001:db8::1"}})
+	if _, err = nrc.addExternalBGPPeersDefinedSet(); err != nil {
+		t.Fatalf("failed to update the external peer set: %v", err)
+	}
+	if list := externalPeerSet(); !reflect.DeepEqual(list, []string{"192.168.0.1/32", "2001:db8::1/128"}) {
+		t.Errorf("expected the IPv6 peer as a host prefix in the external peer set, got %v", list)
+	}
+
+	nrc.resourcePeerRouters = nil
+	if _, err = nrc.addExternalBGPPeersDefinedSet(); err != nil {
+		t.Fatalf("failed to update the external peer set: %v", err)
+	}
+	if list := externalPeerSet(); len(list) != 0 {
+		t.Errorf("expected the removed peer to be gone from the external peer set, got %v", list)
+	}
+}
@@ -0,0 +1,229 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	bgpPeerDownEventReason    = "BGPPeerDown"
+	bgpPeerStatusSyncPeriod   = 15 * time.Second
+	bgpPeerStatusWriteTimeout = 30 * time.Second
+	bgpPeerStatusSubresource  = "status"
+)
+
+// bgpPeerSession is the state of the BGP session with a peer as last reported by the BGP server, along with the
+// number of times it went down after being established
+type bgpPeerSession struct {
+	state gobgpapi.PeerState_SessionState
+	flaps uint32
+}
+
+// watchBGPPeerStates follows the state changes of the BGP sessions with the peers, counting the times they go down
+// after being established and recording an Event on the node when they do
+func (nrc *NetworkRoutingController) watchBGPPeerStates() error {
+	err := nrc.bgpServer.WatchEvent(context.Background(), &gobgpapi.WatchEventRequest{
+		Peer: &gobgpapi.WatchEventRequest_Peer{},
+	}, func(r *gobgpapi.WatchEventResponse) {
+		if event := r.GetPeer(); event != nil && event.Type == gobgpapi.WatchEventResponse_PeerEvent_STATE {
+			nrc.handleBGPPeerState(event.Peer)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch the state of the BGP peers: %v", err)
+	}
+	return nil
+}
+
+// handleBGPPeerState records the new state of the BGP session with a peer. The peers removed from the BGP server
+// aren't reported, so only the sessions that went down on their own are counted as flaps.
+func (nrc *NetworkRoutingController) handleBGPPeerState(peer *gobgpapi.Peer) {
+	if peer == nil || peer.State == nil {
+		return
+	}
+	address, state := peer.State.NeighborAddress, peer.State.SessionState
+	nrc.bgpPeerStatusMutex.Lock()
+	if nrc.bgpPeerSessions == nil {
+		nrc.bgpPeerSessions = make(map[string]*bgpPeerSession)
+	}
+	session, ok := nrc.bgpPeerSessions[address]
+	if !ok {
+		session = &bgpPeerSession{}
+		nrc.bgpPeerSessions[address] = session
+	}
+	wentDown := session.state == gobgpapi.PeerState_ESTABLISHED && state != gobgpapi.PeerState_ESTABLISHED
+	session.state = state
+	if wentDown {
+		session.flaps++
+	}
+	nrc.bgpPeerStatusMutex.Unlock()
+	if !wentDown {
+		return
+	}
+
+	nrc.logger.Info("BGP session with peer went down", utils.LogKeyPeer, address, "asn", peer.State.PeerAsn)
+	if nrc.MetricsEnabled {
+		metrics.ControllerBGPPeerFlaps.WithLabelValues(address).Inc()
+	}
+	utils.RecordNodeWarning(nrc.eventRecorder, nrc.nodeName, bgpPeerDownEventReason,
+		"BGP session with peer %s (AS %d) went down", address, peer.State.PeerAsn)
+}
+
+// setBGPPeerResourceNames records the names of the BGPPeer resources of the peers, by the address of the peer router,
+// whose status records the session of the node with the peer
+func (nrc *NetworkRoutingController) setBGPPeerResourceNames(names map[string]string) {
+	nrc.bgpPeerStatusMutex.Lock()
+	defer nrc.bgpPeerStatusMutex.Unlock()
+	nrc.bgpPeerResourceNames = names
+}
+
+// runBGPPeerStatus starts a goroutine that exports the state of the BGP sessions with the peers every
+// bgpPeerStatusSyncPeriod
+func (nrc *NetworkRoutingController) runBGPPeerStatus(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		t := time.NewTicker(bgpPeerStatusSyncPeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				nrc.syncBGPPeerStatus()
+			case <-stopCh:
+				klog.Infof("Shutting down the BGP peer status sync")
+				return
+			}
+		}
+	}(stopCh, wg)
+}
+
+// syncBGPPeerStatus exports the state of the BGP sessions with the peers as metrics, and records the sessions with
+// the peer routers of BGPPeer resources in the status of their resource. The status of a resource is only written when
+// the session of the node changes, and the node is removed from it once it doesn't peer with the router anymore.
+func (nrc *NetworkRoutingController) syncBGPPeerStatus() {
+	var peers []*gobgpapi.Peer
+	err := nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{EnableAdvertised: true},
+		func(peer *gobgpapi.Peer) {
+			peers = append(peers, peer)
+		})
+	if err != nil {
+		klog.Errorf("Failed to list the BGP peers to export their status: %v", err)
+		return
+	}
+
+	if nrc.MetricsEnabled {
+		metrics.ControllerBGPPeerSessionState.Reset()
+		metrics.ControllerBGPPeerUptime.Reset()
+		metrics.ControllerBGPPeerPrefixesReceived.Reset()
+		metrics.ControllerBGPPeerPrefixesAdvertised.Reset()
+	}
+	statuses := make(map[string]v1alpha1.BGPPeerNodeStatus)
+	nrc.bgpPeerStatusMutex.Lock()
+	for _, peer := range peers {
+		if peer.State == nil {
+			continue
+		}
+		address := peer.State.NeighborAddress
+		var flaps uint32
+		if session, ok := nrc.bgpPeerSessions[address]; ok {
+			flaps = session.flaps
+		}
+		status := bgpPeerNodeStatus(peer, flaps)
+		if nrc.MetricsEnabled {
+			metrics.ControllerBGPPeerSessionState.WithLabelValues(address).Set(float64(peer.State.SessionState))
+			uptime := 0.0
+			if status.EstablishedTime != nil {
+				uptime = time.Since(status.EstablishedTime.Time).Seconds()
+			}
+			metrics.ControllerBGPPeerUptime.WithLabelValues(address).Set(uptime)
+			metrics.ControllerBGPPeerPrefixesReceived.WithLabelValues(address).Set(float64(status.PrefixesReceived))
+			metrics.ControllerBGPPeerPrefixesAdvertised.WithLabelValues(address).
+				Set(float64(status.PrefixesAdvertised))
+		}
+		if name, ok := nrc.bgpPeerResourceNames[address]; ok {
+			statuses[name] = status
+		}
+	}
+	nrc.bgpPeerStatusMutex.Unlock()
+
+	if nrc.bgpPeerStatusClient == nil {
+		return
+	}
+	// the written statuses are only used by this goroutine
+	if nrc.bgpPeerStatuses == nil {
+		nrc.bgpPeerStatuses = make(map[string]v1alpha1.BGPPeerNodeStatus)
+	}
+	for name, status := range statuses {
+		if written, ok := nrc.bgpPeerStatuses[name]; ok && reflect.DeepEqual(written, status) {
+			continue
+		}
+		status := status
+		if err = nrc.writeBGPPeerStatus(name, &status); err != nil {
+			klog.Warningf("Failed to update the status of BGPPeer %s: %v", name, err)
+			continue
+		}
+		nrc.bgpPeerStatuses[name] = status
+	}
+	for name := range nrc.bgpPeerStatuses {
+		if _, ok := statuses[name]; ok {
+			continue
+		}
+		if err = nrc.writeBGPPeerStatus(name, nil); err != nil && !apierrors.IsNotFound(err) {
+			klog.Warningf("Failed to remove node %s from the status of BGPPeer %s: %v", nrc.nodeName, name, err)
+			continue
+		}
+		delete(nrc.bgpPeerStatuses, name)
+	}
+}
+
+// bgpPeerNodeStatus returns the status of the BGP session of the node with the given peer
+func bgpPeerNodeStatus(peer *gobgpapi.Peer, flaps uint32) v1alpha1.BGPPeerNodeStatus {
+	status := v1alpha1.BGPPeerNodeStatus{
+		SessionState: strings.ToLower(peer.State.SessionState.String()),
+		Flaps:        flaps,
+	}
+	if peer.State.SessionState == gobgpapi.PeerState_ESTABLISHED && peer.Timers != nil && peer.Timers.State != nil &&
+		peer.Timers.State.Uptime != nil {
+		establishedTime := metav1.NewTime(peer.Timers.State.Uptime.AsTime())
+		status.EstablishedTime = &establishedTime
+	}
+	for _, afiSafi := range peer.AfiSafis {
+		if afiSafi.State != nil {
+			status.PrefixesReceived += int64(afiSafi.State.Received)
+			status.PrefixesAdvertised += int64(afiSafi.State.Advertised)
+		}
+	}
+	return status
+}
+
+// writeBGPPeerStatus merges the given session of the node into the status of the BGPPeer of the given name, or removes
+// the node from it when the session is nil, leaving the sessions of the other nodes alone
+func (nrc *NetworkRoutingController) writeBGPPeerStatus(name string, status *v1alpha1.BGPPeerNodeStatus) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"nodes": map[string]interface{}{nrc.nodeName: status},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), bgpPeerStatusWriteTimeout)
+	defer cancel()
+	_, err = nrc.bgpPeerStatusClient.Resource(v1alpha1.BGPPeerResource).Patch(ctx, name, types.MergePatchType, patch,
+		metav1.PatchOptions{}, bgpPeerStatusSubresource)
+	return err
+}
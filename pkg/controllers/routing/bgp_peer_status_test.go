@@ -0,0 +1,159 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_bgpPeerNodeStatus(t *testing.T) {
+	uptime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	establishedTime := metav1.NewTime(uptime)
+	afiSafis := []*gobgpapi.AfiSafi{
+		{State: &gobgpapi.AfiSafiState{Received: 3, Advertised: 5}},
+		{State: &gobgpapi.AfiSafiState{Received: 1, Advertised: 2}},
+		{},
+	}
+	testcases := []struct {
+		name     string
+		peer     *gobgpapi.Peer
+		expected v1alpha1.BGPPeerNodeStatus
+	}{
+		{
+			"established session",
+			&gobgpapi.Peer{
+				State:    &gobgpapi.PeerState{SessionState: gobgpapi.PeerState_ESTABLISHED},
+				Timers:   &gobgpapi.Timers{State: &gobgpapi.TimersState{Uptime: timestamppb.New(uptime)}},
+				AfiSafis: afiSafis,
+			},
+			v1alpha1.BGPPeerNodeStatus{SessionState: "established", EstablishedTime: &establishedTime,
+				PrefixesReceived: 4, PrefixesAdvertised: 7, Flaps: 2},
+		},
+		{
+			"session down since it was established",
+			&gobgpapi.Peer{
+				State:  &gobgpapi.PeerState{SessionState: gobgpapi.PeerState_ACTIVE},
+				Timers: &gobgpapi.Timers{State: &gobgpapi.TimersState{Uptime: timestamppb.New(uptime)}},
+			},
+			v1alpha1.BGPPeerNodeStatus{SessionState: "active", Flaps: 2},
+		},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if status := bgpPeerNodeStatus(testcase.peer, 2); !reflect.DeepEqual(status, testcase.expected) {
+				t.Errorf("expected the status %+v, got %+v", testcase.expected, status)
+			}
+		})
+	}
+}
+
+func Test_handleBGPPeerState(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	nrc := &NetworkRoutingController{nodeName: "node-1", eventRecorder: recorder}
+	peerState := func(state gobgpapi.PeerState_SessionState) *gobgpapi.Peer {
+		return &gobgpapi.Peer{State: &gobgpapi.PeerState{NeighborAddress: "10.0.0.1", PeerAsn: 65000,
+			SessionState: state}}
+	}
+
+	for _, state := range []gobgpapi.PeerState_SessionState{gobgpapi.PeerState_ACTIVE,
+		gobgpapi.PeerState_OPENSENT, gobgpapi.PeerState_ESTABLISHED, gobgpapi.PeerState_IDLE,
+		gobgpapi.PeerState_ACTIVE, gobgpapi.PeerState_ESTABLISHED, gobgpapi.PeerState_IDLE} {
+		nrc.handleBGPPeerState(peerState(state))
+	}
+	if flaps := nrc.bgpPeerSessions["10.0.0.1"].flaps; flaps != 2 {
+		t.Errorf("expected 2 flaps of the session, got %d", flaps)
+	}
+	if len(recorder.Events) != 2 {
+		t.Fatalf("expected an Event each time the session went down, got %d", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, bgpPeerDownEventReason) ||
+		!strings.Contains(event, "10.0.0.1 (AS 65000)") {
+		t.Errorf("expected an Event of the session with the peer going down, got %q", event)
+	}
+}
+
+func Test_syncBGPPeerStatus(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	err = bgpServer.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{Peer: &gobgpapi.Peer{
+		Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.0.1", PeerAsn: 65000}}})
+	if err != nil {
+		t.Fatalf("failed to add BGP peer: %v", err)
+	}
+
+	// the fake client only takes the types of decoded JSON
+	peerJSON, err := json.Marshal(&v1alpha1.BGPPeer{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "BGPPeer"},
+		ObjectMeta: metav1.ObjectMeta{Name: "rack1-tor"},
+		Spec:       v1alpha1.BGPPeerSpec{PeerIP: "10.0.0.1", PeerASN: 65000},
+		Status: v1alpha1.BGPPeerStatus{Nodes: map[string]v1alpha1.BGPPeerNodeStatus{
+			"node-2": {SessionState: "established"}}},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal BGPPeer: %v", err)
+	}
+	peer := &unstructured.Unstructured{}
+	if err = peer.UnmarshalJSON(peerJSON); err != nil {
+		t.Fatalf("failed to unmarshal BGPPeer: %v", err)
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.BGPPeerResource: "BGPPeerList"}, peer)
+	nrc := &NetworkRoutingController{bgpServer: bgpServer, nodeName: "node-1", bgpPeerStatusClient: client}
+	readStatus := func() v1alpha1.BGPPeerStatus {
+		obj, err := client.Resource(v1alpha1.BGPPeerResource).Get(context.Background(), "rack1-tor",
+			metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get BGPPeer: %v", err)
+		}
+		peer := &v1alpha1.BGPPeer{}
+		if err = v1alpha1.FromUnstructured(obj, peer); err != nil {
+			t.Fatalf("failed to convert BGPPeer: %v", err)
+		}
+		return peer.Status
+	}
+
+	nrc.setBGPPeerResourceNames(map[string]string{"10.0.0.1": "rack1-tor"})
+	nrc.syncBGPPeerStatus()
+	status := readStatus()
+	if node, ok := status.Nodes["node-1"]; !ok || node.SessionState == "established" || node.EstablishedTime != nil {
+		t.Errorf("expected the session of the node not to be established yet, got %+v", status.Nodes)
+	}
+	if _, ok := status.Nodes["node-2"]; !ok {
+		t.Errorf("expected the session of the other node to be kept, got %+v", status.Nodes)
+	}
+
+	nrc.setBGPPeerResourceNames(map[string]string{})
+	nrc.syncBGPPeerStatus()
+	status = readStatus()
+	if _, ok := status.Nodes["node-1"]; ok {
+		t.Errorf("expected the node to be removed from the status once it doesn't peer anymore, got %+v",
+			status.Nodes)
+	}
+	if _, ok := status.Nodes["node-2"]; !ok {
+		t.Errorf("expected the session of the other node to be kept, got %+v", status.Nodes)
+	}
+}
@@ -42,18 +42,22 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 	if nrc.MetricsEnabled {
 		metrics.ControllerBPGpeers.Set(float64(len(nodes)))
 	}
+	var zones *ibgpZones
+	if nrc.ibgpZoneLabel != "" {
+		zones = newIBGPZones(nodes, nrc.nodeName, nrc.ibgpZoneLabel, nrc.ibgpZoneRouteReflectors)
+	}
 	// establish peer and add Pod CIDRs with current set of nodes
 	currentNodes := make([]string, 0)
 	for _, obj := range nodes {
 		node := obj.(*v1core.Node)
-		nodeIP, err := utils.GetNodeIP(node)
-		if err != nil {
-			klog.Errorf("Failed to find a node IP and therefore cannot sync internal BGP Peer: %v", err)
+		// skip self
+		if node.Name == nrc.nodeName {
 			continue
 		}
 
-		// skip self
-		if nodeIP.String() == nrc.nodeIP.String() {
+		nodeIP, err := nodeBGPAddress(node)
+		if err != nil {
+			klog.Errorf("Failed to find a node IP and therefore cannot sync internal BGP Peer: %v", err)
 			continue
 		}
 
@@ -64,6 +68,16 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 			}
 		}
 
+		// in a partitioned mesh we only peer with the route reflectors of our zone, or as one of them with the other
+		// route reflectors and the nodes of our zone
+		reflectorClusterID := ""
+		if zones != nil {
+			var peer bool
+			if peer, reflectorClusterID = zones.peering(node); !peer {
+				continue
+			}
+		}
+
 		// if node full mesh is not requested then just peer with nodes with same ASN
 		// (run iBGP among same ASN peers)
 		if !nrc.bgpFullMeshMode {
@@ -91,7 +105,7 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 
 		currentNodes = append(currentNodes, nodeIP.String())
 		nrc.activeNodes[nodeIP.String()] = true
-		// explicitly set neighbors.transport.config.local-address with nodeIP which is configured
+		// explicitly set neighbors.transport.config.local-address with the BGP address which is configured
 		// as their neighbor address at the remote peers.
 		// this prevents the controller from initiating connection to its peers with a different IP address
 		// when multiple L3 interfaces are active.
@@ -101,7 +115,7 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 				PeerAsn:         nrc.nodeAsnNumber,
 			},
 			Transport: &gobgpapi.Transport{
-				LocalAddress: nrc.nodeIP.String(),
+				LocalAddress: nrc.bgpIP.String(),
 				RemotePort:   nrc.bgpPort,
 			},
 		}
@@ -113,40 +127,39 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 				DeferralTime:    uint32(nrc.bgpGracefulRestartDeferralTime.Seconds()),
 				LocalRestarting: true,
 			}
+		}
 
-			if nrc.isIpv6 {
-				n.AfiSafis = []*gobgpapi.AfiSafi{
-					{
-						Config: &gobgpapi.AfiSafiConfig{
-							Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST},
-							Enabled: true,
-						},
-						MpGracefulRestart: &gobgpapi.MpGracefulRestart{
-							Config: &gobgpapi.MpGracefulRestartConfig{
-								Enabled: true,
-							},
-							State: &gobgpapi.MpGracefulRestartState{},
-						},
+		// dual-stack nodes exchange the routes of both address families over the sessions between their node IPs
+		if nrc.bgpGracefulRestart || nrc.nodeDualStackIP != nil {
+			n.AfiSafis = make([]*gobgpapi.AfiSafi, 0, 2)
+			for _, ipv6 := range nrc.addressFamilies() {
+				afiSafi := &gobgpapi.AfiSafi{
+					Config: &gobgpapi.AfiSafiConfig{
+						Family:  unicastFamily(ipv6),
+						Enabled: true,
 					},
 				}
-			} else {
-				n.AfiSafis = []*gobgpapi.AfiSafi{
-					{
-						Config: &gobgpapi.AfiSafiConfig{
-							Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+				if nrc.bgpGracefulRestart {
+					afiSafi.MpGracefulRestart = &gobgpapi.MpGracefulRestart{
+						Config: &gobgpapi.MpGracefulRestartConfig{
 							Enabled: true,
 						},
-						MpGracefulRestart: &gobgpapi.MpGracefulRestart{
-							Config: &gobgpapi.MpGracefulRestartConfig{
-								Enabled: true,
-							},
-							State: &gobgpapi.MpGracefulRestartState{},
-						},
-					},
+						State: &gobgpapi.MpGracefulRestartState{},
+					}
 				}
+				n.AfiSafis = append(n.AfiSafis, afiSafi)
 			}
 		}
 
+		if nrc.bgpGracefulRestart && nrc.bgpLongLivedGracefulRestart {
+			n.GracefulRestart.LonglivedEnabled = true
+			for _, afiSafi := range n.AfiSafis {
+				afiSafi.LongLivedGracefulRestart = newLongLivedGracefulRestart(nrc.bgpLongLivedStaleTime)
+			}
+		}
+
+		n.AfiSafis = nrc.withAddPaths(n.AfiSafis, nodeIP.To4() == nil)
+
 		// we are rr-server peer with other rr-client with reflection enabled
 		if nrc.bgpRRServer {
 			if _, ok := node.ObjectMeta.Annotations[rrClientAnnotation]; ok {
@@ -158,12 +171,32 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 			}
 		}
 
+		if zones != nil {
+			if reflectorClusterID != "" {
+				n.RouteReflector = &gobgpapi.RouteReflector{
+					RouteReflectorClient:    true,
+					RouteReflectorClusterId: reflectorClusterID,
+				}
+			}
+			// the route reflector options of a peer can't be updated, so a peer whose role has changed since the
+			// election it was added after is added again
+			if previous, ok := nrc.ibgpZonePeers[nodeIP.String()]; ok && previous != reflectorClusterID {
+				nrc.logger.Info("The route reflector role of the node has changed, setting up the session again",
+					utils.LogKeyPeer, nodeIP.String())
+				if err := nrc.bgpServer.DeletePeer(context.Background(),
+					&gobgpapi.DeletePeerRequest{Address: nodeIP.String()}); err != nil {
+					nrc.logger.Error(err, "Failed to remove the node as peer", utils.LogKeyPeer, nodeIP.String())
+				}
+			}
+			nrc.ibgpZonePeers[nodeIP.String()] = reflectorClusterID
+		}
+
 		// TODO: check if a node is already added as neighbor in a better way than add and catch error
 		if err := nrc.bgpServer.AddPeer(context.Background(), &gobgpapi.AddPeerRequest{
 			Peer: n,
 		}); err != nil {
 			if !strings.Contains(err.Error(), "can't overwrite the existing peer") {
-				klog.Errorf("Failed to add node %s as peer due to %s", nodeIP.String(), err)
+				nrc.logger.Error(err, "Failed to add the node as peer", utils.LogKeyPeer, nodeIP.String())
 			}
 		}
 	}
@@ -186,9 +219,10 @@ func (nrc *NetworkRoutingController) syncInternalPeers() {
 	// delete the neighbor for the nodes that are removed
 	for _, ip := range removedNodes {
 		if err := nrc.bgpServer.DeletePeer(context.Background(), &gobgpapi.DeletePeerRequest{Address: ip}); err != nil {
-			klog.Errorf("Failed to remove node %s as peer due to %s", ip, err)
+			nrc.logger.Error(err, "Failed to remove the node as peer", utils.LogKeyPeer, ip)
 		}
 		delete(nrc.activeNodes, ip)
+		delete(nrc.ibgpZonePeers, ip)
 	}
 }
 
@@ -199,44 +233,54 @@ func (nrc *NetworkRoutingController) connectToExternalBGPPeers(server *gobgp.Bgp
 	for _, n := range peerNeighbors {
 
 		if bgpGracefulRestart {
+			restartTime := uint32(bgpGracefulRestartTime.Seconds())
+			// the restart time may already have been configured for the peer
+			if n.GracefulRestart != nil && n.GracefulRestart.RestartTime != 0 {
+				restartTime = n.GracefulRestart.RestartTime
+			}
 			n.GracefulRestart = &gobgpapi.GracefulRestart{
-				Enabled:         true,
-				RestartTime:     uint32(bgpGracefulRestartTime.Seconds()),
-				DeferralTime:    uint32(bgpGracefulRestartDeferralTime.Seconds()),
-				LocalRestarting: true,
+				Enabled:          true,
+				RestartTime:      restartTime,
+				DeferralTime:     uint32(bgpGracefulRestartDeferralTime.Seconds()),
+				LocalRestarting:  true,
+				LonglivedEnabled: nrc.bgpLongLivedGracefulRestart,
 			}
 
-			if nrc.isIpv6 {
-				n.AfiSafis = []*gobgpapi.AfiSafi{
-					{
-						Config: &gobgpapi.AfiSafiConfig{
-							Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST},
-							Enabled: true,
-						},
-						MpGracefulRestart: &gobgpapi.MpGracefulRestart{
-							Config: &gobgpapi.MpGracefulRestartConfig{
-								Enabled: true,
-							},
-						},
+			// the address family may already have been configured for the maximum prefixes or the long-lived
+			// stale time of the peer
+			if len(n.AfiSafis) == 0 {
+				family := unicastFamily(net.ParseIP(n.Conf.NeighborAddress).To4() == nil)
+				n.AfiSafis = []*gobgpapi.AfiSafi{{Config: &gobgpapi.AfiSafiConfig{Family: family, Enabled: true}}}
+			}
+			for _, afiSafi := range n.AfiSafis {
+				afiSafi.MpGracefulRestart = &gobgpapi.MpGracefulRestart{
+					Config: &gobgpapi.MpGracefulRestartConfig{
+						Enabled: true,
 					},
 				}
-			} else {
-				n.AfiSafis = []*gobgpapi.AfiSafi{
-					{
-						Config: &gobgpapi.AfiSafiConfig{
-							Family:  &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
-							Enabled: true,
-						},
-						MpGracefulRestart: &gobgpapi.MpGracefulRestart{
-							Config: &gobgpapi.MpGracefulRestartConfig{
-								Enabled: true,
-							},
-						},
-					},
+				if nrc.bgpLongLivedGracefulRestart {
+					staleTime := nrc.bgpLongLivedStaleTime
+					// the stale time may already have been configured for the peer
+					if afiSafi.LongLivedGracefulRestart != nil && afiSafi.LongLivedGracefulRestart.Config != nil &&
+						afiSafi.LongLivedGracefulRestart.Config.RestartTime != 0 {
+						staleTime = time.Duration(afiSafi.LongLivedGracefulRestart.Config.RestartTime) * time.Second
+					}
+					afiSafi.LongLivedGracefulRestart = newLongLivedGracefulRestart(staleTime)
 				}
 			}
 		}
-		if peerMultihopTTL > 1 {
+		// on dual-stack nodes the sessions with the peers of the other address family than the node IP are
+		// established from the address of that family
+		if n.Transport != nil {
+			localAddress, err := nrc.localAddressOfFamily(n)
+			if err != nil {
+				return err
+			}
+			n.Transport.LocalAddress = localAddress
+		}
+		n.AfiSafis = nrc.withAddPaths(n.AfiSafis, net.ParseIP(n.Conf.NeighborAddress).To4() == nil)
+		// the multihop TTL or GTSM may already have been configured for the peer
+		if peerMultihopTTL > 1 && n.EbgpMultihop == nil && n.TtlSecurity == nil {
 			n.EbgpMultihop = &gobgpapi.EbgpMultihop{
 				Enabled:     true,
 				MultihopTtl: uint32(peerMultihopTTL),
@@ -247,14 +291,26 @@ func (nrc *NetworkRoutingController) connectToExternalBGPPeers(server *gobgp.Bgp
 			return fmt.Errorf("error peering with peer router "+
 				"%q due to: %s", n.Conf.NeighborAddress, err)
 		}
-		klog.V(2).Infof("Successfully configured %s in ASN %v as BGP peer to the node",
-			n.Conf.NeighborAddress, n.Conf.PeerAsn)
+		nrc.logger.V(2).Info("Successfully configured BGP peer of the node", utils.LogKeyPeer, n.Conf.NeighborAddress,
+			"asn", n.Conf.PeerAsn)
 	}
 	return nil
 }
 
+// newLongLivedGracefulRestart returns the Long-Lived Graceful Restart configuration of an address family of a peer,
+// the peer keeps the routes of the family as stale routes for staleTime once the graceful restart time has expired
+func newLongLivedGracefulRestart(staleTime time.Duration) *gobgpapi.LongLivedGracefulRestart {
+	return &gobgpapi.LongLivedGracefulRestart{
+		Config: &gobgpapi.LongLivedGracefulRestartConfig{
+			Enabled:     true,
+			RestartTime: uint32(staleTime.Seconds()),
+		},
+	}
+}
+
 // Does validation and returns neighbor configs
 func newGlobalPeers(ips []net.IP, ports []uint32, asns []uint32, passwords []string, localips []string,
+	maxPrefixes []uint32, maxPrefixesWarningPct uint32, restartTimes []time.Duration, staleTimes []time.Duration,
 	holdtime float64, localAddress string) ([]*gobgpapi.Peer, error) {
 	peers := make([]*gobgpapi.Peer, 0)
 
@@ -281,6 +337,21 @@ func newGlobalPeers(ips []net.IP, ports []uint32, asns []uint32, passwords []str
 			"Example: \"10.1.1.1,,10.1.1.2\" OR [\"10.1.1.1\",\"\",\"10.1.1.2\"]", localAddress)
 	}
 
+	if len(ips) != len(maxPrefixes) && len(maxPrefixes) != 0 {
+		return nil, errors.New("invalid peer router config. The number of maximum prefixes should either be " +
+			"zero, or one per peer router. Use 0 for a peer router without limit. Example: \"1000,0,1000\"")
+	}
+
+	if len(ips) != len(restartTimes) && len(restartTimes) != 0 {
+		return nil, errors.New("invalid peer router config. The number of graceful restart times should either be " +
+			"zero, or one per peer router. Use 0 for the default restart time. Example: \"2m,0,2m\"")
+	}
+
+	if len(ips) != len(staleTimes) && len(staleTimes) != 0 {
+		return nil, errors.New("invalid peer router config. The number of long-lived stale times should either be " +
+			"zero, or one per peer router. Use 0 for the default stale time. Example: \"24h,0,24h\"")
+	}
+
 	for i := 0; i < len(ips); i++ {
 		if !((asns[i] >= 1 && asns[i] <= 23455) ||
 			(asns[i] >= 23457 && asns[i] <= 63999) ||
@@ -318,12 +389,103 @@ func newGlobalPeers(ips []net.IP, ports []uint32, asns []uint32, passwords []str
 			peer.Transport.LocalAddress = localips[i]
 		}
 
+		// GoBGP logs a warning once the peer advertises maxPrefixesWarningPct percent of its maximum prefixes, and
+		// tears the session down with a Cease notification when it advertises more than its maximum prefixes
+		if len(maxPrefixes) != 0 && maxPrefixes[i] != 0 {
+			family := &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST}
+			if ips[i].To4() == nil {
+				family = &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST}
+			}
+			peer.AfiSafis = []*gobgpapi.AfiSafi{
+				{
+					Config: &gobgpapi.AfiSafiConfig{Family: family, Enabled: true},
+					PrefixLimits: &gobgpapi.PrefixLimit{
+						Family:               family,
+						MaxPrefixes:          maxPrefixes[i],
+						ShutdownThresholdPct: maxPrefixesWarningPct,
+					},
+				},
+			}
+		}
+
+		if len(restartTimes) != 0 && restartTimes[i] != 0 {
+			if restartTimes[i] < 0 || restartTimes[i] > maxGracefulRestartTime {
+				return nil, fmt.Errorf("graceful restart time %s of peer %s is not between 0 and %s",
+					restartTimes[i], ips[i], maxGracefulRestartTime)
+			}
+			// the other graceful restart settings are filled in by connectToExternalBGPPeers
+			peer.GracefulRestart = &gobgpapi.GracefulRestart{RestartTime: uint32(restartTimes[i].Seconds())}
+		}
+
+		if len(staleTimes) != 0 && staleTimes[i] != 0 {
+			if staleTimes[i] < 0 || staleTimes[i] > maxLongLivedStaleTime {
+				return nil, fmt.Errorf("long-lived stale time %s of peer %s is not between 0 and %s",
+					staleTimes[i], ips[i], maxLongLivedStaleTime)
+			}
+			if len(peer.AfiSafis) == 0 {
+				family := &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST}
+				if ips[i].To4() == nil {
+					family = &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST}
+				}
+				peer.AfiSafis = []*gobgpapi.AfiSafi{{Config: &gobgpapi.AfiSafiConfig{Family: family, Enabled: true}}}
+			}
+			// the stale time is only advertised when Long-Lived Graceful Restart is enabled, by
+			// connectToExternalBGPPeers
+			peer.AfiSafis[0].LongLivedGracefulRestart = &gobgpapi.LongLivedGracefulRestart{
+				Config: &gobgpapi.LongLivedGracefulRestartConfig{RestartTime: uint32(staleTimes[i].Seconds())},
+			}
+		}
+
 		peers = append(peers, peer)
 	}
 
 	return peers, nil
 }
 
+// setPeerTTLs sets the eBGP multihop TTL and the GTSM hops of each peer, in the order of the peers. A multihop TTL of 0
+// leaves the peer with the TTL of --peer-router-multihop-ttl, GTSM hops of 0 leave it without GTSM.
+func setPeerTTLs(peers []*gobgpapi.Peer, multihopTTLs []uint32, ttlSecurityHops []uint32) error {
+	if len(multihopTTLs) != len(peers) && len(multihopTTLs) != 0 {
+		return errors.New("invalid peer router config. The number of multihop TTLs should either be zero, or " +
+			"one per peer router. Use 0 for a peer router that uses the TTL of --peer-router-multihop-ttl. " +
+			"Example: \"2,0,2\"")
+	}
+	if len(ttlSecurityHops) != len(peers) && len(ttlSecurityHops) != 0 {
+		return errors.New("invalid peer router config. The number of TTL security hops should either be zero, or " +
+			"one per peer router. Use 0 for a peer router without GTSM. Example: \"1,0,1\"")
+	}
+	for i, peer := range peers {
+		var ttl, hops uint32
+		if len(multihopTTLs) != 0 {
+			ttl = multihopTTLs[i]
+		}
+		if len(ttlSecurityHops) != 0 {
+			hops = ttlSecurityHops[i]
+		}
+		if ttl > maxTTL {
+			return fmt.Errorf("multihop TTL %d of peer %s is greater than %d", ttl, peer.Conf.NeighborAddress,
+				maxTTL)
+		}
+		if hops >= maxTTL {
+			return fmt.Errorf("TTL security hops %d of peer %s are not less than %d", hops,
+				peer.Conf.NeighborAddress, maxTTL)
+		}
+		if ttl > 1 && hops > 0 {
+			return fmt.Errorf("peer %s can't have both a multihop TTL and TTL security hops",
+				peer.Conf.NeighborAddress)
+		}
+		if ttl != 0 {
+			peer.EbgpMultihop = &gobgpapi.EbgpMultihop{Enabled: ttl > 1, MultihopTtl: ttl}
+		}
+		// with GTSM the packets are sent with the maximum TTL, and the ones of the peer are dropped when they arrive
+		// with a TTL lower than the maximum TTL less the hops to the peer
+		if hops > 0 {
+			peer.TtlSecurity = &gobgpapi.TtlSecurity{Enabled: true, TtlMin: maxTTL + 1 - hops}
+		}
+	}
+	return nil
+}
+
 func (nrc *NetworkRoutingController) newNodeEventHandler() cache.ResourceEventHandler {
 	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -339,7 +501,38 @@ func (nrc *NetworkRoutingController) newNodeEventHandler() cache.ResourceEventHa
 			nrc.OnNodeUpdate(obj)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
-			// we are only interested in node add/delete, so skip update
+			// apart from node add/delete we are only interested in nodes changing their IP
+			oldNode, ok := oldObj.(*v1core.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*v1core.Node)
+			if !ok {
+				return
+			}
+			nodeIP, changed := utils.NodeIPChanged(oldNode, newNode)
+			switch {
+			case changed && newNode.Name == nrc.nodeName:
+				klog.Infof("Received IP change of this node to %s from watch API", nodeIP)
+				nrc.requestNodeIPChange(nodeIP)
+			case newNode.Name == nrc.nodeName && nrc.gracefulWithdraw && cordonChanged(oldNode, newNode):
+				klog.Infof("Received cordon change of this node from watch API, so sync the service VIP advertisements")
+				nrc.syncVIPAdvertisements()
+			case changed:
+				klog.Infof("Received IP change of node %s to %s from watch API, so update peering", newNode.Name, nodeIP)
+				nrc.OnNodeUpdate(newObj)
+			case newNode.Name != nrc.nodeName && publishedNodeAddressesChanged(oldNode, newNode):
+				klog.Infof("Received BGP or overlay address change of node %s from watch API, so update peering",
+					newNode.Name)
+				nrc.OnNodeUpdate(newObj)
+			case newNode.Name != nrc.nodeName && nrc.wireguardPublicKey != "" &&
+				wireguardPublicKeyChanged(oldNode, newNode):
+				nrc.updateWireGuardPeer(newNode)
+			case nrc.ibgpZoneLabel != "" && zoneChanged(oldNode, newNode, nrc.ibgpZoneLabel):
+				klog.Infof("Received zone or readiness change of node %s from watch API, so elect the route "+
+					"reflectors of the zones again", newNode.Name)
+				nrc.OnNodeUpdate(newObj)
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
 			node, ok := obj.(*v1core.Node)
@@ -0,0 +1,172 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+)
+
+func Test_connectToExternalBGPPeersGracefulRestart(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	nrc := &NetworkRoutingController{bgpServer: bgpServer, bgpLongLivedGracefulRestart: true,
+		bgpLongLivedStaleTime: time.Hour}
+
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+	peers, err := newGlobalPeers(ips, nil, []uint32{65000, 65000}, nil, nil, []uint32{1000, 0}, 75,
+		[]time.Duration{2 * time.Minute, 0}, []time.Duration{0, 24 * time.Hour}, 90, "10.0.0.10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = nrc.connectToExternalBGPPeers(bgpServer, peers, true, 360*time.Second, 90*time.Second, 1)
+	if err != nil {
+		t.Fatalf("failed to peer with the peer routers: %v", err)
+	}
+
+	testcases := []struct {
+		address     string
+		restartTime uint32
+		staleTime   uint32
+	}{
+		{"10.0.0.1", 120, 3600},
+		{"10.0.0.2", 90, 86400},
+	}
+	for _, testcase := range testcases {
+		var peer *gobgpapi.Peer
+		err = bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{Address: testcase.address},
+			func(p *gobgpapi.Peer) { peer = p })
+		if err != nil || peer == nil {
+			t.Fatalf("failed to list peer %s: %v", testcase.address, err)
+		}
+		gr := peer.GracefulRestart
+		if !gr.Enabled || !gr.LonglivedEnabled || gr.RestartTime != testcase.restartTime {
+			t.Errorf("expected graceful restart with a restart time of %ds and long-lived graceful restart for "+
+				"peer %s, got: %v", testcase.restartTime, testcase.address, gr)
+		}
+		if len(peer.AfiSafis) != 1 || peer.AfiSafis[0].LongLivedGracefulRestart == nil {
+			t.Fatalf("expected long-lived graceful restart for the address family of peer %s, got: %v",
+				testcase.address, peer.AfiSafis)
+		}
+		llgr := peer.AfiSafis[0].LongLivedGracefulRestart.Config
+		if !llgr.Enabled || llgr.RestartTime != testcase.staleTime {
+			t.Errorf("expected a long-lived stale time of %ds for peer %s, got: %v", testcase.staleTime,
+				testcase.address, llgr)
+		}
+	}
+	if limit := peers[0].AfiSafis[0].PrefixLimits; limit == nil || limit.MaxPrefixes != 1000 {
+		t.Errorf("expected the prefix limit of the peer to be kept, got: %v", limit)
+	}
+}
+
+func Test_newGlobalPeersGracefulRestartTimes(t *testing.T) {
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+	asns := []uint32{65000, 65000}
+
+	_, err := newGlobalPeers(ips, nil, asns, nil, nil, nil, 75, []time.Duration{time.Minute}, nil, 90, "10.0.0.10")
+	if err == nil {
+		t.Error("expected an error when the number of restart times doesn't match the number of peers")
+	}
+	_, err = newGlobalPeers(ips, nil, asns, nil, nil, nil, 75, nil, []time.Duration{time.Hour}, 90, "10.0.0.10")
+	if err == nil {
+		t.Error("expected an error when the number of stale times doesn't match the number of peers")
+	}
+	_, err = newGlobalPeers(ips, nil, asns, nil, nil, nil, 75, []time.Duration{2 * time.Hour, 0}, nil, 90, "10.0.0.10")
+	if err == nil {
+		t.Error("expected an error for a restart time above 4095s")
+	}
+	_, err = newGlobalPeers(ips, nil, asns, nil, nil, nil, 75, nil, []time.Duration{0, 5000 * time.Hour}, 90,
+		"10.0.0.10")
+	if err == nil {
+		t.Error("expected an error for a stale time above 16777215s")
+	}
+}
+
+func Test_setPeerTTLs(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	nrc := &NetworkRoutingController{bgpServer: bgpServer}
+
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}
+	peers, err := newGlobalPeers(ips, nil, []uint32{65000, 65000, 65000}, nil, nil, nil, 75, nil, nil, 90,
+		"10.0.0.10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = setPeerTTLs(peers, []uint32{3, 0, 0}, []uint32{0, 1, 0}); err != nil {
+		t.Fatalf("failed to set the TTLs of the peers: %v", err)
+	}
+	err = nrc.connectToExternalBGPPeers(bgpServer, peers, false, 0, 0, 2)
+	if err != nil {
+		t.Fatalf("failed to peer with the peer routers: %v", err)
+	}
+
+	testcases := []struct {
+		address     string
+		multihopTTL uint32
+		ttlMin      uint32
+	}{
+		{"10.0.0.1", 3, 0},
+		{"10.0.0.2", 0, 255},
+		{"10.0.0.3", 2, 0},
+	}
+	for _, testcase := range testcases {
+		var peer *gobgpapi.Peer
+		err = bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{Address: testcase.address},
+			func(p *gobgpapi.Peer) { peer = p })
+		if err != nil || peer == nil {
+			t.Fatalf("failed to list peer %s: %v", testcase.address, err)
+		}
+		var multihopTTL, ttlMin uint32
+		if peer.EbgpMultihop != nil && peer.EbgpMultihop.Enabled {
+			multihopTTL = peer.EbgpMultihop.MultihopTtl
+		}
+		if peer.TtlSecurity != nil && peer.TtlSecurity.Enabled {
+			ttlMin = peer.TtlSecurity.TtlMin
+		}
+		if multihopTTL != testcase.multihopTTL || ttlMin != testcase.ttlMin {
+			t.Errorf("expected the multihop TTL %d and the GTSM minimum TTL %d for peer %s, got %d and %d",
+				testcase.multihopTTL, testcase.ttlMin, testcase.address, multihopTTL, ttlMin)
+		}
+	}
+
+	invalid := []struct {
+		multihopTTLs    []uint32
+		ttlSecurityHops []uint32
+	}{
+		{[]uint32{2}, nil},
+		{nil, []uint32{1}},
+		{[]uint32{2, 0, 0}, []uint32{1, 0, 0}},
+		{[]uint32{256, 0, 0}, nil},
+		{nil, []uint32{255, 0, 0}},
+	}
+	for _, testcase := range invalid {
+		if err = setPeerTTLs(peers, testcase.multihopTTLs, testcase.ttlSecurityHops); err == nil {
+			t.Errorf("expected an error for the multihop TTLs %v and the TTL security hops %v",
+				testcase.multihopTTLs, testcase.ttlSecurityHops)
+		}
+	}
+}
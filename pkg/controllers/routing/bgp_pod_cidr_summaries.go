@@ -0,0 +1,165 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+)
+
+const (
+	// the prefix sets of the summaries of the pod CIDRs and of the pod CIDRs they cover, and the neighbor set of the
+	// external peers the covered pod CIDRs are suppressed towards
+	podCIDRSummarySet         = "podcidrsummarydefinedset"
+	podCIDRSummarySuppressSet = "podcidrsummarysuppressset"
+	podCIDRSummaryPeerSet     = "podcidrsummarypeerset"
+)
+
+// parsePodCIDRSummaries parses the summary prefixes of the pod CIDRs given to the flag
+func parsePodCIDRSummaries(summaries []string) ([]*net.IPNet, error) {
+	prefixes := make([]*net.IPNet, 0, len(summaries))
+	for _, summary := range summaries {
+		_, prefix, err := net.ParseCIDR(strings.TrimSpace(summary))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod CIDR summary %q: %v", summary, err)
+		}
+		ones, bits := prefix.Mask.Size()
+		if ones == bits {
+			return nil, fmt.Errorf("invalid pod CIDR summary %q, a host prefix can't summarize pod CIDRs", summary)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parsePodCIDRSummaryPeers parses the addresses or prefixes of the external peers given to the flag into the
+// prefixes of a neighbor set
+func parsePodCIDRSummaryPeers(peers []string) ([]string, error) {
+	cidrs := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		peer = strings.TrimSpace(peer)
+		if ip := net.ParseIP(peer); ip != nil {
+			cidrs = append(cidrs, fmt.Sprintf("%s/%d", ip, hostPrefixLen(ip.To4() == nil)))
+			continue
+		}
+		_, prefix, err := net.ParseCIDR(peer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pod CIDR summary peer %q, expected an IP or a CIDR", peer)
+		}
+		cidrs = append(cidrs, prefix.String())
+	}
+	return cidrs, nil
+}
+
+// nodePodCIDRSummaries returns the summaries of the given address family that cover at least one of the advertisable
+// pod CIDRs of the node, the other summaries are left to the nodes whose pod CIDRs they cover
+func (nrc *NetworkRoutingController) nodePodCIDRSummaries(ipv6 bool) []*net.IPNet {
+	summaries := make([]*net.IPNet, 0)
+	for _, summary := range nrc.podCIDRSummaries {
+		if (summary.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		summaryLen, _ := summary.Mask.Size()
+		for _, podCIDR := range nrc.advertisablePodCIDRs() {
+			_, cidr, err := net.ParseCIDR(podCIDR)
+			if err != nil {
+				continue
+			}
+			if cidrLen, _ := cidr.Mask.Size(); cidrLen >= summaryLen && summary.Contains(cidr.IP) {
+				summaries = append(summaries, summary)
+				break
+			}
+		}
+	}
+	return summaries
+}
+
+// addPodCIDRSummaryDefinedSets adds the prefix sets of the summaries of the given address family and of the more
+// specific prefixes they cover, and the neighbor set of the peers given to --advertise-pod-cidr-summary-peers
+func (nrc *NetworkRoutingController) addPodCIDRSummaryDefinedSets(ipv6 bool) error {
+	summaries := make([]*gobgpapi.Prefix, 0)
+	moreSpecifics := make([]*gobgpapi.Prefix, 0)
+	for _, summary := range nrc.nodePodCIDRSummaries(ipv6) {
+		summaryLen, _ := summary.Mask.Size()
+		summaries = append(summaries, &gobgpapi.Prefix{IpPrefix: summary.String(),
+			MaskLengthMin: uint32(summaryLen), MaskLengthMax: uint32(summaryLen)})
+		moreSpecifics = append(moreSpecifics, &gobgpapi.Prefix{IpPrefix: summary.String(),
+			MaskLengthMin: uint32(summaryLen) + 1, MaskLengthMax: hostPrefixLen(ipv6)})
+	}
+	definedSets := []*gobgpapi.DefinedSet{
+		{DefinedType: gobgpapi.DefinedType_PREFIX, Name: nrc.prefixSetName(podCIDRSummarySet, ipv6),
+			Prefixes: summaries},
+		{DefinedType: gobgpapi.DefinedType_PREFIX, Name: nrc.prefixSetName(podCIDRSummarySuppressSet, ipv6),
+			Prefixes: moreSpecifics},
+	}
+	if len(nrc.podCIDRSummaryPeers) > 0 {
+		definedSets = append(definedSets, &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+			Name: podCIDRSummaryPeerSet, List: nrc.podCIDRSummaryPeers})
+	}
+	// the summaries only change with the pod CIDRs of the node, which don't change while kube-router runs
+	for _, definedSet := range definedSets {
+		exists := false
+		err := nrc.bgpServer.ListDefinedSet(context.Background(),
+			&gobgpapi.ListDefinedSetRequest{DefinedType: definedSet.DefinedType, Name: definedSet.Name},
+			func(ds *gobgpapi.DefinedSet) {
+				exists = true
+			})
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{DefinedSet: definedSet})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podCIDRSummaryStatements returns the statements of the export policy that advertise the summaries to the external
+// peers with the given actions, and that suppress the pod CIDRs they cover towards the peers given to
+// --advertise-pod-cidr-summary-peers or, without them, towards all the external peers. They have to come before the
+// statement advertising the pod CIDRs to the external peers.
+func (nrc *NetworkRoutingController) podCIDRSummaryStatements(actions *gobgpapi.Actions) []*gobgpapi.Statement {
+	if len(nrc.podCIDRSummaries) == 0 {
+		return nil
+	}
+	peerSet := "externalpeerset"
+	if len(nrc.podCIDRSummaryPeers) > 0 {
+		peerSet = podCIDRSummaryPeerSet
+	}
+	return []*gobgpapi.Statement{
+		{
+			Conditions: &gobgpapi.Conditions{
+				PrefixSet:   &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: podCIDRSummarySuppressSet},
+				NeighborSet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: peerSet},
+			},
+			Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_REJECT},
+		},
+		{
+			Conditions: &gobgpapi.Conditions{
+				PrefixSet:   &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: podCIDRSummarySet},
+				NeighborSet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: "externalpeerset"},
+			},
+			Actions: actions,
+		},
+	}
+}
+
+// advertisePodCIDRSummaries advertises the summaries covering the pod CIDRs of the node like its pod CIDRs, the
+// traffic the node attracts for the pod CIDRs of the other nodes is routed to them through the routes learned from
+// them
+func (nrc *NetworkRoutingController) advertisePodCIDRSummaries() error {
+	for _, ipv6 := range nrc.addressFamilies() {
+		for _, summary := range nrc.nodePodCIDRSummaries(ipv6) {
+			if err := nrc.advertisePodCIDR(summary.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+)
+
+func Test_parsePodCIDRSummaries(t *testing.T) {
+	summaries, err := parsePodCIDRSummaries([]string{"10.244.0.0/16", " 2001:db8:42::/56"})
+	if err != nil {
+		t.Fatalf("failed to parse the summaries: %v", err)
+	}
+	if summaries[0].String() != "10.244.0.0/16" || summaries[1].String() != "2001:db8:42::/56" {
+		t.Errorf("expected the summaries to be parsed as is, got %v", summaries)
+	}
+	for _, invalid := range []string{"10.244.0.0", "10.244.0.1/32", "2001:db8::1/128"} {
+		if _, err = parsePodCIDRSummaries([]string{invalid}); err == nil {
+			t.Errorf("expected an error for the summary %s", invalid)
+		}
+	}
+
+	peers, err := parsePodCIDRSummaryPeers([]string{"192.168.0.1", "192.168.1.0/24", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("failed to parse the summary peers: %v", err)
+	}
+	if expected := []string{"192.168.0.1/32", "192.168.1.0/24", "2001:db8::1/128"}; !reflect.DeepEqual(peers,
+		expected) {
+		t.Errorf("expected the summary peers %v, got %v", expected, peers)
+	}
+	if _, err = parsePodCIDRSummaryPeers([]string{"router-1"}); err == nil {
+		t.Error("expected an error for a summary peer that is neither an IP nor a CIDR")
+	}
+}
+
+func Test_podCIDRSummaries(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+
+	summaries, err := parsePodCIDRSummaries([]string{"10.244.0.0/16", "10.245.0.0/16"})
+	if err != nil {
+		t.Fatalf("failed to parse the summaries: %v", err)
+	}
+	nrc := &NetworkRoutingController{
+		bgpServer:        bgpServer,
+		nodeIP:           net.ParseIP("10.0.0.10"),
+		overlayIP:        net.ParseIP("10.0.0.10"),
+		podCidr:          "10.244.1.0/24",
+		podCIDRSummaries: summaries,
+	}
+
+	if got := nrc.nodePodCIDRSummaries(false); len(got) != 1 || got[0].String() != "10.244.0.0/16" {
+		t.Errorf("expected only the summary covering the pod CIDR of the node, got %v", got)
+	}
+
+	if err = nrc.addPodCIDRSummaryDefinedSets(false); err != nil {
+		t.Fatalf("failed to add the summary defined sets: %v", err)
+	}
+	expectedSets := map[string]*gobgpapi.Prefix{
+		podCIDRSummarySet:         {IpPrefix: "10.244.0.0/16", MaskLengthMin: 16, MaskLengthMax: 16},
+		podCIDRSummarySuppressSet: {IpPrefix: "10.244.0.0/16", MaskLengthMin: 17, MaskLengthMax: 32},
+	}
+	for name, expected := range expectedSets {
+		var prefixes []*gobgpapi.Prefix
+		err = bgpServer.ListDefinedSet(context.Background(), &gobgpapi.ListDefinedSetRequest{
+			DefinedType: gobgpapi.DefinedType_PREFIX, Name: name}, func(ds *gobgpapi.DefinedSet) {
+			prefixes = ds.Prefixes
+		})
+		if err != nil {
+			t.Fatalf("failed to list the defined set %s: %v", name, err)
+		}
+		if len(prefixes) != 1 || prefixes[0].IpPrefix != expected.IpPrefix ||
+			prefixes[0].MaskLengthMin != expected.MaskLengthMin || prefixes[0].MaskLengthMax != expected.MaskLengthMax {
+			t.Errorf("expected %v in the defined set %s, got %v", expected, name, prefixes)
+		}
+	}
+
+	if err = nrc.advertisePodRoute(); err != nil {
+		t.Fatalf("failed to advertise the pod CIDR and its summary: %v", err)
+	}
+	var prefixes []string
+	err = bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+		TableType: gobgpapi.TableType_GLOBAL, Family: unicastFamily(false)}, func(d *gobgpapi.Destination) {
+		prefixes = append(prefixes, d.Prefix)
+	})
+	if err != nil {
+		t.Fatalf("failed to list the paths: %v", err)
+	}
+	sort.Strings(prefixes)
+	if expected := []string{"10.244.0.0/16", "10.244.1.0/24"}; !reflect.DeepEqual(prefixes, expected) {
+		t.Errorf("expected the paths %v, got %v", expected, prefixes)
+	}
+
+	statements := nrc.podCIDRSummaryStatements(&gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT})
+	if len(statements) != 2 || statements[0].Actions.RouteAction != gobgpapi.RouteAction_REJECT ||
+		statements[0].Conditions.NeighborSet.Name != "externalpeerset" {
+		t.Errorf("expected the covered pod CIDRs to be suppressed towards all the external peers, got %v", statements)
+	}
+	nrc.podCIDRSummaryPeers = []string{"192.168.0.0/24"}
+	statements = nrc.podCIDRSummaryStatements(&gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT})
+	if statements[0].Conditions.NeighborSet.Name != podCIDRSummaryPeerSet ||
+		statements[1].Conditions.NeighborSet.Name != "externalpeerset" {
+		t.Errorf("expected the covered pod CIDRs to be suppressed towards the summary peers only, got %v", statements)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"reflect"
 	"sort"
 	"strconv"
@@ -12,8 +13,6 @@ import (
 	gobgpapi "github.com/osrg/gobgp/v3/api"
 	v1core "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
-
-	"github.com/cloudnativelabs/kube-router/pkg/utils"
 )
 
 // AddPolicies adds BGP import and export policies
@@ -23,24 +22,35 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		return nil
 	}
 
-	err := nrc.addPodCidrDefinedSet()
-	if err != nil {
-		klog.Errorf("Failed to add `podcidrdefinedset` defined set: %s", err)
-	}
+	// a prefix set only holds the prefixes of one address family, dual-stack nodes have a set of each family
+	for _, ipv6 := range nrc.addressFamilies() {
+		err := nrc.addPodCidrDefinedSet(ipv6)
+		if err != nil {
+			klog.Errorf("Failed to add `%s` defined set: %s", nrc.prefixSetName("podcidrdefinedset", ipv6), err)
+		}
 
-	err = nrc.addServiceVIPsDefinedSet()
-	if err != nil {
-		klog.Errorf("Failed to add `servicevipsdefinedset` defined set: %s", err)
-	}
+		err = nrc.addServiceVIPsDefinedSet(ipv6)
+		if err != nil {
+			klog.Errorf("Failed to add `%s` defined set: %s", nrc.prefixSetName("servicevipsdefinedset", ipv6), err)
+		}
 
-	err = nrc.addDefaultRouteDefinedSet()
-	if err != nil {
-		klog.Errorf("Failed to add `defaultroutedefinedset` defined set: %s", err)
-	}
+		err = nrc.addDefaultRouteDefinedSet(ipv6)
+		if err != nil {
+			klog.Errorf("Failed to add `%s` defined set: %s", nrc.prefixSetName("defaultroutedefinedset", ipv6), err)
+		}
 
-	err = nrc.addCustomImportRejectDefinedSet()
-	if err != nil {
-		klog.Errorf("Failed to add `customimportrejectdefinedset` defined set: %s", err)
+		err = nrc.addCustomImportRejectDefinedSet(ipv6)
+		if err != nil {
+			klog.Errorf("Failed to add `%s` defined set: %s",
+				nrc.prefixSetName("customimportrejectdefinedset", ipv6), err)
+		}
+
+		if len(nrc.podCIDRSummaries) > 0 {
+			err = nrc.addPodCIDRSummaryDefinedSets(ipv6)
+			if err != nil {
+				klog.Errorf("Failed to add the pod CIDR summary defined sets: %s", err)
+			}
+		}
 	}
 
 	iBGPPeerCIDRs, err := nrc.addiBGPPeersDefinedSet()
@@ -58,6 +68,13 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		klog.Errorf("Failed to add `allpeerset` defined set: %s", err)
 	}
 
+	if len(nrc.rpkiCaches) > 0 {
+		err = nrc.addRPKIPeersDefinedSets(externalBGPPeerCIDRs)
+		if err != nil {
+			klog.Errorf("Failed to add the RPKI defined sets: %s", err)
+		}
+	}
+
 	err = nrc.addExportPolicies()
 	if err != nil {
 		return err
@@ -68,14 +85,22 @@ func (nrc *NetworkRoutingController) AddPolicies() error {
 		return err
 	}
 
+	if nrc.bgpPolicyLister != nil {
+		err = nrc.syncBGPPolicyResources()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// create a defined set to represent just the pod CIDR associated with the node
-func (nrc *NetworkRoutingController) addPodCidrDefinedSet() error {
+// create a defined set to represent just the pod CIDRs of the address family associated with the node
+func (nrc *NetworkRoutingController) addPodCidrDefinedSet(ipv6 bool) error {
+	name := nrc.prefixSetName("podcidrdefinedset", ipv6)
 	var currentDefinedSet *gobgpapi.DefinedSet
 	err := nrc.bgpServer.ListDefinedSet(context.Background(),
-		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: "podcidrdefinedset"},
+		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: name},
 		func(ds *gobgpapi.DefinedSet) {
 			currentDefinedSet = ds
 		})
@@ -83,20 +108,25 @@ func (nrc *NetworkRoutingController) addPodCidrDefinedSet() error {
 		return err
 	}
 	if currentDefinedSet == nil {
-		cidrLen, err := strconv.Atoi(strings.Split(nrc.podCidr, "/")[1])
-		if err != nil || cidrLen < 0 || cidrLen > 32 {
-			return fmt.Errorf("the pod CIDR IP given is not a proper mask: %d", cidrLen)
+		prefixes := make([]*gobgpapi.Prefix, 0)
+		for _, podCIDR := range nrc.advertisablePodCIDRs() {
+			if ip, _, err := net.ParseCIDR(podCIDR); err != nil || (ip.To4() == nil) != ipv6 {
+				continue
+			}
+			cidrLen, err := strconv.Atoi(strings.Split(podCIDR, "/")[1])
+			if err != nil || cidrLen < 0 || cidrLen > 128 {
+				return fmt.Errorf("the pod CIDR IP given is not a proper mask: %d", cidrLen)
+			}
+			prefixes = append(prefixes, &gobgpapi.Prefix{
+				IpPrefix:      podCIDR,
+				MaskLengthMin: uint32(cidrLen),
+				MaskLengthMax: uint32(cidrLen),
+			})
 		}
 		podCidrDefinedSet := &gobgpapi.DefinedSet{
 			DefinedType: gobgpapi.DefinedType_PREFIX,
-			Name:        "podcidrdefinedset",
-			Prefixes: []*gobgpapi.Prefix{
-				{
-					IpPrefix:      nrc.podCidr,
-					MaskLengthMin: uint32(cidrLen),
-					MaskLengthMax: uint32(cidrLen),
-				},
-			},
+			Name:        name,
+			Prefixes:    prefixes,
 		}
 		return nrc.bgpServer.AddDefinedSet(context.Background(),
 			&gobgpapi.AddDefinedSetRequest{DefinedSet: podCidrDefinedSet})
@@ -104,11 +134,12 @@ func (nrc *NetworkRoutingController) addPodCidrDefinedSet() error {
 	return nil
 }
 
-// create a defined set to represent all the advertisable IP associated with the services
-func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet() error {
+// create a defined set to represent all the advertisable IP of the address family associated with the services
+func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet(ipv6 bool) error {
+	name := nrc.prefixSetName("servicevipsdefinedset", ipv6)
 	var currentDefinedSet *gobgpapi.DefinedSet
 	err := nrc.bgpServer.ListDefinedSet(context.Background(),
-		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: "servicevipsdefinedset"},
+		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: name},
 		func(ds *gobgpapi.DefinedSet) {
 			currentDefinedSet = ds
 		})
@@ -117,14 +148,20 @@ func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet() error {
 	}
 	advIPPrefixList := make([]*gobgpapi.Prefix, 0)
 	advIps, _, _ := nrc.getAllVIPs()
+	// the egress addresses the node holds are exported along with the service VIPs
+	advIps = append(advIps, nrc.heldEgressIPs()...)
 	for _, ip := range advIps {
-		advIPPrefixList = append(advIPPrefixList,
-			&gobgpapi.Prefix{IpPrefix: ip + "/32", MaskLengthMin: 32, MaskLengthMax: 32})
+		if parsed := net.ParseIP(ip); parsed == nil || (parsed.To4() == nil) != ipv6 {
+			continue
+		}
+		prefixLen := hostPrefixLen(ipv6)
+		advIPPrefixList = append(advIPPrefixList, &gobgpapi.Prefix{IpPrefix: fmt.Sprintf("%s/%d", ip, prefixLen),
+			MaskLengthMin: prefixLen, MaskLengthMax: prefixLen})
 	}
 	if currentDefinedSet == nil {
 		clusterIPPrefixSet := &gobgpapi.DefinedSet{
 			DefinedType: gobgpapi.DefinedType_PREFIX,
-			Name:        "servicevipsdefinedset",
+			Name:        name,
 			Prefixes:    advIPPrefixList,
 		}
 		return nrc.bgpServer.AddDefinedSet(context.Background(),
@@ -167,7 +204,7 @@ func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet() error {
 	}
 	clusterIPPrefixSet := &gobgpapi.DefinedSet{
 		DefinedType: gobgpapi.DefinedType_PREFIX,
-		Name:        "servicevipsdefinedset",
+		Name:        name,
 		Prefixes:    toAdd,
 	}
 	err = nrc.bgpServer.AddDefinedSet(context.Background(),
@@ -177,7 +214,7 @@ func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet() error {
 	}
 	clusterIPPrefixSet = &gobgpapi.DefinedSet{
 		DefinedType: gobgpapi.DefinedType_PREFIX,
-		Name:        "servicevipsdefinedset",
+		Name:        name,
 		Prefixes:    toDelete,
 	}
 	err = nrc.bgpServer.DeleteDefinedSet(context.Background(),
@@ -189,11 +226,12 @@ func (nrc *NetworkRoutingController) addServiceVIPsDefinedSet() error {
 	return nil
 }
 
-// create a defined set to represent just the host default route
-func (nrc *NetworkRoutingController) addDefaultRouteDefinedSet() error {
+// create a defined set to represent just the host default route of the address family
+func (nrc *NetworkRoutingController) addDefaultRouteDefinedSet(ipv6 bool) error {
+	name := nrc.prefixSetName("defaultroutedefinedset", ipv6)
 	var currentDefinedSet *gobgpapi.DefinedSet
 	err := nrc.bgpServer.ListDefinedSet(context.Background(),
-		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: "defaultroutedefinedset"},
+		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: name},
 		func(ds *gobgpapi.DefinedSet) {
 			currentDefinedSet = ds
 		})
@@ -202,12 +240,16 @@ func (nrc *NetworkRoutingController) addDefaultRouteDefinedSet() error {
 	}
 	if currentDefinedSet == nil {
 		cidrLen := 0
+		defaultRoute := "0.0.0.0/0"
+		if ipv6 {
+			defaultRoute = "::/0"
+		}
 		defaultRouteDefinedSet := &gobgpapi.DefinedSet{
 			DefinedType: gobgpapi.DefinedType_PREFIX,
-			Name:        "defaultroutedefinedset",
+			Name:        name,
 			Prefixes: []*gobgpapi.Prefix{
 				{
-					IpPrefix:      "0.0.0.0/0",
+					IpPrefix:      defaultRoute,
 					MaskLengthMin: uint32(cidrLen),
 					MaskLengthMax: uint32(cidrLen),
 				},
@@ -219,11 +261,12 @@ func (nrc *NetworkRoutingController) addDefaultRouteDefinedSet() error {
 	return nil
 }
 
-// create a defined set to represent custom annotated routes to be rejected on import
-func (nrc *NetworkRoutingController) addCustomImportRejectDefinedSet() error {
+// create a defined set to represent custom annotated routes of the address family to be rejected on import
+func (nrc *NetworkRoutingController) addCustomImportRejectDefinedSet(ipv6 bool) error {
+	name := nrc.prefixSetName("customimportrejectdefinedset", ipv6)
 	var currentDefinedSet *gobgpapi.DefinedSet
 	err := nrc.bgpServer.ListDefinedSet(context.Background(),
-		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: "customimportrejectdefinedset"},
+		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_PREFIX, Name: name},
 		func(ds *gobgpapi.DefinedSet) {
 			currentDefinedSet = ds
 		})
@@ -233,16 +276,19 @@ func (nrc *NetworkRoutingController) addCustomImportRejectDefinedSet() error {
 	if currentDefinedSet == nil {
 		prefixes := make([]*gobgpapi.Prefix, 0)
 		for _, ipNet := range nrc.nodeCustomImportRejectIPNets {
+			if (ipNet.IP.To4() == nil) != ipv6 {
+				continue
+			}
 			prefix := new(gobgpapi.Prefix)
 			prefix.IpPrefix = ipNet.String()
-			mask, _ := ipNet.Mask.Size()
+			mask, bits := ipNet.Mask.Size()
 			prefix.MaskLengthMin = uint32(mask)
-			prefix.MaskLengthMax = uint32(ipv4MaskMinBits)
+			prefix.MaskLengthMax = uint32(bits)
 			prefixes = append(prefixes, prefix)
 		}
 		customImportRejectDefinedSet := &gobgpapi.DefinedSet{
 			DefinedType: gobgpapi.DefinedType_PREFIX,
-			Name:        "customimportrejectdefinedset",
+			Name:        name,
 			Prefixes:    prefixes,
 		}
 		return nrc.bgpServer.AddDefinedSet(context.Background(),
@@ -261,7 +307,7 @@ func (nrc *NetworkRoutingController) addiBGPPeersDefinedSet() ([]string, error)
 	nodes := nrc.nodeLister.List()
 	for _, node := range nodes {
 		nodeObj := node.(*v1core.Node)
-		nodeIP, err := utils.GetNodeIP(nodeObj)
+		nodeIP, err := nodeBGPAddress(nodeObj)
 		if err != nil {
 			klog.Errorf("Failed to find a node IP and therefore cannot add internal BGP Peer: %v", err)
 			continue
@@ -353,19 +399,39 @@ func (nrc *NetworkRoutingController) addExternalBGPPeersDefinedSet() ([]string,
 	if err != nil {
 		return externalBGPPeerCIDRs, err
 	}
-	if len(nrc.globalPeerRouters) > 0 {
-		for _, peer := range nrc.globalPeerRouters {
+	if len(nrc.externalPeers()) > 0 {
+		for _, peer := range nrc.externalPeers() {
 			externalBgpPeers = append(externalBgpPeers, peer.Conf.NeighborAddress)
 		}
 	}
 	if len(nrc.nodePeerRouters) > 0 {
 		externalBgpPeers = append(externalBgpPeers, nrc.nodePeerRouters...)
 	}
-	if len(externalBgpPeers) == 0 {
+	// the unnumbered peers are matched by the link-local address resolved for them once they have been added
+	unnumberedPeerLinks, err := nrc.unnumberedPeerLinks()
+	if err != nil {
+		return externalBGPPeerCIDRs, err
+	}
+	unnumberedPeers := make([]string, 0, len(unnumberedPeerLinks))
+	for address := range unnumberedPeerLinks {
+		unnumberedPeers = append(unnumberedPeers, address)
+	}
+	sort.Strings(unnumberedPeers)
+	externalBgpPeers = append(externalBgpPeers, unnumberedPeers...)
+	// the peers of the BGPPeer resources come and go and the BGPPolicies and the import of the external default routes
+	// match the routes of the external peers, so with either of them the set has to exist even while there are no
+	// external peers
+	if len(externalBgpPeers) == 0 && len(nrc.dynamicNeighborRanges) == 0 && nrc.bgpPeerLister == nil &&
+		nrc.bgpPolicyLister == nil && !nrc.importsExternalDefaultRoute() {
 		return externalBGPPeerCIDRs, nil
 	}
 	for _, peer := range externalBgpPeers {
-		externalBGPPeerCIDRs = append(externalBGPPeerCIDRs, peer+"/32")
+		externalBGPPeerCIDRs = append(externalBGPPeerCIDRs,
+			fmt.Sprintf("%s/%d", peer, hostPrefixLen(net.ParseIP(peer).To4() == nil)))
+	}
+	// the dynamic neighbors are matched by the listen range they are accepted from
+	for _, r := range nrc.dynamicNeighborRanges {
+		externalBGPPeerCIDRs = append(externalBGPPeerCIDRs, r.prefix.String())
 	}
 	if currentDefinedSet == nil {
 		eBGPPeerNS := &gobgpapi.DefinedSet{
@@ -377,6 +443,48 @@ func (nrc *NetworkRoutingController) addExternalBGPPeersDefinedSet() ([]string,
 		return externalBGPPeerCIDRs, err
 	}
 
+	toAdd := make([]string, 0)
+	toDelete := make([]string, 0)
+	current := make(map[string]bool)
+	for _, currentPeer := range currentDefinedSet.List {
+		current[currentPeer] = true
+	}
+	desired := make(map[string]bool)
+	for _, peer := range externalBGPPeerCIDRs {
+		desired[peer] = true
+		if !current[peer] {
+			toAdd = append(toAdd, peer)
+		}
+	}
+	for _, currentPeer := range currentDefinedSet.List {
+		if !desired[currentPeer] {
+			toDelete = append(toDelete, currentPeer)
+		}
+	}
+	if len(toAdd) > 0 {
+		eBGPPeerNS := &gobgpapi.DefinedSet{
+			DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+			Name:        "externalpeerset",
+			List:        toAdd,
+		}
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{DefinedSet: eBGPPeerNS})
+		if err != nil {
+			return externalBGPPeerCIDRs, err
+		}
+	}
+	if len(toDelete) > 0 {
+		eBGPPeerNS := &gobgpapi.DefinedSet{
+			DefinedType: gobgpapi.DefinedType_NEIGHBOR,
+			Name:        "externalpeerset",
+			List:        toDelete,
+		}
+		err = nrc.bgpServer.DeleteDefinedSet(context.Background(),
+			&gobgpapi.DeleteDefinedSetRequest{DefinedSet: eBGPPeerNS, All: false})
+		if err != nil {
+			return externalBGPPeerCIDRs, err
+		}
+	}
+
 	return externalBGPPeerCIDRs, nil
 }
 
@@ -503,9 +611,27 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 				},
 				Actions: &actions,
 			})
+		// in a partitioned mesh the route reflectors reflect the routes learned from the other nodes, which the
+		// route reflector clients never advertise to begin with
+		if nrc.ibgpZoneLabel != "" {
+			statements = append(statements, &gobgpapi.Statement{
+				Conditions: &gobgpapi.Conditions{
+					NeighborSet: &gobgpapi.MatchSet{
+						Type: gobgpapi.MatchSet_ANY,
+						Name: "iBGPpeerset",
+					},
+					RouteType: gobgpapi.Conditions_ROUTE_TYPE_INTERNAL,
+				},
+				Actions: &gobgpapi.Actions{
+					RouteAction: gobgpapi.RouteAction_ACCEPT,
+				},
+			})
+		}
 	}
 
-	if len(nrc.globalPeerRouters) > 0 || len(nrc.nodePeerRouters) > 0 {
+	// the policy is added once, so with BGPPeer resources the statements of the external peers are needed up front
+	if len(nrc.externalPeers()) > 0 || len(nrc.nodePeerRouters) > 0 || len(nrc.unnumberedPeerRouters) > 0 ||
+		len(nrc.dynamicNeighborRanges) > 0 || nrc.bgpPeerLister != nil {
 
 		bgpActions.RouteAction = gobgpapi.RouteAction_ACCEPT
 		if nrc.overrideNextHop {
@@ -550,6 +676,7 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 			if nrc.overrideNextHop {
 				actions.Nexthop = &gobgpapi.NexthopAction{Self: true}
 			}
+			statements = append(statements, nrc.podCIDRSummaryStatements(&actions)...)
 			statements = append(statements, &gobgpapi.Statement{
 				Conditions: &gobgpapi.Conditions{
 					PrefixSet: &gobgpapi.MatchSet{
@@ -568,7 +695,7 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 
 	definition := gobgpapi.Policy{
 		Name:       "kube_router_export",
-		Statements: statements,
+		Statements: nrc.withDualStackStatements(statements),
 	}
 
 	policyAlreadyExists := false
@@ -624,6 +751,8 @@ func (nrc *NetworkRoutingController) addExportPolicies() error {
 // BGP import policies are added so that the following conditions are met:
 //   - do not import Service VIPs advertised from any peers, instead each kube-router originates and injects
 //     Service VIPs into local rib.
+//   - do not import default routes, except the ones of the external peers with --import-external-routes covering them
+//   - with RPKI caches, reject or depreference the routes of the external peers the caches find invalid
 func (nrc *NetworkRoutingController) addImportPolicies() error {
 	statements := make([]*gobgpapi.Statement, 0)
 
@@ -644,16 +773,24 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 		Actions: &actions,
 	})
 
+	// the default routes of the external peers are imported with --import-external-routes covering them
+	defaultRouteNeighborSet := &gobgpapi.MatchSet{
+		Type: gobgpapi.MatchSet_ANY,
+		Name: "allpeerset",
+	}
+	if nrc.importsExternalDefaultRoute() {
+		defaultRouteNeighborSet = &gobgpapi.MatchSet{
+			Type: gobgpapi.MatchSet_INVERT,
+			Name: "externalpeerset",
+		}
+	}
 	statements = append(statements, &gobgpapi.Statement{
 		Conditions: &gobgpapi.Conditions{
 			PrefixSet: &gobgpapi.MatchSet{
 				Type: gobgpapi.MatchSet_ANY,
 				Name: "defaultroutedefinedset",
 			},
-			NeighborSet: &gobgpapi.MatchSet{
-				Type: gobgpapi.MatchSet_ANY,
-				Name: "allpeerset",
-			},
+			NeighborSet: defaultRouteNeighborSet,
 		},
 		Actions: &actions,
 	})
@@ -674,9 +811,13 @@ func (nrc *NetworkRoutingController) addImportPolicies() error {
 		})
 	}
 
+	if len(nrc.rpkiCaches) > 0 {
+		statements = append(statements, rpkiImportStatements()...)
+	}
+
 	definition := gobgpapi.Policy{
 		Name:       "kube_router_import",
-		Statements: statements,
+		Statements: nrc.withDualStackStatements(statements),
 	}
 
 	policyAlreadyExists := false
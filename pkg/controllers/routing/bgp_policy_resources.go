@@ -0,0 +1,356 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	bgpPolicyImportPolicyName = "kube_router_bgppolicy_import"
+	bgpPolicyExportPolicyName = "kube_router_bgppolicy_export"
+)
+
+// bgpPolicyResourcePolicies are the defined sets and the statements of the import and export policies that the
+// BGPPolicy resources make up
+type bgpPolicyResourcePolicies struct {
+	definedSets      []*gobgpapi.DefinedSet
+	importStatements []*gobgpapi.Statement
+	exportStatements []*gobgpapi.Statement
+}
+
+// EnableBGPPolicyResources makes the controller filter and modify the routes of the external peers by the BGPPolicy
+// custom resources. The informer watches the BGPPolicies, BGPPolicyEventHandler is to be added to it.
+func (nrc *NetworkRoutingController) EnableBGPPolicyResources(bgpPolicyInformer cache.SharedIndexInformer) {
+	nrc.bgpPolicyLister = bgpPolicyInformer.GetIndexer()
+	if nrc.bgpResourceSyncChan == nil {
+		nrc.bgpResourceSyncChan = make(chan struct{}, 1)
+	}
+	nrc.BGPPolicyEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { nrc.requestBGPResourceSync() },
+		UpdateFunc: func(interface{}, interface{}) { nrc.requestBGPResourceSync() },
+		DeleteFunc: func(interface{}) { nrc.requestBGPResourceSync() },
+	}
+}
+
+// bgpPolicyResourcesPolicies returns the policies of the BGPPolicy resources, with the statements of the resources in
+// the order of the resources and of their rules. Invalid resources are left out and logged.
+func bgpPolicyResourcesPolicies(objs []interface{}) *bgpPolicyResourcePolicies {
+	bgpPolicies := make([]*v1alpha1.BGPPolicy, 0, len(objs))
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		bgpPolicy := &v1alpha1.BGPPolicy{}
+		if err := v1alpha1.FromUnstructured(u, bgpPolicy); err != nil {
+			klog.Errorf("Ignoring BGPPolicy %s that could not be parsed: %v", u.GetName(), err)
+			continue
+		}
+		bgpPolicies = append(bgpPolicies, bgpPolicy)
+	}
+	sort.Slice(bgpPolicies, func(i, j int) bool {
+		if bgpPolicies[i].Spec.Order != bgpPolicies[j].Spec.Order {
+			return bgpPolicies[i].Spec.Order < bgpPolicies[j].Spec.Order
+		}
+		return bgpPolicies[i].Name < bgpPolicies[j].Name
+	})
+
+	policies := &bgpPolicyResourcePolicies{}
+	for _, bgpPolicy := range bgpPolicies {
+		policy, err := bgpPolicyResourcePolicy(bgpPolicy)
+		if err != nil {
+			klog.Errorf("Ignoring invalid BGPPolicy %s: %v", bgpPolicy.Name, err)
+			continue
+		}
+		policies.definedSets = append(policies.definedSets, policy.definedSets...)
+		policies.importStatements = append(policies.importStatements, policy.importStatements...)
+		policies.exportStatements = append(policies.exportStatements, policy.exportStatements...)
+	}
+	return policies
+}
+
+// bgpPolicyResourcePolicy returns the defined sets and the statements of a BGPPolicy resource
+func bgpPolicyResourcePolicy(bgpPolicy *v1alpha1.BGPPolicy) (*bgpPolicyResourcePolicies, error) {
+	policy := &bgpPolicyResourcePolicies{}
+	neighborSet := "externalpeerset"
+	if len(bgpPolicy.Spec.Peers) > 0 {
+		neighborSet = "bgppolicy-" + bgpPolicy.Name + "-peers"
+		peers := make([]string, 0, len(bgpPolicy.Spec.Peers))
+		for _, peer := range bgpPolicy.Spec.Peers {
+			ip := net.ParseIP(peer)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid peer IP %q", peer)
+			}
+			if ip.To4() != nil {
+				peers = append(peers, ip.String()+"/32")
+			} else {
+				peers = append(peers, ip.String()+"/128")
+			}
+		}
+		policy.definedSets = append(policy.definedSets,
+			&gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: neighborSet, List: peers})
+	}
+
+	for i, rule := range bgpPolicy.Spec.Import {
+		name := fmt.Sprintf("bgppolicy-%s-import-%d", bgpPolicy.Name, i)
+		definedSets, statements, err := bgpPolicyRuleStatements(name, neighborSet, &rule)
+		if err != nil {
+			return nil, fmt.Errorf("import rule %d: %v", i, err)
+		}
+		policy.definedSets = append(policy.definedSets, definedSets...)
+		policy.importStatements = append(policy.importStatements, statements...)
+	}
+	for i, rule := range bgpPolicy.Spec.Export {
+		name := fmt.Sprintf("bgppolicy-%s-export-%d", bgpPolicy.Name, i)
+		definedSets, statements, err := bgpPolicyRuleStatements(name, neighborSet, &rule)
+		if err != nil {
+			return nil, fmt.Errorf("export rule %d: %v", i, err)
+		}
+		policy.definedSets = append(policy.definedSets, definedSets...)
+		policy.exportStatements = append(policy.exportStatements, statements...)
+	}
+	return policy, nil
+}
+
+// bgpPolicyRuleStatements returns the defined sets and the statements of a rule of a BGPPolicy, the statements are
+// named after the given name as are the defined sets. As a prefix set only holds the prefixes of one address family,
+// a rule with IPv4 and IPv6 prefixes makes up a statement for each family.
+func bgpPolicyRuleStatements(name, neighborSet string,
+	rule *v1alpha1.BGPPolicyRule) ([]*gobgpapi.DefinedSet, []*gobgpapi.Statement, error) {
+	actions, err := bgpPolicyRuleActions(rule)
+	if err != nil {
+		return nil, nil, err
+	}
+	definedSets := make([]*gobgpapi.DefinedSet, 0)
+	if len(rule.Communities) > 0 {
+		for _, community := range rule.Communities {
+			if err = validateCommunity(community); err != nil {
+				return nil, nil, err
+			}
+		}
+		definedSets = append(definedSets, &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_COMMUNITY,
+			Name: name + "-communities", List: rule.Communities})
+	}
+	conditions := func() *gobgpapi.Conditions {
+		c := &gobgpapi.Conditions{NeighborSet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: neighborSet}}
+		if len(rule.Communities) > 0 {
+			c.CommunitySet = &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: name + "-communities"}
+		}
+		return c
+	}
+	if len(rule.Prefixes) == 0 {
+		return definedSets, []*gobgpapi.Statement{{Name: name, Conditions: conditions(), Actions: actions}}, nil
+	}
+
+	var ipv4Prefixes, ipv6Prefixes []*gobgpapi.Prefix
+	for _, rulePrefix := range rule.Prefixes {
+		_, ipNet, err := net.ParseCIDR(rulePrefix.CIDR)
+		if err != nil {
+			return nil, nil, err
+		}
+		length, bits := ipNet.Mask.Size()
+		minLength, maxLength := int32(length), rulePrefix.MaxLength
+		if rulePrefix.MinLength != 0 {
+			minLength = rulePrefix.MinLength
+		}
+		if maxLength == 0 {
+			maxLength = minLength
+		}
+		if minLength < int32(length) || maxLength < minLength || maxLength > int32(bits) {
+			return nil, nil, fmt.Errorf("invalid prefix lengths %d to %d of %s", minLength, maxLength, ipNet)
+		}
+		prefix := &gobgpapi.Prefix{IpPrefix: ipNet.String(), MaskLengthMin: uint32(minLength),
+			MaskLengthMax: uint32(maxLength)}
+		if ipNet.IP.To4() != nil {
+			ipv4Prefixes = append(ipv4Prefixes, prefix)
+		} else {
+			ipv6Prefixes = append(ipv6Prefixes, prefix)
+		}
+	}
+	statements := make([]*gobgpapi.Statement, 0)
+	for _, family := range []struct {
+		suffix   string
+		prefixes []*gobgpapi.Prefix
+	}{{"v4", ipv4Prefixes}, {"v6", ipv6Prefixes}} {
+		if len(family.prefixes) == 0 {
+			continue
+		}
+		prefixSet := name + "-" + family.suffix
+		definedSets = append(definedSets, &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_PREFIX,
+			Name: prefixSet, Prefixes: family.prefixes})
+		familyConditions := conditions()
+		familyConditions.PrefixSet = &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: prefixSet}
+		statements = append(statements,
+			&gobgpapi.Statement{Name: prefixSet, Conditions: familyConditions, Actions: actions})
+	}
+	return definedSets, statements, nil
+}
+
+// bgpPolicyRuleActions returns the actions of a rule of a BGPPolicy
+func bgpPolicyRuleActions(rule *v1alpha1.BGPPolicyRule) (*gobgpapi.Actions, error) {
+	actions := &gobgpapi.Actions{}
+	switch rule.Action {
+	case v1alpha1.BGPPolicyActionAccept:
+		actions.RouteAction = gobgpapi.RouteAction_ACCEPT
+	case v1alpha1.BGPPolicyActionReject:
+		if rule.Set != nil {
+			return nil, errors.New("the routes a rule rejects can not be modified")
+		}
+		actions.RouteAction = gobgpapi.RouteAction_REJECT
+		return actions, nil
+	case "":
+		actions.RouteAction = gobgpapi.RouteAction_NONE
+	default:
+		return nil, fmt.Errorf("unknown action %q", rule.Action)
+	}
+	if rule.Set == nil {
+		return actions, nil
+	}
+
+	if rule.Set.LocalPref != nil {
+		actions.LocalPref = &gobgpapi.LocalPrefAction{Value: *rule.Set.LocalPref}
+	}
+	if rule.Set.MED != nil {
+		actions.Med = &gobgpapi.MedAction{Type: gobgpapi.MedAction_REPLACE, Value: int64(*rule.Set.MED)}
+	}
+	if rule.Set.ASPathPrepend != nil {
+		repeat := rule.Set.ASPathPrepend.Repeat
+		if repeat == 0 {
+			repeat = 1
+		}
+		actions.AsPrepend = &gobgpapi.AsPrependAction{Asn: rule.Set.ASPathPrepend.ASN, Repeat: uint32(repeat)}
+	}
+	if len(rule.Set.Communities) > 0 {
+		for _, community := range rule.Set.Communities {
+			if err := validateCommunity(community); err != nil {
+				return nil, err
+			}
+		}
+		actions.Community = &gobgpapi.CommunityAction{Type: gobgpapi.CommunityAction_ADD,
+			Communities: rule.Set.Communities}
+	}
+	return actions, nil
+}
+
+// syncBGPPolicyResources applies the policies of the BGPPolicy resources, the import policy after kube-router's own
+// import policy so that the routes kube-router rejects stay rejected, and the export policy before kube-router's own
+// export policy so that it can filter the routes kube-router advertises. The policies are only set up again when the
+// resources changed, after which the routes of the peers are evaluated again by soft resets.
+func (nrc *NetworkRoutingController) syncBGPPolicyResources() error {
+	policies := bgpPolicyResourcesPolicies(nrc.bgpPolicyLister.List())
+	applied := nrc.bgpPolicyResourcesApplied
+	if applied == nil {
+		applied = &bgpPolicyResourcePolicies{}
+	}
+	if reflect.DeepEqual(policies, applied) {
+		nrc.bgpPolicyResourcesApplied = applied
+		return nil
+	}
+
+	nrc.removeBGPPolicyResources(applied)
+	nrc.bgpPolicyResourcesApplied = &bgpPolicyResourcePolicies{}
+	if err := nrc.addBGPPolicyResources(policies); err != nil {
+		nrc.removeBGPPolicyResources(policies)
+		return fmt.Errorf("failed to apply the BGPPolicies: %v", err)
+	}
+	nrc.bgpPolicyResourcesApplied = policies
+	klog.Infof("Applied the BGPPolicies with %d import and %d export statements", len(policies.importStatements),
+		len(policies.exportStatements))
+
+	err := nrc.bgpServer.ResetPeer(context.Background(),
+		&gobgpapi.ResetPeerRequest{Address: "all", Soft: true, Direction: gobgpapi.ResetPeerRequest_BOTH})
+	if err != nil {
+		return fmt.Errorf("failed to evaluate the routes of the peers again against the BGPPolicies: %v", err)
+	}
+	return nil
+}
+
+// addBGPPolicyResources adds the defined sets and the policies of the BGPPolicy resources and assigns the policies
+func (nrc *NetworkRoutingController) addBGPPolicyResources(policies *bgpPolicyResourcePolicies) error {
+	for _, definedSet := range policies.definedSets {
+		err := nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{DefinedSet: definedSet})
+		if err != nil {
+			return fmt.Errorf("failed to add defined set %s: %v", definedSet.Name, err)
+		}
+	}
+	for name, statements := range map[string][]*gobgpapi.Statement{
+		bgpPolicyImportPolicyName: policies.importStatements,
+		bgpPolicyExportPolicyName: policies.exportStatements,
+	} {
+		if len(statements) == 0 {
+			continue
+		}
+		err := nrc.bgpServer.AddPolicy(context.Background(),
+			&gobgpapi.AddPolicyRequest{Policy: &gobgpapi.Policy{Name: name, Statements: statements}})
+		if err != nil {
+			return fmt.Errorf("failed to add policy %s: %v", name, err)
+		}
+	}
+	return nrc.assignBGPPolicyResources(policies)
+}
+
+// removeBGPPolicyResources unassigns and removes the policies and the defined sets of the BGPPolicy resources, errors
+// are logged so that as much as possible is removed
+func (nrc *NetworkRoutingController) removeBGPPolicyResources(policies *bgpPolicyResourcePolicies) {
+	if err := nrc.assignBGPPolicyResources(&bgpPolicyResourcePolicies{}); err != nil {
+		klog.Errorf("Failed to unassign the policies of the BGPPolicies: %v", err)
+	}
+	for name, statements := range map[string][]*gobgpapi.Statement{
+		bgpPolicyImportPolicyName: policies.importStatements,
+		bgpPolicyExportPolicyName: policies.exportStatements,
+	} {
+		if len(statements) == 0 {
+			continue
+		}
+		err := nrc.bgpServer.DeletePolicy(context.Background(),
+			&gobgpapi.DeletePolicyRequest{Policy: &gobgpapi.Policy{Name: name}, All: true})
+		if err != nil {
+			klog.Errorf("Failed to remove policy %s: %v", name, err)
+		}
+	}
+	for _, definedSet := range policies.definedSets {
+		err := nrc.bgpServer.DeleteDefinedSet(context.Background(), &gobgpapi.DeleteDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: definedSet.DefinedType, Name: definedSet.Name}, All: true})
+		if err != nil {
+			klog.Errorf("Failed to remove defined set %s: %v", definedSet.Name, err)
+		}
+	}
+}
+
+// assignBGPPolicyResources sets the global import and export policies to kube-router's own policies and the ones of
+// the BGPPolicy resources that have statements
+func (nrc *NetworkRoutingController) assignBGPPolicyResources(policies *bgpPolicyResourcePolicies) error {
+	importPolicies := []*gobgpapi.Policy{{Name: "kube_router_import"}}
+	if len(policies.importStatements) > 0 {
+		importPolicies = append(importPolicies, &gobgpapi.Policy{Name: bgpPolicyImportPolicyName})
+	}
+	exportPolicies := make([]*gobgpapi.Policy, 0)
+	if len(policies.exportStatements) > 0 {
+		exportPolicies = append(exportPolicies, &gobgpapi.Policy{Name: bgpPolicyExportPolicyName})
+	}
+	exportPolicies = append(exportPolicies, &gobgpapi.Policy{Name: "kube_router_export"})
+
+	err := nrc.bgpServer.SetPolicyAssignment(context.Background(), &gobgpapi.SetPolicyAssignmentRequest{
+		Assignment: &gobgpapi.PolicyAssignment{Name: "global", Direction: gobgpapi.PolicyDirection_IMPORT,
+			Policies: importPolicies, DefaultAction: gobgpapi.RouteAction_ACCEPT}})
+	if err != nil {
+		return fmt.Errorf("failed to assign the import policies: %v", err)
+	}
+	err = nrc.bgpServer.SetPolicyAssignment(context.Background(), &gobgpapi.SetPolicyAssignmentRequest{
+		Assignment: &gobgpapi.PolicyAssignment{Name: "global", Direction: gobgpapi.PolicyDirection_EXPORT,
+			Policies: exportPolicies, DefaultAction: gobgpapi.RouteAction_REJECT}})
+	if err != nil {
+		return fmt.Errorf("failed to assign the export policies: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package routing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func bgpPolicyObjects(t *testing.T, bgpPolicies ...*v1alpha1.BGPPolicy) []interface{} {
+	objs := make([]interface{}, 0, len(bgpPolicies))
+	for _, bgpPolicy := range bgpPolicies {
+		obj, err := v1alpha1.ToUnstructured(bgpPolicy)
+		if err != nil {
+			t.Fatalf("failed to convert BGPPolicy: %v", err)
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+func Test_bgpPolicyResourcesPolicies(t *testing.T) {
+	localPref := uint32(200)
+	objs := bgpPolicyObjects(t,
+		&v1alpha1.BGPPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "tor"},
+			Spec: v1alpha1.BGPPolicySpec{
+				Order: 10,
+				Peers: []string{"192.168.0.1", "2001:db8::1"},
+				Import: []v1alpha1.BGPPolicyRule{{
+					Prefixes: []v1alpha1.BGPPolicyPrefix{{CIDR: "10.0.0.0/8", MaxLength: 24},
+						{CIDR: "2001:db8:1::/48"}},
+					Action: v1alpha1.BGPPolicyActionAccept,
+					Set:    &v1alpha1.BGPPolicySet{LocalPref: &localPref},
+				}},
+			},
+		},
+		&v1alpha1.BGPPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "no-export"},
+			Spec: v1alpha1.BGPPolicySpec{
+				Export: []v1alpha1.BGPPolicyRule{
+					{Communities: []string{"65000:666"}, Action: v1alpha1.BGPPolicyActionReject},
+					{Set: &v1alpha1.BGPPolicySet{ASPathPrepend: &v1alpha1.BGPPolicyASPathPrepend{ASN: 65001}}},
+				},
+			},
+		},
+		&v1alpha1.BGPPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+			Spec: v1alpha1.BGPPolicySpec{
+				Export: []v1alpha1.BGPPolicyRule{
+					{Prefixes: []v1alpha1.BGPPolicyPrefix{{CIDR: "10.0.0.0/16", MinLength: 8}}},
+				},
+			},
+		},
+	)
+
+	peersSet := &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: "bgppolicy-tor-peers"}
+	externalPeerSet := &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: "externalpeerset"}
+	expected := &bgpPolicyResourcePolicies{
+		definedSets: []*gobgpapi.DefinedSet{
+			{DefinedType: gobgpapi.DefinedType_COMMUNITY, Name: "bgppolicy-no-export-export-0-communities",
+				List: []string{"65000:666"}},
+			{DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: "bgppolicy-tor-peers",
+				List: []string{"192.168.0.1/32", "2001:db8::1/128"}},
+			{DefinedType: gobgpapi.DefinedType_PREFIX, Name: "bgppolicy-tor-import-0-v4",
+				Prefixes: []*gobgpapi.Prefix{{IpPrefix: "10.0.0.0/8", MaskLengthMin: 8, MaskLengthMax: 24}}},
+			{DefinedType: gobgpapi.DefinedType_PREFIX, Name: "bgppolicy-tor-import-0-v6",
+				Prefixes: []*gobgpapi.Prefix{{IpPrefix: "2001:db8:1::/48", MaskLengthMin: 48, MaskLengthMax: 48}}},
+		},
+		importStatements: []*gobgpapi.Statement{
+			{
+				Name: "bgppolicy-tor-import-0-v4",
+				Conditions: &gobgpapi.Conditions{NeighborSet: peersSet,
+					PrefixSet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: "bgppolicy-tor-import-0-v4"}},
+				Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT,
+					LocalPref: &gobgpapi.LocalPrefAction{Value: 200}},
+			},
+			{
+				Name: "bgppolicy-tor-import-0-v6",
+				Conditions: &gobgpapi.Conditions{NeighborSet: peersSet,
+					PrefixSet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: "bgppolicy-tor-import-0-v6"}},
+				Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT,
+					LocalPref: &gobgpapi.LocalPrefAction{Value: 200}},
+			},
+		},
+		exportStatements: []*gobgpapi.Statement{
+			{
+				Name: "bgppolicy-no-export-export-0",
+				Conditions: &gobgpapi.Conditions{NeighborSet: externalPeerSet,
+					CommunitySet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY,
+						Name: "bgppolicy-no-export-export-0-communities"}},
+				Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_REJECT},
+			},
+			{
+				Name:       "bgppolicy-no-export-export-1",
+				Conditions: &gobgpapi.Conditions{NeighborSet: externalPeerSet},
+				Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_NONE,
+					AsPrepend: &gobgpapi.AsPrependAction{Asn: 65001, Repeat: 1}},
+			},
+		},
+	}
+
+	if policies := bgpPolicyResourcesPolicies(objs); !reflect.DeepEqual(policies, expected) {
+		t.Errorf("expected %+v, got %+v", expected, policies)
+	}
+}
+
+func Test_bgpPolicyRuleActions(t *testing.T) {
+	testcases := []struct {
+		name    string
+		rule    v1alpha1.BGPPolicyRule
+		wantErr bool
+	}{
+		{"unknown action", v1alpha1.BGPPolicyRule{Action: "Drop"}, true},
+		{"modified rejected routes", v1alpha1.BGPPolicyRule{Action: v1alpha1.BGPPolicyActionReject,
+			Set: &v1alpha1.BGPPolicySet{Communities: []string{"65000:1"}}}, true},
+		{"invalid community", v1alpha1.BGPPolicyRule{
+			Set: &v1alpha1.BGPPolicySet{Communities: []string{"not-a-community"}}}, true},
+		{"modified accepted routes", v1alpha1.BGPPolicyRule{Action: v1alpha1.BGPPolicyActionAccept,
+			Set: &v1alpha1.BGPPolicySet{Communities: []string{"65000:1"}}}, false},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if _, err := bgpPolicyRuleActions(&testcase.rule); (err != nil) != testcase.wantErr {
+				t.Errorf("expected an error: %t, got %v", testcase.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_syncBGPPolicyResources(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	// stand-ins for the policies and the defined set of AddPolicies the BGPPolicies are applied next to
+	err = bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{DefinedSet: &gobgpapi.DefinedSet{
+		DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: "externalpeerset"}})
+	if err != nil {
+		t.Fatalf("failed to add the external peer set: %v", err)
+	}
+	for _, name := range []string{"kube_router_import", "kube_router_export"} {
+		err = bgpServer.AddPolicy(context.Background(), &gobgpapi.AddPolicyRequest{Policy: &gobgpapi.Policy{Name: name}})
+		if err != nil {
+			t.Fatalf("failed to add policy %s: %v", name, err)
+		}
+	}
+
+	bgpPolicyLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nrc := &NetworkRoutingController{bgpServer: bgpServer, bgpPolicyLister: bgpPolicyLister}
+	assignedPolicies := func(direction gobgpapi.PolicyDirection) []string {
+		var names []string
+		err := bgpServer.ListPolicyAssignment(context.Background(),
+			&gobgpapi.ListPolicyAssignmentRequest{Name: "global", Direction: direction},
+			func(assignment *gobgpapi.PolicyAssignment) {
+				for _, policy := range assignment.Policies {
+					names = append(names, policy.Name)
+				}
+			})
+		if err != nil {
+			t.Fatalf("failed to list policy assignments: %v", err)
+		}
+		return names
+	}
+	definedSetExists := func(definedType gobgpapi.DefinedType, name string) bool {
+		exists := false
+		err := bgpServer.ListDefinedSet(context.Background(),
+			&gobgpapi.ListDefinedSetRequest{DefinedType: definedType, Name: name},
+			func(*gobgpapi.DefinedSet) { exists = true })
+		if err != nil {
+			t.Fatalf("failed to list defined sets: %v", err)
+		}
+		return exists
+	}
+
+	bgpPolicy := &v1alpha1.BGPPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "filter"},
+		Spec: v1alpha1.BGPPolicySpec{
+			Import: []v1alpha1.BGPPolicyRule{{Prefixes: []v1alpha1.BGPPolicyPrefix{{CIDR: "10.0.0.0/8", MaxLength: 32}},
+				Action: v1alpha1.BGPPolicyActionReject}},
+			Export: []v1alpha1.BGPPolicyRule{{Communities: []string{"65000:666"},
+				Action: v1alpha1.BGPPolicyActionReject}},
+		},
+	}
+	for _, obj := range bgpPolicyObjects(t, bgpPolicy) {
+		if err = bgpPolicyLister.Add(obj); err != nil {
+			t.Fatalf("failed to add BGPPolicy to the lister: %v", err)
+		}
+	}
+	if err = nrc.syncBGPPolicyResources(); err != nil {
+		t.Fatalf("failed to sync the BGPPolicies: %v", err)
+	}
+	if names := assignedPolicies(gobgpapi.PolicyDirection_IMPORT); !reflect.DeepEqual(names,
+		[]string{"kube_router_import", bgpPolicyImportPolicyName}) {
+		t.Errorf("expected the import policy of the BGPPolicies after kube-router's own, got %v", names)
+	}
+	if names := assignedPolicies(gobgpapi.PolicyDirection_EXPORT); !reflect.DeepEqual(names,
+		[]string{bgpPolicyExportPolicyName, "kube_router_export"}) {
+		t.Errorf("expected the export policy of the BGPPolicies before kube-router's own, got %v", names)
+	}
+
+	// a sync without changes leaves the policies alone
+	if err = nrc.syncBGPPolicyResources(); err != nil {
+		t.Fatalf("failed to sync the unchanged BGPPolicies: %v", err)
+	}
+
+	bgpPolicy.Spec.Export = nil
+	for _, obj := range bgpPolicyObjects(t, bgpPolicy) {
+		if err = bgpPolicyLister.Update(obj); err != nil {
+			t.Fatalf("failed to update BGPPolicy in the lister: %v", err)
+		}
+	}
+	if err = nrc.syncBGPPolicyResources(); err != nil {
+		t.Fatalf("failed to sync the changed BGPPolicies: %v", err)
+	}
+	if names := assignedPolicies(gobgpapi.PolicyDirection_EXPORT); !reflect.DeepEqual(names,
+		[]string{"kube_router_export"}) {
+		t.Errorf("expected the export policy of the BGPPolicies to be unassigned, got %v", names)
+	}
+	if definedSetExists(gobgpapi.DefinedType_COMMUNITY, "bgppolicy-filter-export-0-communities") {
+		t.Error("expected the community set of the removed rule to be removed")
+	}
+	if !definedSetExists(gobgpapi.DefinedType_PREFIX, "bgppolicy-filter-import-0-v4") {
+		t.Error("expected the prefix set of the remaining rule to be kept")
+	}
+
+	for _, obj := range bgpPolicyObjects(t, bgpPolicy) {
+		if err = bgpPolicyLister.Delete(obj); err != nil {
+			t.Fatalf("failed to delete BGPPolicy from the lister: %v", err)
+		}
+	}
+	if err = nrc.syncBGPPolicyResources(); err != nil {
+		t.Fatalf("failed to sync the removed BGPPolicies: %v", err)
+	}
+	if names := assignedPolicies(gobgpapi.PolicyDirection_IMPORT); !reflect.DeepEqual(names,
+		[]string{"kube_router_import"}) {
+		t.Errorf("expected only kube-router's own import policy, got %v", names)
+	}
+	if definedSetExists(gobgpapi.DefinedType_PREFIX, "bgppolicy-filter-import-0-v4") {
+		t.Error("expected the prefix set of the removed BGPPolicy to be removed")
+	}
+}
@@ -0,0 +1,61 @@
+package routing
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgplog "github.com/osrg/gobgp/v3/pkg/log"
+)
+
+// prefixLimitReachedMsg is the message GoBGP logs both when a peer reaches the warning threshold of its maximum
+// prefixes, with the threshold in the Pct field, and when it exceeds its maximum prefixes and the session is torn down
+const prefixLimitReachedMsg = "prefix limit reached"
+
+// prefixLimitLogger is the logger of GoBGP, which is the only place where GoBGP reports that it tore down the session
+// with a peer because the peer exceeded its maximum prefixes. onTeardown is called with the address of the peer.
+type prefixLimitLogger struct {
+	gobgplog.Logger
+	onTeardown func(peer string)
+}
+
+func newPrefixLimitLogger(onTeardown func(peer string)) *prefixLimitLogger {
+	return &prefixLimitLogger{Logger: gobgplog.NewDefaultLogger(), onTeardown: onTeardown}
+}
+
+func (l *prefixLimitLogger) Warn(msg string, fields gobgplog.Fields) {
+	l.Logger.Warn(msg, fields)
+	if msg != prefixLimitReachedMsg {
+		return
+	}
+	if _, warning := fields["Pct"]; warning {
+		return
+	}
+	if peer, ok := fields["Key"].(string); ok {
+		// the logger is called from the main loop of GoBGP, which has to be left before the peer can be disabled
+		go l.onTeardown(peer)
+	}
+}
+
+// holdDownPeer keeps the session with a peer which exceeded its maximum prefixes down for the restart time, instead
+// of letting GoBGP retry it right away only for the peer to flood it again
+func (nrc *NetworkRoutingController) holdDownPeer(peer string) {
+	nrc.logger.Info("BGP peer exceeded its maximum prefixes, keeping the session down", utils.LogKeyPeer, peer,
+		"restartTime", nrc.peerMaxPrefixesRestartTime.String())
+	err := nrc.bgpServer.DisablePeer(context.Background(), &gobgpapi.DisablePeerRequest{
+		Address:       peer,
+		Communication: "Maximum number of prefixes reached",
+	})
+	if err != nil {
+		nrc.logger.Error(err, "Failed to disable BGP peer", utils.LogKeyPeer, peer)
+		return
+	}
+	time.AfterFunc(nrc.peerMaxPrefixesRestartTime, func() {
+		nrc.logger.Info("Restart time of BGP peer expired, enabling the session", utils.LogKeyPeer, peer)
+		err := nrc.bgpServer.EnablePeer(context.Background(), &gobgpapi.EnablePeerRequest{Address: peer})
+		if err != nil {
+			nrc.logger.Error(err, "Failed to enable BGP peer", utils.LogKeyPeer, peer)
+		}
+	})
+}
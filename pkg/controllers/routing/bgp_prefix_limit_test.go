@@ -0,0 +1,64 @@
+package routing
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgplog "github.com/osrg/gobgp/v3/pkg/log"
+)
+
+func Test_prefixLimitLogger(t *testing.T) {
+	tornDown := make(chan string, 1)
+	logger := newPrefixLimitLogger(func(peer string) { tornDown <- peer })
+
+	logger.Warn(prefixLimitReachedMsg, gobgplog.Fields{"Topic": "Peer", "Key": "10.0.0.1", "Pct": 75})
+	logger.Warn("some other warning", gobgplog.Fields{"Topic": "Peer", "Key": "10.0.0.2"})
+	logger.Warn(prefixLimitReachedMsg, gobgplog.Fields{"Topic": "Peer", "Key": "10.0.0.3"})
+
+	select {
+	case peer := <-tornDown:
+		if peer != "10.0.0.3" {
+			t.Errorf("expected the teardown of 10.0.0.3, got: %s", peer)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the teardown of 10.0.0.3 to be reported")
+	}
+	select {
+	case peer := <-tornDown:
+		t.Errorf("expected a single teardown, got another one of: %s", peer)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_newGlobalPeersMaxPrefixes(t *testing.T) {
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("2001:db8::1")}
+	asns := []uint32{65000, 65000, 65000}
+
+	peers, err := newGlobalPeers(ips, nil, asns, nil, nil, []uint32{1000, 0, 500}, 75, nil, nil, 90, "10.0.0.10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(peers[1].AfiSafis) != 0 {
+		t.Errorf("expected no prefix limit for a peer with 0 maximum prefixes, got: %v", peers[1].AfiSafis)
+	}
+	for i, expected := range map[int]uint32{0: 1000, 2: 500} {
+		if len(peers[i].AfiSafis) != 1 || peers[i].AfiSafis[0].PrefixLimits == nil {
+			t.Fatalf("expected a prefix limit for peer %s, got: %v", ips[i], peers[i].AfiSafis)
+		}
+		limit := peers[i].AfiSafis[0].PrefixLimits
+		if limit.MaxPrefixes != expected || limit.ShutdownThresholdPct != 75 {
+			t.Errorf("expected a limit of %d prefixes with a warning at 75%% for peer %s, got: %v", expected,
+				ips[i], limit)
+		}
+	}
+	if afi := peers[2].AfiSafis[0].PrefixLimits.Family.Afi; afi != gobgpapi.Family_AFI_IP6 {
+		t.Errorf("expected the prefix limit of an IPv6 peer to apply to IPv6, got: %s", afi)
+	}
+
+	_, err = newGlobalPeers(ips, nil, asns, nil, nil, []uint32{1000}, 75, nil, nil, 90, "10.0.0.10")
+	if err == nil {
+		t.Errorf("expected an error when the number of maximum prefixes doesn't match the number of peers")
+	}
+}
@@ -0,0 +1,174 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"k8s.io/klog/v2"
+)
+
+// parseImportExternalRoutes parses the CIDRs of the routes learned from the external peers given to the flag
+func parseImportExternalRoutes(cidrs []string) ([]*net.IPNet, error) {
+	prefixes := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, prefix, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q of the external routes to import: %v", cidr, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// importsExternalDefaultRoute tells whether the default routes of the external peers are imported, which kube-router
+// otherwise rejects from all of its peers
+func (nrc *NetworkRoutingController) importsExternalDefaultRoute() bool {
+	for _, prefix := range nrc.importExternalRoutes {
+		if ones, _ := prefix.Mask.Size(); ones == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// importsExternalRoute tells whether the route to the destination is installed when it is learned from an external
+// peer, which it is when one of the CIDRs of --import-external-routes covers it
+func (nrc *NetworkRoutingController) importsExternalRoute(dst *net.IPNet) bool {
+	dstOnes, dstBits := dst.Mask.Size()
+	for _, prefix := range nrc.importExternalRoutes {
+		ones, bits := prefix.Mask.Size()
+		if bits == dstBits && ones <= dstOnes && prefix.Contains(dst.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// injectExternalRoutes installs the routes learned from the external peers that are imported into the routing table
+// of --import-external-routes-table, and returns the other paths of the table event. As the routes of the other nodes,
+// they are routed across all of the next hops of the equally good paths to the destination with ECMP, and through the
+// next hop of the best path otherwise.
+func (nrc *NetworkRoutingController) injectExternalRoutes(paths []*gobgpapi.Path) []*gobgpapi.Path {
+	imported := make([]*gobgpapi.Path, 0)
+	others := make([]*gobgpapi.Path, 0, len(paths))
+	for _, path := range paths {
+		if nrc.isImportedExternalPath(path) {
+			imported = append(imported, path)
+		} else {
+			others = append(others, path)
+		}
+	}
+
+	for _, dstPaths := range groupPathsByDestination(imported) {
+		if nrc.MetricsEnabled {
+			metrics.ControllerBGPadvertisementsReceived.Add(float64(len(dstPaths)))
+		}
+		dst, _, err := parseBGPPath(dstPaths[0])
+		if err != nil {
+			klog.Errorf("Failed to parse BGP path: %v", err)
+			continue
+		}
+		nextHops := make([]net.IP, 0, len(dstPaths))
+		for _, path := range dstPaths {
+			if path.IsWithdraw {
+				continue
+			}
+			_, nextHop, err := parseBGPPath(path)
+			if err != nil {
+				klog.Errorf("Failed to parse BGP path: %v", err)
+				continue
+			}
+			nextHops = append(nextHops, nextHop)
+			if !nrc.peerECMP {
+				break
+			}
+		}
+
+		if len(nextHops) == 0 {
+			klog.V(2).Infof("Removing external route: '%s' from routing table %d", dst, nrc.importExternalRoutesTable)
+			nrc.routeSyncer.delInjectedRoute(dst)
+			if err = deleteTableRoutesByDestination(dst, nrc.importExternalRoutesTable); err != nil {
+				klog.Errorf("Failed to remove external route to %s: %v", dst, err)
+			}
+			continue
+		}
+		route, err := nrc.externalRoute(dst, nextHops)
+		if err != nil {
+			klog.Errorf("Failed to inject external route to %s: %v", dst, err)
+			continue
+		}
+		klog.V(2).Infof("Inject external route: '%s via %v' from peers to routing table %d", dst, nextHops,
+			nrc.importExternalRoutesTable)
+		nrc.routeSyncer.addInjectedRoute(dst, route)
+	}
+	if len(imported) > 0 {
+		nrc.routeSyncer.syncLocalRouteTable()
+	}
+	return others
+}
+
+// isImportedExternalPath tells whether the path is an imported route learned from an external peer
+func (nrc *NetworkRoutingController) isImportedExternalPath(path *gobgpapi.Path) bool {
+	if path.Family.Afi != gobgpapi.Family_AFI_IP && path.Family.Safi != gobgpapi.Family_SAFI_UNICAST {
+		return false
+	}
+	if !nrc.isExternalPeer(path.NeighborIp) {
+		return false
+	}
+	dst, _, err := parseBGPPath(path)
+	return err == nil && nrc.importsExternalRoute(dst)
+}
+
+// externalRoute returns the route of the import table to the destination through the given next hops, which need not
+// be directly connected: the next hops of eBGP multihop peers are routed to through the gateways of the routes to them
+func (nrc *NetworkRoutingController) externalRoute(dst *net.IPNet, nextHops []net.IP) (*netlink.Route, error) {
+	gateways := make([]net.IP, 0, len(nextHops))
+	seen := make(map[string]bool)
+	for _, nextHop := range nextHops {
+		gateway, err := nrc.nextHopResolver(nextHop)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[gateway.String()] {
+			seen[gateway.String()] = true
+			gateways = append(gateways, gateway)
+		}
+	}
+	route := multipathRoute(dst, gateways)
+	route.Table = nrc.importExternalRoutesTable
+	return route, nil
+}
+
+// resolveNextHop returns the gateway the node routes the traffic to the next hop through, the next hop itself when it
+// is directly connected
+func resolveNextHop(nextHop net.IP) (net.IP, error) {
+	routes, err := netlink.RouteGet(nextHop)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the route to next hop %s: %v", nextHop, err)
+	}
+	if len(routes) > 0 && routes[0].Gw != nil {
+		return routes[0].Gw, nil
+	}
+	return nextHop, nil
+}
+
+// deleteTableRoutesByDestination deletes the routes injected by kube-router to the destination from the given table
+func deleteTableRoutesByDestination(dst *net.IPNet, table int) error {
+	routes, err := netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{
+		Dst: dst, Table: table, Protocol: zebraRouteOriginator,
+	}, netlink.RT_FILTER_DST|netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return fmt.Errorf("failed to get routes from netlink: %v", err)
+	}
+	for i := range routes {
+		if err = netlink.RouteDel(&routes[i]); err != nil {
+			return fmt.Errorf("failed to remove route due to %v", err)
+		}
+	}
+	return nil
+}
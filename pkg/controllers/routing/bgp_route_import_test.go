@@ -0,0 +1,144 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func Test_importsExternalRoute(t *testing.T) {
+	prefixes, err := parseImportExternalRoutes([]string{"10.10.0.0/16", " 2001:db8::/32"})
+	assert.NoError(t, err)
+	nrc := &NetworkRoutingController{importExternalRoutes: prefixes}
+
+	for cidr, expected := range map[string]bool{
+		"10.10.0.0/16":    true,
+		"10.10.1.0/24":    true,
+		"10.0.0.0/8":      false,
+		"10.20.0.0/16":    false,
+		"0.0.0.0/0":       false,
+		"2001:db8:1::/48": true,
+	} {
+		_, dst, _ := net.ParseCIDR(cidr)
+		assert.Equal(t, expected, nrc.importsExternalRoute(dst), "unexpected import of the route to %s", cidr)
+	}
+	assert.False(t, nrc.importsExternalDefaultRoute(), "expected the default route not to be imported")
+
+	nrc.importExternalRoutes, err = parseImportExternalRoutes([]string{"0.0.0.0/0"})
+	assert.NoError(t, err)
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	assert.True(t, nrc.importsExternalRoute(dst), "expected the default route to be imported")
+	assert.True(t, nrc.importsExternalDefaultRoute(), "expected the default route to be imported")
+	_, dst, _ = net.ParseCIDR("::/0")
+	assert.False(t, nrc.importsExternalRoute(dst), "expected the IPv6 default route not to be imported")
+
+	_, err = parseImportExternalRoutes([]string{"10.10.0.0"})
+	assert.Error(t, err, "expected an error for a CIDR without prefix length")
+}
+
+func Test_injectExternalRoutes(t *testing.T) {
+	prefixes, err := parseImportExternalRoutes([]string{"0.0.0.0/0"})
+	assert.NoError(t, err)
+	var replaced []*netlink.Route
+	nrc := &NetworkRoutingController{
+		importExternalRoutes:      prefixes,
+		importExternalRoutesTable: 100,
+		globalPeerRouters: []*gobgpapi.Peer{
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.1.1"}},
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.2.1"}},
+		},
+		nodePeerRouters: []string{"172.16.0.1"},
+		nextHopResolver: func(nextHop net.IP) (net.IP, error) {
+			// the multihop peer is routed to through the first peer
+			if nextHop.Equal(net.ParseIP("172.16.0.1")) {
+				return net.ParseIP("10.0.1.1"), nil
+			}
+			return nextHop, nil
+		},
+		routeSyncer: &routeSyncer{
+			routeTableStateMap: make(map[string]*netlink.Route),
+			routeReplacer: func(route *netlink.Route) error {
+				replaced = append(replaced, route)
+				return nil
+			},
+		},
+	}
+
+	paths := []*gobgpapi.Path{
+		generateTestPath("0.0.0.0", 0, "10.0.1.1", "10.0.1.1", false),
+		generateTestPath("0.0.0.0", 0, "10.0.2.1", "10.0.2.1", false),
+		generateTestPath("10.244.1.0", 24, "192.168.0.2", "192.168.0.2", false),
+	}
+	others := nrc.injectExternalRoutes(paths)
+	assert.Equal(t, []*gobgpapi.Path{paths[2]}, others, "expected the routes of the nodes to be left alone")
+	assert.Len(t, replaced, 1)
+	assert.Equal(t, "0.0.0.0/0", replaced[0].Dst.String())
+	assert.Equal(t, "10.0.1.1", replaced[0].Gw.String(), "expected the route through the best path only without ECMP")
+	assert.Equal(t, 100, replaced[0].Table)
+
+	replaced = nil
+	nrc.peerECMP = true
+	nrc.injectExternalRoutes(paths)
+	assert.Len(t, replaced, 1)
+	assert.Equal(t, 100, replaced[0].Table)
+	assert.Equal(t, []*netlink.NexthopInfo{{Gw: net.ParseIP("10.0.1.1").To4()}, {Gw: net.ParseIP("10.0.2.1").To4()}},
+		replaced[0].MultiPath, "expected the route across the next hops of both peers with ECMP")
+
+	replaced = nil
+	nrc.injectExternalRoutes([]*gobgpapi.Path{
+		generateTestPath("0.0.0.0", 0, "172.16.0.1", "172.16.0.1", false),
+		generateTestPath("0.0.0.0", 0, "10.0.1.1", "10.0.1.1", false),
+	})
+	assert.Len(t, replaced, 1)
+	assert.Equal(t, "10.0.1.1", replaced[0].Gw.String(),
+		"expected the next hops routed through the same gateway to be merged")
+}
+
+func Test_importExternalDefaultRoutePolicy(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+
+	prefixes, err := parseImportExternalRoutes([]string{"0.0.0.0/0"})
+	assert.NoError(t, err)
+	nrc := &NetworkRoutingController{bgpServer: bgpServer, importExternalRoutes: prefixes}
+	for _, set := range []string{"servicevipsdefinedset", "defaultroutedefinedset"} {
+		err = bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_PREFIX, Name: set}})
+		assert.NoError(t, err)
+	}
+	for _, set := range []string{"allpeerset", "externalpeerset"} {
+		err = bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: set}})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, nrc.addImportPolicies())
+
+	var neighborSet *gobgpapi.MatchSet
+	err = bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{Name: "kube_router_import"},
+		func(policy *gobgpapi.Policy) {
+			for _, statement := range policy.Statements {
+				if statement.Conditions.PrefixSet.GetName() == "defaultroutedefinedset" {
+					neighborSet = statement.Conditions.NeighborSet
+				}
+			}
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, gobgpapi.MatchSet_INVERT, neighborSet.GetType(),
+		"expected the default route to be rejected from the peers other than the external peers")
+	assert.Equal(t, "externalpeerset", neighborSet.GetName())
+}
@@ -0,0 +1,170 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// the neighbor sets of the external peers whose routes the RPKI caches find invalid are rejected or depreferenced
+	rpkiRejectPeerSet       = "rpkirejectpeerset"
+	rpkiDepreferencePeerSet = "rpkidepreferencepeerset"
+	// rpkiInvalidLocalPref is the local preference of the depreferenced invalid routes, below the default of 100
+	rpkiInvalidLocalPref = 50
+	// rpkiResultInvalid is the RPKI validation result of the invalid routes in the conditions of the statements
+	rpkiResultInvalid = 3
+	// rpkiNoPeers stands in for the peers of an empty neighbor set, as GoBGP matches all the neighbors with an empty
+	// set and no peer has the unspecified address
+	rpkiNoPeers = "0.0.0.0/32"
+)
+
+// parseRPKICaches parses the host:port of the RPKI caches given to the flag
+func parseRPKICaches(caches []string) ([]*gobgpapi.AddRpkiRequest, error) {
+	requests := make([]*gobgpapi.AddRpkiRequest, 0, len(caches))
+	for _, cache := range caches {
+		host, port, err := net.SplitHostPort(cache)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RPKI cache %q: %v", cache, err)
+		}
+		portNumber, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || portNumber == 0 {
+			return nil, fmt.Errorf("invalid port of the RPKI cache %q", cache)
+		}
+		requests = append(requests, &gobgpapi.AddRpkiRequest{Address: host, Port: uint32(portNumber)})
+	}
+	return requests, nil
+}
+
+// isRPKIInvalidAction returns true for the actions that can be taken on the invalid routes
+func isRPKIInvalidAction(action string) bool {
+	switch action {
+	case options.RPKIInvalidActionReject, options.RPKIInvalidActionDepreference, options.RPKIInvalidActionAccept:
+		return true
+	}
+	return false
+}
+
+// startRPKIClients connects the BGP server to the RPKI caches, it keeps retrying the caches it can't connect to and
+// validates the routes against the ROAs of the caches it is connected to
+func (nrc *NetworkRoutingController) startRPKIClients() error {
+	for _, cache := range nrc.rpkiCaches {
+		err := nrc.bgpServer.AddRpki(context.Background(), cache)
+		if err != nil {
+			return fmt.Errorf("failed to add the RPKI cache %s: %v",
+				net.JoinHostPort(cache.Address, strconv.Itoa(int(cache.Port))), err)
+		}
+		klog.Infof("Validating the routes of the external BGP peers against the RPKI cache %s",
+			net.JoinHostPort(cache.Address, strconv.Itoa(int(cache.Port))))
+	}
+	return nil
+}
+
+// rpkiInvalidAction returns what is done with the invalid routes of an external peer, the action of its BGPPeer
+// resource when it has one and the one of the flag otherwise
+func (nrc *NetworkRoutingController) rpkiInvalidAction(address string) string {
+	if peer, ok := nrc.bgpPeerResourcePeers[address]; ok && peer.config.rpkiInvalidAction != "" {
+		return peer.config.rpkiInvalidAction
+	}
+	return nrc.rpkiDefaultInvalidAction
+}
+
+// addRPKIPeersDefinedSets sorts the external peers into the neighbor sets of the actions taken on their invalid
+// routes, the peers whose invalid routes are accepted are in neither of them
+func (nrc *NetworkRoutingController) addRPKIPeersDefinedSets(externalBGPPeerCIDRs []string) error {
+	peerSets := map[string][]string{rpkiRejectPeerSet: {}, rpkiDepreferencePeerSet: {}}
+	for _, cidr := range externalBGPPeerCIDRs {
+		address := net.ParseIP(strings.SplitN(cidr, "/", 2)[0]).String()
+		switch nrc.rpkiInvalidAction(address) {
+		case options.RPKIInvalidActionReject:
+			peerSets[rpkiRejectPeerSet] = append(peerSets[rpkiRejectPeerSet], cidr)
+		case options.RPKIInvalidActionDepreference:
+			peerSets[rpkiDepreferencePeerSet] = append(peerSets[rpkiDepreferencePeerSet], cidr)
+		}
+	}
+	for _, name := range []string{rpkiRejectPeerSet, rpkiDepreferencePeerSet} {
+		if len(peerSets[name]) == 0 {
+			peerSets[name] = []string{rpkiNoPeers}
+		}
+		if err := nrc.syncNeighborSet(name, peerSets[name]); err != nil {
+			return fmt.Errorf("failed to sync the `%s` defined set: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// syncNeighborSet makes the neighbor set hold the given peers
+func (nrc *NetworkRoutingController) syncNeighborSet(name string, peers []string) error {
+	current := make(map[string]bool)
+	err := nrc.bgpServer.ListDefinedSet(context.Background(),
+		&gobgpapi.ListDefinedSetRequest{DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: name},
+		func(ds *gobgpapi.DefinedSet) {
+			for _, peer := range ds.List {
+				current[peer] = true
+			}
+		})
+	if err != nil {
+		return err
+	}
+	desired := make(map[string]bool, len(peers))
+	toAdd := make([]string, 0)
+	for _, peer := range peers {
+		desired[peer] = true
+		if !current[peer] {
+			toAdd = append(toAdd, peer)
+		}
+	}
+	toDelete := make([]string, 0)
+	for peer := range current {
+		if !desired[peer] {
+			toDelete = append(toDelete, peer)
+		}
+	}
+	sort.Strings(toDelete)
+	// the peers are added first so that the set never goes empty, which would match all the neighbors
+	if len(toAdd) > 0 {
+		err = nrc.bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: name, List: toAdd}})
+		if err != nil {
+			return err
+		}
+	}
+	if len(toDelete) > 0 {
+		err = nrc.bgpServer.DeleteDefinedSet(context.Background(), &gobgpapi.DeleteDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: name, List: toDelete},
+			All:        false})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rpkiImportStatements returns the statements of the import policy that reject or depreference the routes of the
+// external peers the RPKI caches find invalid. Routes the caches have no ROA for, or that the caches couldn't validate
+// as none of them could be reached, are not invalid.
+func rpkiImportStatements() []*gobgpapi.Statement {
+	return []*gobgpapi.Statement{
+		{
+			Conditions: &gobgpapi.Conditions{
+				NeighborSet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: rpkiRejectPeerSet},
+				RpkiResult:  rpkiResultInvalid,
+			},
+			Actions: &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_REJECT},
+		},
+		{
+			Conditions: &gobgpapi.Conditions{
+				NeighborSet: &gobgpapi.MatchSet{Type: gobgpapi.MatchSet_ANY, Name: rpkiDepreferencePeerSet},
+				RpkiResult:  rpkiResultInvalid,
+			},
+			Actions: &gobgpapi.Actions{LocalPref: &gobgpapi.LocalPrefAction{Value: rpkiInvalidLocalPref}},
+		},
+	}
+}
@@ -0,0 +1,133 @@
+package routing
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+)
+
+func Test_parseRPKICaches(t *testing.T) {
+	caches, err := parseRPKICaches([]string{"192.168.0.10:323", "[2001:db8::10]:8282"})
+	if err != nil {
+		t.Fatalf("failed to parse the RPKI caches: %v", err)
+	}
+	expected := []*gobgpapi.AddRpkiRequest{{Address: "192.168.0.10", Port: 323}, {Address: "2001:db8::10", Port: 8282}}
+	if !reflect.DeepEqual(caches, expected) {
+		t.Errorf("expected %v, got %v", expected, caches)
+	}
+	for _, cache := range []string{"192.168.0.10", "192.168.0.10:rtr", "192.168.0.10:0", "192.168.0.10:65536"} {
+		if _, err = parseRPKICaches([]string{cache}); err == nil {
+			t.Errorf("expected an error for the RPKI cache %q", cache)
+		}
+	}
+}
+
+func Test_addRPKIPeersDefinedSets(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	nrc := &NetworkRoutingController{
+		bgpServer:                bgpServer,
+		rpkiDefaultInvalidAction: "reject",
+		bgpPeerResourcePeers: map[string]*bgpPeerResourcePeer{
+			"192.168.0.2":  {config: bgpPeerConfig{rpkiInvalidAction: "depreference"}},
+			"192.168.0.3":  {config: bgpPeerConfig{rpkiInvalidAction: "accept"}},
+			"2001:db8::1":  {config: bgpPeerConfig{}},
+			"192.168.0.10": {config: bgpPeerConfig{rpkiInvalidAction: "depreference"}},
+		},
+	}
+
+	peerSets := func() map[string][]string {
+		sets := make(map[string][]string)
+		err := bgpServer.ListDefinedSet(context.Background(), &gobgpapi.ListDefinedSetRequest{
+			DefinedType: gobgpapi.DefinedType_NEIGHBOR}, func(ds *gobgpapi.DefinedSet) {
+			list := append([]string{}, ds.List...)
+			sort.Strings(list)
+			sets[ds.Name] = list
+		})
+		if err != nil {
+			t.Fatalf("failed to list the neighbor sets: %v", err)
+		}
+		return sets
+	}
+
+	err = nrc.addRPKIPeersDefinedSets([]string{"192.168.0.1/32", "192.168.0.2/32", "192.168.0.3/32",
+		"2001:db8::1/128"})
+	if err != nil {
+		t.Fatalf("failed to add the RPKI defined sets: %v", err)
+	}
+	expected := map[string][]string{
+		rpkiRejectPeerSet:       {"192.168.0.1/32", "2001:db8::1/128"},
+		rpkiDepreferencePeerSet: {"192.168.0.2/32"},
+	}
+	if sets := peerSets(); !reflect.DeepEqual(sets, expected) {
+		t.Errorf("expected the neighbor sets %v, got %v", expected, sets)
+	}
+
+	err = nrc.addRPKIPeersDefinedSets([]string{"192.168.0.3/32"})
+	if err != nil {
+		t.Fatalf("failed to update the RPKI defined sets: %v", err)
+	}
+	expected = map[string][]string{
+		rpkiRejectPeerSet:       {rpkiNoPeers},
+		rpkiDepreferencePeerSet: {rpkiNoPeers},
+	}
+	if sets := peerSets(); !reflect.DeepEqual(sets, expected) {
+		t.Errorf("expected the neighbor sets to match no peer without peers of the action, got %v", sets)
+	}
+
+	nrc.rpkiCaches = []*gobgpapi.AddRpkiRequest{{Address: "192.168.0.10", Port: 323}}
+	for _, set := range []string{"podcidrdefinedset", "servicevipsdefinedset", "defaultroutedefinedset",
+		"customimportrejectdefinedset"} {
+		err = bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+			DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_PREFIX, Name: set}})
+		if err != nil {
+			t.Fatalf("failed to add the defined set %s: %v", set, err)
+		}
+	}
+	err = bgpServer.AddDefinedSet(context.Background(), &gobgpapi.AddDefinedSetRequest{
+		DefinedSet: &gobgpapi.DefinedSet{DefinedType: gobgpapi.DefinedType_NEIGHBOR, Name: "allpeerset"}})
+	if err != nil {
+		t.Fatalf("failed to add the defined set allpeerset: %v", err)
+	}
+	if err = nrc.addImportPolicies(); err != nil {
+		t.Fatalf("failed to add the import policies: %v", err)
+	}
+	var rpkiStatements []*gobgpapi.Statement
+	err = bgpServer.ListPolicy(context.Background(), &gobgpapi.ListPolicyRequest{Name: "kube_router_import"},
+		func(policy *gobgpapi.Policy) {
+			for _, statement := range policy.Statements {
+				if statement.Conditions.RpkiResult == rpkiResultInvalid {
+					rpkiStatements = append(rpkiStatements, statement)
+				}
+			}
+		})
+	if err != nil {
+		t.Fatalf("failed to list the import policy: %v", err)
+	}
+	if len(rpkiStatements) != 2 {
+		t.Fatalf("expected 2 statements matching the invalid routes, got %v", rpkiStatements)
+	}
+	if rpkiStatements[0].Conditions.NeighborSet.Name != rpkiRejectPeerSet ||
+		rpkiStatements[0].Actions.RouteAction != gobgpapi.RouteAction_REJECT {
+		t.Errorf("expected the invalid routes of %s to be rejected, got %v", rpkiRejectPeerSet, rpkiStatements[0])
+	}
+	if rpkiStatements[1].Conditions.NeighborSet.Name != rpkiDepreferencePeerSet ||
+		rpkiStatements[1].Actions.LocalPref.GetValue() != rpkiInvalidLocalPref {
+		t.Errorf("expected the invalid routes of %s to be depreferenced, got %v", rpkiDepreferencePeerSet,
+			rpkiStatements[1])
+	}
+}
@@ -0,0 +1,145 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/proto"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// annotations with the interfaces over which the node peers with unnumbered eBGP peers and the ASNs of the peers
+	peerInterfacesAnnotation    = "kube-router.io/peer.interfaces"
+	peerInterfaceASNsAnnotation = "kube-router.io/peer.interfaceasns"
+)
+
+// newUnnumberedPeers returns the unnumbered eBGP peers (RFC 5549) on the other end of the given interfaces. The
+// sessions are established between the IPv6 link-local addresses of the interfaces and carry the IPv4 routes as well,
+// with the link-local addresses as their next hops (extended next hop encoding).
+func newUnnumberedPeers(interfaces []string, asns []uint32, holdtime float64) ([]*gobgpapi.Peer, error) {
+	if len(interfaces) != len(asns) {
+		return nil, errors.New("invalid unnumbered peer config, the number of interfaces and ASN numbers must be " +
+			"equal")
+	}
+	peers := make([]*gobgpapi.Peer, 0, len(interfaces))
+	for i, iface := range interfaces {
+		if iface == "" {
+			return nil, errors.New("invalid unnumbered peer config, the interface can not be empty")
+		}
+		peers = append(peers, &gobgpapi.Peer{
+			Conf: &gobgpapi.PeerConf{
+				NeighborInterface: iface,
+				PeerAsn:           asns[i],
+			},
+			Timers: &gobgpapi.Timers{Config: &gobgpapi.TimersConfig{HoldTime: uint64(holdtime)}},
+			Transport: &gobgpapi.Transport{
+				RemotePort: options.DefaultBgpPort,
+			},
+			// the IPv4 routes are exchanged over the IPv6 session as well
+			AfiSafis: []*gobgpapi.AfiSafi{
+				{Config: &gobgpapi.AfiSafiConfig{Family: unicastFamily(false), Enabled: true}},
+				{Config: &gobgpapi.AfiSafiConfig{Family: unicastFamily(true), Enabled: true}},
+			},
+		})
+	}
+	return peers, nil
+}
+
+// nodeUnnumberedPeers returns the unnumbered peers of the node annotations, which are used when no interfaces are
+// given to the flags like with the other external peers
+func (nrc *NetworkRoutingController) nodeUnnumberedPeers(node *v1core.Node) ([]*gobgpapi.Peer, error) {
+	interfaces, ok := node.Annotations[peerInterfacesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	asns, err := stringSliceToUInt32(stringToSlice(node.Annotations[peerInterfaceASNsAnnotation], ","))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node's Peer Interface ASN Numbers Annotation: %s", err)
+	}
+	return newUnnumberedPeers(stringToSlice(interfaces, ","), asns, nrc.bgpHoldtime)
+}
+
+// syncUnnumberedPeers adds the unnumbered peers that aren't peers of the BGP server yet. GoBGP resolves the link-local
+// address of the peer from the IPv6 neighbors of the interface as the peer is added, which fails until the peer has
+// been discovered on the link, so that is retried on every sync.
+func (nrc *NetworkRoutingController) syncUnnumberedPeers() {
+	links, err := nrc.unnumberedPeerLinks()
+	if err != nil {
+		klog.Errorf("Failed to list the unnumbered BGP peers: %s", err)
+		return
+	}
+	added := make(map[string]bool, len(links))
+	for _, iface := range links {
+		added[iface] = true
+	}
+	for _, peer := range nrc.unnumberedPeerRouters {
+		if added[peer.Conf.NeighborInterface] {
+			continue
+		}
+		n, ok := proto.Clone(peer).(*gobgpapi.Peer)
+		if !ok {
+			continue
+		}
+		err = nrc.connectToExternalBGPPeers(nrc.bgpServer, []*gobgpapi.Peer{n}, nrc.bgpGracefulRestart,
+			nrc.bgpGracefulRestartDeferralTime, nrc.bgpGracefulRestartTime, nrc.peerMultihopTTL)
+		if err != nil {
+			klog.Warningf("Failed to peer over interface %s, retrying on the next sync: %s",
+				peer.Conf.NeighborInterface, err)
+		}
+	}
+}
+
+// unnumberedPeerLinks returns the interfaces of the unnumbered peers of the BGP server by link-local address
+func (nrc *NetworkRoutingController) unnumberedPeerLinks() (map[string]string, error) {
+	links := make(map[string]string)
+	if len(nrc.unnumberedPeerRouters) == 0 {
+		return links, nil
+	}
+	err := nrc.bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{}, func(peer *gobgpapi.Peer) {
+		if peer.Conf == nil || peer.Conf.NeighborInterface == "" || peer.State == nil {
+			return
+		}
+		// the address carries the interface as its zone, e.g. fe80::1%eth1
+		address := strings.SplitN(peer.State.NeighborAddress, "%", 2)[0]
+		links[address] = peer.Conf.NeighborInterface
+	})
+	return links, err
+}
+
+// unnumberedPeerRoute returns the route to the destination of a path learned from an unnumbered peer, through the
+// link-local address of the peer on its interface. IPv4 destinations are routed through the IPv6 next hop with the
+// RTA_VIA attribute.
+func (nrc *NetworkRoutingController) unnumberedPeerRoute(neighbor string, dst *net.IPNet,
+	nextHop net.IP) (*netlink.Route, error) {
+	links, err := nrc.unnumberedPeerLinks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the unnumbered BGP peers: %v", err)
+	}
+	iface, ok := links[neighbor]
+	if !ok {
+		return nil, fmt.Errorf("the link-local next hop %s of %s is not the one of an unnumbered peer", nextHop, dst)
+	}
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the interface %s of the unnumbered peer: %v", iface, err)
+	}
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       dst,
+		Protocol:  zebraRouteOriginator,
+	}
+	if dst.IP.To4() != nil {
+		route.Via = &netlink.Via{AddrFamily: netlink.FAMILY_V6, Addr: nextHop}
+	} else {
+		route.Gw = nextHop
+	}
+	return route, nil
+}
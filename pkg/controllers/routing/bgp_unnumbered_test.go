@@ -0,0 +1,60 @@
+package routing
+
+import (
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_newUnnumberedPeers(t *testing.T) {
+	peers, err := newUnnumberedPeers([]string{"eth1", "eth2"}, []uint32{65001, 65002}, 90)
+	if err != nil {
+		t.Fatalf("failed to create the unnumbered peers: %v", err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("expected 2 unnumbered peers, got %d", len(peers))
+	}
+	for i, iface := range []string{"eth1", "eth2"} {
+		peer := peers[i]
+		if peer.Conf.NeighborInterface != iface || peer.Conf.NeighborAddress != "" {
+			t.Errorf("expected a peer on interface %s without address, got %v", iface, peer.Conf)
+		}
+		if peer.Conf.PeerAsn != uint32(65001+i) {
+			t.Errorf("expected the ASN %d for the peer on %s, got %d", 65001+i, iface, peer.Conf.PeerAsn)
+		}
+		if len(peer.AfiSafis) != 2 || isIPv6Family(peer.AfiSafis[0].Config.Family) ||
+			!isIPv6Family(peer.AfiSafis[1].Config.Family) {
+			t.Errorf("expected the IPv4 and IPv6 unicast families for the peer on %s, got %v", iface,
+				peer.AfiSafis)
+		}
+	}
+
+	if _, err = newUnnumberedPeers([]string{"eth1"}, []uint32{65001, 65002}, 90); err == nil {
+		t.Error("expected an error for a different number of interfaces and ASNs")
+	}
+	if _, err = newUnnumberedPeers([]string{""}, []uint32{65001}, 90); err == nil {
+		t.Error("expected an error for an empty interface")
+	}
+}
+
+func Test_nodeUnnumberedPeers(t *testing.T) {
+	nrc := &NetworkRoutingController{bgpHoldtime: 90}
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	peers, err := nrc.nodeUnnumberedPeers(node)
+	if err != nil || peers != nil {
+		t.Errorf("expected no unnumbered peers without the annotations, got %v: %v", peers, err)
+	}
+
+	node.Annotations = map[string]string{peerInterfacesAnnotation: "swp1,swp2",
+		peerInterfaceASNsAnnotation: "65001,65002"}
+	peers, err = nrc.nodeUnnumberedPeers(node)
+	if err != nil || len(peers) != 2 || peers[1].Conf.NeighborInterface != "swp2" {
+		t.Errorf("expected the unnumbered peers of the annotations, got %v: %v", peers, err)
+	}
+
+	node.Annotations[peerInterfaceASNsAnnotation] = "invalid"
+	if _, err = nrc.nodeUnnumberedPeers(node); err == nil {
+		t.Error("expected an error for invalid ASNs")
+	}
+}
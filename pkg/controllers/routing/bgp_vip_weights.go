@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"math"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"google.golang.org/protobuf/types/known/anypb"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// vipWeightMaxMED is the MED the VIPs are weighted from, each local endpoint of the service lowers it by one
+	vipWeightMaxMED = 1000
+	// vipWeightEndpointBandwidth is the link bandwidth each local endpoint of the service adds, in bytes per second
+	// (1 Mbit/s), only the ratio between the bandwidths of the nodes matters to the upstream routers
+	vipWeightEndpointBandwidth = 125000
+	// asTrans stands in for the 4-byte ASNs in the 2-byte ASN of the link bandwidth extended community (RFC 6793)
+	asTrans = 23456
+)
+
+// vipWeights returns the number of ready endpoints on the node of the services with a local traffic policy by VIP,
+// summed over the services sharing a VIP. The VIPs of the other services are advertised without a weight, as the
+// traffic they attract is forwarded to the endpoints on all the nodes.
+func (nrc *NetworkRoutingController) vipWeights() map[string]uint32 {
+	weights := make(map[string]uint32)
+	if nrc.vipWeight != options.VIPWeightMED && nrc.vipWeight != options.VIPWeightLinkBandwidth {
+		return weights
+	}
+	for _, obj := range nrc.svcLister.List() {
+		svc := obj.(*v1core.Service)
+		if !serviceIsLocal(svc) {
+			continue
+		}
+		endpoints, err := nrc.nodeEndpointsOfService(svc)
+		if err != nil {
+			klog.Errorf("Failed to count the local endpoints of service %s/%s to weight its VIPs: %v",
+				svc.Namespace, svc.Name, err)
+			continue
+		}
+		vips := nrc.getClusterIPs(svc)
+		vips = append(vips, nrc.getExternalIPs(svc)...)
+		vips = append(vips, nrc.getLoadBalancerIPs(svc)...)
+		for _, vip := range vips {
+			weights[vip] += endpoints
+		}
+	}
+	return weights
+}
+
+// newVIPWeightAttribute returns the path attribute weighting the route of a VIP by the given number of local
+// endpoints, either a MED that prefers the nodes with the most endpoints or a link bandwidth extended community the
+// routers spread the traffic across the nodes in proportion to. It returns nil when the VIPs aren't weighted.
+func (nrc *NetworkRoutingController) newVIPWeightAttribute(endpoints uint32) (*anypb.Any, error) {
+	switch nrc.vipWeight {
+	case options.VIPWeightMED:
+		var med uint32
+		if endpoints < vipWeightMaxMED {
+			med = vipWeightMaxMED - endpoints
+		}
+		return anypb.New(&gobgpapi.MultiExitDiscAttribute{Med: med})
+	case options.VIPWeightLinkBandwidth:
+		asn := nrc.nodeAsnNumber
+		if asn > math.MaxUint16 {
+			asn = asTrans
+		}
+		bandwidth, err := anypb.New(&gobgpapi.LinkBandwidthExtended{Asn: asn,
+			Bandwidth: float32(endpoints) * vipWeightEndpointBandwidth})
+		if err != nil {
+			return nil, err
+		}
+		return anypb.New(&gobgpapi.ExtendedCommunitiesAttribute{Communities: []*anypb.Any{bandwidth}})
+	}
+	return nil, nil
+}
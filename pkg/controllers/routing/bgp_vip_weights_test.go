@@ -0,0 +1,95 @@
+package routing
+
+import (
+	"reflect"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_vipWeights(t *testing.T) {
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	epLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	services := []*v1core.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "local", Namespace: "default"},
+			Spec: v1core.ServiceSpec{Type: ClusterIPST, ClusterIP: "10.96.0.1", ExternalIPs: []string{"1.1.1.1"},
+				ExternalTrafficPolicy: v1core.ServiceExternalTrafficPolicyTypeLocal},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "default"},
+			Spec:       v1core.ServiceSpec{Type: ClusterIPST, ClusterIP: "10.96.0.2"},
+		},
+	}
+	for _, svc := range services {
+		if err := svcLister.Add(svc); err != nil {
+			t.Fatalf("failed to add the service: %v", err)
+		}
+		err := epLister.Add(&v1core.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: svc.Name, Namespace: svc.Namespace},
+			Subsets: []v1core.EndpointSubset{{
+				Addresses: []v1core.EndpointAddress{
+					{IP: "172.20.1.1", NodeName: ptrToString("node-1")},
+					{IP: "172.20.1.2", NodeName: ptrToString("node-1")},
+					{IP: "172.20.2.1", NodeName: ptrToString("node-2")},
+				},
+				NotReadyAddresses: []v1core.EndpointAddress{
+					{IP: "172.20.1.3", NodeName: ptrToString("node-1")},
+				},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("failed to add the endpoints: %v", err)
+		}
+	}
+	nrc := &NetworkRoutingController{nodeName: "node-1", svcLister: svcLister, epLister: epLister}
+
+	if weights := nrc.vipWeights(); len(weights) != 0 {
+		t.Errorf("expected no weights without --advertise-vip-weight, got %v", weights)
+	}
+	nrc.vipWeight = "med"
+	expected := map[string]uint32{"10.96.0.1": 2, "1.1.1.1": 2}
+	if weights := nrc.vipWeights(); !reflect.DeepEqual(weights, expected) {
+		t.Errorf("expected the weights %v, got %v", expected, weights)
+	}
+}
+
+func Test_newVIPWeightAttribute(t *testing.T) {
+	nrc := &NetworkRoutingController{nodeAsnNumber: 4200000000}
+	if attr, err := nrc.newVIPWeightAttribute(3); attr != nil || err != nil {
+		t.Errorf("expected no attribute without --advertise-vip-weight, got %v: %v", attr, err)
+	}
+
+	nrc.vipWeight = "med"
+	attr, err := nrc.newVIPWeightAttribute(3)
+	if err != nil {
+		t.Fatalf("failed to build the MED: %v", err)
+	}
+	med := &gobgpapi.MultiExitDiscAttribute{}
+	if err = attr.UnmarshalTo(med); err != nil || med.Med != vipWeightMaxMED-3 {
+		t.Errorf("expected the MED %d, got %v: %v", vipWeightMaxMED-3, med, err)
+	}
+	if attr, _ = nrc.newVIPWeightAttribute(vipWeightMaxMED + 1); attr.UnmarshalTo(med) != nil || med.Med != 0 {
+		t.Errorf("expected the MED to bottom out at 0, got %v", med)
+	}
+
+	nrc.vipWeight = "link-bandwidth"
+	attr, err = nrc.newVIPWeightAttribute(3)
+	if err != nil {
+		t.Fatalf("failed to build the link bandwidth: %v", err)
+	}
+	communities := &gobgpapi.ExtendedCommunitiesAttribute{}
+	bandwidth := &gobgpapi.LinkBandwidthExtended{}
+	if err = attr.UnmarshalTo(communities); err != nil || len(communities.Communities) != 1 {
+		t.Fatalf("expected a single extended community, got %v: %v", communities, err)
+	}
+	if err = communities.Communities[0].UnmarshalTo(bandwidth); err != nil {
+		t.Fatalf("expected a link bandwidth extended community: %v", err)
+	}
+	if bandwidth.Asn != asTrans || bandwidth.Bandwidth != 3*vipWeightEndpointBandwidth {
+		t.Errorf("expected the bandwidth of 3 endpoints from AS_TRANS, got %v", bandwidth)
+	}
+}
@@ -0,0 +1,101 @@
+package routing
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	v1core "k8s.io/api/core/v1"
+)
+
+// ibgpZones partitions the iBGP mesh by the value of the --ibgp-zone-label node label. The nodes of each zone elect
+// route reflectors among themselves, the route reflectors peer with each other and with the other nodes of their zone,
+// and the other nodes only peer with the route reflectors of their zone.
+type ibgpZones struct {
+	label      string
+	reflectors map[string]bool
+	// the zone of this node and whether it's one of the route reflectors of its zone
+	zone      string
+	reflector bool
+}
+
+// newIBGPZones elects the route reflectors of the zones of the given nodes for the given node
+func newIBGPZones(nodes []interface{}, nodeName, label string, reflectors uint) *ibgpZones {
+	zones := &ibgpZones{label: label, reflectors: electZoneRouteReflectors(nodes, label, reflectors)}
+	for _, obj := range nodes {
+		node := obj.(*v1core.Node)
+		if node.Name == nodeName {
+			zones.zone = node.Labels[label]
+			zones.reflector = zones.reflectors[node.Name]
+			break
+		}
+	}
+	return zones
+}
+
+// electZoneRouteReflectors elects the given number of route reflectors in each zone, the nodes without the label form
+// a zone of their own. The ready nodes are elected first and the nodes of a zone are ordered by name, so that all the
+// nodes elect the same route reflectors from the same nodes without having to agree on them, and a zone whose nodes
+// aren't ready still has route reflectors for its nodes to peer with once they are.
+func electZoneRouteReflectors(nodes []interface{}, label string, reflectors uint) map[string]bool {
+	zones := make(map[string][]*v1core.Node)
+	for _, obj := range nodes {
+		node := obj.(*v1core.Node)
+		zone := node.Labels[label]
+		zones[zone] = append(zones[zone], node)
+	}
+	elected := make(map[string]bool)
+	for _, zoneNodes := range zones {
+		sort.Slice(zoneNodes, func(i, j int) bool {
+			if iReady, jReady := nodeIsReady(zoneNodes[i]), nodeIsReady(zoneNodes[j]); iReady != jReady {
+				return iReady
+			}
+			return zoneNodes[i].Name < zoneNodes[j].Name
+		})
+		for i := 0; i < len(zoneNodes) && i < int(reflectors); i++ {
+			elected[zoneNodes[i].Name] = true
+		}
+	}
+	return elected
+}
+
+// peering returns whether this node peers with the given node and, when the given node is a route reflector client of
+// this node, the cluster ID of the zone this node reflects its routes in
+func (z *ibgpZones) peering(node *v1core.Node) (bool, string) {
+	sameZone := node.Labels[z.label] == z.zone
+	reflector := z.reflectors[node.Name]
+	switch {
+	case z.reflector && reflector:
+		return true, ""
+	case z.reflector && sameZone:
+		return true, zoneClusterID(z.zone)
+	case reflector && sameZone:
+		return true, ""
+	}
+	return false, ""
+}
+
+// zoneClusterID returns the route reflector cluster ID of a zone, the route reflectors of a zone share it so that they
+// don't reflect the routes of their clients back to each other, and it tells apart the route reflectors of the other
+// zones whose reflected routes they reflect to their clients
+func zoneClusterID(zone string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(zone))
+	return fmt.Sprint(hash.Sum32())
+}
+
+// nodeIsReady returns whether the Ready condition of a node is true
+func nodeIsReady(node *v1core.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1core.NodeReady {
+			return condition.Status == v1core.ConditionTrue
+		}
+	}
+	return false
+}
+
+// zoneChanged returns whether the zone or the readiness of a node have changed, which may change the route reflectors
+// of its zone
+func zoneChanged(oldNode, newNode *v1core.Node, label string) bool {
+	return oldNode.Labels[label] != newNode.Labels[label] || nodeIsReady(oldNode) != nodeIsReady(newNode)
+}
@@ -0,0 +1,181 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testZoneLabel = "topology.kubernetes.io/zone"
+
+func newZoneNode(name, ip, zone string, ready bool) *v1core.Node {
+	node := &v1core.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+		Status: v1core.NodeStatus{
+			Addresses: []v1core.NodeAddress{{Type: v1core.NodeInternalIP, Address: ip}},
+		},
+	}
+	if zone != "" {
+		node.Labels[testZoneLabel] = zone
+	}
+	status := v1core.ConditionFalse
+	if ready {
+		status = v1core.ConditionTrue
+	}
+	node.Status.Conditions = []v1core.NodeCondition{{Type: v1core.NodeReady, Status: status}}
+	return node
+}
+
+func Test_electZoneRouteReflectors(t *testing.T) {
+	nodes := []interface{}{
+		newZoneNode("node-c", "10.0.0.3", "a", true),
+		newZoneNode("node-a", "10.0.0.1", "a", false),
+		newZoneNode("node-b", "10.0.0.2", "a", true),
+		newZoneNode("node-d", "10.0.0.4", "b", false),
+		newZoneNode("node-e", "10.0.0.5", "", true),
+	}
+	testcases := []struct {
+		name       string
+		reflectors uint
+		elected    map[string]bool
+	}{
+		{
+			"one route reflector per zone",
+			1,
+			map[string]bool{"node-b": true, "node-d": true, "node-e": true},
+		},
+		{
+			"ready nodes elected before the others",
+			3,
+			map[string]bool{"node-a": true, "node-b": true, "node-c": true, "node-d": true, "node-e": true},
+		},
+		{
+			"more route reflectors than ready nodes",
+			2,
+			map[string]bool{"node-b": true, "node-c": true, "node-d": true, "node-e": true},
+		},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			elected := electZoneRouteReflectors(nodes, testZoneLabel, testcase.reflectors)
+			if !reflect.DeepEqual(elected, testcase.elected) {
+				t.Errorf("expected the route reflectors %v, got %v", testcase.elected, elected)
+			}
+		})
+	}
+}
+
+func Test_ibgpZonesPeering(t *testing.T) {
+	nodes := []interface{}{
+		newZoneNode("node-1", "10.0.0.1", "a", true),
+		newZoneNode("node-2", "10.0.0.2", "a", true),
+		newZoneNode("node-3", "10.0.0.3", "b", true),
+		newZoneNode("node-4", "10.0.0.4", "b", true),
+	}
+	testcases := []struct {
+		name      string
+		nodeName  string
+		peer      string
+		peering   bool
+		clusterID string
+	}{
+		{"route reflector with a client of its zone", "node-1", "node-2", true, zoneClusterID("a")},
+		{"route reflector with the route reflector of another zone", "node-1", "node-3", true, ""},
+		{"route reflector with a client of another zone", "node-1", "node-4", false, ""},
+		{"client with the route reflector of its zone", "node-2", "node-1", true, ""},
+		{"client with the route reflector of another zone", "node-2", "node-3", false, ""},
+		{"client with a client of another zone", "node-2", "node-4", false, ""},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			zones := newIBGPZones(nodes, testcase.nodeName, testZoneLabel, 1)
+			var peer *v1core.Node
+			for _, obj := range nodes {
+				if node := obj.(*v1core.Node); node.Name == testcase.peer {
+					peer = node
+				}
+			}
+			peering, clusterID := zones.peering(peer)
+			if peering != testcase.peering || clusterID != testcase.clusterID {
+				t.Errorf("expected peering %t with the cluster ID %q, got %t with %q", testcase.peering,
+					testcase.clusterID, peering, clusterID)
+			}
+		})
+	}
+
+	if zoneClusterID("a") == zoneClusterID("b") {
+		t.Error("expected the zones to have different cluster IDs")
+	}
+}
+
+func Test_syncInternalPeersWithZones(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []*v1core.Node{
+		newZoneNode("node-0", "10.0.0.0", "a", true),
+		newZoneNode("node-1", "10.0.0.1", "a", true),
+		newZoneNode("node-2", "10.0.0.2", "b", true),
+		newZoneNode("node-3", "10.0.0.3", "b", true),
+	} {
+		if err := nodeLister.Add(node); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+	}
+	nrc := &NetworkRoutingController{
+		bgpServer:               bgpServer,
+		bgpFullMeshMode:         true,
+		nodeName:                "node-0",
+		nodeIP:                  net.ParseIP("10.0.0.0"),
+		bgpIP:                   net.ParseIP("10.0.0.0"),
+		nodeLister:              nodeLister,
+		activeNodes:             make(map[string]bool),
+		ibgpZoneLabel:           testZoneLabel,
+		ibgpZoneRouteReflectors: 1,
+		ibgpZonePeers:           make(map[string]string),
+	}
+
+	reflectorClients := func() map[string]bool {
+		clients := make(map[string]bool)
+		err := bgpServer.ListPeer(context.Background(), &gobgpapi.ListPeerRequest{}, func(peer *gobgpapi.Peer) {
+			clients[peer.Conf.NeighborAddress] = peer.RouteReflector != nil &&
+				peer.RouteReflector.RouteReflectorClient
+		})
+		if err != nil {
+			t.Fatalf("failed to list the BGP peers: %v", err)
+		}
+		return clients
+	}
+
+	nrc.syncInternalPeers()
+	expected := map[string]bool{"10.0.0.1": true, "10.0.0.2": false}
+	if clients := reflectorClients(); !reflect.DeepEqual(clients, expected) {
+		t.Errorf("expected the route reflector to peer with its client and the other route reflector %v, got %v",
+			expected, clients)
+	}
+
+	// with two route reflectors per zone every node is one, so the client is added again as a route reflector
+	nrc.ibgpZoneRouteReflectors = 2
+	nrc.syncInternalPeers()
+	expected = map[string]bool{"10.0.0.1": false, "10.0.0.2": false, "10.0.0.3": false}
+	if clients := reflectorClients(); !reflect.DeepEqual(clients, expected) {
+		t.Errorf("expected the route reflectors to peer with each other %v, got %v", expected, clients)
+	}
+}
@@ -0,0 +1,51 @@
+package routing
+
+import (
+	"net"
+
+	networkingv1alpha1 "k8s.io/api/networking/v1alpha1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// EnableClusterCIDRs makes the controller treat the ranges of the ClusterCIDR objects, which kube-controller-manager
+// allocates the pod CIDRs of the nodes from with its MultiCIDRRangeAllocator, as pod networks. The traffic of the pods
+// to them isn't masqueraded, including to the pods of the nodes whose pod CIDRs aren't known yet. The informer watches
+// the ClusterCIDRs, ClusterCIDREventHandler is to be added to it.
+func (nrc *NetworkRoutingController) EnableClusterCIDRs(clusterCIDRInformer cache.SharedIndexInformer) {
+	nrc.clusterCIDRLister = clusterCIDRInformer.GetIndexer()
+	if nrc.bgpResourceSyncChan == nil {
+		nrc.bgpResourceSyncChan = make(chan struct{}, 1)
+	}
+	nrc.ClusterCIDREventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { nrc.requestBGPResourceSync() },
+		UpdateFunc: func(interface{}, interface{}) { nrc.requestBGPResourceSync() },
+		DeleteFunc: func(interface{}) { nrc.requestBGPResourceSync() },
+	}
+}
+
+// clusterCIDRRanges returns the ranges of the given address family of the ClusterCIDR objects, leaving out (and
+// logging) the invalid ones
+func clusterCIDRRanges(objs []interface{}, ipv6 bool) []string {
+	ranges := make([]string, 0)
+	for _, obj := range objs {
+		clusterCIDR, ok := obj.(*networkingv1alpha1.ClusterCIDR)
+		if !ok {
+			continue
+		}
+		cidr := clusterCIDR.Spec.IPv4
+		if ipv6 {
+			cidr = clusterCIDR.Spec.IPv6
+		}
+		if cidr == "" {
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || (ip.To4() == nil) != ipv6 {
+			klog.Errorf("Ignoring invalid range %q of ClusterCIDR %s", cidr, clusterCIDR.Name)
+			continue
+		}
+		ranges = append(ranges, ipNet.String())
+	}
+	return ranges
+}
@@ -0,0 +1,30 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	networkingv1alpha1 "k8s.io/api/networking/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_clusterCIDRRanges(t *testing.T) {
+	objs := []interface{}{
+		&networkingv1alpha1.ClusterCIDR{
+			ObjectMeta: metav1.ObjectMeta{Name: "initial"},
+			Spec:       networkingv1alpha1.ClusterCIDRSpec{IPv4: "10.32.0.0/12", IPv6: "2001:db8:42::/56"},
+		},
+		&networkingv1alpha1.ClusterCIDR{
+			ObjectMeta: metav1.ObjectMeta{Name: "expansion"},
+			Spec:       networkingv1alpha1.ClusterCIDRSpec{IPv4: "100.64.0.1/16"},
+		},
+		&networkingv1alpha1.ClusterCIDR{
+			ObjectMeta: metav1.ObjectMeta{Name: "mixed-families"},
+			Spec:       networkingv1alpha1.ClusterCIDRSpec{IPv4: "2001:db8:43::/56"},
+		},
+	}
+
+	assert.Equal(t, []string{"10.32.0.0/12", "100.64.0.0/16"}, clusterCIDRRanges(objs, false),
+		"expected the valid IPv4 ranges")
+	assert.Equal(t, []string{"2001:db8:42::/56"}, clusterCIDRRanges(objs, true), "expected the IPv6 range")
+}
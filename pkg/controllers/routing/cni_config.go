@@ -0,0 +1,94 @@
+package routing
+
+import (
+	"github.com/cloudnativelabs/kube-router/pkg/cni"
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const cniConfDriftEventReason = "CNIConfDrift"
+
+// chainedCNIPlugins returns the configuration of the plugins that kube-router chains after its own plugin in the CNI
+// config list
+func (nrc *NetworkRoutingController) chainedCNIPlugins() []map[string]interface{} {
+	plugins := make([]map[string]interface{}, 0)
+	if len(nrc.cniTuningSysctls) > 0 {
+		sysctls := make(map[string]interface{}, len(nrc.cniTuningSysctls))
+		for name, value := range nrc.cniTuningSysctls {
+			sysctls[name] = value
+		}
+		plugins = append(plugins, map[string]interface{}{
+			"type":   "tuning",
+			"sysctl": sysctls,
+		})
+	}
+	if nrc.cniBandwidthPlugin {
+		// the bandwidth capability makes the container runtime pass the kubernetes.io/ingress-bandwidth and
+		// kubernetes.io/egress-bandwidth pod annotations to the plugin
+		plugins = append(plugins, map[string]interface{}{
+			"type":         "bandwidth",
+			"capabilities": map[string]interface{}{"bandwidth": true},
+		})
+	}
+	return plugins
+}
+
+// cniConfValues returns the values the CNI conf file is rendered with
+func (nrc *NetworkRoutingController) cniConfValues() (cni.Values, error) {
+	values := cni.Values{
+		PluginType:     cni.PluginTypeBridge,
+		BridgeName:     kubeBridgeIfName,
+		PodCIDRs:       nrc.allPodCIDRs(),
+		ChainedPlugins: nrc.chainedCNIPlugins(),
+	}
+	if nrc.cniMode == options.CNIModePTP {
+		values.PluginType = cni.PluginTypePTP
+	}
+
+	nrc.cniMTUMutex.Lock()
+	values.MTU = nrc.cniMTU
+	nrc.cniMTUMutex.Unlock()
+	if values.MTU == 0 && nrc.autoMTU {
+		mtu, err := utils.GetMTUFromNodeIP(nrc.overlayIP)
+		if err != nil {
+			klog.Errorf("Failed to auto-configure MTU in CNI conf file due to: %s", err.Error())
+		} else {
+			values.MTU = mtu
+		}
+	}
+	return values, nil
+}
+
+// setCNIMTU pins the MTU in the CNI conf file to the given value instead of the auto detected one and applies it
+func (nrc *NetworkRoutingController) setCNIMTU(mtu int) error {
+	nrc.cniMTUMutex.Lock()
+	nrc.cniMTU = mtu
+	nrc.cniMTUMutex.Unlock()
+	_, err := nrc.cniConfig.Sync()
+	return err
+}
+
+// newCNIConfigManager returns the manager of the CNI conf file, which reports repairs of the file with a metric and an
+// Event on the node
+func (nrc *NetworkRoutingController) newCNIConfigManager(clientset kubernetes.Interface,
+	templatePath string) (*cni.ConfigManager, error) {
+	cm, err := cni.NewConfigManager(nrc.cniConfFile, templatePath, nrc.cniConfValues)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := utils.NewEventRecorder(clientset, nrc.nodeName)
+	nodeRef := utils.NodeObjectReference(nrc.nodeName)
+	cm.DriftHandler = func(path string) {
+		if nrc.MetricsEnabled {
+			metrics.ControllerCNIConfDrift.Inc()
+		}
+		recorder.Eventf(nodeRef, v1core.EventTypeWarning, cniConfDriftEventReason,
+			"CNI conf file %s was modified externally and repaired", path)
+	}
+	return cm, nil
+}
@@ -0,0 +1,223 @@
+package routing
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// the penalty of a flap and the thresholds recommended by RFC 2439, which are also the defaults of most routers
+	dampeningFlapPenalty       = 1000
+	dampeningSuppressThreshold = 2000
+	dampeningReuseThreshold    = 750
+	// the penalty is capped so that a route is suppressed for at most this many half-lives
+	dampeningMaxSuppressHalfLives = 4
+	dampeningReuseCheckPeriod     = 10 * time.Second
+)
+
+// flapDampener implements the route flap dampening of RFC 2439. Every time a route that was announced is withdrawn
+// its penalty is increased, and the penalty decays exponentially with the half-life. Once the penalty exceeds the
+// suppress threshold the announcements of the route are suppressed, until the penalty has decayed below the reuse
+// threshold. The last announcement suppressed is then handed to onReuse so that the route can be brought back.
+type flapDampener struct {
+	halfLife time.Duration
+	onReuse  func(reused map[string]interface{})
+	now      func() time.Time
+
+	mu     sync.Mutex
+	routes map[string]*dampenedRoute
+}
+
+type dampenedRoute struct {
+	penalty    float64
+	updated    time.Time
+	announced  bool
+	suppressed bool
+	// the last announcement of the route while it was suppressed
+	deferred interface{}
+}
+
+func newFlapDampener(halfLife time.Duration, onReuse func(reused map[string]interface{})) *flapDampener {
+	return &flapDampener{
+		halfLife: halfLife,
+		onReuse:  onReuse,
+		now:      time.Now,
+		routes:   make(map[string]*dampenedRoute),
+	}
+}
+
+// decay brings the penalty of the route up to date, it must be called with the lock held
+func (d *flapDampener) decay(route *dampenedRoute, now time.Time) {
+	route.penalty *= math.Pow(0.5, float64(now.Sub(route.updated))/float64(d.halfLife))
+	route.updated = now
+}
+
+// announce records the announcement of the route and reports whether it is suppressed, in which case the caller
+// mustn't act on the announcement and deferred is handed to onReuse once the route is reused instead
+func (d *flapDampener) announce(key string, deferred interface{}) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	route, ok := d.routes[key]
+	if !ok {
+		route = &dampenedRoute{updated: now}
+		d.routes[key] = route
+	}
+	d.decay(route, now)
+	if route.suppressed && route.penalty < dampeningReuseThreshold {
+		route.suppressed = false
+	}
+	if route.suppressed {
+		route.deferred = deferred
+		return true
+	}
+	route.announced = true
+	return false
+}
+
+// withdraw records the withdrawal of the route, which counts as a flap when the route was announced
+func (d *flapDampener) withdraw(key string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	route, ok := d.routes[key]
+	if !ok || (!route.announced && route.deferred == nil) {
+		return
+	}
+	now := d.now()
+	d.decay(route, now)
+	maxPenalty := dampeningReuseThreshold * math.Pow(2, dampeningMaxSuppressHalfLives)
+	route.penalty = math.Min(route.penalty+dampeningFlapPenalty, maxPenalty)
+	route.announced = false
+	route.deferred = nil
+	if !route.suppressed && route.penalty > dampeningSuppressThreshold {
+		klog.Warningf("Route %s is flapping, suppressing its announcements until it is stable", key)
+		route.suppressed = true
+	}
+}
+
+// reuse brings back the suppressed routes whose penalty decayed below the reuse threshold, and forgets the withdrawn
+// routes whose penalty decayed below half of it
+func (d *flapDampener) reuse() {
+	reused := make(map[string]interface{})
+	d.mu.Lock()
+	now := d.now()
+	for key, route := range d.routes {
+		d.decay(route, now)
+		if route.suppressed && route.penalty < dampeningReuseThreshold {
+			klog.Infof("Route %s is stable again, no longer suppressing its announcements", key)
+			route.suppressed = false
+			if route.deferred != nil {
+				reused[key] = route.deferred
+				route.deferred = nil
+				route.announced = true
+			}
+		}
+		if !route.announced && !route.suppressed && route.penalty < dampeningReuseThreshold/2 {
+			delete(d.routes, key)
+		}
+	}
+	d.mu.Unlock()
+
+	if len(reused) > 0 {
+		d.onReuse(reused)
+	}
+}
+
+// run starts a goroutine that brings back the suppressed routes once they can be reused
+func (d *flapDampener) run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	if d == nil {
+		return
+	}
+	wg.Add(1)
+	go func(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		t := time.NewTicker(dampeningReuseCheckPeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				d.reuse()
+			case <-stopCh:
+				klog.Infof("Shutting down route flap dampening")
+				return
+			}
+		}
+	}(stopCh, wg)
+}
+
+// dampenPaths filters the paths of a table event to the destinations whose routes aren't suppressed for flapping. The
+// paths to the suppressed destinations are installed once the routes are reused. Only the routes learned from the
+// external peers are dampened, the routes to the pod CIDRs of the other nodes never are.
+func (nrc *NetworkRoutingController) dampenPaths(paths []*gobgpapi.Path) []*gobgpapi.Path {
+	if nrc.routeDampener == nil {
+		return paths
+	}
+	undampened := make([]*gobgpapi.Path, 0, len(paths))
+	for _, dstPaths := range groupPathsByDestination(paths) {
+		if !nrc.isExternalPeer(dstPaths[0].NeighborIp) {
+			undampened = append(undampened, dstPaths...)
+			continue
+		}
+		dst, _, _ := parseBGPPath(dstPaths[0])
+		withdrawn := true
+		for _, path := range dstPaths {
+			withdrawn = withdrawn && path.IsWithdraw
+		}
+		if withdrawn {
+			nrc.routeDampener.withdraw(dst.String())
+		} else if nrc.routeDampener.announce(dst.String(), dstPaths) {
+			klog.V(1).Infof("Not installing the route to %s learned from %s as it is suppressed for flapping",
+				dst, dstPaths[0].NeighborIp)
+			continue
+		}
+		undampened = append(undampened, dstPaths...)
+	}
+	return undampened
+}
+
+// reuseDampenedPaths installs the routes learned from the external peers once they are no longer suppressed
+func (nrc *NetworkRoutingController) reuseDampenedPaths(reused map[string]interface{}) {
+	for _, deferred := range reused {
+		if paths, ok := deferred.([]*gobgpapi.Path); ok {
+			nrc.injectPaths(paths)
+		}
+	}
+}
+
+// isExternalPeer tells whether the neighbor is one of the external BGP peers, be it a global peer, a node specific
+// peer, the peer of a BGPPeer resource or a dynamic neighbor
+func (nrc *NetworkRoutingController) isExternalPeer(neighbor string) bool {
+	ip := net.ParseIP(neighbor)
+	if ip == nil {
+		return false
+	}
+	for _, peer := range nrc.externalPeers() {
+		if peer.Conf != nil && ip.Equal(net.ParseIP(peer.Conf.NeighborAddress)) {
+			return true
+		}
+	}
+	for _, peer := range nrc.nodePeerRouters {
+		if ip.Equal(net.ParseIP(peer)) {
+			return true
+		}
+	}
+	for _, r := range nrc.dynamicNeighborRanges {
+		if r.prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package routing
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_flapDampener(t *testing.T) {
+	halfLife := 15 * time.Minute
+	now := time.Unix(0, 0)
+	var reused map[string]interface{}
+	d := newFlapDampener(halfLife, func(r map[string]interface{}) { reused = r })
+	d.now = func() time.Time { return now }
+
+	d.withdraw("10.0.0.0/24")
+	if _, ok := d.routes["10.0.0.0/24"]; ok {
+		t.Errorf("expected the withdrawal of a route that was never announced not to be recorded")
+	}
+
+	// the third flap in a row takes the penalty above the suppress threshold
+	for i := 0; i < 3; i++ {
+		if d.announce("10.0.0.0/24", "announcement") {
+			t.Fatalf("expected announcement %d not to be suppressed", i)
+		}
+		d.withdraw("10.0.0.0/24")
+	}
+	if !d.announce("10.0.0.0/24", "last announcement") {
+		t.Fatalf("expected the announcement of a route that flapped 3 times to be suppressed")
+	}
+	if d.announce("10.0.1.0/24", "announcement") {
+		t.Errorf("expected the announcement of another route not to be suppressed")
+	}
+
+	// a penalty of 3000 takes 2 half-lives to decay down to the reuse threshold
+	now = now.Add(2*halfLife - time.Minute)
+	d.reuse()
+	if reused != nil {
+		t.Errorf("expected the route not to be reused before its penalty decayed, got: %v", reused)
+	}
+	now = now.Add(2 * time.Minute)
+	d.reuse()
+	if reused["10.0.0.0/24"] != "last announcement" {
+		t.Errorf("expected the last suppressed announcement to be reused, got: %v", reused)
+	}
+	if d.announce("10.0.0.0/24", "announcement") {
+		t.Errorf("expected the announcement of a reused route not to be suppressed")
+	}
+
+	// however often a route flaps it is suppressed for at most 4 half-lives
+	reused = nil
+	for i := 0; i < 100; i++ {
+		d.announce("10.0.2.0/24", "announcement")
+		d.withdraw("10.0.2.0/24")
+	}
+	d.announce("10.0.2.0/24", "last announcement")
+	now = now.Add(4*halfLife + time.Minute)
+	d.reuse()
+	if reused["10.0.2.0/24"] != "last announcement" {
+		t.Errorf("expected the route to be reused after 4 half-lives, got: %v", reused)
+	}
+
+	var nilDampener *flapDampener
+	if nilDampener.announce("10.0.0.0/24", "announcement") {
+		t.Errorf("expected nothing to be suppressed without dampening")
+	}
+}
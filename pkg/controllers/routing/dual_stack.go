@@ -0,0 +1,153 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"google.golang.org/protobuf/proto"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// the prefix sets of the policies that hold the prefixes of a single address family
+var familyPrefixSets = []string{"podcidrdefinedset", "servicevipsdefinedset", "defaultroutedefinedset",
+	"customimportrejectdefinedset", podCIDRSummarySet, podCIDRSummarySuppressSet}
+
+// selectDualStackAddress selects the address of the node of the other address family than the node IP on dual-stack
+// nodes, it is the next hop of the routes of that family the node advertises. Nodes without an address of the other
+// family are routed for the family of the node IP only.
+func (nrc *NetworkRoutingController) selectDualStackAddress(node *v1core.Node) {
+	nrc.nodeDualStackIP = nil
+	if !nrc.enableDualStack {
+		return
+	}
+	ip, err := utils.GetNodeIPOfFamily(node, !nrc.isIpv6)
+	if err != nil {
+		klog.Warningf("Dual-stack is enabled but node %s has no address of the other address family than %s, "+
+			"only routing the address family of the node IP", node.Name, nrc.nodeIP)
+		return
+	}
+	subnet, _, err := getNodeSubnet(ip)
+	if err != nil {
+		klog.Warningf("Failed to find the subnet of the dual-stack address %s of the node, only routing the "+
+			"address family of the node IP: %v", ip, err)
+		return
+	}
+	nrc.nodeDualStackIP = ip
+	nrc.nodeDualStackSubnet = subnet
+}
+
+// addressFamilies returns the address families the node routes as ipv6 flags, the family of the node IP first
+func (nrc *NetworkRoutingController) addressFamilies() []bool {
+	if nrc.nodeDualStackIP == nil {
+		return []bool{nrc.isIpv6}
+	}
+	return []bool{nrc.isIpv6, !nrc.isIpv6}
+}
+
+// routesFamilyOf returns true if the node routes the address family of the given IP
+func (nrc *NetworkRoutingController) routesFamilyOf(ip net.IP) bool {
+	return (ip.To4() == nil) == nrc.isIpv6 || nrc.nodeDualStackIP != nil
+}
+
+// nodeAddressOfFamily returns the address of the node in the given address family: nodeIP, an address of the node
+// in the family of the node IP, for that family and the dual-stack address otherwise, nil when there is none
+func (nrc *NetworkRoutingController) nodeAddressOfFamily(ipv6 bool, nodeIP net.IP) net.IP {
+	if ipv6 == nrc.isIpv6 {
+		return nodeIP
+	}
+	return nrc.nodeDualStackIP
+}
+
+// prefixSetName returns the name of the prefix set holding the prefixes of the given address family, the sets of the
+// family of the node IP keep their name
+func (nrc *NetworkRoutingController) prefixSetName(name string, ipv6 bool) string {
+	switch {
+	case ipv6 == nrc.isIpv6:
+		return name
+	case ipv6:
+		return name + "-ipv6"
+	default:
+		return name + "-ipv4"
+	}
+}
+
+// withDualStackStatements follows each statement of a policy that matches one of the single family prefix sets with
+// the same statement for the set of the other address family on dual-stack nodes
+func (nrc *NetworkRoutingController) withDualStackStatements(statements []*gobgpapi.Statement) []*gobgpapi.Statement {
+	if nrc.nodeDualStackIP == nil {
+		return statements
+	}
+	withDualStack := make([]*gobgpapi.Statement, 0, 2*len(statements))
+	for _, statement := range statements {
+		withDualStack = append(withDualStack, statement)
+		if statement.Conditions == nil || statement.Conditions.PrefixSet == nil {
+			continue
+		}
+		for _, name := range familyPrefixSets {
+			if statement.Conditions.PrefixSet.Name != name {
+				continue
+			}
+			dualStack, ok := proto.Clone(statement).(*gobgpapi.Statement)
+			if !ok {
+				continue
+			}
+			dualStack.Conditions.PrefixSet.Name = nrc.prefixSetName(name, !nrc.isIpv6)
+			withDualStack = append(withDualStack, dualStack)
+		}
+	}
+	return withDualStack
+}
+
+// unicastFamily returns the unicast BGP family of the given address family
+func unicastFamily(ipv6 bool) *gobgpapi.Family {
+	if ipv6 {
+		return &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST}
+	}
+	return &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST}
+}
+
+// hostPrefixLen returns the prefix length of a single address of the given address family
+func hostPrefixLen(ipv6 bool) uint32 {
+	if ipv6 {
+		return 128
+	}
+	return 32
+}
+
+// localAddressOfFamily returns the local address of the session with an external peer, the peer's own local address
+// when it is of the family of the peer and the dual-stack address of the node otherwise
+func (nrc *NetworkRoutingController) localAddressOfFamily(peer *gobgpapi.Peer) (string, error) {
+	neighbor := net.ParseIP(peer.Conf.NeighborAddress)
+	local := net.ParseIP(peer.Transport.LocalAddress)
+	if neighbor == nil || local == nil || (neighbor.To4() == nil) == (local.To4() == nil) {
+		return peer.Transport.LocalAddress, nil
+	}
+	if ip := nrc.nodeAddressOfFamily(neighbor.To4() == nil, nil); ip != nil {
+		return ip.String(), nil
+	}
+	return "", fmt.Errorf("the node has no address of the address family of peer %s", neighbor)
+}
+
+// inNodeSubnet returns true if the next hop is in the subnet of the node address of its address family
+func (nrc *NetworkRoutingController) inNodeSubnet(nextHop net.IP) bool {
+	if nrc.nodeDualStackIP != nil && (nextHop.To4() == nil) != nrc.isIpv6 {
+		return nrc.nodeDualStackSubnet.Contains(nextHop)
+	}
+	return nrc.nodeSubnet.Contains(nextHop)
+}
+
+// isIPv6Family returns true for the BGP families of IPv6
+func isIPv6Family(family *gobgpapi.Family) bool {
+	return family.Afi == gobgpapi.Family_AFI_IP6
+}
+
+// bgpAddresses returns the addresses BGP listens on by default, the BGP address and the dual-stack address of the node
+func (nrc *NetworkRoutingController) bgpAddresses() []string {
+	if nrc.nodeDualStackIP == nil {
+		return []string{nrc.bgpIP.String()}
+	}
+	return []string{nrc.bgpIP.String(), nrc.nodeDualStackIP.String()}
+}
@@ -0,0 +1,134 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_withDualStackStatements(t *testing.T) {
+	statements := []*gobgpapi.Statement{
+		{
+			Conditions: &gobgpapi.Conditions{PrefixSet: &gobgpapi.MatchSet{Name: "podcidrdefinedset"}},
+			Actions:    &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_ACCEPT},
+		},
+		{
+			Conditions: &gobgpapi.Conditions{NeighborSet: &gobgpapi.MatchSet{Name: "iBGPpeerset"}},
+			Actions:    &gobgpapi.Actions{RouteAction: gobgpapi.RouteAction_REJECT},
+		},
+	}
+
+	nrc := &NetworkRoutingController{}
+	if got := nrc.withDualStackStatements(statements); !reflect.DeepEqual(got, statements) {
+		t.Errorf("expected the statements to be left as is on single stack nodes, got %v", got)
+	}
+
+	nrc.nodeDualStackIP = net.ParseIP("2001:db8::10")
+	got := nrc.withDualStackStatements(statements)
+	names := make([]string, 0, len(got))
+	for _, statement := range got {
+		if statement.Conditions.PrefixSet != nil {
+			names = append(names, statement.Conditions.PrefixSet.Name)
+		} else {
+			names = append(names, statement.Conditions.NeighborSet.Name)
+		}
+	}
+	expected := []string{"podcidrdefinedset", "podcidrdefinedset-ipv6", "iBGPpeerset"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the statements matching %v, got %v", expected, names)
+	}
+	if got[1].Actions.RouteAction != gobgpapi.RouteAction_ACCEPT {
+		t.Errorf("expected the statement of the IPv6 prefix set to keep the action, got %v", got[1].Actions)
+	}
+	if statements[0].Conditions.PrefixSet.Name != "podcidrdefinedset" {
+		t.Error("expected the original statement to be left unchanged")
+	}
+}
+
+func Test_dualStackVIPs(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err = svcLister.Add(&v1core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default"},
+		Spec: v1core.ServiceSpec{Type: ClusterIPST, ClusterIP: "10.96.0.1",
+			ClusterIPs: []string{"10.96.0.1", "2001:db8:96::1"}},
+	}); err != nil {
+		t.Fatalf("failed to add the service: %v", err)
+	}
+	nrc := &NetworkRoutingController{
+		bgpServer:          bgpServer,
+		svcLister:          svcLister,
+		advertiseClusterIP: true,
+		nodeIP:             net.ParseIP("10.0.0.10"),
+		nodeDualStackIP:    net.ParseIP("2001:db8::10"),
+	}
+
+	for _, ipv6 := range nrc.addressFamilies() {
+		if err = nrc.addServiceVIPsDefinedSet(ipv6); err != nil {
+			t.Fatalf("failed to add the service VIPs defined set: %v", err)
+		}
+	}
+	expectedSets := map[string]string{
+		"servicevipsdefinedset":      "10.96.0.1/32",
+		"servicevipsdefinedset-ipv6": "2001:db8:96::1/128",
+	}
+	for name, prefix := range expectedSets {
+		var prefixes []string
+		err = bgpServer.ListDefinedSet(context.Background(), &gobgpapi.ListDefinedSetRequest{
+			DefinedType: gobgpapi.DefinedType_PREFIX, Name: name}, func(ds *gobgpapi.DefinedSet) {
+			for _, p := range ds.Prefixes {
+				prefixes = append(prefixes, p.IpPrefix)
+			}
+		})
+		if err != nil {
+			t.Fatalf("failed to list the defined set %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(prefixes, []string{prefix}) {
+			t.Errorf("expected %s in the defined set %s, got %v", prefix, name, prefixes)
+		}
+	}
+
+	nrc.advertiseVIPs(nrc.getClusterIPs(svcLister.List()[0].(*v1core.Service)))
+	var nextHops []string
+	err = bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+		TableType: gobgpapi.TableType_GLOBAL, Family: unicastFamily(true)}, func(d *gobgpapi.Destination) {
+		for _, path := range d.Paths {
+			nextHop, err := parseBGPNextHop(path)
+			if err != nil {
+				t.Errorf("failed to parse the next hop of %s: %v", d.Prefix, err)
+				continue
+			}
+			nextHops = append(nextHops, d.Prefix+" via "+nextHop.String())
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to list the IPv6 paths: %v", err)
+	}
+	if expected := []string{"2001:db8:96::1/128 via 2001:db8::10"}; !reflect.DeepEqual(nextHops, expected) {
+		t.Errorf("expected the IPv6 cluster IP to be advertised as %v, got %v", expected, nextHops)
+	}
+
+	nrc.nodeDualStackIP = nil
+	if nrc.routesFamilyOf(net.ParseIP("2001:db8:96::1")) {
+		t.Error("expected a single stack node not to route the IPv6 VIPs")
+	}
+}
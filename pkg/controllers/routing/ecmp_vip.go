@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
 
 	"google.golang.org/protobuf/types/known/anypb"
@@ -20,27 +21,37 @@ import (
 	"k8s.io/klog/v2"
 )
 
-// bgpAdvertiseVIP advertises the service vip (cluster ip or load balancer ip or external IP) the configured peers
-func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string) error {
-
+// bgpAdvertiseVIP advertises the service vip (cluster ip or load balancer ip or external IP) the configured peers,
+// with the given BGP communities and weighted by the given number of local endpoints when it isn't 0
+func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string, communities []uint32, endpoints uint32) error {
+	family, nlri, nextHop, err := nrc.vipPathOf(vip)
+	if err != nil {
+		return err
+	}
 	klog.V(2).Infof("Advertising route: '%s/%s via %s' to peers",
-		vip, strconv.Itoa(32), nrc.nodeIP.String())
+		vip, strconv.Itoa(int(hostPrefixLen(isIPv6Family(family)))), nextHop)
 
-	a1, _ := anypb.New(&gobgpapi.OriginAttribute{
-		Origin: 0,
-	})
-	a2, _ := anypb.New(&gobgpapi.NextHopAttribute{
-		NextHop: nrc.nodeIP.String(),
-	})
-	attrs := []*anypb.Any{a1, a2}
-	nlri1, _ := anypb.New(&gobgpapi.IPAddressPrefix{
-		Prefix:    vip,
-		PrefixLen: 32,
-	})
-	_, err := nrc.bgpServer.AddPath(context.Background(), &gobgpapi.AddPathRequest{
+	attrs := nrc.vipPathAttributes(family, nlri, nextHop)
+	if len(communities) > 0 {
+		a3, err := newCommunitiesAttribute(communities)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, a3)
+	}
+	if endpoints > 0 {
+		a4, err := nrc.newVIPWeightAttribute(endpoints)
+		if err != nil {
+			return err
+		}
+		if a4 != nil {
+			attrs = append(attrs, a4)
+		}
+	}
+	_, err = nrc.bgpServer.AddPath(context.Background(), &gobgpapi.AddPathRequest{
 		Path: &gobgpapi.Path{
-			Family: &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
-			Nlri:   nlri1,
+			Family: family,
+			Nlri:   nlri,
 			Pattrs: attrs,
 		},
 	})
@@ -54,26 +65,19 @@ func (nrc *NetworkRoutingController) bgpAdvertiseVIP(vip string) error {
 
 // bgpWithdrawVIP  unadvertises the service vip
 func (nrc *NetworkRoutingController) bgpWithdrawVIP(vip string) error {
+	family, nlri, nextHop, err := nrc.vipPathOf(vip)
+	if err != nil {
+		return err
+	}
 	klog.V(2).Infof("Withdrawing route: '%s/%s via %s' to peers",
-		vip, strconv.Itoa(32), nrc.nodeIP.String())
+		vip, strconv.Itoa(int(hostPrefixLen(isIPv6Family(family)))), nextHop)
 
-	a1, _ := anypb.New(&gobgpapi.OriginAttribute{
-		Origin: 0,
-	})
-	a2, _ := anypb.New(&gobgpapi.NextHopAttribute{
-		NextHop: nrc.nodeIP.String(),
-	})
-	attrs := []*anypb.Any{a1, a2}
-	nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{
-		Prefix:    vip,
-		PrefixLen: 32,
-	})
 	path := gobgpapi.Path{
-		Family: &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+		Family: family,
 		Nlri:   nlri,
-		Pattrs: attrs,
+		Pattrs: nrc.vipPathAttributes(family, nlri, nextHop),
 	}
-	err := nrc.bgpServer.DeletePath(context.Background(), &gobgpapi.DeletePathRequest{
+	err = nrc.bgpServer.DeletePath(context.Background(), &gobgpapi.DeletePathRequest{
 		TableType: gobgpapi.TableType_GLOBAL,
 		Path:      &path,
 	})
@@ -85,9 +89,60 @@ func (nrc *NetworkRoutingController) bgpWithdrawVIP(vip string) error {
 	return err
 }
 
+// vipPathOf returns the family, NLRI and next hop of the route of a VIP, the VIPs of the other address family than
+// the node IP are routed through the address of that family of dual-stack nodes
+func (nrc *NetworkRoutingController) vipPathOf(vip string) (*gobgpapi.Family, *anypb.Any, string, error) {
+	ip := net.ParseIP(vip)
+	if ip == nil {
+		return nil, nil, "", fmt.Errorf("invalid VIP %s", vip)
+	}
+	ipv6 := ip.To4() == nil
+	nextHop := nrc.nodeAddressOfFamily(ipv6, nrc.nodeIP)
+	if nextHop == nil {
+		return nil, nil, "", fmt.Errorf("no address of the node to route the VIP %s through", vip)
+	}
+	nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{
+		Prefix:    vip,
+		PrefixLen: hostPrefixLen(ipv6),
+	})
+	return unicastFamily(ipv6), nlri, nextHop.String(), nil
+}
+
+// vipPathAttributes returns the origin and next hop attributes of the route of a VIP, the next hop of IPv6 routes
+// is carried in the MP_REACH_NLRI attribute
+func (nrc *NetworkRoutingController) vipPathAttributes(family *gobgpapi.Family, nlri *anypb.Any,
+	nextHop string) []*anypb.Any {
+	a1, _ := anypb.New(&gobgpapi.OriginAttribute{
+		Origin: 0,
+	})
+	if isIPv6Family(family) {
+		a2, _ := anypb.New(&gobgpapi.MpReachNLRIAttribute{
+			Family:   family,
+			NextHops: []string{nextHop},
+			Nlris:    []*anypb.Any{nlri},
+		})
+		return []*anypb.Any{a1, a2}
+	}
+	a2, _ := anypb.New(&gobgpapi.NextHopAttribute{
+		NextHop: nextHop,
+	})
+	return []*anypb.Any{a1, a2}
+}
+
 func (nrc *NetworkRoutingController) advertiseVIPs(vips []string) {
+	communities := nrc.vipCommunities()
+	weights := nrc.vipWeights()
 	for _, vip := range vips {
-		err := nrc.bgpAdvertiseVIP(vip)
+		if ip := net.ParseIP(vip); ip != nil && !nrc.routesFamilyOf(ip) {
+			klog.V(2).Infof("Not advertising %s as the node does not route its address family", vip)
+			continue
+		}
+		// VIPs withdrawn too often, e.g. because the endpoints of a local service keep flapping, are held down
+		if nrc.vipDampener.announce(vip, true) {
+			klog.V(1).Infof("Not advertising %s as it is suppressed for flapping", vip)
+			continue
+		}
+		err := nrc.bgpAdvertiseVIP(vip, communities[vip], weights[vip])
 		if err != nil {
 			klog.Errorf("error advertising IP: %q, error: %v", vip, err)
 		}
@@ -96,6 +151,10 @@ func (nrc *NetworkRoutingController) advertiseVIPs(vips []string) {
 
 func (nrc *NetworkRoutingController) withdrawVIPs(vips []string) {
 	for _, vip := range vips {
+		if ip := net.ParseIP(vip); ip != nil && !nrc.routesFamilyOf(ip) {
+			continue
+		}
+		nrc.vipDampener.withdraw(vip)
 		err := nrc.bgpWithdrawVIP(vip)
 		if err != nil {
 			klog.Errorf("error withdrawing IP: %q, error: %v", vip, err)
@@ -147,6 +206,24 @@ func (nrc *NetworkRoutingController) handleServiceUpdate(svc *v1core.Service) {
 	nrc.withdrawVIPs(toWithdraw)
 }
 
+// syncVIPAdvertisements advertises and withdraws the VIPs of all services after the signals gating their
+// advertisement changed
+func (nrc *NetworkRoutingController) syncVIPAdvertisements() {
+	if !nrc.bgpServerStarted {
+		klog.V(3).Info("Skipping sync of the VIP advertisements, controller still performing bootup full-sync")
+		return
+	}
+
+	toAdvertise, toWithdraw, err := nrc.getActiveVIPs()
+	if err != nil {
+		klog.Errorf("error getting routes for services: %s", err)
+		return
+	}
+
+	nrc.advertiseVIPs(toAdvertise)
+	nrc.withdrawVIPs(toWithdraw)
+}
+
 func (nrc *NetworkRoutingController) handleServiceDelete(svc *v1core.Service) {
 
 	if !nrc.bgpServerStarted {
@@ -294,8 +371,13 @@ func (nrc *NetworkRoutingController) newEndpointsEventHandler() cache.ResourceEv
 			nrc.OnEndpointsUpdate(newObj)
 		},
 		DeleteFunc: func(obj interface{}) {
-			// don't do anything if an endpoints resource is deleted since
-			// the service delete event handles route withdrawals
+			// the service delete event handles the route withdrawals of deleted services, but the Endpoints of a
+			// service that still exists can go away too, e.g. when its selector is removed, taking the local
+			// endpoints the VIPs of local services are advertised for with them
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			nrc.OnEndpointsUpdate(obj)
 		},
 	}
 }
@@ -347,16 +429,20 @@ func (nrc *NetworkRoutingController) OnEndpointsUpdate(obj interface{}) {
 	nrc.tryHandleServiceUpdate(svc, "Updating service %s/%s triggered by endpoint update event")
 }
 
-func (nrc *NetworkRoutingController) getClusterIP(svc *v1core.Service) string {
-	clusterIP := ""
+// getClusterIPs returns the cluster IPs of the service, the cluster IP of each address family of dual-stack services
+func (nrc *NetworkRoutingController) getClusterIPs(svc *v1core.Service) []string {
+	clusterIPs := make([]string, 0)
 	if svc.Spec.Type == ClusterIPST || svc.Spec.Type == NodePortST || svc.Spec.Type == LoadBalancerST {
 
 		// skip headless services
 		if !utils.ClusterIPIsNoneOrBlank(svc.Spec.ClusterIP) {
-			clusterIP = svc.Spec.ClusterIP
+			if len(svc.Spec.ClusterIPs) == 0 {
+				return append(clusterIPs, svc.Spec.ClusterIP)
+			}
+			clusterIPs = append(clusterIPs, svc.Spec.ClusterIPs...)
 		}
 	}
-	return clusterIP
+	return clusterIPs
 }
 
 func (nrc *NetworkRoutingController) getExternalIPs(svc *v1core.Service) []string {
@@ -415,6 +501,17 @@ func (nrc *NetworkRoutingController) getVIPs(onlyActiveEndpoints bool) ([]string
 		}
 	}
 
+	// the listeners of the Gateways are served by the proxy of every node, like the LoadBalancer IPs of the services
+	// with the cluster traffic policy
+	if nrc.gatewayLister != nil {
+		gatewayVIPs := nrc.gatewayVIPs()
+		if nrc.advertiseLoadBalancerIP && !(onlyActiveEndpoints && nrc.withdrawsVIPs()) {
+			toAdvertiseList = append(toAdvertiseList, gatewayVIPs...)
+		} else {
+			toWithdrawList = append(toWithdrawList, gatewayVIPs...)
+		}
+	}
+
 	// We need to account for the niche case where multiple services may have the same VIP, in this case, one service
 	// might be ready while the other service is not. We still want to advertise the VIP as long as there is at least
 	// one active endpoint on the node or we might introduce a service disruption.
@@ -450,11 +547,7 @@ func (nrc *NetworkRoutingController) getVIPsForService(svc *v1core.Service,
 
 	advertise := true
 
-	_, hasLocalAnnotation := svc.Annotations[svcLocalAnnotation]
-	hasLocalTrafficPolicy := svc.Spec.ExternalTrafficPolicy == v1core.ServiceExternalTrafficPolicyTypeLocal
-	isLocal := hasLocalAnnotation || hasLocalTrafficPolicy
-
-	if onlyActiveEndpoints && isLocal {
+	if onlyActiveEndpoints && serviceIsLocal(svc) {
 		var err error
 		advertise, err = nrc.nodeHasEndpointsForService(svc)
 		if err != nil {
@@ -462,6 +555,16 @@ func (nrc *NetworkRoutingController) getVIPsForService(svc *v1core.Service,
 		}
 	}
 
+	// services can gate their advertisement by this node on health checks and Leases, e.g. to fail over between nodes
+	if onlyActiveEndpoints && advertise {
+		advertise = nrc.advertiseGates.allowAdvertisement(svc)
+	}
+
+	// the traffic of the services is moved to the other nodes before cordoned nodes are drained and shut down
+	if onlyActiveEndpoints && advertise && nrc.withdrawsVIPs() {
+		advertise = false
+	}
+
 	advertiseIPList, unAdvertisedIPList := nrc.getAllVIPsForService(svc)
 
 	if !advertise {
@@ -478,18 +581,21 @@ func (nrc *NetworkRoutingController) getAllVIPsForService(svc *v1core.Service) (
 	advertisedIPList := make([]string, 0)
 	unAdvertisedIPList := make([]string, 0)
 
-	clusterIP := nrc.getClusterIP(svc)
-	if clusterIP != "" {
+	clusterIPs := nrc.getClusterIPs(svc)
+	if len(clusterIPs) > 0 {
 		if nrc.shouldAdvertiseService(svc, svcAdvertiseClusterAnnotation, nrc.advertiseClusterIP) {
-			advertisedIPList = append(advertisedIPList, clusterIP)
+			advertisedIPList = append(advertisedIPList, clusterIPs...)
 		} else {
-			unAdvertisedIPList = append(unAdvertisedIPList, clusterIP)
+			unAdvertisedIPList = append(unAdvertisedIPList, clusterIPs...)
 		}
 	}
 
+	// the external and LoadBalancer IPs of services pinned to some nodes are only advertised by those nodes
+	servedByNode := nrc.nodeServesServiceVIPs(svc)
+
 	externalIPs := nrc.getExternalIPs(svc)
 	if len(externalIPs) > 0 {
-		if nrc.shouldAdvertiseService(svc, svcAdvertiseExternalAnnotation, nrc.advertiseExternalIP) {
+		if servedByNode && nrc.shouldAdvertiseService(svc, svcAdvertiseExternalAnnotation, nrc.advertiseExternalIP) {
 			advertisedIPList = append(advertisedIPList, externalIPs...)
 		} else {
 			unAdvertisedIPList = append(unAdvertisedIPList, externalIPs...)
@@ -502,7 +608,7 @@ func (nrc *NetworkRoutingController) getAllVIPsForService(svc *v1core.Service) (
 		_, skiplbips := svc.Annotations[svcSkipLbIpsAnnotation]
 		advertiseLoadBalancer := nrc.shouldAdvertiseService(svc, svcAdvertiseLoadBalancerAnnotation,
 			nrc.advertiseLoadBalancerIP)
-		if advertiseLoadBalancer && !skiplbips {
+		if servedByNode && advertiseLoadBalancer && !skiplbips {
 			advertisedIPList = append(advertisedIPList, lbIPs...)
 		} else {
 			unAdvertisedIPList = append(unAdvertisedIPList, lbIPs...)
@@ -513,47 +619,85 @@ func (nrc *NetworkRoutingController) getAllVIPsForService(svc *v1core.Service) (
 
 }
 
+// nodeServesServiceVIPs checks whether this node is selected by the service to advertise its external and
+// LoadBalancer IPs
+func (nrc *NetworkRoutingController) nodeServesServiceVIPs(svc *v1core.Service) bool {
+	if _, ok := svc.Annotations[utils.SvcVIPNodeSelectorAnnotation]; !ok {
+		return true
+	}
+	var nodeLabels map[string]string
+	obj, exists, err := nrc.nodeLister.GetByKey(nrc.nodeName)
+	if err != nil || !exists {
+		klog.Errorf("Failed to get node %s from the cache to match it against the node selector of the service "+
+			"%s/%s: %v", nrc.nodeName, svc.Namespace, svc.Name, err)
+	} else {
+		nodeLabels = obj.(*v1core.Node).Labels
+	}
+	served, err := utils.ServiceVIPsServedByNode(svc, nodeLabels)
+	if err != nil {
+		klog.Errorf("Not advertising the external and LoadBalancer IPs of the service: %v", err)
+	}
+	return served
+}
+
 func isEndpointsForLeaderElection(ep *v1core.Endpoints) bool {
 	_, isLeaderElection := ep.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]
 	return isLeaderElection
 }
 
+// serviceIsLocal returns true for the services whose VIPs are only advertised by the nodes with endpoints of the
+// service
+func serviceIsLocal(svc *v1core.Service) bool {
+	_, hasLocalAnnotation := svc.Annotations[svcLocalAnnotation]
+	hasLocalTrafficPolicy := svc.Spec.ExternalTrafficPolicy == v1core.ServiceExternalTrafficPolicyTypeLocal
+	return hasLocalAnnotation || hasLocalTrafficPolicy
+}
+
 // nodeHasEndpointsForService will get the corresponding Endpoints resource for a given Service
 // return true if any endpoint addresses has NodeName matching the node name of the route controller
 func (nrc *NetworkRoutingController) nodeHasEndpointsForService(svc *v1core.Service) (bool, error) {
+	endpoints, err := nrc.nodeEndpointsOfService(svc)
+	return endpoints > 0, err
+}
+
+// nodeEndpointsOfService returns the number of ready endpoint addresses of the given Service that are on this node
+func (nrc *NetworkRoutingController) nodeEndpointsOfService(svc *v1core.Service) (uint32, error) {
 	// listers for endpoints and services should use the same keys since
 	// endpoint and service resources share the same object name and namespace
 	key, err := cache.MetaNamespaceKeyFunc(svc)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
 	item, exists, err := nrc.epLister.GetByKey(key)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
 
+	// a service without Endpoints, not created yet or deleted, has no endpoints on any node. This is not an error, as
+	// it would keep the VIPs of all the other services from being advertised and withdrawn.
 	if !exists {
-		return false, fmt.Errorf("endpoint resource doesn't exist for service: %q", svc.Name)
+		return 0, nil
 	}
 
 	ep, ok := item.(*v1core.Endpoints)
 	if !ok {
-		return false, errors.New("failed to convert cache item to Endpoints type")
+		return 0, errors.New("failed to convert cache item to Endpoints type")
 	}
 
+	var endpoints uint32
 	for _, subset := range ep.Subsets {
 		for _, address := range subset.Addresses {
 			if address.NodeName != nil {
 				if *address.NodeName == nrc.nodeName {
-					return true, nil
+					endpoints++
 				}
 			} else {
 				if address.IP == nrc.nodeIP.String() {
-					return true, nil
+					endpoints++
 				}
 			}
 		}
 	}
 
-	return false, nil
+	return endpoints, nil
 }
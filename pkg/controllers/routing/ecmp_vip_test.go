@@ -4,9 +4,11 @@ import (
 	"context"
 	"testing"
 
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	v1core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 )
 
 // Compare 2 string slices by value.
@@ -373,3 +375,67 @@ func Test_getVIPsForService(t *testing.T) {
 		})
 	}
 }
+
+func Test_getVIPsForServiceNodeSelector(t *testing.T) {
+	svc := &v1core.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "svc-loadbalancer",
+			Annotations: map[string]string{utils.SvcVIPNodeSelectorAnnotation: "node-role.kubernetes.io/ingress"},
+		},
+		Spec: v1core.ServiceSpec{
+			Type:        LoadBalancerST,
+			ClusterIP:   "10.0.0.1",
+			ExternalIPs: []string{"1.1.1.1"},
+		},
+		Status: v1core.ServiceStatus{
+			LoadBalancer: v1core.LoadBalancerStatus{
+				Ingress: []v1core.LoadBalancerIngress{{IP: "10.0.255.1"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		nodeLabels    map[string]string
+		advertisedIPs []string
+		withdrawnIPs  []string
+	}{
+		{
+			"selected node advertises the external and LoadBalancer IPs",
+			map[string]string{"node-role.kubernetes.io/ingress": ""},
+			[]string{"10.0.0.1", "1.1.1.1", "10.0.255.1"},
+			[]string{},
+		},
+		{
+			"other nodes only advertise the cluster IP",
+			map[string]string{"node-role.kubernetes.io/worker": ""},
+			[]string{"10.0.0.1"},
+			[]string{"1.1.1.1", "10.0.255.1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: test.nodeLabels}}
+			if err := nodeLister.Add(node); err != nil {
+				t.Fatalf("failed to add node to the lister: %v", err)
+			}
+			nrc := NetworkRoutingController{
+				nodeName:                "node-1",
+				nodeLister:              nodeLister,
+				advertiseClusterIP:      true,
+				advertiseExternalIP:     true,
+				advertiseLoadBalancerIP: true,
+			}
+
+			advertisedIPs, withdrawnIPs, _ := nrc.getVIPsForService(svc, false)
+			if !Equal(test.advertisedIPs, advertisedIPs) {
+				t.Errorf("Advertised IPs are incorrect, got: %v, want: %v.", advertisedIPs, test.advertisedIPs)
+			}
+			if !Equal(test.withdrawnIPs, withdrawnIPs) {
+				t.Errorf("Withdrawn IPs are incorrect, got: %v, want: %v.", withdrawnIPs, test.withdrawnIPs)
+			}
+		})
+	}
+}
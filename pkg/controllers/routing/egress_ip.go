@@ -0,0 +1,725 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	egressIPChainName = "KUBE-ROUTER-EGRESS-IP"
+	egressIPComment   = utils.OwnerComment + " egress IPs of the pods"
+	// egressIPSetPrefix is the prefix of the ipsets of the pods of the EgressIPs, whose names are short enough for the
+	// temporary sets of their refresh and the "inet6:" prefix of the IPv6 sets
+	egressIPSetPrefix = "kube-router-eip-"
+	// the traffic of the pods that is routed to the egress nodes is marked in the high byte of the mark, clear of the
+	// marks of the network policies and of the services
+	egressIPMarkShift = 24
+	egressIPMarkMask  = 0xff000000
+	egressIPMaxCount  = 255
+	// egressIPRulePriority is the priority of the ip rules that look up the tables of the egress nodes for the marked
+	// traffic, the tables starting at egressIPTableBase
+	egressIPRulePriority       = 5000
+	egressIPTableBase          = 7700
+	egressIPSyncPeriod         = 10 * time.Second
+	egressIPStatusWriteTimeout = 30 * time.Second
+	egressIPStatusSubresource  = "status"
+)
+
+// egressIP is an EgressIP resource as it is set up on the node
+type egressIP struct {
+	name string
+	ip   net.IP
+	// node is the name of the egress node holding the address, empty when none of the nodes can hold it, and
+	// statusNode the one recorded in the status of the resource
+	node       string
+	statusNode string
+	// podIPs are the addresses of the selected pods of all of the nodes
+	podIPs []string
+	// route is the route to the egress node that the traffic of the pods of other nodes is routed through, nil on the
+	// egress node and when the node has no route to it
+	route *netlink.Route
+}
+
+// egressIPConfig is what the EgressIPs set up on the node
+type egressIPConfig struct {
+	// sets are the addresses of the pods by the name of their ipset
+	sets        map[string][]string
+	mangleRules [][]string
+	natRules    [][]string
+	rules       []*netlink.Rule
+	routes      []*netlink.Route
+	// held are the egress addresses the node holds and advertises
+	held []string
+}
+
+// EnableEgressIPResources makes the controller translate the source of the traffic of the pods the EgressIP custom
+// resources select to their egress addresses, and record the nodes holding the addresses in the status of the
+// resources with the given client. The informers watch the EgressIPs, the pods and the namespaces,
+// EgressIPEventHandler, EgressIPPodEventHandler and EgressIPNamespaceEventHandler are to be added to them.
+func (nrc *NetworkRoutingController) EnableEgressIPResources(egressIPInformer, podInformer,
+	nsInformer cache.SharedIndexInformer, client dynamic.Interface) {
+	nrc.egressIPLister = egressIPInformer.GetIndexer()
+	nrc.podLister = podInformer.GetIndexer()
+	nrc.nsLister = nsInformer.GetIndexer()
+	nrc.egressIPStatusClient = client
+	nrc.egressIPSyncChan = make(chan struct{}, 1)
+	nrc.EgressIPEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { nrc.requestEgressIPSync() },
+		UpdateFunc: func(interface{}, interface{}) { nrc.requestEgressIPSync() },
+		DeleteFunc: func(interface{}) { nrc.requestEgressIPSync() },
+	}
+	nrc.EgressIPPodEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { nrc.requestEgressIPSync() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok := oldObj.(*v1core.Pod)
+			if !ok {
+				return
+			}
+			newPod, ok := newObj.(*v1core.Pod)
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(oldPod.Status.PodIPs, newPod.Status.PodIPs) ||
+				!reflect.DeepEqual(oldPod.Labels, newPod.Labels) || oldPod.Status.Phase != newPod.Status.Phase {
+				nrc.requestEgressIPSync()
+			}
+		},
+		DeleteFunc: func(interface{}) { nrc.requestEgressIPSync() },
+	}
+	nrc.EgressIPNamespaceEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { nrc.requestEgressIPSync() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNs, ok := oldObj.(*v1core.Namespace)
+			if !ok {
+				return
+			}
+			newNs, ok := newObj.(*v1core.Namespace)
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(oldNs.Labels, newNs.Labels) {
+				nrc.requestEgressIPSync()
+			}
+		},
+		DeleteFunc: func(interface{}) { nrc.requestEgressIPSync() },
+	}
+}
+
+// requestEgressIPSync asks the EgressIP goroutine to sync the EgressIPs, unless a sync is already pending
+func (nrc *NetworkRoutingController) requestEgressIPSync() {
+	select {
+	case nrc.egressIPSyncChan <- struct{}{}:
+	default:
+	}
+}
+
+// runEgressIPs starts a goroutine that syncs the EgressIPs every egressIPSyncPeriod, which also follows the readiness
+// of the nodes, and whenever a sync is requested
+func (nrc *NetworkRoutingController) runEgressIPs(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		t := time.NewTicker(egressIPSyncPeriod)
+		defer t.Stop()
+		for {
+			nrc.syncEgressIPs()
+			select {
+			case <-t.C:
+			case <-nrc.egressIPSyncChan:
+			case <-stopCh:
+				klog.Infof("Shutting down the EgressIP sync")
+				return
+			}
+		}
+	}(stopCh, wg)
+}
+
+// egressIPSelectsNode returns true if all of the labels of the EgressIP's node selector are set on the node
+func egressIPSelectsNode(resource *v1alpha1.EgressIP, node *v1core.Node) bool {
+	for key, value := range resource.Spec.NodeSelector {
+		if nodeValue, ok := node.Labels[key]; !ok || nodeValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// egressIPNode returns the node that holds the egress address of the EgressIP: of the ready and schedulable nodes the
+// resource selects, the one with the highest hash of the names of the resource and of the node. All of the nodes agree
+// on it from their caches, and the address only moves to another node when its node goes away, is cordoned or becomes
+// not ready, or when a node taking precedence is added.
+func egressIPNode(resource *v1alpha1.EgressIP, nodes []*v1core.Node) *v1core.Node {
+	var egressNode *v1core.Node
+	var egressNodeHash uint64
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !nodeIsReady(node) || !egressIPSelectsNode(resource, node) {
+			continue
+		}
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(resource.Name + "/" + node.Name))
+		if sum := hash.Sum64(); egressNode == nil || sum > egressNodeHash {
+			egressNode, egressNodeHash = node, sum
+		}
+	}
+	return egressNode
+}
+
+// egressIPPods returns the pods the EgressIP selects, leaving out the ones of the host network and the terminated ones
+func (nrc *NetworkRoutingController) egressIPPods(resource *v1alpha1.EgressIP) ([]*v1core.Pod, error) {
+	if resource.Spec.NamespaceSelector == nil && resource.Spec.PodSelector == nil {
+		return nil, nil
+	}
+	var err error
+	namespaceSelector, podSelector := labels.Everything(), labels.Everything()
+	if resource.Spec.NamespaceSelector != nil {
+		if namespaceSelector, err = metav1.LabelSelectorAsSelector(resource.Spec.NamespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid namespace selector: %v", err)
+		}
+	}
+	if resource.Spec.PodSelector != nil {
+		if podSelector, err = metav1.LabelSelectorAsSelector(resource.Spec.PodSelector); err != nil {
+			return nil, fmt.Errorf("invalid pod selector: %v", err)
+		}
+	}
+
+	podLister := listers.NewPodLister(nrc.podLister)
+	selected := make([]*v1core.Pod, 0)
+	for _, obj := range nrc.nsLister.List() {
+		ns, ok := obj.(*v1core.Namespace)
+		if !ok || !namespaceSelector.Matches(labels.Set(ns.Labels)) {
+			continue
+		}
+		pods, err := podLister.Pods(ns.Name).List(podSelector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the pods of namespace %s: %v", ns.Name, err)
+		}
+		for _, pod := range pods {
+			if pod.Spec.HostNetwork || pod.Status.Phase == v1core.PodSucceeded || pod.Status.Phase == v1core.PodFailed {
+				continue
+			}
+			selected = append(selected, pod)
+		}
+	}
+	return selected, nil
+}
+
+// egressIPs returns the EgressIPs as they are set up on the node, sorted by name. The resources whose egress address
+// isn't of the address family of the node IP, is the one of a resource that sorts before them or whose selectors are
+// invalid are left out and logged. A pod selected by several resources has its traffic leave from the egress address
+// of the one that sorts first.
+func (nrc *NetworkRoutingController) egressIPs() []*egressIP {
+	resources := make([]*v1alpha1.EgressIP, 0)
+	for _, obj := range nrc.egressIPLister.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		resource := &v1alpha1.EgressIP{}
+		if err := v1alpha1.FromUnstructured(u, resource); err != nil {
+			klog.Errorf("Ignoring EgressIP %s that could not be parsed: %v", u.GetName(), err)
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Name < resources[j].Name })
+
+	nodes := make([]*v1core.Node, 0)
+	for _, obj := range nrc.nodeLister.List() {
+		if node, ok := obj.(*v1core.Node); ok {
+			nodes = append(nodes, node)
+		}
+	}
+
+	eips := make([]*egressIP, 0, len(resources))
+	egressAddresses := make(map[string]string)
+	selectedPods := make(map[string]string)
+	for _, resource := range resources {
+		ip := net.ParseIP(resource.Spec.EgressIP)
+		if ip == nil || (ip.To4() == nil) != nrc.isIpv6 {
+			klog.Errorf("Ignoring EgressIP %s whose egress IP %q isn't an address of the family of the node IP",
+				resource.Name, resource.Spec.EgressIP)
+			continue
+		}
+		if name, ok := egressAddresses[ip.String()]; ok {
+			klog.Errorf("Ignoring EgressIP %s with the egress IP of EgressIP %s", resource.Name, name)
+			continue
+		}
+		if len(eips) == egressIPMaxCount {
+			klog.Errorf("Ignoring EgressIP %s, at most %d EgressIPs are supported", resource.Name, egressIPMaxCount)
+			continue
+		}
+		pods, err := nrc.egressIPPods(resource)
+		if err != nil {
+			klog.Errorf("Ignoring EgressIP %s: %v", resource.Name, err)
+			continue
+		}
+		egressAddresses[ip.String()] = resource.Name
+
+		eip := &egressIP{name: resource.Name, ip: ip, statusNode: resource.Status.EgressNode, podIPs: []string{}}
+		if node := egressIPNode(resource, nodes); node != nil {
+			eip.node = node.Name
+			if node.Name != nrc.nodeName {
+				eip.route = nrc.egressNodeRoute(node)
+			}
+		}
+		for _, pod := range pods {
+			for _, podIP := range pod.Status.PodIPs {
+				ip := net.ParseIP(podIP.IP)
+				if ip == nil || (ip.To4() == nil) != nrc.isIpv6 {
+					continue
+				}
+				if name, ok := selectedPods[ip.String()]; ok {
+					klog.V(2).Infof("Pod %s/%s of EgressIP %s is selected by EgressIP %s as well", pod.Namespace,
+						pod.Name, name, resource.Name)
+					continue
+				}
+				selectedPods[ip.String()] = resource.Name
+				eip.podIPs = append(eip.podIPs, ip.String())
+			}
+		}
+		sort.Strings(eip.podIPs)
+		eips = append(eips, eip)
+	}
+	return eips
+}
+
+// egressNodeRoute returns the route the traffic of the pods to the egress node is routed through, the one to its pod
+// CIDR that the node was injected with, as it goes through the same tunnel or gateway. It is nil when the node wasn't
+// injected with a route to it, or the route goes through the WireGuard overlay, whose peers only accept the traffic to
+// the pod CIDRs of the nodes.
+func (nrc *NetworkRoutingController) egressNodeRoute(node *v1core.Node) *netlink.Route {
+	podCIDRs, err := utils.GetPodCIDRsFromNode(node)
+	if err != nil {
+		klog.Warningf("Couldn't determine the pod CIDR of egress node %s: %v", node.Name, err)
+		return nil
+	}
+	for _, podCIDR := range podCIDRs {
+		_, dst, err := net.ParseCIDR(podCIDR)
+		if err != nil || (dst.IP.To4() == nil) != nrc.isIpv6 {
+			continue
+		}
+		route := nrc.routeSyncer.injectedRoute(dst)
+		if route == nil {
+			continue
+		}
+		if nrc.overlayEncapsulation == options.OverlayEncapsulationWireGuard && route.LinkIndex != 0 {
+			klog.Warningf("Can't route the traffic to egress node %s through the WireGuard overlay", node.Name)
+			return nil
+		}
+		return route
+	}
+	return nil
+}
+
+// egressIPSetName returns the name of the ipset of the pods of an EgressIP
+func egressIPSetName(name string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	return fmt.Sprintf("%s%08x", egressIPSetPrefix, hash.Sum32())
+}
+
+// ipSetRuleName returns the name of an ipset of the controller in the iptables rules
+func (nrc *NetworkRoutingController) ipSetRuleName(name string) string {
+	if nrc.isIpv6 {
+		return "inet6:" + name
+	}
+	return name
+}
+
+// egressIPConfig returns what the EgressIPs set up on the node. The egress node of an EgressIP translates the source of
+// the traffic of its pods leaving the cluster to the egress address, which it advertises. On the other nodes the
+// traffic is marked, routed to the egress node with the route of the table of the EgressIP, and left alone by the
+// masquerading of the traffic of the pods. When the node has no route to the egress node, or no node can hold the
+// egress address, the traffic leaves the cluster as it otherwise would.
+func (nrc *NetworkRoutingController) egressIPConfig(eips []*egressIP) *egressIPConfig {
+	config := &egressIPConfig{sets: make(map[string][]string)}
+	for i, eip := range eips {
+		setName := egressIPSetName(eip.name)
+		comment := []string{"-m", "comment", "--comment", utils.OwnerComment + " egress IP " + eip.name}
+		match := []string{"-m", "set", "--match-set", nrc.ipSetRuleName(setName), "src",
+			"-m", "set", "!", "--match-set", nrc.ipSetRuleName(podSubnetsIPSetName), "dst",
+			"-m", "set", "!", "--match-set", nrc.ipSetRuleName(nodeAddrsIPSetName), "dst"}
+		rule := append(append([]string{}, comment...), match...)
+
+		switch {
+		case eip.node == nrc.nodeName:
+			config.sets[setName] = eip.podIPs
+			config.natRules = append(config.natRules, append(rule, "-j", "SNAT", "--to-source", eip.ip.String()))
+			config.held = append(config.held, eip.ip.String())
+		case eip.route != nil:
+			mark := (i + 1) << egressIPMarkShift
+			markArg := fmt.Sprintf("%#x/%#x", mark, egressIPMarkMask)
+			config.sets[setName] = eip.podIPs
+			config.mangleRules = append(config.mangleRules, append(rule, "-j", "MARK", "--set-xmark", markArg))
+			config.natRules = append(config.natRules,
+				append(append([]string{}, comment...), "-m", "mark", "--mark", markArg, "-j", "ACCEPT"))
+
+			ipRule := netlink.NewRule()
+			ipRule.Family = nrc.egressIPFamily()
+			ipRule.Priority = egressIPRulePriority
+			ipRule.Mark = mark
+			ipRule.Mask = egressIPMarkMask
+			ipRule.Table = egressIPTableBase + i
+			config.rules = append(config.rules, ipRule)
+
+			route := *eip.route
+			route.Dst = defaultRouteDst(nrc.isIpv6)
+			route.Table = egressIPTableBase + i
+			route.Protocol = zebraRouteOriginator
+			config.routes = append(config.routes, &route)
+		}
+	}
+	return config
+}
+
+// egressIPFamily returns the netlink address family of the EgressIPs, the one of the node IP
+func (nrc *NetworkRoutingController) egressIPFamily() int {
+	if nrc.isIpv6 {
+		return nl.FAMILY_V6
+	}
+	return nl.FAMILY_V4
+}
+
+// heldEgressIPs returns the egress addresses the node holds, which are exported to the BGP peers along with the
+// service VIPs
+func (nrc *NetworkRoutingController) heldEgressIPs() []string {
+	nrc.egressIPMutex.Lock()
+	defer nrc.egressIPMutex.Unlock()
+	return append([]string{}, nrc.egressIPsHeld...)
+}
+
+// syncEgressIPs sets up the EgressIPs on the node
+func (nrc *NetworkRoutingController) syncEgressIPs() {
+	eips := nrc.egressIPs()
+	config := nrc.egressIPConfig(eips)
+	if err := nrc.syncEgressIPSets(config.sets); err != nil {
+		klog.Errorf("Failed to sync the ipsets of the EgressIPs: %v", err)
+		return
+	}
+	if err := nrc.syncEgressIPRoutes(config.routes); err != nil {
+		klog.Errorf("Failed to sync the routes of the EgressIPs: %v", err)
+	}
+	if err := nrc.syncEgressIPRules(config.rules); err != nil {
+		klog.Errorf("Failed to sync the ip rules of the EgressIPs: %v", err)
+	}
+	if err := nrc.syncEgressIPChains(config); err != nil {
+		klog.Errorf("Failed to sync the iptables rules of the EgressIPs: %v", err)
+		return
+	}
+	nrc.destroyStaleEgressIPSets(config.sets)
+	nrc.syncEgressIPAdvertisements(config.held)
+	nrc.syncEgressIPStatus(eips)
+}
+
+// syncEgressIPSets creates the ipsets of the pods of the EgressIPs and refreshes their members
+func (nrc *NetworkRoutingController) syncEgressIPSets(sets map[string][]string) error {
+	nrc.ipsetMutex.Lock()
+	defer nrc.ipsetMutex.Unlock()
+	for name, podIPs := range sets {
+		set := nrc.ipSetHandler.Get(name)
+		if set == nil {
+			var err error
+			if set, err = nrc.ipSetHandler.Create(name, utils.TypeHashIP, utils.OptionTimeout, "0"); err != nil {
+				return fmt.Errorf("failed to create ipset %s: %v", name, err)
+			}
+		}
+		if err := set.Refresh(podIPs); err != nil {
+			return fmt.Errorf("failed to refresh ipset %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// destroyStaleEgressIPSets destroys the ipsets of the EgressIPs that aren't set up anymore, along with the ones left
+// by the EgressIPs deleted while kube-router wasn't running on the first sync
+func (nrc *NetworkRoutingController) destroyStaleEgressIPSets(sets map[string][]string) {
+	nrc.ipsetMutex.Lock()
+	defer nrc.ipsetMutex.Unlock()
+	if !nrc.egressIPSetsCleaned {
+		saved, err := utils.NewIPSet(nrc.isIpv6)
+		if err == nil {
+			err = saved.Save()
+		}
+		if err != nil {
+			klog.Errorf("Failed to list the ipsets of the EgressIPs: %v", err)
+		} else {
+			for name, set := range saved.Sets {
+				if _, ok := sets[name]; ok || !strings.HasPrefix(name, egressIPSetPrefix) {
+					continue
+				}
+				if err = set.Destroy(); err != nil {
+					klog.Errorf("Failed to destroy the ipset %s of a deleted EgressIP: %v", name, err)
+				}
+			}
+			nrc.egressIPSetsCleaned = true
+		}
+	}
+	for name := range nrc.ipSetHandler.Sets {
+		if _, ok := sets[name]; ok || !strings.HasPrefix(name, egressIPSetPrefix) {
+			continue
+		}
+		if err := nrc.ipSetHandler.Destroy(name); err != nil {
+			klog.Errorf("Failed to destroy the ipset %s of an EgressIP: %v", name, err)
+		}
+	}
+}
+
+// syncEgressIPRoutes installs the routes of the tables of the EgressIPs and removes the ones of the tables that aren't
+// used anymore
+func (nrc *NetworkRoutingController) syncEgressIPRoutes(routes []*netlink.Route) error {
+	installed, err := netlink.RouteListFiltered(nrc.egressIPFamily(), &netlink.Route{
+		Table: unix.RT_TABLE_UNSPEC, Protocol: zebraRouteOriginator,
+	}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return fmt.Errorf("failed to list the routes of the EgressIPs: %v", err)
+	}
+	tables := make(map[int]bool)
+	for _, route := range routes {
+		tables[route.Table] = true
+	}
+	for i := range installed {
+		route := &installed[i]
+		if !isEgressIPTable(route.Table) || tables[route.Table] {
+			continue
+		}
+		klog.V(1).Infof("Removing the route of table %d of an EgressIP", route.Table)
+		if err = netlink.RouteDel(route); err != nil {
+			klog.Errorf("Failed to remove the route of table %d of an EgressIP: %v", route.Table, err)
+		}
+	}
+	for _, route := range routes {
+		if err = netlink.RouteReplace(route); err != nil {
+			klog.Errorf("Failed to install the route %s of an EgressIP: %v", route, err)
+		}
+	}
+	return nil
+}
+
+// isEgressIPTable returns whether the routing table is one of the tables of the EgressIPs
+func isEgressIPTable(table int) bool {
+	return table >= egressIPTableBase && table < egressIPTableBase+egressIPMaxCount
+}
+
+// egressIPRuleKey identifies an ip rule of an EgressIP by its mark and table
+func egressIPRuleKey(rule *netlink.Rule) string {
+	return fmt.Sprintf("%#x/%#x/%d", rule.Mark, rule.Mask, rule.Table)
+}
+
+// syncEgressIPRules adds the ip rules of the EgressIPs and removes the ones of the EgressIPs that aren't set up anymore
+func (nrc *NetworkRoutingController) syncEgressIPRules(rules []*netlink.Rule) error {
+	filter := netlink.NewRule()
+	filter.Priority = egressIPRulePriority
+	installed, err := netlink.RuleListFiltered(nrc.egressIPFamily(), filter, netlink.RT_FILTER_PRIORITY)
+	if err != nil {
+		return fmt.Errorf("failed to list the ip rules of the EgressIPs: %v", err)
+	}
+	reconcileEgressIPRules(rules, installed, netlink.RuleAdd, netlink.RuleDel)
+	return nil
+}
+
+// reconcileEgressIPRules removes the installed ip rules that aren't among the given rules by egressIPRuleKey, and adds
+// the given rules that aren't installed
+func reconcileEgressIPRules(rules []*netlink.Rule, installed []netlink.Rule, add func(*netlink.Rule) error,
+	del func(*netlink.Rule) error) {
+	keys := make(map[string]bool)
+	for _, rule := range rules {
+		keys[egressIPRuleKey(rule)] = true
+	}
+	installedKeys := make(map[string]bool)
+	for i := range installed {
+		rule := &installed[i]
+		key := egressIPRuleKey(rule)
+		if keys[key] && !installedKeys[key] {
+			installedKeys[key] = true
+			continue
+		}
+		klog.V(1).Infof("Removing the ip rule %s of an EgressIP", rule)
+		if err := del(rule); err != nil {
+			klog.Errorf("Failed to remove the ip rule %s of an EgressIP: %v", rule, err)
+		}
+	}
+	for _, rule := range rules {
+		if installedKeys[egressIPRuleKey(rule)] {
+			continue
+		}
+		klog.V(1).Infof("Adding the ip rule %s of an EgressIP", rule)
+		if err := add(rule); err != nil {
+			klog.Errorf("Failed to add the ip rule %s of an EgressIP: %v", rule, err)
+		}
+	}
+}
+
+// syncEgressIPChains sets the rules of the chains of the EgressIPs in the mangle and nat tables, which are jumped to
+// from the start of the PREROUTING and POSTROUTING chains so that the traffic of the pods is translated to the egress
+// addresses before it would be masqueraded
+func (nrc *NetworkRoutingController) syncEgressIPChains(config *egressIPConfig) error {
+	iptablesCmdHandler, err := nrc.newIptablesCmdHandler()
+	if err != nil {
+		return fmt.Errorf("failed to create iptables handler: %v", err)
+	}
+	if err = nrc.syncEgressIPChain(iptablesCmdHandler, "mangle", "PREROUTING", config.mangleRules); err != nil {
+		return err
+	}
+	return nrc.syncEgressIPChain(iptablesCmdHandler, "nat", "POSTROUTING", config.natRules)
+}
+
+// syncEgressIPChain sets the rules of the chain of the EgressIPs in the given table, when they changed since they were
+// last set, and jumps to it from the start of the given built-in chain
+func (nrc *NetworkRoutingController) syncEgressIPChain(iptablesCmdHandler *iptables.IPTables,
+	table, builtinChain string, rules [][]string) error {
+	exists, err := iptablesCmdHandler.ChainExists(table, egressIPChainName)
+	if err != nil {
+		return fmt.Errorf("failed to look up chain %s of table %s: %v", egressIPChainName, table, err)
+	}
+	if nrc.egressIPChainRules == nil {
+		nrc.egressIPChainRules = make(map[string][][]string)
+	}
+	if applied, ok := nrc.egressIPChainRules[table]; !exists || !ok || !reflect.DeepEqual(applied, rules) {
+		if err = iptablesCmdHandler.ClearChain(table, egressIPChainName); err != nil {
+			return fmt.Errorf("failed to flush chain %s of table %s: %v", egressIPChainName, table, err)
+		}
+		for _, rule := range rules {
+			if err = iptablesCmdHandler.Append(table, egressIPChainName, rule...); err != nil {
+				delete(nrc.egressIPChainRules, table)
+				return fmt.Errorf("failed to add the rule of an EgressIP to table %s: %v", table, err)
+			}
+		}
+		nrc.egressIPChainRules[table] = rules
+	}
+
+	jump := []string{"-m", "comment", "--comment", egressIPComment, "-j", egressIPChainName}
+	if exists, err = iptablesCmdHandler.Exists(table, builtinChain, jump...); err != nil {
+		return fmt.Errorf("failed to look up the jump to chain %s of table %s: %v", egressIPChainName, table, err)
+	}
+	if !exists {
+		if err = iptablesCmdHandler.Insert(table, builtinChain, 1, jump...); err != nil {
+			return fmt.Errorf("failed to add the jump to chain %s of table %s: %v", egressIPChainName, table, err)
+		}
+	}
+	return nil
+}
+
+// syncEgressIPAdvertisements advertises the egress addresses the node holds to the BGP peers, and withdraws the ones
+// it doesn't hold anymore. The held addresses are exported along with the service VIPs, so the export policies are
+// updated first when they changed.
+func (nrc *NetworkRoutingController) syncEgressIPAdvertisements(held []string) {
+	if !nrc.bgpServerStarted || nrc.shuttingDown.Load() {
+		return
+	}
+	nrc.egressIPMutex.Lock()
+	previous := nrc.egressIPsHeld
+	nrc.egressIPsHeld = held
+	nrc.egressIPMutex.Unlock()
+
+	if !reflect.DeepEqual(previous, held) {
+		if err := nrc.AddPolicies(); err != nil {
+			klog.Errorf("Error adding BGP policies: %v", err)
+		}
+	}
+	heldIPs := make(map[string]bool)
+	for _, ip := range held {
+		heldIPs[ip] = true
+		if err := nrc.bgpAdvertiseVIP(ip, nil, 0); err != nil {
+			klog.Errorf("Failed to advertise egress IP %s: %v", ip, err)
+		}
+	}
+	for _, ip := range previous {
+		if heldIPs[ip] {
+			continue
+		}
+		klog.Infof("Withdrawing egress IP %s that the node doesn't hold anymore", ip)
+		if err := nrc.bgpWithdrawVIP(ip); err != nil {
+			klog.Errorf("Failed to withdraw egress IP %s: %v", ip, err)
+		}
+	}
+}
+
+// syncEgressIPStatus records the node in the status of the EgressIPs whose egress address it took over
+func (nrc *NetworkRoutingController) syncEgressIPStatus(eips []*egressIP) {
+	if nrc.egressIPStatusClient == nil {
+		return
+	}
+	for _, eip := range eips {
+		if eip.node != nrc.nodeName || eip.statusNode == nrc.nodeName {
+			continue
+		}
+		klog.Infof("Took over egress IP %s of EgressIP %s", eip.ip, eip.name)
+		if err := nrc.writeEgressIPStatus(eip.name); err != nil {
+			klog.Warningf("Failed to update the status of EgressIP %s: %v", eip.name, err)
+		}
+	}
+}
+
+// writeEgressIPStatus records the node as the egress node in the status of the EgressIP of the given name
+func (nrc *NetworkRoutingController) writeEgressIPStatus(name string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"egressNode": nrc.nodeName},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), egressIPStatusWriteTimeout)
+	defer cancel()
+	_, err = nrc.egressIPStatusClient.Resource(v1alpha1.EgressIPResource).Patch(ctx, name, types.MergePatchType, patch,
+		metav1.PatchOptions{}, egressIPStatusSubresource)
+	return err
+}
+
+// deleteEgressIPs removes the iptables rules, ip rules and routes of the EgressIPs, the ipsets of their pods are
+// destroyed along with the other ipsets of the controller
+func (nrc *NetworkRoutingController) deleteEgressIPs() error {
+	var errs []string
+	iptablesCmdHandler, err := nrc.newIptablesCmdHandler()
+	if err != nil {
+		return fmt.Errorf("failed to create iptables handler: %v", err)
+	}
+	jump := []string{"-m", "comment", "--comment", egressIPComment, "-j", egressIPChainName}
+	for table, builtinChain := range map[string]string{"mangle": "PREROUTING", "nat": "POSTROUTING"} {
+		if err = iptablesCmdHandler.DeleteIfExists(table, builtinChain, jump...); err != nil {
+			errs = append(errs, err.Error())
+		}
+		exists, err := iptablesCmdHandler.ChainExists(table, egressIPChainName)
+		if err == nil && exists {
+			err = iptablesCmdHandler.ClearAndDeleteChain(table, egressIPChainName)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err = nrc.syncEgressIPRules(nil); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err = nrc.syncEgressIPRoutes(nil); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
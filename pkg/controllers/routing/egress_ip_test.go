@@ -0,0 +1,227 @@
+package routing
+
+import (
+	"net"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func egressIPTestNode(name, podCIDR string, labels map[string]string) *v1core.Node {
+	return &v1core.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       v1core.NodeSpec{PodCIDR: podCIDR, PodCIDRs: []string{podCIDR}},
+		Status: v1core.NodeStatus{Conditions: []v1core.NodeCondition{
+			{Type: v1core.NodeReady, Status: v1core.ConditionTrue},
+		}},
+	}
+}
+
+func egressIPTestPod(namespace, name, ip string, labels map[string]string) *v1core.Pod {
+	return &v1core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Status:     v1core.PodStatus{Phase: v1core.PodRunning, PodIPs: []v1core.PodIP{{IP: ip}}},
+	}
+}
+
+func Test_egressIPNode(t *testing.T) {
+	resource := &v1alpha1.EgressIP{
+		ObjectMeta: metav1.ObjectMeta{Name: "billing"},
+		Spec:       v1alpha1.EgressIPSpec{EgressIP: "203.0.113.10", NodeSelector: map[string]string{"egress": "true"}},
+	}
+	nodes := []*v1core.Node{
+		egressIPTestNode("node-1", "10.1.1.0/24", map[string]string{"egress": "true"}),
+		egressIPTestNode("node-2", "10.1.2.0/24", map[string]string{"egress": "true"}),
+		egressIPTestNode("node-3", "10.1.3.0/24", map[string]string{"egress": "true"}),
+		egressIPTestNode("node-4", "10.1.4.0/24", nil),
+	}
+
+	holder := egressIPNode(resource, nodes)
+	if !assert.NotNil(t, holder, "expected a node to hold the egress IP") {
+		return
+	}
+	assert.NotEqual(t, "node-4", holder.Name, "expected a node the resource selects")
+	assert.Equal(t, holder, egressIPNode(resource, []*v1core.Node{nodes[3], nodes[2], nodes[1], nodes[0]}),
+		"expected the holder not to depend on the order of the nodes")
+
+	holder.Spec.Unschedulable = true
+	cordonedHolder := holder.Name
+	failover := egressIPNode(resource, nodes)
+	if !assert.NotNil(t, failover, "expected another node to take over the egress IP") {
+		return
+	}
+	assert.NotEqual(t, cordonedHolder, failover.Name, "expected the egress IP to move off of the cordoned node")
+	assert.NotEqual(t, "node-4", failover.Name)
+
+	failover.Status.Conditions[0].Status = v1core.ConditionFalse
+	remaining := egressIPNode(resource, nodes)
+	if !assert.NotNil(t, remaining) {
+		return
+	}
+	assert.NotContains(t, []string{cordonedHolder, failover.Name, "node-4"}, remaining.Name,
+		"expected the egress IP to move off of the not ready node")
+
+	remaining.Spec.Unschedulable = true
+	assert.Nil(t, egressIPNode(resource, nodes), "expected no holder without an eligible node")
+}
+
+func Test_egressIPs(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		nodeName:       "node-1",
+		routeSyncer:    newRouteSyncer(0),
+		egressIPLister: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		podLister:      cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		nsLister:       cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		nodeLister:     cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+	_ = nrc.nodeLister.Add(egressIPTestNode("node-1", "10.1.1.0/24", nil))
+	_ = nrc.nodeLister.Add(egressIPTestNode("node-2", "10.1.2.0/24", map[string]string{"egress": "true"}))
+	_, podCIDR, _ := net.ParseCIDR("10.1.2.0/24")
+	nrc.routeSyncer.addInjectedRoute(podCIDR, &netlink.Route{Dst: podCIDR, Gw: net.ParseIP("192.168.0.2")})
+
+	_ = nrc.nsLister.Add(&v1core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "billing",
+		Labels: map[string]string{"team": "billing"}}})
+	_ = nrc.nsLister.Add(&v1core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+	invoices := map[string]string{"app": "invoices"}
+	_ = nrc.podLister.Add(egressIPTestPod("billing", "invoices-1", "10.1.1.5", invoices))
+	_ = nrc.podLister.Add(egressIPTestPod("billing", "invoices-2", "10.1.2.5", invoices))
+	_ = nrc.podLister.Add(egressIPTestPod("billing", "ledger", "10.1.1.6", nil))
+	_ = nrc.podLister.Add(egressIPTestPod("web", "frontend", "10.1.1.7", invoices))
+	completed := egressIPTestPod("billing", "invoices-job", "10.1.1.8", invoices)
+	completed.Status.Phase = v1core.PodSucceeded
+	_ = nrc.podLister.Add(completed)
+	hostNetwork := egressIPTestPod("billing", "invoices-agent", "192.168.0.1", invoices)
+	hostNetwork.Spec.HostNetwork = true
+	_ = nrc.podLister.Add(hostNetwork)
+
+	for _, resource := range []*v1alpha1.EgressIP{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "billing"},
+			Spec: v1alpha1.EgressIPSpec{
+				EgressIP:          "203.0.113.10",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "billing"}},
+				PodSelector:       &metav1.LabelSelector{MatchLabels: invoices},
+				NodeSelector:      map[string]string{"egress": "true"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "invoices"},
+			Spec: v1alpha1.EgressIPSpec{
+				EgressIP:    "203.0.113.11",
+				PodSelector: &metav1.LabelSelector{MatchLabels: invoices},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "shadowed"},
+			Spec: v1alpha1.EgressIPSpec{
+				EgressIP:    "203.0.113.11",
+				PodSelector: &metav1.LabelSelector{},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "ipv6"},
+			Spec:       v1alpha1.EgressIPSpec{EgressIP: "2001:db8::10", PodSelector: &metav1.LabelSelector{}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "no-selectors"},
+			Spec:       v1alpha1.EgressIPSpec{EgressIP: "203.0.113.12"},
+		},
+	} {
+		obj, err := v1alpha1.ToUnstructured(resource)
+		if err != nil {
+			t.Fatalf("failed to convert EgressIP: %v", err)
+		}
+		_ = nrc.egressIPLister.Add(obj)
+	}
+
+	eips := nrc.egressIPs()
+	names := make([]string, 0, len(eips))
+	for _, eip := range eips {
+		names = append(names, eip.name)
+	}
+	assert.Equal(t, []string{"billing", "invoices", "no-selectors"}, names,
+		"expected the valid resources sorted by name")
+
+	assert.Equal(t, "node-2", eips[0].node, "expected the only node the resource selects to hold the egress IP")
+	assert.Equal(t, []string{"10.1.1.5", "10.1.2.5"}, eips[0].podIPs, "expected the running pods the resource selects")
+	if assert.NotNil(t, eips[0].route, "expected the route to the pod CIDR of the egress node") {
+		assert.Equal(t, "192.168.0.2", eips[0].route.Gw.String())
+	}
+	assert.Equal(t, []string{"10.1.1.7"}, eips[1].podIPs,
+		"expected the pods selected by a resource that sorts before to be left out")
+	assert.Empty(t, eips[2].podIPs, "expected no pods without selectors")
+}
+
+func Test_egressIPConfig(t *testing.T) {
+	nrc := &NetworkRoutingController{nodeName: "node-1"}
+	_, podCIDR, _ := net.ParseCIDR("10.1.2.0/24")
+	eips := []*egressIP{
+		{name: "held", ip: net.ParseIP("203.0.113.10"), node: "node-1", podIPs: []string{"10.1.1.5"}},
+		{name: "remote", ip: net.ParseIP("203.0.113.11"), node: "node-2", podIPs: []string{"10.1.1.6"},
+			route: &netlink.Route{Dst: podCIDR, Gw: net.ParseIP("192.168.0.2")}},
+		{name: "unreachable", ip: net.ParseIP("203.0.113.12"), node: "node-3", podIPs: []string{"10.1.1.7"}},
+		{name: "unheld", ip: net.ParseIP("203.0.113.13"), podIPs: []string{"10.1.1.8"}},
+	}
+
+	config := nrc.egressIPConfig(eips)
+	assert.Equal(t, map[string][]string{
+		egressIPSetName("held"):   {"10.1.1.5"},
+		egressIPSetName("remote"): {"10.1.1.6"},
+	}, config.sets, "expected the sets of the egress IPs held by the node or routed to their holder")
+	assert.Equal(t, []string{"203.0.113.10"}, config.held)
+
+	if assert.Len(t, config.natRules, 2) {
+		assert.Equal(t, []string{"-j", "SNAT", "--to-source", "203.0.113.10"},
+			config.natRules[0][len(config.natRules[0])-4:], "expected the held egress IP to be the source")
+		assert.Equal(t, []string{"-m", "mark", "--mark", "0x2000000/0xff000000", "-j", "ACCEPT"},
+			config.natRules[1][4:], "expected the traffic routed to the holder not to be masqueraded")
+	}
+	if assert.Len(t, config.mangleRules, 1) {
+		assert.Equal(t, []string{"-j", "MARK", "--set-xmark", "0x2000000/0xff000000"},
+			config.mangleRules[0][len(config.mangleRules[0])-4:])
+	}
+	if assert.Len(t, config.rules, 1) {
+		assert.Equal(t, 0x2000000, config.rules[0].Mark)
+		assert.Equal(t, egressIPTableBase+1, config.rules[0].Table)
+		assert.Equal(t, egressIPRulePriority, config.rules[0].Priority)
+	}
+	if assert.Len(t, config.routes, 1) {
+		assert.Equal(t, "0.0.0.0/0", config.routes[0].Dst.String())
+		assert.Equal(t, "192.168.0.2", config.routes[0].Gw.String())
+		assert.Equal(t, egressIPTableBase+1, config.routes[0].Table)
+		assert.Equal(t, podCIDR.String(), eips[1].route.Dst.String(), "expected the injected route to be left alone")
+	}
+}
+
+func Test_reconcileEgressIPRules(t *testing.T) {
+	rule := func(mark, table int) *netlink.Rule {
+		r := netlink.NewRule()
+		r.Priority = egressIPRulePriority
+		r.Mark = mark
+		r.Mask = egressIPMarkMask
+		r.Table = table
+		return r
+	}
+	rules := []*netlink.Rule{rule(0x1000000, egressIPTableBase), rule(0x2000000, egressIPTableBase+1)}
+	installed := []netlink.Rule{*rule(0x1000000, egressIPTableBase), *rule(0x3000000, egressIPTableBase+2),
+		*rule(0x1000000, egressIPTableBase)}
+
+	var added, deleted []string
+	reconcileEgressIPRules(rules, installed,
+		func(rule *netlink.Rule) error {
+			added = append(added, egressIPRuleKey(rule))
+			return nil
+		},
+		func(rule *netlink.Rule) error {
+			deleted = append(deleted, egressIPRuleKey(rule))
+			return nil
+		})
+	assert.Equal(t, []string{"0x3000000/0xff000000/7702", "0x1000000/0xff000000/7700"}, deleted,
+		"expected the stale and the duplicate rules to be removed")
+	assert.Equal(t, []string{"0x2000000/0xff000000/7701"}, added, "expected the missing rule to be added")
+}
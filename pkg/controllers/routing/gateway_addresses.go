@@ -0,0 +1,78 @@
+package routing
+
+import (
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// EnableGatewayAddresses makes the controller advertise the addresses of the Gateways programmed by kube-router along
+// with the LoadBalancer IPs of the services, when those are advertised. The informers watch the GatewayClasses and the
+// Gateways, GatewayEventHandler is to be added to both.
+func (nrc *NetworkRoutingController) EnableGatewayAddresses(gatewayClassInformer,
+	gatewayInformer cache.SharedIndexInformer) {
+	nrc.gatewayClassLister = gatewayClassInformer.GetIndexer()
+	nrc.gatewayLister = gatewayInformer.GetIndexer()
+	nrc.GatewayEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { nrc.syncGatewayAddresses() },
+		UpdateFunc: func(interface{}, interface{}) { nrc.syncGatewayAddresses() },
+		DeleteFunc: func(interface{}) { nrc.syncGatewayAddresses() },
+	}
+}
+
+// gatewayVIPs returns the IPv4 addresses of the Gateways, the only ones the proxy serves them on
+func (nrc *NetworkRoutingController) gatewayVIPs() []string {
+	vips := make([]string, 0)
+	for _, gateway := range utils.ManagedGateways(nrc.gatewayLister, nrc.gatewayClassLister) {
+		for _, address := range utils.GatewayAddresses(gateway) {
+			if net.ParseIP(address).To4() != nil {
+				vips = append(vips, address)
+			}
+		}
+	}
+	return vips
+}
+
+// syncGatewayAddresses advertises the addresses of the Gateways, and withdraws the addresses advertised before that
+// neither a Gateway nor a service has anymore
+func (nrc *NetworkRoutingController) syncGatewayAddresses() {
+	if !nrc.bgpServerStarted {
+		klog.V(3).Info("Skipping update to the Gateways, controller still performing bootup full-sync")
+		return
+	}
+	nrc.gatewayMutex.Lock()
+	defer nrc.gatewayMutex.Unlock()
+
+	toAdvertise, toWithdraw, err := nrc.getActiveVIPs()
+	if err != nil {
+		klog.Errorf("error getting routes for services: %s", err)
+		return
+	}
+
+	// update export policies so that the addresses of the Gateways get added to the VIP prefix set
+	if err = nrc.AddPolicies(); err != nil {
+		klog.Errorf("Error adding BGP policies: %s", err.Error())
+	}
+
+	nrc.advertiseVIPs(toAdvertise)
+	nrc.withdrawVIPs(append(toWithdraw, releasedGatewayVIPs(nrc.gatewayVIPsAdvertised, toAdvertise, toWithdraw)...))
+	nrc.gatewayVIPsAdvertised = nrc.gatewayVIPs()
+}
+
+// releasedGatewayVIPs returns the addresses of the Gateways advertised before that are neither to be advertised nor
+// already to be withdrawn
+func releasedGatewayVIPs(advertised, toAdvertise, toWithdraw []string) []string {
+	current := make(map[string]bool)
+	for _, vip := range append(append([]string{}, toAdvertise...), toWithdraw...) {
+		current[vip] = true
+	}
+	released := make([]string, 0)
+	for _, vip := range advertised {
+		if !current[vip] {
+			released = append(released, vip)
+		}
+	}
+	return released
+}
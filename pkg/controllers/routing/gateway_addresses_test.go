@@ -0,0 +1,71 @@
+package routing
+
+import (
+	"testing"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_getVIPsGateways(t *testing.T) {
+	add := func(lister cache.Indexer, obj interface{}) {
+		u, err := v1alpha1.ToUnstructured(obj)
+		if err != nil {
+			t.Fatalf("failed to convert %v: %v", obj, err)
+		}
+		assert.NoError(t, lister.Add(u))
+	}
+	nrc := &NetworkRoutingController{
+		nodeName:                "node-1",
+		svcLister:               cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		nodeLister:              cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		gatewayLister:           cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		gatewayClassLister:      cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		advertiseLoadBalancerIP: true,
+		gracefulWithdraw:        true,
+	}
+	add(nrc.gatewayClassLister, &gatewayv1.GatewayClass{ObjectMeta: metav1.ObjectMeta{Name: "l4"},
+		Spec: gatewayv1.GatewayClassSpec{ControllerName: utils.GatewayControllerName}})
+	add(nrc.gatewayLister, &gatewayv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "public"},
+		Spec: gatewayv1.GatewaySpec{GatewayClassName: "l4",
+			Addresses: []gatewayv1.GatewayAddress{{Value: "192.0.2.1"}, {Value: "2001:db8::1"}}},
+	})
+	add(nrc.gatewayLister, &gatewayv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "other"},
+		Spec:       gatewayv1.GatewaySpec{GatewayClassName: "other"},
+		Status:     gatewayv1.GatewayStatus{Addresses: []gatewayv1.GatewayAddress{{Value: "192.0.2.2"}}},
+	})
+
+	toAdvertise, toWithdraw, err := nrc.getActiveVIPs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.0.2.1"}, toAdvertise,
+		"expected the IPv4 addresses of the Gateways of kube-router to be advertised")
+	assert.Empty(t, toWithdraw)
+
+	nrc.shuttingDown.Store(true)
+	toAdvertise, toWithdraw, _ = nrc.getActiveVIPs()
+	assert.Empty(t, toAdvertise)
+	assert.Equal(t, []string{"192.0.2.1"}, toWithdraw, "expected the addresses to be withdrawn on shutdown")
+	allVIPs, _, _ := nrc.getAllVIPs()
+	assert.Equal(t, []string{"192.0.2.1"}, allVIPs, "expected the addresses to be kept in the export policies")
+
+	nrc.shuttingDown.Store(false)
+	nrc.advertiseLoadBalancerIP = false
+	toAdvertise, toWithdraw, _ = nrc.getActiveVIPs()
+	assert.Empty(t, toAdvertise)
+	assert.Equal(t, []string{"192.0.2.1"}, toWithdraw,
+		"expected the addresses not to be advertised along with the LoadBalancer IPs")
+}
+
+func Test_releasedGatewayVIPs(t *testing.T) {
+	assert.Equal(t, []string{"192.0.2.3"},
+		releasedGatewayVIPs([]string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, []string{"192.0.2.1"},
+			[]string{"192.0.2.2"}),
+		"expected the addresses neither advertised nor withdrawn anymore")
+	assert.Empty(t, releasedGatewayVIPs(nil, []string{"192.0.2.1"}, nil))
+}
@@ -0,0 +1,70 @@
+package routing
+
+import (
+	"time"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// nodeCordoned returns whether this node is marked unschedulable, as it is while it is drained
+func (nrc *NetworkRoutingController) nodeCordoned() bool {
+	obj, exists, err := nrc.nodeLister.GetByKey(nrc.nodeName)
+	if err != nil || !exists {
+		return false
+	}
+	node, ok := obj.(*v1core.Node)
+	return ok && node.Spec.Unschedulable
+}
+
+// withdrawsVIPs returns whether the VIPs of the services are withdrawn whatever their endpoints, which they are with
+// --graceful-withdraw while the node is cordoned or shutting down
+func (nrc *NetworkRoutingController) withdrawsVIPs() bool {
+	return nrc.gracefulWithdraw && (nrc.shuttingDown.Load() || nrc.nodeCordoned())
+}
+
+// cordonChanged returns whether the node was cordoned or uncordoned
+func cordonChanged(oldNode, newNode *v1core.Node) bool {
+	return oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable
+}
+
+// withdrawOnShutdown withdraws the service VIPs, egress IPs and pod CIDRs of the node from the peers, then waits
+// gracefulWithdrawDelay for the in-flight connections to finish before the BGP server is stopped. With graceful
+// restart, the peers keep the routes of a restarting node, so they are only withdrawn when the node is cordoned.
+func (nrc *NetworkRoutingController) withdrawOnShutdown() {
+	if nrc.bgpGracefulRestart && !nrc.nodeCordoned() {
+		klog.Infof("Not withdrawing the advertisements on shutdown, the peers keep them for graceful restart")
+		return
+	}
+	nrc.shuttingDown.Store(true)
+
+	klog.Infof("Withdrawing the service VIPs, egress IPs and pod CIDRs of the node from the BGP peers")
+	vips, _, err := nrc.getAllVIPs()
+	if err != nil {
+		klog.Errorf("Failed to get the service VIPs to withdraw: %v", err)
+	}
+	nrc.withdrawVIPs(vips)
+	nrc.withdrawVIPs(nrc.heldEgressIPs())
+	nrc.withdrawPodRoute()
+
+	if nrc.gracefulWithdrawDelay > 0 {
+		klog.Infof("Waiting %s for the in-flight connections before stopping the BGP server", nrc.gracefulWithdrawDelay)
+		time.Sleep(nrc.gracefulWithdrawDelay)
+	}
+}
+
+// withdrawPodRoute withdraws the pod CIDRs of this node, and the summaries covering them, advertised by
+// advertisePodRoute
+func (nrc *NetworkRoutingController) withdrawPodRoute() {
+	cidrs := nrc.advertisablePodCIDRs()
+	for _, ipv6 := range nrc.addressFamilies() {
+		for _, summary := range nrc.nodePodCIDRSummaries(ipv6) {
+			cidrs = append(cidrs, summary.String())
+		}
+	}
+	for _, cidr := range cidrs {
+		if err := nrc.withdrawPodCIDR(cidr); err != nil {
+			klog.Errorf("Failed to withdraw the pod CIDR %s: %v", cidr, err)
+		}
+	}
+}
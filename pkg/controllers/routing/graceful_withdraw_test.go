@@ -0,0 +1,132 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	gobgp "github.com/osrg/gobgp/v3/pkg/server"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_withdrawsVIPs(t *testing.T) {
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := nodeLister.Add(node); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	nrc := &NetworkRoutingController{nodeName: "node-1", nodeLister: nodeLister, gracefulWithdraw: true}
+
+	if nrc.withdrawsVIPs() {
+		t.Error("expected the VIPs of a schedulable node to be advertised")
+	}
+
+	cordoned := node.DeepCopy()
+	cordoned.Spec.Unschedulable = true
+	if err := nodeLister.Update(cordoned); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+	if !cordonChanged(node, cordoned) {
+		t.Error("expected the cordon to be a change of the node")
+	}
+	if !nrc.withdrawsVIPs() {
+		t.Error("expected the VIPs of a cordoned node to be withdrawn")
+	}
+	nrc.gracefulWithdraw = false
+	if nrc.withdrawsVIPs() {
+		t.Error("expected the VIPs of a cordoned node to be advertised without --graceful-withdraw")
+	}
+
+	nrc.gracefulWithdraw = true
+	if err := nodeLister.Update(node); err != nil {
+		t.Fatalf("failed to update node: %v", err)
+	}
+	nrc.shuttingDown.Store(true)
+	if !nrc.withdrawsVIPs() {
+		t.Error("expected the VIPs to be withdrawn on shutdown")
+	}
+}
+
+func Test_withdrawOnShutdown(t *testing.T) {
+	bgpServer := gobgp.NewBgpServer()
+	go bgpServer.Serve()
+	err := bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{
+		Global: &gobgpapi.Global{Asn: 100, RouterId: "10.0.0.0", ListenPort: -1}})
+	if err != nil {
+		t.Fatalf("failed to start BGP server: %v", err)
+	}
+	defer func() {
+		if err := bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server: %v", err)
+		}
+	}()
+
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err = nodeLister.Add(&v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	err = svcLister.Add(&v1core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default"},
+		Spec:       v1core.ServiceSpec{Type: ClusterIPST, ClusterIP: "10.96.0.10"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add service: %v", err)
+	}
+	nrc := &NetworkRoutingController{
+		bgpServer:          bgpServer,
+		nodeName:           "node-1",
+		nodeIP:             net.ParseIP("10.0.0.1"),
+		overlayIP:          net.ParseIP("10.0.0.1"),
+		podCidr:            "10.244.1.0/24",
+		nodeLister:         nodeLister,
+		svcLister:          svcLister,
+		advertiseClusterIP: true,
+		gracefulWithdraw:   true,
+		bgpGracefulRestart: true,
+	}
+	listPrefixes := func() []string {
+		var prefixes []string
+		err := bgpServer.ListPath(context.Background(), &gobgpapi.ListPathRequest{
+			TableType: gobgpapi.TableType_GLOBAL, Family: unicastFamily(false)}, func(d *gobgpapi.Destination) {
+			prefixes = append(prefixes, d.Prefix)
+		})
+		if err != nil {
+			t.Fatalf("failed to list the paths: %v", err)
+		}
+		sort.Strings(prefixes)
+		return prefixes
+	}
+
+	toAdvertise, _, err := nrc.getActiveVIPs()
+	if err != nil {
+		t.Fatalf("failed to get the VIPs: %v", err)
+	}
+	nrc.advertiseVIPs(toAdvertise)
+	if err = nrc.advertisePodRoute(); err != nil {
+		t.Fatalf("failed to advertise the pod CIDR: %v", err)
+	}
+	expected := []string{"10.244.1.0/24", "10.96.0.10/32"}
+	if prefixes := listPrefixes(); !reflect.DeepEqual(prefixes, expected) {
+		t.Fatalf("expected the paths %v, got %v", expected, prefixes)
+	}
+
+	nrc.withdrawOnShutdown()
+	if prefixes := listPrefixes(); !reflect.DeepEqual(prefixes, expected) {
+		t.Errorf("expected the paths %v to be kept for graceful restart, got %v", expected, prefixes)
+	}
+
+	nrc.bgpGracefulRestart = false
+	nrc.withdrawOnShutdown()
+	if prefixes := listPrefixes(); len(prefixes) != 0 {
+		t.Errorf("expected the paths to be withdrawn on shutdown, got %v", prefixes)
+	}
+	if toAdvertise, _, _ = nrc.getActiveVIPs(); len(toAdvertise) != 0 {
+		t.Errorf("expected no VIPs to be advertised again while shutting down, got %v", toAdvertise)
+	}
+}
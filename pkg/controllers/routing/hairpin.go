@@ -0,0 +1,226 @@
+package routing
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// HairpinController keeps hairpin_mode enabled on the kube-bridge port of every pod, so that traffic a pod sends to a
+// Service that is load balanced back to the same pod can be switched out of the port it came in on.
+//
+// The ports are reconciled periodically and whenever links change on the node or pods change, which covers pods that
+// are restarted (and get a new veth) as well as kube-bridge being recreated.
+type HairpinController struct {
+	syncPeriod      time.Duration
+	syncRequestChan chan struct{}
+	MetricsEnabled  bool
+
+	podLister cache.Indexer
+
+	PodEventHandler cache.ResourceEventHandler
+}
+
+// Run syncs hairpin_mode on the bridge ports periodically, whenever links change and whenever a sync is requested till
+// we receive notification on stopCh
+func (hc *HairpinController) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	t := time.NewTicker(hc.syncPeriod)
+	defer t.Stop()
+	defer wg.Done()
+
+	linkUpdates := make(chan netlink.LinkUpdate)
+	done := make(chan struct{})
+	defer close(done)
+	if err := netlink.LinkSubscribe(linkUpdates, done); err != nil {
+		klog.Errorf("Failed to subscribe to link updates, hairpin_mode will only be synced every %s: %v",
+			hc.syncPeriod, err)
+	} else {
+		// new veths and a recreated kube-bridge show up as new links, the channel is closed once done is closed
+		go func() {
+			for update := range linkUpdates {
+				if update.Header.Type == unix.RTM_NEWLINK {
+					hc.RequestSync()
+				}
+			}
+		}()
+	}
+
+	klog.Info("Starting hairpin mode controller")
+	for {
+		if err := hc.sync(); err != nil {
+			klog.Errorf("Failed to sync hairpin_mode on %s ports: %v", kubeBridgeIfName, err)
+		}
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down hairpin mode controller")
+			return
+		case <-t.C:
+		case <-hc.syncRequestChan:
+		}
+	}
+}
+
+// RequestSync allows the request of a sync without blocking the callee
+func (hc *HairpinController) RequestSync() {
+	select {
+	case hc.syncRequestChan <- struct{}{}:
+	default:
+	}
+}
+
+func (hc *HairpinController) sync() error {
+	bridge, err := netlink.LinkByName(kubeBridgeIfName)
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %v", kubeBridgeIfName, err)
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+
+	ports := make(map[int]string)
+	for _, link := range links {
+		if link.Attrs().MasterIndex != bridge.Attrs().Index || link.Type() != "veth" {
+			continue
+		}
+		ports[link.Attrs().Index] = link.Attrs().Name
+
+		protinfo, err := netlink.LinkGetProtinfo(link)
+		if err != nil {
+			klog.Errorf("Failed to get bridge port settings of %s: %v", link.Attrs().Name, err)
+			continue
+		}
+		if protinfo.Hairpin {
+			continue
+		}
+		if err = netlink.LinkSetHairpin(link, true); err != nil {
+			klog.Errorf("Failed to enable hairpin_mode on %s: %v", link.Attrs().Name, err)
+			continue
+		}
+		klog.Infof("Enabled hairpin_mode on %s port %s", kubeBridgeIfName, link.Attrs().Name)
+	}
+
+	fdb, err := netlink.NeighList(0, unix.AF_BRIDGE)
+	if err != nil {
+		return fmt.Errorf("failed to list forwarding database of %s: %v", kubeBridgeIfName, err)
+	}
+	neighs, err := netlink.NeighList(bridge.Attrs().Index, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list neighbors on %s: %v", kubeBridgeIfName, err)
+	}
+	portPods := podsOnBridgePorts(ports, fdb, neighs, hc.podsByIP())
+
+	names := make([]string, 0, len(portPods))
+	for port, pod := range portPods {
+		names = append(names, pod.Namespace+"/"+pod.Name+"("+port+")")
+	}
+	sort.Strings(names)
+	klog.V(1).Infof("Pods with hairpin_mode enabled on their %s port: %v", kubeBridgeIfName, names)
+
+	if hc.MetricsEnabled {
+		metrics.ControllerHairpinModePods.Reset()
+		for port, pod := range portPods {
+			metrics.ControllerHairpinModePods.WithLabelValues(pod.Namespace, pod.Name, port).Set(1)
+		}
+	}
+	return nil
+}
+
+// podsByIP indexes the pods that are not running in the host network by their IPs
+func (hc *HairpinController) podsByIP() map[string]*v1core.Pod {
+	pods := make(map[string]*v1core.Pod)
+	for _, obj := range hc.podLister.List() {
+		pod, ok := obj.(*v1core.Pod)
+		if !ok || pod.Spec.HostNetwork {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			pods[podIP.IP] = pod
+		}
+	}
+	return pods
+}
+
+// podsOnBridgePorts finds out which pod is attached to each of the given bridge ports: the forwarding database of the
+// bridge maps the pod's MAC to the port it was learned on, and the neighbor table of the bridge maps the pod's IP to
+// its MAC.
+func podsOnBridgePorts(ports map[int]string, fdb []netlink.Neigh, neighs []netlink.Neigh,
+	podsByIP map[string]*v1core.Pod) map[string]*v1core.Pod {
+	portsByMAC := make(map[string]string)
+	for _, entry := range fdb {
+		port, ok := ports[entry.LinkIndex]
+		// the permanent entries are the MACs of the host side of the veths rather than the pods
+		if !ok || entry.State&netlink.NUD_PERMANENT != 0 || entry.HardwareAddr == nil {
+			continue
+		}
+		portsByMAC[entry.HardwareAddr.String()] = port
+	}
+
+	portPods := make(map[string]*v1core.Pod)
+	for _, neigh := range neighs {
+		// failed and incomplete entries do not hold a resolved MAC
+		if neigh.IP == nil || neigh.HardwareAddr == nil ||
+			neigh.State&(netlink.NUD_FAILED|netlink.NUD_INCOMPLETE) != 0 {
+			continue
+		}
+		port, ok := portsByMAC[neigh.HardwareAddr.String()]
+		if !ok {
+			continue
+		}
+		if pod, ok := podsByIP[neigh.IP.String()]; ok {
+			portPods[port] = pod
+		}
+	}
+	return portPods
+}
+
+func (hc *HairpinController) newPodEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			hc.RequestSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok := oldObj.(*v1core.Pod)
+			if !ok {
+				return
+			}
+			newPod, ok := newObj.(*v1core.Pod)
+			if !ok {
+				return
+			}
+			if oldPod.Status.PodIP != newPod.Status.PodIP {
+				hc.RequestSync()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			hc.RequestSync()
+		},
+	}
+}
+
+// NewHairpinController returns new HairpinController object
+func NewHairpinController(config *options.KubeRouterConfig,
+	podInformer cache.SharedIndexInformer) (*HairpinController, error) {
+	hc := HairpinController{syncPeriod: config.RoutesSyncPeriod}
+	hc.syncRequestChan = make(chan struct{}, 1)
+
+	if config.MetricsEnabled {
+		prometheus.MustRegister(metrics.ControllerHairpinModePods)
+		hc.MetricsEnabled = true
+	}
+
+	hc.podLister = podInformer.GetIndexer()
+	hc.PodEventHandler = hc.newPodEventHandler()
+
+	return &hc, nil
+}
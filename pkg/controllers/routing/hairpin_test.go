@@ -0,0 +1,60 @@
+package routing
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func mustParseMAC(t *testing.T, mac string) net.HardwareAddr {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", mac, err)
+	}
+	return hwAddr
+}
+
+func Test_podsOnBridgePorts(t *testing.T) {
+	ports := map[int]string{10: "veth1", 11: "veth2", 12: "veth3"}
+	fdb := []netlink.Neigh{
+		// permanent entries of the host side of the veths
+		{LinkIndex: 10, HardwareAddr: mustParseMAC(t, "0a:00:00:00:00:10"), State: netlink.NUD_PERMANENT},
+		{LinkIndex: 11, HardwareAddr: mustParseMAC(t, "0a:00:00:00:00:11"), State: netlink.NUD_PERMANENT},
+		// learned pod MACs
+		{LinkIndex: 10, HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:01"), State: netlink.NUD_REACHABLE},
+		{LinkIndex: 11, HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:02"), State: netlink.NUD_REACHABLE},
+		{LinkIndex: 12, HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:03"), State: netlink.NUD_REACHABLE},
+		// not a port of kube-bridge
+		{LinkIndex: 2, HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:04"), State: netlink.NUD_REACHABLE},
+	}
+	neighs := []netlink.Neigh{
+		{IP: net.ParseIP("172.20.0.2"), HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:01"),
+			State: netlink.NUD_REACHABLE},
+		{IP: net.ParseIP("2001:db8::2"), HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:01"),
+			State: netlink.NUD_STALE},
+		{IP: net.ParseIP("172.20.0.3"), HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:02"),
+			State: netlink.NUD_FAILED},
+		// no pod with this IP
+		{IP: net.ParseIP("172.20.0.4"), HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:03"),
+			State: netlink.NUD_REACHABLE},
+		{IP: net.ParseIP("172.20.0.5"), HardwareAddr: mustParseMAC(t, "0e:00:00:00:00:04"),
+			State: netlink.NUD_REACHABLE},
+	}
+	podsByIP := map[string]*v1core.Pod{
+		"172.20.0.2":  {ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+		"2001:db8::2": {ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+		"172.20.0.3":  {ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}},
+		"172.20.0.5":  {ObjectMeta: metav1.ObjectMeta{Name: "elsewhere", Namespace: "default"}},
+	}
+
+	portPods := podsOnBridgePorts(ports, fdb, neighs, podsByIP)
+	if len(portPods) != 1 {
+		t.Fatalf("expected a single pod to be found, got %v", portPods)
+	}
+	if pod, ok := portPods["veth1"]; !ok || pod.Name != "web" {
+		t.Errorf("expected pod web on veth1, got %v", portPods)
+	}
+}
@@ -0,0 +1,222 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/pager"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// lastIP returns the last address of the network
+func lastIP(network *net.IPNet) *big.Int {
+	ones, bits := network.Mask.Size()
+	last := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	last.Sub(last, big.NewInt(1))
+	return last.Or(last, new(big.Int).SetBytes(network.IP))
+}
+
+// nextFreeBlock returns the first network with the given prefix length within the pool CIDR that does not overlap any
+// of the already allocated networks
+func nextFreeBlock(poolCIDR *net.IPNet, blockSize int, allocated []*net.IPNet) (*net.IPNet, error) {
+	ones, bits := poolCIDR.Mask.Size()
+	if blockSize < ones || blockSize > bits {
+		return nil, fmt.Errorf("block size /%d does not fit in pool %s", blockSize, poolCIDR)
+	}
+	ipLen := bits / 8 //nolint:gomnd // bits per byte
+
+	step := new(big.Int).Lsh(big.NewInt(1), uint(bits-blockSize))
+	poolEnd := lastIP(poolCIDR)
+	candidate := new(big.Int).SetBytes(poolCIDR.IP.Mask(poolCIDR.Mask))
+
+	for candidate.Cmp(poolEnd) <= 0 {
+		ip := make(net.IP, ipLen)
+		candidate.FillBytes(ip)
+		block := &net.IPNet{IP: ip, Mask: net.CIDRMask(blockSize, bits)}
+
+		next := new(big.Int).Add(candidate, step)
+		free := true
+		for _, existing := range allocated {
+			if block.Contains(existing.IP) || existing.Contains(block.IP) {
+				free = false
+				// skip past the allocation, which may be larger than a block when the block size of the pool changed
+				if end := new(big.Int).Add(lastIP(existing), big.NewInt(1)); end.Cmp(next) > 0 {
+					next = end
+				}
+			}
+		}
+		if free {
+			return block, nil
+		}
+		candidate = next
+	}
+	return nil, fmt.Errorf("pool %s has no free /%d blocks left", poolCIDR, blockSize)
+}
+
+// ipPoolSelectsNode returns true if all of the labels of the pool's node selector are set on the node
+func ipPoolSelectsNode(pool *v1alpha1.IPPool, node *v1core.Node) bool {
+	for key, value := range pool.Spec.NodeSelector {
+		if nodeValue, ok := node.Labels[key]; !ok || nodeValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// listNodeNames returns the names of all of the nodes of the cluster. The nodes are listed in pages, as every node does
+// it when it starts.
+func listNodeNames(clientset kubernetes.Interface) (map[string]bool, error) {
+	nodeNames := make(map[string]bool)
+	nodePager := pager.New(pager.SimplePageFunc(func(opts metav1.ListOptions) (runtime.Object, error) {
+		return clientset.CoreV1().Nodes().List(context.Background(), opts)
+	}))
+	err := nodePager.EachListItem(context.Background(), metav1.ListOptions{}, func(obj runtime.Object) error {
+		if n, ok := obj.(*v1core.Node); ok {
+			nodeNames[n.Name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	return nodeNames, nil
+}
+
+// allocateFromIPPool returns the pod CIDR that is allocated to the node from the named pool, allocating a new one if
+// the node does not have one yet. Allocations are recorded in the status of the pool and the update is retried on
+// conflicts, so concurrent allocations by other nodes never hand out the same block twice. Allocations of nodes that no
+// longer exist are released while allocating.
+func allocateFromIPPool(clientset kubernetes.Interface, dynClient dynamic.Interface, poolName,
+	nodeName string) (string, error) {
+	var cidr string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cidr = ""
+		obj, err := dynClient.Resource(v1alpha1.IPPoolResource).Get(context.Background(), poolName,
+			metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		pool := &v1alpha1.IPPool{}
+		if err = v1alpha1.FromUnstructured(obj, pool); err != nil {
+			return err
+		}
+
+		if allocation, ok := pool.Status.Allocations[nodeName]; ok {
+			cidr = allocation
+			return nil
+		}
+		if pool.Spec.Disabled {
+			return nil
+		}
+
+		// the nodes are listed on every attempt and after reading the pool: a node exists before it allocates, so
+		// every node with an allocation in the pool read above is in the list, including the ones that joined while
+		// this node was allocating
+		existingNodes, err := listNodeNames(clientset)
+		if err != nil {
+			return err
+		}
+
+		_, poolCIDR, err := net.ParseCIDR(pool.Spec.CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %v", pool.Spec.CIDR, err)
+		}
+		if pool.Status.Allocations == nil {
+			pool.Status.Allocations = make(map[string]string)
+		}
+		allocated := make([]*net.IPNet, 0, len(pool.Status.Allocations))
+		for allocatedNode, allocation := range pool.Status.Allocations {
+			if !existingNodes[allocatedNode] {
+				klog.Infof("Releasing pod CIDR %s of deleted node %s in IPPool %s", allocation, allocatedNode,
+					poolName)
+				delete(pool.Status.Allocations, allocatedNode)
+				continue
+			}
+			_, network, err := net.ParseCIDR(allocation)
+			if err != nil {
+				klog.Warningf("Ignoring invalid allocation %s of node %s in IPPool %s", allocation, allocatedNode,
+					poolName)
+				continue
+			}
+			allocated = append(allocated, network)
+		}
+
+		block, err := nextFreeBlock(poolCIDR, pool.Spec.BlockSize, allocated)
+		if err != nil {
+			return err
+		}
+		pool.Status.Allocations[nodeName] = block.String()
+
+		obj, err = v1alpha1.ToUnstructured(pool)
+		if err != nil {
+			return err
+		}
+		if _, err = dynClient.Resource(v1alpha1.IPPoolResource).UpdateStatus(context.Background(), obj,
+			metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		klog.Infof("Allocated pod CIDR %s to node %s from IPPool %s", block, nodeName, poolName)
+		cidr = block.String()
+		return nil
+	})
+	return cidr, err
+}
+
+// AllocatePodCIDRsFromIPPools allocates a pod CIDR to this node from every IPPool that selects it and records the
+// result in the kube-router.io/pod-cidrs annotation of the node, which is where the rest of kube-router (and the other
+// nodes) pick up the pod CIDRs from. The allocated CIDRs are returned ordered by the name of their pool.
+func AllocatePodCIDRsFromIPPools(clientset kubernetes.Interface, dynClient dynamic.Interface,
+	hostnameOverride string) ([]string, error) {
+	node, err := utils.GetNodeObject(clientset, hostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+
+	poolList, err := dynClient.Resource(v1alpha1.IPPoolResource).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list IPPools: %v", err)
+	}
+	pools := make([]*v1alpha1.IPPool, 0, len(poolList.Items))
+	for idx := range poolList.Items {
+		pool := &v1alpha1.IPPool{}
+		if err = v1alpha1.FromUnstructured(&poolList.Items[idx], pool); err != nil {
+			klog.Errorf("Ignoring IPPool %s that could not be parsed: %v", poolList.Items[idx].GetName(), err)
+			continue
+		}
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+
+	cidrs := make([]string, 0)
+	for _, pool := range pools {
+		if !ipPoolSelectsNode(pool, node) {
+			continue
+		}
+		cidr, err := allocateFromIPPool(clientset, dynClient, pool.Name, node.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate pod CIDR from IPPool %s: %v", pool.Name, err)
+		}
+		if cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("no enabled IPPool selects node %s", node.Name)
+	}
+
+	if err = annotateNodePodCIDRs(clientset, node, cidrs); err != nil {
+		return nil, err
+	}
+	return cidrs, nil
+}
@@ -0,0 +1,213 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", cidr, err)
+	}
+	return network
+}
+
+func Test_nextFreeBlock(t *testing.T) {
+	testcases := []struct {
+		name      string
+		pool      string
+		blockSize int
+		allocated []string
+		expected  string
+		err       bool
+	}{
+		{"empty pool", "10.0.0.0/16", 24, nil, "10.0.0.0/24", false},
+		{"first block taken", "10.0.0.0/16", 24, []string{"10.0.0.0/24"}, "10.0.1.0/24", false},
+		{"hole between allocations", "10.0.0.0/16", 24, []string{"10.0.0.0/24", "10.0.2.0/24"}, "10.0.1.0/24",
+			false},
+		{"larger allocation is skipped", "10.0.0.0/16", 24, []string{"10.0.0.0/22"}, "10.0.4.0/24", false},
+		{"smaller allocation blocks its block", "10.0.0.0/16", 24, []string{"10.0.0.128/25"}, "10.0.1.0/24", false},
+		{"pool exhausted", "10.0.0.0/23", 24, []string{"10.0.0.0/24", "10.0.1.0/24"}, "", true},
+		{"block larger than pool", "10.0.0.0/24", 16, nil, "", true},
+		{"ipv6", "2001:db8::/48", 64, []string{"2001:db8::/64"}, "2001:db8:0:1::/64", false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			allocated := make([]*net.IPNet, 0)
+			for _, cidr := range tc.allocated {
+				allocated = append(allocated, mustParseCIDR(t, cidr))
+			}
+			block, err := nextFreeBlock(mustParseCIDR(t, tc.pool), tc.blockSize, allocated)
+			if tc.err != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.err, err)
+			}
+			if err == nil && block.String() != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, block)
+			}
+		})
+	}
+}
+
+func Test_AllocatePodCIDRsFromIPPools(t *testing.T) {
+	nodes := []*v1core.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"rack": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+	}
+	pools := []*v1alpha1.IPPool{
+		{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "IPPool"},
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec:       v1alpha1.IPPoolSpec{CIDR: "10.0.0.0/16", BlockSize: 24},
+			Status: v1alpha1.IPPoolStatus{Allocations: map[string]string{
+				"node-2":       "10.0.0.0/24",
+				"deleted-node": "10.0.1.0/24",
+			}},
+		},
+		{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "IPPool"},
+			ObjectMeta: metav1.ObjectMeta{Name: "rack-a"},
+			Spec: v1alpha1.IPPoolSpec{CIDR: "192.168.0.0/16", BlockSize: 26,
+				NodeSelector: map[string]string{"rack": "a"}},
+		},
+		{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "IPPool"},
+			ObjectMeta: metav1.ObjectMeta{Name: "rack-b"},
+			Spec: v1alpha1.IPPoolSpec{CIDR: "172.16.0.0/16", BlockSize: 24,
+				NodeSelector: map[string]string{"rack": "b"}},
+		},
+		{
+			TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "IPPool"},
+			ObjectMeta: metav1.ObjectMeta{Name: "retired"},
+			Spec:       v1alpha1.IPPoolSpec{CIDR: "172.31.0.0/16", BlockSize: 24, Disabled: true},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	for _, node := range nodes {
+		if _, err := clientset.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create node: %v", err)
+		}
+	}
+	objs := make([]runtime.Object, 0)
+	for _, pool := range pools {
+		obj, err := v1alpha1.ToUnstructured(pool)
+		if err != nil {
+			t.Fatalf("failed to convert IPPool: %v", err)
+		}
+		objs = append(objs, obj)
+	}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.IPPoolResource: "IPPoolList"}, objs...)
+
+	cidrs, err := AllocatePodCIDRsFromIPPools(clientset, dynClient, "node-1")
+	if err != nil {
+		t.Fatalf("failed to allocate pod CIDRs: %v", err)
+	}
+	expected := []string{"10.0.1.0/24", "192.168.0.0/26"}
+	if !reflect.DeepEqual(expected, cidrs) {
+		t.Errorf("expected pod CIDRs %v, got %v", expected, cidrs)
+	}
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	if annotation := node.Annotations[utils.PodCIDRsAnnotation]; annotation != "10.0.1.0/24,192.168.0.0/26" {
+		t.Errorf("unexpected pod CIDRs annotation %q", annotation)
+	}
+
+	obj, err := dynClient.Resource(v1alpha1.IPPoolResource).Get(context.Background(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get IPPool: %v", err)
+	}
+	pool := &v1alpha1.IPPool{}
+	if err = v1alpha1.FromUnstructured(obj, pool); err != nil {
+		t.Fatalf("failed to convert IPPool: %v", err)
+	}
+	expectedAllocations := map[string]string{"node-1": "10.0.1.0/24", "node-2": "10.0.0.0/24"}
+	if !reflect.DeepEqual(expectedAllocations, pool.Status.Allocations) {
+		t.Errorf("expected allocations %v, got %v", expectedAllocations, pool.Status.Allocations)
+	}
+
+	// allocating again must return the same CIDRs
+	cidrs, err = AllocatePodCIDRsFromIPPools(clientset, dynClient, "node-1")
+	if err != nil {
+		t.Fatalf("failed to allocate pod CIDRs: %v", err)
+	}
+	if !reflect.DeepEqual(expected, cidrs) {
+		t.Errorf("expected pod CIDRs %v on reallocation, got %v", expected, cidrs)
+	}
+}
+
+func Test_AllocatePodCIDRsFromIPPoolsConcurrentNode(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})
+	pool, err := v1alpha1.ToUnstructured(&v1alpha1.IPPool{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "IPPool"},
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1alpha1.IPPoolSpec{CIDR: "10.0.0.0/16", BlockSize: 24},
+	})
+	if err != nil {
+		t.Fatalf("failed to convert IPPool: %v", err)
+	}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.IPPoolResource: "IPPoolList"}, pool)
+
+	// node-2 joins and allocates the first block after node-1 read the pool, so the first update of node-1 conflicts
+	conflicted := false
+	dynClient.PrependReactor("update", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicted || action.GetSubresource() != "status" {
+			return false, nil, nil
+		}
+		conflicted = true
+		if _, err := clientset.CoreV1().Nodes().Create(context.Background(),
+			&v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create node: %v", err)
+		}
+		concurrent := pool.DeepCopy()
+		concurrent.Object["status"] = map[string]interface{}{
+			"allocations": map[string]interface{}{"node-2": "10.0.0.0/24"}}
+		if err := dynClient.Tracker().Update(v1alpha1.IPPoolResource, concurrent, ""); err != nil {
+			t.Fatalf("failed to update IPPool: %v", err)
+		}
+		return true, nil, apierrors.NewConflict(v1alpha1.IPPoolResource.GroupResource(), "default", nil)
+	})
+
+	cidrs, err := AllocatePodCIDRsFromIPPools(clientset, dynClient, "node-1")
+	if err != nil {
+		t.Fatalf("failed to allocate pod CIDRs: %v", err)
+	}
+	if !conflicted {
+		t.Fatalf("expected the allocation to conflict with the one of node-2")
+	}
+	if expected := []string{"10.0.1.0/24"}; !reflect.DeepEqual(expected, cidrs) {
+		t.Errorf("expected pod CIDRs %v, got %v", expected, cidrs)
+	}
+
+	obj, err := dynClient.Resource(v1alpha1.IPPoolResource).Get(context.Background(), "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get IPPool: %v", err)
+	}
+	allocated := &v1alpha1.IPPool{}
+	if err = v1alpha1.FromUnstructured(obj, allocated); err != nil {
+		t.Fatalf("failed to convert IPPool: %v", err)
+	}
+	expectedAllocations := map[string]string{"node-1": "10.0.1.0/24", "node-2": "10.0.0.0/24"}
+	if !reflect.DeepEqual(expectedAllocations, allocated.Status.Allocations) {
+		t.Errorf("expected allocations %v, got %v", expectedAllocations, allocated.Status.Allocations)
+	}
+}
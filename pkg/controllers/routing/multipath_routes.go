@@ -0,0 +1,142 @@
+package routing
+
+import (
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// groupPathsByDestination groups the paths learned from the peers in a table event by their destination, keeping the
+// order of the paths which GoBGP sorts from the best to the worst
+func groupPathsByDestination(paths []*gobgpapi.Path) [][]*gobgpapi.Path {
+	groups := make([][]*gobgpapi.Path, 0)
+	index := make(map[string]int)
+	for _, path := range paths {
+		if path.Family.Afi != gobgpapi.Family_AFI_IP && path.Family.Safi != gobgpapi.Family_SAFI_UNICAST {
+			continue
+		}
+		// locally originated paths
+		if path.NeighborIp == "<nil>" {
+			continue
+		}
+		dst, _, err := parseBGPPath(path)
+		if err != nil {
+			klog.Errorf("Failed to parse BGP path: %v", err)
+			continue
+		}
+		i, ok := index[dst.String()]
+		if !ok {
+			i = len(groups)
+			index[dst.String()] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], path)
+	}
+	return groups
+}
+
+// multipathRoute returns an ECMP route to the destination across all of the next hops, or a plain route when there is
+// a single one
+func multipathRoute(dst *net.IPNet, nextHops []net.IP) *netlink.Route {
+	if len(nextHops) == 1 {
+		return &netlink.Route{
+			Dst:      dst,
+			Gw:       nextHops[0],
+			Protocol: zebraRouteOriginator,
+		}
+	}
+	multiPath := make([]*netlink.NexthopInfo, 0, len(nextHops))
+	for _, nextHop := range nextHops {
+		multiPath = append(multiPath, &netlink.NexthopInfo{Gw: nextHop})
+	}
+	return &netlink.Route{
+		Dst:       dst,
+		MultiPath: multiPath,
+		Protocol:  zebraRouteOriginator,
+	}
+}
+
+// injectMultipathRoutes installs the routes of a table event when ECMP is enabled. GoBGP then hands over all of the
+// equally good paths to a destination at once, each time the set changes, so the set replaces whatever route was
+// installed before: a route through the remaining next hop when only one path is left, or no route at all when the
+// last path is withdrawn.
+func (nrc *NetworkRoutingController) injectMultipathRoutes(paths []*gobgpapi.Path) {
+	for _, dstPaths := range groupPathsByDestination(paths) {
+		if nrc.MetricsEnabled {
+			metrics.ControllerBGPadvertisementsReceived.Add(float64(len(dstPaths)))
+		}
+		active := make([]*gobgpapi.Path, 0, len(dstPaths))
+		for _, path := range dstPaths {
+			if !path.IsWithdraw {
+				active = append(active, path)
+			}
+		}
+
+		var err error
+		if len(active) == 0 {
+			err = nrc.injectRoute(dstPaths[0])
+		} else {
+			err = nrc.injectMultipathRoute(active)
+		}
+		if err != nil {
+			klog.Errorf("Failed to inject routes due to: " + err.Error())
+		}
+	}
+}
+
+// injectMultipathRoute installs an ECMP route across the next hops of the paths to a destination. Only next hops that
+// are directly reachable, either in the subnet of the node or directly connected external peers such as the ToR
+// switches of a dual-homed node which are usually on a subnet of their own, are routed to this way. Otherwise the
+// route through the best path only is installed as usual.
+func (nrc *NetworkRoutingController) injectMultipathRoute(paths []*gobgpapi.Path) error {
+	var dst *net.IPNet
+	nextHops := make([]net.IP, 0, len(paths))
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		pathDst, nextHop, err := parseBGPPath(path)
+		if err != nil {
+			return err
+		}
+		sameSubnet := nrc.inNodeSubnet(nextHop)
+		if (!sameSubnet || nrc.shouldCreateTunnel(sameSubnet)) && !nrc.isDirectlyConnectedPeer(nextHop) {
+			klog.V(2).Infof("Next hop %s of %s isn't directly reachable, only installing the route through the "+
+				"best path", nextHop, pathDst)
+			return nrc.injectRoute(paths[0])
+		}
+		dst = pathDst
+		if !seen[nextHop.String()] {
+			seen[nextHop.String()] = true
+			nextHops = append(nextHops, nextHop)
+		}
+	}
+	for _, nextHop := range nextHops {
+		// clean up the tunnels that may be left over from an overlay configuration, without touching the route
+		tunnelName := generateTunnelName(nextHop.String())
+		if _, err := netlink.LinkByName(tunnelName); err == nil {
+			nrc.cleanupTunnel(dst, tunnelName)
+		}
+	}
+
+	klog.V(2).Infof("Inject route: '%s via %v' from peers to routing table", dst, nextHops)
+	nrc.routeSyncer.addInjectedRoute(dst, multipathRoute(dst, nextHops))
+	// Immediately sync the local route table regardless of timer
+	nrc.routeSyncer.syncLocalRouteTable()
+	return nil
+}
+
+// isDirectlyConnectedPeer tells whether the next hop is one of the external BGP peers, which are directly connected
+// unless eBGP multihop is used
+func (nrc *NetworkRoutingController) isDirectlyConnectedPeer(nextHop net.IP) bool {
+	for _, peer := range nrc.externalPeers() {
+		if peer.EbgpMultihop != nil && peer.EbgpMultihop.Enabled {
+			continue
+		}
+		if peer.Conf != nil && nextHop.Equal(net.ParseIP(peer.Conf.NeighborAddress)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package routing
+
+import (
+	"net"
+	"testing"
+
+	gobgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func generateTestPath(prefix string, prefixLen uint32, nextHop, neighbor string, withdraw bool) *gobgpapi.Path {
+	nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{Prefix: prefix, PrefixLen: prefixLen})
+	origin, _ := anypb.New(&gobgpapi.OriginAttribute{Origin: 0})
+	nh, _ := anypb.New(&gobgpapi.NextHopAttribute{NextHop: nextHop})
+	return &gobgpapi.Path{
+		Family:     &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+		Nlri:       nlri,
+		Pattrs:     []*anypb.Any{origin, nh},
+		NeighborIp: neighbor,
+		IsWithdraw: withdraw,
+	}
+}
+
+func Test_groupPathsByDestination(t *testing.T) {
+	paths := []*gobgpapi.Path{
+		generateTestPath("10.10.0.0", 16, "192.168.0.1", "192.168.0.1", false),
+		generateTestPath("10.20.0.0", 16, "192.168.0.1", "192.168.0.1", false),
+		generateTestPath("10.10.0.0", 16, "192.168.1.1", "192.168.1.1", false),
+		generateTestPath("10.30.0.0", 16, "192.168.0.10", "<nil>", false),
+	}
+
+	groups := groupPathsByDestination(paths)
+	assert.Equal(t, [][]*gobgpapi.Path{{paths[0], paths[2]}, {paths[1]}}, groups,
+		"expected the paths from the peers grouped by destination in order, without the local paths")
+}
+
+func Test_injectMultipathRoute(t *testing.T) {
+	var replaced []*netlink.Route
+	nrc := &NetworkRoutingController{
+		nodeSubnet: net.IPNet{IP: net.ParseIP("192.168.0.0"), Mask: net.CIDRMask(16, 32)},
+		globalPeerRouters: []*gobgpapi.Peer{
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.1.0"}},
+			{Conf: &gobgpapi.PeerConf{NeighborAddress: "10.0.2.0"}},
+		},
+		routeSyncer: &routeSyncer{
+			routeTableStateMap: make(map[string]*netlink.Route),
+			routeReplacer: func(route *netlink.Route) error {
+				replaced = append(replaced, route)
+				return nil
+			},
+		},
+	}
+
+	err := nrc.injectMultipathRoute([]*gobgpapi.Path{
+		generateTestPath("10.10.0.0", 16, "192.168.0.1", "192.168.0.1", false),
+		generateTestPath("10.10.0.0", 16, "192.168.1.1", "192.168.1.1", false),
+	})
+	assert.NoError(t, err)
+
+	route, ok := nrc.routeSyncer.routeTableStateMap["10.10.0.0/16"]
+	assert.True(t, ok, "expected a route to the destination to be injected")
+	assert.Nil(t, route.Gw)
+	assert.Equal(t, []*netlink.NexthopInfo{{Gw: net.ParseIP("192.168.0.1").To4()}, {Gw: net.ParseIP("192.168.1.1").To4()}},
+		route.MultiPath, "expected an ECMP route across both peers")
+	assert.Equal(t, []*netlink.Route{route}, replaced, "expected the route to be synced right away")
+
+	// the ToRs of a dual-homed node are on point-to-point subnets of their own
+	err = nrc.injectMultipathRoute([]*gobgpapi.Path{
+		generateTestPath("172.16.0.0", 12, "10.0.1.0", "10.0.1.0", false),
+		generateTestPath("172.16.0.0", 12, "10.0.2.0", "10.0.2.0", false),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, nrc.routeSyncer.routeTableStateMap["172.16.0.0/12"].MultiPath, 2,
+		"expected an ECMP route across both directly connected peers")
+
+	// one of the links failed
+	err = nrc.injectMultipathRoute([]*gobgpapi.Path{
+		generateTestPath("172.16.0.0", 12, "10.0.2.0", "10.0.2.0", false),
+	})
+	assert.NoError(t, err)
+	route = nrc.routeSyncer.routeTableStateMap["172.16.0.0/12"]
+	assert.Nil(t, route.MultiPath)
+	assert.True(t, route.Gw.Equal(net.ParseIP("10.0.2.0")), "expected the route to move to the remaining peer")
+}
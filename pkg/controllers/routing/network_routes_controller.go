@@ -2,23 +2,26 @@ package routing
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
-	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/protobuf/types/known/anypb"
 
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/cloudnativelabs/kube-router/pkg/bfd"
+	"github.com/cloudnativelabs/kube-router/pkg/cni"
 	"github.com/cloudnativelabs/kube-router/pkg/healthcheck"
 	"github.com/cloudnativelabs/kube-router/pkg/metrics"
 	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/tracing"
 	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"github.com/coreos/go-iptables/iptables"
 	gobgpapi "github.com/osrg/gobgp/v3/api"
@@ -27,9 +30,13 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vishvananda/netlink"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	v1core "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -40,6 +47,9 @@ const (
 	podSubnetsIPSetName  = "kube-router-pod-subnets"
 	nodeAddrsIPSetName   = "kube-router-node-ips"
 
+	// routingSyncFailedEventReason is the reason of the Events about the parts of the periodic sync that failed
+	routingSyncFailedEventReason = "RoutingSyncFailed"
+
 	nodeASNAnnotation                = "kube-router.io/node.asn"
 	nodeCommunitiesAnnotation        = "kube-router.io/node.bgp.communities"
 	nodeCustomImportRejectAnnotation = "kube-router.io/node.bgp.customimportreject"
@@ -48,8 +58,14 @@ const (
 	peerASNAnnotation                = "kube-router.io/peer.asns"
 	peerIPAnnotation                 = "kube-router.io/peer.ips"
 	peerLocalIPAnnotation            = "kube-router.io/peer.localips"
+	peerGracefulRestartAnnotation    = "kube-router.io/peer.gracefulrestarttimes"
+	peerLongLivedStaleAnnotation     = "kube-router.io/peer.longlivedstaletimes"
+	peerMaxPrefixesAnnotation        = "kube-router.io/peer.maxprefixes"
+	peerMultihopTTLAnnotation        = "kube-router.io/peer.multihopttls"
+	peerTTLSecurityHopsAnnotation    = "kube-router.io/peer.ttlsecurityhops"
 	//nolint:gosec // this is not a hardcoded password
 	peerPasswordAnnotation             = "kube-router.io/peer.passwords"
+	peerPasswordSecretsAnnotation      = "kube-router.io/peer.passwordsecrets"
 	peerPortAnnotation                 = "kube-router.io/peer.ports"
 	rrClientAnnotation                 = "kube-router.io/rr.client"
 	rrServerAnnotation                 = "kube-router.io/rr.server"
@@ -72,18 +88,34 @@ const (
 	bgpCommunityMaxPartSize = 16
 	routeReflectorMaxID     = 32
 	ipv4MaskMinBits         = 32
+	// The restart time of the Graceful Restart capability (RFC 4724 3) and the stale time of the Long-Lived Graceful
+	// Restart capability (RFC 9494 3) are 12 and 24 bit numbers of seconds
+	maxGracefulRestartTime = 4095 * time.Second
+	maxLongLivedStaleTime  = 16777215 * time.Second
+	// maxTTL is the TTL of the packets of the sessions with GTSM (RFC 5082 3)
+	maxTTL = 255
 	// Taken from: https://github.com/torvalds/linux/blob/master/include/uapi/linux/rtnetlink.h#L284
 	zebraRouteOriginator = 0x11
 )
 
+// tracer creates the spans of the syncs of the controller
+var tracer = tracing.Tracer("routing")
+
 // NetworkRoutingController is struct to hold necessary information required by controller
 type NetworkRoutingController struct {
 	nodeIP                         net.IP
+	bgpIP                          net.IP
+	overlayIP                      net.IP
+	bgpInterface                   string
+	overlayInterface               string
 	nodeName                       string
 	nodeSubnet                     net.IPNet
+	nodeDualStackIP                net.IP
+	nodeDualStackSubnet            net.IPNet
 	nodeInterface                  string
 	routerID                       string
 	isIpv6                         bool
+	enableDualStack                bool
 	activeNodes                    map[string]bool
 	mu                             sync.Mutex
 	clientset                      kubernetes.Interface
@@ -101,18 +133,63 @@ type NetworkRoutingController struct {
 	nodeAsnNumber                  uint32
 	nodeCustomImportRejectIPNets   []net.IPNet
 	nodeCommunities                []string
+	clusterIPCommunities           []uint32
+	externalIPCommunities          []uint32
+	loadBalancerIPCommunities      []uint32
+	podCIDRCommunities             []uint32
+	podCIDRSummaries               []*net.IPNet
+	podCIDRSummaryPeers            []string
+	importExternalRoutes           []*net.IPNet
+	importExternalRoutesTable      int
+	nextHopResolver                func(nextHop net.IP) (net.IP, error)
 	globalPeerRouters              []*gobgpapi.Peer
+	globalPeerPasswordSecrets      []*v1alpha1.SecretKeyReference
 	nodePeerRouters                []string
+	resourcePeerRouters            []*gobgpapi.Peer
+	unnumberedPeerRouters          []*gobgpapi.Peer
+	dynamicNeighborRanges          []dynamicNeighborRange
+	bgpPeerResourcePeers           map[string]*bgpPeerResourcePeer
+	bgpPolicyResourcesApplied      *bgpPolicyResourcePolicies
+	bgpResourceSyncChan            chan struct{}
 	enableCNI                      bool
 	bgpFullMeshMode                bool
 	bgpEnableInternal              bool
 	bgpGracefulRestart             bool
 	bgpGracefulRestartTime         time.Duration
 	bgpGracefulRestartDeferralTime time.Duration
+	bgpLongLivedGracefulRestart    bool
+	bgpLongLivedStaleTime          time.Duration
+	gracefulWithdraw               bool
+	gracefulWithdrawDelay          time.Duration
+	shuttingDown                   atomic.Bool
+	bgpAddPaths                    uint8
+	vipWeight                      string
 	ipSetHandler                   *utils.IPSet
 	enableOverlays                 bool
 	overlayType                    string
+	overlayEncapsulation           string
+	vxlanPort                      uint16
+	genevePort                     uint16
+	wireguardPort                  uint16
+	wireguardPrivateKeyFile        string
+	wireguardPublicKey             string
+	wireguardPeers                 map[string]*wireguardPeer
+	wireguardMutex                 sync.Mutex
+	overlayMTUProbePeriod          time.Duration
+	overlayRoutes                  map[string]net.IP
+	overlayPathMTUs                map[string]int
+	overlayMTUMutex                sync.Mutex
+	pathMTUProber                  func(dst net.IP, size int) (bool, error)
 	peerMultihopTTL                uint8
+	peerBFD                        bool
+	peerBFDInterval                time.Duration
+	peerBFDMultiplier              uint8
+	peerECMP                       bool
+	peerMaxPrefixesWarningPct      uint32
+	peerMaxPrefixesRestartTime     time.Duration
+	rpkiCaches                     []*gobgpapi.AddRpkiRequest
+	rpkiDefaultInvalidAction       string
+	bfdServer                      *bfd.Server
 	MetricsEnabled                 bool
 	bgpServerStarted               bool
 	bgpHoldtime                    float64
@@ -120,7 +197,13 @@ type NetworkRoutingController struct {
 	bgpRRClient                    bool
 	bgpRRServer                    bool
 	bgpClusterID                   string
+	ibgpZoneLabel                  string
+	ibgpZoneRouteReflectors        uint
+	ibgpZonePeers                  map[string]string
 	cniConfFile                    string
+	cniConfig                      *cni.ConfigManager
+	cniMTU                         int
+	cniMTUMutex                    sync.Mutex
 	disableSrcDstCheck             bool
 	initSrcDstCheckDone            bool
 	ec2IamAuthorized               bool
@@ -128,19 +211,81 @@ type NetworkRoutingController struct {
 	pathPrependCount               uint8
 	pathPrepend                    bool
 	localAddressList               []string
+	localAddressFromNodeIP         bool
+	routerIDFromNodeIP             bool
+	nodeIPChangeChan               chan net.IP
 	overrideNextHop                bool
 	podCidr                        string
+	podCIDRs                       []string
+	cniBandwidthPlugin             bool
+	cniMode                        string
+	cniTuningSysctls               map[string]string
 	CNIFirewallSetup               *sync.Cond
 	ipsetMutex                     *sync.Mutex
+	sysctls                        *utils.SysctlManager
 	routeSyncer                    *routeSyncer
+	advertiseGates                 *advertiseGates
+	routeDampener                  *flapDampener
+	vipDampener                    *flapDampener
 
 	nodeLister cache.Indexer
 	svcLister  cache.Indexer
 	epLister   cache.Indexer
-
-	NodeEventHandler      cache.ResourceEventHandler
-	ServiceEventHandler   cache.ResourceEventHandler
-	EndpointsEventHandler cache.ResourceEventHandler
+	// bgpPeerLister lists the BGPPeer resources when they are enabled, see EnableBGPPeerResources
+	bgpPeerLister cache.Indexer
+	// bgpPolicyLister lists the BGPPolicy resources when they are enabled, see EnableBGPPolicyResources
+	bgpPolicyLister cache.Indexer
+	// staticRouteLister lists the StaticRoute resources when they are enabled, see EnableStaticRouteResources
+	staticRouteLister cache.Indexer
+	// clusterCIDRLister lists the ClusterCIDR objects when they are enabled, see EnableClusterCIDRs
+	clusterCIDRLister cache.Indexer
+	// egressIPLister lists the EgressIP resources when they are enabled, see EnableEgressIPResources, along with the
+	// pods and the namespaces they select
+	egressIPLister cache.Indexer
+	podLister      cache.Indexer
+	nsLister       cache.Indexer
+	// gatewayLister lists the Gateways when their addresses are advertised, see EnableGatewayAddresses, along with
+	// the GatewayClasses
+	gatewayLister      cache.Indexer
+	gatewayClassLister cache.Indexer
+	// bgpPeerStatusClient writes the status of the BGPPeer resources when they are enabled
+	bgpPeerStatusClient dynamic.Interface
+	eventRecorder       record.EventRecorder
+	logger              klog.Logger
+
+	// the BGP sessions with the peers by address and the names of the BGPPeer resources of the peers, along with the
+	// statuses of the resources as last written
+	bgpPeerSessions      map[string]*bgpPeerSession
+	bgpPeerResourceNames map[string]string
+	bgpPeerStatuses      map[string]v1alpha1.BGPPeerNodeStatus
+	bgpPeerStatusMutex   sync.Mutex
+
+	// the client writing the status of the EgressIPs, the rules of their chains by table as last set and the egress
+	// addresses the node holds
+	egressIPStatusClient dynamic.Interface
+	egressIPSyncChan     chan struct{}
+	egressIPChainRules   map[string][][]string
+	egressIPSetsCleaned  bool
+	egressIPsHeld        []string
+	egressIPMutex        sync.Mutex
+
+	// the addresses of the Gateways as last advertised, to withdraw the ones the Gateways let go of
+	gatewayVIPsAdvertised []string
+	gatewayMutex          sync.Mutex
+
+	NodeEventHandler        cache.ResourceEventHandler
+	ServiceEventHandler     cache.ResourceEventHandler
+	EndpointsEventHandler   cache.ResourceEventHandler
+	BGPPeerEventHandler     cache.ResourceEventHandler
+	BGPPolicyEventHandler   cache.ResourceEventHandler
+	StaticRouteEventHandler cache.ResourceEventHandler
+	ClusterCIDREventHandler cache.ResourceEventHandler
+	// the handlers of the EgressIPs, the pods and the namespaces when the EgressIPs are enabled
+	EgressIPEventHandler          cache.ResourceEventHandler
+	EgressIPPodEventHandler       cache.ResourceEventHandler
+	EgressIPNamespaceEventHandler cache.ResourceEventHandler
+	// GatewayEventHandler is only set when the addresses of the Gateways are advertised
+	GatewayEventHandler cache.ResourceEventHandler
 }
 
 // Run runs forever until we are notified on stop channel
@@ -148,7 +293,11 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 	wg *sync.WaitGroup) {
 	var err error
 	if nrc.enableCNI {
-		nrc.updateCNIConfig()
+		if _, err = nrc.cniConfig.Sync(); err != nil {
+			klog.Fatalf("Failed to write CNI conf file %s: %s", nrc.cniConfFile, err.Error())
+		}
+		wg.Add(1)
+		go nrc.cniConfig.Run(nrc.syncPeriod, stopCh, wg)
 	}
 
 	klog.V(1).Info("Populating ipsets.")
@@ -164,6 +313,16 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 	}
 
 	// enable IP forwarding for the packets coming in/out from the pods
+	if sysctlErr := nrc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv4IPForward, Value: 1,
+		Reason: "routing of pod traffic"}); sysctlErr != nil {
+		klog.Errorf("Failed to enable IPv4 forwarding of traffic from pods: %s", sysctlErr.Error())
+	}
+	if nrc.isIpv6 || nrc.enableDualStack {
+		if sysctlErr := nrc.sysctls.Ensure(utils.SysctlSetting{Path: utils.IPv6ConfAllForwarding, Value: 1,
+			Reason: "routing of pod traffic"}); sysctlErr != nil {
+			klog.Errorf("Failed to enable IPv6 forwarding of traffic from pods: %s", sysctlErr.Error())
+		}
+	}
 	err = nrc.enableForwarding()
 	if err != nil {
 		klog.Errorf("Failed to enable IP forwarding of traffic from pods: %s", err.Error())
@@ -179,6 +338,7 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 		if err != nil {
 			klog.Errorf("Failed to enable required policy based routing: %s", err.Error())
 		}
+		nrc.setupOverlayEncapsulation()
 	} else {
 		klog.V(1).Info("IPIP Tunnel Overlay disabled in configuration.")
 		klog.V(1).Info("Cleaning up old overlay networking if needed.")
@@ -186,6 +346,8 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 		if err != nil {
 			klog.Errorf("Failed to disable policy based routing: %s", err.Error())
 		}
+		deleteVXLAN()
+		deleteWireGuard()
 	}
 
 	klog.V(1).Info("Performing cleanup of depreciated rules/ipsets (if needed).")
@@ -211,71 +373,8 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 		}
 	}
 
-	// create 'kube-bridge' interface to which pods will be connected
-	kubeBridgeIf, err := netlink.LinkByName("kube-bridge")
-	if err != nil && err.Error() == IfaceNotFound {
-		linkAttrs := netlink.NewLinkAttrs()
-		linkAttrs.Name = "kube-bridge"
-		bridge := &netlink.Bridge{LinkAttrs: linkAttrs}
-		if err = netlink.LinkAdd(bridge); err != nil {
-			klog.Errorf("Failed to create `kube-router` bridge due to %s. Will be created by CNI bridge "+
-				"plugin when pod is launched.", err.Error())
-		}
-		kubeBridgeIf, err = netlink.LinkByName("kube-bridge")
-		if err != nil {
-			klog.Errorf("Failed to find created `kube-router` bridge due to %s. Will be created by CNI "+
-				"bridge plugin when pod is launched.", err.Error())
-		}
-		err = netlink.LinkSetUp(kubeBridgeIf)
-		if err != nil {
-			klog.Errorf("Failed to bring `kube-router` bridge up due to %s. Will be created by CNI bridge "+
-				"plugin at later point when pod is launched.", err.Error())
-		}
-	}
-
-	if nrc.autoMTU {
-		mtu, err := utils.GetMTUFromNodeIP(nrc.nodeIP)
-		if err != nil {
-			klog.Errorf("Failed to find MTU for node IP: %s for intelligently setting the kube-bridge MTU "+
-				"due to %s.", nrc.nodeIP, err.Error())
-		}
-		if mtu > 0 {
-			klog.Infof("Setting MTU of kube-bridge interface to: %d", mtu)
-			err = netlink.LinkSetMTU(kubeBridgeIf, mtu)
-			if err != nil {
-				klog.Errorf(
-					"Failed to set MTU for kube-bridge interface due to: %s (kubeBridgeIf: %#v, mtu: %v)",
-					err.Error(), kubeBridgeIf, mtu,
-				)
-				// need to correct kuberouter.conf because autoConfigureMTU() may have set an invalid value!
-				currentMTU := kubeBridgeIf.Attrs().MTU
-				if currentMTU > 0 && currentMTU != mtu {
-					klog.Warningf("Updating config file with current MTU for kube-bridge: %d", currentMTU)
-					if err := nrc.configureMTU(currentMTU); err != nil {
-						klog.Errorf("Failed to update config file due to: %s", err.Error())
-					}
-				}
-			}
-		} else {
-			klog.Infof("Not setting MTU of kube-bridge interface")
-		}
-	}
-	// enable netfilter for the bridge
-	if _, err := exec.Command("modprobe", "br_netfilter").CombinedOutput(); err != nil {
-		klog.Errorf("Failed to enable netfilter for bridge. Network policies and service proxy may "+
-			"not work: %s", err.Error())
-	}
-	sysctlErr := utils.SetSysctl(utils.BridgeNFCallIPTables, 1)
-	if sysctlErr != nil {
-		klog.Errorf("Failed to enable iptables for bridge. Network policies and service proxy may "+
-			"not work: %s", sysctlErr.Error())
-	}
-	if nrc.isIpv6 {
-		sysctlErr = utils.SetSysctl(utils.BridgeNFCallIP6Tables, 1)
-		if sysctlErr != nil {
-			klog.Errorf("Failed to enable ip6tables for bridge. Network policies and service proxy may "+
-				"not work: %s", sysctlErr.Error())
-		}
+	if nrc.cniMode != options.CNIModePTP {
+		nrc.setupKubeBridge()
 	}
 
 	t := time.NewTicker(nrc.syncPeriod)
@@ -287,6 +386,29 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 	// Start route syncer
 	nrc.routeSyncer.run(stopCh, wg)
 
+	// Start probing the path MTU to the nodes the overlay tunnels go to
+	if nrc.enableOverlays && nrc.autoMTU && nrc.overlayMTUProbePeriod > 0 {
+		nrc.runOverlayMTUProbes(stopCh, wg)
+	}
+
+	// Start evaluating the health checks and Leases gating the advertisement of service VIPs
+	nrc.advertiseGates.run(stopCh, wg)
+
+	// Start bringing back the routes and VIPs suppressed for flapping
+	nrc.routeDampener.run(stopCh, wg)
+	nrc.vipDampener.run(stopCh, wg)
+
+	if nrc.peerBFD {
+		bfdServer := bfd.NewServer()
+		if err = bfdServer.Start(); err != nil {
+			klog.Errorf("Failed to start BFD server, BGP sessions with the external peers won't be monitored "+
+				"with BFD: %s", err)
+		} else {
+			nrc.bfdServer = bfdServer
+			defer bfdServer.Stop()
+		}
+	}
+
 	// Wait till we are ready to launch BGP server
 	for {
 		err := nrc.startBgpServer(true)
@@ -306,6 +428,17 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 	}
 
 	nrc.bgpServerStarted = true
+
+	// Start exporting the state of the BGP sessions with the peers
+	if nrc.MetricsEnabled || nrc.bgpPeerStatusClient != nil {
+		nrc.runBGPPeerStatus(stopCh, wg)
+	}
+
+	// Start translating the traffic of the pods to the egress addresses of the EgressIPs
+	if nrc.egressIPLister != nil {
+		nrc.runEgressIPs(stopCh, wg)
+	}
+
 	if !nrc.bgpGracefulRestart {
 		defer func() {
 			err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
@@ -315,6 +448,11 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 		}()
 	}
 
+	// Withdraw the advertisements of the node before the BGP server is stopped
+	if nrc.gracefulWithdraw {
+		defer nrc.withdrawOnShutdown()
+	}
+
 	// loop forever till notified to stop on stopCh
 	for {
 		var err error
@@ -325,45 +463,83 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 		default:
 		}
 
+		if !nrc.bgpServerStarted {
+			nrc.restartBgpServer()
+		}
+
+		ctx, syncSpan := tracer.Start(context.Background(), "routing.sync")
+
 		// Update ipset entries
 		if nrc.enablePodEgress || nrc.enableOverlays {
 			klog.V(1).Info("Syncing ipsets")
+			_, span := tracer.Start(ctx, "sync ipsets")
 			err = nrc.syncNodeIPSets()
+			tracing.End(span, err)
 			if err != nil {
 				klog.Errorf("Error synchronizing ipsets: %s", err.Error())
+				utils.RecordNodeWarning(nrc.eventRecorder, nrc.nodeName, routingSyncFailedEventReason,
+					"Failed to sync the ipsets: %v", err)
 			}
 		}
 
-		// enable IP forwarding for the packets coming in/out from the pods
-		err = nrc.enableForwarding()
-		if err != nil {
-			klog.Errorf("Failed to enable IP forwarding of traffic from pods: %s", err.Error())
-		}
-
 		// advertise or withdraw IPs for the services to be reachable via host
+		_, span := tracer.Start(ctx, "get service VIPs")
 		toAdvertise, toWithdraw, err := nrc.getActiveVIPs()
+		tracing.End(span, err)
 		if err != nil {
 			klog.Errorf("failed to get routes to advertise/withdraw %s", err)
 		}
 
 		klog.V(1).Infof("Performing periodic sync of service VIP routes")
+		_, span = tracer.Start(ctx, "BGP update of service VIPs", trace.WithAttributes(
+			attribute.Int("advertised", len(toAdvertise)), attribute.Int("withdrawn", len(toWithdraw))))
 		nrc.advertiseVIPs(toAdvertise)
 		nrc.withdrawVIPs(toWithdraw)
+		span.End()
 
 		klog.V(1).Info("Performing periodic sync of pod CIDR routes")
+		_, span = tracer.Start(ctx, "BGP update of pod CIDRs")
 		err = nrc.advertisePodRoute()
+		tracing.End(span, err)
 		if err != nil {
 			klog.Errorf("Error advertising route: %s", err.Error())
+			utils.RecordNodeWarning(nrc.eventRecorder, nrc.nodeName, routingSyncFailedEventReason,
+				"Failed to advertise the pod CIDR routes: %v", err)
+		}
+
+		_, span = tracer.Start(ctx, "sync BGP peers")
+		if nrc.bgpPeerLister != nil {
+			nrc.syncBGPPeerResources()
+		}
+
+		if nrc.staticRouteLister != nil {
+			nrc.syncStaticRoutes()
+		}
+
+		if len(nrc.unnumberedPeerRouters) > 0 {
+			nrc.syncUnnumberedPeers()
 		}
 
+		if len(nrc.globalPeerPasswordSecrets) > 0 {
+			nrc.syncPeerPasswordSecrets(true)
+		}
+		span.End()
+
+		_, span = tracer.Start(ctx, "BGP policies")
 		err = nrc.AddPolicies()
+		tracing.End(span, err)
 		if err != nil {
 			klog.Errorf("Error adding BGP policies: %s", err.Error())
+			utils.RecordNodeWarning(nrc.eventRecorder, nrc.nodeName, routingSyncFailedEventReason,
+				"Failed to apply the BGP policies: %v", err)
 		}
 
 		if nrc.bgpEnableInternal {
+			_, span = tracer.Start(ctx, "sync internal BGP peers")
 			nrc.syncInternalPeers()
+			span.End()
 		}
+		tracing.End(syncSpan, err)
 
 		if err == nil {
 			healthcheck.SendHeartBeat(healthChan, "NRC")
@@ -377,99 +553,214 @@ func (nrc *NetworkRoutingController) Run(healthChan chan<- *healthcheck.Controll
 			klog.Infof("Shutting down network routes controller")
 			return
 		case <-t.C:
+		case nodeIP := <-nrc.nodeIPChangeChan:
+			nrc.handleNodeIPChange(nodeIP)
+		case <-nrc.bgpResourceSyncChan:
+			klog.V(1).Info("Performing requested sync of the custom resources")
 		}
 	}
 }
 
-func (nrc *NetworkRoutingController) updateCNIConfig() {
-	cidr, err := utils.GetPodCidrFromCniSpec(nrc.cniConfFile)
+// requestBGPResourceSync asks the controller's main loop to sync the BGPPeer, BGPPolicy and StaticRoute resources and
+// the ClusterCIDRs, unless a sync is already pending
+func (nrc *NetworkRoutingController) requestBGPResourceSync() {
+	select {
+	case nrc.bgpResourceSyncChan <- struct{}{}:
+	default:
+	}
+}
+
+// requestNodeIPChange hands the new node IP over to the controller's main loop, replacing any change that is still
+// pending
+func (nrc *NetworkRoutingController) requestNodeIPChange(nodeIP net.IP) {
+	select {
+	case <-nrc.nodeIPChangeChan:
+	default:
+	}
+	nrc.nodeIPChangeChan <- nodeIP
+}
+
+// handleNodeIPChange moves the BGP speaker over to the new node IP. Sessions with the peers can not be moved to a new
+// local address (and the BGP router ID may be derived from the node IP), so the BGP server is restarted. The routes
+// and VIPs are advertised again with the new next hop by the sync that follows.
+func (nrc *NetworkRoutingController) handleNodeIPChange(nodeIP net.IP) {
+	if nodeIP.Equal(nrc.nodeIP) {
+		return
+	}
+	if (nodeIP.To4() == nil) != nrc.isIpv6 {
+		klog.Errorf("Node IP changed from %s to %s which is of another address family, kube-router needs to be "+
+			"restarted to use it", nrc.nodeIP, nodeIP)
+		return
+	}
+	klog.Infof("Node IP changed from %s to %s, restarting BGP server", nrc.nodeIP, nodeIP)
+
+	if nrc.bgpServerStarted {
+		nrc.bgpServer.Stop()
+		nrc.bgpServerStarted = false
+	}
+
+	// tunnels are bound to the old node IP, they are recreated as routes are learned from the peers again
+	links, err := netlink.LinkList()
 	if err != nil {
-		klog.Errorf("Failed to get pod CIDR from CNI conf file: %s", err)
+		klog.Errorf("Failed to list links to clean up overlay tunnels: %s", err.Error())
+	}
+	for _, link := range links {
+		_, geneve := link.(*netlink.Geneve)
+		if tunnel, ok := link.(*netlink.Iptun); ok && tunnel.Local.Equal(nrc.overlayIP) ||
+			geneve && strings.HasPrefix(link.Attrs().Name, "tun") {
+			if err = netlink.LinkDel(link); err != nil {
+				klog.Errorf("Failed to delete tunnel %s: %s", link.Attrs().Name, err.Error())
+			}
+		}
 	}
 
-	if reflect.DeepEqual(cidr, net.IPNet{}) {
-		klog.Infof("`subnet` in CNI conf file is empty so populating `subnet` in CNI conf file with pod " +
-			"CIDR assigned to the node obtained from node spec.")
+	nrc.nodeIP = nodeIP
+	if nrc.routerIDFromNodeIP {
+		nrc.routerID = nodeIP.String()
+	}
+	// the BGP and overlay addresses follow the node IP unless other ones are selected
+	obj, exists, err := nrc.nodeLister.GetByKey(nrc.nodeName)
+	if err != nil || !exists {
+		klog.Errorf("Failed to get node %s from the cache to select its BGP and overlay addresses: %v",
+			nrc.nodeName, err)
+	} else {
+		node := obj.(*v1core.Node)
+		if err = nrc.selectNodeAddresses(node); err != nil {
+			klog.Errorf("Failed to select the BGP and overlay addresses for the new node IP %s: %s", nodeIP, err)
+		} else if err = nrc.publishNodeAddresses(node); err != nil {
+			klog.Errorf("Failed to publish the BGP and overlay addresses of the node: %s", err)
+		}
+	}
+	if nrc.localAddressFromNodeIP {
+		nrc.localAddressList = nrc.bgpAddresses()
 	}
-	cidrlen, _ := cidr.Mask.Size()
-	oldCidr := cidr.IP.String() + "/" + strconv.Itoa(cidrlen)
+	nrc.mu.Lock()
+	nrc.activeNodes = make(map[string]bool)
+	nrc.ibgpZonePeers = make(map[string]string)
+	nrc.mu.Unlock()
+
+	nrc.restartBgpServer()
+}
 
-	currentCidr := nrc.podCidr
+// restartBgpServer starts the BGP server after it was stopped by a node IP change, it is retried on every sync till it
+// succeeds
+func (nrc *NetworkRoutingController) restartBgpServer() {
+	if err := nrc.startBgpServer(true); err != nil {
+		klog.Errorf("Failed to restart BGP server on node IP %s, will retry on the next sync: %s", nrc.nodeIP, err)
+		// release the gRPC listener, so that the next attempt can bind to it again
+		nrc.bgpServer.Stop()
+		return
+	}
+	nrc.bgpServerStarted = true
+	// the peers of the BGPPeer resources and the BGPPolicies are set up again on the new BGP server by the sync that
+	// follows
+	nrc.bgpPeerResourcePeers = nil
+	nrc.resourcePeerRouters = nil
+	nrc.bgpPolicyResourcesApplied = nil
+}
 
-	if len(cidr.IP) == 0 || strings.Compare(oldCidr, currentCidr) != 0 {
-		err = utils.InsertPodCidrInCniSpec(nrc.cniConfFile, currentCidr)
+// setupKubeBridge creates the kube-bridge interface that pods are attached to in bridge mode and enables netfilter
+// for the traffic that is switched by it
+func (nrc *NetworkRoutingController) setupKubeBridge() {
+	// create 'kube-bridge' interface to which pods will be connected
+	kubeBridgeIf, err := netlink.LinkByName("kube-bridge")
+	if err != nil && err.Error() == IfaceNotFound {
+		linkAttrs := netlink.NewLinkAttrs()
+		linkAttrs.Name = "kube-bridge"
+		bridge := &netlink.Bridge{LinkAttrs: linkAttrs}
+		if err = netlink.LinkAdd(bridge); err != nil {
+			klog.Errorf("Failed to create `kube-router` bridge due to %s. Will be created by CNI bridge "+
+				"plugin when pod is launched.", err.Error())
+		}
+		kubeBridgeIf, err = netlink.LinkByName("kube-bridge")
 		if err != nil {
-			klog.Fatalf("Failed to insert `subnet`(pod CIDR) into CNI conf file: %s", err.Error())
+			klog.Errorf("Failed to find created `kube-router` bridge due to %s. Will be created by CNI "+
+				"bridge plugin when pod is launched.", err.Error())
+		}
+		err = netlink.LinkSetUp(kubeBridgeIf)
+		if err != nil {
+			klog.Errorf("Failed to bring `kube-router` bridge up due to %s. Will be created by CNI bridge "+
+				"plugin at later point when pod is launched.", err.Error())
 		}
 	}
 
 	if nrc.autoMTU {
-		err = nrc.autoConfigureMTU()
+		mtu, err := utils.GetMTUFromNodeIP(nrc.overlayIP)
 		if err != nil {
-			klog.Errorf("Failed to auto-configure MTU due to: %s", err.Error())
+			klog.Errorf("Failed to find MTU for node IP: %s for intelligently setting the kube-bridge MTU "+
+				"due to %s.", nrc.overlayIP, err.Error())
+		}
+		if mtu > 0 {
+			klog.Infof("Setting MTU of kube-bridge interface to: %d", mtu)
+			err = netlink.LinkSetMTU(kubeBridgeIf, mtu)
+			if err != nil {
+				klog.Errorf(
+					"Failed to set MTU for kube-bridge interface due to: %s (kubeBridgeIf: %#v, mtu: %v)",
+					err.Error(), kubeBridgeIf, mtu,
+				)
+				// need to correct kuberouter.conf because the auto detected MTU may be an invalid value!
+				currentMTU := kubeBridgeIf.Attrs().MTU
+				if currentMTU > 0 && currentMTU != mtu {
+					klog.Warningf("Updating config file with current MTU for kube-bridge: %d", currentMTU)
+					if err := nrc.setCNIMTU(currentMTU); err != nil {
+						klog.Errorf("Failed to update config file due to: %s", err.Error())
+					}
+				}
+			}
+		} else {
+			klog.Infof("Not setting MTU of kube-bridge interface")
+		}
+	}
+	// enable netfilter for the bridge
+	if _, err := exec.Command("modprobe", "br_netfilter").CombinedOutput(); err != nil {
+		klog.Errorf("Failed to enable netfilter for bridge. Network policies and service proxy may "+
+			"not work: %s", err.Error())
+	}
+	sysctlErr := nrc.sysctls.Ensure(utils.SysctlSetting{Path: utils.BridgeNFCallIPTables, Value: 1,
+		Reason: "iptables filtering of bridged pod traffic"})
+	if sysctlErr != nil {
+		klog.Errorf("Failed to enable iptables for bridge. Network policies and service proxy may "+
+			"not work: %s", sysctlErr.Error())
+	}
+	if nrc.isIpv6 {
+		sysctlErr = nrc.sysctls.Ensure(utils.SysctlSetting{Path: utils.BridgeNFCallIP6Tables, Value: 1,
+			Reason: "ip6tables filtering of bridged pod traffic"})
+		if sysctlErr != nil {
+			klog.Errorf("Failed to enable ip6tables for bridge. Network policies and service proxy may "+
+				"not work: %s", sysctlErr.Error())
 		}
 	}
 }
 
-func (nrc *NetworkRoutingController) configureMTU(mtu int) error {
-	file, err := os.ReadFile(nrc.cniConfFile)
-	if err != nil {
-		return fmt.Errorf("failed to load CNI conf file: %s", err.Error())
+// injectPaths installs the routes of the paths of a table event
+func (nrc *NetworkRoutingController) injectPaths(paths []*gobgpapi.Path) {
+	if len(nrc.importExternalRoutes) > 0 {
+		paths = nrc.injectExternalRoutes(paths)
 	}
-	var config interface{}
-	err = json.Unmarshal(file, &config)
-	if err != nil {
-		return fmt.Errorf("failed to parse JSON from CNI conf file: %s", err.Error())
+	if nrc.peerECMP {
+		nrc.injectMultipathRoutes(paths)
+		return
 	}
-	if strings.HasSuffix(nrc.cniConfFile, ".conflist") {
-		configMap := config.(map[string]interface{})
-		for key := range configMap {
-			if key != "plugins" {
-				continue
+	for _, path := range paths {
+		if path.Family.Afi == gobgpapi.Family_AFI_IP || path.Family.Safi == gobgpapi.Family_SAFI_UNICAST {
+			if nrc.MetricsEnabled {
+				metrics.ControllerBGPadvertisementsReceived.Inc()
+			}
+			if path.NeighborIp == "<nil>" {
+				return
 			}
-			pluginConfigs := configMap["plugins"].([]interface{})
-			for _, pluginConfig := range pluginConfigs {
-				pluginConfigMap := pluginConfig.(map[string]interface{})
-				pluginConfigMap["mtu"] = mtu
+			klog.V(2).Infof("Processing bgp route advertisement from peer: %s", path.NeighborIp)
+			if err := nrc.injectRoute(path); err != nil {
+				klog.Errorf("Failed to inject routes due to: " + err.Error())
 			}
 		}
-	} else {
-		pluginConfig := config.(map[string]interface{})
-		pluginConfig["mtu"] = mtu
 	}
-	configJSON, _ := json.Marshal(config)
-	err = os.WriteFile(nrc.cniConfFile, configJSON, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to insert `mtu` into CNI conf file: %s", err.Error())
-	}
-	return nil
-}
-
-func (nrc *NetworkRoutingController) autoConfigureMTU() error {
-	mtu, err := utils.GetMTUFromNodeIP(nrc.nodeIP)
-	if err != nil {
-		return fmt.Errorf("failed to generate MTU: %s", err.Error())
-	}
-	return nrc.configureMTU(mtu)
 }
 
 func (nrc *NetworkRoutingController) watchBgpUpdates() {
 	pathWatch := func(r *gobgpapi.WatchEventResponse) {
 		if table := r.GetTable(); table != nil {
-			for _, path := range table.Paths {
-				if path.Family.Afi == gobgpapi.Family_AFI_IP || path.Family.Safi == gobgpapi.Family_SAFI_UNICAST {
-					if nrc.MetricsEnabled {
-						metrics.ControllerBGPadvertisementsReceived.Inc()
-					}
-					if path.NeighborIp == "<nil>" {
-						return
-					}
-					klog.V(2).Infof("Processing bgp route advertisement from peer: %s", path.NeighborIp)
-					if err := nrc.injectRoute(path); err != nil {
-						klog.Errorf("Failed to inject routes due to: " + err.Error())
-					}
-				}
-			}
+			nrc.injectPaths(nrc.dampenPaths(table.Paths))
 		}
 	}
 	err := nrc.bgpServer.WatchEvent(context.Background(), &gobgpapi.WatchEventRequest{
@@ -486,74 +777,117 @@ func (nrc *NetworkRoutingController) watchBgpUpdates() {
 	}
 }
 
+// allPodCIDRs returns all of the pod CIDRs assigned to this node, the first of which is the primary pod CIDR
+func (nrc *NetworkRoutingController) allPodCIDRs() []string {
+	if len(nrc.podCIDRs) == 0 {
+		return []string{nrc.podCidr}
+	}
+	return nrc.podCIDRs
+}
+
+// advertisablePodCIDRs returns the pod CIDRs of this node that belong to an address family the node routes, the one of
+// the node IP and on dual-stack nodes the other one as well
+func (nrc *NetworkRoutingController) advertisablePodCIDRs() []string {
+	cidrs := make([]string, 0)
+	for _, cidr := range nrc.allPodCIDRs() {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			klog.Warningf("Ignoring invalid pod CIDR %s: %v", cidr, err)
+			continue
+		}
+		if !nrc.routesFamilyOf(ip) {
+			klog.V(2).Infof("Not advertising pod CIDR %s as the node does not route its address family", cidr)
+			continue
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs
+}
+
+// advertisePodRoute advertises each of the advertisable pod CIDRs of this node, and the summaries covering them
 func (nrc *NetworkRoutingController) advertisePodRoute() error {
 	if nrc.MetricsEnabled {
 		metrics.ControllerBGPadvertisementsSent.WithLabelValues("pod-route").Inc()
 	}
 
-	cidrStr := strings.Split(nrc.podCidr, "/")
-	subnet := cidrStr[0]
-	cidrLen, err := strconv.Atoi(cidrStr[1])
-	if err != nil || cidrLen < 0 || cidrLen > 32 {
-		return fmt.Errorf("the pod CIDR IP given is not a proper mask: %d", cidrLen)
+	for _, cidr := range nrc.advertisablePodCIDRs() {
+		if err := nrc.advertisePodCIDR(cidr); err != nil {
+			return err
+		}
 	}
-	if nrc.isIpv6 {
-		klog.V(2).Infof("Advertising route: '%s/%d via %s' to peers", subnet, cidrLen, nrc.nodeIP.String())
+	return nrc.advertisePodCIDRSummaries()
+}
 
-		v6Family := &gobgpapi.Family{
-			Afi:  gobgpapi.Family_AFI_IP6,
-			Safi: gobgpapi.Family_SAFI_UNICAST,
-		}
-		nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{
-			PrefixLen: uint32(cidrLen),
-			Prefix:    cidrStr[0],
-		})
-		a1, _ := anypb.New(&gobgpapi.OriginAttribute{
-			Origin: 0,
-		})
-		v6Attrs, _ := anypb.New(&gobgpapi.MpReachNLRIAttribute{
-			Family:   v6Family,
-			NextHops: []string{nrc.nodeIP.String()},
-			Nlris:    []*anypb.Any{nlri},
-		})
-		_, err := nrc.bgpServer.AddPath(context.Background(), &gobgpapi.AddPathRequest{
-			Path: &gobgpapi.Path{
-				Family: v6Family,
-				Nlri:   nlri,
-				Pattrs: []*anypb.Any{a1, v6Attrs},
-			},
-		})
-		if err != nil {
-			return fmt.Errorf(err.Error())
-		}
-	} else {
+func (nrc *NetworkRoutingController) advertisePodCIDR(podCIDR string) error {
+	path, nextHop, err := nrc.podCIDRPath(podCIDR)
+	if err != nil {
+		return err
+	}
+	klog.V(2).Infof("Advertising route: '%s via %s' to peers", podCIDR, nextHop)
+	_, err = nrc.bgpServer.AddPath(context.Background(), &gobgpapi.AddPathRequest{Path: path})
+	return err
+}
 
-		klog.V(2).Infof("Advertising route: '%s/%d via %s' to peers", subnet, cidrLen, nrc.nodeIP.String())
-		nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{
-			PrefixLen: uint32(cidrLen),
-			Prefix:    cidrStr[0],
-		})
+// withdrawPodCIDR withdraws the route of a pod CIDR of the node, or of a summary covering them, from the peers
+func (nrc *NetworkRoutingController) withdrawPodCIDR(podCIDR string) error {
+	path, nextHop, err := nrc.podCIDRPath(podCIDR)
+	if err != nil {
+		return err
+	}
+	klog.V(2).Infof("Withdrawing route: '%s via %s' from peers", podCIDR, nextHop)
+	return nrc.bgpServer.DeletePath(context.Background(), &gobgpapi.DeletePathRequest{
+		TableType: gobgpapi.TableType_GLOBAL,
+		Path:      path,
+	})
+}
 
-		a1, _ := anypb.New(&gobgpapi.OriginAttribute{
-			Origin: 0,
-		})
-		a2, _ := anypb.New(&gobgpapi.NextHopAttribute{
-			NextHop: nrc.nodeIP.String(),
+// podCIDRPath returns the route of a pod CIDR of the node, or of a summary covering them, along with its next hop
+func (nrc *NetworkRoutingController) podCIDRPath(podCIDR string) (*gobgpapi.Path, string, error) {
+	cidrStr := strings.Split(podCIDR, "/")
+	subnet := cidrStr[0]
+	ipv6 := net.ParseIP(subnet).To4() == nil
+	cidrLen, err := strconv.Atoi(cidrStr[1])
+	if err != nil || cidrLen < 0 || (!ipv6 && cidrLen > 32) || cidrLen > 128 {
+		return nil, "", fmt.Errorf("the pod CIDR IP given is not a proper mask: %d", cidrLen)
+	}
+	// pod CIDRs of the other address family than the node IP are advertised with the dual-stack node address
+	nextHop := nrc.nodeAddressOfFamily(ipv6, nrc.overlayIP)
+	if nextHop == nil {
+		return nil, "", fmt.Errorf("no address of the node to advertise the pod CIDR %s with", podCIDR)
+	}
+
+	family := &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST}
+	if ipv6 {
+		family.Afi = gobgpapi.Family_AFI_IP6
+	}
+	nlri, _ := anypb.New(&gobgpapi.IPAddressPrefix{
+		PrefixLen: uint32(cidrLen),
+		Prefix:    subnet,
+	})
+	a1, _ := anypb.New(&gobgpapi.OriginAttribute{
+		Origin: 0,
+	})
+	var a2 *anypb.Any
+	if ipv6 {
+		a2, _ = anypb.New(&gobgpapi.MpReachNLRIAttribute{
+			Family:   family,
+			NextHops: []string{nextHop.String()},
+			Nlris:    []*anypb.Any{nlri},
 		})
-		attrs := []*anypb.Any{a1, a2}
-
-		_, err := nrc.bgpServer.AddPath(context.Background(), &gobgpapi.AddPathRequest{
-			Path: &gobgpapi.Path{
-				Family: &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
-				Nlri:   nlri,
-				Pattrs: attrs,
-			},
+	} else {
+		a2, _ = anypb.New(&gobgpapi.NextHopAttribute{
+			NextHop: nextHop.String(),
 		})
+	}
+	attrs := []*anypb.Any{a1, a2}
+	if len(nrc.podCIDRCommunities) > 0 {
+		a3, err := newCommunitiesAttribute(nrc.podCIDRCommunities)
 		if err != nil {
-			return fmt.Errorf(err.Error())
+			return nil, "", err
 		}
+		attrs = append(attrs, a3)
 	}
-	return nil
+	return &gobgpapi.Path{Family: family, Nlri: nlri, Pattrs: attrs}, nextHop.String(), nil
 }
 
 func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
@@ -567,7 +901,7 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 	}
 
 	tunnelName := generateTunnelName(nextHop.String())
-	sameSubnet := nrc.nodeSubnet.Contains(nextHop)
+	sameSubnet := nrc.inNodeSubnet(nextHop)
 
 	// If we've made it this far, then it is likely that the node is holding a destination route for this path already.
 	// If the path we've received from GoBGP is a withdrawal, we should clean up any lingering routes that may exist
@@ -587,40 +921,59 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 				nextHop.String())
 			// Also delete route from state map so that it doesn't get re-synced after deletion
 			nrc.routeSyncer.delInjectedRoute(dst)
+			nrc.forgetOverlayRoute(dst)
 			nrc.cleanupTunnel(dst, tunnelName)
+			cleanupVXLANPeer(nextHop)
+			nrc.cleanupWireGuardPeer(nextHop, dst)
 			return nil
 		}
 
 		// Also delete route from state map so that it doesn't get re-synced after deletion
 		nrc.routeSyncer.delInjectedRoute(dst)
+		nrc.forgetOverlayRoute(dst)
+		nrc.cleanupWireGuardPeer(nextHop, dst)
 		return deleteRoutesByDestination(dst)
 	}
 
-	shouldCreateTunnel := func() bool {
-		if !nrc.enableOverlays {
-			return false
-		}
-		if nrc.overlayType == "full" {
-			return true
-		}
-		if nrc.overlayType == "subnet" && !sameSubnet {
-			return true
+	// the routes learned from unnumbered peers are through the link-local address of the peer on its interface, they
+	// are never tunneled
+	if nextHop.IsLinkLocalUnicast() {
+		route, err = nrc.unnumberedPeerRoute(path.NeighborIp, dst, nextHop)
+		if err != nil {
+			return err
 		}
-		return false
+		klog.V(2).Infof("Inject route: '%s via %s dev %d' from unnumbered peer to routing table", dst, nextHop,
+			route.LinkIndex)
+		nrc.routeSyncer.addInjectedRoute(dst, route)
+		nrc.routeSyncer.syncLocalRouteTable()
+		return nil
 	}
 
 	// create IPIP tunnels only when node is not in same subnet or overlay-type is set to 'full'
 	// if the user has disabled overlays, don't create tunnels. If we're not creating a tunnel, check to see if there is
-	// any cleanup that needs to happen.
-	if shouldCreateTunnel() {
-		link, err = nrc.setupOverlayTunnel(tunnelName, nextHop)
+	// any cleanup that needs to happen. The tunnels are bound to the overlay address, so the routes through the next
+	// hops of the other address family of dual-stack nodes aren't tunneled.
+	if nrc.shouldCreateTunnel(sameSubnet) && (nextHop.To4() == nil) == (nrc.overlayIP.To4() == nil) {
+		switch nrc.overlayEncapsulation {
+		case options.OverlayEncapsulationVXLAN:
+			link, err = nrc.setupVXLANPeer(nextHop)
+		case options.OverlayEncapsulationGeneve:
+			link, err = nrc.setupGeneveTunnel(tunnelName, nextHop)
+		case options.OverlayEncapsulationWireGuard:
+			link, err = nrc.setupWireGuardPeer(nextHop, dst)
+		default:
+			link, err = nrc.setupOverlayTunnel(tunnelName, nextHop)
+		}
 		if err != nil {
 			return err
 		}
 	} else {
 		// knowing that a tunnel shouldn't exist for this route, check to see if there are any lingering tunnels /
 		// routes that need to be cleaned up.
+		nrc.forgetOverlayRoute(dst)
 		nrc.cleanupTunnel(dst, tunnelName)
+		cleanupVXLANPeer(nextHop)
+		nrc.cleanupWireGuardPeer(nextHop, dst)
 	}
 
 	switch {
@@ -628,9 +981,15 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 		// if we setup an overlay tunnel link, then use it for destination routing
 		route = &netlink.Route{
 			LinkIndex: link.Attrs().Index,
-			Src:       nrc.nodeIP,
+			Src:       nrc.overlayIP,
 			Dst:       dst,
 			Protocol:  zebraRouteOriginator,
+			MTU:       nrc.overlayRouteMTU(dst, nextHop, link),
+		}
+		// the frames of the VXLAN and Geneve overlays are addressed to the MAC programmed for the next hop
+		if nrc.overlayIsL2() {
+			route.Gw = nextHop
+			route.Flags = int(netlink.FLAG_ONLINK)
 		}
 	case sameSubnet:
 		// if the nextHop is within the same subnet, add a route for the destination so that traffic can bet routed
@@ -653,6 +1012,21 @@ func (nrc *NetworkRoutingController) injectRoute(path *gobgpapi.Path) error {
 	return nil
 }
 
+// shouldCreateTunnel tells whether the routes through a next hop go through an IPIP tunnel, which is the case when
+// overlays are enabled and either the next hop is in another subnet or the overlay type is set to 'full'
+func (nrc *NetworkRoutingController) shouldCreateTunnel(sameSubnet bool) bool {
+	if !nrc.enableOverlays {
+		return false
+	}
+	if nrc.overlayType == "full" {
+		return true
+	}
+	if nrc.overlayType == "subnet" && !sameSubnet {
+		return true
+	}
+	return false
+}
+
 func (nrc *NetworkRoutingController) isPeerEstablished(peerIP string) (bool, error) {
 	var peerConnected bool
 	peerFunc := func(peer *gobgpapi.Peer) {
@@ -694,7 +1068,7 @@ func (nrc *NetworkRoutingController) setupOverlayTunnel(tunnelName string, nextH
 	// an error here indicates that the the tunnel didn't exist, so we need to create it, if it already exists there's
 	// nothing to do here
 	if err != nil {
-		cmdArgs := []string{"tunnel", "add", tunnelName, "mode", "ipip", "local", nrc.nodeIP.String(), "remote",
+		cmdArgs := []string{"tunnel", "add", tunnelName, "mode", "ipip", "local", nrc.overlayIP.String(), "remote",
 			nextHop.String()}
 		// need to skip binding device if nrc.nodeInterface is loopback, otherwise packets never leave
 		// from egress interface to the tunnel peer.
@@ -752,6 +1126,22 @@ func (nrc *NetworkRoutingController) Cleanup() {
 		klog.V(1).Infof("Error deleting Pod egress iptables rule: %s", err.Error())
 	}
 
+	// the iptables rules, ip rules and routes of the EgressIPs, their ipsets are destroyed below
+	if err = nrc.deleteEgressIPs(); err != nil {
+		klog.Errorf("Error deleting the EgressIP configuration: %v", err)
+	}
+
+	// the peers of the VXLAN and WireGuard overlays and the routes through them go away along with their interfaces
+	deleteVXLAN()
+	deleteWireGuard()
+
+	// namespace bandwidth limit cleanup, the qdiscs on kube-bridge go away along with the bridge itself
+	if bridge, err := netlink.LinkByName(kubeBridgeIfName); err == nil {
+		if err = deleteBandwidthIfb(bridge); err != nil {
+			klog.Errorf("Error deleting namespace bandwidth limits: %v", err)
+		}
+	}
+
 	// For some reason, if we go too fast into the ipset logic below it causes the system to think that the above
 	// iptables rules are still referencing the ipsets below, and we get errors
 	time.Sleep(1 * time.Second)
@@ -802,28 +1192,12 @@ func (nrc *NetworkRoutingController) syncNodeIPSets() error {
 		klog.V(1).Infof("Returned ipset mutex lock")
 	}()
 
-	nodes := nrc.nodeLister.List()
-
 	// Collect active PodCIDR(s) and NodeIPs from nodes
-	currentPodCidrs := make([]string, 0)
-	currentNodeIPs := make([]string, 0)
-	for _, obj := range nodes {
-		node := obj.(*v1core.Node)
-		podCIDR := node.GetAnnotations()["kube-router.io/pod-cidr"]
-		if podCIDR == "" {
-			podCIDR = node.Spec.PodCIDR
-		}
-		if podCIDR == "" {
-			klog.Warningf("Couldn't determine PodCIDR of the %v node", node.Name)
-			continue
-		}
-		currentPodCidrs = append(currentPodCidrs, podCIDR)
-		nodeIP, err := utils.GetNodeIP(node)
-		if err != nil {
-			klog.Errorf("Failed to find a node IP, cannot add to node ipset which could affect routing: %v", err)
-			continue
-		}
-		currentNodeIPs = append(currentNodeIPs, nodeIP.String())
+	currentPodCidrs, currentNodeIPs := nrc.nodeIPSetMembers(nrc.nodeLister.List())
+
+	// the ranges the pod CIDRs of the nodes are allocated from are pod subnets as well
+	if nrc.clusterCIDRLister != nil {
+		currentPodCidrs = append(currentPodCidrs, clusterCIDRRanges(nrc.clusterCIDRLister.List(), nrc.isIpv6)...)
 	}
 
 	// Syncing Pod subnet ipset entries
@@ -874,6 +1248,60 @@ func (nrc *NetworkRoutingController) newIptablesCmdHandler() (*iptables.IPTables
 	return iptables.NewWithProtocol(iptables.ProtocolIPv4)
 }
 
+// podInterfaces returns the iptables interface match for the host side of the pod interfaces, which is the bridge in
+// bridge mode and the veths created by the ptp plugin in ptp mode
+func (nrc *NetworkRoutingController) podInterfaces() string {
+	if nrc.cniMode == options.CNIModePTP {
+		return "veth+"
+	}
+	return kubeBridgeIfName
+}
+
+// nodeIPSetMembers returns the pod CIDRs and the addresses of the nodes for the pod subnets and node addresses ipsets,
+// which only hold the address family of the controller: the other family of dual-stack nodes is left out
+func (nrc *NetworkRoutingController) nodeIPSetMembers(nodes []interface{}) ([]string, []string) {
+	podCIDRs := make([]string, 0)
+	nodeIPs := make([]string, 0)
+	for _, obj := range nodes {
+		node := obj.(*v1core.Node)
+		cidrs, err := utils.GetPodCIDRsFromNode(node)
+		if err != nil {
+			klog.Warningf("Couldn't determine PodCIDR of the %v node: %v", node.Name, err)
+			continue
+		}
+		for _, cidr := range cidrs {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			if err == nil && (ip.To4() == nil) == nrc.isIpv6 {
+				podCIDRs = append(podCIDRs, ipNet.String())
+			}
+		}
+		nodeIP, err := utils.GetNodeIPOfFamily(node, nrc.isIpv6)
+		if err != nil {
+			klog.Errorf("Failed to find a node IP, cannot add to node ipset which could affect routing: %v", err)
+			continue
+		}
+		nodeIPs = append(nodeIPs, nodeIP.String())
+		// tunnel traffic is sourced from the overlay address and BGP sessions from the BGP address of the nodes
+		publishedIPs := make(map[string]bool)
+		published := []string{utils.NodeBGPAddressAnnotation, utils.NodeOverlayAddressAnnotation}
+		for _, annotation := range published {
+			ip, err := publishedNodeAddress(node, annotation)
+			if err != nil {
+				klog.Errorf("Failed to add the published address of node %s to node ipset: %v", node.Name, err)
+				continue
+			}
+			if (ip.To4() == nil) != nrc.isIpv6 {
+				continue
+			}
+			if !ip.Equal(nodeIP) && !publishedIPs[ip.String()] {
+				publishedIPs[ip.String()] = true
+				nodeIPs = append(nodeIPs, ip.String())
+			}
+		}
+	}
+	return podCIDRs, nodeIPs
+}
+
 // ensure there is rule in filter table and FORWARD chain to permit in/out traffic from pods
 // this rules will be appended so that any iptables rules for network policies will take
 // precedence
@@ -881,42 +1309,36 @@ func (nrc *NetworkRoutingController) enableForwarding() error {
 
 	iptablesCmdHandler, _ := nrc.newIptablesCmdHandler()
 
-	comment := "allow outbound traffic from pods"
-	args := []string{"-m", "comment", "--comment", comment, "-i", "kube-bridge", "-j", "ACCEPT"}
-	exists, err := iptablesCmdHandler.Exists("filter", "FORWARD", args...)
-	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
-	}
-	if !exists {
-		err := iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
+	rules := []struct {
+		comment string
+		match   []string
+	}{
+		{"allow outbound traffic from pods", []string{"-i", nrc.podInterfaces()}},
+		{"allow inbound traffic to pods", []string{"-o", nrc.podInterfaces()}},
+		{"allow outbound node port traffic on node interface with which node ip is associated",
+			[]string{"-o", nrc.nodeInterface}},
+	}
+	for _, rule := range rules {
+		// the versions of kube-router before the rules were tagged created them without the owner in the comment
+		legacyArgs := append([]string{"-m", "comment", "--comment", rule.comment}, rule.match...)
+		legacyArgs = append(legacyArgs, "-j", "ACCEPT")
+		err := iptablesCmdHandler.DeleteIfExists("filter", "FORWARD", legacyArgs...)
 		if err != nil {
 			return fmt.Errorf("failed to run iptables command: %s", err.Error())
 		}
-	}
 
-	comment = "allow inbound traffic to pods"
-	args = []string{"-m", "comment", "--comment", comment, "-o", "kube-bridge", "-j", "ACCEPT"}
-	exists, err = iptablesCmdHandler.Exists("filter", "FORWARD", args...)
-	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
-	}
-	if !exists {
-		err = iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
+		args := append([]string{"-m", "comment", "--comment", utils.OwnerComment + " " + rule.comment},
+			rule.match...)
+		args = append(args, "-j", "ACCEPT")
+		exists, err := iptablesCmdHandler.Exists("filter", "FORWARD", args...)
 		if err != nil {
 			return fmt.Errorf("failed to run iptables command: %s", err.Error())
 		}
-	}
-
-	comment = "allow outbound node port traffic on node interface with which node ip is associated"
-	args = []string{"-m", "comment", "--comment", comment, "-o", nrc.nodeInterface, "-j", "ACCEPT"}
-	exists, err = iptablesCmdHandler.Exists("filter", "FORWARD", args...)
-	if err != nil {
-		return fmt.Errorf("failed to run iptables command: %s", err.Error())
-	}
-	if !exists {
-		err = iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
-		if err != nil {
-			return fmt.Errorf("failed to run iptables command: %s", err.Error())
+		if !exists {
+			err = iptablesCmdHandler.Insert("filter", "FORWARD", 1, args...)
+			if err != nil {
+				return fmt.Errorf("failed to run iptables command: %s", err.Error())
+			}
 		}
 	}
 
@@ -947,7 +1369,14 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 		nrc.nodeAsnNumber = nodeAsnNumber
 	}
 
-	if clusterid, ok := node.ObjectMeta.Annotations[rrServerAnnotation]; ok {
+	if nrc.ibgpZoneLabel != "" {
+		_, rrServer := node.ObjectMeta.Annotations[rrServerAnnotation]
+		_, rrClient := node.ObjectMeta.Annotations[rrClientAnnotation]
+		if rrServer || rrClient {
+			klog.Warningf("Ignoring the rr.server and rr.client annotations of the node as the route reflectors "+
+				"are elected in the zones of the %s label", nrc.ibgpZoneLabel)
+		}
+	} else if clusterid, ok := node.ObjectMeta.Annotations[rrServerAnnotation]; ok {
 		klog.Infof("Found rr.server for the node to be %s from the node annotation", clusterid)
 		_, err := strconv.ParseUint(clusterid, 0, routeReflectorMaxID)
 		if err != nil {
@@ -991,7 +1420,10 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 		nrc.pathPrependCount = uint8(repeatN)
 	}
 
+	// the communities and import rejects are rebuilt from the annotations whenever the BGP server is (re)started
 	var nodeCommunities []string
+	nrc.nodeCommunities = nil
+	nrc.nodeCustomImportRejectIPNets = nil
 	nodeBGPCommunitiesAnnotation, ok := node.ObjectMeta.Annotations[nodeCommunitiesAnnotation]
 	if !ok {
 		klog.V(1).Info("Did not find any BGP communities on current node's annotations. " +
@@ -1028,12 +1460,15 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 		}
 	}
 
+	serverOpts := make([]gobgp.ServerOption, 0)
 	if grpcServer {
-		nrc.bgpServer = gobgp.NewBgpServer(
-			gobgp.GrpcListenAddress(nrc.nodeIP.String() + ":50051" + "," + "127.0.0.1:50051"))
-	} else {
-		nrc.bgpServer = gobgp.NewBgpServer()
+		serverOpts = append(serverOpts,
+			gobgp.GrpcListenAddress(nrc.nodeIP.String()+":50051"+","+"127.0.0.1:50051"))
+	}
+	if nrc.peerMaxPrefixesRestartTime > 0 {
+		serverOpts = append(serverOpts, gobgp.LoggerOption(newPrefixLimitLogger(nrc.holdDownPeer)))
 	}
+	nrc.bgpServer = gobgp.NewBgpServer(serverOpts...)
 	go nrc.bgpServer.Serve()
 
 	var localAddressList []string
@@ -1051,6 +1486,9 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 		RouterId:        nrc.routerID,
 		ListenAddresses: localAddressList,
 		ListenPort:      int32(nrc.bgpPort),
+		// the routes learned from several peers with equal cost are handed over by the watch as a set, so that they
+		// can be installed as ECMP routes
+		UseMultiplePaths: nrc.peerECMP,
 	}
 
 	if err := nrc.bgpServer.StartBgp(context.Background(), &gobgpapi.StartBgpRequest{Global: global}); err != nil {
@@ -1059,6 +1497,30 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 
 	go nrc.watchBgpUpdates()
 
+	if err := nrc.watchBGPPeerStates(); err != nil {
+		err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+		if err2 != nil {
+			klog.Errorf("Failed to stop bgpServer: %s", err2)
+		}
+		return err
+	}
+
+	if err := nrc.startRPKIClients(); err != nil {
+		err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+		if err2 != nil {
+			klog.Errorf("Failed to stop bgpServer: %s", err2)
+		}
+		return err
+	}
+
+	if err := nrc.addDynamicNeighbors(); err != nil {
+		err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+		if err2 != nil {
+			klog.Errorf("Failed to stop bgpServer: %s", err2)
+		}
+		return err
+	}
+
 	// If the global routing peer is configured then peer with it
 	// else attempt to get peers from node specific BGP annotations.
 	if len(nrc.globalPeerRouters) == 0 {
@@ -1131,6 +1593,20 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 			}
 		}
 
+		// Get Global Peer Router password secrets configs
+		nodeBGPPasswordSecretsAnnotation, ok := node.ObjectMeta.Annotations[peerPasswordSecretsAnnotation]
+		if ok {
+			nrc.globalPeerPasswordSecrets, err = parsePeerPasswordSecrets(
+				stringToSlice(nodeBGPPasswordSecretsAnnotation, ","), len(peerIPs), peerPasswords)
+			if err != nil {
+				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+				if err2 != nil {
+					klog.Errorf("Failed to stop bgpServer: %s", err2)
+				}
+				return fmt.Errorf("failed to parse node's Peer Password Secrets Annotation: %s", err)
+			}
+		}
+
 		// Get Global Peer Router LocalIP configs
 		var peerLocalIPs []string
 		nodeBGPPeerLocalIPs, ok := node.ObjectMeta.Annotations[peerLocalIPAnnotation]
@@ -1160,9 +1636,80 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 			}
 		}
 
+		// Get Global Peer Router maximum prefixes configs
+		var peerMaxPrefixes []uint32
+		nodeBGPPeerMaxPrefixes, ok := node.ObjectMeta.Annotations[peerMaxPrefixesAnnotation]
+		if ok {
+			peerMaxPrefixes, err = stringSliceToUInt32(stringToSlice(nodeBGPPeerMaxPrefixes, ","))
+			if err != nil {
+				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+				if err2 != nil {
+					klog.Errorf("Failed to stop bgpServer: %s", err2)
+				}
+				return fmt.Errorf("failed to parse node's Peer Maximum Prefixes Annotation: %s", err)
+			}
+		}
+
+		// Get Global Peer Router graceful restart times configs
+		var peerRestartTimes []time.Duration
+		nodeBGPPeerRestartTimes, ok := node.ObjectMeta.Annotations[peerGracefulRestartAnnotation]
+		if ok {
+			peerRestartTimes, err = stringSliceToDurations(stringToSlice(nodeBGPPeerRestartTimes, ","))
+			if err != nil {
+				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+				if err2 != nil {
+					klog.Errorf("Failed to stop bgpServer: %s", err2)
+				}
+				return fmt.Errorf("failed to parse node's Peer Graceful Restart Times Annotation: %s", err)
+			}
+		}
+
+		// Get Global Peer Router long-lived stale times configs
+		var peerStaleTimes []time.Duration
+		nodeBGPPeerStaleTimes, ok := node.ObjectMeta.Annotations[peerLongLivedStaleAnnotation]
+		if ok {
+			peerStaleTimes, err = stringSliceToDurations(stringToSlice(nodeBGPPeerStaleTimes, ","))
+			if err != nil {
+				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+				if err2 != nil {
+					klog.Errorf("Failed to stop bgpServer: %s", err2)
+				}
+				return fmt.Errorf("failed to parse node's Peer Long-Lived Stale Times Annotation: %s", err)
+			}
+		}
+
+		// Get Global Peer Router multihop TTLs and TTL security hops configs
+		var peerMultihopTTLs, peerTTLSecurityHops []uint32
+		nodeBGPPeerMultihopTTLs, ok := node.ObjectMeta.Annotations[peerMultihopTTLAnnotation]
+		if ok {
+			peerMultihopTTLs, err = stringSliceToUInt32(stringToSlice(nodeBGPPeerMultihopTTLs, ","))
+			if err != nil {
+				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+				if err2 != nil {
+					klog.Errorf("Failed to stop bgpServer: %s", err2)
+				}
+				return fmt.Errorf("failed to parse node's Peer Multihop TTLs Annotation: %s", err)
+			}
+		}
+		nodeBGPPeerTTLSecurityHops, ok := node.ObjectMeta.Annotations[peerTTLSecurityHopsAnnotation]
+		if ok {
+			peerTTLSecurityHops, err = stringSliceToUInt32(stringToSlice(nodeBGPPeerTTLSecurityHops, ","))
+			if err != nil {
+				err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+				if err2 != nil {
+					klog.Errorf("Failed to stop bgpServer: %s", err2)
+				}
+				return fmt.Errorf("failed to parse node's Peer TTL Security Hops Annotation: %s", err)
+			}
+		}
+
 		// Create and set Global Peer Router complete configs
 		nrc.globalPeerRouters, err = newGlobalPeers(peerIPs, peerPorts, peerASNs, peerPasswords, peerLocalIPs,
-			nrc.bgpHoldtime, nrc.nodeIP.String())
+			peerMaxPrefixes, nrc.peerMaxPrefixesWarningPct, peerRestartTimes, peerStaleTimes, nrc.bgpHoldtime,
+			nrc.bgpIP.String())
+		if err == nil {
+			err = setPeerTTLs(nrc.globalPeerRouters, peerMultihopTTLs, peerTTLSecurityHops)
+		}
 		if err != nil {
 			err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
 			if err2 != nil {
@@ -1175,7 +1722,23 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 		nrc.nodePeerRouters = ipStrings
 	}
 
+	// the unnumbered peers of the flags take precedence over the ones of the node annotations as well
+	if len(nrc.unnumberedPeerRouters) == 0 {
+		nrc.unnumberedPeerRouters, err = nrc.nodeUnnumberedPeers(node)
+		if err != nil {
+			err2 := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{})
+			if err2 != nil {
+				klog.Errorf("Failed to stop bgpServer: %s", err2)
+			}
+			return fmt.Errorf("failed to process the unnumbered peer configs: %s", err)
+		}
+	}
+	if len(nrc.unnumberedPeerRouters) != 0 {
+		nrc.syncUnnumberedPeers()
+	}
+
 	if len(nrc.globalPeerRouters) != 0 {
+		nrc.syncPeerPasswordSecrets(false)
 		err := nrc.connectToExternalBGPPeers(nrc.bgpServer, nrc.globalPeerRouters, nrc.bgpGracefulRestart,
 			nrc.bgpGracefulRestartDeferralTime, nrc.bgpGracefulRestartTime, nrc.peerMultihopTTL)
 		if err != nil {
@@ -1187,6 +1750,7 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 			return fmt.Errorf("failed to peer with Global Peer Router(s): %s",
 				err)
 		}
+		nrc.syncBFDSessions(nrc.globalPeerRouters)
 	} else {
 		klog.Infof("No Global Peer Routers configured. Peering skipped.")
 	}
@@ -1200,17 +1764,26 @@ func (nrc *NetworkRoutingController) startBgpServer(grpcServer bool) error {
 func NewNetworkRoutingController(clientset kubernetes.Interface,
 	kubeRouterConfig *options.KubeRouterConfig,
 	nodeInformer cache.SharedIndexInformer, svcInformer cache.SharedIndexInformer,
-	epInformer cache.SharedIndexInformer, ipsetMutex *sync.Mutex) (*NetworkRoutingController, error) {
+	epInformer cache.SharedIndexInformer, ipsetMutex *sync.Mutex,
+	sysctls *utils.SysctlManager) (*NetworkRoutingController, error) {
 
 	var err error
 
-	nrc := NetworkRoutingController{ipsetMutex: ipsetMutex}
+	nrc := NetworkRoutingController{ipsetMutex: ipsetMutex, sysctls: sysctls, logger: utils.ControllerLogger("NRC")}
 	if kubeRouterConfig.MetricsEnabled {
 		// Register the metrics for this controller
 		prometheus.MustRegister(metrics.ControllerBGPadvertisementsReceived)
 		prometheus.MustRegister(metrics.ControllerBGPadvertisementsSent)
 		prometheus.MustRegister(metrics.ControllerBGPInternalPeersSyncTime)
 		prometheus.MustRegister(metrics.ControllerBPGpeers)
+		prometheus.MustRegister(metrics.ControllerBGPPeerSessionState)
+		prometheus.MustRegister(metrics.ControllerBGPPeerUptime)
+		prometheus.MustRegister(metrics.ControllerBGPPeerPrefixesReceived)
+		prometheus.MustRegister(metrics.ControllerBGPPeerPrefixesAdvertised)
+		prometheus.MustRegister(metrics.ControllerBGPPeerFlaps)
+		prometheus.MustRegister(metrics.ControllerCNIConfDrift)
+		prometheus.MustRegister(metrics.ControllerOverlayMTU)
+		prometheus.MustRegister(metrics.ControllerOverlayPMTUBlackhole)
 		prometheus.MustRegister(metrics.ControllerRoutesSyncTime)
 		nrc.MetricsEnabled = true
 	}
@@ -1221,12 +1794,49 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.bgpGracefulRestart = kubeRouterConfig.BGPGracefulRestart
 	nrc.bgpGracefulRestartDeferralTime = kubeRouterConfig.BGPGracefulRestartDeferralTime
 	nrc.bgpGracefulRestartTime = kubeRouterConfig.BGPGracefulRestartTime
+	nrc.bgpLongLivedGracefulRestart = kubeRouterConfig.BGPLongLivedGracefulRestart
+	nrc.bgpLongLivedStaleTime = kubeRouterConfig.BGPLongLivedStaleTime
+	nrc.gracefulWithdraw = kubeRouterConfig.GracefulWithdraw
+	nrc.gracefulWithdrawDelay = kubeRouterConfig.GracefulWithdrawDelay
+	nrc.bgpAddPaths = kubeRouterConfig.BGPAddPaths
 	nrc.peerMultihopTTL = kubeRouterConfig.PeerMultihopTTL
+	nrc.peerECMP = kubeRouterConfig.PeerECMP
+	nrc.peerMaxPrefixesWarningPct = kubeRouterConfig.PeerMaxPrefixesWarningPct
+	nrc.peerMaxPrefixesRestartTime = kubeRouterConfig.PeerMaxPrefixesRestartTime
+	if nrc.peerMaxPrefixesWarningPct > 100 {
+		return nil, errors.New("the maximum prefixes warning percentage can't be greater than 100")
+	}
+	nrc.rpkiCaches, err = parseRPKICaches(kubeRouterConfig.RPKICaches)
+	if err != nil {
+		return nil, err
+	}
+	nrc.rpkiDefaultInvalidAction = kubeRouterConfig.RPKIInvalidAction
+	if kubeRouterConfig.BGPRouteFlapDampening {
+		if kubeRouterConfig.BGPRouteFlapDampeningHalfLife <= 0 {
+			return nil, errors.New("the route flap dampening half-life must be greater than zero")
+		}
+		nrc.routeDampener = newFlapDampener(kubeRouterConfig.BGPRouteFlapDampeningHalfLife, nrc.reuseDampenedPaths)
+		nrc.vipDampener = newFlapDampener(kubeRouterConfig.BGPRouteFlapDampeningHalfLife,
+			func(map[string]interface{}) { nrc.syncVIPAdvertisements() })
+	}
+	nrc.peerBFD = kubeRouterConfig.PeerBFD
+	nrc.peerBFDInterval = kubeRouterConfig.PeerBFDInterval
+	nrc.peerBFDMultiplier = kubeRouterConfig.PeerBFDMultiplier
+	if nrc.peerBFD {
+		if nrc.peerBFDInterval <= 0 || nrc.peerBFDMultiplier == 0 {
+			return nil, errors.New("the BFD interval and multiplier must be greater than zero")
+		}
+		if nrc.peerMultihopTTL > 1 {
+			return nil, errors.New("BFD can only be used with directly connected BGP peers, it can't be combined " +
+				"with eBGP multihop")
+		}
+	}
 	nrc.enablePodEgress = kubeRouterConfig.EnablePodEgress
 	nrc.syncPeriod = kubeRouterConfig.RoutesSyncPeriod
 	nrc.overrideNextHop = kubeRouterConfig.OverrideNextHop
 	nrc.clientset = clientset
 	nrc.activeNodes = make(map[string]bool)
+	nrc.ibgpZonePeers = make(map[string]string)
 	nrc.bgpRRClient = false
 	nrc.bgpRRServer = false
 	nrc.bgpServerStarted = false
@@ -1247,6 +1857,7 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	}
 
 	nrc.nodeName = node.Name
+	nrc.eventRecorder = utils.NewEventRecorder(clientset, nrc.nodeName)
 
 	nodeIP, err := utils.GetNodeIP(node)
 	if err != nil {
@@ -1254,6 +1865,7 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	}
 	nrc.nodeIP = nodeIP
 	nrc.isIpv6 = nodeIP.To4() == nil
+	nrc.enableDualStack = kubeRouterConfig.EnableIPv4 && kubeRouterConfig.EnableIPv6
 
 	if kubeRouterConfig.RouterID != "" {
 		nrc.routerID = kubeRouterConfig.RouterID
@@ -1262,6 +1874,25 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 			return nil, errors.New("router-id must be specified in ipv6 operation")
 		}
 		nrc.routerID = nrc.nodeIP.String()
+		nrc.routerIDFromNodeIP = true
+	}
+	nrc.nodeIPChangeChan = make(chan net.IP, 1)
+
+	nrc.bgpInterface = kubeRouterConfig.BGPInterface
+	nrc.overlayInterface = kubeRouterConfig.OverlayInterface
+	if err = nrc.selectNodeAddresses(node); err != nil {
+		return nil, err
+	}
+	if err = nrc.publishNodeAddresses(node); err != nil {
+		return nil, err
+	}
+	if nrc.enableOverlays && nrc.overlayEncapsulation == options.OverlayEncapsulationWireGuard {
+		if nrc.wireguardPublicKey, err = wireguardPublicKey(nrc.wireguardPrivateKeyFile); err != nil {
+			return nil, err
+		}
+		if err = nrc.publishWireGuardPublicKey(node); err != nil {
+			return nil, err
+		}
 	}
 
 	// lets start with assumption we hace necessary IAM creds to access EC2 api
@@ -1272,18 +1903,20 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 		if nrc.cniConfFile == "" {
 			nrc.cniConfFile = "/etc/cni/net.d/10-kuberouter.conf"
 		}
-		if _, err := os.Stat(nrc.cniConfFile); os.IsNotExist(err) {
-			return nil, errors.New("CNI conf file " + nrc.cniConfFile + " does not exist.")
+		nrc.cniConfig, err = nrc.newCNIConfigManager(clientset, kubeRouterConfig.CNIConfTemplate)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	cidr, err := utils.GetPodCidrFromNodeSpec(clientset, nrc.hostnameOverride)
+	cidrs, err := utils.GetPodCIDRsFromNodeSpec(clientset, nrc.hostnameOverride)
 	if err != nil {
 		klog.Fatalf("Failed to get pod CIDR from node spec. kube-router relies on kube-controller-manager to "+
 			"allocate pod CIDR for the node or an annotation `kube-router.io/pod-cidr`. Error: %v", err)
 		return nil, fmt.Errorf("failed to get pod CIDR details from Node.spec: %s", err.Error())
 	}
-	nrc.podCidr = cidr
+	nrc.podCidr = cidrs[0]
+	nrc.podCIDRs = cidrs
 
 	nrc.ipSetHandler, err = utils.NewIPSet(nrc.isIpv6)
 	if err != nil {
@@ -1318,9 +1951,56 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.advertiseExternalIP = kubeRouterConfig.AdvertiseExternalIP
 	nrc.advertiseLoadBalancerIP = kubeRouterConfig.AdvertiseLoadBalancerIP
 	nrc.advertisePodCidr = kubeRouterConfig.AdvertiseNodePodCidr
+	if nrc.clusterIPCommunities, err = parseCommunities(kubeRouterConfig.AdvertiseClusterIPCommunities); err != nil {
+		return nil, fmt.Errorf("invalid Cluster IP BGP communities: %v", err)
+	}
+	if nrc.externalIPCommunities, err = parseCommunities(kubeRouterConfig.AdvertiseExternalIPCommunities); err != nil {
+		return nil, fmt.Errorf("invalid External IP BGP communities: %v", err)
+	}
+	nrc.loadBalancerIPCommunities, err = parseCommunities(kubeRouterConfig.AdvertiseLoadBalancerIPCommunities)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LoadBalancer IP BGP communities: %v", err)
+	}
+	if nrc.podCIDRCommunities, err = parseCommunities(kubeRouterConfig.AdvertisePodCidrCommunities); err != nil {
+		return nil, fmt.Errorf("invalid pod CIDR BGP communities: %v", err)
+	}
+	if nrc.podCIDRSummaries, err = parsePodCIDRSummaries(kubeRouterConfig.AdvertisePodCidrSummaries); err != nil {
+		return nil, err
+	}
+	if nrc.podCIDRSummaryPeers, err = parsePodCIDRSummaryPeers(kubeRouterConfig.AdvertisePodCidrSummaryPeers); err != nil {
+		return nil, err
+	}
+	if nrc.importExternalRoutes, err = parseImportExternalRoutes(kubeRouterConfig.ImportExternalRoutes); err != nil {
+		return nil, err
+	}
+	nrc.importExternalRoutesTable = kubeRouterConfig.ImportExternalRoutesTable
+	nrc.nextHopResolver = resolveNextHop
+	if len(nrc.podCIDRSummaries) > 0 && !nrc.bgpEnableInternal {
+		klog.Warning("The pod CIDR summaries are advertised without iBGP, the nodes need routes to the pod CIDRs " +
+			"of the other nodes to route the traffic they attract for them")
+	}
+	nrc.vipWeight = kubeRouterConfig.AdvertiseVIPWeight
+	nrc.ibgpZoneLabel = kubeRouterConfig.IBGPZoneLabel
+	nrc.ibgpZoneRouteReflectors = kubeRouterConfig.IBGPZoneRouteReflectors
+	if nrc.ibgpZoneLabel != "" && nrc.ibgpZoneRouteReflectors == 0 {
+		return nil, errors.New("a partitioned iBGP mesh needs at least one route reflector per zone")
+	}
 	nrc.autoMTU = kubeRouterConfig.AutoMTU
+	nrc.cniBandwidthPlugin = kubeRouterConfig.CNIBandwidthPlugin
+	nrc.cniMode = kubeRouterConfig.CNIMode
+	nrc.cniTuningSysctls = kubeRouterConfig.CNITuningSysctls
 	nrc.enableOverlays = kubeRouterConfig.EnableOverlay
 	nrc.overlayType = kubeRouterConfig.OverlayType
+	nrc.overlayEncapsulation = kubeRouterConfig.OverlayEncapsulation
+	nrc.vxlanPort = kubeRouterConfig.VXLANPort
+	nrc.genevePort = kubeRouterConfig.GenevePort
+	nrc.wireguardPort = kubeRouterConfig.WireGuardPort
+	nrc.wireguardPrivateKeyFile = kubeRouterConfig.WireGuardPrivateKeyFile
+	nrc.wireguardPeers = make(map[string]*wireguardPeer)
+	nrc.overlayMTUProbePeriod = kubeRouterConfig.OverlayMTUProbePeriod
+	nrc.overlayRoutes = make(map[string]net.IP)
+	nrc.overlayPathMTUs = make(map[string]int)
+	nrc.pathMTUProber = pingUnfragmented
 	nrc.CNIFirewallSetup = sync.NewCond(&sync.Mutex{})
 
 	nrc.bgpPort = kubeRouterConfig.BGPPort
@@ -1356,24 +2036,60 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 			return nil, fmt.Errorf("failed to decode CLI Peer Passwords file: %s", err)
 		}
 	}
+	nrc.globalPeerPasswordSecrets, err = parsePeerPasswordSecrets(kubeRouterConfig.PeerPasswordSecrets,
+		len(kubeRouterConfig.PeerRouters), peerPasswords)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CLI Peer Password Secrets flag: %s", err)
+	}
+
+	peerMaxPrefixes := make([]uint32, 0)
+	for _, i := range kubeRouterConfig.PeerMaxPrefixes {
+		peerMaxPrefixes = append(peerMaxPrefixes, uint32(i))
+	}
 
 	nrc.globalPeerRouters, err = newGlobalPeers(kubeRouterConfig.PeerRouters, peerPorts,
-		peerASNs, peerPasswords, nil, nrc.bgpHoldtime, nrc.nodeIP.String())
+		peerASNs, peerPasswords, nil, peerMaxPrefixes, nrc.peerMaxPrefixesWarningPct,
+		kubeRouterConfig.PeerGracefulRestartTimes, kubeRouterConfig.PeerLongLivedStaleTimes, nrc.bgpHoldtime,
+		nrc.bgpIP.String())
 	if err != nil {
 		return nil, fmt.Errorf("error processing Global Peer Router configs: %s", err)
 	}
 
-	nrc.nodeSubnet, nrc.nodeInterface, err = getNodeSubnet(nodeIP)
+	peerMultihopTTLs := make([]uint32, 0)
+	for _, i := range kubeRouterConfig.PeerMultihopTTLs {
+		peerMultihopTTLs = append(peerMultihopTTLs, uint32(i))
+	}
+	peerTTLSecurityHops := make([]uint32, 0)
+	for _, i := range kubeRouterConfig.PeerTTLSecurityHops {
+		peerTTLSecurityHops = append(peerTTLSecurityHops, uint32(i))
+	}
+	err = setPeerTTLs(nrc.globalPeerRouters, peerMultihopTTLs, peerTTLSecurityHops)
 	if err != nil {
-		return nil, errors.New("failed find the subnet of the node IP and interface on" +
-			"which its configured: " + err.Error())
+		return nil, fmt.Errorf("error processing Global Peer Router configs: %s", err)
+	}
+
+	peerInterfaceASNs := make([]uint32, 0)
+	for _, i := range kubeRouterConfig.PeerInterfaceASNs {
+		peerInterfaceASNs = append(peerInterfaceASNs, uint32(i))
+	}
+	nrc.unnumberedPeerRouters, err = newUnnumberedPeers(kubeRouterConfig.PeerInterfaces, peerInterfaceASNs,
+		nrc.bgpHoldtime)
+	if err != nil {
+		return nil, fmt.Errorf("error processing the unnumbered peer configs: %s", err)
+	}
+
+	nrc.dynamicNeighborRanges, err = newDynamicNeighborRanges(kubeRouterConfig.PeerListenRanges,
+		kubeRouterConfig.PeerListenRangeASNs)
+	if err != nil {
+		return nil, fmt.Errorf("error processing the dynamic neighbor configs: %s", err)
 	}
 
 	bgpLocalAddressListAnnotation, ok := node.ObjectMeta.Annotations[bgpLocalAddressAnnotation]
 	if !ok {
 		klog.Infof("Could not find annotation `kube-router.io/bgp-local-addresses` on node object so BGP "+
-			"will listen on node IP: %s address.", nrc.nodeIP.String())
-		nrc.localAddressList = append(nrc.localAddressList, nrc.nodeIP.String())
+			"will listen on BGP address: %s.", nrc.bgpIP.String())
+		nrc.localAddressList = append(nrc.localAddressList, nrc.bgpAddresses()...)
+		nrc.localAddressFromNodeIP = true
 	} else {
 		klog.Infof("Found annotation `kube-router.io/bgp-local-addresses` on node object so BGP will listen "+
 			"on local IP's: %s", bgpLocalAddressListAnnotation)
@@ -1389,6 +2105,13 @@ func NewNetworkRoutingController(clientset kubernetes.Interface,
 	nrc.svcLister = svcInformer.GetIndexer()
 	nrc.ServiceEventHandler = nrc.newServiceEventHandler()
 
+	nrc.advertiseGates, err = newAdvertiseGates(kubeRouterConfig.AdvertiseHealthChecks,
+		kubeRouterConfig.AdvertiseHealthCheckPeriod, nrc.nodeName, clientset, nrc.svcLister,
+		nrc.syncVIPAdvertisements)
+	if err != nil {
+		return nil, err
+	}
+
 	nrc.epLister = epInformer.GetIndexer()
 	nrc.EndpointsEventHandler = nrc.newEndpointsEventHandler()
 
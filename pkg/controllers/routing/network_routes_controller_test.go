@@ -1129,6 +1129,26 @@ func Test_nodeHasEndpointsForService(t *testing.T) {
 			false,
 			nil,
 		},
+		{
+			"service has no endpoints resource",
+			&NetworkRoutingController{
+				nodeName: "node-1",
+			},
+			&v1core.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "svc-1",
+					Namespace: "default",
+				},
+				Spec: v1core.ServiceSpec{
+					Type:        ClusterIPST,
+					ClusterIP:   "10.0.0.1",
+					ExternalIPs: []string{"1.1.1.1", "2.2.2.2"},
+				},
+			},
+			nil,
+			false,
+			nil,
+		},
 	}
 
 	for _, testcase := range testcases {
@@ -1136,18 +1156,22 @@ func Test_nodeHasEndpointsForService(t *testing.T) {
 			clientset := fake.NewSimpleClientset()
 			startInformersForRoutes(testcase.nrc, clientset)
 
-			_, err := clientset.CoreV1().Endpoints("default").Create(context.Background(), testcase.existingEndpoint, metav1.CreateOptions{})
-			if err != nil {
-				t.Fatalf("failed to create existing endpoints: %v", err)
+			if testcase.existingEndpoint != nil {
+				_, err := clientset.CoreV1().Endpoints("default").Create(context.Background(), testcase.existingEndpoint, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatalf("failed to create existing endpoints: %v", err)
+				}
 			}
 
-			_, err = clientset.CoreV1().Services("default").Create(context.Background(), testcase.existingService, metav1.CreateOptions{})
+			_, err := clientset.CoreV1().Services("default").Create(context.Background(), testcase.existingService, metav1.CreateOptions{})
 			if err != nil {
 				t.Fatalf("failed to create existing services: %v", err)
 			}
 
 			waitForListerWithTimeout(testcase.nrc.svcLister, time.Second*10, t)
-			waitForListerWithTimeout(testcase.nrc.epLister, time.Second*10, t)
+			if testcase.existingEndpoint != nil {
+				waitForListerWithTimeout(testcase.nrc.epLister, time.Second*10, t)
+			}
 
 			nodeHasEndpoints, err := testcase.nrc.nodeHasEndpointsForService(testcase.existingService)
 			if !reflect.DeepEqual(err, testcase.err) {
@@ -1181,6 +1205,7 @@ func Test_advertisePodRoute(t *testing.T) {
 				bgpServer: gobgp.NewBgpServer(),
 				podCidr:   "172.20.0.0/24",
 				nodeIP:    net.ParseIP("10.0.0.1"),
+				overlayIP: net.ParseIP("10.0.0.1"),
 			},
 			"node-1",
 			&v1core.Node{
@@ -1203,6 +1228,7 @@ func Test_advertisePodRoute(t *testing.T) {
 				hostnameOverride: "node-1",
 				podCidr:          "172.20.0.0/24",
 				nodeIP:           net.ParseIP("10.0.0.1"),
+				overlayIP:        net.ParseIP("10.0.0.1"),
 			},
 			"",
 			&v1core.Node{
@@ -1758,6 +1784,43 @@ func Test_routeReflectorConfiguration(t *testing.T) {
 
 }
 
+func Test_startBgpServerRestart(t *testing.T) {
+	nrc := &NetworkRoutingController{
+		bgpFullMeshMode:  true,
+		bgpPort:          10000,
+		clientset:        fake.NewSimpleClientset(),
+		nodeIP:           net.ParseIP("10.0.0.0"),
+		routerID:         "10.0.0.0",
+		activeNodes:      make(map[string]bool),
+		hostnameOverride: "node-1",
+	}
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: map[string]string{
+		nodeCommunitiesAnnotation:        "100:100,100:200",
+		nodeCustomImportRejectAnnotation: "10.0.0.0/16",
+	}}}
+	if err := createNodes(nrc.clientset, []*v1core.Node{node}); err != nil {
+		t.Fatalf("failed to create existing nodes: %v", err)
+	}
+
+	// the BGP server is started again when the node IP changes
+	for i := 0; i < 2; i++ {
+		if err := nrc.startBgpServer(false); err != nil {
+			t.Fatalf("failed to start BGP server: %v", err)
+		}
+		if err := nrc.bgpServer.StopBgp(context.Background(), &gobgpapi.StopBgpRequest{}); err != nil {
+			t.Fatalf("failed to stop BGP server : %s", err)
+		}
+		if !reflect.DeepEqual([]string{"100:100", "100:200"}, nrc.nodeCommunities) {
+			t.Errorf("expected the communities of the node annotation after start %d, got %v", i+1,
+				nrc.nodeCommunities)
+		}
+		if len(nrc.nodeCustomImportRejectIPNets) != 1 {
+			t.Errorf("expected the import reject of the node annotation after start %d, got %v", i+1,
+				nrc.nodeCustomImportRejectIPNets)
+		}
+	}
+}
+
 /* Disabling test for now. OnNodeUpdate() behaviour is changed. test needs to be adopted.
 func Test_OnNodeUpdate(t *testing.T) {
 	testcases := []struct {
@@ -1927,6 +1990,44 @@ func Test_OnNodeUpdate(t *testing.T) {
 }
 */
 
+func Test_nodeIPChangeRequest(t *testing.T) {
+	nodeWithIP := func(name, ip string) *v1core.Node {
+		return &v1core.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1core.NodeStatus{Addresses: []v1core.NodeAddress{
+				{Type: v1core.NodeInternalIP, Address: ip},
+			}},
+		}
+	}
+	nrc := &NetworkRoutingController{
+		nodeName:         "node-1",
+		nodeIP:           net.ParseIP("10.0.0.1"),
+		nodeIPChangeChan: make(chan net.IP, 1),
+	}
+	handler := nrc.newNodeEventHandler()
+
+	// changes of other nodes and updates that keep the IP are not handed to the main loop
+	handler.OnUpdate(nodeWithIP("node-2", "10.0.0.2"), nodeWithIP("node-2", "10.0.0.12"))
+	handler.OnUpdate(nodeWithIP("node-1", "10.0.0.1"), nodeWithIP("node-1", "10.0.0.1"))
+	select {
+	case nodeIP := <-nrc.nodeIPChangeChan:
+		t.Fatalf("unexpected node IP change to %s", nodeIP)
+	default:
+	}
+
+	// only the latest of several pending changes is handed to the main loop
+	handler.OnUpdate(nodeWithIP("node-1", "10.0.0.1"), nodeWithIP("node-1", "10.0.0.5"))
+	handler.OnUpdate(nodeWithIP("node-1", "10.0.0.5"), nodeWithIP("node-1", "10.0.0.6"))
+	select {
+	case nodeIP := <-nrc.nodeIPChangeChan:
+		if !nodeIP.Equal(net.ParseIP("10.0.0.6")) {
+			t.Errorf("expected node IP change to 10.0.0.6, got %s", nodeIP)
+		}
+	default:
+		t.Fatal("expected a node IP change to be requested")
+	}
+}
+
 func Test_generateTunnelName(t *testing.T) {
 	testcases := []struct {
 		name       string
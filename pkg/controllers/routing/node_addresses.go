@@ -0,0 +1,110 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// annotations with the interface (or IP) selected for BGP peering and the overlay on a node
+	bgpInterfaceAnnotation     = "kube-router.io/bgp-interface"
+	overlayInterfaceAnnotation = "kube-router.io/overlay-interface"
+)
+
+// selectNodeAddresses selects the addresses of the node used for BGP peering and as the overlay tunnel endpoint (which
+// is also the next hop of the node's pod CIDR routes), both default to the node IP, and the address of the other
+// address family of dual-stack nodes
+func (nrc *NetworkRoutingController) selectNodeAddresses(node *v1core.Node) error {
+	bgpIP, err := utils.SelectNodeAddress(node, bgpInterfaceAnnotation, nrc.bgpInterface, nrc.nodeIP)
+	if err != nil {
+		return fmt.Errorf("failed to select the BGP address of the node: %v", err)
+	}
+	overlayIP, err := utils.SelectNodeAddress(node, overlayInterfaceAnnotation, nrc.overlayInterface, nrc.nodeIP)
+	if err != nil {
+		return fmt.Errorf("failed to select the overlay address of the node: %v", err)
+	}
+	nodeSubnet, nodeInterface, err := getNodeSubnet(overlayIP)
+	if err != nil {
+		return fmt.Errorf("failed to find the subnet of the overlay address %s and the interface on which it is "+
+			"configured: %v", overlayIP, err)
+	}
+
+	nrc.bgpIP = bgpIP
+	nrc.overlayIP = overlayIP
+	nrc.nodeSubnet = nodeSubnet
+	nrc.nodeInterface = nodeInterface
+	nrc.selectDualStackAddress(node)
+	return nil
+}
+
+// publishNodeAddresses annotates the node with its selected BGP and overlay addresses when they differ from the node
+// IP, so that the other nodes peer with the BGP address and accept the tunnel traffic from the overlay address. The
+// annotations are removed again once the addresses are the node IP.
+func (nrc *NetworkRoutingController) publishNodeAddresses(node *v1core.Node) error {
+	selected := map[string]net.IP{
+		utils.NodeBGPAddressAnnotation:     nrc.bgpIP,
+		utils.NodeOverlayAddressAnnotation: nrc.overlayIP,
+	}
+	annotations := make(map[string]interface{})
+	for annotation, ip := range selected {
+		value, ok := node.Annotations[annotation]
+		switch {
+		case !ip.Equal(nrc.nodeIP) && value != ip.String():
+			annotations[annotation] = ip.String()
+		case ip.Equal(nrc.nodeIP) && ok:
+			// a null value removes the annotation in a merge patch
+			annotations[annotation] = nil
+		}
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = nrc.clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate node %s with its BGP and overlay addresses: %v", node.Name, err)
+	}
+	return nil
+}
+
+// publishedNodeAddress returns the address of the node published in the given annotation, or its node IP when the
+// address is not published
+func publishedNodeAddress(node *v1core.Node, annotation string) (net.IP, error) {
+	value, ok := node.Annotations[annotation]
+	if !ok {
+		return utils.GetNodeIP(node)
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %s in %s annotation of node %s", value, annotation, node.Name)
+	}
+	return ip, nil
+}
+
+// nodeBGPAddress returns the address that is used to peer with the given node
+func nodeBGPAddress(node *v1core.Node) (net.IP, error) {
+	return publishedNodeAddress(node, utils.NodeBGPAddressAnnotation)
+}
+
+// publishedNodeAddressesChanged returns true when the node published other BGP or overlay addresses
+func publishedNodeAddressesChanged(oldNode, newNode *v1core.Node) bool {
+	for _, annotation := range []string{utils.NodeBGPAddressAnnotation, utils.NodeOverlayAddressAnnotation} {
+		if oldNode.Annotations[annotation] != newNode.Annotations[annotation] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_publishNodeAddresses(t *testing.T) {
+	testcases := []struct {
+		name                string
+		existingAnnotations map[string]string
+		bgpIP               net.IP
+		overlayIP           net.IP
+		expectedAnnotations map[string]string
+	}{
+		{
+			"addresses are the node IP",
+			nil,
+			net.ParseIP("10.0.0.1"),
+			net.ParseIP("10.0.0.1"),
+			nil,
+		},
+		{
+			"selected addresses are published",
+			map[string]string{"foo": "bar"},
+			net.ParseIP("192.168.1.1"),
+			net.ParseIP("192.168.2.1"),
+			map[string]string{"foo": "bar", utils.NodeBGPAddressAnnotation: "192.168.1.1",
+				utils.NodeOverlayAddressAnnotation: "192.168.2.1"},
+		},
+		{
+			"stale addresses are removed",
+			map[string]string{utils.NodeBGPAddressAnnotation: "192.168.1.1",
+				utils.NodeOverlayAddressAnnotation: "192.168.2.1"},
+			net.ParseIP("10.0.0.1"),
+			net.ParseIP("192.168.2.5"),
+			map[string]string{utils.NodeOverlayAddressAnnotation: "192.168.2.5"},
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: testcase.existingAnnotations}}
+			clientset := fake.NewSimpleClientset(node)
+			nrc := &NetworkRoutingController{
+				clientset: clientset,
+				nodeIP:    net.ParseIP("10.0.0.1"),
+				bgpIP:     testcase.bgpIP,
+				overlayIP: testcase.overlayIP,
+			}
+			if err := nrc.publishNodeAddresses(node); err != nil {
+				t.Fatalf("failed to publish node addresses: %v", err)
+			}
+
+			node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get node: %v", err)
+			}
+			if len(node.Annotations) != len(testcase.expectedAnnotations) {
+				t.Fatalf("expected annotations %v, got %v", testcase.expectedAnnotations, node.Annotations)
+			}
+			for key, value := range testcase.expectedAnnotations {
+				if node.Annotations[key] != value {
+					t.Errorf("expected annotations %v, got %v", testcase.expectedAnnotations, node.Annotations)
+				}
+			}
+		})
+	}
+}
+
+func Test_nodeBGPAddress(t *testing.T) {
+	node := &v1core.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1core.NodeStatus{Addresses: []v1core.NodeAddress{
+			{Type: v1core.NodeInternalIP, Address: "10.0.0.1"},
+		}},
+	}
+	if ip, err := nodeBGPAddress(node); err != nil || !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("expected node IP as BGP address of unannotated node, got %s (%v)", ip, err)
+	}
+
+	node.Annotations = map[string]string{utils.NodeBGPAddressAnnotation: "192.168.1.1"}
+	if ip, err := nodeBGPAddress(node); err != nil || !ip.Equal(net.ParseIP("192.168.1.1")) {
+		t.Errorf("expected published BGP address, got %s (%v)", ip, err)
+	}
+
+	node.Annotations = map[string]string{utils.NodeBGPAddressAnnotation: "eth1"}
+	if _, err := nodeBGPAddress(node); err == nil {
+		t.Error("expected an error for an invalid published BGP address")
+	}
+}
+
+func Test_nodeIPSetMembers(t *testing.T) {
+	nodes := []interface{}{
+		&v1core.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "dual-stack", Annotations: map[string]string{
+				utils.NodeOverlayAddressAnnotation: "fd00::10"}},
+			Spec: v1core.NodeSpec{PodCIDR: "172.20.0.0/24", PodCIDRs: []string{"172.20.0.0/24", "fd00:20::/64"}},
+			Status: v1core.NodeStatus{Addresses: []v1core.NodeAddress{
+				{Type: v1core.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1core.NodeInternalIP, Address: "fd00::1"},
+			}},
+		},
+		&v1core.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "ipv6-first", Annotations: map[string]string{
+				utils.NodeBGPAddressAnnotation: "192.168.1.2"}},
+			Spec: v1core.NodeSpec{PodCIDR: "fd00:21::/64", PodCIDRs: []string{"fd00:21::/64", "172.20.1.0/24"}},
+			Status: v1core.NodeStatus{Addresses: []v1core.NodeAddress{
+				{Type: v1core.NodeInternalIP, Address: "fd00::2"},
+				{Type: v1core.NodeInternalIP, Address: "10.0.0.2"},
+			}},
+		},
+	}
+
+	testcases := []struct {
+		name             string
+		ipv6             bool
+		expectedPodCIDRs []string
+		expectedNodeIPs  []string
+	}{
+		{"IPv4 sets", false, []string{"172.20.0.0/24", "172.20.1.0/24"},
+			[]string{"10.0.0.1", "10.0.0.2", "192.168.1.2"}},
+		{"IPv6 sets", true, []string{"fd00:20::/64", "fd00:21::/64"}, []string{"fd00::1", "fd00::10", "fd00::2"}},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			nrc := &NetworkRoutingController{isIpv6: testcase.ipv6}
+			podCIDRs, nodeIPs := nrc.nodeIPSetMembers(nodes)
+			assert.Equal(t, testcase.expectedPodCIDRs, podCIDRs)
+			assert.Equal(t, testcase.expectedNodeIPs, nodeIPs)
+		})
+	}
+}
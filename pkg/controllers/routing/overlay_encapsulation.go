@@ -0,0 +1,258 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	vxlanIfName = "kube-vxlan"
+	// overlayVNI is the VXLAN and Geneve network identifier of the overlay
+	overlayVNI = 1
+	// the UDP and VXLAN or Geneve headers and the inner Ethernet header
+	udpEncapsulationOverhead = 8 + 8 + 14
+	// the UDP and WireGuard data headers and the authentication tag
+	wireguardEncapsulationOverhead = 8 + 16 + 16
+)
+
+// encapsulationOverhead returns the bytes the given overlay encapsulation adds to the packets sent from the given
+// address: the outer IP header, plus the UDP and VXLAN or Geneve headers and the inner Ethernet header of the UDP
+// encapsulations, or the UDP and WireGuard headers and the authentication tag of WireGuard
+func encapsulationOverhead(encapsulation string, ip net.IP) int {
+	ipHeader := ipv4.HeaderLen
+	if ip.To4() == nil {
+		ipHeader = ipv6.HeaderLen
+	}
+	switch encapsulation {
+	case options.OverlayEncapsulationVXLAN, options.OverlayEncapsulationGeneve:
+		return ipHeader + udpEncapsulationOverhead
+	case options.OverlayEncapsulationWireGuard:
+		return ipHeader + wireguardEncapsulationOverhead
+	}
+	return ipHeader
+}
+
+// overlayIsL2 tells whether the overlay encapsulation carries Ethernet frames, whose routes through the overlay go
+// through the next hop so that the frames are addressed to the MAC of the overlay interface of the next hop
+func (nrc *NetworkRoutingController) overlayIsL2() bool {
+	return nrc.overlayEncapsulation == options.OverlayEncapsulationVXLAN ||
+		nrc.overlayEncapsulation == options.OverlayEncapsulationGeneve
+}
+
+// overlayMAC returns the MAC of the VXLAN and Geneve interfaces of the node with the given overlay address, a locally
+// administered address derived from the last 4 bytes of the overlay address so that the nodes know the MACs of each
+// other without having to publish them
+func overlayMAC(ip net.IP) net.HardwareAddr {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return net.HardwareAddr{0x0a, 0x58, ip[len(ip)-4], ip[len(ip)-3], ip[len(ip)-2], ip[len(ip)-1]}
+}
+
+// neighFamily returns the netlink family of the neighbor entries of the given address
+func neighFamily(ip net.IP) int {
+	if ip.To4() == nil {
+		return netlink.FAMILY_V6
+	}
+	return netlink.FAMILY_V4
+}
+
+// setupVXLAN creates the VXLAN interface the pod traffic to the other nodes is tunneled through, sourced from the
+// overlay address of the node. The interface doesn't learn the nodes behind the MACs, both are programmed for each node
+// the pod traffic is tunneled to.
+func (nrc *NetworkRoutingController) setupVXLAN() error {
+	if link, err := netlink.LinkByName(vxlanIfName); err == nil {
+		vxlan, ok := link.(*netlink.Vxlan)
+		if ok && vxlan.SrcAddr.Equal(nrc.overlayIP) && vxlan.Port == int(nrc.vxlanPort) {
+			return netlink.LinkSetUp(link)
+		}
+		klog.Infof("Setting up the VXLAN interface %s again for the overlay address %s", vxlanIfName, nrc.overlayIP)
+		if err = netlink.LinkDel(link); err != nil {
+			return fmt.Errorf("failed to delete the VXLAN interface %s: %v", vxlanIfName, err)
+		}
+	}
+
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = vxlanIfName
+	attrs.HardwareAddr = overlayMAC(nrc.overlayIP)
+	vxlan := &netlink.Vxlan{LinkAttrs: attrs, VxlanId: overlayVNI, SrcAddr: nrc.overlayIP, Port: int(nrc.vxlanPort)}
+	// need to skip binding device if nrc.nodeInterface is loopback, otherwise packets never leave
+	// from egress interface to the tunnel peer.
+	if nrc.nodeInterface != "lo" {
+		if nodeIf, err := netlink.LinkByName(nrc.nodeInterface); err == nil {
+			vxlan.VtepDevIndex = nodeIf.Attrs().Index
+		}
+	}
+	if mtu, err := utils.GetMTUFromNodeIP(nrc.overlayIP); err == nil {
+		vxlan.MTU = mtu - encapsulationOverhead(nrc.overlayEncapsulation, nrc.overlayIP)
+	}
+	if err := netlink.LinkAdd(vxlan); err != nil {
+		return fmt.Errorf("failed to create the VXLAN interface %s: %v", vxlanIfName, err)
+	}
+	link, err := netlink.LinkByName(vxlanIfName)
+	if err != nil {
+		return fmt.Errorf("failed to get the VXLAN interface %s: %v", vxlanIfName, err)
+	}
+	if err = netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring the VXLAN interface %s up: %v", vxlanIfName, err)
+	}
+	return nil
+}
+
+// setupVXLANPeer programs the MAC of the overlay interface of the node of the given next hop on the VXLAN interface,
+// along with the address of the node the frames to the MAC are sent to
+func (nrc *NetworkRoutingController) setupVXLANPeer(nextHop net.IP) (netlink.Link, error) {
+	link, err := netlink.LinkByName(vxlanIfName)
+	if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s, failed to get the "+
+			"VXLAN interface: %v", nextHop, err)
+	}
+	neighs := []*netlink.Neigh{
+		{LinkIndex: link.Attrs().Index, Family: unix.AF_BRIDGE, Flags: netlink.NTF_SELF,
+			State: netlink.NUD_PERMANENT, IP: nextHop, HardwareAddr: overlayMAC(nextHop)},
+		{LinkIndex: link.Attrs().Index, Family: neighFamily(nextHop), State: netlink.NUD_PERMANENT,
+			IP: nextHop, HardwareAddr: overlayMAC(nextHop)},
+	}
+	for _, neigh := range neighs {
+		if err = netlink.NeighSet(neigh); err != nil {
+			return nil, fmt.Errorf("route not injected for the route advertised by the node %s, failed to program "+
+				"its MAC on the VXLAN interface: %v", nextHop, err)
+		}
+	}
+	if err = addOverlayNodeRoute(nextHop, vxlanIfName); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// cleanupVXLANPeer removes the MAC of the node of the given next hop from the VXLAN interface once its routes no
+// longer go through the overlay
+func cleanupVXLANPeer(nextHop net.IP) {
+	link, err := netlink.LinkByName(vxlanIfName)
+	if err != nil {
+		return
+	}
+	neighs := []*netlink.Neigh{
+		{LinkIndex: link.Attrs().Index, Family: unix.AF_BRIDGE, Flags: netlink.NTF_SELF, IP: nextHop,
+			HardwareAddr: overlayMAC(nextHop)},
+		{LinkIndex: link.Attrs().Index, Family: neighFamily(nextHop), IP: nextHop},
+	}
+	for _, neigh := range neighs {
+		if err = netlink.NeighDel(neigh); err != nil && !strings.Contains(err.Error(), "no such file") {
+			klog.V(1).Infof("Failed to remove the MAC of node %s from the VXLAN interface: %v", nextHop, err)
+		}
+	}
+	deleteOverlayNodeRoute(nextHop, vxlanIfName)
+}
+
+// setupGeneveTunnel creates the Geneve tunnel to the node of the given next hop. Unlike VXLAN interfaces, Geneve
+// interfaces with the same VNI are told apart by their remote address, so each node gets a tunnel of its own like the
+// IPIP tunnels.
+func (nrc *NetworkRoutingController) setupGeneveTunnel(tunnelName string, nextHop net.IP) (netlink.Link, error) {
+	link, err := netlink.LinkByName(tunnelName)
+	// an error here indicates that the the tunnel didn't exist, so we need to create it, if it already exists there's
+	// nothing to do here
+	if err != nil {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Name = tunnelName
+		attrs.HardwareAddr = overlayMAC(nrc.overlayIP)
+		if mtu, err := utils.GetMTUFromNodeIP(nrc.overlayIP); err == nil {
+			attrs.MTU = mtu - encapsulationOverhead(nrc.overlayEncapsulation, nrc.overlayIP)
+		}
+		geneve := &netlink.Geneve{LinkAttrs: attrs, ID: overlayVNI, Remote: nextHop, Dport: nrc.genevePort}
+		if err = netlink.LinkAdd(geneve); err != nil {
+			return nil, fmt.Errorf("route not injected for the route advertised by the node %s "+
+				"Failed to create tunnel interface %s. error: %s", nextHop, tunnelName, err)
+		}
+		link, err = netlink.LinkByName(tunnelName)
+		if err != nil {
+			return nil, fmt.Errorf("route not injected for the route advertised by the node %s "+
+				"Failed to get tunnel interface by name error: %s", tunnelName, err)
+		}
+		if err = netlink.LinkSetUp(link); err != nil {
+			return nil, fmt.Errorf("failed to bring tunnel interface %s up due to: %s", tunnelName, err)
+		}
+	} else {
+		klog.V(1).Infof("Tunnel interface: %s for the node %s already exists.", tunnelName, nextHop)
+	}
+
+	err = netlink.NeighSet(&netlink.Neigh{LinkIndex: link.Attrs().Index, Family: neighFamily(nextHop),
+		State: netlink.NUD_PERMANENT, IP: nextHop, HardwareAddr: overlayMAC(nextHop)})
+	if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s, failed to program "+
+			"its MAC on tunnel interface %s: %v", nextHop, tunnelName, err)
+	}
+	if err = addOverlayNodeRoute(nextHop, tunnelName); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// addOverlayNodeRoute adds the route to the given node through the given overlay interface to the custom route table,
+// so that the traffic of the pods to the node goes through the overlay as well
+func addOverlayNodeRoute(nextHop net.IP, ifName string) error {
+	out, err := exec.Command("ip", "route", "list", "table", customRouteTableID).CombinedOutput()
+	if err != nil || !strings.Contains(string(out), nextHop.String()+" dev "+ifName+" ") {
+		//nolint:gosec // this exec should be safe from command injection given the parameter's context
+		if out, err = exec.Command("ip", "route", "replace", nextHop.String(), "dev", ifName, "table",
+			customRouteTableID).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add route in custom route table, err: %s, output: %s", err, string(out))
+		}
+	}
+	return nil
+}
+
+// deleteOverlayNodeRoute removes the route to the given node through the given overlay interface from the custom route
+// table
+func deleteOverlayNodeRoute(nextHop net.IP, ifName string) {
+	//nolint:gosec // this exec should be safe from command injection given the parameter's context
+	if out, err := exec.Command("ip", "route", "del", nextHop.String(), "dev", ifName, "table",
+		customRouteTableID).CombinedOutput(); err != nil {
+		klog.V(1).Infof("Failed to remove the route to node %s from the custom route table: %v, output: %s",
+			nextHop, err, string(out))
+	}
+}
+
+// setupOverlayEncapsulation sets up the interface of the overlay encapsulation shared by the tunnels to all the nodes,
+// and removes the ones of the other encapsulations
+func (nrc *NetworkRoutingController) setupOverlayEncapsulation() {
+	switch nrc.overlayEncapsulation {
+	case options.OverlayEncapsulationVXLAN:
+		klog.V(1).Info("Setting up the VXLAN overlay.")
+		if err := nrc.setupVXLAN(); err != nil {
+			klog.Errorf("Failed to set up the VXLAN overlay: %s", err.Error())
+		}
+	case options.OverlayEncapsulationWireGuard:
+		klog.V(1).Info("Setting up the WireGuard overlay.")
+		if err := nrc.setupWireGuard(); err != nil {
+			klog.Errorf("Failed to set up the WireGuard overlay: %s", err.Error())
+		}
+	}
+	if nrc.overlayEncapsulation != options.OverlayEncapsulationVXLAN {
+		deleteVXLAN()
+	}
+	if nrc.overlayEncapsulation != options.OverlayEncapsulationWireGuard {
+		deleteWireGuard()
+	}
+}
+
+// deleteVXLAN removes the VXLAN interface along with the MACs of the nodes and the routes through it
+func deleteVXLAN() {
+	link, err := netlink.LinkByName(vxlanIfName)
+	if err != nil {
+		return
+	}
+	if err = netlink.LinkDel(link); err != nil {
+		klog.Errorf("Failed to delete the VXLAN interface %s: %v", vxlanIfName, err)
+	}
+}
@@ -0,0 +1,71 @@
+package routing
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/vishvananda/netlink"
+)
+
+func Test_encapsulationOverhead(t *testing.T) {
+	testcases := []struct {
+		encapsulation string
+		ip            string
+		overhead      int
+	}{
+		{options.OverlayEncapsulationIPIP, "10.0.0.1", 20},
+		{options.OverlayEncapsulationIPIP, "2001:db8::1", 40},
+		{options.OverlayEncapsulationVXLAN, "10.0.0.1", 50},
+		{options.OverlayEncapsulationGeneve, "2001:db8::1", 70},
+		{options.OverlayEncapsulationWireGuard, "10.0.0.1", 60},
+		{options.OverlayEncapsulationWireGuard, "2001:db8::1", 80},
+	}
+	for _, testcase := range testcases {
+		if overhead := encapsulationOverhead(testcase.encapsulation, net.ParseIP(testcase.ip)); overhead !=
+			testcase.overhead {
+			t.Errorf("expected an overhead of %d for %s from %s, got %d", testcase.overhead, testcase.encapsulation,
+				testcase.ip, overhead)
+		}
+	}
+}
+
+func Test_overlayMAC(t *testing.T) {
+	testcases := []struct {
+		ip  string
+		mac net.HardwareAddr
+	}{
+		{"10.0.0.1", net.HardwareAddr{0x0a, 0x58, 10, 0, 0, 1}},
+		{"192.168.1.254", net.HardwareAddr{0x0a, 0x58, 192, 168, 1, 254}},
+		{"2001:db8::a:b", net.HardwareAddr{0x0a, 0x58, 0, 0x0a, 0, 0x0b}},
+	}
+	for _, testcase := range testcases {
+		if mac := overlayMAC(net.ParseIP(testcase.ip)); !reflect.DeepEqual(mac, testcase.mac) {
+			t.Errorf("expected the MAC %s for %s, got %s", testcase.mac, testcase.ip, mac)
+		}
+	}
+}
+
+func Test_neighFamily(t *testing.T) {
+	if family := neighFamily(net.ParseIP("10.0.0.1")); family != netlink.FAMILY_V4 {
+		t.Errorf("expected the IPv4 family for an IPv4 address, got %d", family)
+	}
+	if family := neighFamily(net.ParseIP("2001:db8::1")); family != netlink.FAMILY_V6 {
+		t.Errorf("expected the IPv6 family for an IPv6 address, got %d", family)
+	}
+}
+
+func Test_overlayIsL2(t *testing.T) {
+	for encapsulation, l2 := range map[string]bool{
+		options.OverlayEncapsulationIPIP:      false,
+		options.OverlayEncapsulationVXLAN:     true,
+		options.OverlayEncapsulationGeneve:    true,
+		options.OverlayEncapsulationWireGuard: false,
+	} {
+		nrc := &NetworkRoutingController{overlayEncapsulation: encapsulation}
+		if nrc.overlayIsL2() != l2 {
+			t.Errorf("expected %s to be an L2 overlay: %t", encapsulation, l2)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/metrics"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// the path MTU probes don't go below the minimum MTU every IPv4 host must accept and the minimum MTU of IPv6 links
+	minIPv4PathMTU         = 576
+	minIPv6PathMTU         = 1280
+	icmpEchoHeaderLen      = 8
+	icmpEchoIDMask         = 0xffff
+	icmpProtocol           = 1
+	icmpv6Protocol         = 58
+	overlayMTUProbeTimeout = time.Second
+)
+
+// the sequence numbers of the path MTU probes, so that the replies to the probes timed out aren't taken for the replies
+// to the later ones
+var pathMTUProbeSeq uint32
+
+// overlayRouteMTU returns the MTU of the given overlay route to the pod CIDR of another node through the given link,
+// the MTU of the path to the node minus the overhead of the overlay encapsulation. The IPIP and Geneve tunnels to the
+// node are set to that MTU. The route is tracked so that its MTU follows the path MTU found by the probes. It returns
+// 0, which leaves the route without an MTU, when --auto-mtu is off or the path MTU can't be found.
+func (nrc *NetworkRoutingController) overlayRouteMTU(dst *net.IPNet, nextHop net.IP, link netlink.Link) int {
+	if !nrc.autoMTU {
+		return 0
+	}
+	nrc.overlayMTUMutex.Lock()
+	defer nrc.overlayMTUMutex.Unlock()
+	nrc.overlayRoutes[dst.String()] = nextHop
+	mtu, err := nrc.overlayMTU(nextHop)
+	if err != nil {
+		klog.Errorf("Failed to find the MTU of the path to node %s for the MTU of its overlay routes: %v", nextHop, err)
+		return 0
+	}
+	return nrc.overlayLinkMTU(link, mtu)
+}
+
+// forgetOverlayRoute stops tracking the MTU of the overlay route to the given destination, along with the path MTU of
+// its next hop once no overlay route goes through it anymore
+func (nrc *NetworkRoutingController) forgetOverlayRoute(dst *net.IPNet) {
+	nrc.overlayMTUMutex.Lock()
+	defer nrc.overlayMTUMutex.Unlock()
+	nextHop, ok := nrc.overlayRoutes[dst.String()]
+	if !ok {
+		return
+	}
+	delete(nrc.overlayRoutes, dst.String())
+	for _, other := range nrc.overlayRoutes {
+		if other.Equal(nextHop) {
+			return
+		}
+	}
+	delete(nrc.overlayPathMTUs, nextHop.String())
+	metrics.ControllerOverlayMTU.DeleteLabelValues(nextHop.String())
+	metrics.ControllerOverlayPMTUBlackhole.DeleteLabelValues(nextHop.String())
+}
+
+// overlayMTU returns the MTU of the overlay traffic to the given node, it must be called with the lock held
+func (nrc *NetworkRoutingController) overlayMTU(nextHop net.IP) (int, error) {
+	linkMTU, routeMTU, err := underlayMTUs(nextHop)
+	if err != nil {
+		return 0, err
+	}
+	mtu := minMTU(linkMTU, routeMTU, nrc.overlayPathMTUs[nextHop.String()]) -
+		encapsulationOverhead(nrc.overlayEncapsulation, nextHop)
+	if nrc.MetricsEnabled {
+		metrics.ControllerOverlayMTU.WithLabelValues(nextHop.String()).Set(float64(mtu))
+	}
+	return mtu, nil
+}
+
+// overlayLinkMTU sets the MTU of the IPIP and Geneve tunnels, which each go to a single node, to the overlay MTU of
+// their node and returns the MTU of the routes through the given link, which is capped at the MTU of the VXLAN and
+// WireGuard interfaces shared by all the nodes
+func (nrc *NetworkRoutingController) overlayLinkMTU(link netlink.Link, mtu int) int {
+	if nrc.overlayEncapsulation != options.OverlayEncapsulationVXLAN &&
+		nrc.overlayEncapsulation != options.OverlayEncapsulationWireGuard && link.Attrs().MTU != mtu {
+		klog.V(1).Infof("Setting the MTU of the overlay tunnel %s to %d", link.Attrs().Name, mtu)
+		if err := netlink.LinkSetMTU(link, mtu); err != nil {
+			klog.Errorf("Failed to set the MTU of the overlay tunnel %s: %v", link.Attrs().Name, err)
+		} else {
+			return mtu
+		}
+	}
+	return minMTU(mtu, link.Attrs().MTU)
+}
+
+// overlayLinkName returns the name of the interface of the overlay routes to the given node
+func (nrc *NetworkRoutingController) overlayLinkName(nextHop net.IP) string {
+	switch nrc.overlayEncapsulation {
+	case options.OverlayEncapsulationVXLAN:
+		return vxlanIfName
+	case options.OverlayEncapsulationWireGuard:
+		return wireguardIfName
+	}
+	return generateTunnelName(nextHop.String())
+}
+
+// underlayMTUs returns the MTU of the interface the traffic to the given node leaves through, and the path MTU the
+// kernel has learned for the node from the ICMP errors of the routers along the path if any, 0 otherwise
+func underlayMTUs(nextHop net.IP) (int, int, error) {
+	routes, err := netlink.RouteGet(nextHop)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(routes) == 0 {
+		return 0, 0, fmt.Errorf("no route to %s", nextHop)
+	}
+	link, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	if err != nil {
+		return 0, 0, err
+	}
+	return link.Attrs().MTU, routes[0].MTU, nil
+}
+
+// minMTU returns the lowest of the given MTUs, ignoring the unknown ones which are 0
+func minMTU(mtus ...int) int {
+	lowest := 0
+	for _, mtu := range mtus {
+		if mtu > 0 && (lowest == 0 || mtu < lowest) {
+			lowest = mtu
+		}
+	}
+	return lowest
+}
+
+// runOverlayMTUProbes starts a goroutine that probes the path MTU to the nodes the overlay routes go through every
+// --overlay-mtu-probe-period
+func (nrc *NetworkRoutingController) runOverlayMTUProbes(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		t := time.NewTicker(nrc.overlayMTUProbePeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				nrc.probeOverlayPathMTUs()
+			case <-stopCh:
+				klog.Infof("Shutting down the overlay path MTU probes")
+				return
+			}
+		}
+	}(stopCh, wg)
+}
+
+// probeOverlayPathMTUs finds the path MTU to each of the nodes the overlay routes go through with unfragmentable
+// pings. When full-size pings are dropped along the path without the kernel learning a lower path MTU from an ICMP
+// error, the path is a PMTU blackhole on which the encapsulated packets that fill the MTU of the interface would be
+// dropped silently, so the MTU of the overlay routes to the node is lowered to the largest ping that gets through.
+func (nrc *NetworkRoutingController) probeOverlayPathMTUs() {
+	nrc.overlayMTUMutex.Lock()
+	nextHops := make(map[string]net.IP)
+	for _, nextHop := range nrc.overlayRoutes {
+		nextHops[nextHop.String()] = nextHop
+	}
+	nrc.overlayMTUMutex.Unlock()
+
+	changed := false
+	for _, nextHop := range nextHops {
+		linkMTU, _, err := underlayMTUs(nextHop)
+		if err != nil {
+			klog.Errorf("Failed to find the MTU of the interface to node %s to probe its path MTU: %v", nextHop, err)
+			continue
+		}
+		minPathMTU := minIPv4PathMTU
+		if nextHop.To4() == nil {
+			minPathMTU = minIPv6PathMTU
+		}
+		pathMTU, err := findPathMTU(func(size int) (bool, error) {
+			return nrc.pathMTUProber(nextHop, size)
+		}, minPathMTU, linkMTU)
+		if err != nil {
+			klog.Errorf("Failed to probe the path MTU to node %s: %v", nextHop, err)
+			continue
+		}
+		if pathMTU == 0 {
+			klog.V(2).Infof("Node %s doesn't answer to pings, not probing its path MTU", nextHop)
+			continue
+		}
+		_, routeMTU, err := underlayMTUs(nextHop)
+		if err != nil {
+			klog.Errorf("Failed to find the path MTU learned for node %s: %v", nextHop, err)
+			continue
+		}
+		blackhole := pathMTU < linkMTU && (routeMTU == 0 || routeMTU > pathMTU)
+		if blackhole {
+			klog.Warningf("Packets over %d bytes to node %s are dropped along the path without an ICMP error, "+
+				"lowering the MTU of its overlay routes", pathMTU, nextHop)
+		}
+		if nrc.MetricsEnabled {
+			value := 0.0
+			if blackhole {
+				value = 1
+			}
+			metrics.ControllerOverlayPMTUBlackhole.WithLabelValues(nextHop.String()).Set(value)
+		}
+		if nrc.updateOverlayRouteMTUs(nextHop, blackhole, pathMTU) {
+			changed = true
+		}
+	}
+	if changed {
+		nrc.routeSyncer.syncLocalRouteTable()
+	}
+}
+
+// updateOverlayRouteMTUs records the probed path MTU of the given node when its path is a PMTU blackhole, and updates
+// the MTU of its overlay routes. It returns whether the MTU of any route has changed.
+func (nrc *NetworkRoutingController) updateOverlayRouteMTUs(nextHop net.IP, blackhole bool, pathMTU int) bool {
+	nrc.overlayMTUMutex.Lock()
+	defer nrc.overlayMTUMutex.Unlock()
+	if blackhole {
+		nrc.overlayPathMTUs[nextHop.String()] = pathMTU
+	} else {
+		delete(nrc.overlayPathMTUs, nextHop.String())
+	}
+	mtu, err := nrc.overlayMTU(nextHop)
+	if err != nil {
+		klog.Errorf("Failed to find the MTU of the path to node %s for the MTU of its overlay routes: %v", nextHop, err)
+		return false
+	}
+	link, err := netlink.LinkByName(nrc.overlayLinkName(nextHop))
+	if err != nil {
+		klog.Errorf("Failed to find the overlay interface to node %s: %v", nextHop, err)
+		return false
+	}
+	mtu = nrc.overlayLinkMTU(link, mtu)
+	changed := false
+	for dst, other := range nrc.overlayRoutes {
+		if other.Equal(nextHop) && nrc.routeSyncer.setInjectedRouteMTU(dst, mtu) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// findPathMTU returns the largest packet size between the given minimum and maximum that gets through with the given
+// probe, by binary search. It returns 0 when the packets of the minimum size don't get through either, in which case
+// the probes are dropped or not answered regardless of their size.
+func findPathMTU(probe func(size int) (bool, error), minSize, maxSize int) (int, error) {
+	if ok, err := probe(maxSize); err != nil {
+		return 0, err
+	} else if ok {
+		return maxSize, nil
+	}
+	if ok, err := probe(minSize); err != nil || !ok {
+		return 0, err
+	}
+	// minSize gets through and maxSize doesn't
+	for maxSize-minSize > 1 {
+		size := (minSize + maxSize) / 2
+		ok, err := probe(size)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			minSize = size
+		} else {
+			maxSize = size
+		}
+	}
+	return minSize, nil
+}
+
+// pingUnfragmented sends an ICMP echo request of the given size, IP header included, to the given address without
+// letting it be fragmented along the path regardless of the path MTU the kernel has learned, and returns whether the
+// echo reply came back in time
+func pingUnfragmented(dst net.IP, size int) (bool, error) {
+	network, address, protocol := "ip4:icmp", "0.0.0.0", icmpProtocol
+	level, option, probe := unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE
+	var request, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	headerLen := ipv4.HeaderLen
+	if dst.To4() == nil {
+		network, address, protocol = "ip6:ipv6-icmp", "::", icmpv6Protocol
+		level, option, probe = unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE
+		request, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		headerLen = ipv6.HeaderLen
+	}
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	rawConn, err := conn.(*net.IPConn).SyscallConn()
+	if err != nil {
+		return false, err
+	}
+	var sockoptErr error
+	if err = rawConn.Control(func(fd uintptr) {
+		sockoptErr = unix.SetsockoptInt(int(fd), level, option, probe)
+	}); err != nil {
+		return false, err
+	}
+	if sockoptErr != nil {
+		return false, fmt.Errorf("failed to prohibit the fragmentation of the pings: %v", sockoptErr)
+	}
+
+	id, seq := os.Getpid()&icmpEchoIDMask, int(atomic.AddUint32(&pathMTUProbeSeq, 1)&icmpEchoIDMask)
+	msg := icmp.Message{Type: request, Body: &icmp.Echo{ID: id, Seq: seq,
+		Data: make([]byte, size-headerLen-icmpEchoHeaderLen)}}
+	// the checksum of ICMPv6 is filled in by the kernel for raw sockets
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+	if _, err = conn.WriteTo(b, &net.IPAddr{IP: dst}); err != nil {
+		if errors.Is(err, unix.EMSGSIZE) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(overlayMTUProbeTimeout)); err != nil {
+		return false, err
+	}
+	buf := make([]byte, size)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !from.(*net.IPAddr).IP.Equal(dst) {
+			continue
+		}
+		msg, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || msg.Type != reply {
+			continue
+		}
+		if echo, ok := msg.Body.(*icmp.Echo); ok && echo.ID == id && echo.Seq == seq {
+			return true, nil
+		}
+	}
+}
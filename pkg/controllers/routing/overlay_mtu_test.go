@@ -0,0 +1,97 @@
+package routing
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func Test_minMTU(t *testing.T) {
+	testcases := []struct {
+		name string
+		mtus []int
+		mtu  int
+	}{
+		{"interface MTU only", []int{1500, 0, 0}, 1500},
+		{"learned path MTU below the interface MTU", []int{1500, 1400, 0}, 1400},
+		{"probed path MTU below the learned one", []int{9000, 1500, 1450}, 1450},
+		{"no known MTU", []int{0, 0}, 0},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if mtu := minMTU(testcase.mtus...); mtu != testcase.mtu {
+				t.Errorf("expected the MTU %d, got %d", testcase.mtu, mtu)
+			}
+		})
+	}
+}
+
+func Test_findPathMTU(t *testing.T) {
+	testcases := []struct {
+		name    string
+		pathMTU int
+		err     error
+		found   int
+	}{
+		{"full-size packets get through", 1500, nil, 1500},
+		{"PMTU blackhole", 1412, nil, 1412},
+		{"minimum-size packets dropped", 0, nil, 0},
+		{"probe error", 1500, errors.New("probe error"), 0},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			probes := 0
+			found, err := findPathMTU(func(size int) (bool, error) {
+				probes++
+				return size <= testcase.pathMTU, testcase.err
+			}, minIPv4PathMTU, 1500)
+			if !errors.Is(err, testcase.err) {
+				t.Errorf("expected the error %v, got %v", testcase.err, err)
+			}
+			if found != testcase.found {
+				t.Errorf("expected the path MTU %d, got %d", testcase.found, found)
+			}
+			if probes > 12 {
+				t.Errorf("expected a binary search of the path MTU, got %d probes", probes)
+			}
+		})
+	}
+}
+
+func Test_forgetOverlayRoute(t *testing.T) {
+	nextHop := net.ParseIP("10.0.0.2")
+	_, dst1, _ := net.ParseCIDR("10.1.1.0/24")
+	_, dst2, _ := net.ParseCIDR("10.1.2.0/24")
+	nrc := &NetworkRoutingController{
+		overlayRoutes:   map[string]net.IP{dst1.String(): nextHop, dst2.String(): nextHop},
+		overlayPathMTUs: map[string]int{nextHop.String(): 1400},
+	}
+
+	nrc.forgetOverlayRoute(dst1)
+	if _, ok := nrc.overlayPathMTUs[nextHop.String()]; !ok {
+		t.Error("expected the path MTU of the next hop to be kept while an overlay route goes through it")
+	}
+	nrc.forgetOverlayRoute(dst2)
+	if len(nrc.overlayRoutes) != 0 || len(nrc.overlayPathMTUs) != 0 {
+		t.Errorf("expected the overlay routes and path MTUs to be forgotten, got %v and %v", nrc.overlayRoutes,
+			nrc.overlayPathMTUs)
+	}
+}
+
+func Test_setInjectedRouteMTU(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.1.1.0/24")
+	rs := newRouteSyncer(0)
+	rs.addInjectedRoute(dst, &netlink.Route{Dst: dst, MTU: 1480})
+
+	if !rs.setInjectedRouteMTU(dst.String(), 1380) || rs.routeTableStateMap[dst.String()].MTU != 1380 {
+		t.Errorf("expected the MTU of the route to be changed, got %d", rs.routeTableStateMap[dst.String()].MTU)
+	}
+	if rs.setInjectedRouteMTU(dst.String(), 1380) {
+		t.Error("expected an unchanged MTU not to be a change")
+	}
+	if rs.setInjectedRouteMTU("10.1.2.0/24", 1380) {
+		t.Error("expected no change for a destination without a route")
+	}
+}
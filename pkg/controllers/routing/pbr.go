@@ -22,11 +22,13 @@ func (nrc *NetworkRoutingController) enablePolicyBasedRouting() error {
 		return fmt.Errorf("failed to verify if `ip rule` exists: %s", err.Error())
 	}
 
-	if !strings.Contains(string(out), nrc.podCidr) {
-		//nolint:gosec // this exec should be safe from command injection given the parameter's context
-		err = exec.Command("ip", "rule", "add", "from", nrc.podCidr, "lookup", customRouteTableID).Run()
-		if err != nil {
-			return fmt.Errorf("failed to add ip rule due to: %s", err.Error())
+	for _, podCIDR := range nrc.allPodCIDRs() {
+		if !strings.Contains(string(out), podCIDR) {
+			//nolint:gosec // this exec should be safe from command injection given the parameter's context
+			err = exec.Command("ip", "rule", "add", "from", podCIDR, "lookup", customRouteTableID).Run()
+			if err != nil {
+				return fmt.Errorf("failed to add ip rule due to: %s", err.Error())
+			}
 		}
 	}
 
@@ -45,11 +47,13 @@ func (nrc *NetworkRoutingController) disablePolicyBasedRouting() error {
 			err.Error())
 	}
 
-	if strings.Contains(string(out), nrc.podCidr) {
-		//nolint:gosec // this exec should be safe from command injection given the parameter's context
-		err = exec.Command("ip", "rule", "del", "from", nrc.podCidr, "table", customRouteTableID).Run()
-		if err != nil {
-			return fmt.Errorf("failed to delete ip rule: %s", err.Error())
+	for _, podCIDR := range nrc.allPodCIDRs() {
+		if strings.Contains(string(out), podCIDR) {
+			//nolint:gosec // this exec should be safe from command injection given the parameter's context
+			err = exec.Command("ip", "rule", "del", "from", podCIDR, "table", customRouteTableID).Run()
+			if err != nil {
+				return fmt.Errorf("failed to delete ip rule: %s", err.Error())
+			}
 		}
 	}
 
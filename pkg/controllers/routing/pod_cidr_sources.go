@@ -0,0 +1,153 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	awsMetadataIPv4PrefixPathTemplate = "network/interfaces/macs/%s/ipv4-prefix"
+	gceMetadataIPAliasesURL           = "http://metadata.google.internal/computeMetadata/v1/instance/" +
+		"network-interfaces/0/ip-aliases/"
+	gceMetadataTimeout = 10 * time.Second
+)
+
+// the cloud metadata lookups are variables so that they can be replaced in tests
+var (
+	getAWSMetadataPodCIDRs = awsMetadataPodCIDRs
+	getGCEMetadataPodCIDRs = gceMetadataPodCIDRs
+)
+
+// awsMetadataPodCIDRs returns the IPv4 prefixes delegated to the primary ENI of the EC2 instance
+func awsMetadataPodCIDRs() ([]string, error) {
+	sess, err := session.NewSession(aws.NewConfig().WithMaxRetries(awsMaxRetries))
+	if err != nil {
+		return nil, err
+	}
+	metadataClient := ec2metadata.New(sess)
+	mac, err := metadataClient.GetMetadata("mac")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the MAC of the primary ENI: %v", err)
+	}
+	prefixes, err := metadataClient.GetMetadata(fmt.Sprintf(awsMetadataIPv4PrefixPathTemplate, mac))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the IPv4 prefixes delegated to ENI %s: %v", mac, err)
+	}
+	return parsePodCIDRList(prefixes)
+}
+
+// gceMetadataPodCIDRs returns the alias IP ranges of the first network interface of the GCE instance
+func gceMetadataPodCIDRs() ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, gceMetadataIPAliasesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := (&http.Client{Timeout: gceMetadataTimeout}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the GCE metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GCE metadata server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the alias IP ranges from the GCE metadata server: %v", err)
+	}
+	return parsePodCIDRList(string(body))
+}
+
+// parsePodCIDRList parses a list of CIDRs separated by commas and/or whitespace, as returned by the metadata services
+// and used in node annotations
+func parsePodCIDRList(list string) ([]string, error) {
+	cidrs := make([]string, 0)
+	for _, cidr := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t' || r == '\r'
+	}) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid pod CIDR %q: %v", cidr, err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// annotateNodePodCIDRs records the pod CIDRs of the node in its kube-router.io/pod-cidrs annotation, unless they are
+// already recorded
+func annotateNodePodCIDRs(clientset kubernetes.Interface, node *v1core.Node, cidrs []string) error {
+	annotation := strings.Join(cidrs, ",")
+	if node.Annotations[utils.PodCIDRsAnnotation] == annotation {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{utils.PodCIDRsAnnotation: annotation},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate node %s with its pod CIDRs: %v", node.Name, err)
+	}
+	return nil
+}
+
+// PublishPodCIDRsFromSource reads the pod CIDRs of this node from the given source and records them in the
+// kube-router.io/pod-cidrs annotation of the node, which is where the rest of kube-router (and the other nodes) pick
+// up the pod CIDRs from. This allows kube-router to be used on clusters where kube-controller-manager doesn't allocate
+// the pod CIDRs, without having to annotate the nodes by hand.
+func PublishPodCIDRsFromSource(clientset kubernetes.Interface, source, annotation,
+	hostnameOverride string) ([]string, error) {
+	node, err := utils.GetNodeObject(clientset, hostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+
+	var cidrs []string
+	switch source {
+	case options.PodCIDRSourceAWSMetadata:
+		cidrs, err = getAWSMetadataPodCIDRs()
+	case options.PodCIDRSourceGCEMetadata:
+		cidrs, err = getGCEMetadataPodCIDRs()
+	case options.PodCIDRSourceAnnotation:
+		value, ok := node.Annotations[annotation]
+		if !ok {
+			return nil, fmt.Errorf("node %s has no %s annotation", node.Name, annotation)
+		}
+		cidrs, err = parsePodCIDRList(value)
+	default:
+		return nil, fmt.Errorf("unknown pod CIDR source %s", source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("no pod CIDRs found for node %s from source %s", node.Name, source)
+	}
+
+	if err = annotateNodePodCIDRs(clientset, node, cidrs); err != nil {
+		return nil, err
+	}
+	klog.Infof("Using pod CIDRs %s of node %s from source %s", strings.Join(cidrs, ","), node.Name, source)
+	return cidrs, nil
+}
@@ -0,0 +1,82 @@
+package routing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_parsePodCIDRList(t *testing.T) {
+	testcases := []struct {
+		name     string
+		list     string
+		expected []string
+		err      bool
+	}{
+		{"newline separated metadata", "10.0.1.16/28\n10.0.1.32/28\n", []string{"10.0.1.16/28", "10.0.1.32/28"}, false},
+		{"comma separated annotation", "10.244.1.0/24, fd00:10:244:1::/64",
+			[]string{"10.244.1.0/24", "fd00:10:244:1::/64"}, false},
+		{"empty", "", []string{}, false},
+		{"invalid CIDR", "10.244.1.0/24,10.244.2.0", nil, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cidrs, err := parsePodCIDRList(tc.list)
+			if tc.err != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.err, err)
+			}
+			if !reflect.DeepEqual(cidrs, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, cidrs)
+			}
+		})
+	}
+}
+
+func Test_PublishPodCIDRsFromSource(t *testing.T) {
+	origAWS := getAWSMetadataPodCIDRs
+	defer func() { getAWSMetadataPodCIDRs = origAWS }()
+	getAWSMetadataPodCIDRs = func() ([]string, error) { return []string{"10.0.1.16/28", "10.0.1.32/28"}, nil }
+
+	testcases := []struct {
+		name        string
+		source      string
+		annotations map[string]string
+		expected    string
+		err         bool
+	}{
+		{"aws metadata", options.PodCIDRSourceAWSMetadata, nil, "10.0.1.16/28,10.0.1.32/28", false},
+		{"annotation of another IPAM", options.PodCIDRSourceAnnotation,
+			map[string]string{"ipam.example.com/pod-cidrs": "10.244.3.0/24"}, "10.244.3.0/24", false},
+		{"missing annotation", options.PodCIDRSourceAnnotation, nil, "", true},
+		{"empty annotation", options.PodCIDRSourceAnnotation,
+			map[string]string{"ipam.example.com/pod-cidrs": ""}, "", true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: tc.annotations}}
+			clientset := fake.NewSimpleClientset(node)
+
+			_, err := PublishPodCIDRsFromSource(clientset, tc.source, "ipam.example.com/pod-cidrs", "node-1")
+			if tc.err != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", tc.err, err)
+			}
+
+			node, err = clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get node: %v", err)
+			}
+			if node.Annotations[utils.PodCIDRsAnnotation] != tc.expected {
+				t.Errorf("expected pod CIDRs annotation %q, got %q", tc.expected,
+					node.Annotations[utils.PodCIDRsAnnotation])
+			}
+		})
+	}
+}
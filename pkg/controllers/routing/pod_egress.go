@@ -4,26 +4,34 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
 	"k8s.io/klog/v2"
 )
 
 // set up MASQUERADE rule so that egress traffic from the pods gets masqueraded to node's IP
 
+const podEgressComment = utils.OwnerComment + " masquerade outbound traffic from pods"
+
 var (
-	podEgressArgs4 = []string{"-m", "set", "--match-set", podSubnetsIPSetName, "src",
+	podEgressMatch4 = []string{"-m", "set", "--match-set", podSubnetsIPSetName, "src",
 		"-m", "set", "!", "--match-set", podSubnetsIPSetName, "dst",
 		"-m", "set", "!", "--match-set", nodeAddrsIPSetName, "dst",
 		"-j", "MASQUERADE"}
-	podEgressArgs6 = []string{"-m", "set", "--match-set", "inet6:" + podSubnetsIPSetName, "src",
+	podEgressMatch6 = []string{"-m", "set", "--match-set", "inet6:" + podSubnetsIPSetName, "src",
 		"-m", "set", "!", "--match-set", "inet6:" + podSubnetsIPSetName, "dst",
 		"-m", "set", "!", "--match-set", "inet6:" + nodeAddrsIPSetName, "dst",
 		"-j", "MASQUERADE"}
+	podEgressArgs4 = append([]string{"-m", "comment", "--comment", podEgressComment}, podEgressMatch4...)
+	podEgressArgs6 = append([]string{"-m", "comment", "--comment", podEgressComment}, podEgressMatch6...)
+	// the rules of the older versions of kube-router, the latest of which only lacked the comment
 	podEgressArgsBad4 = [][]string{{"-m", "set", "--match-set", podSubnetsIPSetName, "src",
 		"-m", "set", "!", "--match-set", podSubnetsIPSetName, "dst",
-		"-j", "MASQUERADE"}}
+		"-j", "MASQUERADE"},
+		podEgressMatch4, append(podEgressMatch4, "--random-fully")}
 	podEgressArgsBad6 = [][]string{{"-m", "set", "--match-set", "inet6:" + podSubnetsIPSetName, "src",
 		"-m", "set", "!", "--match-set", "inet6:" + podSubnetsIPSetName, "dst",
-		"-j", "MASQUERADE"}}
+		"-j", "MASQUERADE"},
+		podEgressMatch6, append(podEgressMatch6, "--random-fully")}
 )
 
 func (nrc *NetworkRoutingController) createPodEgressRule() error {
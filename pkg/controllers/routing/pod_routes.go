@@ -0,0 +1,258 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudnativelabs/kube-router/pkg/cri"
+	"github.com/cloudnativelabs/kube-router/pkg/options"
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const podRoutesAnnotation = "kube-router.io/pod.routes"
+
+// podRoute is a route to program in the network namespace of a pod
+type podRoute struct {
+	dst *net.IPNet
+	gw  net.IP
+}
+
+// parsePodRoutes parses the routes of the pod routes annotation, a comma separated list of routes in the format
+// "<destination CIDR> via <gateway>", e.g. "10.20.0.0/16 via 10.1.0.1, 192.168.0.0/24 via 10.1.0.1"
+func parsePodRoutes(annotation string) ([]podRoute, error) {
+	routes := make([]podRoute, 0)
+	for _, entry := range strings.Split(annotation, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 || fields[1] != "via" {
+			return nil, fmt.Errorf("route %q is not in the format \"<destination CIDR> via <gateway>\"",
+				strings.TrimSpace(entry))
+		}
+		_, dst, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination of route %q: %v", strings.TrimSpace(entry), err)
+		}
+		gw := net.ParseIP(fields[2])
+		if gw == nil {
+			return nil, fmt.Errorf("invalid gateway of route %q", strings.TrimSpace(entry))
+		}
+		if (dst.IP.To4() == nil) != (gw.To4() == nil) {
+			return nil, fmt.Errorf("the destination and gateway of route %q are of different address families",
+				strings.TrimSpace(entry))
+		}
+		routes = append(routes, podRoute{dst: dst, gw: gw})
+	}
+	return routes, nil
+}
+
+// PodRoutesController programs the routes listed in the kube-router.io/pod.routes annotation of the pods running on
+// this node in their network namespace, e.g. to reach a secondary network or an on-prem range through another gateway
+// than the default route of the pod.
+//
+// The routes are added with the protocol of the routes kube-router injects on the node, so that the routes removed
+// from the annotation are removed from the pod too while leaving the routes set up by the CNI alone. They are
+// reconciled periodically and whenever the annotation or the IP of a pod changes.
+type PodRoutesController struct {
+	nodeName        string
+	runtimeEndpoint string
+	syncPeriod      time.Duration
+	syncRequestChan chan struct{}
+
+	podLister cache.Indexer
+	// the pods whose network namespace holds routes from the annotation
+	podsWithRoutes map[types.UID]bool
+
+	PodEventHandler cache.ResourceEventHandler
+}
+
+// Run syncs the routes in the network namespace of the pods periodically and whenever a sync is requested till we
+// receive notification on stopCh
+func (prc *PodRoutesController) Run(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	t := time.NewTicker(prc.syncPeriod)
+	defer t.Stop()
+	defer wg.Done()
+
+	klog.Info("Starting pod routes controller")
+	for {
+		prc.sync()
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down pod routes controller")
+			return
+		case <-t.C:
+		case <-prc.syncRequestChan:
+		}
+	}
+}
+
+// RequestSync allows the request of a sync without blocking the callee
+func (prc *PodRoutesController) RequestSync() {
+	select {
+	case prc.syncRequestChan <- struct{}{}:
+	default:
+	}
+}
+
+func (prc *PodRoutesController) sync() {
+	var rs cri.RuntimeService
+	seen := make(map[types.UID]bool)
+	for _, obj := range prc.podLister.List() {
+		pod, ok := obj.(*v1core.Pod)
+		if !ok || pod.Spec.NodeName != prc.nodeName || pod.Spec.HostNetwork || pod.Status.Phase != v1core.PodRunning {
+			continue
+		}
+		annotation, hasRoutes := pod.Annotations[podRoutesAnnotation]
+		// the routes of pods whose annotation was removed still have to be cleaned up
+		if !hasRoutes && !prc.podsWithRoutes[pod.UID] {
+			continue
+		}
+		seen[pod.UID] = true
+
+		routes, err := parsePodRoutes(annotation)
+		if err != nil {
+			klog.Errorf("Invalid %s annotation on pod %s/%s: %v", podRoutesAnnotation, pod.Namespace, pod.Name, err)
+			continue
+		}
+		if rs == nil {
+			rs, err = cri.NewRemoteRuntimeService(prc.runtimeEndpoint, cri.DefaultConnectionTimeout)
+			if err != nil {
+				klog.Errorf("Failed to connect to the container runtime, not syncing the routes of the pods: %v", err)
+				return
+			}
+			defer utils.CloseCloserDisregardError(rs)
+		}
+		if err = syncPodRoutes(rs, pod, routes); err != nil {
+			klog.Errorf("Failed to sync the routes of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		klog.V(1).Infof("Synced %d routes in the network namespace of pod %s/%s", len(routes), pod.Namespace,
+			pod.Name)
+		prc.podsWithRoutes[pod.UID] = len(routes) > 0
+	}
+
+	for uid := range prc.podsWithRoutes {
+		if !seen[uid] || !prc.podsWithRoutes[uid] {
+			delete(prc.podsWithRoutes, uid)
+		}
+	}
+}
+
+// syncPodRoutes makes the routes in the network namespace of the pod that were added by kube-router match the given
+// routes
+func syncPodRoutes(rs cri.RuntimeService, pod *v1core.Pod, routes []podRoute) error {
+	var containerURL string
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.ContainerID != "" {
+			containerURL = status.ContainerID
+			break
+		}
+	}
+	if containerURL == "" {
+		return errors.New("no container of the pod has been started yet")
+	}
+	_, containerID, err := cri.EndpointParser(containerURL)
+	if err != nil {
+		return err
+	}
+	info, err := rs.ContainerInfo(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to get the PID of container %s: %v", containerID, err)
+	}
+
+	podNetNS, err := netns.GetFromPid(info.Pid)
+	if err != nil {
+		return fmt.Errorf("failed to get the network namespace of PID %d: %v", info.Pid, err)
+	}
+	defer utils.CloseCloserDisregardError(&podNetNS)
+	// the handle works in the network namespace of the pod without switching the namespace of the current thread
+	handle, err := netlink.NewHandleAt(podNetNS)
+	if err != nil {
+		return fmt.Errorf("failed to get a netlink handle in the network namespace of the pod: %v", err)
+	}
+	defer handle.Close()
+
+	existing, err := handle.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Protocol: zebraRouteOriginator},
+		netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return fmt.Errorf("failed to list the routes of the pod: %v", err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, route := range routes {
+		err = handle.RouteReplace(&netlink.Route{Dst: route.dst, Gw: route.gw, Protocol: zebraRouteOriginator})
+		if err != nil {
+			return fmt.Errorf("failed to add the route to %s via %s: %v", route.dst, route.gw, err)
+		}
+		wanted[route.dst.String()] = true
+	}
+	for i := range existing {
+		if existing[i].Dst == nil || wanted[existing[i].Dst.String()] {
+			continue
+		}
+		if err = handle.RouteDel(&existing[i]); err != nil {
+			return fmt.Errorf("failed to delete the route to %s: %v", existing[i].Dst, err)
+		}
+		klog.V(1).Infof("Deleted the route to %s from pod %s/%s", existing[i].Dst, pod.Namespace, pod.Name)
+	}
+	return nil
+}
+
+func (prc *PodRoutesController) newPodEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			prc.RequestSync()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok := oldObj.(*v1core.Pod)
+			if !ok {
+				return
+			}
+			newPod, ok := newObj.(*v1core.Pod)
+			if !ok {
+				return
+			}
+			if oldPod.Annotations[podRoutesAnnotation] != newPod.Annotations[podRoutesAnnotation] ||
+				oldPod.Status.PodIP != newPod.Status.PodIP || oldPod.Status.Phase != newPod.Status.Phase {
+				prc.RequestSync()
+			}
+		},
+	}
+}
+
+// NewPodRoutesController returns new PodRoutesController object
+func NewPodRoutesController(clientset kubernetes.Interface, config *options.KubeRouterConfig,
+	podInformer cache.SharedIndexInformer) (*PodRoutesController, error) {
+	if config.RuntimeEndpoint == "" {
+		return nil, errors.New("the routes of the pods can only be programmed with --runtime-endpoint")
+	}
+	prc := PodRoutesController{
+		runtimeEndpoint: config.RuntimeEndpoint,
+		syncPeriod:      config.RoutesSyncPeriod,
+		podsWithRoutes:  make(map[types.UID]bool),
+	}
+	prc.syncRequestChan = make(chan struct{}, 1)
+
+	node, err := utils.GetNodeObject(clientset, config.HostnameOverride)
+	if err != nil {
+		return nil, err
+	}
+	prc.nodeName = node.Name
+
+	prc.podLister = podInformer.GetIndexer()
+	prc.PodEventHandler = prc.newPodEventHandler()
+
+	return &prc, nil
+}
@@ -0,0 +1,88 @@
+package routing
+
+import (
+	"testing"
+)
+
+func Test_parsePodRoutes(t *testing.T) {
+	testcases := []struct {
+		name       string
+		annotation string
+		expected   []string
+		expectErr  bool
+	}{
+		{
+			"single route",
+			"10.20.0.0/16 via 10.1.0.1",
+			[]string{"10.20.0.0/16 via 10.1.0.1"},
+			false,
+		},
+		{
+			"several routes with extra spaces",
+			" 10.20.0.0/16 via 10.1.0.1 ,192.168.0.0/24  via 10.1.0.254,",
+			[]string{"10.20.0.0/16 via 10.1.0.1", "192.168.0.0/24 via 10.1.0.254"},
+			false,
+		},
+		{
+			"IPv6 route",
+			"2001:db8:1::/48 via 2001:db8::1",
+			[]string{"2001:db8:1::/48 via 2001:db8::1"},
+			false,
+		},
+		{
+			"destination is normalized to its network",
+			"10.20.1.1/16 via 10.1.0.1",
+			[]string{"10.20.0.0/16 via 10.1.0.1"},
+			false,
+		},
+		{
+			"empty annotation",
+			"",
+			[]string{},
+			false,
+		},
+		{
+			"missing via",
+			"10.20.0.0/16 10.1.0.1",
+			nil,
+			true,
+		},
+		{
+			"invalid destination",
+			"10.20.0.0 via 10.1.0.1",
+			nil,
+			true,
+		},
+		{
+			"invalid gateway",
+			"10.20.0.0/16 via gateway",
+			nil,
+			true,
+		},
+		{
+			"mixed address families",
+			"10.20.0.0/16 via 2001:db8::1",
+			nil,
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			routes, err := parsePodRoutes(testcase.annotation)
+			if (err != nil) != testcase.expectErr {
+				t.Fatalf("expected error: %v, got: %v", testcase.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			actual := make([]string, 0, len(routes))
+			for _, route := range routes {
+				actual = append(actual, route.dst.String()+" via "+route.gw.String())
+			}
+			if !Equal(actual, testcase.expected) {
+				t.Errorf("expected routes: %v, got: %v", testcase.expected, actual)
+			}
+		})
+	}
+}
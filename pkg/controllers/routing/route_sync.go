@@ -34,6 +34,32 @@ func (rs *routeSyncer) delInjectedRoute(dst *net.IPNet) {
 	}
 }
 
+// injectedRoute returns a copy of the route to the given destination in the route map, nil when there is none
+func (rs *routeSyncer) injectedRoute(dst *net.IPNet) *netlink.Route {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	route, ok := rs.routeTableStateMap[dst.String()]
+	if !ok {
+		return nil
+	}
+	routeCopy := *route
+	return &routeCopy
+}
+
+// setInjectedRouteMTU sets the MTU of the route to the given destination in the route map, and returns whether it has
+// changed
+func (rs *routeSyncer) setInjectedRouteMTU(dst string, mtu int) bool {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	route, ok := rs.routeTableStateMap[dst]
+	if !ok || route.MTU == mtu {
+		return false
+	}
+	klog.V(3).Infof("Setting the MTU of the route for destination %s to %d", dst, mtu)
+	route.MTU = mtu
+	return true
+}
+
 // syncLocalRouteTable iterates over the local route state map and syncs all routes to the kernel's routing table
 func (rs *routeSyncer) syncLocalRouteTable() {
 	rs.mutex.Lock()
@@ -0,0 +1,192 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// staticRouteOriginator is the protocol of the routes of the StaticRoute resources, an unassigned one that tells them
+// apart from the routes kube-router injects for BGP and from the ones added by the administrators
+const staticRouteOriginator = 0x13
+
+// EnableStaticRouteResources makes the controller install the routes of the StaticRoute custom resources that select
+// the node. The informer watches the StaticRoutes, StaticRouteEventHandler is to be added to it.
+func (nrc *NetworkRoutingController) EnableStaticRouteResources(staticRouteInformer cache.SharedIndexInformer) {
+	nrc.staticRouteLister = staticRouteInformer.GetIndexer()
+	if nrc.bgpResourceSyncChan == nil {
+		nrc.bgpResourceSyncChan = make(chan struct{}, 1)
+	}
+	nrc.StaticRouteEventHandler = cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { nrc.requestBGPResourceSync() },
+		UpdateFunc: func(interface{}, interface{}) { nrc.requestBGPResourceSync() },
+		DeleteFunc: func(interface{}) { nrc.requestBGPResourceSync() },
+	}
+}
+
+// staticRouteSelectsNode returns true if all of the labels of the route's node selector are set on the node
+func staticRouteSelectsNode(staticRoute *v1alpha1.StaticRoute, node *v1core.Node) bool {
+	for key, value := range staticRoute.Spec.NodeSelector {
+		if nodeValue, ok := node.Labels[key]; !ok || nodeValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// staticRoutes returns the routes of the StaticRoute resources that select the node, by staticRouteKey. The routes of
+// invalid resources are left out and logged, as are the ones with the destination, table and metric of a route that
+// sorts before them by name.
+func staticRoutes(objs []interface{}, node *v1core.Node) map[string]*netlink.Route {
+	staticRoutes := make([]*v1alpha1.StaticRoute, 0, len(objs))
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		staticRoute := &v1alpha1.StaticRoute{}
+		if err := v1alpha1.FromUnstructured(u, staticRoute); err != nil {
+			klog.Errorf("Ignoring StaticRoute %s that could not be parsed: %v", u.GetName(), err)
+			continue
+		}
+		staticRoutes = append(staticRoutes, staticRoute)
+	}
+	sort.Slice(staticRoutes, func(i, j int) bool { return staticRoutes[i].Name < staticRoutes[j].Name })
+
+	routes := make(map[string]*netlink.Route)
+	names := make(map[string]string)
+	for _, staticRoute := range staticRoutes {
+		if !staticRouteSelectsNode(staticRoute, node) {
+			continue
+		}
+		route, err := staticRouteSpecRoute(&staticRoute.Spec)
+		if err != nil {
+			klog.Errorf("Ignoring invalid StaticRoute %s: %v", staticRoute.Name, err)
+			continue
+		}
+		key := staticRouteKey(route)
+		if name, ok := names[key]; ok {
+			klog.Errorf("Ignoring StaticRoute %s with the route of StaticRoute %s", staticRoute.Name, name)
+			continue
+		}
+		names[key] = staticRoute.Name
+		routes[key] = route
+	}
+	return routes
+}
+
+// staticRouteSpecRoute returns the route of the spec of a StaticRoute resource
+func staticRouteSpecRoute(spec *v1alpha1.StaticRouteSpec) (*netlink.Route, error) {
+	_, dst, err := net.ParseCIDR(spec.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q: %v", spec.Destination, err)
+	}
+	if spec.Table < 0 || spec.Metric < 0 {
+		return nil, errors.New("the table and the metric can't be negative")
+	}
+	route := &netlink.Route{
+		Dst:      dst,
+		Table:    spec.Table,
+		Priority: spec.Metric,
+		Protocol: staticRouteOriginator,
+	}
+	if route.Table == 0 {
+		route.Table = unix.RT_TABLE_MAIN
+	}
+
+	switch spec.Type {
+	case "", v1alpha1.StaticRouteTypeUnicast:
+		gateway := net.ParseIP(spec.Gateway)
+		if gateway == nil {
+			return nil, fmt.Errorf("invalid gateway %q of a unicast route", spec.Gateway)
+		}
+		if (gateway.To4() == nil) != (dst.IP.To4() == nil) {
+			return nil, fmt.Errorf("the gateway %s isn't of the address family of the destination %s", gateway, dst)
+		}
+		route.Type = unix.RTN_UNICAST
+		route.Gw = gateway
+	case v1alpha1.StaticRouteTypeBlackhole:
+		if spec.Gateway != "" {
+			return nil, errors.New("a blackhole route can't have a gateway")
+		}
+		route.Type = unix.RTN_BLACKHOLE
+	default:
+		return nil, fmt.Errorf("unknown type %q", spec.Type)
+	}
+	return route, nil
+}
+
+// staticRouteKey identifies a route by its table, destination and metric, as the kernel does
+func staticRouteKey(route *netlink.Route) string {
+	return fmt.Sprintf("%d/%s/%d", route.Table, staticRouteDst(route), route.Priority)
+}
+
+// staticRouteDst returns the destination of the route, as the default routes listed from netlink have none
+func staticRouteDst(route *netlink.Route) *net.IPNet {
+	if route.Dst != nil {
+		return route.Dst
+	}
+	return defaultRouteDst(route.Family == nl.FAMILY_V6)
+}
+
+// syncStaticRoutes installs the routes of the StaticRoute resources that select the node in all of the routing tables,
+// and removes the ones it installed before that aren't selected anymore or whose resource was deleted, including
+// while kube-router wasn't running
+func (nrc *NetworkRoutingController) syncStaticRoutes() {
+	obj, exists, err := nrc.nodeLister.GetByKey(nrc.nodeName)
+	if err != nil || !exists {
+		klog.Errorf("Failed to get node %s from the cache to select its StaticRoutes: %v", nrc.nodeName, err)
+		return
+	}
+	routes := staticRoutes(nrc.staticRouteLister.List(), obj.(*v1core.Node))
+
+	installed, err := netlink.RouteListFiltered(nl.FAMILY_ALL, &netlink.Route{
+		Table: unix.RT_TABLE_UNSPEC, Protocol: staticRouteOriginator,
+	}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		klog.Errorf("Failed to list the installed routes of the StaticRoutes: %v", err)
+		return
+	}
+	reconcileStaticRoutes(routes, installed, netlink.RouteReplace, netlink.RouteDel)
+}
+
+// reconcileStaticRoutes removes the installed routes that aren't among the given routes by staticRouteKey, then
+// replaces all of the given routes so that the ones changed from outside of kube-router are installed again
+func reconcileStaticRoutes(routes map[string]*netlink.Route, installed []netlink.Route,
+	replace func(*netlink.Route) error, del func(*netlink.Route) error) {
+	for i := range installed {
+		route := &installed[i]
+		if _, ok := routes[staticRouteKey(route)]; ok {
+			continue
+		}
+		klog.Infof("Removing the route to %s of table %d of a StaticRoute", staticRouteDst(route), route.Table)
+		if err := del(route); err != nil {
+			klog.Errorf("Failed to remove the route to %s of table %d of a StaticRoute: %v", staticRouteDst(route),
+				route.Table, err)
+		}
+	}
+
+	keys := make([]string, 0, len(routes))
+	for key := range routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		route := routes[key]
+		klog.V(2).Infof("Syncing the route %s of a StaticRoute", route)
+		if err := replace(route); err != nil {
+			klog.Errorf("Failed to install the route to %s of table %d of a StaticRoute: %v", route.Dst, route.Table,
+				err)
+		}
+	}
+}
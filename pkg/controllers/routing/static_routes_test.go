@@ -0,0 +1,123 @@
+package routing
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func staticRouteObjects(t *testing.T, staticRoutes ...*v1alpha1.StaticRoute) []interface{} {
+	objs := make([]interface{}, 0, len(staticRoutes))
+	for _, staticRoute := range staticRoutes {
+		obj, err := v1alpha1.ToUnstructured(staticRoute)
+		if err != nil {
+			t.Fatalf("failed to convert StaticRoute: %v", err)
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+func Test_staticRoutes(t *testing.T) {
+	objs := staticRouteObjects(t,
+		&v1alpha1.StaticRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "on-prem"},
+			Spec:       v1alpha1.StaticRouteSpec{Destination: "192.168.0.0/16", Gateway: "10.0.0.254", Metric: 10},
+		},
+		&v1alpha1.StaticRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "unallocated-services"},
+			Spec: v1alpha1.StaticRouteSpec{
+				Destination: "10.96.128.0/17",
+				Type:        v1alpha1.StaticRouteTypeBlackhole,
+				Table:       100,
+			},
+		},
+		&v1alpha1.StaticRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "rack-b"},
+			Spec: v1alpha1.StaticRouteSpec{
+				Destination:  "172.16.0.0/12",
+				Gateway:      "10.0.0.253",
+				NodeSelector: map[string]string{"rack": "b"},
+			},
+		},
+		&v1alpha1.StaticRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup-gateway"},
+			Spec:       v1alpha1.StaticRouteSpec{Destination: "192.168.0.0/16", Gateway: "10.0.0.253", Metric: 10},
+		},
+		&v1alpha1.StaticRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "mixed-families"},
+			Spec:       v1alpha1.StaticRouteSpec{Destination: "2001:db8::/32", Gateway: "10.0.0.254"},
+		},
+		&v1alpha1.StaticRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "blackhole-gateway"},
+			Spec: v1alpha1.StaticRouteSpec{
+				Destination: "10.97.0.0/16",
+				Type:        v1alpha1.StaticRouteTypeBlackhole,
+				Gateway:     "10.0.0.254",
+			},
+		},
+	)
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"rack": "a"}}}
+
+	routes := staticRoutes(objs, node)
+	keys := make([]string, 0, len(routes))
+	for key := range routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"100/10.96.128.0/17/0", "254/192.168.0.0/16/10"}, keys,
+		"expected the valid routes that select the node")
+
+	onPrem := routes["254/192.168.0.0/16/10"]
+	assert.Equal(t, "10.0.0.253", onPrem.Gw.String(),
+		"expected the route of the StaticRoute that sorts first by name")
+	assert.Equal(t, unix.RTN_UNICAST, onPrem.Type)
+	assert.Equal(t, 10, onPrem.Priority)
+	assert.Equal(t, staticRouteOriginator, int(onPrem.Protocol))
+
+	blackhole := routes["100/10.96.128.0/17/0"]
+	assert.Equal(t, unix.RTN_BLACKHOLE, blackhole.Type)
+	assert.Equal(t, 100, blackhole.Table)
+	assert.Nil(t, blackhole.Gw)
+
+	node.Labels["rack"] = "b"
+	assert.Contains(t, staticRoutes(objs, node), "254/172.16.0.0/12/0", "expected the route of the selected rack")
+}
+
+func Test_reconcileStaticRoutes(t *testing.T) {
+	_, kept, _ := net.ParseCIDR("192.168.0.0/16")
+	_, stale, _ := net.ParseCIDR("10.96.128.0/17")
+	routes := map[string]*netlink.Route{
+		"254/192.168.0.0/16/0": {Dst: kept, Gw: net.ParseIP("10.0.0.254"), Table: unix.RT_TABLE_MAIN},
+		"254/0.0.0.0/0/0":      {Table: unix.RT_TABLE_MAIN, Type: unix.RTN_BLACKHOLE},
+	}
+	installed := []netlink.Route{
+		{Dst: kept, Gw: net.ParseIP("10.0.0.254"), Table: unix.RT_TABLE_MAIN},
+		{Dst: stale, Table: 100, Type: unix.RTN_BLACKHOLE},
+		{Family: nl.FAMILY_V4, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_BLACKHOLE},
+		{Family: nl.FAMILY_V6, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_BLACKHOLE},
+	}
+
+	var replaced, deleted []string
+	reconcileStaticRoutes(routes, installed,
+		func(route *netlink.Route) error {
+			replaced = append(replaced, staticRouteKey(route))
+			return nil
+		},
+		func(route *netlink.Route) error {
+			deleted = append(deleted, staticRouteKey(route))
+			return nil
+		})
+	assert.Equal(t, []string{"100/10.96.128.0/17/0", "254/::/0/0"}, deleted,
+		"expected the routes that aren't selected anymore to be removed")
+	assert.Equal(t, []string{"254/0.0.0.0/0/0", "254/192.168.0.0/16/0"}, replaced,
+		"expected all of the selected routes to be installed again")
+}
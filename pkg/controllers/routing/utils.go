@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	gobgpapi "github.com/osrg/gobgp/v3/api"
 	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
@@ -70,6 +71,22 @@ func stringSliceToUInt32(s []string) ([]uint32, error) {
 	return ints, nil
 }
 
+func stringSliceToDurations(s []string) ([]time.Duration, error) {
+	durations := make([]time.Duration, 0)
+	for _, durationString := range s {
+		if durationString == "" {
+			durations = append(durations, 0)
+			continue
+		}
+		newDuration, err := time.ParseDuration(durationString)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse \"%s\" as a duration", durationString)
+		}
+		durations = append(durations, newDuration)
+	}
+	return durations, nil
+}
+
 func stringSliceB64Decode(s []string) ([]string, error) {
 	ss := make([]string, 0)
 	for _, b64String := range s {
@@ -149,26 +166,33 @@ func generateTunnelName(nodeIP string) string {
 // gobgp (internal/pkg/table/policy.go:ParseCommunity()). If it is not able to parse the community information it
 // returns an error.
 func validateCommunity(arg string) error {
-	_, err := strconv.ParseUint(arg, 10, bgpCommunityMaxSize)
+	_, err := parseCommunity(arg)
+	return err
+}
+
+// parseCommunity parses a BGP community, given as a 32-bit integer, as 2 16-bit integers separated by a colon or as
+// the name of a well known community, into its 32-bit value
+func parseCommunity(arg string) (uint32, error) {
+	i, err := strconv.ParseUint(arg, 10, bgpCommunityMaxSize)
 	if err == nil {
-		return nil
+		return uint32(i), nil
 	}
 
 	_regexpCommunity := regexp.MustCompile(`(\d+):(\d+)`)
 	elems := _regexpCommunity.FindStringSubmatch(arg)
 	if len(elems) == 3 {
-		if _, err := strconv.ParseUint(elems[1], 10, bgpCommunityMaxPartSize); err == nil {
-			if _, err = strconv.ParseUint(elems[2], 10, bgpCommunityMaxPartSize); err == nil {
-				return nil
+		if high, err := strconv.ParseUint(elems[1], 10, bgpCommunityMaxPartSize); err == nil {
+			if low, err := strconv.ParseUint(elems[2], 10, bgpCommunityMaxPartSize); err == nil {
+				return uint32(high<<bgpCommunityMaxPartSize | low), nil
 			}
 		}
 	}
-	for _, v := range bgp.WellKnownCommunityNameMap {
-		if arg == v {
-			return nil
+	for community, name := range bgp.WellKnownCommunityNameMap {
+		if arg == name {
+			return uint32(community), nil
 		}
 	}
-	return fmt.Errorf("failed to parse %s as community", arg)
+	return 0, fmt.Errorf("failed to parse %s as community", arg)
 }
 
 // parseBGPNextHop takes in a GoBGP Path and parses out the destination's next hop from its attributes. If it
@@ -179,7 +203,6 @@ func parseBGPNextHop(path *gobgpapi.Path) (net.IP, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal path attribute: %s", err)
 		}
-		//nolint:gocritic // this is the correct way to use a switch statement regardless of how many case statements
 		switch t := unmarshalNew.(type) {
 		case *gobgpapi.NextHopAttribute:
 			nextHop := net.ParseIP(t.NextHop).To4()
@@ -189,6 +212,25 @@ func parseBGPNextHop(path *gobgpapi.Path) (net.IP, error) {
 				}
 			}
 			return nextHop, nil
+		case *gobgpapi.MpReachNLRIAttribute:
+			// the next hop of the routes of the other address families than IPv4, such as IPv6 unicast
+			if len(t.NextHops) == 0 {
+				return nil, fmt.Errorf("no next hop in the MP_REACH_NLRI attribute of path: %s", path)
+			}
+			nextHop := net.ParseIP(t.NextHops[0])
+			if nextHop == nil {
+				return nil, fmt.Errorf("invalid nextHop address: %s", t.NextHops[0])
+			}
+			// unnumbered peers may only have a link-local next hop, following an unspecified global one
+			if nextHop.IsUnspecified() && len(t.NextHops) > 1 {
+				if nextHop = net.ParseIP(t.NextHops[1]); nextHop == nil {
+					return nil, fmt.Errorf("invalid nextHop address: %s", t.NextHops[1])
+				}
+			}
+			if nextHop4 := nextHop.To4(); nextHop4 != nil {
+				nextHop = nextHop4
+			}
+			return nextHop, nil
 		}
 	}
 	return nil, fmt.Errorf("could not parse next hop received from GoBGP for path: %s", path)
@@ -232,3 +274,11 @@ func deleteRoutesByDestination(destinationSubnet *net.IPNet) error {
 	}
 	return nil
 }
+
+// defaultRouteDst returns the destination of the default route of the address family
+func defaultRouteDst(ipv6 bool) *net.IPNet {
+	if ipv6 {
+		return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, net.IPv6len*8)}
+	}
+	return &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, net.IPv4len*8)}
+}
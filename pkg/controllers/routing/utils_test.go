@@ -4,7 +4,9 @@ import (
 	"net"
 	"testing"
 
+	gobgpapi "github.com/osrg/gobgp/v3/api"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 func Test_stringSliceToIPs(t *testing.T) {
@@ -82,3 +84,59 @@ func Test_validateCommunity(t *testing.T) {
 		assert.Error(t, validateCommunity("community"))
 	})
 }
+
+func Test_parseCommunity(t *testing.T) {
+	t.Run("BGP community specified as a 32-bit integer should be parsed as is", func(t *testing.T) {
+		community, err := parseCommunity("4294967041")
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(4294967041), community)
+	})
+	t.Run("BGP community specified as 2 16-bit integers should be parsed as high and low order bits", func(t *testing.T) {
+		community, err := parseCommunity("65000:100")
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(65000<<16|100), community)
+	})
+	t.Run("Well known BGP communities passed as a string should be parsed as their value", func(t *testing.T) {
+		community, err := parseCommunity("no-export")
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(0xFFFFFF01), community)
+	})
+	t.Run("BGP community that is not a number should fail parsing", func(t *testing.T) {
+		_, err := parseCommunity("community")
+		assert.Error(t, err)
+	})
+}
+
+func Test_parseBGPNextHop(t *testing.T) {
+	t.Run("The next hop of IPv4 routes is parsed from the next hop attribute", func(t *testing.T) {
+		attr, _ := anypb.New(&gobgpapi.NextHopAttribute{NextHop: "10.0.0.1"})
+		nextHop, err := parseBGPNextHop(&gobgpapi.Path{Pattrs: []*anypb.Any{attr}})
+		assert.Nil(t, err)
+		assert.Equal(t, net.ParseIP("10.0.0.1").To4(), nextHop)
+	})
+	t.Run("The next hop of IPv6 routes is parsed from the MP_REACH_NLRI attribute", func(t *testing.T) {
+		attr, _ := anypb.New(&gobgpapi.MpReachNLRIAttribute{
+			Family:   &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP6, Safi: gobgpapi.Family_SAFI_UNICAST},
+			NextHops: []string{"2001:db8::1", "fe80::1"},
+		})
+		nextHop, err := parseBGPNextHop(&gobgpapi.Path{Pattrs: []*anypb.Any{attr}})
+		assert.Nil(t, err)
+		assert.Equal(t, net.ParseIP("2001:db8::1"), nextHop)
+	})
+	t.Run("The link-local next hop of unnumbered peers follows an unspecified global one", func(t *testing.T) {
+		attr, _ := anypb.New(&gobgpapi.MpReachNLRIAttribute{
+			Family:   &gobgpapi.Family{Afi: gobgpapi.Family_AFI_IP, Safi: gobgpapi.Family_SAFI_UNICAST},
+			NextHops: []string{"::", "fe80::1"},
+		})
+		nextHop, err := parseBGPNextHop(&gobgpapi.Path{Pattrs: []*anypb.Any{attr}})
+		assert.Nil(t, err)
+		assert.Equal(t, net.ParseIP("fe80::1"), nextHop)
+	})
+	t.Run("A path without a next hop should fail parsing", func(t *testing.T) {
+		attr, _ := anypb.New(&gobgpapi.MpReachNLRIAttribute{})
+		_, err := parseBGPNextHop(&gobgpapi.Path{Pattrs: []*anypb.Any{attr}})
+		assert.Error(t, err)
+		_, err = parseBGPNextHop(&gobgpapi.Path{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,274 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudnativelabs/kube-router/pkg/utils"
+	"github.com/vishvananda/netlink"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	wireguardIfName = "kube-wireguard"
+	// wireguardPublicKeyAnnotation publishes the WireGuard public key of a node to the other nodes
+	wireguardPublicKeyAnnotation = "kube-router.io/wireguard-public-key"
+)
+
+// wireguardPeer is a node the pod traffic is tunneled to over WireGuard, along with the pod CIDRs routed to it
+type wireguardPeer struct {
+	publicKey  string
+	allowedIPs map[string]bool
+}
+
+// wireguardPublicKey returns the public key of the WireGuard private key held in the given file, generating the
+// private key first when the file doesn't exist
+func wireguardPublicKey(keyFile string) (string, error) {
+	if _, err := os.Stat(keyFile); errors.Is(err, os.ErrNotExist) {
+		klog.Infof("Generating the WireGuard private key of the node in %s", keyFile)
+		key, err := exec.Command("wg", "genkey").Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate a WireGuard private key: %v", err)
+		}
+		if err = os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+			return "", fmt.Errorf("failed to create the directory of the WireGuard private key: %v", err)
+		}
+		if err = os.WriteFile(keyFile, key, 0o600); err != nil {
+			return "", fmt.Errorf("failed to write the WireGuard private key: %v", err)
+		}
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the WireGuard private key: %v", err)
+	}
+	cmd := exec.Command("wg", "pubkey")
+	cmd.Stdin = strings.NewReader(string(key))
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to derive the WireGuard public key from %s: %v", keyFile, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// publishWireGuardPublicKey annotates the node with its WireGuard public key, so that the other nodes set it up as a
+// peer of their WireGuard interface
+func (nrc *NetworkRoutingController) publishWireGuardPublicKey(node *v1core.Node) error {
+	if node.Annotations[wireguardPublicKeyAnnotation] == nrc.wireguardPublicKey {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{wireguardPublicKeyAnnotation: nrc.wireguardPublicKey},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = nrc.clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate node %s with its WireGuard public key: %v", node.Name, err)
+	}
+	return nil
+}
+
+// setupWireGuard creates the WireGuard interface the pod traffic to the other nodes is tunneled through, listening on
+// --wireguard-port with the private key of the node
+func (nrc *NetworkRoutingController) setupWireGuard() error {
+	link, err := netlink.LinkByName(wireguardIfName)
+	if err != nil {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Name = wireguardIfName
+		if err = netlink.LinkAdd(&netlink.Wireguard{LinkAttrs: attrs}); err != nil {
+			return fmt.Errorf("failed to create the WireGuard interface %s: %v", wireguardIfName, err)
+		}
+		if link, err = netlink.LinkByName(wireguardIfName); err != nil {
+			return fmt.Errorf("failed to get the WireGuard interface %s: %v", wireguardIfName, err)
+		}
+	}
+
+	//nolint:gosec // this exec should be safe from command injection given the parameter's context
+	out, err := exec.Command("wg", "set", wireguardIfName, "listen-port", strconv.Itoa(int(nrc.wireguardPort)),
+		"private-key", nrc.wireguardPrivateKeyFile).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to configure the WireGuard interface %s: %v, output: %s", wireguardIfName, err,
+			string(out))
+	}
+
+	// the overlay packets have to fit the MTU of the interface they leave through once encapsulated
+	if mtu, err := utils.GetMTUFromNodeIP(nrc.overlayIP); err != nil {
+		klog.Errorf("Failed to find the MTU of the overlay address %s to set the MTU of the WireGuard interface: %v",
+			nrc.overlayIP, err)
+	} else if err = netlink.LinkSetMTU(link,
+		mtu-encapsulationOverhead(nrc.overlayEncapsulation, nrc.overlayIP)); err != nil {
+		klog.Errorf("Failed to set the MTU of the WireGuard interface: %v", err)
+	}
+
+	if err = netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring the WireGuard interface %s up: %v", wireguardIfName, err)
+	}
+	return nil
+}
+
+// setupWireGuardPeer routes the given destination to the node of the given next hop through the WireGuard interface,
+// setting the node up as a peer of the interface with its published public key and the destination as one of its
+// allowed IPs
+func (nrc *NetworkRoutingController) setupWireGuardPeer(nextHop net.IP, dst *net.IPNet) (netlink.Link, error) {
+	node, err := nrc.nodeByBGPAddress(nextHop)
+	if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s: %v", nextHop, err)
+	}
+	publicKey, ok := node.Annotations[wireguardPublicKeyAnnotation]
+	if !ok {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s as the node has no "+
+			"WireGuard public key yet", nextHop)
+	}
+	link, err := netlink.LinkByName(wireguardIfName)
+	if err != nil {
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s, failed to get the "+
+			"WireGuard interface: %v", nextHop, err)
+	}
+
+	nrc.wireguardMutex.Lock()
+	defer nrc.wireguardMutex.Unlock()
+	peer, ok := nrc.wireguardPeers[nextHop.String()]
+	if !ok {
+		// the traffic of the pods to the node itself is tunneled as well
+		peer = &wireguardPeer{allowedIPs: map[string]bool{
+			fmt.Sprintf("%s/%d", nextHop, hostPrefixLen(nextHop.To4() == nil)): true,
+		}}
+	}
+	if ok && peer.allowedIPs[dst.String()] && peer.publicKey == publicKey {
+		return link, nil
+	}
+	if ok && peer.publicKey != publicKey {
+		nrc.removeWireGuardPeer(peer)
+	}
+	peer.publicKey = publicKey
+	peer.allowedIPs[dst.String()] = true
+	//nolint:gosec // this exec should be safe from command injection given the parameter's context
+	out, err := exec.Command("wg", wireguardPeerArgs(peer, nextHop, nrc.wireguardPort)...).CombinedOutput()
+	if err != nil {
+		delete(peer.allowedIPs, dst.String())
+		return nil, fmt.Errorf("route not injected for the route advertised by the node %s, failed to set up its "+
+			"WireGuard peer: %v, output: %s", nextHop, err, string(out))
+	}
+	nrc.wireguardPeers[nextHop.String()] = peer
+
+	if err = addOverlayNodeRoute(nextHop, wireguardIfName); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// cleanupWireGuardPeer removes the given destination from the allowed IPs of the WireGuard peer of the given next hop,
+// and removes the peer once no other destination is routed to it
+func (nrc *NetworkRoutingController) cleanupWireGuardPeer(nextHop net.IP, dst *net.IPNet) {
+	nrc.wireguardMutex.Lock()
+	defer nrc.wireguardMutex.Unlock()
+	peer, ok := nrc.wireguardPeers[nextHop.String()]
+	if !ok || !peer.allowedIPs[dst.String()] {
+		return
+	}
+	delete(peer.allowedIPs, dst.String())
+	if len(peer.allowedIPs) > 1 {
+		//nolint:gosec // this exec should be safe from command injection given the parameter's context
+		if out, err := exec.Command("wg", wireguardPeerArgs(peer, nextHop, nrc.wireguardPort)...).
+			CombinedOutput(); err != nil {
+			klog.Errorf("Failed to remove %s from the allowed IPs of the WireGuard peer of node %s: %v, output: %s",
+				dst, nextHop, err, string(out))
+		}
+		return
+	}
+
+	klog.V(1).Infof("Removing the WireGuard peer of node %s as no routes go through it any longer", nextHop)
+	nrc.removeWireGuardPeer(peer)
+	delete(nrc.wireguardPeers, nextHop.String())
+	deleteOverlayNodeRoute(nextHop, wireguardIfName)
+}
+
+// removeWireGuardPeer removes a peer from the WireGuard interface, the caller holds the WireGuard mutex
+func (nrc *NetworkRoutingController) removeWireGuardPeer(peer *wireguardPeer) {
+	//nolint:gosec // this exec should be safe from command injection given the parameter's context
+	out, err := exec.Command("wg", "set", wireguardIfName, "peer", peer.publicKey, "remove").CombinedOutput()
+	if err != nil {
+		klog.Errorf("Failed to remove WireGuard peer %s: %v, output: %s", peer.publicKey, err, string(out))
+	}
+}
+
+// updateWireGuardPeer sets the WireGuard peer of a node up again with the new public key the node has published
+func (nrc *NetworkRoutingController) updateWireGuardPeer(node *v1core.Node) {
+	nextHop, err := nodeBGPAddress(node)
+	if err != nil {
+		klog.Errorf("Failed to find the BGP address of node %s to update its WireGuard peer: %v", node.Name, err)
+		return
+	}
+	publicKey, ok := node.Annotations[wireguardPublicKeyAnnotation]
+
+	nrc.wireguardMutex.Lock()
+	defer nrc.wireguardMutex.Unlock()
+	peer, exists := nrc.wireguardPeers[nextHop.String()]
+	if !exists || !ok || peer.publicKey == publicKey {
+		return
+	}
+	klog.Infof("The WireGuard public key of node %s has changed, updating its peer", node.Name)
+	nrc.removeWireGuardPeer(peer)
+	peer.publicKey = publicKey
+	//nolint:gosec // this exec should be safe from command injection given the parameter's context
+	if out, err := exec.Command("wg", wireguardPeerArgs(peer, nextHop, nrc.wireguardPort)...).
+		CombinedOutput(); err != nil {
+		klog.Errorf("Failed to set up the WireGuard peer of node %s with its new public key: %v, output: %s",
+			node.Name, err, string(out))
+	}
+}
+
+// wireguardPeerArgs returns the arguments of the wg command setting up the given peer, reached on the given port of
+// the given endpoint
+func wireguardPeerArgs(peer *wireguardPeer, endpoint net.IP, port uint16) []string {
+	allowedIPs := make([]string, 0, len(peer.allowedIPs))
+	for allowedIP := range peer.allowedIPs {
+		allowedIPs = append(allowedIPs, allowedIP)
+	}
+	sort.Strings(allowedIPs)
+	return []string{"set", wireguardIfName, "peer", peer.publicKey,
+		"endpoint", net.JoinHostPort(endpoint.String(), strconv.Itoa(int(port))),
+		"allowed-ips", strings.Join(allowedIPs, ",")}
+}
+
+// nodeByBGPAddress returns the node whose BGP address is the given IP
+func (nrc *NetworkRoutingController) nodeByBGPAddress(ip net.IP) (*v1core.Node, error) {
+	for _, obj := range nrc.nodeLister.List() {
+		node := obj.(*v1core.Node)
+		if address, err := nodeBGPAddress(node); err == nil && address.Equal(ip) {
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("no node has the BGP address %s", ip)
+}
+
+// wireguardPublicKeyChanged returns whether the WireGuard public key published by a node has changed
+func wireguardPublicKeyChanged(oldNode, newNode *v1core.Node) bool {
+	return oldNode.Annotations[wireguardPublicKeyAnnotation] != newNode.Annotations[wireguardPublicKeyAnnotation]
+}
+
+// deleteWireGuard removes the WireGuard interface along with its peers and the routes through it
+func deleteWireGuard() {
+	link, err := netlink.LinkByName(wireguardIfName)
+	if err != nil {
+		return
+	}
+	if err = netlink.LinkDel(link); err != nil {
+		klog.Errorf("Failed to delete the WireGuard interface %s: %v", wireguardIfName, err)
+	}
+}
@@ -0,0 +1,86 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_wireguardPeerArgs(t *testing.T) {
+	peer := &wireguardPeer{
+		publicKey:  "key",
+		allowedIPs: map[string]bool{"10.0.0.2/32": true, "10.1.2.0/24": true, "10.1.1.0/24": true},
+	}
+	expected := []string{"set", wireguardIfName, "peer", "key", "endpoint", "10.0.0.2:51820", "allowed-ips",
+		"10.0.0.2/32,10.1.1.0/24,10.1.2.0/24"}
+	if args := wireguardPeerArgs(peer, net.ParseIP("10.0.0.2"), 51820); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v, got %v", expected, args)
+	}
+
+	peer = &wireguardPeer{publicKey: "key", allowedIPs: map[string]bool{"2001:db8::2/128": true}}
+	expected = []string{"set", wireguardIfName, "peer", "key", "endpoint", "[2001:db8::2]:51820", "allowed-ips",
+		"2001:db8::2/128"}
+	if args := wireguardPeerArgs(peer, net.ParseIP("2001:db8::2"), 51820); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected the IPv6 endpoint in brackets %v, got %v", expected, args)
+	}
+}
+
+func Test_nodeByBGPAddress(t *testing.T) {
+	nodeLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range []*v1core.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: v1core.NodeStatus{
+				Addresses: []v1core.NodeAddress{{Type: v1core.NodeInternalIP, Address: "10.0.0.1"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2",
+				Annotations: map[string]string{"kube-router.io/bgp-address": "192.168.0.2"}},
+			Status: v1core.NodeStatus{
+				Addresses: []v1core.NodeAddress{{Type: v1core.NodeInternalIP, Address: "10.0.0.2"}},
+			},
+		},
+	} {
+		if err := nodeLister.Add(node); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+	}
+	nrc := &NetworkRoutingController{nodeLister: nodeLister}
+
+	if node, err := nrc.nodeByBGPAddress(net.ParseIP("10.0.0.1")); err != nil || node.Name != "node-1" {
+		t.Errorf("expected node-1 for its node IP, got %v: %v", node, err)
+	}
+	if node, err := nrc.nodeByBGPAddress(net.ParseIP("192.168.0.2")); err != nil || node.Name != "node-2" {
+		t.Errorf("expected node-2 for its published BGP address, got %v: %v", node, err)
+	}
+	if _, err := nrc.nodeByBGPAddress(net.ParseIP("10.0.0.2")); err == nil {
+		t.Error("expected an error for the node IP of a node with another BGP address")
+	}
+}
+
+func Test_publishWireGuardPublicKey(t *testing.T) {
+	node := &v1core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	clientset := fake.NewSimpleClientset(node)
+	nrc := &NetworkRoutingController{clientset: clientset, wireguardPublicKey: "key"}
+
+	if err := nrc.publishWireGuardPublicKey(node); err != nil {
+		t.Fatalf("failed to publish the WireGuard public key: %v", err)
+	}
+	published, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+	if key := published.Annotations[wireguardPublicKeyAnnotation]; key != "key" {
+		t.Errorf("expected the public key in the annotation of the node, got %q", key)
+	}
+	if !wireguardPublicKeyChanged(node, published) || wireguardPublicKeyChanged(published, published) {
+		t.Error("expected the published public key to be a change of the node")
+	}
+}
@@ -88,6 +88,96 @@ var (
 		Name:      "service_bps_out",
 		Help:      "Outgoing bytes per second",
 	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port"})
+	// ServiceEndpointActiveConn Active connections of the endpoint of the service
+	ServiceEndpointActiveConn = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_active_connections",
+		Help:      "Active connections of the endpoint of the service",
+	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port", "endpoint", "pod"})
+	// ServiceEndpointInactiveConn Inactive connections of the endpoint of the service
+	ServiceEndpointInactiveConn = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_inactive_connections",
+		Help:      "Inactive connections of the endpoint of the service",
+	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port", "endpoint", "pod"})
+	// ServiceEndpointTotalConn Total connections made to the endpoint of the service
+	ServiceEndpointTotalConn = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_total_connections",
+		Help:      "Total connections made to the endpoint of the service",
+	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port", "endpoint", "pod"})
+	// ServiceEndpointPacketsIn Total incoming packets of the endpoint of the service
+	ServiceEndpointPacketsIn = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_packets_in",
+		Help:      "Total incoming packets of the endpoint of the service",
+	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port", "endpoint", "pod"})
+	// ServiceEndpointPacketsOut Total outgoing packets of the endpoint of the service
+	ServiceEndpointPacketsOut = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_packets_out",
+		Help:      "Total outgoing packets of the endpoint of the service",
+	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port", "endpoint", "pod"})
+	// ServiceEndpointBytesIn Total incoming bytes of the endpoint of the service
+	ServiceEndpointBytesIn = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_bytes_in",
+		Help:      "Total incoming bytes of the endpoint of the service",
+	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port", "endpoint", "pod"})
+	// ServiceEndpointBytesOut Total outgoing bytes of the endpoint of the service
+	ServiceEndpointBytesOut = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_endpoint_bytes_out",
+		Help:      "Total outgoing bytes of the endpoint of the service",
+	}, []string{"svc_namespace", "service_name", "service_vip", "protocol", "port", "endpoint", "pod"})
+	// ServiceConntrackFlows Conntrack flows of the service
+	ServiceConntrackFlows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_conntrack_flows",
+		Help:      "Conntrack flows of the service",
+	}, []string{"svc_namespace", "service_name"})
+	// ServiceConntrackLongLivedFlows Conntrack flows of the service older than the long lived age
+	ServiceConntrackLongLivedFlows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_conntrack_long_lived_flows",
+		Help:      "Conntrack flows of the service older than the long lived age",
+	}, []string{"svc_namespace", "service_name"})
+	// ServiceConntrackLongLivedBytes Bytes carried by the long lived conntrack flows of the service
+	ServiceConntrackLongLivedBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_conntrack_long_lived_bytes",
+		Help:      "Bytes carried by the long lived conntrack flows of the service",
+	}, []string{"svc_namespace", "service_name"})
+	// ServiceConntrackOldestFlowAge Age of the oldest conntrack flow of the service
+	ServiceConntrackOldestFlowAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_conntrack_oldest_flow_age_seconds",
+		Help:      "Age of the oldest conntrack flow of the service",
+	}, []string{"svc_namespace", "service_name"})
+	// PodConntrackFlows Conntrack flows of the pod
+	PodConntrackFlows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pod_conntrack_flows",
+		Help:      "Conntrack flows of the pod",
+	}, []string{"namespace", "pod"})
+	// PodConntrackLongLivedFlows Conntrack flows of the pod older than the long lived age
+	PodConntrackLongLivedFlows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pod_conntrack_long_lived_flows",
+		Help:      "Conntrack flows of the pod older than the long lived age",
+	}, []string{"namespace", "pod"})
+	// PodConntrackLongLivedBytes Bytes carried by the long lived conntrack flows of the pod
+	PodConntrackLongLivedBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pod_conntrack_long_lived_bytes",
+		Help:      "Bytes carried by the long lived conntrack flows of the pod",
+	}, []string{"namespace", "pod"})
+	// PodConntrackOldestFlowAge Age of the oldest conntrack flow of the pod
+	PodConntrackOldestFlowAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pod_conntrack_oldest_flow_age_seconds",
+		Help:      "Age of the oldest conntrack flow of the pod",
+	}, []string{"namespace", "pod"})
 	// ControllerIpvsServices Number of ipvs services in the instance
 	ControllerIpvsServices = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: namespace,
@@ -118,6 +208,37 @@ var (
 		Name:      "controller_bgp_peers",
 		Help:      "BGP peers in the runtime configuration",
 	})
+	// ControllerBGPPeerSessionState State of the BGP session with the peer
+	ControllerBGPPeerSessionState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_session_state",
+		Help: "State of the BGP session with the peer as in BGP4-MIB: 1 idle, 2 connect, 3 active, 4 opensent, " +
+			"5 openconfirm, 6 established",
+	}, []string{"peer_ip"})
+	// ControllerBGPPeerUptime Time the BGP session with the peer has been established for
+	ControllerBGPPeerUptime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_uptime_seconds",
+		Help:      "Time the BGP session with the peer has been established for",
+	}, []string{"peer_ip"})
+	// ControllerBGPPeerPrefixesReceived Prefixes received from the BGP peer
+	ControllerBGPPeerPrefixesReceived = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_prefixes_received",
+		Help:      "Prefixes received from the BGP peer",
+	}, []string{"peer_ip"})
+	// ControllerBGPPeerPrefixesAdvertised Prefixes advertised to the BGP peer
+	ControllerBGPPeerPrefixesAdvertised = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_prefixes_advertised",
+		Help:      "Prefixes advertised to the BGP peer",
+	}, []string{"peer_ip"})
+	// ControllerBGPPeerFlaps Number of times the BGP session with the peer went down after being established
+	ControllerBGPPeerFlaps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_bgp_peer_flaps",
+		Help:      "Number of times the BGP session with the peer went down after being established",
+	}, []string{"peer_ip"})
 	// ControllerBGPInternalPeersSyncTime Time it took to sync internal bgp peers
 	ControllerBGPInternalPeersSyncTime = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: namespace,
@@ -145,6 +266,72 @@ var (
 		Name:      "controller_ipvs_metrics_export_time",
 		Help:      "Time it took to export metrics",
 	})
+	// ControllerCNIConfDrift Number of times the CNI conf file was modified externally and repaired
+	ControllerCNIConfDrift = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_cni_conf_drift",
+		Help:      "Number of times the CNI conf file was modified externally and repaired",
+	})
+	// ControllerHairpinModePods Pods that have hairpin_mode enabled on their kube-bridge port
+	ControllerHairpinModePods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_hairpin_mode_pods",
+		Help:      "Pods that have hairpin_mode enabled on their kube-bridge port",
+	}, []string{"namespace", "pod", "interface"})
+	// ControllerOverlayMTU MTU of the overlay routes to the node
+	ControllerOverlayMTU = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_overlay_mtu",
+		Help:      "MTU of the overlay routes to the node",
+	}, []string{"node_ip"})
+	// ControllerOverlayPMTUBlackhole Whether full-size packets to the node are dropped without an ICMP error
+	ControllerOverlayPMTUBlackhole = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "controller_overlay_pmtu_blackhole",
+		Help:      "Whether full-size packets to the node are dropped along the path without an ICMP error",
+	}, []string{"node_ip"})
+	// ControllerSysctlDrift Number of times a managed sysctl was found with an unexpected value
+	ControllerSysctlDrift = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "controller_sysctl_drift",
+		Help:      "Number of times a managed sysctl was found with an unexpected value and reset",
+	}, []string{"sysctl"})
+	// NetpolPodAcceptedPackets Packets of new flows from/to the pod allowed by the network policies
+	NetpolPodAcceptedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "netpol_pod_accepted_packets",
+		Help:      "Packets of new flows from/to the pod allowed by the network policies",
+	}, []string{"namespace", "pod"})
+	// NetpolPodAcceptedBytes Bytes of new flows from/to the pod allowed by the network policies
+	NetpolPodAcceptedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "netpol_pod_accepted_bytes",
+		Help:      "Bytes of new flows from/to the pod allowed by the network policies",
+	}, []string{"namespace", "pod"})
+	// NetpolPodDroppedPackets Packets from/to the pod dropped by the network policies
+	NetpolPodDroppedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "netpol_pod_dropped_packets",
+		Help:      "Packets from/to the pod dropped by the network policies",
+	}, []string{"namespace", "pod"})
+	// NetpolPodDroppedBytes Bytes from/to the pod dropped by the network policies
+	NetpolPodDroppedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "netpol_pod_dropped_bytes",
+		Help:      "Bytes from/to the pod dropped by the network policies",
+	}, []string{"namespace", "pod"})
+	// NetpolPolicyAcceptedPackets Packets of new flows allowed by the rules of the network policy
+	NetpolPolicyAcceptedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "netpol_policy_accepted_packets",
+		Help:      "Packets of new flows allowed by the rules of the network policy",
+	}, []string{"namespace", "policy"})
+	// NetpolPolicyAcceptedBytes Bytes of new flows allowed by the rules of the network policy
+	NetpolPolicyAcceptedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "netpol_policy_accepted_bytes",
+		Help:      "Bytes of new flows allowed by the rules of the network policy",
+	}, []string{"namespace", "policy"})
 	// ControllerPolicyChainsSyncTime Time it took for controller to sync policys
 	ControllerPolicyChainsSyncTime = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: namespace,
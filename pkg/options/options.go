@@ -12,101 +12,342 @@ const (
 	DefaultBgpPort         = 179
 	DefaultBgpHoldTime     = 90 * time.Second
 	defaultHealthCheckPort = 20244
+
+	// CNIModeBridge attaches pods to kube-bridge
+	CNIModeBridge = "bridge"
+	// CNIModePTP gives each pod a point-to-point veth with host routes
+	CNIModePTP = "ptp"
+
+	// LogFormatText writes the log messages as the klog text lines
+	LogFormatText = "text"
+	// LogFormatJSON writes each log message as a JSON object, with its fields as keys
+	LogFormatJSON = "json"
+
+	// NetpolBridgeModeAuto intercepts the bridged pod traffic unless the pods are routed (ptp CNI mode) or
+	// br_netfilter isn't loaded
+	NetpolBridgeModeAuto = "auto"
+	// NetpolBridgeModeOn always intercepts the bridged pod traffic with physdev rules
+	NetpolBridgeModeOn = "on"
+	// NetpolBridgeModeOff only intercepts the pod traffic in the FORWARD and OUTPUT chains
+	NetpolBridgeModeOff = "off"
+
+	// OverlayEncapsulationIPIP tunnels the pod traffic across nodes in IP-in-IP
+	OverlayEncapsulationIPIP = "ipip"
+	// OverlayEncapsulationVXLAN tunnels the pod traffic across nodes in VXLAN, for networks that drop IPIP
+	OverlayEncapsulationVXLAN = "vxlan"
+	// OverlayEncapsulationGeneve tunnels the pod traffic across nodes in Geneve, for networks that drop IPIP
+	OverlayEncapsulationGeneve = "geneve"
+	// OverlayEncapsulationWireGuard tunnels the pod traffic across nodes over WireGuard, encrypting it in transit
+	OverlayEncapsulationWireGuard = "wireguard"
+
+	// PodCIDRSourceNode takes the pod CIDRs from the kube-router annotations and the spec of the node
+	PodCIDRSourceNode = "node"
+	// PodCIDRSourceAWSMetadata takes the pod CIDRs from the IPv4 prefixes delegated to the primary ENI of the instance
+	PodCIDRSourceAWSMetadata = "aws-metadata"
+	// PodCIDRSourceGCEMetadata takes the pod CIDRs from the alias IP ranges of the first interface of the instance
+	PodCIDRSourceGCEMetadata = "gce-metadata"
+	// PodCIDRSourceAnnotation takes the pod CIDRs from a node annotation maintained by another IPAM
+	PodCIDRSourceAnnotation = "annotation"
+
+	// RPKIInvalidActionReject rejects the routes an RPKI cache finds invalid
+	RPKIInvalidActionReject = "reject"
+	// RPKIInvalidActionDepreference accepts the invalid routes with a lower local preference than the other routes
+	RPKIInvalidActionDepreference = "depreference"
+	// RPKIInvalidActionAccept accepts the invalid routes like the other routes
+	RPKIInvalidActionAccept = "accept"
+
+	// VIPWeightNone advertises the service VIPs without a weight
+	VIPWeightNone = "none"
+	// VIPWeightMED advertises the service VIPs with a MED that is lower the more local endpoints the service has
+	VIPWeightMED = "med"
+	// VIPWeightLinkBandwidth advertises the service VIPs with a link bandwidth extended community proportional to the
+	// number of local endpoints of the service
+	VIPWeightLinkBandwidth = "link-bandwidth"
 )
 
 type KubeRouterConfig struct {
-	AdvertiseClusterIP             bool
-	AdvertiseExternalIP            bool
-	AdvertiseLoadBalancerIP        bool
-	AdvertiseNodePodCidr           bool
-	AutoMTU                        bool
-	BGPGracefulRestart             bool
-	BGPGracefulRestartDeferralTime time.Duration
-	BGPGracefulRestartTime         time.Duration
-	BGPHoldTime                    time.Duration
-	BGPPort                        uint32
-	CacheSyncTimeout               time.Duration
-	CleanupConfig                  bool
-	ClusterAsn                     uint
-	ClusterIPCIDR                  string
-	DisableSrcDstCheck             bool
-	EnableCNI                      bool
-	EnableiBGP                     bool
-	EnableOverlay                  bool
-	EnablePodEgress                bool
-	EnablePprof                    bool
-	ExcludedCidrs                  []string
-	ExternalIPCIDRs                []string
-	FullMeshMode                   bool
-	GlobalHairpinMode              bool
-	HealthPort                     uint16
-	HelpRequested                  bool
-	HostnameOverride               string
-	InjectedRoutesSyncPeriod       time.Duration
-	IPTablesSyncPeriod             time.Duration
-	IpvsGracefulPeriod             time.Duration
-	IpvsGracefulTermination        bool
-	IpvsPermitAll                  bool
-	IpvsSyncPeriod                 time.Duration
-	Kubeconfig                     string
-	MasqueradeAll                  bool
-	Master                         string
-	MetricsEnabled                 bool
-	MetricsPath                    string
-	MetricsPort                    uint16
-	NodePortBindOnAllIP            bool
-	NodePortRange                  string
-	OverlayType                    string
-	OverrideNextHop                bool
-	PeerASNs                       []uint
-	PeerMultihopTTL                uint8
-	PeerPasswords                  []string
-	PeerPasswordsFile              string
-	PeerPorts                      []uint
-	PeerRouters                    []net.IP
-	RouterID                       string
-	RoutesSyncPeriod               time.Duration
-	RunFirewall                    bool
-	RunRouter                      bool
-	RunServiceProxy                bool
-	RuntimeEndpoint                string
-	Version                        bool
-	VLevel                         string
+	AdvertiseClusterIP                 bool
+	AdvertiseClusterIPCommunities      []string
+	AdvertiseExternalIP                bool
+	AdvertiseExternalIPCommunities     []string
+	AdvertiseHealthCheckPeriod         time.Duration
+	AdvertiseHealthChecks              map[string]string
+	AdvertiseLoadBalancerIP            bool
+	AdvertiseLoadBalancerIPCommunities []string
+	AdvertiseNodePodCidr               bool
+	AdvertisePodCidrCommunities        []string
+	AdvertisePodCidrSummaries          []string
+	AdvertisePodCidrSummaryPeers       []string
+	AdvertiseVIPWeight                 string
+	AnnounceVIPs                       bool
+	AutoMTU                            bool
+	BGPAddPaths                        uint8
+	BGPGracefulRestart                 bool
+	BGPGracefulRestartDeferralTime     time.Duration
+	BGPGracefulRestartTime             time.Duration
+	BGPHoldTime                        time.Duration
+	BGPInterface                       string
+	BGPLongLivedGracefulRestart        bool
+	BGPLongLivedStaleTime              time.Duration
+	BGPPort                            uint32
+	BGPRouteFlapDampening              bool
+	BGPRouteFlapDampeningHalfLife      time.Duration
+	BridgeHairpinMode                  bool
+	CacheSyncTimeout                   time.Duration
+	CleanupConfig                      bool
+	ClusterAsn                         uint
+	ClusterIPCIDR                      string
+	CNIBandwidthPlugin                 bool
+	CNIConfTemplate                    string
+	CNIMode                            string
+	CNITuningSysctls                   map[string]string
+	ConntrackAccounting                bool
+	ConntrackLongLivedAge              time.Duration
+	ConntrackReport                    bool
+	DisableSrcDstCheck                 bool
+	DSREncapsulation                   string
+	DSRGUEPort                         uint16
+	EnableAdminNetworkPolicy           bool
+	EnableBGPPeers                     bool
+	EnableBGPPolicies                  bool
+	EnableClusterCIDRs                 bool
+	EnableCNI                          bool
+	EnableEgressIPs                    bool
+	EnableGatewayAPI                   bool
+	EnableGlobalNetworkPolicy          bool
+	EnableiBGP                         bool
+	EnableIPPoolIPAM                   bool
+	EnableIPv4                         bool
+	EnableIPv6                         bool
+	EnableNDPProxy                     bool
+	EnableNetpolStatus                 bool
+	EnableNodeFirewall                 bool
+	EnableOverlay                      bool
+	EnablePodEgress                    bool
+	EnablePodRoutes                    bool
+	EnablePprof                        bool
+	EnableStaticRoutes                 bool
+	EnableWatchList                    bool
+	ExcludedCidrs                      []string
+	ExternalIPCIDRs                    []string
+	Force                              bool
+	FullMeshMode                       bool
+	GenevePort                         uint16
+	GlobalHairpinMode                  bool
+	GracefulWithdraw                   bool
+	GracefulWithdrawDelay              time.Duration
+	HealthPort                         uint16
+	HelpRequested                      bool
+	HostnameOverride                   string
+	IBGPZoneLabel                      string
+	IBGPZoneRouteReflectors            uint
+	ImportExternalRoutes               []string
+	ImportExternalRoutesTable          int
+	InjectedRoutesSyncPeriod           time.Duration
+	IPTablesSyncPeriod                 time.Duration
+	IpvsGracefulPeriod                 time.Duration
+	IpvsGracefulTermination            bool
+	IpvsPermitAll                      bool
+	IpvsSlowStartPeriod                time.Duration
+	IpvsStatsEstimation                bool
+	IpvsSyncDaemonID                   int
+	IpvsSyncDaemonInterface            string
+	IpvsSyncPeriod                     time.Duration
+	IpvsTCPFinTimeout                  time.Duration
+	IpvsTCPTimeout                     time.Duration
+	IpvsTerminatingEndpoints           bool
+	IpvsTopologyAwareRouting           bool
+	IpvsUDPTimeout                     time.Duration
+	KubeAPIBurst                       int
+	KubeAPIQPS                         float32
+	Kubeconfig                         string
+	LoadBalancerCIDRs                  []string
+	LoadBalancerDefaultClass           bool
+	LoadBalancerSyncPeriod             time.Duration
+	LogFormat                          string
+	MasqueradeAll                      bool
+	Master                             string
+	MetricsEnabled                     bool
+	MetricsIpvsEndpoints               bool
+	MetricsPath                        string
+	MetricsPort                        uint16
+	NamespaceIsolation                 bool
+	NamespaceIsolationExempt           []string
+	NetpolBridgeMode                   string
+	NetpolDenyAction                   string
+	NetpolFlowExport                   string
+	NetpolNFLogGroup                   uint16
+	NetpolNFLogLimit                   string
+	NetpolNFLogLimitBurst              uint
+	NetpolNFLogSize                    uint32
+	NetpolPolicyLogNFLogGroup          uint16
+	NetpolSimulate                     string
+	NodeLocalDNSIP                     net.IP
+	NodePortAddresses                  []string
+	NodePortAllowedCIDRs               []string
+	NodePortBindOnAllIP                bool
+	NodePortInterface                  string
+	NodePortRange                      string
+	NoMasqueradeCIDRs                  []string
+	NoTrackCIDRs                       []string
+	NoTrackPorts                       []string
+	OverlayEncapsulation               string
+	OverlayInterface                   string
+	OverlayMTUProbePeriod              time.Duration
+	OverlayType                        string
+	OverrideNextHop                    bool
+	PeerASNs                           []uint
+	PeerBFD                            bool
+	PeerBFDInterval                    time.Duration
+	PeerBFDMultiplier                  uint8
+	PeerECMP                           bool
+	PeerGracefulRestartTimes           []time.Duration
+	PeerInterfaceASNs                  []uint
+	PeerInterfaces                     []string
+	PeerListenRangeASNs                []string
+	PeerListenRanges                   []string
+	PeerLongLivedStaleTimes            []time.Duration
+	PeerMaxPrefixes                    []uint
+	PeerMaxPrefixesRestartTime         time.Duration
+	PeerMaxPrefixesWarningPct          uint32
+	PeerMultihopTTL                    uint8
+	PeerMultihopTTLs                   []uint
+	PeerPasswords                      []string
+	PeerPasswordSecrets                []string
+	PeerPasswordsFile                  string
+	PeerPorts                          []uint
+	PeerRouters                        []net.IP
+	PeerTTLSecurityHops                []uint
+	PodCIDRSource                      string
+	PodCIDRSourceAnnotation            string
+	RouterID                           string
+	RoutesSyncPeriod                   time.Duration
+	RPKICaches                         []string
+	RPKIInvalidAction                  string
+	RunFirewall                        bool
+	RunLoadBalancer                    bool
+	RunRouter                          bool
+	RunServiceProxy                    bool
+	RuntimeEndpoint                    string
+	SkipKernelModuleCheck              bool
+	SysctlSyncPeriod                   time.Duration
+	Sysctls                            map[string]int
+	TracingEndpoint                    string
+	TracingInsecure                    bool
+	Version                            bool
+	VLevel                             string
+	VModule                            string
+	VXLANPort                          uint16
+	WireGuardPort                      uint16
+	WireGuardPrivateKeyFile            string
 	// FullMeshPassword    string
 }
 
 func NewKubeRouterConfig() *KubeRouterConfig {
 	//nolint:gomnd // Here we are specifying the names of the literals which is very similar to constant behavior
 	return &KubeRouterConfig{
+		AdvertiseHealthCheckPeriod:     5 * time.Second,
+		AdvertiseVIPWeight:             VIPWeightNone,
 		BGPGracefulRestartDeferralTime: 360 * time.Second,
 		BGPGracefulRestartTime:         90 * time.Second,
 		BGPHoldTime:                    90 * time.Second,
+		BGPLongLivedStaleTime:          1 * time.Hour,
+		BGPRouteFlapDampeningHalfLife:  15 * time.Minute,
 		CacheSyncTimeout:               1 * time.Minute,
 		ClusterIPCIDR:                  "10.96.0.0/12",
+		CNIMode:                        CNIModeBridge,
+		ConntrackLongLivedAge:          1 * time.Hour,
+		DSREncapsulation:               "ipip",
+		DSRGUEPort:                     6080,
+		EnableIPv4:                     true,
 		EnableOverlay:                  true,
+		GenevePort:                     6081,
+		IBGPZoneRouteReflectors:        2,
 		IPTablesSyncPeriod:             5 * time.Minute,
 		IpvsGracefulPeriod:             30 * time.Second,
 		IpvsSyncPeriod:                 5 * time.Minute,
+		KubeAPIBurst:                   10,
+		KubeAPIQPS:                     5,
+		LoadBalancerDefaultClass:       true,
+		LoadBalancerSyncPeriod:         1 * time.Minute,
+		LogFormat:                      LogFormatText,
+		NamespaceIsolationExempt:       []string{"kube-system"},
 		NodePortRange:                  "30000-32767",
+		OverlayEncapsulation:           OverlayEncapsulationIPIP,
 		OverlayType:                    "subnet",
+		PeerBFDInterval:                300 * time.Millisecond,
+		PeerBFDMultiplier:              3,
+		PeerMaxPrefixesWarningPct:      75,
+		PodCIDRSource:                  PodCIDRSourceNode,
 		RoutesSyncPeriod:               5 * time.Minute,
+		RPKIInvalidAction:              RPKIInvalidActionReject,
+		ImportExternalRoutesTable:      254,
 		InjectedRoutesSyncPeriod:       60 * time.Second,
+		SysctlSyncPeriod:               1 * time.Minute,
+		VXLANPort:                      4789,
+		WireGuardPort:                  51820,
+		WireGuardPrivateKeyFile:        "/var/lib/kube-router/wireguard.key",
 	}
 }
 
 func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&s.AdvertiseClusterIP, "advertise-cluster-ip", false,
 		"Add Cluster IP of the service to the RIB so that it gets advertises to the BGP peers.")
+	fs.StringSliceVar(&s.AdvertiseClusterIPCommunities, "advertise-cluster-ip-communities",
+		s.AdvertiseClusterIPCommunities,
+		"BGP communities the Cluster IPs of the services are advertised with. Can be overridden per service with "+
+			"the kube-router.io/service.advertise.clusterip.communities annotation.")
 	fs.BoolVar(&s.AdvertiseExternalIP, "advertise-external-ip", false,
 		"Add External IP of service to the RIB so that it gets advertised to the BGP peers.")
+	fs.StringSliceVar(&s.AdvertiseExternalIPCommunities, "advertise-external-ip-communities",
+		s.AdvertiseExternalIPCommunities,
+		"BGP communities the External IPs of the services are advertised with. Can be overridden per service "+
+			"with the kube-router.io/service.advertise.externalip.communities annotation.")
+	fs.DurationVar(&s.AdvertiseHealthCheckPeriod, "advertise-health-check-period", s.AdvertiseHealthCheckPeriod,
+		"The delay between evaluations of the health checks and Leases gating the advertisement of service VIPs "+
+			"(e.g. '5s', '1m'). Must be greater than 0.")
+	fs.StringToStringVar(&s.AdvertiseHealthChecks, "advertise-health-checks", s.AdvertiseHealthChecks,
+		"Health checks services can gate the advertisement of their VIPs by this node on with the "+
+			"kube-router.io/service.advertise.healthcheck annotation, as name=check pairs (e.g. "+
+			"ingress=http://127.0.0.1:10254/healthz). A check is either an http:// or https:// URL that has to "+
+			"answer with a 2xx or 3xx status, or exec: followed by a command that has to exit with 0.")
 	fs.BoolVar(&s.AdvertiseLoadBalancerIP, "advertise-loadbalancer-ip", false,
 		"Add LoadbBalancer IP of service status as set by the LB provider to the RIB so that it gets "+
 			"advertised to the BGP peers.")
+	fs.StringSliceVar(&s.AdvertiseLoadBalancerIPCommunities, "advertise-loadbalancer-ip-communities",
+		s.AdvertiseLoadBalancerIPCommunities,
+		"BGP communities the LoadBalancer IPs of the services are advertised with. Can be overridden per service "+
+			"with the kube-router.io/service.advertise.loadbalancerip.communities annotation.")
 	fs.BoolVar(&s.AdvertiseNodePodCidr, "advertise-pod-cidr", true,
 		"Add Node's POD cidr to the RIB so that it gets advertised to the BGP peers.")
+	fs.StringSliceVar(&s.AdvertisePodCidrCommunities, "advertise-pod-cidr-communities",
+		s.AdvertisePodCidrCommunities,
+		"BGP communities the pod CIDRs of the node are advertised with.")
+	fs.StringSliceVar(&s.AdvertisePodCidrSummaries, "advertise-pod-cidr-summaries", s.AdvertisePodCidrSummaries,
+		"Summary prefixes advertised to the external BGP peers by the nodes whose pod CIDRs they cover (e.g. the "+
+			"cluster CIDR), instead of the pod CIDRs they cover.")
+	fs.StringSliceVar(&s.AdvertisePodCidrSummaryPeers, "advertise-pod-cidr-summary-peers",
+		s.AdvertisePodCidrSummaryPeers,
+		"IPs or CIDRs of the external BGP peers the pod CIDRs covered by \"--advertise-pod-cidr-summaries\" are "+
+			"suppressed towards, the other external peers get them along with the summaries. Defaults to all the "+
+			"external BGP peers.")
+	fs.StringVar(&s.AdvertiseVIPWeight, "advertise-vip-weight", s.AdvertiseVIPWeight,
+		"Weight the VIPs of the services with a local traffic policy by the number of ready endpoints of the "+
+			"service on the node, so that the upstream routers can spread the traffic across the nodes unequally. "+
+			"One of "+VIPWeightNone+", "+VIPWeightMED+" (the nodes with the most endpoints are preferred) or "+
+			VIPWeightLinkBandwidth+" (weighted ECMP with the link bandwidth extended community).")
+	fs.BoolVar(&s.AnnounceVIPs, "announce-vips", false,
+		"Send gratuitous ARPs (unsolicited neighbor advertisements for IPv6) on the node's interface when this node "+
+			"starts serving a service's external or LoadBalancer IP, so that L2 neighbors update their caches "+
+			"immediately on failover.")
 	fs.BoolVar(&s.AutoMTU, "auto-mtu", true,
 		"Auto detect and set the largest possible MTU for kube-bridge and pod interfaces (also accounts for "+
-			"IPIP overlay network when enabled).")
+			"IPIP overlay network when enabled), and the MTU of the overlay routes and tunnels to each node from "+
+			"the MTU of the path to the node minus the overhead of --overlay-encapsulation.")
+	fs.Uint8Var(&s.BGPAddPaths, "bgp-add-paths", s.BGPAddPaths,
+		"The number of paths of each prefix sent to the BGP peers that support Add-Path (RFC 7911), whose "+
+			"paths are received as well, e.g. for route reflectors to reflect the paths of all the nodes "+
+			"advertising a service VIP. 0 disables Add-Path.")
 	fs.BoolVar(&s.BGPGracefulRestart, "bgp-graceful-restart", false,
 		"Enables the BGP Graceful Restart capability so that routes are preserved on unexpected restarts")
 	fs.DurationVar(&s.BGPGracefulRestartDeferralTime, "bgp-graceful-restart-deferral-time",
@@ -118,31 +359,141 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"This parameter is mainly used to modify the holdtime declared to BGP peer. When Kube-router goes down "+
 			"abnormally, the local saving time of BGP route will be affected. "+
 			"Holdtime must be in the range 3s to 18h12m16s.")
+	fs.StringVar(&s.BGPInterface, "bgp-interface", "",
+		"Interface (or IP) of the node whose address is used to peer with the other nodes and the external BGP "+
+			"peers. Can be overridden per node with the kube-router.io/bgp-interface annotation. Defaults to the "+
+			"node IP.")
+	fs.BoolVar(&s.BGPLongLivedGracefulRestart, "bgp-long-lived-graceful-restart", false,
+		"Enables the BGP Long-Lived Graceful Restart capability (RFC 9494) next to \"--bgp-graceful-restart\", so "+
+			"that the peers keep the routes of the node as stale, least preferred, routes once its graceful "+
+			"restart time has expired, instead of withdrawing them.")
+	fs.DurationVar(&s.BGPLongLivedStaleTime, "bgp-long-lived-stale-time", s.BGPLongLivedStaleTime,
+		"How long the peers keep the routes of the node as stale routes with Long-Lived Graceful Restart, "+
+			"maximum 4660h.")
 	fs.Uint32Var(&s.BGPPort, "bgp-port", DefaultBgpPort,
 		"The port open for incoming BGP connections and to use for connecting with other BGP peers.")
+	fs.BoolVar(&s.BGPRouteFlapDampening, "bgp-route-flap-dampening", false,
+		"Suppress the routes learned from the external BGP peers, and the service VIPs advertised by this node, "+
+			"which are withdrawn and announced again too often, until they are stable (RFC 2439).")
+	fs.DurationVar(&s.BGPRouteFlapDampeningHalfLife, "bgp-route-flap-dampening-half-life",
+		s.BGPRouteFlapDampeningHalfLife,
+		"The time after which half of the penalty of a flapping route is forgiven. Routes are suppressed for at "+
+			"most 4 half-lives.")
+	fs.BoolVar(&s.BridgeHairpinMode, "bridge-hairpin-mode", false,
+		"Keep hairpin_mode enabled on the kube-bridge port of every pod, so that pods can reach themselves through "+
+			"hairpin Services. Requires --enable-cni with --cni-mode=bridge.")
 	fs.DurationVar(&s.CacheSyncTimeout, "cache-sync-timeout", s.CacheSyncTimeout,
 		"The timeout for cache synchronization (e.g. '5s', '1m'). Must be greater than 0.")
 	fs.BoolVar(&s.CleanupConfig, "cleanup-config", false,
 		"Cleanup iptables rules, ipvs, ipset configuration and exit.")
 	fs.UintVar(&s.ClusterAsn, "cluster-asn", s.ClusterAsn,
 		"ASN number under which cluster nodes will run iBGP.")
+	fs.BoolVar(&s.CNIBandwidthPlugin, "cni-bandwidth-plugin", false,
+		"Chain the bandwidth plugin into the CNI conf so that the kubernetes.io/ingress-bandwidth and "+
+			"kubernetes.io/egress-bandwidth pod annotations are enforced. Requires a .conflist CNI conf file.")
+	fs.StringVar(&s.CNIConfTemplate, "cni-conf-template", "",
+		"Path to a Go template the CNI conf is rendered from with the MTU, pod CIDRs and plugin chain of the node. "+
+			"When not given the existing CNI conf is updated in place.")
+	fs.StringVar(&s.CNIMode, "cni-mode", s.CNIMode,
+		"How pods are connected to the node network when kube-router manages the CNI conf. Either \"bridge\" to "+
+			"attach pods to kube-bridge, or \"ptp\" to give each pod a veth with host routes and route all pod "+
+			"traffic through the node.")
+	fs.StringToStringVar(&s.CNITuningSysctls, "cni-tuning-sysctls", s.CNITuningSysctls,
+		"Chain the tuning plugin into the CNI conf to set the given sysctls (e.g. net.core.somaxconn=1024) in "+
+			"the network namespace of every pod. Requires a .conflist CNI conf file.")
+	fs.BoolVar(&s.ConntrackAccounting, "conntrack-accounting", false,
+		"Enable conntrack accounting and timestamps, and export the number of flows and the long-lived flows of each "+
+			"service and pod and the bytes they carried as metrics.")
+	fs.DurationVar(&s.ConntrackLongLivedAge, "conntrack-long-lived-age", s.ConntrackLongLivedAge,
+		"The age from which a conntrack flow counts as long-lived.")
+	fs.BoolVar(&s.ConntrackReport, "conntrack-report", false,
+		"Print the conntrack flows and long-lived flows of each service and pod on the node, and exit. Requires "+
+			"--conntrack-accounting to have been enabled for the ages and byte counts to be known.")
 	fs.BoolVar(&s.DisableSrcDstCheck, "disable-source-dest-check", true,
 		"Disable the source-dest-check attribute for AWS EC2 instances. When this option is false, it must be "+
 			"set some other way.")
+	fs.StringVar(&s.DSREncapsulation, "dsr-encapsulation", s.DSREncapsulation,
+		"The encapsulation of the traffic of DSR services to the pods on other nodes, ipip or gue (IPIP in Generic "+
+			"UDP Encapsulation, for fabrics that drop IPIP). Overridden by the kube-router.io/service.dsr.encapsulation "+
+			"annotation of the services.")
+	fs.Uint16Var(&s.DSRGUEPort, "dsr-gue-port", s.DSRGUEPort,
+		"The UDP port the pods of DSR services receive the GUE encapsulated traffic on.")
+	fs.BoolVar(&s.EnableAdminNetworkPolicy, "enable-admin-network-policy", false,
+		"Enforce the AdminNetworkPolicy and BaselineAdminNetworkPolicy resources (policy.networking.k8s.io/v1alpha1) "+
+			"before and after the network policies. Requires --run-firewall and their CRDs to be installed.")
+	fs.BoolVar(&s.EnableBGPPeers, "enable-bgp-peers", false,
+		"Peer with the routers of the BGPPeer custom resources that select this node, next to the peer routers of "+
+			"the flags or of the node annotations. Requires the BGPPeer CRD.")
+	fs.BoolVar(&s.EnableBGPPolicies, "enable-bgp-policies", false,
+		"Filter and modify the routes learned from and advertised to the external peers by the BGPPolicy custom "+
+			"resources. Requires the BGPPolicy CRD.")
+	fs.BoolVar(&s.EnableClusterCIDRs, "enable-cluster-cidrs", false,
+		"Treat the ranges of the ClusterCIDR objects that kube-controller-manager allocates the pod CIDRs of the nodes "+
+			"from as pod subnets, the traffic of the pods to them isn't masqueraded. Requires the "+
+			"networking.k8s.io/v1alpha1 API.")
 	fs.BoolVar(&s.EnableCNI, "enable-cni", true,
 		"Enable CNI plugin. Disable if you want to use kube-router features alongside another CNI plugin.")
+	fs.BoolVar(&s.EnableEgressIPs, "enable-egress-ips", false,
+		"Translate the source of the traffic of the pods the EgressIP custom resources select to their egress IPs, "+
+			"held and advertised by one of the nodes. Requires --enable-pod-egress and the EgressIP CRD.")
+	fs.BoolVar(&s.EnableGatewayAPI, "enable-gateway-api", false,
+		"Serve the TCP and UDP listeners of the Gateways of the GatewayClasses of the kube-router.io/gateway-controller "+
+			"controller, forwarding to the backends of their TCPRoutes and UDPRoutes. Requires the Gateway API CRDs.")
+	fs.BoolVar(&s.EnableGlobalNetworkPolicy, "enable-global-network-policy", false,
+		"Enforce the GlobalNetworkPolicy custom resources on the pods, before the admin network policies, and with "+
+			"--enable-node-firewall on the host endpoints they select. Requires --run-firewall for the pods.")
 	fs.BoolVar(&s.EnableiBGP, "enable-ibgp", true,
 		"Enables peering with nodes with the same ASN, if disabled will only peer with external BGP peers")
+	fs.BoolVar(&s.EnableIPPoolIPAM, "enable-ippool-ipam", false,
+		"Allocate the pod CIDRs of this node from the IPPool custom resources that select it, instead of relying on "+
+			"the pod CIDR allocated by kube-controller-manager.")
+	fs.BoolVar(&s.EnableIPv4, "enable-ipv4", s.EnableIPv4,
+		"Enforce the network policies on the IPv4 traffic of the pods.")
+	fs.BoolVar(&s.EnableIPv6, "enable-ipv6", s.EnableIPv6,
+		"Enforce the network policies on the IPv6 traffic of the pods, with --enable-ipv4 on dual-stack clusters "+
+			"where the routes of both address families are advertised as well.")
+	fs.BoolVar(&s.EnableNDPProxy, "enable-ndp-proxy", false,
+		"Answer IPv6 neighbor solicitations for the external and LoadBalancer IPs of services served by this node "+
+			"on the node's interface, so that they can be resolved on L2 networks without BGP.")
+	fs.BoolVar(&s.EnableNetpolStatus, "enable-netpol-status", false,
+		"Publish the outcome of the syncs of the network policies (the time of the last successful sync, the number "+
+			"of policies, chains and ipsets, and the last error) in a NodeNetworkPolicyStatus named after the node. "+
+			"Requires the NodeNetworkPolicyStatus CRD.")
+	fs.BoolVar(&s.EnableNodeFirewall, "enable-node-firewall", false,
+		"Enforce the NodeFirewall custom resources that select this node on the traffic to the node's own "+
+			"addresses.")
 	fs.BoolVar(&s.EnableOverlay, "enable-overlay", true,
 		"When enable-overlay is set to true, IP-in-IP tunneling is used for pod-to-pod networking across "+
 			"nodes in different subnets. When set to false no tunneling is used and routing infrastructure is "+
 			"expected to route traffic for pod-to-pod networking across nodes in different subnets")
 	fs.BoolVar(&s.EnablePodEgress, "enable-pod-egress", true,
 		"SNAT traffic from Pods to destinations outside the cluster.")
+	fs.BoolVar(&s.EnablePodRoutes, "enable-pod-routes", false,
+		"Program the routes listed in the kube-router.io/pod.routes annotation of the pods in their network "+
+			"namespace. Requires --runtime-endpoint.")
 	fs.BoolVar(&s.EnablePprof, "enable-pprof", false,
 		"Enables pprof for debugging performance and memory leak issues.")
+	fs.BoolVar(&s.EnableStaticRoutes, "enable-static-routes", false,
+		"Install the static and blackhole routes of the StaticRoute custom resources that select this node. "+
+			"Requires the StaticRoute CRD.")
+	fs.BoolVar(&s.EnableWatchList, "enable-watch-list", false,
+		"Stream the initial state of the informers from the API server with watch lists instead of listing it, "+
+			"which uses less memory on the API server. Requires the WatchList feature gate of the API server, "+
+			"kube-router falls back to listing otherwise.")
 	fs.StringSliceVar(&s.ExcludedCidrs, "excluded-cidrs", s.ExcludedCidrs,
 		"Excluded CIDRs are used to exclude IPVS rules from deletion.")
+	fs.BoolVar(&s.Force, "force", false,
+		"Start even if another component (e.g. kube-proxy or another network policy controller) appears to be "+
+			"managing the same parts of the node's dataplane.")
+	fs.Uint16Var(&s.GenevePort, "geneve-port", s.GenevePort,
+		"The UDP port the Geneve overlay sends to and listens on with --overlay-encapsulation=geneve.")
+	fs.BoolVar(&s.GracefulWithdraw, "graceful-withdraw", false,
+		"Withdraw the service VIPs from the BGP peers while the node is cordoned, and the service VIPs and pod "+
+			"CIDRs before stopping the BGP server on shutdown, so the traffic moves to the other nodes before the "+
+			"node goes away. With --bgp-graceful-restart, only the shutdowns of cordoned nodes withdraw them.")
+	fs.DurationVar(&s.GracefulWithdrawDelay, "graceful-withdraw-delay", 0,
+		"Time to wait on shutdown with --graceful-withdraw after withdrawing the advertisements, for the in-flight "+
+			"connections to finish, before stopping the BGP server.")
 	fs.BoolVar(&s.GlobalHairpinMode, "hairpin-mode", false,
 		"Add iptables rules for every Service Endpoint to support hairpin traffic.")
 	fs.Uint16Var(&s.HealthPort, "health-port", defaultHealthCheckPort, "Health check port, 0 = Disabled")
@@ -151,6 +502,20 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&s.HostnameOverride, "hostname-override", s.HostnameOverride,
 		"Overrides the NodeName of the node. Set this if kube-router is unable to determine your NodeName "+
 			"automatically.")
+	fs.StringVar(&s.IBGPZoneLabel, "ibgp-zone-label", "",
+		"Partition the iBGP mesh by the value of this node label (e.g. topology.kubernetes.io/zone) instead of "+
+			"peering every node with every other node. The nodes of each zone elect route reflectors among "+
+			"themselves, which peer with each other and with the other nodes of their zone. Overrides the "+
+			"rr.server and rr.client node annotations.")
+	fs.UintVar(&s.IBGPZoneRouteReflectors, "ibgp-zone-route-reflectors", s.IBGPZoneRouteReflectors,
+		"The number of route reflectors elected among the ready nodes of each zone with --ibgp-zone-label.")
+	fs.StringSliceVar(&s.ImportExternalRoutes, "import-external-routes", s.ImportExternalRoutes,
+		"Install the routes learned from the external BGP peers within these CIDRs (e.g. 0.0.0.0/0 for all of "+
+			"them, including the default route) into the routing table of --import-external-routes-table, through "+
+			"any next hop. The routes rejected by the import rules of the BGPPolicies aren't installed.")
+	fs.IntVar(&s.ImportExternalRoutesTable, "import-external-routes-table", s.ImportExternalRoutesTable,
+		"The kernel routing table the routes of --import-external-routes are installed into, 254 being the main "+
+			"table.")
 	fs.DurationVar(&s.InjectedRoutesSyncPeriod, "injected-routes-sync-period", s.InjectedRoutesSyncPeriod,
 		"The delay between route table synchronizations  (e.g. '5s', '1m', '2h22m'). Must be greater than 0.")
 	fs.DurationVar(&s.IPTablesSyncPeriod, "iptables-sync-period", s.IPTablesSyncPeriod,
@@ -162,20 +527,139 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"Enables the experimental IPVS graceful terminaton capability")
 	fs.BoolVar(&s.IpvsPermitAll, "ipvs-permit-all", true,
 		"Enables rule to accept all incoming traffic to service VIP's on the node.")
+	fs.DurationVar(&s.IpvsSlowStartPeriod, "ipvs-slow-start-period", 0,
+		"Ramp up the IPVS weight of the new endpoints of the services over this period (e.g. '30s', '2m'), so that "+
+			"they don't get their full share of the new connections at once. Only the weighted schedulers (wrr, "+
+			"wlc) take the weights into account. Disabled when 0.")
+	fs.BoolVar(&s.IpvsStatsEstimation, "ipvs-stats-estimation", true,
+		"Run the rate estimator of the kernel for the IPVS services, which computes their connection, packet and "+
+			"byte rates. Disabling it saves CPU on nodes with tens of thousands of services, the rate metrics of the "+
+			"services are then no longer published. Needs a kernel 6.2 or newer to be disabled.")
+	fs.IntVar(&s.IpvsSyncDaemonID, "ipvs-sync-daemon-id", 0,
+		"The sync ID of the IPVS sync daemons, from 0 to 255, to tell the connection state of the nodes of this "+
+			"cluster apart from other clusters on the same network.")
+	fs.StringVar(&s.IpvsSyncDaemonInterface, "ipvs-sync-daemon-interface", "",
+		"Run the IPVS sync daemons on this interface, multicasting the state of the IPVS connections between the "+
+			"nodes so that the established connections survive a failover of the VIPs to another node. Disabled "+
+			"when empty.")
 	fs.DurationVar(&s.IpvsSyncPeriod, "ipvs-sync-period", s.IpvsSyncPeriod,
 		"The delay between ipvs config synchronizations (e.g. '5s', '1m', '2h22m'). Must be greater than 0.")
+	fs.DurationVar(&s.IpvsTCPTimeout, "ipvs-tcp-timeout", 0,
+		"The timeout of the idle IPVS TCP connections (e.g. '900s', '1h'), 0 keeps the value of the kernel.")
+	fs.DurationVar(&s.IpvsTCPFinTimeout, "ipvs-tcpfin-timeout", 0,
+		"The timeout of the IPVS TCP connections after receiving a FIN (e.g. '120s'), 0 keeps the value of the "+
+			"kernel.")
+	fs.BoolVar(&s.IpvsTerminatingEndpoints, "ipvs-terminating-endpoints", false,
+		"Route to the terminating endpoints that are still serving when a service has no ready endpoints left, and "+
+			"drain the terminating endpoints with an IPVS weight of 0 otherwise. Watches the EndpointSlices.")
+	fs.BoolVar(&s.IpvsTopologyAwareRouting, "ipvs-topology-aware-routing", false,
+		"Prefer the endpoints in the zone of the node for the services whose EndpointSlices have topology hints "+
+			"(topology-mode annotation or PreferClose traffic distribution). Watches the EndpointSlices.")
+	fs.DurationVar(&s.IpvsUDPTimeout, "ipvs-udp-timeout", 0,
+		"The timeout of the idle IPVS UDP connections (e.g. '300s', '30m'), 0 keeps the value of the kernel.")
+	fs.IntVar(&s.KubeAPIBurst, "kube-api-burst", s.KubeAPIBurst,
+		"The burst of requests to the API server allowed above --kube-api-qps.")
+	fs.Float32Var(&s.KubeAPIQPS, "kube-api-qps", s.KubeAPIQPS,
+		"The sustained rate of requests per second to the API server.")
 	fs.StringVar(&s.Kubeconfig, "kubeconfig", s.Kubeconfig,
 		"Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	fs.StringSliceVar(&s.LoadBalancerCIDRs, "loadbalancer-ip-range", s.LoadBalancerCIDRs,
+		"CIDRs of the pools the LoadBalancer IPs of the services are allocated from by --run-loadbalancer, IPv4 "+
+			"and IPv6 (e.g. 192.0.2.0/24,2001:db8::/120).")
+	fs.BoolVar(&s.LoadBalancerDefaultClass, "loadbalancer-default-class", s.LoadBalancerDefaultClass,
+		"Allocate LoadBalancer IPs to the services without a loadBalancerClass too, not only to those of the "+
+			"kube-router.io/lballoc class.")
+	fs.DurationVar(&s.LoadBalancerSyncPeriod, "loadbalancer-sync-period", s.LoadBalancerSyncPeriod,
+		"The delay between checks of the LoadBalancer IPs allocated to the services (e.g. '30s', '1m'). Must be "+
+			"greater than 0.")
+	fs.StringVar(&s.LogFormat, "log-format", s.LogFormat,
+		"The format of the log messages, text or json. json writes each message as a JSON object with the "+
+			"timestamp, the caller, the message and its fields (e.g. controller, namespace, pod, policy, peer).")
 	fs.BoolVar(&s.MasqueradeAll, "masquerade-all", false,
 		"SNAT all traffic to cluster IP/node port.")
 	fs.StringVar(&s.Master, "master", s.Master,
 		"The address of the Kubernetes API server (overrides any value in kubeconfig).")
+	fs.BoolVar(&s.MetricsIpvsEndpoints, "metrics-ipvs-endpoints", false,
+		"Publish the connections, packets and bytes of each endpoint of the services, labeled with their pod. Adds a "+
+			"time series per endpoint of each service VIP.")
 	fs.StringVar(&s.MetricsPath, "metrics-path", "/metrics", "Prometheus metrics path")
 	fs.Uint16Var(&s.MetricsPort, "metrics-port", 0, "Prometheus metrics port, (Default 0, Disabled)")
+	fs.BoolVar(&s.NamespaceIsolation, "namespace-isolation", false,
+		"Isolate the namespaces from each other: the pods which aren't selected by an ingress network policy only "+
+			"accept traffic from the pods of their own namespace, and from outside the pod network.")
+	fs.StringSliceVar(&s.NamespaceIsolationExempt, "namespace-isolation-exempt", s.NamespaceIsolationExempt,
+		"Namespaces whose pods accept traffic from all namespaces when --namespace-isolation is enabled (e.g. the "+
+			"namespace of the cluster DNS).")
+	fs.StringVar(&s.NetpolBridgeMode, "netpol-bridge-mode", NetpolBridgeModeAuto,
+		"Whether the network policies intercept the pod traffic switched by a bridge with physdev rules, which need "+
+			"br_netfilter: auto (unless --cni-mode=ptp or br_netfilter isn't loaded), on or off for CNIs without a "+
+			"bridge, which only rely on the FORWARD chain.")
+	fs.StringVar(&s.NetpolDenyAction, "netpol-deny-action", "REJECT",
+		"Verdict of the traffic that the network policies don't allow: DROP, REJECT (answers with ICMP port "+
+			"unreachable) or REJECT:<type> to answer with another ICMP error (e.g. icmp-admin-prohibited, translated "+
+			"for IPv6) or with a TCP reset (tcp-reset). Network policies can override it with the "+
+			"kube-router.io/deny-action annotation.")
+	fs.StringVar(&s.NetpolFlowExport, "netpol-flow-export", "",
+		"Export the flows dropped by the network policies as JSON lines, along with the pods and network policies "+
+			"involved, to stdout, a file (file://<path>) or a remote syslog server (syslog://<host:port> over UDP, "+
+			"syslog+tcp://<host:port> over TCP). Reads the NFLOG group of --netpol-nflog-group, which nothing else may "+
+			"listen to. Disabled by default.")
+	fs.Uint16Var(&s.NetpolNFLogGroup, "netpol-nflog-group", 100,
+		"NFLOG group of the traffic dropped by the network policies.")
+	fs.StringVar(&s.NetpolNFLogLimit, "netpol-nflog-limit", "10/minute",
+		"Rate limit of the logged traffic of each pod and network policy rule, as <count>/<second|minute|hour|day>. "+
+			"Empty logs all of the traffic.")
+	fs.UintVar(&s.NetpolNFLogLimitBurst, "netpol-nflog-limit-burst", 10,
+		"Number of packets logged in a burst before --netpol-nflog-limit applies.")
+	fs.Uint32Var(&s.NetpolNFLogSize, "netpol-nflog-size", 0,
+		"Number of bytes of the logged packets copied to the NFLOG groups, 0 copies the whole packets.")
+	fs.Uint16Var(&s.NetpolPolicyLogNFLogGroup, "netpol-policy-log-nflog-group", 101,
+		"NFLOG group of the traffic logged for the network policies annotated with kube-router.io/log=true.")
+	fs.StringVar(&s.NetpolSimulate, "netpol-simulate", "",
+		"Print whether the network policies allow the flow <namespace>/<pod>,<namespace>/<pod>|<IP>,"+
+			"<protocol>[/<port>] (e.g. shop/frontend,shop/backend,tcp/8080) and which policy rule decided it, and "+
+			"exit. The flow is simulated by the kube-router running on the node, on its --health-port.")
+	fs.StringSliceVar(&s.NoMasqueradeCIDRs, "no-masquerade-cidrs", s.NoMasqueradeCIDRs,
+		"Destination CIDRs the traffic of the pods to keeps the pod IPs as its source, even with "+
+			"\"--masquerade-all\" and pod egress masquerading. Services can be exempted the same way with the "+
+			"kube-router.io/service.no-masquerade annotation.")
+	fs.IPVar(&s.NodeLocalDNSIP, "node-local-dns-ip", nil,
+		"The IP of the node-local DNS cache (e.g. 169.254.20.10). The DNS traffic to and from it is exempted from "+
+			"connection tracking and NAT, and allowed by the network policies of the pods.")
+	fs.StringSliceVar(&s.NodePortAddresses, "nodeport-addresses", s.NodePortAddresses,
+		"CIDRs of the node addresses NodePort services are served on, each address of the node within them serves "+
+			"them. Takes precedence over \"--nodeport-bindon-all-ip\" and \"--nodeport-interface\" when set.")
+	fs.StringSliceVar(&s.NodePortAllowedCIDRs, "nodeport-allowed-cidrs", s.NodePortAllowedCIDRs,
+		"Client CIDRs that are allowed to reach NodePort services, traffic from other clients is dropped. Can be "+
+			"overridden per service with the kube-router.io/service.nodeport.allowed-cidrs annotation. Defaults to "+
+			"allowing all clients.")
 	fs.BoolVar(&s.NodePortBindOnAllIP, "nodeport-bindon-all-ip", false,
 		"For service of NodePort type create IPVS service that listens on all IP's of the node.")
+	fs.StringVar(&s.NodePortInterface, "nodeport-interface", "",
+		"Interface (or IP) of the node whose address serves NodePort services, unless "+
+			"\"--nodeport-bindon-all-ip\" is set. Can be overridden per node with the "+
+			"kube-router.io/nodeport-interface annotation. Defaults to the node IP.")
 	fs.BoolVar(&s.FullMeshMode, "nodes-full-mesh", true,
 		"Each node in the cluster will setup BGP peering with rest of the nodes.")
+	fs.StringSliceVar(&s.NoTrackCIDRs, "notrack-cidrs", s.NoTrackCIDRs,
+		"CIDRs whose traffic, to and from them, is exempted from connection tracking and NAT (e.g. high packet rate "+
+			"workloads). Untracked traffic is allowed by the network policies of the pods.")
+	fs.StringSliceVar(&s.NoTrackPorts, "notrack-ports", s.NoTrackPorts,
+		"Ports whose traffic, to and from them, is exempted from connection tracking and NAT, as protocol:port or "+
+			"protocol:first-last (e.g. udp:5000-5010). Untracked traffic is allowed by the network policies of the "+
+			"pods.")
+	fs.StringVar(&s.OverlayEncapsulation, "overlay-encapsulation", s.OverlayEncapsulation,
+		"The encapsulation of the pod traffic tunneled across nodes with --enable-overlay, ipip, vxlan or geneve "+
+			"(UDP encapsulations, for networks that drop IPIP) or wireguard (encrypted with keys exchanged through "+
+			"the kube-router.io/wireguard-public-key node annotation).")
+	fs.StringVar(&s.OverlayInterface, "overlay-interface", "",
+		"Interface (or IP) of the node whose address is used as the endpoint of the overlay tunnels and as the next "+
+			"hop of the node's pod CIDR routes. Can be overridden per node with the "+
+			"kube-router.io/overlay-interface annotation. Defaults to the node IP.")
+	fs.DurationVar(&s.OverlayMTUProbePeriod, "overlay-mtu-probe-period", s.OverlayMTUProbePeriod,
+		"How often to probe the path MTU to the nodes the overlay tunnels to with unfragmentable pings, "+
+			"lowering the MTU of the overlay routes to a node when full-size pings get dropped along the path "+
+			"without an ICMP error (a PMTU blackhole). Needs --auto-mtu. 0 disables the probes.")
 	fs.StringVar(&s.OverlayType, "overlay-type", s.OverlayType,
 		"Possible values: subnet,full - "+
 			"When set to \"subnet\", the default, default \"--enable-overlay=true\" behavior is used. "+
@@ -185,25 +669,97 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"routes sent to peers with the local ip.")
 	fs.UintSliceVar(&s.PeerASNs, "peer-router-asns", s.PeerASNs,
 		"ASN numbers of the BGP peer to which cluster nodes will advertise cluster ip and node's pod cidr.")
+	fs.BoolVar(&s.PeerBFD, "peer-router-bfd", false,
+		"Runs a BFD session (RFC 5880) with each of the external BGP peers, the BGP session with a peer is shut "+
+			"down as soon as its BFD session goes down so that the routes through it are withdrawn in less than "+
+			"a second instead of at the expiry of the BGP hold time.")
+	fs.DurationVar(&s.PeerBFDInterval, "peer-router-bfd-interval", s.PeerBFDInterval,
+		"The interval at which BFD control packets are sent to and expected from the external BGP peers.")
+	fs.Uint8Var(&s.PeerBFDMultiplier, "peer-router-bfd-multiplier", s.PeerBFDMultiplier,
+		"The number of BFD control packets that can be missed before the BFD session with an external BGP peer "+
+			"is declared down.")
+	fs.BoolVar(&s.PeerECMP, "peer-router-ecmp", false,
+		"Installs the routes learned from several external BGP peers with equal cost (e.g. the two ToR "+
+			"switches of a dual-homed node) as ECMP routes across all of them, instead of only through the best "+
+			"one.")
+	fs.DurationSliceVar(&s.PeerGracefulRestartTimes, "peer-router-graceful-restart-times",
+		s.PeerGracefulRestartTimes,
+		"The BGP Graceful restart time of each external BGP peer defined with \"--peer-router-ips\". 0 or no "+
+			"value means \"--bgp-graceful-restart-time\".")
+	fs.UintSliceVar(&s.PeerInterfaceASNs, "peer-router-interface-asns", s.PeerInterfaceASNs,
+		"ASN numbers of the unnumbered BGP peers on the other end of the interfaces defined with "+
+			"\"--peer-router-interfaces\".")
+	fs.StringSliceVar(&s.PeerInterfaces, "peer-router-interfaces", s.PeerInterfaces,
+		"Interfaces over which the nodes peer with the router on their other end without any configured "+
+			"address (BGP unnumbered), using the IPv6 link-local addresses of the interface and the router. The "+
+			"IPv4 routes are exchanged over the session as well, with an IPv6 next hop (RFC 5549).")
 	fs.IPSliceVar(&s.PeerRouters, "peer-router-ips", s.PeerRouters,
 		"The ip address of the external router to which all nodes will peer and advertise the cluster ip and "+
 			"pod cidr's.")
+	fs.StringSliceVar(&s.PeerListenRangeASNs, "peer-router-listen-range-asns", s.PeerListenRangeASNs,
+		"The ASNs the routers of each range of \"--peer-router-listen-ranges\" may have, either a single ASN or a "+
+			"range of ASNs like 65000-65099.")
+	fs.StringSliceVar(&s.PeerListenRanges, "peer-router-listen-ranges", s.PeerListenRanges,
+		"Prefixes from which the nodes accept BGP sessions from any external router (dynamic neighbors), "+
+			"without the routers being configured one by one. The nodes don't initiate these sessions.")
+	fs.DurationSliceVar(&s.PeerLongLivedStaleTimes, "peer-router-long-lived-stale-times", s.PeerLongLivedStaleTimes,
+		"The Long-Lived Graceful Restart stale time of each external BGP peer defined with \"--peer-router-ips\". "+
+			"0 or no value means \"--bgp-long-lived-stale-time\".")
+	fs.UintSliceVar(&s.PeerMaxPrefixes, "peer-router-max-prefixes", s.PeerMaxPrefixes,
+		"The maximum number of prefixes accepted from each external BGP peer defined with \"--peer-router-ips\". "+
+			"The session with a peer is torn down when it advertises more. 0 or no value means unlimited.")
+	fs.DurationVar(&s.PeerMaxPrefixesRestartTime, "peer-router-max-prefixes-restart-time",
+		s.PeerMaxPrefixesRestartTime,
+		"How long the session with an external BGP peer stays down after being torn down for exceeding its "+
+			"maximum number of prefixes. When 0 the session is retried right away.")
+	fs.Uint32Var(&s.PeerMaxPrefixesWarningPct, "peer-router-max-prefixes-warning-pct", s.PeerMaxPrefixesWarningPct,
+		"The percentage of the maximum number of prefixes of an external BGP peer from which a warning is logged. "+
+			"0 disables the warning.")
 	fs.Uint8Var(&s.PeerMultihopTTL, "peer-router-multihop-ttl", s.PeerMultihopTTL,
 		"Enable eBGP multihop supports -- sets multihop-ttl. (Relevant only if ttl >= 2)")
+	fs.UintSliceVar(&s.PeerMultihopTTLs, "peer-router-multihop-ttls", s.PeerMultihopTTLs,
+		"The eBGP multihop TTL of each external BGP peer defined with \"--peer-router-ips\". Use 0 for a peer "+
+			"that uses the TTL of \"--peer-router-multihop-ttl\".")
 	fs.StringSliceVar(&s.PeerPasswords, "peer-router-passwords", s.PeerPasswords,
 		"Password for authenticating against the BGP peer defined with \"--peer-router-ips\".")
+	fs.StringSliceVar(&s.PeerPasswordSecrets, "peer-router-password-secrets", s.PeerPasswordSecrets,
+		"The namespace/name[/key] of the Secret holding the password of each BGP peer defined with "+
+			"\"--peer-router-ips\", the key defaults to password. Leave it empty for the peers without one. "+
+			"Changed passwords are applied on the next sync.")
 	fs.StringVar(&s.PeerPasswordsFile, "peer-router-passwords-file", s.PeerPasswordsFile,
 		"Path to file containing password for authenticating against the BGP peer defined with "+
 			"\"--peer-router-ips\". --peer-router-passwords will be preferred if both are set.")
 	fs.UintSliceVar(&s.PeerPorts, "peer-router-ports", s.PeerPorts,
 		"The remote port of the external BGP to which all nodes will peer. If not set, default BGP "+
 			"port ("+strconv.Itoa(DefaultBgpPort)+") will be used.")
+	fs.UintSliceVar(&s.PeerTTLSecurityHops, "peer-router-ttl-security-hops", s.PeerTTLSecurityHops,
+		"The number of hops to each external BGP peer defined with \"--peer-router-ips\" for the Generalized TTL "+
+			"Security Mechanism (GTSM, RFC 5082), which drops the BGP packets of the peer that have made more hops. "+
+			"Use 0 for a peer without GTSM. Can't be combined with eBGP multihop for the same peer.")
+	fs.StringVar(&s.PodCIDRSource, "pod-cidr-source", s.PodCIDRSource,
+		"Where the pod CIDRs of this node are taken from, one of "+PodCIDRSourceNode+", "+
+			PodCIDRSourceAWSMetadata+", "+PodCIDRSourceGCEMetadata+" or "+PodCIDRSourceAnnotation+". With any "+
+			"source other than "+PodCIDRSourceNode+" the CIDRs are published in the kube-router.io/pod-cidrs "+
+			"annotation of the node on startup.")
+	fs.StringVar(&s.PodCIDRSourceAnnotation, "pod-cidr-source-annotation", "",
+		"Node annotation holding the comma separated pod CIDRs of the node when \"--pod-cidr-source="+
+			PodCIDRSourceAnnotation+"\", e.g. one maintained by another IPAM.")
 	fs.StringVar(&s.RouterID, "router-id", "", "BGP router-id. Must be specified in a ipv6 only "+
 		"cluster.")
 	fs.DurationVar(&s.RoutesSyncPeriod, "routes-sync-period", s.RoutesSyncPeriod,
 		"The delay between route updates and advertisements (e.g. '5s', '1m', '2h22m'). Must be greater than 0.")
+	fs.StringSliceVar(&s.RPKICaches, "rpki-caches", s.RPKICaches,
+		"The host:port of the RPKI caches the routes learned from the external BGP peers are validated against "+
+			"with the RPKI to Router protocol. The origin of the routes isn't validated without caches.")
+	fs.StringVar(&s.RPKIInvalidAction, "rpki-invalid-action", s.RPKIInvalidAction,
+		"What is done with the routes of the external BGP peers the RPKI caches find invalid, one of "+
+			RPKIInvalidActionReject+", "+RPKIInvalidActionDepreference+" or "+RPKIInvalidActionAccept+". Can be "+
+			"overridden per peer by the rpkiInvalidAction of the BGPPeer resources.")
 	fs.BoolVar(&s.RunFirewall, "run-firewall", true,
 		"Enables Network Policy -- sets up iptables to provide ingress firewall for pods.")
+	fs.BoolVar(&s.RunLoadBalancer, "run-loadbalancer", false,
+		"Allocate the LoadBalancer IPs of the services from the pools of --loadbalancer-ip-range, by the elected "+
+			"kube-router instance. Advertise them with --advertise-loadbalancer-ip.")
 	fs.BoolVar(&s.RunRouter, "run-router", true,
 		"Enables Pod Networking -- Advertises and learns the routes to Pods via iBGP.")
 	fs.BoolVar(&s.RunServiceProxy, "run-service-proxy", true,
@@ -212,13 +768,37 @@ func (s *KubeRouterConfig) AddFlags(fs *pflag.FlagSet) {
 		"Path to CRI compatible container runtime socket (used for DSR mode). Currently known working with "+
 			"containerd.")
 	fs.StringVar(&s.ClusterIPCIDR, "service-cluster-ip-range", s.ClusterIPCIDR,
-		"CIDR value from which service cluster IPs are assigned. Default: 10.96.0.0/12")
+		"CIDR value from which service cluster IPs are assigned, on dual-stack clusters the IPv4 and the IPv6 CIDR "+
+			"separated by a comma. Default: 10.96.0.0/12")
 	fs.StringSliceVar(&s.ExternalIPCIDRs, "service-external-ip-range", s.ExternalIPCIDRs,
 		"Specify external IP CIDRs that are used for inter-cluster communication "+
 			"(can be specified multiple times)")
 	fs.StringVar(&s.NodePortRange, "service-node-port-range", s.NodePortRange,
 		"NodePort range specified with either a hyphen or colon")
+	fs.BoolVar(&s.SkipKernelModuleCheck, "skip-kernel-module-check", false,
+		"Skip verifying the kernel configuration options and the kernel modules (loading the missing ones) required "+
+			"by the enabled functionality at startup.")
+	fs.DurationVar(&s.SysctlSyncPeriod, "sysctl-sync-period", s.SysctlSyncPeriod,
+		"The delay between checks of the managed sysctls for drift (e.g. '5s', '1m'). Must be greater than 0.")
+	fs.StringToIntVar(&s.Sysctls, "sysctls", s.Sysctls,
+		"Sysctls to manage on the node, either overriding the values kube-router sets or in addition to them "+
+			"(e.g. net.netfilter.nf_conntrack_max=262144).")
+	fs.StringVar(&s.TracingEndpoint, "tracing-endpoint", s.TracingEndpoint,
+		"The OTLP gRPC endpoint (host:port) the OpenTelemetry spans of the syncs of the network policy, service "+
+			"proxy and routing controllers are exported to. Tracing is disabled when empty.")
+	fs.BoolVar(&s.TracingInsecure, "tracing-insecure", false,
+		"Export the spans to --tracing-endpoint without TLS.")
 	fs.StringVarP(&s.VLevel, "v", "v", "0", "log level for V logs")
 	fs.BoolVarP(&s.Version, "version", "V", false,
 		"Print version information.")
+	fs.StringVar(&s.VModule, "vmodule", s.VModule,
+		"Comma-separated list of pattern=N settings of the log level of the V logs of the files matching the "+
+			"pattern (e.g. bgp_peers=4,policy*=3).")
+	fs.Uint16Var(&s.VXLANPort, "vxlan-port", s.VXLANPort,
+		"The UDP port the VXLAN overlay sends to and listens on with --overlay-encapsulation=vxlan.")
+	fs.Uint16Var(&s.WireGuardPort, "wireguard-port", s.WireGuardPort,
+		"The UDP port the WireGuard overlay listens on with --overlay-encapsulation=wireguard.")
+	fs.StringVar(&s.WireGuardPrivateKeyFile, "wireguard-private-key-file", s.WireGuardPrivateKeyFile,
+		"The file holding the WireGuard private key of the node, generated when it doesn't exist. It must persist "+
+			"across restarts for the other nodes to keep the public key of the node.")
 }
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudnativelabs/kube-router/pkg/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+const (
+	serviceName         = "kube-router"
+	instrumentationName = "github.com/cloudnativelabs/kube-router/pkg/controllers/"
+)
+
+// Tracer returns the tracer the controller creates the spans of its syncs with. The spans are no-ops until Setup
+// sets up the exporter.
+func Tracer(controller string) trace.Tracer {
+	return otel.Tracer(instrumentationName + controller)
+}
+
+// Setup exports the spans of the controllers to the OTLP gRPC endpoint, with the host name and the version of
+// kube-router as resource attributes. The returned function exports the pending spans and stops the exporter.
+func Setup(ctx context.Context, endpoint string, insecure bool) (func(context.Context) error, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+	}
+	res, err := resource.New(ctx, resource.WithHost(), resource.WithAttributes(
+		semconv.ServiceName(serviceName), semconv.ServiceVersion(version.Version)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect the tracing resource: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		klog.Errorf("Failed to export spans: %v", err)
+	}))
+	return provider.Shutdown, nil
+}
+
+// End ends the span of a phase of a sync, recording the error the phase failed with if any
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_End(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	ctx, sync := tracer.Start(context.Background(), "sync")
+	_, ok := tracer.Start(ctx, "phase that succeeded")
+	End(ok, nil)
+	_, failed := tracer.Start(ctx, "phase that failed")
+	End(failed, errors.New("iptables-restore failed"))
+	sync.End()
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Empty(t, spans[0].Events())
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "iptables-restore failed", spans[1].Status().Description)
+	if assert.Len(t, spans[1].Events(), 1) {
+		assert.Equal(t, "exception", spans[1].Events()[0].Name)
+	}
+}
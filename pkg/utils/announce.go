@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ethernetHeaderLength = 14
+	arpPacketLength      = 28
+	arpHardwareEthernet  = 1
+	arpOperationRequest  = 1
+	ipv6AllNodesAddress  = "ff02::1"
+	// Router (R), Solicited (S) and Override (O) flags are the top three bits of the first NA byte, per RFC 4861 4.4
+	naOverrideFlag = 0x20
+	// Target link-layer address option type, per RFC 4861 4.6.1
+	ndpOptionTargetLinkLayerAddress = 2
+	// Neighbor Discovery messages must be sent with a hop limit of 255, per RFC 4861 7.1.2
+	ndpHopLimit = 255
+)
+
+// htons converts a short (uint16) from host byte order to network byte order, the bytes of the value in network byte
+// order are read back in host byte order so that it is a no-op on big-endian hosts
+func htons(i uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, i)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+// buildGratuitousARP builds an ethernet frame containing a gratuitous ARP request which announces that the given IP can
+// be reached at the given hardware address
+func buildGratuitousARP(hwAddr net.HardwareAddr, ip net.IP) []byte {
+	frame := make([]byte, ethernetHeaderLength+arpPacketLength)
+
+	// Ethernet header: broadcast destination, our source and the ARP ether type
+	copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], hwAddr)
+	binary.BigEndian.PutUint16(frame[12:14], unix.ETH_P_ARP)
+
+	// ARP payload, a gratuitous ARP has the same sender and target protocol address
+	arp := frame[ethernetHeaderLength:]
+	binary.BigEndian.PutUint16(arp[0:2], arpHardwareEthernet)
+	binary.BigEndian.PutUint16(arp[2:4], unix.ETH_P_IP)
+	arp[4] = 6 // hardware address length
+	arp[5] = 4 // protocol address length
+	binary.BigEndian.PutUint16(arp[6:8], arpOperationRequest)
+	copy(arp[8:14], hwAddr)
+	copy(arp[14:18], ip.To4())
+	copy(arp[18:24], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(arp[24:28], ip.To4())
+
+	return frame
+}
+
+// buildUnsolicitedNeighborAdvertisement builds the body of an unsolicited ICMPv6 neighbor advertisement with the
+// override flag set, which tells neighbors to update their caches for the target IP with the given hardware address
+func buildUnsolicitedNeighborAdvertisement(hwAddr net.HardwareAddr, ip net.IP) ([]byte, error) {
+	//nolint:gomnd // the layout of this message is defined in RFC 4861 4.4
+	body := make([]byte, 4+net.IPv6len+2+len(hwAddr))
+	body[0] = naOverrideFlag
+	copy(body[4:4+net.IPv6len], ip.To16())
+	opt := body[4+net.IPv6len:]
+	opt[0] = ndpOptionTargetLinkLayerAddress
+	opt[1] = byte(len(opt) / 8) //nolint:gomnd // option length is expressed in units of 8 octets
+	copy(opt[2:], hwAddr)
+
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborAdvertisement,
+		Code: 0,
+		Body: &icmp.RawBody{Data: body},
+	}
+	// the checksum is filled in by the kernel for ICMPv6 raw sockets
+	return msg.Marshal(nil)
+}
+
+// SendGratuitousARP broadcasts a gratuitous ARP for the given IPv4 address on the given interface so that L2 neighbors
+// immediately update their ARP caches instead of waiting for the old entry to expire
+func SendGratuitousARP(iface *net.Interface, ip net.IP) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+	if len(iface.HardwareAddr) != 6 { //nolint:gomnd // length of an ethernet MAC address
+		return fmt.Errorf("interface %s does not have an ethernet hardware address", iface.Name)
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return fmt.Errorf("failed to open raw socket: %v", err)
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	addr := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    6, //nolint:gomnd // length of an ethernet MAC address
+	}
+	copy(addr.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	if err = unix.Sendto(fd, buildGratuitousARP(iface.HardwareAddr, ip), 0, addr); err != nil {
+		return fmt.Errorf("failed to send gratuitous ARP for %s on %s: %v", ip, iface.Name, err)
+	}
+	return nil
+}
+
+// SendUnsolicitedNeighborAdvertisement sends an unsolicited neighbor advertisement for the given IPv6 address to all
+// nodes on the given interface, which is the IPv6 equivalent of a gratuitous ARP
+func SendUnsolicitedNeighborAdvertisement(iface *net.Interface, ip net.IP) error {
+	if ip.To4() != nil || ip.To16() == nil {
+		return fmt.Errorf("%s is not an IPv6 address", ip)
+	}
+	if len(iface.HardwareAddr) == 0 {
+		return fmt.Errorf("interface %s does not have a hardware address", iface.Name)
+	}
+
+	msg, err := buildUnsolicitedNeighborAdvertisement(iface.HardwareAddr, ip)
+	if err != nil {
+		return fmt.Errorf("failed to build neighbor advertisement: %v", err)
+	}
+
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return fmt.Errorf("failed to open ICMPv6 socket: %v", err)
+	}
+	defer CloseCloserDisregardError(conn)
+
+	pc := conn.IPv6PacketConn()
+	if err = pc.SetMulticastInterface(iface); err != nil {
+		return fmt.Errorf("failed to set multicast interface %s: %v", iface.Name, err)
+	}
+	if err = pc.SetMulticastHopLimit(ndpHopLimit); err != nil {
+		return fmt.Errorf("failed to set hop limit: %v", err)
+	}
+
+	dst := &net.IPAddr{IP: net.ParseIP(ipv6AllNodesAddress), Zone: iface.Name}
+	if _, err = pc.WriteTo(msg, nil, dst); err != nil {
+		return fmt.Errorf("failed to send neighbor advertisement for %s on %s: %v", ip, iface.Name, err)
+	}
+	return nil
+}
+
+// AnnounceIP sends a gratuitous ARP (IPv4) or an unsolicited neighbor advertisement (IPv6) for the given IP on the
+// named interface
+func AnnounceIP(ifaceName string, ip net.IP) error {
+	if ip == nil {
+		return errors.New("no IP given to announce")
+	}
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %v", ifaceName, err)
+	}
+	if ip.To4() != nil {
+		return SendGratuitousARP(iface, ip)
+	}
+	return SendUnsolicitedNeighborAdvertisement(iface, ip)
+}
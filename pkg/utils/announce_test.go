@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"unsafe"
+)
+
+func Test_buildGratuitousARP(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:42:ac:11:00:02")
+	frame := buildGratuitousARP(hwAddr, net.ParseIP("10.0.0.10"))
+
+	expected := []byte{
+		// ethernet header
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x42, 0xac, 0x11, 0x00, 0x02, 0x08, 0x06,
+		// ARP request, ethernet / IPv4
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04, 0x00, 0x01,
+		// sender hardware and protocol address
+		0x02, 0x42, 0xac, 0x11, 0x00, 0x02, 10, 0, 0, 10,
+		// target hardware and protocol address
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 10, 0, 0, 10,
+	}
+	if !bytes.Equal(frame, expected) {
+		t.Errorf("unexpected gratuitous ARP frame:\nexpected: %x\ngot:      %x", expected, frame)
+	}
+}
+
+func Test_buildUnsolicitedNeighborAdvertisement(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:42:ac:11:00:02")
+	ip := net.ParseIP("2001:db8::10")
+	msg, err := buildUnsolicitedNeighborAdvertisement(hwAddr, ip)
+	if err != nil {
+		t.Fatalf("failed to build neighbor advertisement: %v", err)
+	}
+
+	// type, code, checksum (left for the kernel), flags + reserved, target, target link-layer address option
+	if len(msg) != 4+4+net.IPv6len+8 {
+		t.Fatalf("unexpected neighbor advertisement length: %d", len(msg))
+	}
+	if msg[0] != 136 || msg[1] != 0 {
+		t.Errorf("expected ICMPv6 type 136 code 0, got type %d code %d", msg[0], msg[1])
+	}
+	if msg[4] != naOverrideFlag {
+		t.Errorf("expected only the override flag to be set, got %#x", msg[4])
+	}
+	if !net.IP(msg[8:24]).Equal(ip) {
+		t.Errorf("expected target %s, got %s", ip, net.IP(msg[8:24]))
+	}
+	if !bytes.Equal(msg[24:], []byte{2, 1, 0x02, 0x42, 0xac, 0x11, 0x00, 0x02}) {
+		t.Errorf("unexpected target link-layer address option: %x", msg[24:])
+	}
+}
+
+func Test_htons(t *testing.T) {
+	value := htons(0x0806)
+	// the value must be laid out in memory in network byte order, whatever the byte order of the host
+	memory := (*[2]byte)(unsafe.Pointer(&value))
+	if !bytes.Equal(memory[:], []byte{0x08, 0x06}) {
+		t.Errorf("expected htons(0x0806) to be stored as 0806, got %x", memory[:])
+	}
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	v1core "k8s.io/api/core/v1"
+)
+
+// ConntrackFlowStats aggregates the conntrack flows of a service or a pod
+type ConntrackFlowStats struct {
+	Flows int
+	// LongLivedFlows are the flows older than the long lived age, and LongLivedBytes the bytes they carried in both
+	// directions. Flow ages are only known with nf_conntrack_timestamp enabled and the byte counts with
+	// nf_conntrack_acct, the flows set up before those were enabled don't have them either.
+	LongLivedFlows int
+	LongLivedBytes uint64
+	OldestFlowAge  time.Duration
+}
+
+func (s *ConntrackFlowStats) add(flow *netlink.ConntrackFlow, age, longLivedAge time.Duration) {
+	s.Flows++
+	if age > s.OldestFlowAge {
+		s.OldestFlowAge = age
+	}
+	if age >= longLivedAge && age > 0 {
+		s.LongLivedFlows++
+		s.LongLivedBytes += flow.Forward.Bytes + flow.Reverse.Bytes
+	}
+}
+
+// ConntrackFlowAge returns the age of the flow, or zero when it isn't timestamped
+func ConntrackFlowAge(flow *netlink.ConntrackFlow, now time.Time) time.Duration {
+	if flow.TimeStart == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, int64(flow.TimeStart)))
+}
+
+// ConntrackFrontendKey identifies a frontend of a service, an IP, port and protocol the clients send their traffic to
+func ConntrackFrontendKey(ip string, port int, protocol string) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port)) + "/" + strings.ToLower(protocol)
+}
+
+func conntrackFlowProtocol(flow *netlink.ConntrackFlow) string {
+	switch flow.Forward.Protocol {
+	case unix.IPPROTO_TCP:
+		return "tcp"
+	case unix.IPPROTO_UDP:
+		return "udp"
+	case unix.IPPROTO_SCTP:
+		return "sctp"
+	}
+	return ""
+}
+
+// ConntrackServiceFrontends maps the frontends of the services, their cluster, external and load balancer IPs and the
+// node ports on the node IP, to the namespace/name of the services
+func ConntrackServiceFrontends(services []*v1core.Service, nodeIP net.IP) map[string]string {
+	frontends := make(map[string]string)
+	for _, svc := range services {
+		if ServiceIsHeadless(svc) {
+			continue
+		}
+		name := svc.Namespace + "/" + svc.Name
+		ips := make([]string, 0)
+		ips = append(ips, svc.Spec.ClusterIPs...)
+		ips = append(ips, svc.Spec.ExternalIPs...)
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				ips = append(ips, ingress.IP)
+			}
+		}
+		for _, port := range svc.Spec.Ports {
+			for _, ip := range ips {
+				frontends[ConntrackFrontendKey(ip, int(port.Port), string(port.Protocol))] = name
+			}
+			if port.NodePort != 0 && nodeIP != nil {
+				frontends[ConntrackFrontendKey(nodeIP.String(), int(port.NodePort), string(port.Protocol))] = name
+			}
+		}
+	}
+	return frontends
+}
+
+// ConntrackPodIPs maps the IPs of the pods that aren't on the host network to the namespace/name of the pods
+func ConntrackPodIPs(pods []*v1core.Pod) map[string]string {
+	podIPs := make(map[string]string)
+	for _, pod := range pods {
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			podIPs[podIP.IP] = pod.Namespace + "/" + pod.Name
+		}
+	}
+	return podIPs
+}
+
+// AggregateConntrackFlows aggregates the flows per service, by the frontend of the service the flows were sent to, and
+// per pod, by the pod at either end of the flows: the client in the original direction or the endpoint the flow was
+// load balanced to in the reply direction
+func AggregateConntrackFlows(flows []*netlink.ConntrackFlow, frontends, podIPs map[string]string,
+	longLivedAge time.Duration, now time.Time) (map[string]*ConntrackFlowStats, map[string]*ConntrackFlowStats) {
+	serviceStats := make(map[string]*ConntrackFlowStats)
+	podStats := make(map[string]*ConntrackFlowStats)
+	addTo := func(stats map[string]*ConntrackFlowStats, name string, flow *netlink.ConntrackFlow, age time.Duration) {
+		if _, ok := stats[name]; !ok {
+			stats[name] = &ConntrackFlowStats{}
+		}
+		stats[name].add(flow, age, longLivedAge)
+	}
+
+	for _, flow := range flows {
+		age := ConntrackFlowAge(flow, now)
+		frontend := ConntrackFrontendKey(flow.Forward.DstIP.String(), int(flow.Forward.DstPort),
+			conntrackFlowProtocol(flow))
+		if service, ok := frontends[frontend]; ok {
+			addTo(serviceStats, service, flow, age)
+		}
+
+		client, hasClient := podIPs[flow.Forward.SrcIP.String()]
+		if hasClient {
+			addTo(podStats, client, flow, age)
+		}
+		if endpoint, ok := podIPs[flow.Reverse.SrcIP.String()]; ok && (!hasClient || endpoint != client) {
+			addTo(podStats, endpoint, flow, age)
+		}
+	}
+	return serviceStats, podStats
+}
+
+// ListConntrackFlows lists the conntrack flows of both address families
+func ListConntrackFlows() ([]*netlink.ConntrackFlow, error) {
+	flows := make([]*netlink.ConntrackFlow, 0)
+	for _, family := range []netlink.InetFamily{unix.AF_INET, unix.AF_INET6} {
+		familyFlows, err := netlink.ConntrackTableList(netlink.ConntrackTable, family)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list conntrack flows: %v", err)
+		}
+		flows = append(flows, familyFlows...)
+	}
+	return flows, nil
+}
+
+// udpConntrackFilter returns the filter matching the UDP flows to the port of the address, only those of them answered
+// by the endpoint when one is given
+func udpConntrackFilter(address net.IP, port uint16, endpoint net.IP) (*netlink.ConntrackFilter, error) {
+	filter := &netlink.ConntrackFilter{}
+	if err := filter.AddProtocol(unix.IPPROTO_UDP); err != nil {
+		return nil, err
+	}
+	if err := filter.AddIP(netlink.ConntrackOrigDstIP, address); err != nil {
+		return nil, err
+	}
+	if err := filter.AddPort(netlink.ConntrackOrigDstPort, port); err != nil {
+		return nil, err
+	}
+	if endpoint != nil {
+		if err := filter.AddIP(netlink.ConntrackReplySrcIP, endpoint); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// DeleteUDPConntrackFlows deletes the conntrack flows of the UDP datagrams sent to the port of the address, only those
+// of them answered by the endpoint when one is given, and returns how many flows were deleted
+func DeleteUDPConntrackFlows(address net.IP, port uint16, endpoint net.IP) (uint, error) {
+	filter, err := udpConntrackFilter(address, port, endpoint)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build the conntrack filter of %s:%d: %v", address, port, err)
+	}
+	family := netlink.InetFamily(unix.AF_INET6)
+	if address.To4() != nil {
+		family = unix.AF_INET
+	}
+	deleted, err := netlink.ConntrackDeleteFilter(netlink.ConntrackTable, family, filter)
+	if err != nil {
+		return deleted, fmt.Errorf("failed to delete the conntrack flows of %s:%d: %v", address, port, err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestConntrackFlow(src, dst, replySrc string, dport uint16, start time.Time, bytes uint64) *netlink.ConntrackFlow {
+	flow := &netlink.ConntrackFlow{TimeStart: uint64(start.UnixNano())}
+	flow.Forward.Protocol = unix.IPPROTO_TCP
+	flow.Forward.SrcIP = net.ParseIP(src)
+	flow.Forward.DstIP = net.ParseIP(dst)
+	flow.Forward.DstPort = dport
+	flow.Forward.Bytes = bytes
+	flow.Reverse.SrcIP = net.ParseIP(replySrc)
+	flow.Reverse.Bytes = bytes
+	return flow
+}
+
+func Test_ConntrackServiceFrontends(t *testing.T) {
+	services := []*apiv1.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: apiv1.ServiceSpec{
+				ClusterIPs:  []string{"10.96.0.10"},
+				ExternalIPs: []string{"1.1.1.1"},
+				Ports:       []apiv1.ServicePort{{Port: 80, NodePort: 30080, Protocol: apiv1.ProtocolTCP}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "headless", Namespace: "default"},
+			Spec: apiv1.ServiceSpec{
+				Type:       apiv1.ServiceTypeClusterIP,
+				ClusterIP:  "None",
+				ClusterIPs: []string{"None"},
+				Ports:      []apiv1.ServicePort{{Port: 80, Protocol: apiv1.ProtocolTCP}},
+			},
+		},
+	}
+
+	expected := map[string]string{
+		"10.96.0.10:80/tcp":     "default/web",
+		"1.1.1.1:80/tcp":        "default/web",
+		"192.168.1.1:30080/tcp": "default/web",
+	}
+	frontends := ConntrackServiceFrontends(services, net.ParseIP("192.168.1.1"))
+	if !reflect.DeepEqual(frontends, expected) {
+		t.Errorf("expected frontends %v, got %v", expected, frontends)
+	}
+}
+
+func Test_AggregateConntrackFlows(t *testing.T) {
+	now := time.Now()
+	frontends := map[string]string{"10.96.0.10:80/tcp": "default/web"}
+	podIPs := map[string]string{"10.1.1.1": "default/client", "10.1.2.1": "default/web-1"}
+	flows := []*netlink.ConntrackFlow{
+		// long-lived flow from a client pod load balanced to a pod of the service
+		newTestConntrackFlow("10.1.1.1", "10.96.0.10", "10.1.2.1", 80, now.Add(-2*time.Hour), 100),
+		// short-lived flow from outside the cluster to the service
+		newTestConntrackFlow("192.168.1.100", "10.96.0.10", "10.1.2.1", 80, now.Add(-time.Minute), 10),
+		// flow without timestamp from the client pod to outside the cluster
+		newTestConntrackFlow("10.1.1.1", "8.8.8.8", "8.8.8.8", 53, time.Unix(0, 0), 10),
+	}
+	flows[2].TimeStart = 0
+
+	serviceStats, podStats := AggregateConntrackFlows(flows, frontends, podIPs, time.Hour, now)
+
+	expectedServiceStats := map[string]*ConntrackFlowStats{
+		"default/web": {Flows: 2, LongLivedFlows: 1, LongLivedBytes: 200, OldestFlowAge: 2 * time.Hour},
+	}
+	if !reflect.DeepEqual(serviceStats, expectedServiceStats) {
+		t.Errorf("expected service stats %+v, got %+v", expectedServiceStats["default/web"], serviceStats["default/web"])
+	}
+	expectedPodStats := map[string]*ConntrackFlowStats{
+		"default/client": {Flows: 2, LongLivedFlows: 1, LongLivedBytes: 200, OldestFlowAge: 2 * time.Hour},
+		"default/web-1":  {Flows: 2, LongLivedFlows: 1, LongLivedBytes: 200, OldestFlowAge: 2 * time.Hour},
+	}
+	if !reflect.DeepEqual(podStats, expectedPodStats) {
+		for name, stats := range podStats {
+			t.Logf("pod %s: %+v", name, stats)
+		}
+		t.Error("did not get expected pod stats")
+	}
+}
+
+func Test_udpConntrackFilter(t *testing.T) {
+	udpFlow := func(dst, replySrc string, dport uint16) *netlink.ConntrackFlow {
+		flow := newTestConntrackFlow("192.168.1.5", dst, replySrc, dport, time.Now(), 0)
+		flow.Forward.Protocol = unix.IPPROTO_UDP
+		return flow
+	}
+
+	filter, err := udpConntrackFilter(net.ParseIP("10.96.0.10"), 53, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.MatchConntrackFlow(udpFlow("10.96.0.10", "10.1.0.5", 53)) {
+		t.Error("expected the UDP flow to the service to match")
+	}
+	if filter.MatchConntrackFlow(udpFlow("10.96.0.10", "10.1.0.5", 54)) {
+		t.Error("expected the UDP flow to another port not to match")
+	}
+	if filter.MatchConntrackFlow(newTestConntrackFlow("192.168.1.5", "10.96.0.10", "10.1.0.5", 53, time.Now(), 0)) {
+		t.Error("expected the TCP flow to the service not to match")
+	}
+
+	filter, err = udpConntrackFilter(net.ParseIP("10.96.0.10"), 53, net.ParseIP("10.1.0.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.MatchConntrackFlow(udpFlow("10.96.0.10", "10.1.0.5", 53)) {
+		t.Error("expected the UDP flow answered by the endpoint to match")
+	}
+	if filter.MatchConntrackFlow(udpFlow("10.96.0.10", "10.1.0.6", 53)) {
+		t.Error("expected the UDP flow answered by another endpoint not to match")
+	}
+}
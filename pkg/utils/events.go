@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"fmt"
+
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventSourceComponent = "kube-router"
+	// eventMessageLimit is the length the messages of the Events are truncated to, the API server rejects the Events
+	// with longer messages
+	eventMessageLimit = 1024
+
+	// IptablesSyncFailedEventReason is the reason of the Events about iptables rules that couldn't be programmed
+	IptablesSyncFailedEventReason = "IptablesSyncFailed"
+)
+
+// NewEventRecorder returns an EventRecorder which emits Kubernetes Events from kube-router on the given node
+func NewEventRecorder(clientset kubernetes.Interface, nodeName string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1core.EventSource{Component: eventSourceComponent, Host: nodeName})
+}
+
+// NodeObjectReference returns a reference to the node that Events about the node can be recorded against. Like the
+// kubelet the node name is used as UID, so that the Events show up when describing the node.
+func NodeObjectReference(nodeName string) *v1core.ObjectReference {
+	return &v1core.ObjectReference{Kind: "Node", Name: nodeName, UID: types.UID(nodeName)}
+}
+
+// RecordNodeWarning records a Warning Event on the node, truncating the message to the length the API server accepts.
+// Nothing is recorded without a recorder.
+func RecordNodeWarning(recorder record.EventRecorder, nodeName, reason, messageFmt string, args ...interface{}) {
+	if recorder == nil {
+		return
+	}
+	message := fmt.Sprintf(messageFmt, args...)
+	if len(message) > eventMessageLimit {
+		message = message[:eventMessageLimit-len("...")] + "..."
+	}
+	recorder.Event(NodeObjectReference(nodeName), v1core.EventTypeWarning, reason, message)
+}
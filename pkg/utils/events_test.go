@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_RecordNodeWarning(t *testing.T) {
+	RecordNodeWarning(nil, "node-1", IptablesSyncFailedEventReason, "ignored without a recorder")
+
+	recorder := record.NewFakeRecorder(2)
+	RecordNodeWarning(recorder, "node-1", IptablesSyncFailedEventReason, "Failed to run %s: %v", "iptables-restore",
+		"exit status 1")
+	assert.Equal(t, "Warning IptablesSyncFailed Failed to run iptables-restore: exit status 1", <-recorder.Events)
+
+	RecordNodeWarning(recorder, "node-1", IptablesSyncFailedEventReason, "%s", strings.Repeat("x", 2000))
+	event := <-recorder.Events
+	assert.Len(t, strings.TrimPrefix(event, "Warning IptablesSyncFailed "), eventMessageLimit)
+	assert.True(t, strings.HasSuffix(event, "..."))
+}
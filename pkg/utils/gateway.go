@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"net"
+	"sort"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// GatewayControllerName is the controllerName of the GatewayClasses whose Gateways are programmed by kube-router
+const GatewayControllerName = "kube-router.io/gateway-controller"
+
+// ManagedGatewayClasses returns the GatewayClasses of kube-router, the ones whose controllerName is
+// GatewayControllerName
+func ManagedGatewayClasses(gatewayClassLister cache.Indexer) []*gatewayv1.GatewayClass {
+	classes := make([]*gatewayv1.GatewayClass, 0)
+	for _, obj := range gatewayClassLister.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		var class gatewayv1.GatewayClass
+		if err := v1alpha1.FromUnstructured(u, &class); err != nil {
+			klog.Errorf("Failed to convert GatewayClass %s: %v", u.GetName(), err)
+			continue
+		}
+		if class.Spec.ControllerName == GatewayControllerName {
+			classes = append(classes, &class)
+		}
+	}
+	return classes
+}
+
+// ManagedGateways returns the Gateways of the GatewayClasses of kube-router, sorted by namespace and name
+func ManagedGateways(gatewayLister, gatewayClassLister cache.Indexer) []*gatewayv1.Gateway {
+	classes := make(map[string]bool)
+	for _, class := range ManagedGatewayClasses(gatewayClassLister) {
+		classes[class.Name] = true
+	}
+
+	gateways := make([]*gatewayv1.Gateway, 0)
+	for _, obj := range gatewayLister.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		var gateway gatewayv1.Gateway
+		if err := v1alpha1.FromUnstructured(u, &gateway); err != nil {
+			klog.Errorf("Failed to convert Gateway %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+			continue
+		}
+		if classes[gateway.Spec.GatewayClassName] {
+			gateways = append(gateways, &gateway)
+		}
+	}
+	sort.Slice(gateways, func(i, j int) bool {
+		if gateways[i].Namespace != gateways[j].Namespace {
+			return gateways[i].Namespace < gateways[j].Namespace
+		}
+		return gateways[i].Name < gateways[j].Name
+	})
+	return gateways
+}
+
+// GatewayAddresses returns the IP addresses the Gateway serves: the ones it asks for in its spec, or else the ones
+// allocated to it in its status
+func GatewayAddresses(gateway *gatewayv1.Gateway) []string {
+	if addresses := gatewayIPAddresses(gateway.Spec.Addresses); len(addresses) > 0 {
+		return addresses
+	}
+	return gatewayIPAddresses(gateway.Status.Addresses)
+}
+
+// GatewayRequestsAddresses returns whether the Gateway asks for IP addresses in its spec, which then aren't allocated
+func GatewayRequestsAddresses(gateway *gatewayv1.Gateway) bool {
+	return len(gatewayIPAddresses(gateway.Spec.Addresses)) > 0
+}
+
+func gatewayIPAddresses(addresses []gatewayv1.GatewayAddress) []string {
+	ips := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if address.Type != nil && *address.Type != gatewayv1.IPAddressType {
+			continue
+		}
+		if net.ParseIP(address.Value) == nil {
+			continue
+		}
+		ips = append(ips, address.Value)
+	}
+	return ips
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	gatewayv1alpha2 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1alpha2"
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// GatewayRoute is a TCPRoute or an UDPRoute, which attaches to the listeners of its protocol
+type GatewayRoute struct {
+	Kind            string
+	Namespace       string
+	Name            string
+	Protocol        string
+	Generation      int64
+	ResourceVersion string
+	Created         metav1.Time
+	Spec            gatewayv1alpha2.RouteSpec
+	Status          gatewayv1alpha2.RouteStatus
+}
+
+// BackendRefError tells why a backend of a route can't be resolved, Reason is the reason of the ResolvedRefs condition
+// of the route
+type BackendRefError struct {
+	Reason  string
+	Message string
+}
+
+func (e *BackendRefError) Error() string {
+	return e.Message
+}
+
+// GatewayRoutes returns the TCPRoutes and UDPRoutes, the oldest first
+func GatewayRoutes(tcpRouteLister, udpRouteLister cache.Indexer) []*GatewayRoute {
+	routes := make([]*GatewayRoute, 0)
+	for _, kind := range []struct {
+		name     string
+		protocol string
+		lister   cache.Indexer
+	}{
+		{gatewayv1alpha2.TCPRouteKind, gatewayv1.TCPProtocolType, tcpRouteLister},
+		{gatewayv1alpha2.UDPRouteKind, gatewayv1.UDPProtocolType, udpRouteLister},
+	} {
+		for _, obj := range kind.lister.List() {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			// the UDPRoutes only differ from the TCPRoutes by their kind
+			var route gatewayv1alpha2.TCPRoute
+			if err := v1alpha1.FromUnstructured(u, &route); err != nil {
+				klog.Errorf("Failed to convert %s %s/%s: %v", kind.name, u.GetNamespace(), u.GetName(), err)
+				continue
+			}
+			routes = append(routes, &GatewayRoute{Kind: kind.name, Namespace: route.Namespace, Name: route.Name,
+				Protocol: kind.protocol, Generation: route.Generation, ResourceVersion: route.ResourceVersion,
+				Created: route.CreationTimestamp, Spec: route.Spec, Status: route.Status})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if !routes[i].Created.Equal(&routes[j].Created) {
+			return routes[i].Created.Before(&routes[j].Created)
+		}
+		return routes[i].Namespace+"/"+routes[i].Name < routes[j].Namespace+"/"+routes[j].Name
+	})
+	return routes
+}
+
+// GatewayListenerKey identifies a listener of a Gateway
+func GatewayListenerKey(gateway *gatewayv1.Gateway, listener gatewayv1.Listener) string {
+	return gateway.Namespace + "/" + gateway.Name + "/" + listener.Name
+}
+
+// AttachGatewayRoutes returns the route attached to each listener of the Gateways, by GatewayListenerKey. Only the
+// oldest of the routes that attach to a listener is attached, the others are ignored.
+func AttachGatewayRoutes(gateways []*gatewayv1.Gateway, routes []*GatewayRoute) map[string]*GatewayRoute {
+	attached := make(map[string]*GatewayRoute)
+	for _, gateway := range gateways {
+		for _, listener := range gateway.Spec.Listeners {
+			for _, route := range routes {
+				if RouteAttachesTo(route, gateway, listener) {
+					attached[GatewayListenerKey(gateway, listener)] = route
+					break
+				}
+			}
+		}
+	}
+	return attached
+}
+
+// RouteAttachesTo returns whether the route attaches to the listener of the Gateway: the route refers to the Gateway
+// or to the listener, and the listener allows it
+func RouteAttachesTo(route *GatewayRoute, gateway *gatewayv1.Gateway, listener gatewayv1.Listener) bool {
+	if !ListenerAllowsRoute(route, gateway, listener) {
+		return false
+	}
+	for _, ref := range route.Spec.ParentRefs {
+		if ParentRefMatchesListener(route, ref, gateway, listener) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenerAllowsRoute returns whether the route is of the protocol of the listener and in a namespace it allows
+func ListenerAllowsRoute(route *GatewayRoute, gateway *gatewayv1.Gateway, listener gatewayv1.Listener) bool {
+	if route.Protocol != listener.Protocol {
+		return false
+	}
+	from := gatewayv1.NamespacesFromSame
+	if listener.AllowedRoutes != nil && listener.AllowedRoutes.Namespaces != nil &&
+		listener.AllowedRoutes.Namespaces.From != nil {
+		from = *listener.AllowedRoutes.Namespaces.From
+	}
+	switch from {
+	case gatewayv1.NamespacesFromSame:
+		return route.Namespace == gateway.Namespace
+	case gatewayv1.NamespacesFromAll:
+		return true
+	}
+	// the namespaces aren't watched, so the routes can't be selected by the labels of theirs
+	return false
+}
+
+// ParentRefIsGateway returns whether the parent reference of the route refers to the Gateway
+func ParentRefIsGateway(route *GatewayRoute, ref gatewayv1alpha2.ParentReference, gateway *gatewayv1.Gateway) bool {
+	if ref.Group != nil && *ref.Group != gatewayv1.GroupName {
+		return false
+	}
+	if ref.Kind != nil && *ref.Kind != "Gateway" {
+		return false
+	}
+	namespace := route.Namespace
+	if ref.Namespace != nil {
+		namespace = *ref.Namespace
+	}
+	return namespace == gateway.Namespace && ref.Name == gateway.Name
+}
+
+// ParentRefMatchesListener returns whether the parent reference of the route refers to the Gateway, and to the
+// listener when it names a listener or a port
+func ParentRefMatchesListener(route *GatewayRoute, ref gatewayv1alpha2.ParentReference, gateway *gatewayv1.Gateway,
+	listener gatewayv1.Listener) bool {
+	if !ParentRefIsGateway(route, ref, gateway) {
+		return false
+	}
+	if ref.SectionName != nil && *ref.SectionName != listener.Name {
+		return false
+	}
+	return ref.Port == nil || *ref.Port == listener.Port
+}
+
+// ResolveGatewayBackend returns the Service the backend of the route refers to along with the name of its port, a
+// BackendRefError is returned when the backend isn't a port of a Service of the namespace and protocol of the route
+func ResolveGatewayBackend(route *GatewayRoute, ref gatewayv1alpha2.BackendRef, svcLister cache.Indexer) (
+	*v1core.Service, string, error) {
+	if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != "Service") {
+		return nil, "", &BackendRefError{Reason: gatewayv1.ReasonInvalidKind,
+			Message: fmt.Sprintf("backend %s isn't a Service, only Services are supported", ref.Name)}
+	}
+	if ref.Namespace != nil && *ref.Namespace != route.Namespace {
+		return nil, "", &BackendRefError{Reason: gatewayv1.ReasonRefNotPermitted,
+			Message: fmt.Sprintf("backend %s/%s is in another namespace, ReferenceGrants aren't supported",
+				*ref.Namespace, ref.Name)}
+	}
+	if ref.Port == nil {
+		return nil, "", &BackendRefError{Reason: gatewayv1.ReasonBackendNotFound,
+			Message: fmt.Sprintf("backend %s has no port", ref.Name)}
+	}
+	obj, exists, err := svcLister.GetByKey(route.Namespace + "/" + ref.Name)
+	if err != nil || !exists {
+		return nil, "", &BackendRefError{Reason: gatewayv1.ReasonBackendNotFound,
+			Message: fmt.Sprintf("Service %s/%s doesn't exist", route.Namespace, ref.Name)}
+	}
+	svc := obj.(*v1core.Service)
+	for _, port := range svc.Spec.Ports {
+		if port.Port == *ref.Port && string(port.Protocol) == route.Protocol {
+			return svc, port.Name, nil
+		}
+	}
+	return nil, "", &BackendRefError{Reason: gatewayv1.ReasonBackendNotFound,
+		Message: fmt.Sprintf("Service %s/%s has no %s port %d", route.Namespace, ref.Name, route.Protocol, *ref.Port)}
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	gatewayv1alpha2 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1alpha2"
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+func Test_RouteAttachesTo(t *testing.T) {
+	gateway := &gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: "infra", Name: "public"}}
+	listener := gatewayv1.Listener{Name: "tcp", Port: 8000, Protocol: gatewayv1.TCPProtocolType}
+	allNamespaces := listener
+	allNamespaces.AllowedRoutes = &gatewayv1.AllowedRoutes{
+		Namespaces: &gatewayv1.RouteNamespaces{From: pointer.String(gatewayv1.NamespacesFromAll)}}
+	selectedNamespaces := listener
+	selectedNamespaces.AllowedRoutes = &gatewayv1.AllowedRoutes{
+		Namespaces: &gatewayv1.RouteNamespaces{From: pointer.String(gatewayv1.NamespacesFromSelector)}}
+	route := func(namespace, protocol string, refs ...gatewayv1alpha2.ParentReference) *GatewayRoute {
+		return &GatewayRoute{Namespace: namespace, Protocol: protocol, Spec: gatewayv1alpha2.RouteSpec{ParentRefs: refs}}
+	}
+
+	testcases := []struct {
+		name     string
+		route    *GatewayRoute
+		listener gatewayv1.Listener
+		expected bool
+	}{
+		{"route of the namespace of the Gateway", route("infra", gatewayv1.TCPProtocolType,
+			gatewayv1alpha2.ParentReference{Name: "public"}), listener, true},
+		{"route of another protocol", route("infra", gatewayv1.UDPProtocolType,
+			gatewayv1alpha2.ParentReference{Name: "public"}), listener, false},
+		{"route of another Gateway", route("infra", gatewayv1.TCPProtocolType,
+			gatewayv1alpha2.ParentReference{Name: "private"}), listener, false},
+		{"route of another namespace", route("web", gatewayv1.TCPProtocolType,
+			gatewayv1alpha2.ParentReference{Namespace: pointer.String("infra"), Name: "public"}), listener, false},
+		{"route of another namespace allowed by the listener", route("web", gatewayv1.TCPProtocolType,
+			gatewayv1alpha2.ParentReference{Namespace: pointer.String("infra"), Name: "public"}), allNamespaces,
+			true},
+		{"route of a namespace the listener would select", route("web", gatewayv1.TCPProtocolType,
+			gatewayv1alpha2.ParentReference{Namespace: pointer.String("infra"), Name: "public"}),
+			selectedNamespaces, false},
+		{"route of another listener", route("infra", gatewayv1.TCPProtocolType,
+			gatewayv1alpha2.ParentReference{Name: "public", SectionName: pointer.String("tls")}), listener, false},
+		{"route of the port of the listener", route("infra", gatewayv1.TCPProtocolType,
+			gatewayv1alpha2.ParentReference{Name: "private"},
+			gatewayv1alpha2.ParentReference{Name: "public", Port: pointer.Int32(8000)}), listener, true},
+		{"route of a parent of another kind", route("infra", gatewayv1.TCPProtocolType,
+			gatewayv1alpha2.ParentReference{Kind: pointer.String("Service"), Name: "public"}), listener, false},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			assert.Equal(t, testcase.expected, RouteAttachesTo(testcase.route, gateway, testcase.listener))
+		})
+	}
+}
+
+func Test_AttachGatewayRoutes(t *testing.T) {
+	gateway := &gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "public"},
+		Spec: gatewayv1.GatewaySpec{Listeners: []gatewayv1.Listener{
+			{Name: "tcp", Port: 8000, Protocol: gatewayv1.TCPProtocolType},
+			{Name: "udp", Port: 53, Protocol: gatewayv1.UDPProtocolType},
+		}}}
+	parent := []gatewayv1alpha2.ParentReference{{Name: "public"}}
+	created := metav1.NewTime(time.Now())
+	routeLister := func(routes ...*gatewayv1alpha2.TCPRoute) cache.Indexer {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		for _, route := range routes {
+			u, err := v1alpha1.ToUnstructured(route)
+			assert.NoError(t, err)
+			assert.NoError(t, indexer.Add(u))
+		}
+		return indexer
+	}
+	routes := GatewayRoutes(routeLister(
+		&gatewayv1alpha2.TCPRoute{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "newer",
+			CreationTimestamp: metav1.NewTime(created.Add(time.Minute))},
+			Spec: gatewayv1alpha2.RouteSpec{ParentRefs: parent}},
+		&gatewayv1alpha2.TCPRoute{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "app",
+			CreationTimestamp: created}, Spec: gatewayv1alpha2.RouteSpec{ParentRefs: parent}},
+	), routeLister())
+
+	attached := AttachGatewayRoutes([]*gatewayv1.Gateway{gateway}, routes)
+	assert.Len(t, attached, 1, "expected no route to be attached to the UDP listener")
+	if assert.Contains(t, attached, "web/public/tcp") {
+		assert.Equal(t, "app", attached["web/public/tcp"].Name, "expected the oldest route to be attached")
+		assert.Equal(t, "TCPRoute", attached["web/public/tcp"].Kind)
+	}
+}
+
+func Test_ResolveGatewayBackend(t *testing.T) {
+	svcLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, svcLister.Add(&v1core.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "app"},
+		Spec: v1core.ServiceSpec{Ports: []v1core.ServicePort{
+			{Name: "http", Port: 80, Protocol: v1core.ProtocolTCP},
+			{Name: "dns", Port: 53, Protocol: v1core.ProtocolUDP},
+		}}}))
+	route := &GatewayRoute{Kind: "TCPRoute", Namespace: "web", Name: "app", Protocol: gatewayv1.TCPProtocolType}
+
+	svc, portName, err := ResolveGatewayBackend(route, gatewayv1alpha2.BackendRef{Name: "app",
+		Port: pointer.Int32(80)}, svcLister)
+	assert.NoError(t, err)
+	assert.Equal(t, "app", svc.Name)
+	assert.Equal(t, "http", portName)
+
+	testcases := []struct {
+		name   string
+		ref    gatewayv1alpha2.BackendRef
+		reason string
+	}{
+		{"backend of another kind", gatewayv1alpha2.BackendRef{Kind: pointer.String("ServiceImport"), Name: "app",
+			Port: pointer.Int32(80)}, gatewayv1.ReasonInvalidKind},
+		{"backend of another namespace", gatewayv1alpha2.BackendRef{Namespace: pointer.String("db"), Name: "app",
+			Port: pointer.Int32(80)}, gatewayv1.ReasonRefNotPermitted},
+		{"backend without a port", gatewayv1alpha2.BackendRef{Name: "app"}, gatewayv1.ReasonBackendNotFound},
+		{"missing Service", gatewayv1alpha2.BackendRef{Name: "missing", Port: pointer.Int32(80)},
+			gatewayv1.ReasonBackendNotFound},
+		{"port of another protocol", gatewayv1alpha2.BackendRef{Name: "app", Port: pointer.Int32(53)},
+			gatewayv1.ReasonBackendNotFound},
+	}
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			_, _, err := ResolveGatewayBackend(route, testcase.ref, svcLister)
+			var refErr *BackendRefError
+			if assert.ErrorAs(t, err, &refErr) {
+				assert.Equal(t, testcase.reason, refErr.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+
+	gatewayv1 "github.com/cloudnativelabs/kube-router/pkg/apis/gateway/v1"
+	"github.com/cloudnativelabs/kube-router/pkg/apis/kuberouter/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+func Test_ManagedGateways(t *testing.T) {
+	classLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	gatewayLister := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	add := func(lister cache.Indexer, obj interface{}) {
+		u, err := v1alpha1.ToUnstructured(obj)
+		if err != nil {
+			t.Fatalf("failed to convert %v: %v", obj, err)
+		}
+		assert.NoError(t, lister.Add(u))
+	}
+	add(classLister, &gatewayv1.GatewayClass{ObjectMeta: metav1.ObjectMeta{Name: "kube-router"},
+		Spec: gatewayv1.GatewayClassSpec{ControllerName: GatewayControllerName}})
+	add(classLister, &gatewayv1.GatewayClass{ObjectMeta: metav1.ObjectMeta{Name: "envoy"},
+		Spec: gatewayv1.GatewayClassSpec{ControllerName: "gateway.envoyproxy.io/gatewayclass-controller"}})
+	for _, gateway := range []struct{ namespace, name, class string }{
+		{"web", "public", "kube-router"},
+		{"db", "internal", "kube-router"},
+		{"web", "edge", "envoy"},
+		{"web", "orphan", "missing"},
+	} {
+		add(gatewayLister, &gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: gateway.namespace,
+			Name: gateway.name}, Spec: gatewayv1.GatewaySpec{GatewayClassName: gateway.class}})
+	}
+
+	names := make([]string, 0)
+	for _, gateway := range ManagedGateways(gatewayLister, classLister) {
+		names = append(names, gateway.Namespace+"/"+gateway.Name)
+	}
+	assert.Equal(t, []string{"db/internal", "web/public"}, names,
+		"expected the Gateways of the classes of kube-router sorted by namespace and name")
+}
+
+func Test_GatewayAddresses(t *testing.T) {
+	hostname := "Hostname"
+	allocated := gatewayv1.GatewayStatus{Addresses: []gatewayv1.GatewayAddress{{Value: "192.0.2.1"}}}
+
+	gateway := &gatewayv1.Gateway{Status: allocated}
+	assert.Equal(t, []string{"192.0.2.1"}, GatewayAddresses(gateway), "expected the allocated addresses")
+	assert.False(t, GatewayRequestsAddresses(gateway))
+
+	gateway.Spec.Addresses = []gatewayv1.GatewayAddress{
+		{Type: pointer.String(gatewayv1.IPAddressType), Value: "198.51.100.1"},
+		{Type: &hostname, Value: "gateway.example.com"},
+		{Value: "not-an-address"},
+	}
+	assert.Equal(t, []string{"198.51.100.1"}, GatewayAddresses(gateway),
+		"expected the IP addresses asked for in the spec")
+	assert.True(t, GatewayRequestsAddresses(gateway))
+
+	gateway.Spec.Addresses = gateway.Spec.Addresses[1:]
+	assert.Equal(t, []string{"192.0.2.1"}, GatewayAddresses(gateway),
+		"expected the allocated addresses when the spec asks for no IP address")
+}
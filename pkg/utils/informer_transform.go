@@ -0,0 +1,59 @@
+package utils
+
+import (
+	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// StripUnusedFields is an informer transform which drops the fields of the objects kube-router never reads before
+// they are stored in the informer caches. Managed fields and the last applied configuration of kubectl are dropped
+// from every object, and from pods everything but their containers' names and ports, the fields that place them on a
+// node and the IPs, phase and container IDs of their status. It is meant to be set on the informers of pods, endpoints
+// and services, which hold tens of thousands of objects in large clusters, on every node.
+func StripUnusedFields(obj interface{}) (interface{}, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+		if annotations := accessor.GetAnnotations(); annotations != nil {
+			if _, ok := annotations[lastAppliedConfigAnnotation]; ok {
+				delete(annotations, lastAppliedConfigAnnotation)
+				accessor.SetAnnotations(annotations)
+			}
+		}
+	}
+
+	switch o := obj.(type) {
+	case *v1core.Pod:
+		stripUnusedPodFields(o)
+	case *v1core.Service:
+		o.Status.Conditions = nil
+	}
+	return obj, nil
+}
+
+func stripUnusedPodFields(pod *v1core.Pod) {
+	containers := make([]v1core.Container, 0, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		containers = append(containers, v1core.Container{Name: container.Name, Ports: container.Ports})
+	}
+	pod.Spec = v1core.PodSpec{
+		Containers:                    containers,
+		NodeName:                      pod.Spec.NodeName,
+		HostNetwork:                   pod.Spec.HostNetwork,
+		TerminationGracePeriodSeconds: pod.Spec.TerminationGracePeriodSeconds,
+	}
+
+	containerStatuses := make([]v1core.ContainerStatus, 0, len(pod.Status.ContainerStatuses))
+	for _, status := range pod.Status.ContainerStatuses {
+		containerStatuses = append(containerStatuses, v1core.ContainerStatus{Name: status.Name,
+			ContainerID: status.ContainerID})
+	}
+	pod.Status = v1core.PodStatus{
+		Phase:             pod.Status.Phase,
+		HostIP:            pod.Status.HostIP,
+		PodIP:             pod.Status.PodIP,
+		PodIPs:            pod.Status.PodIPs,
+		ContainerStatuses: containerStatuses,
+	}
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_StripUnusedFields(t *testing.T) {
+	gracePeriod := int64(30)
+	objectMeta := func() metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "foo"},
+			Annotations: map[string]string{
+				lastAppliedConfigAnnotation:  `{"apiVersion":"v1"}`,
+				"kube-router.io/service.dsr": "tunnel",
+			},
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+		}
+	}
+	strippedMeta := metav1.ObjectMeta{
+		Name:        "foo",
+		Namespace:   "default",
+		Labels:      map[string]string{"app": "foo"},
+		Annotations: map[string]string{"kube-router.io/service.dsr": "tunnel"},
+	}
+
+	testcases := []struct {
+		name     string
+		obj      interface{}
+		expected interface{}
+	}{
+		{
+			"pod keeps its ports, placement, IPs, phase and container IDs",
+			&v1core.Pod{
+				ObjectMeta: objectMeta(),
+				Spec: v1core.PodSpec{
+					Containers: []v1core.Container{{
+						Name:  "foo",
+						Image: "foo:latest",
+						Env:   []v1core.EnvVar{{Name: "FOO", Value: "bar"}},
+						Ports: []v1core.ContainerPort{{Name: "http", ContainerPort: 8080, Protocol: v1core.ProtocolTCP}},
+					}},
+					Volumes:                       []v1core.Volume{{Name: "data"}},
+					NodeName:                      "node-1",
+					HostNetwork:                   false,
+					TerminationGracePeriodSeconds: &gracePeriod,
+				},
+				Status: v1core.PodStatus{
+					Phase:      v1core.PodRunning,
+					Conditions: []v1core.PodCondition{{Type: v1core.PodReady, Status: v1core.ConditionTrue}},
+					HostIP:     "10.0.0.1",
+					PodIP:      "10.1.0.5",
+					PodIPs:     []v1core.PodIP{{IP: "10.1.0.5"}},
+					ContainerStatuses: []v1core.ContainerStatus{{
+						Name:        "foo",
+						Image:       "foo:latest",
+						ContainerID: "containerd://abc",
+					}},
+				},
+			},
+			&v1core.Pod{
+				ObjectMeta: strippedMeta,
+				Spec: v1core.PodSpec{
+					Containers: []v1core.Container{{
+						Name:  "foo",
+						Ports: []v1core.ContainerPort{{Name: "http", ContainerPort: 8080, Protocol: v1core.ProtocolTCP}},
+					}},
+					NodeName:                      "node-1",
+					TerminationGracePeriodSeconds: &gracePeriod,
+				},
+				Status: v1core.PodStatus{
+					Phase:             v1core.PodRunning,
+					HostIP:            "10.0.0.1",
+					PodIP:             "10.1.0.5",
+					PodIPs:            []v1core.PodIP{{IP: "10.1.0.5"}},
+					ContainerStatuses: []v1core.ContainerStatus{{Name: "foo", ContainerID: "containerd://abc"}},
+				},
+			},
+		},
+		{
+			"service keeps its spec and load balancer status",
+			&v1core.Service{
+				ObjectMeta: objectMeta(),
+				Spec:       v1core.ServiceSpec{ClusterIP: "10.96.0.10", Type: v1core.ServiceTypeLoadBalancer},
+				Status: v1core.ServiceStatus{
+					LoadBalancer: v1core.LoadBalancerStatus{Ingress: []v1core.LoadBalancerIngress{{IP: "1.1.1.1"}}},
+					Conditions:   []metav1.Condition{{Type: "LoadBalancerReady"}},
+				},
+			},
+			&v1core.Service{
+				ObjectMeta: strippedMeta,
+				Spec:       v1core.ServiceSpec{ClusterIP: "10.96.0.10", Type: v1core.ServiceTypeLoadBalancer},
+				Status: v1core.ServiceStatus{
+					LoadBalancer: v1core.LoadBalancerStatus{Ingress: []v1core.LoadBalancerIngress{{IP: "1.1.1.1"}}},
+				},
+			},
+		},
+		{
+			"endpoints keep their subsets",
+			&v1core.Endpoints{
+				ObjectMeta: objectMeta(),
+				Subsets:    []v1core.EndpointSubset{{Addresses: []v1core.EndpointAddress{{IP: "10.1.0.5"}}}},
+			},
+			&v1core.Endpoints{
+				ObjectMeta: strippedMeta,
+				Subsets:    []v1core.EndpointSubset{{Addresses: []v1core.EndpointAddress{{IP: "10.1.0.5"}}}},
+			},
+		},
+		{
+			"objects which aren't API objects are left as is",
+			"foo",
+			"foo",
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			obj, err := StripUnusedFields(testcase.obj)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(obj, testcase.expected) {
+				t.Errorf("expected %+v, got %+v", testcase.expected, obj)
+			}
+		})
+	}
+}
@@ -150,6 +150,8 @@ const (
 	// tmpIPSetPrefix Is the prefix added to temporary ipset names used in the atomic swap operations during ipset
 	// restore. You should never see these on your system because they only exist during the restore.
 	tmpIPSetPrefix = "TMP-"
+	// ipv6SetPrefix is the prefix of the names of the IPv6 sets
+	ipv6SetPrefix = "inet6:"
 )
 
 // IPSet represent ipset sets managed by.
@@ -262,7 +264,9 @@ func (ipset *IPSet) Create(setName string, createOptions ...string) (*Set, error
 			// Add "family inet6" option and a "inet6:" prefix for IPv6 sets.
 			args := []string{"create", "-exist", ipset.Sets[setName].name()}
 			args = append(args, createOptions...)
-			args = append(args, "family", "inet6")
+			if !hasFamilyOption(createOptions) {
+				args = append(args, "family", "inet6")
+			}
 			if _, err := ipset.run(args...); err != nil {
 				return nil, fmt.Errorf("failed to create ipset set on system: %s", err)
 			}
@@ -300,9 +304,13 @@ func (ipset *IPSet) Add(set *Set) error {
 // RefreshSet add/update internal Sets with a Set of entries but does not run restore command
 func (ipset *IPSet) RefreshSet(setName string, entriesWithOptions [][]string, setType string) {
 	if ipset.Get(setName) == nil {
+		options := []string{setType, OptionTimeout, "0"}
+		if ipset.isIpv6 {
+			options = append(options, "family", FamillyInet6)
+		}
 		ipset.Sets[setName] = &Set{
 			Name:    setName,
-			Options: []string{setType, OptionTimeout, "0"},
+			Options: options,
 			Parent:  ipset,
 		}
 	}
@@ -437,13 +445,29 @@ func (set *Set) IsActive() (bool, error) {
 }
 
 func (set *Set) name() string {
-	if set.Parent.isIpv6 {
-		return "inet6:" + set.Name
+	return set.Parent.setName(set.Name)
+}
+
+// setName returns the name of the set on the system, IPv6 sets get an "inet6:" prefix so that the sets of both address
+// families can be named alike
+func (ipset *IPSet) setName(name string) string {
+	if ipset.isIpv6 {
+		return ipv6SetPrefix + name
 	}
-	return set.Name
+	return name
 }
 
-// Parse ipset save stdout.
+func hasFamilyOption(options []string) bool {
+	for _, option := range options {
+		if option == "family" {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse ipset save stdout, only the sets of the address family of the IPSet are kept, the IPv6 ones without their
+// "inet6:" prefix.
 // ex:
 // create KUBE-DST-3YNVZWWGX3UQQ4VQ hash:ip family inet hashsize 1024 maxelem 65536 timeout 0
 // add KUBE-DST-3YNVZWWGX3UQQ4VQ 100.96.1.6 timeout 0
@@ -453,14 +477,18 @@ func parseIPSetSave(ipset *IPSet, result string) map[string]*Set {
 	lines := strings.Split(result, "\n")
 	for _, line := range lines {
 		content := strings.Split(line, " ")
+		if len(content) < 2 || strings.HasPrefix(content[1], ipv6SetPrefix) != ipset.isIpv6 {
+			continue
+		}
+		name := strings.TrimPrefix(content[1], ipv6SetPrefix)
 		if content[0] == "create" {
-			sets[content[1]] = &Set{
+			sets[name] = &Set{
 				Parent:  ipset,
-				Name:    content[1],
+				Name:    name,
 				Options: scrubInitValFromOptions(content[2:]),
 			}
 		} else if content[0] == "add" {
-			set := sets[content[1]]
+			set := sets[name]
 			set.Entries = append(set.Entries, &Entry{
 				Set:     set,
 				Options: content[2:],
@@ -522,10 +550,10 @@ func buildIPSetRestore(ipset *IPSet) string {
 		}
 
 		// now create the actual IPSet (this is a noop if it already exists, because we run with -exists):
-		ipSetRestore.WriteString(fmt.Sprintf("create %s %s\n", set.Name, setOptions))
+		ipSetRestore.WriteString(fmt.Sprintf("create %s %s\n", ipset.setName(set.Name), setOptions))
 
 		// now that both exist, we can swap them:
-		ipSetRestore.WriteString(fmt.Sprintf("swap %s %s\n", tmpSetName, set.Name))
+		ipSetRestore.WriteString(fmt.Sprintf("swap %s %s\n", tmpSetName, ipset.setName(set.Name)))
 
 		// empty the tmp set (which is actually the old one now):
 		ipSetRestore.WriteString(fmt.Sprintf("flush %s\n", tmpSetName))
@@ -555,6 +583,13 @@ func (ipset *IPSet) Save() error {
 		return err
 	}
 	ipset.Sets = parseIPSetSave(ipset, stdout)
+	// only the sets kube-router owns are kept, so that restoring or destroying the saved sets never touches the sets
+	// of others
+	for name := range ipset.Sets {
+		if !IsOwnedIPSet(name) {
+			delete(ipset.Sets, name)
+		}
+	}
 	return nil
 }
 
@@ -101,3 +101,31 @@ func Test_scrubInitValFromOptions(t *testing.T) {
 		assert.Equal(t, desired, scrubInitValFromOptions(noInitVal))
 	})
 }
+
+func Test_parseIPSetSave(t *testing.T) {
+	save := "create KUBE-DST-FOO hash:ip family inet hashsize 1024 maxelem 65536 timeout 0\n" +
+		"add KUBE-DST-FOO 10.1.1.1 timeout 0\n" +
+		"create inet6:KUBE-DST-FOO hash:ip family inet6 hashsize 1024 maxelem 65536 timeout 0\n" +
+		"add inet6:KUBE-DST-FOO 2001:db8::1 timeout 0\n"
+
+	t.Run("IPv4 handlers only keep the IPv4 sets", func(t *testing.T) {
+		sets := parseIPSetSave(&IPSet{isIpv6: false}, save)
+		assert.Len(t, sets, 1)
+		assert.Equal(t, []string{"10.1.1.1", "timeout", "0"}, sets["KUBE-DST-FOO"].Entries[0].Options)
+	})
+
+	t.Run("IPv6 handlers only keep the IPv6 sets, without their prefix", func(t *testing.T) {
+		sets := parseIPSetSave(&IPSet{isIpv6: true}, save)
+		assert.Len(t, sets, 1)
+		assert.Equal(t, []string{"2001:db8::1", "timeout", "0"}, sets["KUBE-DST-FOO"].Entries[0].Options)
+	})
+}
+
+func Test_buildIPSetRestoreIPv6(t *testing.T) {
+	ipset := &IPSet{isIpv6: true, Sets: map[string]*Set{}}
+	ipset.RefreshSet("KUBE-DST-FOO", [][]string{{"2001:db8::1", OptionTimeout, "0"}}, TypeHashIP)
+
+	restore := buildIPSetRestore(ipset)
+	assert.Contains(t, restore, "create inet6:KUBE-DST-FOO hash:ip timeout 0 family inet6\n")
+	assert.Contains(t, restore, " inet6:KUBE-DST-FOO\n", "expected the swap to target the prefixed set")
+}
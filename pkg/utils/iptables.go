@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"strings"
 
+	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
@@ -29,14 +30,50 @@ func init() {
 	hasWait = strings.Contains(string(cmdOutput), "wait")
 }
 
+// IPTablesSaveRestore runs iptables-save and iptables-restore, or ip6tables-save and ip6tables-restore, for the
+// rules of a single address family
+type IPTablesSaveRestore struct {
+	saveCmd    string
+	restoreCmd string
+}
+
+// NewIPTablesSaveRestore returns the IPTablesSaveRestore of the given address family
+func NewIPTablesSaveRestore(ipFamily apiv1.IPFamily) *IPTablesSaveRestore {
+	if ipFamily == apiv1.IPv6Protocol {
+		return &IPTablesSaveRestore{saveCmd: "ip6tables-save", restoreCmd: "ip6tables-restore"}
+	}
+	return &IPTablesSaveRestore{saveCmd: "iptables-save", restoreCmd: "iptables-restore"}
+}
+
 // SaveInto calls `iptables-save` for given table and stores result in a given buffer.
 func SaveInto(table string, buffer *bytes.Buffer) error {
-	path, err := exec.LookPath("iptables-save")
+	return NewIPTablesSaveRestore(apiv1.IPv4Protocol).SaveInto(table, buffer)
+}
+
+// Restore runs `iptables-restore` passing data through []byte.
+func Restore(table string, data []byte) error {
+	return NewIPTablesSaveRestore(apiv1.IPv4Protocol).Restore(table, data)
+}
+
+// SaveInto calls `iptables-save` for given table and stores result in a given buffer.
+func (i *IPTablesSaveRestore) SaveInto(table string, buffer *bytes.Buffer) error {
+	return i.save(table, buffer)
+}
+
+// SaveWithCountersInto calls `iptables-save -c` for given table and stores result in a given buffer, each rule is
+// prefixed with its packet and byte counters as in `[packets:bytes] -A chain ...`.
+func (i *IPTablesSaveRestore) SaveWithCountersInto(table string, buffer *bytes.Buffer) error {
+	return i.save(table, buffer, "-c")
+}
+
+func (i *IPTablesSaveRestore) save(table string, buffer *bytes.Buffer, extraArgs ...string) error {
+	path, err := exec.LookPath(i.saveCmd)
 	if err != nil {
 		return err
 	}
 	stderrBuffer := bytes.NewBuffer(nil)
-	args := []string{"iptables-save", "-t", table}
+	args := append([]string{i.saveCmd}, extraArgs...)
+	args = append(args, "-t", table)
 	klog.V(9).Infof("running iptables command: path=`%s` args=%+v", path, args)
 	cmd := exec.Cmd{
 		Path:   path,
@@ -51,17 +88,27 @@ func SaveInto(table string, buffer *bytes.Buffer) error {
 }
 
 // Restore runs `iptables-restore` passing data through []byte.
-func Restore(table string, data []byte) error {
-	path, err := exec.LookPath("iptables-restore")
+func (i *IPTablesSaveRestore) Restore(table string, data []byte) error {
+	return i.restore(table, data)
+}
+
+// RestoreNoFlush runs `iptables-restore --noflush` passing data through []byte, only the chains declared in data are
+// flushed and the rest of the table is left as is.
+func (i *IPTablesSaveRestore) RestoreNoFlush(table string, data []byte) error {
+	return i.restore(table, data, "--noflush")
+}
+
+func (i *IPTablesSaveRestore) restore(table string, data []byte, extraArgs ...string) error {
+	path, err := exec.LookPath(i.restoreCmd)
 	if err != nil {
 		return err
 	}
-	var args []string
+	args := []string{i.restoreCmd}
 	if hasWait {
-		args = []string{"iptables-restore", "--wait", "-T", table}
-	} else {
-		args = []string{"iptables-restore", "-T", table}
+		args = append(args, "--wait")
 	}
+	args = append(args, extraArgs...)
+	args = append(args, "-T", table)
 	klog.V(9).Infof("running iptables command: path=`%s` args=%+v", path, args)
 	cmd := exec.Cmd{
 		Path:  path,
@@ -76,29 +123,6 @@ func Restore(table string, data []byte) error {
 	return nil
 }
 
-// AppendUnique ensures that rule is in chain only once in the buffer and that the occurrence is at the end of the
-// buffer
-func AppendUnique(buffer bytes.Buffer, chain string, rule []string) bytes.Buffer {
-	var desiredBuffer bytes.Buffer
-
-	// First we need to remove any previous instances of the rule that exist, so that we can be sure that our version
-	// is unique and appended to the very end of the buffer
-	rules := strings.Split(buffer.String(), "\n")
-	if len(rules) > 0 && rules[len(rules)-1] == "" {
-		rules = rules[:len(rules)-1]
-	}
-	for _, foundRule := range rules {
-		if strings.Contains(foundRule, chain) && strings.Contains(foundRule, strings.Join(rule, " ")) {
-			continue
-		}
-		desiredBuffer.WriteString(foundRule + "\n")
-	}
-
-	// Now append the rule that we wanted to be unique
-	desiredBuffer = Append(desiredBuffer, chain, rule)
-	return desiredBuffer
-}
-
 // Append appends rule to chain at the end of buffer
 func Append(buffer bytes.Buffer, chain string, rule []string) bytes.Buffer {
 	ruleStr := strings.Join(append([]string{"-A", chain}, rule...), " ")
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// procConfigPath is where the kernel exposes its configuration when it is built with CONFIG_IKCONFIG_PROC
+	procConfigPath = "/proc/config.gz"
+	// bootPath holds the config-<release> files of the installed kernels
+	bootPath = "/boot"
+)
+
+// KernelConfigOption describes an option of the kernel configuration that kube-router depends on
+type KernelConfigOption struct {
+	// Name of the option (e.g. CONFIG_IP_VS)
+	Name string
+	// Required options cause prerequisite validation to fail when the kernel was built without them, otherwise only a
+	// warning is logged
+	Required bool
+	// Reason is a human readable explanation of which feature needs this option
+	Reason string
+}
+
+// KernelConfigError is returned when the kernel was built without one or more required options
+type KernelConfigError struct {
+	Missing []KernelConfigOption
+	source  string
+}
+
+// Error return the error as string
+func (e *KernelConfigError) Error() string {
+	report := make([]string, 0, len(e.Missing))
+	for _, option := range e.Missing {
+		report = append(report, fmt.Sprintf("%s (needed for %s)", option.Name, option.Reason))
+	}
+	return fmt.Sprintf("the kernel was built without required options according to %s, use a kernel built with "+
+		"them or run kube-router with --skip-kernel-module-check: %s", e.source, strings.Join(report, "; "))
+}
+
+// readKernelConfig returns the options of the running kernel's configuration that are built in (y) or built as a
+// module (m), along with the file they were read from. The configuration is read from /proc/config.gz, or from the
+// config file of the running kernel in /boot when the kernel doesn't expose it.
+func readKernelConfig() (map[string]string, string, error) {
+	var reader io.Reader
+	source := procConfigPath
+	file, err := os.Open(procConfigPath)
+	if err == nil {
+		defer CloseCloserDisregardError(file)
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, source, fmt.Errorf("failed to decompress %s: %v", source, err)
+		}
+		reader = gzipReader
+	} else {
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			return nil, "", fmt.Errorf("unable to determine kernel release: %v", err)
+		}
+		source = path.Join(bootPath, "config-"+unix.ByteSliceToString(uts.Release[:]))
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, source, err
+		}
+		defer CloseCloserDisregardError(file)
+		reader = file
+	}
+
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		// entries look like: CONFIG_IP_VS=m, the options that are not set are commented out
+		name, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if value == "y" || value == "m" {
+			config[name] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, source, fmt.Errorf("failed to read %s: %v", source, err)
+	}
+	return config, source, nil
+}
+
+// EnsureKernelConfig verifies that the running kernel was built with each of the given options, either built in or as
+// a module. Options that are not required only produce a warning when they are missing, if any required option is
+// missing a *KernelConfigError is returned listing all of them. As not every host ships the configuration of its
+// kernel, only a warning is logged when it can't be found.
+func EnsureKernelConfig(options []KernelConfigOption) error {
+	config, source, err := readKernelConfig()
+	if err != nil {
+		klog.Warningf("unable to read the kernel configuration, not checking the kernel options needed by the "+
+			"enabled functionality: %v", err)
+		return nil
+	}
+
+	configErr := KernelConfigError{source: source}
+	for _, option := range options {
+		if _, ok := config[option.Name]; ok {
+			klog.V(2).Infof("kernel option %s is enabled", option.Name)
+			continue
+		}
+		if !option.Required {
+			klog.Warningf("the kernel was built without the optional %s option (needed for %s), this feature will "+
+				"not work on this node", option.Name, option.Reason)
+			continue
+		}
+		configErr.Missing = append(configErr.Missing, option)
+	}
+
+	if len(configErr.Missing) > 0 {
+		return &configErr
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const testKernelConfig = `#
+# Automatically generated file; DO NOT EDIT.
+#
+CONFIG_NETFILTER_XT_SET=m
+CONFIG_IP_SET=y
+CONFIG_IP_VS=m
+# CONFIG_IP_VS_NFCT is not set
+CONFIG_IP_VS_MH=
+`
+
+func Test_EnsureKernelConfig(t *testing.T) {
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	if _, err := writer.Write([]byte(testKernelConfig)); err != nil {
+		t.Fatalf("failed to compress the kernel configuration: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to compress the kernel configuration: %v", err)
+	}
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		t.Fatalf("failed to determine the kernel release: %v", err)
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+
+	testcases := []struct {
+		name           string
+		procConfig     []byte
+		bootConfig     []byte
+		options        []KernelConfigOption
+		expectedErr    bool
+		expectedMissed []string
+	}{
+		{
+			"options read from /proc/config.gz",
+			gzipped.Bytes(),
+			nil,
+			[]KernelConfigOption{{Name: "CONFIG_IP_SET", Required: true}, {Name: "CONFIG_IP_VS", Required: true}},
+			false,
+			nil,
+		},
+		{
+			"options read from /boot when /proc/config.gz is missing",
+			nil,
+			[]byte(testKernelConfig),
+			[]KernelConfigOption{{Name: "CONFIG_NETFILTER_XT_SET", Required: true}, {Name: "CONFIG_IP_VS_MH"}},
+			false,
+			nil,
+		},
+		{
+			"missing required options are all reported",
+			gzipped.Bytes(),
+			nil,
+			[]KernelConfigOption{{Name: "CONFIG_IP_VS_NFCT", Required: true}, {Name: "CONFIG_IP_VS", Required: true},
+				{Name: "CONFIG_IP_VS_MH", Required: true}, {Name: "CONFIG_IP_VS_WRR"}},
+			true,
+			[]string{"CONFIG_IP_VS_NFCT", "CONFIG_IP_VS_MH"},
+		},
+		{
+			"missing kernel configuration does not cause an error",
+			nil,
+			nil,
+			[]KernelConfigOption{{Name: "CONFIG_IP_VS", Required: true}},
+			false,
+			nil,
+		},
+	}
+
+	origProcConfigPath, origBootPath := procConfigPath, bootPath
+	defer func() {
+		procConfigPath, bootPath = origProcConfigPath, origBootPath
+	}()
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			procConfigPath = path.Join(t.TempDir(), "config.gz")
+			if testcase.procConfig != nil {
+				if err := os.WriteFile(procConfigPath, testcase.procConfig, 0644); err != nil {
+					t.Fatalf("failed to create fake /proc/config.gz: %v", err)
+				}
+			}
+			bootPath = t.TempDir()
+			if testcase.bootConfig != nil {
+				if err := os.WriteFile(path.Join(bootPath, "config-"+release), testcase.bootConfig, 0644); err != nil {
+					t.Fatalf("failed to create fake kernel config: %v", err)
+				}
+			}
+
+			err := EnsureKernelConfig(testcase.options)
+			if !testcase.expectedErr {
+				if err != nil {
+					t.Fatalf("expected no error, but got: %v", err)
+				}
+				return
+			}
+
+			var configErr *KernelConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("expected a KernelConfigError, but got: %v", err)
+			}
+			if len(configErr.Missing) != len(testcase.expectedMissed) {
+				t.Fatalf("expected %d missing options, but got %d: %v", len(testcase.expectedMissed),
+					len(configErr.Missing), err)
+			}
+			for idx, option := range configErr.Missing {
+				if option.Name != testcase.expectedMissed[idx] {
+					t.Errorf("expected missing option %s, but got %s", testcase.expectedMissed[idx], option.Name)
+				}
+			}
+		})
+	}
+}
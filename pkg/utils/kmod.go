@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// sysModulePath is where the kernel exposes loaded (and most built-in) modules
+	sysModulePath = "/sys/module"
+	// libModulesPath is the root of the module tree which holds modules.builtin for the running kernel
+	libModulesPath = "/lib/modules"
+	// modprobe is a function variable so that the prerequisite checks can be tested without loading modules
+	modprobe = func(module string) ([]byte, error) {
+		return exec.Command("modprobe", module).CombinedOutput()
+	}
+)
+
+// KernelModule describes a kernel module that kube-router depends on
+type KernelModule struct {
+	// Name of the module as passed to modprobe (e.g. ip_vs)
+	Name string
+	// Required modules cause prerequisite validation to fail if they cannot be loaded, otherwise only a warning is
+	// logged as kube-router is able to work without them (e.g. an optional IPVS scheduler)
+	Required bool
+	// Reason is a human readable explanation of which feature needs this module
+	Reason string
+	// Config is the option of the kernel configuration which builds the module (e.g. CONFIG_IP_VS)
+	Config string
+}
+
+// KernelModuleError is returned when one or more required kernel modules are missing and could not be loaded
+type KernelModuleError struct {
+	Missing []KernelModule
+	errs    []error
+}
+
+// Error return the error as string
+func (e *KernelModuleError) Error() string {
+	report := make([]string, 0, len(e.Missing))
+	for idx, module := range e.Missing {
+		report = append(report, fmt.Sprintf("%s (needed for %s): %v", module.Name, module.Reason, e.errs[idx]))
+	}
+	return fmt.Sprintf("required kernel modules are not available, ensure that they are present on the host's "+
+		"kernel or run kube-router with --skip-kernel-module-check: %s", strings.Join(report, "; "))
+}
+
+// isKernelModuleAvailable checks whether the module is either already loaded or compiled into the running kernel
+func isKernelModuleAvailable(module string, builtin map[string]bool) bool {
+	// Module names are normalized by the kernel to use underscores, while modules.builtin and modprobe accept both
+	normalized := strings.ReplaceAll(module, "-", "_")
+	if _, err := os.Stat(path.Join(sysModulePath, normalized)); err == nil {
+		return true
+	}
+	return builtin[normalized]
+}
+
+// builtinKernelModules parses modules.builtin for the running kernel and returns the set of modules that are compiled
+// into the kernel. Not every built-in module shows up under /sys/module, so this covers the case where the kernel was
+// configured with =y instead of =m.
+func builtinKernelModules() map[string]bool {
+	builtin := make(map[string]bool)
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		klog.V(2).Infof("unable to determine kernel release to read modules.builtin: %v", err)
+		return builtin
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+
+	file, err := os.Open(path.Join(libModulesPath, release, "modules.builtin"))
+	if err != nil {
+		klog.V(2).Infof("unable to read modules.builtin for kernel %s: %v", release, err)
+		return builtin
+	}
+	defer CloseCloserDisregardError(file)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// entries look like: kernel/net/netfilter/ipvs/ip_vs.ko
+		name := strings.TrimSuffix(filepath.Base(strings.TrimSpace(scanner.Text())), ".ko")
+		if name != "" {
+			builtin[strings.ReplaceAll(name, "-", "_")] = true
+		}
+	}
+	return builtin
+}
+
+// EnsureKernelModules verifies that each of the given kernel modules is available in the running kernel, attempting to
+// modprobe the ones that are not yet loaded. Modules that are not required only produce a warning when they are
+// missing, if any required module is unavailable a *KernelModuleError is returned listing all of them.
+func EnsureKernelModules(modules []KernelModule) error {
+	var moduleErr KernelModuleError
+	builtin := builtinKernelModules()
+
+	for _, module := range modules {
+		if isKernelModuleAvailable(module.Name, builtin) {
+			klog.V(2).Infof("kernel module %s is available", module.Name)
+			continue
+		}
+		out, err := modprobe(module.Name)
+		if err == nil {
+			klog.Infof("loaded kernel module %s (needed for %s)", module.Name, module.Reason)
+			continue
+		}
+		err = fmt.Errorf("modprobe failed: %v: %s", err, strings.TrimSpace(string(out)))
+		if !module.Required {
+			klog.Warningf("optional kernel module %s (needed for %s) could not be loaded, this feature will not "+
+				"work on this node: %v", module.Name, module.Reason, err)
+			continue
+		}
+		moduleErr.Missing = append(moduleErr.Missing, module)
+		moduleErr.errs = append(moduleErr.errs, err)
+	}
+
+	if len(moduleErr.Missing) > 0 {
+		return &moduleErr
+	}
+	return nil
+}
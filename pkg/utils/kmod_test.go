@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func Test_EnsureKernelModules(t *testing.T) {
+	testcases := []struct {
+		name           string
+		loaded         []string
+		loadable       []string
+		modules        []KernelModule
+		expectedErr    bool
+		expectedMissed []string
+		builtin        []string
+	}{
+		{
+			"all modules already loaded",
+			[]string{"ip_vs", "ip_set"},
+			nil,
+			[]KernelModule{{Name: "ip_vs", Required: true}, {Name: "ip_set", Required: true}},
+			false,
+			nil,
+			nil,
+		},
+		{
+			"missing module is loaded by modprobe",
+			[]string{"ip_vs"},
+			[]string{"ip_vs_rr"},
+			[]KernelModule{{Name: "ip_vs", Required: true}, {Name: "ip_vs_rr", Required: true}},
+			false,
+			nil,
+			nil,
+		},
+		{
+			"missing optional module does not cause an error",
+			[]string{"ip_vs"},
+			nil,
+			[]KernelModule{{Name: "ip_vs", Required: true}, {Name: "ip_vs_sh"}},
+			false,
+			nil,
+			nil,
+		},
+		{
+			"missing required modules are all reported",
+			nil,
+			nil,
+			[]KernelModule{{Name: "ip_vs", Required: true}, {Name: "ipip", Required: true}, {Name: "ip_vs_sh"}},
+			true,
+			[]string{"ip_vs", "ipip"},
+			nil,
+		},
+		{
+			"built-in module needs no modprobe",
+			[]string{"ip_set"},
+			nil,
+			[]KernelModule{{Name: "ip_set", Required: true}, {Name: "br_netfilter", Required: true}},
+			false,
+			nil,
+			[]string{"kernel/net/bridge/br_netfilter.ko"},
+		},
+	}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		t.Fatalf("failed to determine the kernel release: %v", err)
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+
+	origSysModulePath, origLibModulesPath, origModprobe := sysModulePath, libModulesPath, modprobe
+	defer func() {
+		sysModulePath, libModulesPath, modprobe = origSysModulePath, origLibModulesPath, origModprobe
+	}()
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			sysModulePath = t.TempDir()
+			// the modules built into the kernel of the host running the test mustn't be taken into account
+			libModulesPath = t.TempDir()
+			if err := os.Mkdir(path.Join(libModulesPath, release), 0755); err != nil {
+				t.Fatalf("failed to create fake module tree: %v", err)
+			}
+			if err := os.WriteFile(path.Join(libModulesPath, release, "modules.builtin"),
+				[]byte(strings.Join(testcase.builtin, "\n")), 0644); err != nil {
+				t.Fatalf("failed to create fake modules.builtin: %v", err)
+			}
+			for _, module := range testcase.loaded {
+				if err := os.Mkdir(path.Join(sysModulePath, module), 0755); err != nil {
+					t.Fatalf("failed to create fake module directory: %v", err)
+				}
+			}
+			modprobe = func(module string) ([]byte, error) {
+				for _, loadable := range testcase.loadable {
+					if loadable == module {
+						return nil, nil
+					}
+				}
+				return []byte("module not found"), errors.New("exit status 1")
+			}
+
+			err := EnsureKernelModules(testcase.modules)
+			if !testcase.expectedErr {
+				if err != nil {
+					t.Fatalf("expected no error, but got: %v", err)
+				}
+				return
+			}
+
+			var moduleErr *KernelModuleError
+			if !errors.As(err, &moduleErr) {
+				t.Fatalf("expected a KernelModuleError, but got: %v", err)
+			}
+			if len(moduleErr.Missing) != len(testcase.expectedMissed) {
+				t.Fatalf("expected %d missing modules, but got %d: %v", len(testcase.expectedMissed),
+					len(moduleErr.Missing), err)
+			}
+			for idx, module := range moduleErr.Missing {
+				if module.Name != testcase.expectedMissed[idx] {
+					t.Errorf("expected missing module %s, but got %s", testcase.expectedMissed[idx], module.Name)
+				}
+			}
+		})
+	}
+}
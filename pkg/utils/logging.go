@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/klog/v2"
+)
+
+// Keys of the fields of the structured log messages, used consistently across the controllers so that log
+// aggregation pipelines can filter on them
+const (
+	LogKeyController = "controller"
+	LogKeyNamespace  = "namespace"
+	LogKeyPeer       = "peer"
+	LogKeyPod        = "pod"
+	LogKeyPolicy     = "policy"
+	LogKeyService    = "service"
+)
+
+// NewJSONLogger returns a logger which writes one JSON object per message to w, with the timestamp, the caller, the
+// verbosity level and the message and its fields. V logs above verbosity are dropped.
+func NewJSONLogger(w io.Writer, verbosity int) klog.Logger {
+	var mu sync.Mutex
+	return funcr.NewJSON(func(obj string) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintln(w, obj)
+	}, funcr.Options{
+		LogCaller:          funcr.All,
+		LogTimestamp:       true,
+		TimestampFormat:    time.RFC3339Nano,
+		Verbosity:          verbosity,
+		RenderBuiltinsHook: trimBuiltinLogFields,
+	})
+}
+
+// trimBuiltinLogFields leaves out the name of the logger when it has none and strips the trailing newline the messages
+// logged with the printf style functions of klog end with
+func trimBuiltinLogFields(kvList []interface{}) []interface{} {
+	fields := make([]interface{}, 0, len(kvList))
+	for i := 0; i+1 < len(kvList); i += 2 {
+		key, value := kvList[i], kvList[i+1]
+		switch key {
+		case "logger":
+			if value == "" {
+				continue
+			}
+		case "msg":
+			if msg, ok := value.(string); ok {
+				value = strings.TrimSuffix(msg, "\n")
+			}
+		}
+		fields = append(fields, key, value)
+	}
+	return fields
+}
+
+// ControllerLogger returns the logger of the named controller, which adds the controller field to its messages. It must
+// be called after the logger of klog is set up.
+func ControllerLogger(controller string) klog.Logger {
+	return klog.LoggerWithValues(klog.Background(), LogKeyController, controller)
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	lines := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", line, err)
+		}
+		lines = append(lines, obj)
+	}
+	return lines
+}
+
+func Test_NewJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, 1).WithValues(LogKeyController, "NPC")
+
+	logger.Info("Synced network policy", LogKeyNamespace, "default", LogKeyPolicy, "deny-all")
+	logger.V(1).Info("Synced pod", LogKeyNamespace, "default", LogKeyPod, "web-0")
+	logger.V(2).Info("Dropped")
+	logger.Info("Logged by klog.Infof\n")
+	logger.Error(errors.New("boom"), "Failed to sync", LogKeyPeer, "192.0.2.1")
+
+	lines := decodeLogLines(t, &buf)
+	if !assert.Len(t, lines, 4) {
+		return
+	}
+	assert.Equal(t, "Synced network policy", lines[0]["msg"])
+	assert.Equal(t, "NPC", lines[0][LogKeyController])
+	assert.Equal(t, "default", lines[0][LogKeyNamespace])
+	assert.Equal(t, "deny-all", lines[0][LogKeyPolicy])
+	assert.Contains(t, lines[0], "ts")
+	assert.Contains(t, lines[0], "caller")
+	assert.NotContains(t, lines[0], "logger")
+	assert.Equal(t, float64(1), lines[1]["level"])
+	assert.Equal(t, "web-0", lines[1][LogKeyPod])
+	assert.Equal(t, "Logged by klog.Infof", lines[2]["msg"])
+	assert.Equal(t, "boom", lines[3]["error"])
+	assert.Equal(t, "192.0.2.1", lines[3][LogKeyPeer])
+}
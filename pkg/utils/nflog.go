@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// The NFLOG messages and attributes of the netfilter netlink ULOG subsystem, from linux/netfilter/nfnetlink_log.h
+const (
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind   = 1
+	nfulnlCfgCmdUnbind = 2
+
+	nfulnlCopyPacket = 2
+
+	nfulaTimestamp = 3
+	nfulaPayload   = 9
+	nfulaPrefix    = 10
+
+	// the attribute types carry the nested and network byte order flags in their two top bits
+	nlaTypeMask = 0x3fff
+
+	nflogReceiveBufferSize = 1 << 20
+	// the receive timeout bounds how long Receive blocks, so that the readers can check whether they were stopped
+	nflogReceiveTimeout = time.Second
+)
+
+// NFLogPacket is a packet logged by the NFLOG target of iptables
+type NFLogPacket struct {
+	// Prefix is the --nflog-prefix of the rule which logged the packet
+	Prefix string
+	// Payload is the start of the packet, from its network header on
+	Payload   []byte
+	Timestamp time.Time
+}
+
+// NFLog receives the packets logged to an NFLOG group
+type NFLog struct {
+	fd    int
+	group uint16
+	seq   uint32
+}
+
+// NewNFLog binds to the NFLOG group, copying up to copyRange bytes of the logged packets. Only a single socket can be
+// bound to a group, so this fails when another process, e.g. ulogd, already listens to it.
+func NewNFLog(group uint16, copyRange uint32) (*NFLog, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netfilter netlink socket: %v", err)
+	}
+	l := &NFLog{fd: fd, group: group}
+	if err = l.setup(copyRange); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *NFLog) setup(copyRange uint32) error {
+	if err := unix.Bind(l.fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to bind netfilter netlink socket: %v", err)
+	}
+	if err := unix.SetsockoptInt(l.fd, unix.SOL_SOCKET, unix.SO_RCVBUF, nflogReceiveBufferSize); err != nil {
+		return fmt.Errorf("failed to set the receive buffer size of the NFLOG socket: %v", err)
+	}
+	timeout := unix.NsecToTimeval(nflogReceiveTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(l.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		return fmt.Errorf("failed to set the receive timeout of the NFLOG socket: %v", err)
+	}
+	if err := l.request(buildNFLogCmd(nfulnlCfgCmdBind)); err != nil {
+		return fmt.Errorf("failed to bind to NFLOG group %d: %v", l.group, err)
+	}
+	if err := l.request(buildNFLogCopyMode(copyRange)); err != nil {
+		return fmt.Errorf("failed to set the copy mode of NFLOG group %d: %v", l.group, err)
+	}
+	return nil
+}
+
+// request sends a config message and waits for its acknowledgement
+func (l *NFLog) request(attr []byte) error {
+	l.seq++
+	if err := unix.Sendto(l.fd, buildNFLogConfigMessage(l.seq, l.group, attr), 0,
+		&unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+	buf := make([]byte, unix.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(l.fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		done, err := parseNetlinkAck(buf[:n], l.seq)
+		if done {
+			return err
+		}
+	}
+}
+
+// Receive returns the packets logged since the last call, it returns no packets when none were logged within a second
+func (l *NFLog) Receive() ([]NFLogPacket, error) {
+	buf := make([]byte, nflogReceiveBufferSize)
+	n, _, err := unix.Recvfrom(l.fd, buf, 0)
+	if err != nil {
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseNFLogMessages(buf[:n]), nil
+}
+
+// Close unbinds from the NFLOG group and closes the socket
+func (l *NFLog) Close() error {
+	_ = l.request(buildNFLogCmd(nfulnlCfgCmdUnbind))
+	return unix.Close(l.fd)
+}
+
+func buildNFLogCmd(cmd uint8) []byte {
+	return buildNetlinkAttr(nfulaCfgCmd, []byte{cmd})
+}
+
+func buildNFLogCopyMode(copyRange uint32) []byte {
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode[0:4], copyRange)
+	mode[4] = nfulnlCopyPacket
+	return buildNetlinkAttr(nfulaCfgMode, mode)
+}
+
+// buildNFLogConfigMessage builds the netlink message configuring the NFLOG group with the attribute
+func buildNFLogConfigMessage(seq uint32, group uint16, attr []byte) []byte {
+	length := unix.NLMSG_HDRLEN + 4 + len(attr)
+	msg := make([]byte, length)
+	native := nl.NativeEndian()
+	native.PutUint32(msg[0:4], uint32(length))
+	native.PutUint16(msg[4:6], unix.NFNL_SUBSYS_ULOG<<8|nfulnlMsgConfig)
+	native.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	native.PutUint32(msg[8:12], seq)
+	// nfgenmsg: the address family, which NFLOG groups don't depend on, the version and the group in network order
+	msg[unix.NLMSG_HDRLEN] = unix.AF_UNSPEC
+	msg[unix.NLMSG_HDRLEN+1] = unix.NFNETLINK_V0
+	binary.BigEndian.PutUint16(msg[unix.NLMSG_HDRLEN+2:unix.NLMSG_HDRLEN+4], group)
+	copy(msg[unix.NLMSG_HDRLEN+4:], attr)
+	return msg
+}
+
+func buildNetlinkAttr(attrType uint16, value []byte) []byte {
+	length := unix.NLA_HDRLEN + len(value)
+	attr := make([]byte, nlAlign(length))
+	native := nl.NativeEndian()
+	native.PutUint16(attr[0:2], uint16(length))
+	native.PutUint16(attr[2:4], attrType)
+	copy(attr[unix.NLA_HDRLEN:], value)
+	return attr
+}
+
+func nlAlign(length int) int {
+	return (length + unix.NLMSG_ALIGNTO - 1) & ^(unix.NLMSG_ALIGNTO - 1)
+}
+
+// parseNetlinkAck tells whether the messages hold the acknowledgement of the request, along with its error
+func parseNetlinkAck(data []byte, seq uint32) (bool, error) {
+	native := nl.NativeEndian()
+	for len(data) >= unix.NLMSG_HDRLEN {
+		length := int(native.Uint32(data[0:4]))
+		if length < unix.NLMSG_HDRLEN || length > len(data) {
+			return true, errors.New("truncated netlink message")
+		}
+		if native.Uint16(data[4:6]) == unix.NLMSG_ERROR && native.Uint32(data[8:12]) == seq {
+			if length < unix.NLMSG_HDRLEN+4 {
+				return true, errors.New("truncated netlink error message")
+			}
+			if code := int32(native.Uint32(data[unix.NLMSG_HDRLEN : unix.NLMSG_HDRLEN+4])); code != 0 {
+				return true, unix.Errno(-code)
+			}
+			return true, nil
+		}
+		if nlAlign(length) >= len(data) {
+			break
+		}
+		data = data[nlAlign(length):]
+	}
+	return false, nil
+}
+
+// parseNFLogMessages returns the packets of the NFLOG messages, skipping the messages of other types
+func parseNFLogMessages(data []byte) []NFLogPacket {
+	native := nl.NativeEndian()
+	packets := make([]NFLogPacket, 0)
+	for len(data) >= unix.NLMSG_HDRLEN {
+		length := int(native.Uint32(data[0:4]))
+		if length < unix.NLMSG_HDRLEN || length > len(data) {
+			break
+		}
+		if native.Uint16(data[4:6]) == unix.NFNL_SUBSYS_ULOG<<8|nfulnlMsgPacket && length >= unix.NLMSG_HDRLEN+4 {
+			packets = append(packets, parseNFLogPacket(data[unix.NLMSG_HDRLEN+4:length]))
+		}
+		if nlAlign(length) >= len(data) {
+			break
+		}
+		data = data[nlAlign(length):]
+	}
+	return packets
+}
+
+func parseNFLogPacket(attrs []byte) NFLogPacket {
+	native := nl.NativeEndian()
+	var packet NFLogPacket
+	for len(attrs) >= unix.NLA_HDRLEN {
+		length := int(native.Uint16(attrs[0:2]))
+		if length < unix.NLA_HDRLEN || length > len(attrs) {
+			break
+		}
+		value := attrs[unix.NLA_HDRLEN:length]
+		switch native.Uint16(attrs[2:4]) & nlaTypeMask {
+		case nfulaPrefix:
+			// the prefix is NUL terminated
+			for i, c := range value {
+				if c == 0 {
+					value = value[:i]
+					break
+				}
+			}
+			packet.Prefix = string(value)
+		case nfulaPayload:
+			packet.Payload = append([]byte(nil), value...)
+		case nfulaTimestamp:
+			if len(value) >= 16 {
+				packet.Timestamp = time.Unix(int64(binary.BigEndian.Uint64(value[0:8])),
+					int64(binary.BigEndian.Uint64(value[8:16]))*int64(time.Microsecond))
+			}
+		}
+		if nlAlign(length) >= len(attrs) {
+			break
+		}
+		attrs = attrs[nlAlign(length):]
+	}
+	return packet
+}
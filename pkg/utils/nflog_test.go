@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func Test_buildNFLogConfigMessage(t *testing.T) {
+	msg := buildNFLogConfigMessage(7, 100, buildNFLogCopyMode(128))
+
+	native := nl.NativeEndian()
+	if length := native.Uint32(msg[0:4]); int(length) != len(msg) || len(msg) != 32 {
+		t.Errorf("unexpected message length %d for %d bytes", length, len(msg))
+	}
+	if msgType := native.Uint16(msg[4:6]); msgType != 0x401 {
+		t.Errorf("expected an NFULNL_MSG_CONFIG message, got type %#x", msgType)
+	}
+	if seq := native.Uint32(msg[8:12]); seq != 7 {
+		t.Errorf("expected sequence number 7, got %d", seq)
+	}
+	expected := []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, 100}
+	if !bytes.Equal(msg[16:20], expected) {
+		t.Errorf("unexpected nfgenmsg:\nexpected: %x\ngot:      %x", expected, msg[16:20])
+	}
+	// NFULA_CFG_MODE attribute of 10 bytes padded to 12: copy range 128 in network order and NFULNL_COPY_PACKET
+	if attrType := native.Uint16(msg[22:24]); attrType != nfulaCfgMode || native.Uint16(msg[20:22]) != 10 {
+		t.Errorf("unexpected attribute header %x", msg[20:24])
+	}
+	expected = []byte{0, 0, 0, 128, nfulnlCopyPacket, 0, 0, 0}
+	if !bytes.Equal(msg[24:], expected) {
+		t.Errorf("unexpected copy mode:\nexpected: %x\ngot:      %x", expected, msg[24:])
+	}
+}
+
+func Test_parseNetlinkAck(t *testing.T) {
+	ack := func(seq uint32, code int32) []byte {
+		msg := make([]byte, unix.NLMSG_HDRLEN+4)
+		native := nl.NativeEndian()
+		native.PutUint32(msg[0:4], uint32(len(msg)))
+		native.PutUint16(msg[4:6], unix.NLMSG_ERROR)
+		native.PutUint32(msg[8:12], seq)
+		native.PutUint32(msg[16:20], uint32(code))
+		return msg
+	}
+
+	if done, err := parseNetlinkAck(ack(3, 0), 3); !done || err != nil {
+		t.Errorf("expected a successful acknowledgement, got %v, %v", done, err)
+	}
+	if done, err := parseNetlinkAck(ack(3, -int32(unix.EBUSY)), 3); !done || !errors.Is(err, unix.EBUSY) {
+		t.Errorf("expected EBUSY, got %v, %v", done, err)
+	}
+	if done, _ := parseNetlinkAck(ack(2, 0), 3); done {
+		t.Errorf("expected the acknowledgement of another request to be skipped")
+	}
+}
+
+func Test_parseNFLogMessages(t *testing.T) {
+	payload := []byte{0x45, 0, 0, 20}
+	timestamp := make([]byte, 16)
+	binary.BigEndian.PutUint64(timestamp[0:8], 1700000000)
+	binary.BigEndian.PutUint64(timestamp[8:16], 250)
+	attrs := append(buildNetlinkAttr(nfulaPrefix, []byte("DROP test-ns/deny\x00")), buildNetlinkAttr(nfulaPayload,
+		payload)...)
+	// the network byte order flag is set on the timestamp
+	attrs = append(attrs, buildNetlinkAttr(nfulaTimestamp|0x4000, timestamp)...)
+
+	packetMsg := make([]byte, unix.NLMSG_HDRLEN+4+len(attrs))
+	native := nl.NativeEndian()
+	native.PutUint32(packetMsg[0:4], uint32(len(packetMsg)))
+	native.PutUint16(packetMsg[4:6], unix.NFNL_SUBSYS_ULOG<<8|nfulnlMsgPacket)
+	copy(packetMsg[unix.NLMSG_HDRLEN+4:], attrs)
+	otherMsg := buildNFLogConfigMessage(1, 100, buildNFLogCmd(nfulnlCfgCmdBind))
+
+	packets := parseNFLogMessages(append(append(packetMsg, otherMsg...), packetMsg...))
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+	packet := packets[0]
+	if packet.Prefix != "DROP test-ns/deny" {
+		t.Errorf("unexpected prefix %q", packet.Prefix)
+	}
+	if !bytes.Equal(packet.Payload, payload) {
+		t.Errorf("unexpected payload %x", packet.Payload)
+	}
+	if expected := time.Unix(1700000000, 250000); !packet.Timestamp.Equal(expected) {
+		t.Errorf("expected timestamp %v, got %v", expected, packet.Timestamp)
+	}
+}
@@ -12,6 +12,16 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// NodeBGPAddressAnnotation holds the address other nodes peer with the node on, when it differs from the node IP
+	NodeBGPAddressAnnotation = "kube-router.io/bgp-address"
+	// NodeOverlayAddressAnnotation holds the address the overlay tunnels of the node are sourced from, when it differs
+	// from the node IP
+	NodeOverlayAddressAnnotation = "kube-router.io/overlay-address"
 )
 
 // GetNodeObject returns the node API object for the node
@@ -62,23 +72,121 @@ func GetNodeIP(node *apiv1.Node) (net.IP, error) {
 	return nil, errors.New("host IP unknown")
 }
 
+// GetNodeIPOfFamily returns the most valid external facing IP address for a node in the given address family, with the
+// same order of preference as GetNodeIP. Dual-stack nodes have an address of each family.
+func GetNodeIPOfFamily(node *apiv1.Node, ipv6 bool) (net.IP, error) {
+	for _, addressType := range []apiv1.NodeAddressType{apiv1.NodeInternalIP, apiv1.NodeExternalIP} {
+		for _, address := range node.Status.Addresses {
+			if address.Type != addressType {
+				continue
+			}
+			if ip := net.ParseIP(address.Address); ip != nil && (ip.To4() == nil) == ipv6 {
+				return ip, nil
+			}
+		}
+	}
+	return nil, errors.New("host IP of the address family unknown")
+}
+
+// NodeIPChanged returns the new IP of the node and true when the IP returned by GetNodeIP differs between the old and
+// the new version of the node object
+func NodeIPChanged(oldNode, newNode *apiv1.Node) (net.IP, bool) {
+	newIP, err := GetNodeIP(newNode)
+	if err != nil {
+		return nil, false
+	}
+	oldIP, err := GetNodeIP(oldNode)
+	if err != nil {
+		return newIP, true
+	}
+	return newIP, !oldIP.Equal(newIP)
+}
+
+// NewLocalNodeIPEventHandler returns a handler for node events which calls onChange with the new IP of the node with
+// the given name whenever its IP changes, e.g. after a DHCP renewal or when the node is re-IPed by the cloud provider
+func NewLocalNodeIPEventHandler(nodeName string, onChange func(nodeIP net.IP)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*apiv1.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*apiv1.Node)
+			if !ok || newNode.Name != nodeName {
+				return
+			}
+			if nodeIP, changed := NodeIPChanged(oldNode, newNode); changed {
+				klog.Infof("IP of node %s changed to %s", nodeName, nodeIP)
+				onChange(nodeIP)
+			}
+		},
+	}
+}
+
+// SelectNodeAddress returns the address of the node selected by the given annotation of the node, or by flagValue when
+// the node is not annotated. Both name either an IP or an interface of the node, in which case the first global
+// unicast address of the interface in the address family of the node IP is used. Without a selection the node IP is
+// returned.
+func SelectNodeAddress(node *apiv1.Node, annotation, flagValue string, nodeIP net.IP) (net.IP, error) {
+	selection := flagValue
+	if value, ok := node.Annotations[annotation]; ok {
+		selection = value
+	}
+	if selection == "" {
+		return nodeIP, nil
+	}
+	if ip := net.ParseIP(selection); ip != nil {
+		if (ip.To4() == nil) != (nodeIP.To4() == nil) {
+			return nil, fmt.Errorf("address %s is not of the address family of the node IP %s", ip, nodeIP)
+		}
+		return ip, nil
+	}
+
+	link, err := netlink.LinkByName(selection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %v", selection, err)
+	}
+	family := netlink.FAMILY_V4
+	if nodeIP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	addresses, err := netlink.AddrList(link, family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of interface %s: %v", selection, err)
+	}
+	for _, addr := range addresses {
+		if addr.IP.IsGlobalUnicast() {
+			return addr.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no global unicast address", selection)
+}
+
 // GetMTUFromNodeIP returns the MTU by detecting it from the IP on the node and figuring in tunneling configurations
 func GetMTUFromNodeIP(nodeIP net.IP) (int, error) {
+	link, err := GetNodeInterfaceFromNodeIP(nodeIP)
+	if err != nil {
+		return 0, err
+	}
+	return link.Attrs().MTU, nil
+}
+
+// GetNodeInterfaceFromNodeIP returns the link which has the node IP assigned to it
+func GetNodeInterfaceFromNodeIP(nodeIP net.IP) (netlink.Link, error) {
 	links, err := netlink.LinkList()
 	if err != nil {
-		return 0, errors.New("failed to get list of links")
+		return nil, errors.New("failed to get list of links")
 	}
 	for _, link := range links {
 		addresses, err := netlink.AddrList(link, netlink.FAMILY_ALL)
 		if err != nil {
-			return 0, errors.New("failed to get list of addr")
+			return nil, errors.New("failed to get list of addr")
 		}
 		for _, addr := range addresses {
 			if addr.IPNet.IP.Equal(nodeIP) {
-				linkMTU := link.Attrs().MTU
-				return linkMTU, nil
+				return link, nil
 			}
 		}
 	}
-	return 0, errors.New("failed to find interface with specified node IP")
+	return nil, errors.New("failed to find interface with specified node IP")
 }
@@ -190,3 +190,102 @@ func Test_GetNodeIP(t *testing.T) {
 		})
 	}
 }
+
+func Test_GetNodeIPOfFamily(t *testing.T) {
+	node := &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+		Status: apiv1.NodeStatus{
+			Addresses: []apiv1.NodeAddress{
+				{Type: apiv1.NodeExternalIP, Address: "2001:db8::1"},
+				{Type: apiv1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: apiv1.NodeInternalIP, Address: "fd00::1"},
+			},
+		},
+	}
+
+	if ip, err := GetNodeIPOfFamily(node, false); err != nil || !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("expected the internal IPv4 address, got %s: %v", ip, err)
+	}
+	if ip, err := GetNodeIPOfFamily(node, true); err != nil || !ip.Equal(net.ParseIP("fd00::1")) {
+		t.Errorf("expected the internal IPv6 address to be preferred over the external one, got %s: %v", ip, err)
+	}
+	node.Status.Addresses = node.Status.Addresses[1:2]
+	if _, err := GetNodeIPOfFamily(node, true); err == nil {
+		t.Error("expected an error for a node without an IPv6 address")
+	}
+}
+
+func Test_NodeIPChanged(t *testing.T) {
+	nodeWithIPs := func(ips ...string) *apiv1.Node {
+		node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+		for _, ip := range ips {
+			node.Status.Addresses = append(node.Status.Addresses,
+				apiv1.NodeAddress{Type: apiv1.NodeInternalIP, Address: ip})
+		}
+		return node
+	}
+	testcases := []struct {
+		name    string
+		oldNode *apiv1.Node
+		newNode *apiv1.Node
+		ip      net.IP
+		changed bool
+	}{
+		{"unchanged", nodeWithIPs("10.0.0.1"), nodeWithIPs("10.0.0.1"), net.ParseIP("10.0.0.1"), false},
+		{"secondary address added", nodeWithIPs("10.0.0.1"), nodeWithIPs("10.0.0.1", "10.0.0.2"),
+			net.ParseIP("10.0.0.1"), false},
+		{"re-IPed", nodeWithIPs("10.0.0.1"), nodeWithIPs("10.0.0.5"), net.ParseIP("10.0.0.5"), true},
+		{"address assigned", nodeWithIPs(), nodeWithIPs("10.0.0.1"), net.ParseIP("10.0.0.1"), true},
+		{"address removed", nodeWithIPs("10.0.0.1"), nodeWithIPs(), nil, false},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			ip, changed := NodeIPChanged(testcase.oldNode, testcase.newNode)
+			if changed != testcase.changed {
+				t.Errorf("expected changed to be %v", testcase.changed)
+			}
+			if !ip.Equal(testcase.ip) {
+				t.Errorf("expected IP %s, got %s", testcase.ip, ip)
+			}
+		})
+	}
+}
+
+func Test_SelectNodeAddress(t *testing.T) {
+	const annotation = "kube-router.io/bgp-interface"
+	nodeWithAnnotation := func(value string) *apiv1.Node {
+		node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+		if value != "" {
+			node.Annotations = map[string]string{annotation: value}
+		}
+		return node
+	}
+	nodeIP := net.ParseIP("10.0.0.1")
+	testcases := []struct {
+		name      string
+		node      *apiv1.Node
+		flagValue string
+		ip        net.IP
+		err       bool
+	}{
+		{"defaults to node IP", nodeWithAnnotation(""), "", nodeIP, false},
+		{"flag selects IP", nodeWithAnnotation(""), "192.168.1.1", net.ParseIP("192.168.1.1"), false},
+		{"annotation overrides flag", nodeWithAnnotation("192.168.2.1"), "192.168.1.1", net.ParseIP("192.168.2.1"),
+			false},
+		{"other address family", nodeWithAnnotation("2001:db8::1"), "", nil, true},
+		{"missing interface", nodeWithAnnotation(""), "kr-missing0", nil, true},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			ip, err := SelectNodeAddress(testcase.node, annotation, testcase.flagValue, nodeIP)
+			if testcase.err != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", testcase.err, err)
+			}
+			if !ip.Equal(testcase.ip) {
+				t.Errorf("expected IP %s, got %s", testcase.ip, ip)
+			}
+		})
+	}
+}
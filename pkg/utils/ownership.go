@@ -0,0 +1,57 @@
+package utils
+
+import "strings"
+
+// OwnerComment starts the comment of the iptables rules kube-router adds to chains it doesn't own, like the builtin
+// chains, so that they can be told apart from the rules of firewalld, other CNIs or the administrators of the node
+const OwnerComment = "kube-router"
+
+var (
+	// ownedChainPrefixes are the prefixes of the names of the iptables chains kube-router creates
+	ownedChainPrefixes = []string{"KUBE-ROUTER-", "KUBE-NWPLCY-", "KUBE-POD-FW-"}
+	// ownedIPSetPrefixes are the prefixes of the names of the ipsets kube-router creates
+	ownedIPSetPrefixes = []string{"kube-router-", "KUBE-SRC-", "KUBE-DST-", tmpIPSetPrefix}
+)
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOwnedChain checks whether the iptables chain was created by kube-router
+func IsOwnedChain(chain string) bool {
+	return hasAnyPrefix(chain, ownedChainPrefixes)
+}
+
+// IsOwnedIPSet checks whether the ipset, of either address family, was created by kube-router
+func IsOwnedIPSet(name string) bool {
+	return hasAnyPrefix(strings.TrimPrefix(name, "inet6:"), ownedIPSetPrefixes)
+}
+
+// IsOwnedRule checks whether the iptables rule, as listed by iptables -S or iptables-save, was added by kube-router:
+// either it is in or jumps to a chain kube-router owns, it matches on an ipset kube-router owns, or its comment starts
+// with OwnerComment
+func IsOwnedRule(rule string) bool {
+	fields := strings.Fields(rule)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "-A", "-I", "-N", "-j", "-g", "--append", "--insert", "--new-chain", "--jump", "--goto":
+			if IsOwnedChain(fields[i+1]) {
+				return true
+			}
+		case "--match-set":
+			if IsOwnedIPSet(fields[i+1]) {
+				return true
+			}
+		case "--comment":
+			if strings.HasPrefix(strings.TrimPrefix(fields[i+1], "\""), OwnerComment) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package utils
+
+import "testing"
+
+func Test_IsOwnedIPSet(t *testing.T) {
+	testcases := []struct {
+		name  string
+		owned bool
+	}{
+		{"kube-router-pod-subnets", true},
+		{"inet6:kube-router-pod-subnets", true},
+		{"KUBE-SRC-ABCDEFGHIJKLMNOP", true},
+		{"inet6:KUBE-DST-ABCDEFGHIJKLMNOP", true},
+		{"TMP-ABCDEFGHIJKLMNOP", true},
+		{"KUBE-CLUSTER-IP", false},
+		{"f2b-sshd", false},
+	}
+
+	for _, testcase := range testcases {
+		if owned := IsOwnedIPSet(testcase.name); owned != testcase.owned {
+			t.Errorf("expected ipset %s to be owned: %v, got %v", testcase.name, testcase.owned, owned)
+		}
+	}
+}
+
+func Test_IsOwnedRule(t *testing.T) {
+	testcases := []struct {
+		name  string
+		rule  string
+		owned bool
+	}{
+		{
+			"rule in a chain of kube-router",
+			"-A KUBE-ROUTER-SERVICES -m set --match-set kube-router-service-ips dst -j ACCEPT",
+			true,
+		},
+		{
+			"jump to a chain of kube-router",
+			"-A POSTROUTING -m ipvs --vdir ORIGINAL -j KUBE-ROUTER-HAIRPIN",
+			true,
+		},
+		{
+			"match on an ipset of kube-router",
+			`-A INPUT -m comment --comment "reject traffic to services without endpoints" ` +
+				"-m set --match-set kube-router-svc-no-endpoints dst,dst -j REJECT --reject-with icmp-port-unreachable",
+			true,
+		},
+		{
+			"comment of kube-router",
+			`-A POSTROUTING -m ipvs --ipvs --vdir ORIGINAL --vmethod MASQ -m comment ` +
+				`--comment "kube-router masquerade outbound IPVS traffic" -j SNAT --to-source 10.0.0.1`,
+			true,
+		},
+		{
+			"single word comment of kube-router",
+			"-A FORWARD -m comment --comment kube-router -j ACCEPT",
+			true,
+		},
+		{
+			"rule of an administrator",
+			`-A POSTROUTING -m ipvs --ipvs --vdir ORIGINAL --vmethod MASQ -m comment --comment "keepalived" ` +
+				"-j SNAT --to-source 10.0.0.1",
+			false,
+		},
+		{
+			"rule of another CNI",
+			"-A FORWARD -m set --match-set cali40all-hosts-net src -j cali-FORWARD",
+			false,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if owned := IsOwnedRule(testcase.rule); owned != testcase.owned {
+				t.Errorf("expected rule %q to be owned: %v, got %v", testcase.rule, testcase.owned, owned)
+			}
+		})
+	}
+}
@@ -9,16 +9,30 @@ import (
 
 	"github.com/containernetworking/cni/libcni"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	podCIDRAnnotation = "kube-router.io/pod-cidr"
+	// PodCIDRsAnnotation holds a comma separated list of pod CIDRs of the node, it takes precedence over the single
+	// kube-router.io/pod-cidr annotation and is set by kube-router when allocating pod CIDRs from IPPools
+	PodCIDRsAnnotation = "kube-router.io/pod-cidrs"
 )
 
-// GetPodCidrFromCniSpec gets pod CIDR allocated to the node from CNI spec file and returns it
+// GetPodCidrFromCniSpec gets pod CIDR allocated to the node from CNI spec file and returns it, when the CNI spec
+// contains multiple subnets the first one is returned
 func GetPodCidrFromCniSpec(cniConfFilePath string) (net.IPNet, error) {
-	var podCidr = net.IPNet{}
+	cidrs, err := GetPodCIDRsFromCniSpec(cniConfFilePath)
+	if err != nil || len(cidrs) == 0 {
+		return net.IPNet{}, err
+	}
+	return cidrs[0], nil
+}
+
+// GetPodCIDRsFromCniSpec gets all of the pod CIDRs allocated to the node from CNI spec file and returns them
+func GetPodCIDRsFromCniSpec(cniConfFilePath string) ([]net.IPNet, error) {
+	var podCidrs = make([]net.IPNet, 0)
 	var err error
 	var ipamConfig *allocator.IPAMConfig
 
@@ -26,14 +40,14 @@ func GetPodCidrFromCniSpec(cniConfFilePath string) (net.IPNet, error) {
 		var confList *libcni.NetworkConfigList
 		confList, err = libcni.ConfListFromFile(cniConfFilePath)
 		if err != nil {
-			return net.IPNet{}, fmt.Errorf("failed to load CNI config list file: %s", err.Error())
+			return nil, fmt.Errorf("failed to load CNI config list file: %s", err.Error())
 		}
 		for _, conf := range confList.Plugins {
 			if conf.Network.IPAM.Type != "" {
 				ipamConfig, _, err = allocator.LoadIPAMConfig(conf.Bytes, "")
 				if err != nil {
 					if err.Error() != "no IP ranges specified" {
-						return net.IPNet{}, fmt.Errorf("failed to get IPAM details from the CNI conf file: %s", err.Error())
+						return nil, fmt.Errorf("failed to get IPAM details from the CNI conf file: %s", err.Error())
 					}
 				}
 				break
@@ -42,34 +56,41 @@ func GetPodCidrFromCniSpec(cniConfFilePath string) (net.IPNet, error) {
 	} else {
 		netconfig, err := libcni.ConfFromFile(cniConfFilePath)
 		if err != nil {
-			return net.IPNet{}, fmt.Errorf("failed to load CNI conf file: %s", err.Error())
+			return nil, fmt.Errorf("failed to load CNI conf file: %s", err.Error())
 		}
 		ipamConfig, _, err = allocator.LoadIPAMConfig(netconfig.Bytes, "")
 		if err != nil {
 			// TODO: Handle this error properly in controllers, if no subnet is specified
 			if err.Error() != "no IP ranges specified" {
-				return net.IPNet{}, fmt.Errorf("failed to get IPAM details from the CNI conf file: %s", err.Error())
+				return nil, fmt.Errorf("failed to get IPAM details from the CNI conf file: %s", err.Error())
 			}
-			return net.IPNet{}, nil
+			return podCidrs, nil
 		}
 	}
-	// TODO: Support multiple subnet definitions in CNI conf
 	if ipamConfig != nil && len(ipamConfig.Ranges) > 0 {
 		for _, rangeset := range ipamConfig.Ranges {
 			for _, item := range rangeset {
 				if item.Subnet.IP != nil {
-					podCidr = net.IPNet(item.Subnet)
-					break
+					podCidrs = append(podCidrs, net.IPNet(item.Subnet))
 				}
 			}
 		}
 	}
-	return podCidr, nil
+	return podCidrs, nil
 }
 
 // InsertPodCidrInCniSpec inserts the pod CIDR allocated to the node by kubernetes controller manager
 // and stored it in the CNI specification
 func InsertPodCidrInCniSpec(cniConfFilePath string, cidr string) error {
+	return updateIPAMInCniSpec(cniConfFilePath, func(ipam map[string]interface{}) {
+		delete(ipam, "ranges")
+		ipam["subnet"] = cidr
+	})
+}
+
+// updateIPAMInCniSpec applies the update function to the IPAM configuration found in the CNI specification and writes
+// the result back to the file
+func updateIPAMInCniSpec(cniConfFilePath string, update func(ipam map[string]interface{})) error {
 	file, err := os.ReadFile(cniConfFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to load CNI conf file: %s", err.Error())
@@ -92,8 +113,7 @@ func InsertPodCidrInCniSpec(cniConfFilePath string, cidr string) error {
 			for _, pluginConfig := range pluginConfigs {
 				pluginConfigMap := pluginConfig.(map[string]interface{})
 				if val, ok := pluginConfigMap["ipam"]; ok {
-					valObj := val.(map[string]interface{})
-					valObj["subnet"] = cidr
+					update(val.(map[string]interface{}))
 					updatedCidr = true
 					break
 				}
@@ -110,7 +130,7 @@ func InsertPodCidrInCniSpec(cniConfFilePath string, cidr string) error {
 			return fmt.Errorf("failed to parse JSON from CNI conf file: %s", err.Error())
 		}
 		pluginConfig := config.(map[string]interface{})
-		pluginConfig["ipam"].(map[string]interface{})["subnet"] = cidr
+		update(pluginConfig["ipam"].(map[string]interface{}))
 	}
 	configJSON, _ := json.Marshal(config)
 	err = os.WriteFile(cniConfFilePath, configJSON, 0644)
@@ -120,25 +140,61 @@ func InsertPodCidrInCniSpec(cniConfFilePath string, cidr string) error {
 	return nil
 }
 
-// GetPodCidrFromNodeSpec reads the pod CIDR allocated to the node from API node object and returns it
+// GetPodCidrFromNodeSpec reads the pod CIDR allocated to the node from API node object and returns it, when the node
+// has multiple pod CIDRs the first one is returned
 func GetPodCidrFromNodeSpec(clientset kubernetes.Interface, hostnameOverride string) (string, error) {
+	cidrs, err := GetPodCIDRsFromNodeSpec(clientset, hostnameOverride)
+	if err != nil {
+		return "", err
+	}
+	return cidrs[0], nil
+}
+
+// GetPodCIDRsFromNodeSpec reads all of the pod CIDRs allocated to the node from API node object and returns them
+func GetPodCIDRsFromNodeSpec(clientset kubernetes.Interface, hostnameOverride string) ([]string, error) {
 	node, err := GetNodeObject(clientset, hostnameOverride)
 	if err != nil {
-		return "", fmt.Errorf("Failed to get pod CIDR allocated for the node due to: " + err.Error())
+		return nil, fmt.Errorf("Failed to get pod CIDR allocated for the node due to: " + err.Error())
+	}
+	return GetPodCIDRsFromNode(node)
+}
+
+// GetPodCIDRsFromNode returns the pod CIDRs of the node, which are taken from (in order of preference) the
+// kube-router.io/pod-cidrs annotation, the kube-router.io/pod-cidr annotation, node.Spec.PodCIDRs and node.Spec.PodCIDR
+func GetPodCIDRsFromNode(node *apiv1.Node) ([]string, error) {
+	if cidrs, ok := node.Annotations[PodCIDRsAnnotation]; ok {
+		podCIDRs := make([]string, 0)
+		for _, cidr := range strings.Split(cidrs, ",") {
+			cidr = strings.TrimSpace(cidr)
+			if cidr == "" {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("error parsing pod CIDRs in node annotation: %v", err)
+			}
+			podCIDRs = append(podCIDRs, cidr)
+		}
+		if len(podCIDRs) > 0 {
+			return podCIDRs, nil
+		}
 	}
 
 	if cidr, ok := node.Annotations[podCIDRAnnotation]; ok {
-		_, _, err = net.ParseCIDR(cidr)
+		_, _, err := net.ParseCIDR(cidr)
 		if err != nil {
-			return "", fmt.Errorf("error parsing pod CIDR in node annotation: %v", err)
+			return nil, fmt.Errorf("error parsing pod CIDR in node annotation: %v", err)
 		}
 
-		return cidr, nil
+		return []string{cidr}, nil
+	}
+
+	if len(node.Spec.PodCIDRs) > 0 {
+		return node.Spec.PodCIDRs, nil
 	}
 
 	if node.Spec.PodCIDR == "" {
-		return "", fmt.Errorf("node.Spec.PodCIDR not set for node: %v", node.Name)
+		return nil, fmt.Errorf("node.Spec.PodCIDR not set for node: %v", node.Name)
 	}
 
-	return node.Spec.PodCIDR, nil
+	return []string{node.Spec.PodCIDR}, nil
 }
@@ -122,6 +122,72 @@ func Test_InsertPodCidrInCniSpec(t *testing.T) {
 	}
 }
 
+func Test_GetPodCIDRsFromNode(t *testing.T) {
+	testcases := []struct {
+		name     string
+		node     *apiv1.Node
+		podCIDRs []string
+		err      bool
+	}{
+		{
+			"pod-cidrs annotation takes precedence",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-node",
+					Annotations: map[string]string{
+						PodCIDRsAnnotation: "172.17.0.0/24, 10.10.0.0/26",
+						podCIDRAnnotation:  "172.18.0.0/24",
+					},
+				},
+				Spec: apiv1.NodeSpec{PodCIDR: "172.19.0.0/24"},
+			},
+			[]string{"172.17.0.0/24", "10.10.0.0/26"},
+			false,
+		},
+		{
+			"node.Spec.PodCIDRs",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-node"},
+				Spec: apiv1.NodeSpec{
+					PodCIDR:  "172.17.0.0/24",
+					PodCIDRs: []string{"172.17.0.0/24", "2001:db8::/64"},
+				},
+			},
+			[]string{"172.17.0.0/24", "2001:db8::/64"},
+			false,
+		},
+		{
+			"invalid pod-cidrs annotation",
+			&apiv1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test-node",
+					Annotations: map[string]string{PodCIDRsAnnotation: "172.17.0.0/24,10.10.0.0"},
+				},
+			},
+			nil,
+			true,
+		},
+		{
+			"no pod cidr",
+			&apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}},
+			nil,
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			podCIDRs, err := GetPodCIDRsFromNode(testcase.node)
+			if testcase.err != (err != nil) {
+				t.Fatalf("expected error: %v, got: %v", testcase.err, err)
+			}
+			if !reflect.DeepEqual(podCIDRs, testcase.podCIDRs) {
+				t.Errorf("expected pod CIDRs %v, got %v", testcase.podCIDRs, podCIDRs)
+			}
+		})
+	}
+}
+
 func Test_GetPodCidrFromNodeSpec(t *testing.T) {
 	testcases := []struct {
 		name             string
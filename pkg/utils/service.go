@@ -1,14 +1,20 @@
 package utils
 
 import (
+	"fmt"
 	"strings"
 
 	v1core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/cache"
 )
 
 const (
 	IPInIPHeaderLength = 20
+
+	// SvcVIPNodeSelectorAnnotation is a label selector restricting the nodes which advertise and serve the external
+	// and LoadBalancer IPs of the service to the ones it matches
+	SvcVIPNodeSelectorAnnotation = "kube-router.io/service.vip.nodeselector"
 )
 
 // ServiceForEndpoints given Endpoint object return Service API object if it exists
@@ -62,3 +68,20 @@ func containsOnlyNone(clusterIPs []string) bool {
 	}
 	return true
 }
+
+// ServiceVIPsServedByNode checks whether the node with the given labels advertises and serves the external and
+// LoadBalancer IPs of the service, which every node does unless the service restricts them to some nodes with
+// SvcVIPNodeSelectorAnnotation. A selector that doesn't parse selects no node, so that a mistake in it doesn't spread
+// the VIPs to all the nodes of the cluster.
+func ServiceVIPsServedByNode(svc *v1core.Service, nodeLabels map[string]string) (bool, error) {
+	value, ok := svc.Annotations[SvcVIPNodeSelectorAnnotation]
+	if !ok {
+		return true, nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse the %s annotation of the service %s/%s: %v",
+			SvcVIPNodeSelectorAnnotation, svc.Namespace, svc.Name, err)
+	}
+	return selector.Matches(labels.Set(nodeLabels)), nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+
+	v1core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ServiceVIPsServedByNode(t *testing.T) {
+	ingressNodeLabels := map[string]string{
+		"kubernetes.io/hostname":          "ingress-1",
+		"node-role.kubernetes.io/ingress": "",
+	}
+
+	testcases := []struct {
+		name        string
+		annotations map[string]string
+		nodeLabels  map[string]string
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			"service without the annotation is served by every node",
+			nil,
+			map[string]string{"kubernetes.io/hostname": "worker-1"},
+			true,
+			false,
+		},
+		{
+			"node matching the selector serves the service",
+			map[string]string{SvcVIPNodeSelectorAnnotation: "node-role.kubernetes.io/ingress"},
+			ingressNodeLabels,
+			true,
+			false,
+		},
+		{
+			"node not matching the selector doesn't serve the service",
+			map[string]string{SvcVIPNodeSelectorAnnotation: "node-role.kubernetes.io/ingress"},
+			map[string]string{"kubernetes.io/hostname": "worker-1"},
+			false,
+			false,
+		},
+		{
+			"node in an explicit list of nodes serves the service",
+			map[string]string{SvcVIPNodeSelectorAnnotation: "kubernetes.io/hostname in (ingress-1, ingress-2)"},
+			ingressNodeLabels,
+			true,
+			false,
+		},
+		{
+			"invalid selector selects no node",
+			map[string]string{SvcVIPNodeSelectorAnnotation: "kubernetes.io/hostname in (ingress-1"},
+			ingressNodeLabels,
+			false,
+			true,
+		},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			svc := &v1core.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc-1", Namespace: "default",
+				Annotations: testcase.annotations}}
+			served, err := ServiceVIPsServedByNode(svc, testcase.nodeLabels)
+			if (err != nil) != testcase.expectErr {
+				t.Errorf("expected error: %v, got: %v", testcase.expectErr, err)
+			}
+			if served != testcase.expected {
+				t.Errorf("expected served: %v, got: %v", testcase.expected, served)
+			}
+		})
+	}
+}
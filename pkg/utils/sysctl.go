@@ -12,17 +12,27 @@ const (
 	IPv4IPVSExpireNodestConn = "net/ipv4/vs/expire_nodest_conn"
 	IPv4IPVSExpireQuiescent  = "net/ipv4/vs/expire_quiescent_template"
 	IPv4IPVSConnReuseMode    = "net/ipv4/vs/conn_reuse_mode"
+	IPv4IPVSPMTUDisc         = "net/ipv4/vs/pmtu_disc"
+	IPv4IPVSRunEstimation    = "net/ipv4/vs/run_estimation"
 	IPv4ConfAllArpIgnore     = "net/ipv4/conf/all/arp_ignore"
 	IPv4ConfAllArpAnnounce   = "net/ipv4/conf/all/arp_announce"
+	NetfilterConntrackAcct   = "net/netfilter/nf_conntrack_acct"
+	NetfilterConntrackTstamp = "net/netfilter/nf_conntrack_timestamp"
 
 	// Network Routes Configuration Paths
 	BridgeNFCallIPTables  = "net/bridge/bridge-nf-call-iptables"
 	BridgeNFCallIP6Tables = "net/bridge/bridge-nf-call-ip6tables"
+	IPv4IPForward         = "net/ipv4/ip_forward"
+	IPv6ConfAllForwarding = "net/ipv6/conf/all/forwarding"
 
 	// Template Configuration Paths
 	IPv4ConfRPFilterTemplate = "net/ipv4/conf/%s/rp_filter"
+	IPv6ConfProxyNDPTemplate = "net/ipv6/conf/%s/proxy_ndp"
 )
 
+// procSysPath is where the kernel exposes the sysctls, a variable so that the tests can point it elsewhere
+var procSysPath = "/proc/sys"
+
 type SysctlError struct {
 	additionalInfo string
 	err            error
@@ -53,9 +63,15 @@ func SetSysctlSingleTemplate(pathTemplate string, substitute string, value int)
 	return SetSysctl(actualPath, value)
 }
 
+// SysctlExists returns whether the kernel has the sysctl, e.g. because the module providing it is loaded
+func SysctlExists(path string) bool {
+	_, err := os.Stat(fmt.Sprintf("%s/%s", procSysPath, path))
+	return err == nil
+}
+
 // SetSysctl sets a sysctl value
 func SetSysctl(path string, value int) *SysctlError {
-	sysctlPath := fmt.Sprintf("/proc/sys/%s", path)
+	sysctlPath := fmt.Sprintf("%s/%s", procSysPath, path)
 	if _, err := os.Stat(sysctlPath); err != nil {
 		if os.IsNotExist(err) {
 			return &SysctlError{
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// SysctlSetting describes a sysctl that kube-router wants to own on the host
+type SysctlSetting struct {
+	// Path of the option relative to /proc/sys (e.g. net/ipv4/vs/conntrack)
+	Path string
+	// Value the option should be set to, unless it has been overridden by the user
+	Value int
+	// Reason is a human readable explanation of why kube-router needs this option
+	Reason string
+}
+
+// SysctlManager keeps track of all of the sysctls that kube-router manages so that they can be set in one place,
+// overridden from the command line, and periodically checked for drift caused by other processes on the host.
+type SysctlManager struct {
+	mu        sync.Mutex
+	desired   map[string]SysctlSetting
+	overrides map[string]int
+	// applied holds the sysctls that have been set to their desired value once, only those can drift
+	applied map[string]bool
+	// DriftHandler, if set, is called whenever a managed sysctl is found to no longer contain its desired value
+	DriftHandler func(path string, expected, actual int)
+}
+
+// normalizeSysctlPath converts sysctl names given in either dotted (net.ipv4.ip_forward) or path
+// (net/ipv4/ip_forward) notation to path notation relative to /proc/sys
+func normalizeSysctlPath(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "/proc/sys/")
+	if strings.Contains(name, "/") {
+		return name
+	}
+	return strings.ReplaceAll(name, ".", "/")
+}
+
+// NewSysctlManager returns a SysctlManager, the overrides map keys may be given in either dotted or path notation.
+// Any override that does not correspond to a sysctl registered by kube-router is managed as an additional sysctl so
+// that users are able to tune things like conntrack limits alongside the options kube-router owns.
+func NewSysctlManager(overrides map[string]int) *SysctlManager {
+	sm := &SysctlManager{
+		desired:   make(map[string]SysctlSetting),
+		overrides: make(map[string]int),
+		applied:   make(map[string]bool),
+	}
+	for name, value := range overrides {
+		path := normalizeSysctlPath(name)
+		sm.overrides[path] = value
+		sm.desired[path] = SysctlSetting{Path: path, Value: value, Reason: "user specified sysctl"}
+	}
+	return sm
+}
+
+// GetSysctl reads the current integer value of a sysctl
+func GetSysctl(path string) (int, *SysctlError) {
+	sysctlPath := fmt.Sprintf("%s/%s", procSysPath, path)
+	buf, err := os.ReadFile(sysctlPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, &SysctlError{
+				"option not found, Does your kernel version support this feature?",
+				err, path, 0, false}
+		}
+		return 0, &SysctlError{"path could not be read", err, path, 0, true}
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0, &SysctlError{"value could not be parsed as an integer", err, path, 0, true}
+	}
+	return value, nil
+}
+
+// Ensure registers the setting with the manager and immediately applies it (using the user provided override value if
+// there is one). If the manager is nil the setting is simply applied once, which keeps callers that have not been
+// handed a manager (like the cleanup path) working.
+func (sm *SysctlManager) Ensure(setting SysctlSetting) *SysctlError {
+	if sm == nil {
+		return SetSysctl(setting.Path, setting.Value)
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if value, ok := sm.overrides[setting.Path]; ok {
+		if value != setting.Value {
+			klog.Infof("sysctl %s is overridden to %d, kube-router would set %d for %s", setting.Path, value,
+				setting.Value, setting.Reason)
+		}
+		setting.Value = value
+	}
+	sm.desired[setting.Path] = setting
+	return sm.apply(setting.Path)
+}
+
+// Apply sets the managed sysctls that haven't been applied yet, like the user specified ones at startup, to their
+// desired value. Those aren't counted as drift since kube-router never set them before.
+func (sm *SysctlManager) Apply() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for _, path := range sm.sortedPaths() {
+		if sm.applied[path] {
+			continue
+		}
+		if sysctlErr := sm.apply(path); sysctlErr != nil {
+			klog.Errorf("failed to apply managed sysctl: %s", sysctlErr.Error())
+		}
+	}
+}
+
+func (sm *SysctlManager) apply(path string) *SysctlError {
+	sysctlErr := SetSysctl(path, sm.desired[path].Value)
+	sm.applied[path] = sysctlErr == nil
+	return sysctlErr
+}
+
+func (sm *SysctlManager) sortedPaths() []string {
+	paths := make([]string, 0, len(sm.desired))
+	for path := range sm.desired {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Reconcile checks each managed sysctl against its desired value and corrects any that have drifted, the sysctls that
+// couldn't be applied before (e.g. because the module providing them wasn't loaded yet) are applied without being
+// counted as drift
+func (sm *SysctlManager) Reconcile() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for _, path := range sm.sortedPaths() {
+		if !sm.applied[path] {
+			if sysctlErr := sm.apply(path); sysctlErr != nil {
+				klog.V(1).Infof("unable to apply managed sysctl: %s", sysctlErr.Error())
+			}
+			continue
+		}
+		setting := sm.desired[path]
+		actual, sysctlErr := GetSysctl(path)
+		if sysctlErr != nil {
+			klog.V(1).Infof("unable to check managed sysctl: %s", sysctlErr.Error())
+			continue
+		}
+		if actual == setting.Value {
+			continue
+		}
+		klog.Warningf("sysctl %s drifted from %d to %d (managed for %s), resetting it", path, setting.Value,
+			actual, setting.Reason)
+		if sm.DriftHandler != nil {
+			sm.DriftHandler(path, setting.Value, actual)
+		}
+		if sysctlErr = SetSysctl(path, setting.Value); sysctlErr != nil {
+			klog.Errorf("failed to reset drifted sysctl: %s", sysctlErr.Error())
+		}
+	}
+}
+
+// Run periodically reconciles all managed sysctls till we receive notification on stopCh, Apply is expected to have
+// been called at startup
+func (sm *SysctlManager) Run(syncPeriod time.Duration, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	t := time.NewTicker(syncPeriod)
+	defer t.Stop()
+	defer wg.Done()
+
+	klog.Info("Starting sysctl manager")
+	for {
+		sm.Reconcile()
+		select {
+		case <-stopCh:
+			klog.Info("Shutting down sysctl manager")
+			return
+		case <-t.C:
+		}
+	}
+}
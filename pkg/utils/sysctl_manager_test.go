@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_normalizeSysctlPath(t *testing.T) {
+	testcases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"dotted notation", "net.ipv4.ip_forward", "net/ipv4/ip_forward"},
+		{"path notation", "net/ipv4/conf/eth0.100/rp_filter", "net/ipv4/conf/eth0.100/rp_filter"},
+		{"absolute path", "/proc/sys/net/netfilter/nf_conntrack_max", "net/netfilter/nf_conntrack_max"},
+	}
+
+	for _, testcase := range testcases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if actual := normalizeSysctlPath(testcase.input); actual != testcase.expected {
+				t.Errorf("expected %s, but got %s", testcase.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_NewSysctlManager(t *testing.T) {
+	sm := NewSysctlManager(map[string]int{
+		"net.netfilter.nf_conntrack_max": 262144,
+		IPv4IPVSConntrack:                0,
+	})
+
+	if value, ok := sm.overrides[IPv4IPVSConntrack]; !ok || value != 0 {
+		t.Errorf("expected override for %s to be 0, got %d (present: %t)", IPv4IPVSConntrack, value, ok)
+	}
+	setting, ok := sm.desired["net/netfilter/nf_conntrack_max"]
+	if !ok {
+		t.Fatalf("expected user specified sysctl to be managed")
+	}
+	if setting.Value != 262144 {
+		t.Errorf("expected user specified sysctl value to be 262144, got %d", setting.Value)
+	}
+}
+
+func Test_SysctlManagerReconcile(t *testing.T) {
+	procSysPath = t.TempDir()
+	defer func() { procSysPath = "/proc/sys" }()
+
+	const userSysctl = "net/netfilter/nf_conntrack_max"
+	for _, path := range []string{userSysctl, IPv4IPForward} {
+		if err := os.MkdirAll(filepath.Join(procSysPath, filepath.Dir(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(procSysPath, path), []byte("0\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drifted := make([]string, 0)
+	sm := NewSysctlManager(map[string]int{userSysctl: 262144})
+	sm.DriftHandler = func(path string, _, _ int) {
+		drifted = append(drifted, path)
+	}
+
+	sm.Apply()
+	if sysctlErr := sm.Ensure(SysctlSetting{Path: IPv4IPForward, Value: 1, Reason: "test"}); sysctlErr != nil {
+		t.Fatalf("expected the sysctl to be set, got %s", sysctlErr.Error())
+	}
+	sm.Reconcile()
+	if len(drifted) != 0 {
+		t.Errorf("expected the first application of the sysctls not to be drift, got drift of %v", drifted)
+	}
+	if value, _ := GetSysctl(userSysctl); value != 262144 {
+		t.Errorf("expected user specified sysctl to be applied, got %d", value)
+	}
+
+	if sysctlErr := SetSysctl(IPv4IPForward, 0); sysctlErr != nil {
+		t.Fatal(sysctlErr.Error())
+	}
+	sm.Reconcile()
+	if len(drifted) != 1 || drifted[0] != IPv4IPForward {
+		t.Errorf("expected %s to have drifted, got drift of %v", IPv4IPForward, drifted)
+	}
+	if value, _ := GetSysctl(IPv4IPForward); value != 1 {
+		t.Errorf("expected drifted sysctl to be reset, got %d", value)
+	}
+}